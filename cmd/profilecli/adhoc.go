@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+
+	adhocprofilesv1 "github.com/grafana/pyroscope/api/gen/proto/go/adhocprofiles/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/adhocprofiles/v1/adhocprofilesv1connect"
+	"github.com/grafana/pyroscope/pkg/adhocprofiles"
+	connectapi "github.com/grafana/pyroscope/pkg/api/connect"
+)
+
+func (c *phlareClient) adHocProfileClient() adhocprofilesv1connect.AdHocProfileServiceClient {
+	return adhocprofilesv1connect.NewAdHocProfileServiceClient(
+		c.httpClient(),
+		c.URL,
+		append(
+			connectapi.DefaultClientOptions(),
+			c.protocolOption(),
+		)...,
+	)
+}
+
+type adhocRunParams struct {
+	*phlareClient
+	name      string
+	user      string
+	frequency int
+	perfPath  string
+	output    string
+	command   []string
+}
+
+func addAdhocRunParams(cmd commander) *adhocRunParams {
+	params := &adhocRunParams{}
+	params.phlareClient = addPhlareClient(cmd)
+
+	cmd.Flag("name", "Name of the uploaded profile. By default, the name of the command is used.").StringVar(&params.name)
+	cmd.Flag("user", "The user the profile is uploaded by.").Default(os.Getenv("USER")).StringVar(&params.user)
+	cmd.Flag("frequency", "The sampling frequency of the CPU profile, in Hz.").Default("99").IntVar(&params.frequency)
+	cmd.Flag("perf-path", "Path to the perf binary used to capture the profile.").Default("perf").StringVar(&params.perfPath)
+	cmd.Flag("output", "Also write the captured profile, in the perf script format, to the file.").StringVar(&params.output)
+	cmd.Arg("command", "The command to run and its arguments, after --.").Required().StringsVar(&params.command)
+	return params
+}
+
+// adhocRun runs the command under perf record, and uploads the CPU profile
+// as an ad-hoc profile once the command exits. The profile is uploaded even
+// if the command fails.
+func adhocRun(ctx context.Context, params *adhocRunParams) error {
+	if params.frequency <= 0 {
+		return errors.New("--frequency must be positive")
+	}
+	dir, err := os.MkdirTemp("", "profilecli-adhoc-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	perfData := filepath.Join(dir, "perf.data")
+
+	runErr := params.record(ctx, perfData)
+	var exitErr *exec.ExitError
+	if runErr != nil && !errors.As(runErr, &exitErr) {
+		return errors.Wrap(runErr, "run perf record")
+	}
+
+	script, err := params.script(ctx, perfData)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(script)) == 0 {
+		return errors.New("no samples captured")
+	}
+	if params.output != "" {
+		if err = os.WriteFile(params.output, script, 0o644); err != nil {
+			return err
+		}
+	}
+
+	name := params.name
+	if name == "" {
+		name = filepath.Base(params.command[0]) + ".perf"
+	}
+	req := connect.NewRequest(&adhocprofilesv1.AdHocProfilesUploadRequest{
+		Name:    name,
+		Profile: base64.StdEncoding.EncodeToString(script),
+	})
+	if params.user != "" {
+		req.Header().Set(adhocprofiles.UserHeader, params.user)
+	}
+	resp, err := params.adHocProfileClient().Upload(ctx, req)
+	if err != nil {
+		return errors.Wrap(err, "upload profile")
+	}
+	level.Info(logger).Log("msg", "ad-hoc profile uploaded", "id", resp.Msg.Id, "name", resp.Msg.Name)
+	_, _ = fmt.Fprintln(output(ctx), resp.Msg.Id)
+
+	if runErr != nil {
+		return fmt.Errorf("command failed: %w", runErr)
+	}
+	return nil
+}
+
+func (p *adhocRunParams) record(ctx context.Context, perfData string) error {
+	args := append([]string{
+		"record",
+		"-F", strconv.Itoa(p.frequency),
+		"-g",
+		"-o", perfData,
+		"--",
+	}, p.command...)
+	cmd := exec.CommandContext(ctx, p.perfPath, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// The interrupt signal is delivered to the command, which is in the same
+	// process group: the profile is still uploaded if the command is stopped.
+	signal.Ignore(os.Interrupt)
+	defer signal.Reset(os.Interrupt)
+
+	level.Debug(logger).Log("msg", "running command", "cmd", cmd.String())
+	return cmd.Run()
+}
+
+func (p *adhocRunParams) script(ctx context.Context, perfData string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.perfPath, "script", "-i", perfData)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("run perf script: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+
+	"github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
+	"github.com/grafana/pyroscope/pkg/operations"
+	"github.com/grafana/pyroscope/pkg/phlaredb"
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+)
+
+const (
+	backupManifestFilename = "backup.json"
+	backupDirTimeFormat    = "20060102T150405Z"
+)
+
+// backupManifest describes a backup of the blocks of a tenant. It is
+// written once all the blocks are copied and verified: a backup without
+// manifest is incomplete.
+type backupManifest struct {
+	Tenant    string        `json:"tenant"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Blocks    []backupBlock `json:"blocks"`
+}
+
+type backupBlock struct {
+	ID      ulid.ULID `json:"id"`
+	MinTime int64     `json:"minTime"`
+	MaxTime int64     `json:"maxTime"`
+	// Source is the bucket or the ingester directory of the block.
+	Source string `json:"source"`
+	Size   uint64 `json:"size"`
+	// Files are the SHA-256 checksums of the files of the block, by path.
+	Files map[string]string `json:"files"`
+}
+
+var errNoBackup = errors.New("no backup found")
+
+type blocksBackupParams struct {
+	bucketName      string
+	objectStoreType string
+	tenant          string
+	localPaths      []string
+	dest            string
+}
+
+func addBlocksBackupParams(cmd commander) *blocksBackupParams {
+	params := &blocksBackupParams{}
+	cmd.Arg("dest", "The directory the backups are stored in.").Required().StringVar(&params.dest)
+	cmd.Flag("bucket-name", "The name of the object storage bucket. If empty, the blocks of --path are backed up.").StringVar(&params.bucketName)
+	cmd.Flag("object-store-type", "The type of the object storage (e.g., gcs).").Default("gcs").StringVar(&params.objectStoreType)
+	cmd.Flag("tenant", "The tenant of the blocks.").Default("anonymous").StringVar(&params.tenant)
+	cmd.Flag("local-path", "Local blocks directory of an ingester to include, e.g., ./data/<tenant>/local: the blocks not uploaded to the object storage yet (accepts multiples).").StringsVar(&params.localPaths)
+	return params
+}
+
+type blocksRestoreParams struct {
+	bucketName      string
+	objectStoreType string
+	tenant          string
+	at              string
+	dryRun          bool
+	src             string
+}
+
+func addBlocksRestoreParams(cmd commander) *blocksRestoreParams {
+	params := &blocksRestoreParams{}
+	cmd.Arg("src", "The directory the backups are stored in.").Required().ExistingDirVar(&params.src)
+	cmd.Flag("bucket-name", "The name of the object storage bucket to restore the blocks to. If empty, the blocks are restored to --path.").StringVar(&params.bucketName)
+	cmd.Flag("object-store-type", "The type of the object storage (e.g., gcs).").Default("gcs").StringVar(&params.objectStoreType)
+	cmd.Flag("tenant", "The tenant of the blocks.").Default("anonymous").StringVar(&params.tenant)
+	cmd.Flag("at", "Restore the last backup created before this time, e.g., 2024-05-01T12:00:00Z or now-1d.").Default("now").StringVar(&params.at)
+	cmd.Flag("dry-run", "Verify the backup and print the blocks to restore, without restoring them.").Default("false").BoolVar(&params.dryRun)
+	return params
+}
+
+// tenantBucket returns the bucket of the blocks of the tenant: the object
+// storage if a bucket name is given, --path otherwise.
+func tenantBucket(ctx context.Context, bucketName, objectStoreType, tenant string) (objstore.Bucket, error) {
+	return getBucket(ctx, &blocksQueryParams{
+		BucketName:      bucketName,
+		ObjectStoreType: objectStoreType,
+		TenantID:        tenant,
+	})
+}
+
+// blocksBackup copies the blocks of the tenant to a new backup in the
+// destination directory, and verifies them. The blocks marked for deletion
+// are skipped, and the blocks of the previous backup are linked instead of
+// being downloaded again.
+func blocksBackup(ctx context.Context, params *blocksBackupParams) error {
+	type source struct {
+		name   string
+		bucket objstore.Bucket
+	}
+	var sources []source
+	bucket, err := tenantBucket(ctx, params.bucketName, params.objectStoreType, params.tenant)
+	if err != nil {
+		return err
+	}
+	sources = append(sources, source{name: bucket.Name(), bucket: bucket})
+	for _, p := range params.localPaths {
+		b, err := filesystem.NewBucket(p)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source{name: p, bucket: b})
+	}
+
+	manifest := backupManifest{
+		Tenant:    params.tenant,
+		CreatedAt: time.Now().UTC(),
+	}
+	previousDir, previous, err := findBackup(filepath.Join(params.dest, params.tenant), manifest.CreatedAt)
+	switch {
+	case err == nil:
+	case errors.Is(err, errNoBackup) || errors.Is(err, os.ErrNotExist):
+		previous = &backupManifest{}
+	default:
+		return err
+	}
+	previousBlocks := make(map[ulid.ULID]backupBlock, len(previous.Blocks))
+	for _, b := range previous.Blocks {
+		previousBlocks[b.ID] = b
+	}
+	dir := filepath.Join(params.dest, params.tenant, manifest.CreatedAt.Format(backupDirTimeFormat))
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return errors.Wrap(err, "create backup dir")
+	}
+
+	// The blocks replicated to several ingesters, or already uploaded by an
+	// ingester, are copied once.
+	seen := make(map[ulid.ULID]struct{})
+	for _, s := range sources {
+		metas, err := phlaredb.NewBlockQuerier(ctx, s.bucket).BlockMetas(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "list blocks of %s", s.name)
+		}
+		for _, m := range metas {
+			if m == nil {
+				continue
+			}
+			if _, ok := seen[m.ULID]; ok {
+				continue
+			}
+			seen[m.ULID] = struct{}{}
+			marked, err := hasDeletionMark(ctx, s.bucket, m.ULID)
+			if err != nil {
+				return errors.Wrapf(err, "check deletion mark of block %s", m.ULID)
+			}
+			if marked {
+				level.Info(logger).Log("msg", "block marked for deletion, skipping", "block", m.ULID, "source", s.name)
+				continue
+			}
+			blockDir := filepath.Join(dir, m.ULID.String())
+			if b, ok := previousBlocks[m.ULID]; ok {
+				previousBlockDir := filepath.Join(previousDir, m.ULID.String())
+				err = verifyBlockChecksums(previousBlockDir, b.Files)
+				if err == nil {
+					err = linkBlockDir(previousBlockDir, blockDir, b.Files)
+				}
+				if err == nil {
+					manifest.Blocks = append(manifest.Blocks, b)
+					level.Info(logger).Log("msg", "block already backed up, linked", "block", m.ULID, "previous", previousDir)
+					continue
+				}
+				level.Warn(logger).Log("msg", "block of the previous backup can't be reused, downloading it", "block", m.ULID, "err", err)
+				if err = os.RemoveAll(blockDir); err != nil {
+					return err
+				}
+			}
+			if err = block.Download(ctx, logger, s.bucket, m.ULID, blockDir); err != nil {
+				return errors.Wrapf(err, "download block %s", m.ULID)
+			}
+			size, err := verifyBlockDir(blockDir)
+			if err != nil {
+				return errors.Wrapf(err, "verify block %s", m.ULID)
+			}
+			files, err := blockChecksums(blockDir)
+			if err != nil {
+				return errors.Wrapf(err, "checksum block %s", m.ULID)
+			}
+			manifest.Blocks = append(manifest.Blocks, backupBlock{
+				ID:      m.ULID,
+				MinTime: int64(m.MinTime),
+				MaxTime: int64(m.MaxTime),
+				Source:  s.name,
+				Size:    size,
+				Files:   files,
+			})
+			level.Info(logger).Log("msg", "block backed up", "block", m.ULID, "source", s.name, "size", humanize.Bytes(size))
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(dir, backupManifestFilename), data, 0o644); err != nil {
+		return errors.Wrap(err, "write backup manifest")
+	}
+	_, _ = fmt.Fprintf(output(ctx), "backed up %d blocks to %s\n", len(manifest.Blocks), dir)
+	return nil
+}
+
+// blocksRestore uploads the blocks of the last backup created before the
+// given time. The blocks already present in the destination are skipped.
+func blocksRestore(ctx context.Context, params *blocksRestoreParams) error {
+	at, err := operations.ParseTime(params.at)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse at")
+	}
+	dir, manifest, err := findBackup(filepath.Join(params.src, params.tenant), at)
+	if err != nil {
+		return err
+	}
+	level.Info(logger).Log("msg", "restoring backup", "dir", dir, "created_at", manifest.CreatedAt, "blocks", len(manifest.Blocks))
+
+	// All the blocks are verified before restoring any of them.
+	for _, b := range manifest.Blocks {
+		blockDir := filepath.Join(dir, b.ID.String())
+		if _, err = verifyBlockDir(blockDir); err != nil {
+			return errors.Wrapf(err, "verify block %s", b.ID)
+		}
+		if err = verifyBlockChecksums(blockDir, b.Files); err != nil {
+			return errors.Wrapf(err, "verify block %s", b.ID)
+		}
+	}
+	if params.dryRun {
+		for _, b := range manifest.Blocks {
+			_, _ = fmt.Fprintf(output(ctx), "%s\t%s\n", b.ID, humanize.Bytes(b.Size))
+		}
+		_, _ = fmt.Fprintf(output(ctx), "%d blocks verified\n", len(manifest.Blocks))
+		return nil
+	}
+
+	bucket, err := tenantBucket(ctx, params.bucketName, params.objectStoreType, params.tenant)
+	if err != nil {
+		return err
+	}
+	var restored int
+	for _, b := range manifest.Blocks {
+		exists, err := bucket.Exists(ctx, path.Join(b.ID.String(), block.MetaFilename))
+		if err != nil {
+			return err
+		}
+		if exists {
+			level.Info(logger).Log("msg", "block already exists, skipping", "block", b.ID)
+			continue
+		}
+		if err = block.Upload(ctx, logger, bucket, filepath.Join(dir, b.ID.String())); err != nil {
+			return errors.Wrapf(err, "upload block %s", b.ID)
+		}
+		restored++
+	}
+	_, _ = fmt.Fprintf(output(ctx), "restored %d blocks of %d from %s\n", restored, len(manifest.Blocks), dir)
+	return nil
+}
+
+// findBackup returns the last complete backup created before the given time.
+func findBackup(dir string, at time.Time) (string, *backupManifest, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() > entries[j].Name() })
+	for _, e := range entries {
+		createdAt, err := time.Parse(backupDirTimeFormat, e.Name())
+		if err != nil || !e.IsDir() || createdAt.After(at) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name(), backupManifestFilename))
+		if errors.Is(err, os.ErrNotExist) {
+			level.Warn(logger).Log("msg", "skipping incomplete backup", "dir", e.Name())
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		var m backupManifest
+		if err = json.Unmarshal(data, &m); err != nil {
+			return "", nil, errors.Wrapf(err, "read backup manifest of %s", e.Name())
+		}
+		return filepath.Join(dir, e.Name()), &m, nil
+	}
+	return "", nil, errors.Wrapf(errNoBackup, "in %s before %s", dir, at.Format(time.RFC3339))
+}
+
+// hasDeletionMark reports whether the block is marked for deletion, in the
+// block directory or in the markers location of the bucket.
+func hasDeletionMark(ctx context.Context, bucket objstore.Bucket, id ulid.ULID) (bool, error) {
+	for _, p := range []string{path.Join(id.String(), block.DeletionMarkFilename), block.DeletionMarkFilepath(id)} {
+		exists, err := bucket.Exists(ctx, p)
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// blockChecksums returns the SHA-256 checksums of the files of the block
+// directory, by path relative to the directory.
+func blockChecksums(dir string) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err = io.Copy(h, f); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		sums[filepath.ToSlash(rel)] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return sums, err
+}
+
+// verifyBlockChecksums checks that the files of the block directory are the
+// files backed up, with the same checksums.
+func verifyBlockChecksums(dir string, expected map[string]string) error {
+	if len(expected) == 0 {
+		return errors.New("no checksums in the backup manifest")
+	}
+	actual, err := blockChecksums(dir)
+	if err != nil {
+		return err
+	}
+	for name, sum := range expected {
+		if actual[name] != sum {
+			return fmt.Errorf("file %s has checksum %q, expected %q", name, actual[name], sum)
+		}
+	}
+	if len(actual) != len(expected) {
+		return fmt.Errorf("block has %d files, expected %d", len(actual), len(expected))
+	}
+	return nil
+}
+
+// linkBlockDir hard links the files of the block to the destination
+// directory, or copies them if they can't be linked.
+func linkBlockDir(src, dst string, files map[string]string) error {
+	for name := range files {
+		from, to := filepath.Join(src, filepath.FromSlash(name)), filepath.Join(dst, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+			return err
+		}
+		if err := os.Link(from, to); err == nil {
+			continue
+		}
+		if err := copyFile(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// verifyBlockDir checks that the files of the block meta are present with
+// the expected size, and returns the size of the block.
+func verifyBlockDir(dir string) (uint64, error) {
+	meta, err := block.ReadMetaFromDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var size uint64
+	for _, f := range meta.Files {
+		info, err := os.Stat(filepath.Join(dir, f.RelPath))
+		if err != nil {
+			return 0, err
+		}
+		if f.SizeBytes > 0 && uint64(info.Size()) != f.SizeBytes {
+			return 0, fmt.Errorf("file %s has size %d, expected %d", f.RelPath, info.Size(), f.SizeBytes)
+		}
+		size += uint64(info.Size())
+	}
+	return size, nil
+}
@@ -34,6 +34,7 @@ type canaryExporterParams struct {
 	ListenAddress string
 	TestFrequency time.Duration
 	TestDelay     time.Duration
+	QueryTimeout  time.Duration
 	QueryProbeSet string
 }
 
@@ -44,6 +45,7 @@ func addCanaryExporterParams(ceCmd commander) *canaryExporterParams {
 	ceCmd.Flag("listen-address", "Listen address for the canary exporter.").Default(":4101").StringVar(&params.ListenAddress)
 	ceCmd.Flag("test-frequency", "How often the specified Pyroscope cell should be tested.").Default("15s").DurationVar(&params.TestFrequency)
 	ceCmd.Flag("test-delay", "The delay between ingest and query requests.").Default("2s").DurationVar(&params.TestDelay)
+	ceCmd.Flag("query-timeout", "How long to wait for the ingested profile to become queryable, the query is retried every test-delay. The ingest-to-query latency is exported as a metric. 0 to query only once.").Default("10s").DurationVar(&params.QueryTimeout)
 	ceCmd.Flag("query-probe-set", "Which set of probes to use for query requests. Available sets are \"default\" and \"all\".").Default("default").EnumVar(&params.QueryProbeSet, "default", "all")
 	params.phlareClient = addPhlareClient(ceCmd)
 
@@ -80,6 +82,8 @@ type canaryExporterMetrics struct {
 	probeTLSVersion                         *prometheus.GaugeVec
 	probeSSLLastInformation                 *prometheus.GaugeVec
 	probeHTTPVersion                        *prometheus.GaugeVec
+	ingestToQueryDuration                   prometheus.Histogram
+	ingestToQueryTimeouts                   prometheus.Counter
 }
 
 func newCanaryExporterMetrics(reg prometheus.Registerer) *canaryExporterMetrics {
@@ -136,6 +140,15 @@ func newCanaryExporterMetrics(reg prometheus.Registerer) *canaryExporterMetrics
 			Name: "probe_http_version",
 			Help: "Returns the version of HTTP of the probe response",
 		}, []string{"name"}),
+		ingestToQueryDuration: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:    "probe_ingest_to_query_duration_seconds",
+			Help:    "Duration between the ingestion of the canary profile and the first query returning it",
+			Buckets: prometheus.ExponentialBuckets(0.25, 2, 10),
+		}),
+		ingestToQueryTimeouts: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "probe_ingest_to_query_timeouts_total",
+			Help: "Number of canary profiles not queryable within the query timeout",
+		}),
 	}
 }
 
@@ -317,13 +330,7 @@ func (ce *canaryExporter) testPyroscopeCell(ctx context.Context) error {
 		return fmt.Errorf("error during ingestion: %w", err)
 	}
 
-	if ce.params.TestDelay > 0 {
-		level.Info(logger).Log("msg", "waiting before running a query", "delay", ce.params.TestDelay)
-		select {
-		case <-time.After(ce.params.TestDelay):
-		case <-ctx.Done():
-		}
-	}
+	ce.waitQueryable(ctx, now)
 
 	// Now try to query the data back
 	var multiError multierror.MultiError
@@ -340,6 +347,53 @@ func (ce *canaryExporter) testPyroscopeCell(ctx context.Context) error {
 	return nil
 }
 
+// waitQueryable waits for the ingested profile to be returned by a query,
+// and records the ingest-to-query latency. The query is retried every test
+// delay until the query timeout: the query probes are run anyway.
+func (ce *canaryExporter) waitQueryable(ctx context.Context, now time.Time) {
+	ingested := time.Now()
+	sleep := func(d time.Duration) bool {
+		select {
+		case <-time.After(d):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if ce.params.TestDelay > 0 {
+		level.Info(logger).Log("msg", "waiting before running a query", "delay", ce.params.TestDelay)
+		if !sleep(ce.params.TestDelay) {
+			return
+		}
+	}
+	if ce.params.QueryTimeout <= 0 {
+		return
+	}
+
+	retryInterval := ce.params.TestDelay
+	if retryInterval <= 0 {
+		retryInterval = time.Second
+	}
+	// The queries are not instrumented: the probe metrics are only
+	// recorded by the query probes.
+	ce.params.client.Transport = ce.defaultTransport
+	deadline := ingested.Add(ce.params.QueryTimeout)
+	for {
+		err := ce.testSelectMergeProfile(ctx, now)
+		if err == nil {
+			latency := time.Since(ingested)
+			ce.metrics.ingestToQueryDuration.Observe(latency.Seconds())
+			level.Info(logger).Log("msg", "ingested profile is queryable", "latency", latency)
+			return
+		}
+		if time.Now().Add(retryInterval).After(deadline) || !sleep(retryInterval) {
+			ce.metrics.ingestToQueryTimeouts.Inc()
+			level.Warn(logger).Log("msg", "ingested profile is not queryable", "timeout", ce.params.QueryTimeout, "err", err)
+			return
+		}
+	}
+}
+
 func (ce *canaryExporter) runProbe(ctx context.Context, probeName string, probeFunc func(ctx context.Context) error) error {
 	rCtx, done := ce.doTrace(ctx, probeName)
 	result := false
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	"github.com/prometheus/common/model"
+
+	"github.com/grafana/pyroscope/pkg/compactor"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
+	"github.com/grafana/pyroscope/pkg/phlaredb"
+)
+
+type blocksPlanParams struct {
+	tenant         string
+	blockRanges    compactor.DurationList
+	shards         uint32
+	splitGroups    uint32
+	splitStageSize uint32
+}
+
+func addBlocksPlanParams(cmd commander) *blocksPlanParams {
+	params := &blocksPlanParams{}
+	cmd.Flag("tenant", "The tenant of the blocks.").Default("anonymous").StringVar(&params.tenant)
+	cmd.Flag("block-ranges", "List of compaction time ranges.").Default("1h0m0s,2h0m0s,8h0m0s").SetValue(&params.blockRanges)
+	cmd.Flag("split-and-merge-shards", "The number of shards to use when splitting blocks. 0 to disable splitting.").Default("0").Uint32Var(&params.shards)
+	cmd.Flag("split-groups", "Number of groups that blocks for splitting are grouped into.").Default("1").Uint32Var(&params.splitGroups)
+	cmd.Flag("split-and-merge-stage-size", "Number of stages split shards will be written to. 0 means no stages are used.").Default("0").Uint32Var(&params.splitStageSize)
+	return params
+}
+
+// blocksPlan prints the compaction jobs of the blocks, without compacting
+// them: it previews the compaction of a tenant with the given compactor
+// configuration. The jobs with distinct sharding keys can be run by
+// different compactors in parallel.
+func blocksPlan(ctx context.Context, params *blocksPlanParams) error {
+	bucket, err := filesystem.NewBucket(cfg.blocks.path)
+	if err != nil {
+		return err
+	}
+	metas, err := phlaredb.NewBlockQuerier(ctx, bucket).BlockMetas(ctx)
+	if err != nil {
+		return err
+	}
+	jobs, err := compactor.PlanJobs(params.tenant, metas, params.blockRanges.ToMilliseconds(), params.shards, params.splitStageSize, params.splitGroups)
+	if err != nil {
+		return err
+	}
+
+	table := tablewriter.NewWriter(output(ctx))
+	table.SetHeader([]string{"Job", "Stage", "Sharding key", "MinTime", "MaxTime", "Blocks", "Size"})
+	var (
+		splitJobs    int
+		shardingKeys = make(map[string]struct{})
+	)
+	for _, j := range jobs {
+		stage := "merge"
+		if j.UseSplitting() {
+			stage = "split"
+			splitJobs++
+		}
+		shardingKeys[j.ShardingKey()] = struct{}{}
+		var size uint64
+		for _, m := range j.Metas() {
+			for _, f := range m.Files {
+				size += f.SizeBytes
+			}
+		}
+		table.Append([]string{
+			j.Key(),
+			stage,
+			j.ShardingKey(),
+			model.Time(j.MinTime()).Time().Format(time.RFC3339),
+			model.Time(j.MaxTime()).Time().Format(time.RFC3339),
+			strconv.Itoa(len(j.Metas())),
+			humanize.Bytes(size),
+		})
+	}
+	table.Render()
+
+	_, _ = fmt.Fprintf(output(ctx), "%d blocks, %d jobs: %d split, %d merge; up to %d jobs can run in parallel.\n",
+		len(metas), len(jobs), splitJobs, len(jobs)-splitJobs, len(shardingKeys))
+	return nil
+}
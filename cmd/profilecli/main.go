@@ -56,6 +56,20 @@ func main() {
 	blocksCompactCmd.Arg("dest", "The destination where compacted blocks should be stored.").Required().StringVar(&cfg.blocks.compact.dst)
 	blocksCompactCmd.Flag("shards", "The amount of shards to split output blocks into.").Default("0").IntVar(&cfg.blocks.compact.shards)
 
+	blocksPlanCmd := blocksCmd.Command("plan", "Print the compaction jobs of the blocks, without compacting them.")
+	blocksPlanParams := addBlocksPlanParams(blocksPlanCmd)
+
+	blocksBackupCmd := blocksCmd.Command("backup", "Back up the blocks of a tenant, including the blocks of the ingesters not uploaded yet.")
+	blocksBackupParams := addBlocksBackupParams(blocksBackupCmd)
+	blocksRestoreCmd := blocksCmd.Command("restore", "Verify and restore the blocks of a tenant from a backup.")
+	blocksRestoreParams := addBlocksRestoreParams(blocksRestoreCmd)
+
+	blocksQuarantineCmd := blocksCmd.Command("quarantine", "Operate on the blocks moved to the quarantine directory by the compactor.")
+	blocksQuarantineListCmd := blocksQuarantineCmd.Command("list", "List the quarantined blocks of a tenant.")
+	blocksQuarantineListParams := addBlocksQuarantineParams(blocksQuarantineListCmd)
+	blocksQuarantinePurgeCmd := blocksQuarantineCmd.Command("purge", "Permanently delete the quarantined blocks of a tenant.")
+	blocksQuarantinePurgeParams := addBlocksQuarantinePurgeParams(blocksQuarantinePurgeCmd)
+
 	blocksQueryCmd := blocksCmd.Command("query", "Query on local/remote blocks.")
 	blocksQuerySeriesCmd := blocksQueryCmd.Command("series", "Request series labels on local/remote blocks.")
 	blocksQuerySeriesParams := addBlocksQuerySeriesParams(blocksQuerySeriesCmd)
@@ -77,6 +91,12 @@ func main() {
 	queryGoPGOCmd := queryCmd.Command("go-pgo", "Request profile for Go PGO.")
 	queryGoPGOOutput := queryGoPGOCmd.Flag("output", "How to output the result, examples: console, raw, pprof=./my.pprof").Default("pprof=./default.pgo").String()
 	queryGoPGOParams := addQueryGoPGOParams(queryGoPGOCmd)
+	queryExportCmd := queryCmd.Command("export", "Export merged profile as speedscope JSON or collapsed stacks.")
+	queryExportParams := addQueryExportParams(queryExportCmd)
+	queryTopCmd := queryCmd.Command("top", "Show the top functions of the merged profile.")
+	queryTopParams := addQueryTopParams(queryTopCmd)
+	queryDiffCmd := queryCmd.Command("diff", "Compare the merged profiles of two selectors or time ranges.")
+	queryDiffParams := addQueryDiffParams(queryDiffCmd)
 	querySeriesCmd := queryCmd.Command("series", "Request series labels.")
 	querySeriesParams := addQuerySeriesParams(querySeriesCmd)
 	queryLabelValuesCardinalityCmd := queryCmd.Command("label-values-cardinality", "Request label values cardinality.")
@@ -88,6 +108,16 @@ func main() {
 	uploadCmd := app.Command("upload", "Upload profile(s).")
 	uploadParams := addUploadParams(uploadCmd)
 
+	uploadSymbolsCmd := app.Command("upload-symbols", "Upload debug info file(s) to symbolize the profiles of stripped binaries.")
+	uploadSymbolsParams := addUploadSymbolsParams(uploadSymbolsCmd)
+
+	perfGateCmd := app.Command("perf-gate", "Compare profiles with a baseline, and fail if the total or selected functions regress beyond thresholds.")
+	perfGateParams := addPerfGateParams(perfGateCmd)
+
+	adhocCmd := app.Command("adhoc", "Operate on ad-hoc profiles.")
+	adhocRunCmd := adhocCmd.Command("run", "Run a command, capture its CPU profile with perf, and upload it as an ad-hoc profile.")
+	adhocRunParams := addAdhocRunParams(adhocRunCmd)
+
 	canaryExporterCmd := app.Command("canary-exporter", "Run the canary exporter.")
 	canaryExporterParams := addCanaryExporterParams(canaryExporterCmd)
 
@@ -102,6 +132,10 @@ func main() {
 	raftInfoCmd := raftCmd.Command("info", "Print info about a Raft node.")
 	raftInfoParams := addRaftInfoParams(raftInfoCmd)
 
+	tokenCmd := adminCmd.Command("token", "Manage API tokens.")
+	tokenGenerateCmd := tokenCmd.Command("generate", "Generate an API token, and print its entry for the API tokens file.")
+	tokenGenerateParams := addTokenGenerateParams(tokenGenerateCmd)
+
 	// parse command line arguments
 	parsedCmd := kingpin.MustParse(app.Parse(os.Args[1:]))
 
@@ -133,6 +167,18 @@ func main() {
 		if err := queryGoPGO(ctx, queryGoPGOParams, *queryGoPGOOutput); err != nil {
 			os.Exit(checkError(err))
 		}
+	case queryExportCmd.FullCommand():
+		if err := queryExport(ctx, queryExportParams); err != nil {
+			os.Exit(checkError(err))
+		}
+	case queryTopCmd.FullCommand():
+		if err := queryTop(ctx, queryTopParams); err != nil {
+			os.Exit(checkError(err))
+		}
+	case queryDiffCmd.FullCommand():
+		if err := queryDiff(ctx, queryDiffParams); err != nil {
+			os.Exit(checkError(err))
+		}
 	case querySeriesCmd.FullCommand():
 		if err := querySeries(ctx, querySeriesParams); err != nil {
 			os.Exit(checkError(err))
@@ -161,6 +207,18 @@ func main() {
 		if err := upload(ctx, uploadParams); err != nil {
 			os.Exit(checkError(err))
 		}
+	case uploadSymbolsCmd.FullCommand():
+		if err := uploadSymbols(ctx, uploadSymbolsParams); err != nil {
+			os.Exit(checkError(err))
+		}
+	case perfGateCmd.FullCommand():
+		if err := perfGate(ctx, perfGateParams); err != nil {
+			os.Exit(checkError(err))
+		}
+	case adhocRunCmd.FullCommand():
+		if err := adhocRun(ctx, adhocRunParams); err != nil {
+			os.Exit(checkError(err))
+		}
 	case canaryExporterCmd.FullCommand():
 		if err := newCanaryExporter(canaryExporterParams).run(ctx); err != nil {
 			os.Exit(checkError(err))
@@ -173,6 +231,26 @@ func main() {
 		if err := blocksCompact(ctx, cfg.blocks.compact.src, cfg.blocks.compact.dst, cfg.blocks.compact.shards); err != nil {
 			os.Exit(checkError(err))
 		}
+	case blocksPlanCmd.FullCommand():
+		if err := blocksPlan(ctx, blocksPlanParams); err != nil {
+			os.Exit(checkError(err))
+		}
+	case blocksBackupCmd.FullCommand():
+		if err := blocksBackup(ctx, blocksBackupParams); err != nil {
+			os.Exit(checkError(err))
+		}
+	case blocksRestoreCmd.FullCommand():
+		if err := blocksRestore(ctx, blocksRestoreParams); err != nil {
+			os.Exit(checkError(err))
+		}
+	case blocksQuarantineListCmd.FullCommand():
+		if err := blocksQuarantineList(ctx, blocksQuarantineListParams); err != nil {
+			os.Exit(checkError(err))
+		}
+	case blocksQuarantinePurgeCmd.FullCommand():
+		if err := blocksQuarantinePurge(ctx, blocksQuarantinePurgeParams); err != nil {
+			os.Exit(checkError(err))
+		}
 	case readyCmd.FullCommand():
 		if err := ready(ctx, readyParams); err != nil {
 			os.Exit(checkError(err))
@@ -181,6 +259,10 @@ func main() {
 		if err := raftInfo(ctx, raftInfoParams); err != nil {
 			os.Exit(checkError(err))
 		}
+	case tokenGenerateCmd.FullCommand():
+		if err := tokenGenerate(ctx, tokenGenerateParams); err != nil {
+			os.Exit(checkError(err))
+		}
 	default:
 		level.Error(logger).Log("msg", "unknown command", "cmd", parsedCmd)
 	}
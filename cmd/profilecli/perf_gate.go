@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+
+	"github.com/grafana/pyroscope/pkg/model"
+)
+
+type perfGateParams struct {
+	*queryProfileParams
+	BaselineQuery       string
+	BaselineFrom        string
+	BaselineTo          string
+	MaxTotalIncrease    float64
+	FunctionThresholds  map[string]string
+	MaxFunctionIncrease float64
+}
+
+func addPerfGateParams(cmd commander) *perfGateParams {
+	params := &perfGateParams{
+		FunctionThresholds: map[string]string{},
+	}
+	params.queryProfileParams = addQueryProfileParams(cmd)
+	cmd.Flag("baseline-query", "Label selector of the baseline profiles, e.g., the benchmark profiles of the main branch.").Required().StringVar(&params.BaselineQuery)
+	cmd.Flag("baseline-from", "Beginning of the baseline query. Defaults to --from.").StringVar(&params.BaselineFrom)
+	cmd.Flag("baseline-to", "End of the baseline query. Defaults to --to.").StringVar(&params.BaselineTo)
+	cmd.Flag("max-total-increase", "Maximum increase of the profile total, in percent. Negative to disable the check.").Default("5").Float64Var(&params.MaxTotalIncrease)
+	cmd.Flag("function", "Check the total value of a function, with its maximum increase in percent, e.g., --function='main.parse=10'. An empty threshold uses --max-function-increase.").StringMapVar(&params.FunctionThresholds)
+	cmd.Flag("max-function-increase", "Default maximum increase of the total value of the checked functions, in percent.").Default("10").Float64Var(&params.MaxFunctionIncrease)
+	return params
+}
+
+// gateCheck compares the values per second of the time ranges, so that
+// the baseline and the candidate time ranges may have different lengths.
+type gateCheck struct {
+	name      string
+	baseline  float64
+	candidate float64
+	threshold float64
+}
+
+// increase returns the increase of the candidate value, in percent.
+func (c gateCheck) increase() float64 {
+	if c.baseline == 0 {
+		if c.candidate == 0 {
+			return 0
+		}
+		return 100
+	}
+	return (c.candidate - c.baseline) * 100 / c.baseline
+}
+
+func (c gateCheck) failed() bool {
+	return c.increase() > c.threshold
+}
+
+// perfGate compares the profiles selected with --query, typically the
+// profiles of a benchmark run uploaded in a pull request, with the baseline
+// profiles, and fails if the total or the functions checked regress beyond
+// their thresholds.
+func perfGate(ctx context.Context, params *perfGateParams) error {
+	diffParams := &queryDiffParams{
+		queryProfileParams: params.queryProfileParams,
+		LeftQuery:          params.BaselineQuery,
+		LeftFrom:           params.BaselineFrom,
+		LeftTo:             params.BaselineTo,
+	}
+	baseline, candidate, err := diffParams.sides()
+	if err != nil {
+		return err
+	}
+	if !baseline.from.Before(baseline.to) || !candidate.from.Before(candidate.to) {
+		return errors.New("the time ranges must not be empty")
+	}
+	checks, err := params.checks()
+	if err != nil {
+		return err
+	}
+	level.Info(logger).Log("msg", "comparing profiles with the baseline", "url", params.URL, "type", params.ProfileType,
+		"query", candidate.query, "from", candidate.from, "to", candidate.to,
+		"baseline_query", baseline.query, "baseline_from", baseline.from, "baseline_to", baseline.to)
+
+	baselineTree, candidateTree, err := params.selectDiffTrees(ctx, params.ProfileType, baseline, candidate)
+	if err != nil {
+		return err
+	}
+	if baselineTree.Total() == 0 {
+		return errors.New("no baseline profiles found")
+	}
+	if candidateTree.Total() == 0 {
+		return errors.New("no profiles found")
+	}
+
+	evaluateGateChecks(checks, baselineTree, candidateTree, baseline.to.Sub(baseline.from), candidate.to.Sub(candidate.from))
+	failed := writeGateReport(output(ctx), checks)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// checks returns the checks, with the thresholds but without the values.
+// The total check is first, and the functions follow in name order.
+func (p *perfGateParams) checks() ([]gateCheck, error) {
+	var checks []gateCheck
+	if p.MaxTotalIncrease >= 0 {
+		checks = append(checks, gateCheck{threshold: p.MaxTotalIncrease})
+	}
+	names := make([]string, 0, len(p.FunctionThresholds))
+	for name := range p.FunctionThresholds {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		threshold := p.MaxFunctionIncrease
+		if v := p.FunctionThresholds[name]; v != "" {
+			var err error
+			if threshold, err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, errors.Wrapf(err, "invalid threshold of function %s", name)
+			}
+		}
+		checks = append(checks, gateCheck{name: name, threshold: threshold})
+	}
+	if len(checks) == 0 {
+		return nil, errors.New("nothing to check: enable --max-total-increase or add --function")
+	}
+	return checks, nil
+}
+
+// evaluateGateChecks sets the values of the checks, per second of the time
+// range of the profiles: the total of the profiles for the check without a
+// function name, and the total value of the function otherwise.
+func evaluateGateChecks(checks []gateCheck, baseline, candidate *model.Tree, baselineDuration, candidateDuration time.Duration) {
+	baselineStats := functionStats(baseline)
+	candidateStats := functionStats(candidate)
+	for i := range checks {
+		c := &checks[i]
+		if c.name == "" {
+			c.baseline = float64(baseline.Total()) / baselineDuration.Seconds()
+			c.candidate = float64(candidate.Total()) / candidateDuration.Seconds()
+			continue
+		}
+		if s, ok := baselineStats[c.name]; ok {
+			c.baseline = float64(s.total) / baselineDuration.Seconds()
+		}
+		if s, ok := candidateStats[c.name]; ok {
+			c.candidate = float64(s.total) / candidateDuration.Seconds()
+		}
+	}
+}
+
+// writeGateReport writes the result of the checks, and returns the number
+// of failed checks.
+func writeGateReport(w io.Writer, checks []gateCheck) (failed int) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Check", "Baseline (per second)", "Candidate (per second)", "Change", "Threshold", "Result"})
+	for _, c := range checks {
+		name := c.name
+		if name == "" {
+			name = "(total)"
+		}
+		result := "OK"
+		if c.failed() {
+			result = "FAIL"
+			failed++
+		}
+		table.Append([]string{
+			name,
+			strconv.FormatFloat(c.baseline, 'f', 2, 64),
+			strconv.FormatFloat(c.candidate, 'f', 2, 64),
+			fmt.Sprintf("%+.2f%%", c.increase()),
+			fmt.Sprintf("%.2f%%", c.threshold),
+			result,
+		})
+	}
+	table.Render()
+	return failed
+}
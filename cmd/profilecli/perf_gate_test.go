@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/model"
+)
+
+func Test_evaluateGateChecks(t *testing.T) {
+	// The baseline covers two hours, the candidate one hour: the values
+	// are compared per second.
+	baseline := new(model.Tree)
+	baseline.InsertStack(1200, "main", "parse")
+	baseline.InsertStack(600, "main", "encode")
+	candidate := new(model.Tree)
+	candidate.InsertStack(600, "main", "parse")
+	candidate.InsertStack(450, "main", "encode")
+
+	checks := []gateCheck{
+		{threshold: 5},
+		{name: "encode", threshold: 10},
+		{name: "main", threshold: 20},
+		{name: "parse", threshold: 0},
+	}
+	evaluateGateChecks(checks, baseline, candidate, 2*time.Hour, time.Hour)
+
+	require.Len(t, checks, 4)
+	assert.InDelta(t, 0.25, checks[0].baseline, 1e-9)
+	assert.InDelta(t, 1050.0/3600, checks[0].candidate, 1e-9)
+	assert.InDelta(t, 16.67, checks[0].increase(), 0.01)
+	assert.True(t, checks[0].failed())
+
+	assert.InDelta(t, 50, checks[1].increase(), 1e-9)
+	assert.True(t, checks[1].failed())
+	assert.InDelta(t, 16.67, checks[2].increase(), 0.01)
+	assert.False(t, checks[2].failed())
+	assert.InDelta(t, 0, checks[3].increase(), 1e-9)
+	assert.False(t, checks[3].failed())
+
+	var out bytes.Buffer
+	assert.Equal(t, 2, writeGateReport(&out, checks))
+	assert.Contains(t, out.String(), "+50.00%")
+}
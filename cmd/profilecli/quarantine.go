@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+)
+
+type blocksQuarantineParams struct {
+	bucketName      string
+	objectStoreType string
+	tenant          string
+}
+
+func addBlocksQuarantineParams(cmd commander) *blocksQuarantineParams {
+	params := &blocksQuarantineParams{}
+	cmd.Flag("bucket-name", "The name of the object storage bucket. If empty, the blocks of --path are used.").StringVar(&params.bucketName)
+	cmd.Flag("object-store-type", "The type of the object storage (e.g., gcs).").Default("gcs").StringVar(&params.objectStoreType)
+	cmd.Flag("tenant", "The tenant of the blocks.").Default("anonymous").StringVar(&params.tenant)
+	return params
+}
+
+type blocksQuarantinePurgeParams struct {
+	*blocksQuarantineParams
+	blocks    []string
+	olderThan time.Duration
+	dryRun    bool
+}
+
+func addBlocksQuarantinePurgeParams(cmd commander) *blocksQuarantinePurgeParams {
+	params := &blocksQuarantinePurgeParams{blocksQuarantineParams: addBlocksQuarantineParams(cmd)}
+	cmd.Flag("block", "The ID of the quarantined block to delete (accepts multiples). If empty, all the quarantined blocks are deleted.").StringsVar(&params.blocks)
+	cmd.Flag("older-than", "Only delete the blocks quarantined for longer than this duration.").Default("0s").DurationVar(&params.olderThan)
+	cmd.Flag("dry-run", "Print the blocks to delete, without deleting them.").BoolVar(&params.dryRun)
+	return params
+}
+
+// blocksQuarantineList prints the blocks the compactor moved to the
+// quarantine directory of the tenant, and why.
+func blocksQuarantineList(ctx context.Context, params *blocksQuarantineParams) error {
+	bucket, err := tenantBucket(ctx, params.bucketName, params.objectStoreType, params.tenant)
+	if err != nil {
+		return err
+	}
+	marks, err := block.ListQuarantined(ctx, logger, bucket)
+	if err != nil {
+		return err
+	}
+
+	table := tablewriter.NewWriter(output(ctx))
+	table.SetHeader([]string{"Block ID", "Quarantined", "Reason", "Details"})
+	for _, m := range marks {
+		var quarantined string
+		if m.QuarantineTime > 0 {
+			quarantined = time.Unix(m.QuarantineTime, 0).UTC().Format(time.RFC3339)
+		}
+		table.Append([]string{m.ID.String(), quarantined, string(m.Reason), m.Details})
+	}
+	table.Render()
+	return nil
+}
+
+// blocksQuarantinePurge permanently deletes the quarantined blocks of the
+// tenant.
+func blocksQuarantinePurge(ctx context.Context, params *blocksQuarantinePurgeParams) error {
+	selected := make(map[ulid.ULID]struct{}, len(params.blocks))
+	for _, b := range params.blocks {
+		id, err := ulid.Parse(b)
+		if err != nil {
+			return fmt.Errorf("invalid block ID %q: %w", b, err)
+		}
+		selected[id] = struct{}{}
+	}
+
+	bucket, err := tenantBucket(ctx, params.bucketName, params.objectStoreType, params.tenant)
+	if err != nil {
+		return err
+	}
+	marks, err := block.ListQuarantined(ctx, logger, bucket)
+	if err != nil {
+		return err
+	}
+
+	out := output(ctx)
+	var deleted int
+	for _, m := range marks {
+		if _, ok := selected[m.ID]; len(selected) > 0 && !ok {
+			continue
+		}
+		// The blocks without a mark are being quarantined: they are
+		// only deleted when requested explicitly.
+		if m.QuarantineTime == 0 && len(selected) == 0 {
+			continue
+		}
+		if params.olderThan > 0 && time.Since(time.Unix(m.QuarantineTime, 0)) < params.olderThan {
+			continue
+		}
+		if params.dryRun {
+			fmt.Fprintf(out, "would delete %s\n", m.ID)
+			continue
+		}
+		if err = block.DeleteQuarantined(ctx, logger, bucket, m.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "deleted %s\n", m.ID)
+		deleted++
+	}
+	fmt.Fprintf(out, "%d quarantined blocks deleted\n", deleted)
+	return nil
+}
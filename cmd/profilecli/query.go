@@ -3,12 +3,14 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
 	"sort"
 	"time"
 
 	"connectrpc.com/connect"
 	"github.com/dustin/go-humanize"
 	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/runutil"
 	"github.com/olekukonko/tablewriter"
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
@@ -20,6 +22,8 @@ import (
 	"github.com/grafana/pyroscope/api/gen/proto/go/storegateway/v1/storegatewayv1connect"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	connectapi "github.com/grafana/pyroscope/pkg/api/connect"
+	"github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/convert/speedscope"
 	"github.com/grafana/pyroscope/pkg/operations"
 )
 
@@ -336,3 +340,53 @@ func queryLabelValuesCardinality(ctx context.Context, params *queryLabelValuesCa
 
 	return nil
 }
+
+type queryExportParams struct {
+	*queryProfileParams
+	Format string
+	Output string
+}
+
+func addQueryExportParams(queryCmd commander) *queryExportParams {
+	params := new(queryExportParams)
+	params.queryProfileParams = addQueryProfileParams(queryCmd)
+	queryCmd.Flag("format", "Export format (speedscope or collapsed).").Default("speedscope").EnumVar(&params.Format, "speedscope", "collapsed")
+	queryCmd.Flag("output", "File to write the export to. Writes to stdout when empty.").Default("").StringVar(&params.Output)
+	return params
+}
+
+func queryExport(ctx context.Context, params *queryExportParams) (err error) {
+	from, to, err := params.parseFromTo()
+	if err != nil {
+		return err
+	}
+	level.Info(logger).Log("msg", "export merged profile from profile store", "url", params.URL, "from", from, "to", to, "query", params.Query, "type", params.ProfileType, "format", params.Format)
+
+	profileType, err := model.ParseProfileTypeSelector(params.ProfileType)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse profile type")
+	}
+
+	tree, err := params.selectTree(ctx, params.ProfileType, params.Query, from, to)
+	if err != nil {
+		return err
+	}
+
+	w := output(ctx)
+	if params.Output != "" {
+		f, err := os.OpenFile(params.Output, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return errors.Wrap(err, "failed to create output file")
+		}
+		defer runutil.CloseWithErrCapture(&err, f, "failed to close output file")
+		w = f
+	}
+
+	switch params.Format {
+	case "collapsed":
+		tree.WriteCollapsed(w)
+		return nil
+	default:
+		return speedscope.WriteTree(w, tree, profileType.ID, speedscope.UnitFromSampleUnit(profileType.SampleUnit))
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log/level"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/operations"
+)
+
+// selectTree returns the merged stack traces of the profiles matching the
+// selector, as a tree.
+func (c *phlareClient) selectTree(ctx context.Context, profileType, query string, from, to time.Time) (*model.Tree, error) {
+	resp, err := c.queryClient().SelectMergeStacktraces(ctx, connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: profileType,
+		Start:         from.UnixMilli(),
+		End:           to.UnixMilli(),
+		LabelSelector: query,
+		Format:        querierv1.ProfileFormat_PROFILE_FORMAT_TREE,
+	}))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+	tree, err := model.UnmarshalTree(resp.Msg.Tree)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal tree")
+	}
+	return tree, nil
+}
+
+type functionStat struct {
+	name  string
+	self  int64
+	total int64
+}
+
+// functionStats aggregates the self and total values of the tree by function.
+// The stacks are walked from the roots: the virtual root of the unmarshalled
+// trees is not a function.
+func functionStats(t *model.Tree) map[string]*functionStat {
+	stats := make(map[string]*functionStat)
+	seen := make(map[string]struct{})
+	t.IterateStacksFromRoot(func(self int64, stack []string) {
+		clear(seen)
+		for _, fn := range stack {
+			if _, ok := seen[fn]; ok {
+				// Recursive calls are only counted once.
+				continue
+			}
+			seen[fn] = struct{}{}
+			s, ok := stats[fn]
+			if !ok {
+				s = &functionStat{name: fn}
+				stats[fn] = s
+			}
+			s.total += self
+		}
+		stats[stack[len(stack)-1]].self += self
+	})
+	return stats
+}
+
+func percentage(v, total int64) string {
+	if total == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(float64(v)*100/float64(total), 'f', 2, 64) + "%"
+}
+
+type queryTopParams struct {
+	*queryProfileParams
+	TopN int
+	Sort string
+}
+
+func addQueryTopParams(queryCmd commander) *queryTopParams {
+	params := new(queryTopParams)
+	params.queryProfileParams = addQueryProfileParams(queryCmd)
+	queryCmd.Flag("top-n", "Number of functions to show.").Default("20").IntVar(&params.TopN)
+	queryCmd.Flag("sort", "Sort the functions by their self or total value.").Default("self").EnumVar(&params.Sort, "self", "total")
+	return params
+}
+
+func queryTop(ctx context.Context, params *queryTopParams) error {
+	from, to, err := params.parseFromTo()
+	if err != nil {
+		return err
+	}
+	level.Info(logger).Log("msg", "query top functions from profile store", "url", params.URL, "from", from, "to", to, "query", params.Query, "type", params.ProfileType)
+
+	tree, err := params.selectTree(ctx, params.ProfileType, params.Query, from, to)
+	if err != nil {
+		return err
+	}
+	writeTop(output(ctx), tree, params.Sort, params.TopN)
+	return nil
+}
+
+func writeTop(w io.Writer, tree *model.Tree, sortBy string, n int) {
+	stats := make([]*functionStat, 0)
+	for _, s := range functionStats(tree) {
+		stats = append(stats, s)
+	}
+	slices.SortFunc(stats, func(a, b *functionStat) int {
+		if sortBy == "total" {
+			if c := cmp.Compare(b.total, a.total); c != 0 {
+				return c
+			}
+		}
+		if c := cmp.Compare(b.self, a.self); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.name, b.name)
+	})
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+
+	total := tree.Total()
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Function", "Self", "Self %", "Total", "Total %"})
+	for _, s := range stats {
+		table.Append([]string{
+			s.name,
+			strconv.FormatInt(s.self, 10),
+			percentage(s.self, total),
+			strconv.FormatInt(s.total, 10),
+			percentage(s.total, total),
+		})
+	}
+	table.Render()
+}
+
+type queryDiffParams struct {
+	*queryProfileParams
+	LeftQuery  string
+	LeftFrom   string
+	LeftTo     string
+	RightQuery string
+	RightFrom  string
+	RightTo    string
+	Format     string
+	TopN       int
+	Normalize  bool
+}
+
+func addQueryDiffParams(queryCmd commander) *queryDiffParams {
+	params := new(queryDiffParams)
+	params.queryProfileParams = addQueryProfileParams(queryCmd)
+	queryCmd.Flag("left-query", "Label selector of the baseline profiles. Defaults to --query.").StringVar(&params.LeftQuery)
+	queryCmd.Flag("left-from", "Beginning of the baseline query. Defaults to --from.").StringVar(&params.LeftFrom)
+	queryCmd.Flag("left-to", "End of the baseline query. Defaults to --to.").StringVar(&params.LeftTo)
+	queryCmd.Flag("right-query", "Label selector of the compared profiles. Defaults to --query.").StringVar(&params.RightQuery)
+	queryCmd.Flag("right-from", "Beginning of the compared query. Defaults to --from.").StringVar(&params.RightFrom)
+	queryCmd.Flag("right-to", "End of the compared query. Defaults to --to.").StringVar(&params.RightTo)
+	queryCmd.Flag("format", "Output format: folded stacks with the baseline and compared values, or a table of the top regressions.").Default("top").EnumVar(&params.Format, "folded", "top")
+	queryCmd.Flag("top-n", "Number of functions to show in the top regressions table.").Default("20").IntVar(&params.TopN)
+	queryCmd.Flag("normalize", "Scale the compared values to the total of the baseline, e.g., to compare time ranges of different durations.").BoolVar(&params.Normalize)
+	return params
+}
+
+// sides returns the selectors and time ranges of the baseline and compared
+// profiles.
+func (p *queryDiffParams) sides() (left, right diffSide, err error) {
+	or := func(v, def string) string {
+		if v != "" {
+			return v
+		}
+		return def
+	}
+	left = diffSide{query: or(p.LeftQuery, p.Query)}
+	right = diffSide{query: or(p.RightQuery, p.Query)}
+	for _, s := range []struct {
+		side     *diffSide
+		from, to string
+	}{
+		{&left, or(p.LeftFrom, p.From), or(p.LeftTo, p.To)},
+		{&right, or(p.RightFrom, p.From), or(p.RightTo, p.To)},
+	} {
+		if s.side.from, err = operations.ParseTime(s.from); err != nil {
+			return left, right, errors.Wrap(err, "failed to parse from")
+		}
+		if s.side.to, err = operations.ParseTime(s.to); err != nil {
+			return left, right, errors.Wrap(err, "failed to parse to")
+		}
+		if s.side.to.Before(s.side.from) {
+			return left, right, errors.New("from cannot be after to")
+		}
+	}
+	return left, right, nil
+}
+
+type diffSide struct {
+	query    string
+	from, to time.Time
+}
+
+func (c *phlareClient) selectDiffTrees(ctx context.Context, profileType string, left, right diffSide) (*model.Tree, *model.Tree, error) {
+	leftTree, err := c.selectTree(ctx, profileType, left.query, left.from, left.to)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "baseline")
+	}
+	rightTree, err := c.selectTree(ctx, profileType, right.query, right.from, right.to)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "compared")
+	}
+	return leftTree, rightTree, nil
+}
+
+func queryDiff(ctx context.Context, params *queryDiffParams) error {
+	left, right, err := params.sides()
+	if err != nil {
+		return err
+	}
+	level.Info(logger).Log("msg", "query diff from profile store", "url", params.URL, "type", params.ProfileType,
+		"left_query", left.query, "left_from", left.from, "left_to", left.to,
+		"right_query", right.query, "right_from", right.from, "right_to", right.to)
+
+	leftTree, rightTree, err := params.selectDiffTrees(ctx, params.ProfileType, left, right)
+	if err != nil {
+		return err
+	}
+	scale := 1.0
+	if params.Normalize && rightTree.Total() > 0 {
+		scale = float64(leftTree.Total()) / float64(rightTree.Total())
+	}
+
+	switch params.Format {
+	case "folded":
+		writeFoldedDiff(output(ctx), leftTree, rightTree, scale)
+	default:
+		writeTopRegressions(output(ctx), computeRegressions(leftTree, rightTree, scale), params.TopN)
+	}
+	return nil
+}
+
+// writeFoldedDiff writes the stacks of both trees with the baseline and the
+// compared values, in the format of the difffolded.pl script of FlameGraph.
+func writeFoldedDiff(w io.Writer, left, right *model.Tree, scale float64) {
+	type values struct{ left, right int64 }
+	stacks := make(map[string]*values)
+	var keys []string
+	collect := func(t *model.Tree, fn func(v *values, self int64)) {
+		t.IterateStacksFromRoot(func(self int64, stack []string) {
+			key := strings.Join(stack, ";")
+			v, ok := stacks[key]
+			if !ok {
+				v = new(values)
+				stacks[key] = v
+				keys = append(keys, key)
+			}
+			fn(v, self)
+		})
+	}
+	collect(left, func(v *values, self int64) { v.left += self })
+	collect(right, func(v *values, self int64) { v.right += int64(float64(self) * scale) })
+	slices.Sort(keys)
+	for _, k := range keys {
+		v := stacks[k]
+		_, _ = fmt.Fprintf(w, "%s %d %d\n", k, v.left, v.right)
+	}
+}
+
+type regression struct {
+	name        string
+	left, right int64
+}
+
+func (r regression) delta() int64 { return r.right - r.left }
+
+// computeRegressions compares the self values of the functions of both
+// trees, the largest increase first. The right values are multiplied by
+// scale.
+func computeRegressions(left, right *model.Tree, scale float64) []regression {
+	byName := make(map[string]*regression)
+	get := func(name string) *regression {
+		r, ok := byName[name]
+		if !ok {
+			r = &regression{name: name}
+			byName[name] = r
+		}
+		return r
+	}
+	for _, s := range functionStats(left) {
+		get(s.name).left = s.self
+	}
+	for _, s := range functionStats(right) {
+		get(s.name).right = int64(float64(s.self) * scale)
+	}
+	res := make([]regression, 0, len(byName))
+	for _, r := range byName {
+		res = append(res, *r)
+	}
+	slices.SortFunc(res, func(a, b regression) int {
+		if c := cmp.Compare(b.delta(), a.delta()); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.name, b.name)
+	})
+	return res
+}
+
+func writeTopRegressions(w io.Writer, regressions []regression, n int) {
+	if len(regressions) > n {
+		regressions = regressions[:n]
+	}
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Function", "Baseline", "Compared", "Delta", "Change"})
+	for _, r := range regressions {
+		table.Append([]string{
+			r.name,
+			strconv.FormatInt(r.left, 10),
+			strconv.FormatInt(r.right, 10),
+			fmt.Sprintf("%+d", r.delta()),
+			change(r.left, r.right),
+		})
+	}
+	table.Render()
+}
+
+func change(left, right int64) string {
+	if left == 0 {
+		if right == 0 {
+			return "0.00%"
+		}
+		return "new"
+	}
+	return fmt.Sprintf("%+.2f%%", float64(right-left)*100/float64(left))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/grafana/pyroscope/pkg/util/apitoken"
+)
+
+type tokenGenerateParams struct {
+	name   string
+	tenant string
+	scopes []string
+}
+
+func addTokenGenerateParams(cmd commander) *tokenGenerateParams {
+	params := &tokenGenerateParams{}
+	cmd.Flag("name", "Name of the token, e.g., the agent or the team using it.").Required().StringVar(&params.name)
+	cmd.Flag("tenant", "The tenant of the token. Empty for single-tenant deployments.").StringVar(&params.tenant)
+	cmd.Flag("scope", "Scope of the token: ingest, query or admin. Can be repeated.").Required().EnumsVar(&params.scopes,
+		string(apitoken.ScopeIngest), string(apitoken.ScopeQuery), string(apitoken.ScopeAdmin))
+	return params
+}
+
+// tokenGenerate prints a new token, and its entry for the tokens file of
+// the server: only the hash of the token is stored there.
+func tokenGenerate(ctx context.Context, params *tokenGenerateParams) error {
+	token, err := apitoken.Generate()
+	if err != nil {
+		return err
+	}
+	entry := apitoken.Token{
+		Name:   params.name,
+		Tenant: params.tenant,
+		SHA256: apitoken.HashToken(token),
+	}
+	for _, s := range params.scopes {
+		entry.Scopes = append(entry.Scopes, apitoken.Scope(s))
+	}
+	b, err := yaml.Marshal([]apitoken.Token{entry})
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(output(ctx), "# Token: %s\n# Add the following entry to the tokens of the API tokens file:\n%s", token, b)
+	return nil
+}
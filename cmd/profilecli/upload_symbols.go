@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/grafana/pyroscope/pkg/experiment/symbolizer"
+)
+
+type uploadSymbolsParams struct {
+	*phlareClient
+	paths   []string
+	buildID string
+}
+
+func addUploadSymbolsParams(cmd commander) *uploadSymbolsParams {
+	params := &uploadSymbolsParams{}
+	params.phlareClient = addPhlareClient(cmd)
+
+	cmd.Arg("path", "Path(s) to ELF file(s) with debug info to upload").Required().ExistingFilesVar(&params.paths)
+	cmd.Flag("build-id", "Build ID of the file. By default, the GNU build ID of the file is used. Only allowed with a single file.").StringVar(&params.buildID)
+	return params
+}
+
+func uploadSymbols(ctx context.Context, params *uploadSymbolsParams) error {
+	if params.buildID != "" && len(params.paths) > 1 {
+		return errors.New("--build-id can only be used with a single file")
+	}
+	for _, path := range params.paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		buildID := params.buildID
+		if buildID == "" {
+			if buildID, err = elfBuildID(data); err != nil {
+				return errors.Wrapf(err, "%s", path)
+			}
+		}
+		if err = params.uploadSymbols(ctx, buildID, data); err != nil {
+			return errors.Wrapf(err, "%s", path)
+		}
+		level.Info(logger).Log("msg", "symbols uploaded", "path", path, "build_id", buildID)
+	}
+	return nil
+}
+
+func elfBuildID(data []byte) (string, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return "", errors.Wrap(err, "parse ELF file")
+	}
+	defer f.Close()
+	buildID, err := symbolizer.BuildIDFromELF(f)
+	if err != nil {
+		return "", err
+	}
+	if buildID == "" {
+		return "", errors.New("the file has no build ID, use --build-id to specify it")
+	}
+	return buildID, nil
+}
+
+func (c *phlareClient) uploadSymbols(ctx context.Context, buildID string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/api/v1/symbols/%s", c.URL, buildID), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("upload failed: %s: %s", res.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
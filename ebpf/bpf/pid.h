@@ -5,7 +5,7 @@
 #include "bpf_helpers.h"
 #include "vmlinux.h"
 
-#define PID_NESTED_NAMESPACES_MAX 4
+#define PID_NESTED_NAMESPACES_MAX 8
 
 static __always_inline void current_pid(uint64_t ns_pid_ino, uint32_t *pid) {
     unsigned int inum;
@@ -25,7 +25,8 @@ static __always_inline void current_pid(uint64_t ns_pid_ino, uint32_t *pid) {
     // match the level with pid ns inode
 #pragma unroll
     for (int i = 0; i < PID_NESTED_NAMESPACES_MAX; i++) {
-        if ((level - i) < 0) {
+        // level is unsigned, level - i would wrap around
+        if (i > level) {
             break;
         }
         inum = BPF_CORE_READ(task, group_leader, thread_pid, numbers[level - i].ns, ns.inum);
@@ -36,4 +37,31 @@ static __always_inline void current_pid(uint64_t ns_pid_ino, uint32_t *pid) {
     }
 }
 
+static __always_inline void current_tid(uint64_t ns_pid_ino, uint32_t *tid) {
+    unsigned int inum;
+
+    // fallback to host tid, if no inode provided
+    if (ns_pid_ino == 0) {
+        uint64_t pid_tgid = bpf_get_current_pid_tgid();
+        *tid = (u32)pid_tgid;
+        return;
+    }
+
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+
+    unsigned int level = BPF_CORE_READ(task, thread_pid, level);
+
+#pragma unroll
+    for (int i = 0; i < PID_NESTED_NAMESPACES_MAX; i++) {
+        if (i > level) {
+            break;
+        }
+        inum = BPF_CORE_READ(task, thread_pid, numbers[level - i].ns, ns.inum);
+        if (inum == ns_pid_ino) {
+            *tid = BPF_CORE_READ(task, thread_pid, numbers[level - i].nr);
+            break;
+        }
+    }
+}
+
 #endif // PYROSCOPE_PID
@@ -43,7 +43,7 @@ int do_perf_event(struct bpf_perf_event_data *ctx) {
                 .type = PROFILING_TYPE_UNKNOWN,
                 .collect_kernel = 0,
                 .collect_user = 0,
-                .padding_ = 0
+                .filter_threads = 0
         };
         if (bpf_map_update_elem(&pids, &tgid, &unknown, BPF_NOEXIST)) {
             bpf_dbg_printk("failed to update pids map. probably concurrent update\n");
@@ -61,12 +61,30 @@ int do_perf_event(struct bpf_perf_event_data *ctx) {
         return 0;
     }
 
+    if (config->filter_threads) {
+        u32 tid = 0;
+        current_tid(global_config.ns_pid_ino, &tid);
+        if (tid == 0 || bpf_map_lookup_elem(&threads, &tid) == NULL) {
+            return 0;
+        }
+    }
+
     if (config->type == PROFILING_TYPE_PYTHON) {
         bpf_tail_call(ctx, &progs, PROG_IDX_PYTHON);
         return 0;
     }
 
-    if (config->type == PROFILING_TYPE_FRAMEPOINTERS) {
+    if (config->type == PROFILING_TYPE_RUBY) {
+        u32 tid = 0;
+        current_tid(global_config.ns_pid_ino, &tid);
+        if (tid == tgid) {
+            bpf_tail_call(ctx, &progs, PROG_IDX_RUBY);
+        }
+        // the other threads are unwound with the frame pointers, as well as
+        // the main thread if rbperf is not loaded
+    }
+
+    if (config->type == PROFILING_TYPE_FRAMEPOINTERS || config->type == PROFILING_TYPE_RUBY) {
         key.pid = tgid;
         key.kern_stack = -1;
         key.user_stack = -1;
@@ -74,6 +92,17 @@ int do_perf_event(struct bpf_perf_event_data *ctx) {
         if (config->collect_kernel) {
             key.kern_stack = bpf_get_stackid(ctx, &stacks, KERN_STACKID_FLAGS);
         }
+        if (config->collect_user && bpf_map_lookup_elem(&unwind_pids, &tgid) != NULL) {
+            u32 zero = 0;
+            struct unwind_state *state = bpf_map_lookup_elem(&unwind_state, &zero);
+            if (state != NULL) {
+                state->key = key;
+                state->ip = 0;
+                state->depth = 0;
+                bpf_tail_call(ctx, &progs, PROG_IDX_DWARF);
+            }
+            // the frame pointers are used if the DWARF unwinder is not loaded
+        }
         if (config->collect_user) {
             key.user_stack = bpf_get_stackid(ctx, &stacks, USER_STACKID_FLAGS);
         }
@@ -83,11 +112,490 @@ int do_perf_event(struct bpf_perf_event_data *ctx) {
             (*val)++;
         else
             bpf_map_update_elem(&counts, &key, &one, BPF_NOEXIST);
+
+        u32 zero = 0;
+        u32 *raw = bpf_map_lookup_elem(&raw_samples_enabled, &zero);
+        if (raw && *raw) {
+            struct raw_sample sample = {
+                    .ts = bpf_ktime_get_ns(),
+                    .pid = tgid,
+                    .cpu = bpf_get_smp_processor_id(),
+                    .kern_stack = key.kern_stack,
+                    .user_stack = key.user_stack,
+            };
+            current_tid(global_config.ns_pid_ino, &sample.tid);
+            bpf_perf_event_output(ctx, &raw_samples, BPF_F_CURRENT_CPU, &sample, sizeof(sample));
+        }
     }
     return 0;
 }
 
 
+#if defined(__TARGET_ARCH_x86)
+
+#define THREAD_SIZE (4096 << 2)
+
+// unwind_user_regs reads the user registers the unwinding starts from: the
+// registers of the sample if it is taken in user mode, the registers saved
+// at the top of the kernel stack of the task otherwise.
+static __always_inline int unwind_user_regs(struct bpf_perf_event_data *ctx, struct unwind_state *state) {
+    if ((ctx->regs.cs & 3) == 3) {
+        state->ip = ctx->regs.ip;
+        state->sp = ctx->regs.sp;
+        state->bp = ctx->regs.bp;
+        return 0;
+    }
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    void *stack = NULL;
+    if (pyro_bpf_core_read(&stack, sizeof(stack), &task->stack) || stack == NULL) {
+        return -1;
+    }
+    struct pt_regs *regs = (struct pt_regs *) (stack + THREAD_SIZE) - 1;
+    struct pt_regs user = {};
+    if (bpf_probe_read_kernel(&user, sizeof(user), regs)) {
+        return -1;
+    }
+    state->ip = user.ip;
+    state->sp = user.sp;
+    state->bp = user.bp;
+    return state->ip == 0 ? -1 : 0;
+}
+
+static __always_inline struct unwind_mapping *unwind_find_mapping(struct unwind_pid *info, u64 pc) {
+    for (int i = 0; i < MAX_UNWIND_MAPPINGS; i++) {
+        if (i >= info->count) {
+            break;
+        }
+        struct unwind_mapping *m = &info->mappings[i];
+        if (pc >= m->start && pc < m->end) {
+            return m;
+        }
+    }
+    return NULL;
+}
+
+// unwind_find_row returns the last row of the table of the mapping at or
+// before the pc.
+static __always_inline struct unwind_row *unwind_find_row(struct unwind_mapping *m, u32 pc) {
+    u32 lo = m->rows_start;
+    u32 hi = m->rows_start + m->rows_count;
+    u32 found = hi;
+    for (int i = 0; i < UNWIND_SEARCH_STEPS; i++) {
+        if (lo >= hi) {
+            break;
+        }
+        u32 mid = lo + (hi - lo) / 2;
+        struct unwind_row *row = bpf_map_lookup_elem(&unwind_rows, &mid);
+        if (row == NULL) {
+            return NULL;
+        }
+        if (row->pc <= pc) {
+            found = mid;
+            lo = mid + 1;
+        } else {
+            hi = mid;
+        }
+    }
+    if (found == m->rows_start + m->rows_count) {
+        return NULL;
+    }
+    return bpf_map_lookup_elem(&unwind_rows, &found);
+}
+
+#define UNWIND_NEXT 0
+#define UNWIND_DONE 1
+#define UNWIND_ERROR 2
+
+// unwind_step finds the caller of the frame at state->ip, with the unwind
+// table of the binary if there is one, with the frame pointers otherwise.
+static __always_inline int unwind_step(struct unwind_state *state, struct unwind_pid *info, int leaf) {
+    // the return addresses point after the call, which may be the first
+    // instruction of another function
+    u64 pc = leaf ? state->ip : state->ip - 1;
+    struct unwind_mapping *m = unwind_find_mapping(info, pc);
+    struct unwind_row *row = NULL;
+    if (m != NULL) {
+        row = unwind_find_row(m, (u32) (pc - m->bias));
+    }
+    if (row == NULL || row->cfa_type == UNWIND_CFA_END) {
+        if (state->bp == 0) {
+            return UNWIND_DONE;
+        }
+        u64 frame[2];
+        if (bpf_probe_read_user(frame, sizeof(frame), (void *) state->bp)) {
+            return UNWIND_ERROR;
+        }
+        state->sp = state->bp + sizeof(frame);
+        state->bp = frame[0];
+        state->ip = frame[1];
+        return state->ip == 0 ? UNWIND_DONE : UNWIND_NEXT;
+    }
+    if (row->cfa_type == UNWIND_CFA_LAST_FRAME) {
+        return UNWIND_DONE;
+    }
+    if (row->cfa_type != UNWIND_CFA_RSP && row->cfa_type != UNWIND_CFA_RBP) {
+        return UNWIND_ERROR;
+    }
+    u64 cfa = (row->cfa_type == UNWIND_CFA_RSP ? state->sp : state->bp) + row->cfa_offset;
+    u64 ra = 0;
+    if (bpf_probe_read_user(&ra, sizeof(ra), (void *) (cfa - 8))) {
+        return UNWIND_ERROR;
+    }
+    if (row->rbp_offset != 0) {
+        u64 bp = 0;
+        if (bpf_probe_read_user(&bp, sizeof(bp), (void *) (cfa + (s64) row->rbp_offset * 8))) {
+            return UNWIND_ERROR;
+        }
+        state->bp = bp;
+    }
+    state->sp = cfa;
+    state->ip = ra;
+    return ra == 0 ? UNWIND_DONE : UNWIND_NEXT;
+}
+
+// unwind_submit stores the stack unwound and counts the sample.
+static __always_inline void unwind_submit(struct unwind_state *state) {
+    u64 h = 0xcbf29ce484222325ULL;
+    for (int i = 0; i < PERF_MAX_STACK_DEPTH; i++) {
+        if (i >= state->depth) {
+            state->stack[i] = 0;
+            continue;
+        }
+        h = (h ^ state->stack[i]) * 0x100000001b3ULL;
+    }
+    // the stack ids are positive
+    u32 id = (u32) (h ^ (h >> 32)) & 0x7fffffff;
+    if (bpf_map_update_elem(&dwarf_stacks, &id, &state->stack, BPF_ANY)) {
+        return;
+    }
+    state->key.user_stack = id;
+    state->key.flags |= SAMPLE_KEY_FLAG_DWARF_STACK;
+    u32 *val = bpf_map_lookup_elem(&counts, &state->key);
+    if (val) {
+        (*val)++;
+    } else {
+        u32 one = 1;
+        bpf_map_update_elem(&counts, &state->key, &one, BPF_NOEXIST);
+    }
+}
+
+// dwarf_unwind is tail called by do_perf_event for the processes with unwind
+// tables, it unwinds UNWIND_FRAMES_PER_CALL frames and tail calls itself for
+// the next ones.
+SEC("perf_event")
+int dwarf_unwind(struct bpf_perf_event_data *ctx) {
+    u32 zero = 0;
+    struct unwind_state *state = bpf_map_lookup_elem(&unwind_state, &zero);
+    if (state == NULL) {
+        return 0;
+    }
+    if (state->depth == 0 && state->ip == 0) {
+        if (unwind_user_regs(ctx, state)) {
+            return 0;
+        }
+    }
+    struct unwind_pid *info = bpf_map_lookup_elem(&unwind_pids, &state->key.pid);
+    if (info == NULL) {
+        return 0;
+    }
+    for (int i = 0; i < UNWIND_FRAMES_PER_CALL; i++) {
+        u32 depth = state->depth;
+        if (depth >= PERF_MAX_STACK_DEPTH) {
+            state->key.flags |= SAMPLE_KEY_FLAG_STACK_TRUNCATED;
+            unwind_submit(state);
+            return 0;
+        }
+        state->stack[depth] = state->ip;
+        state->depth = depth + 1;
+        int res = unwind_step(state, info, depth == 0);
+        if (res != UNWIND_NEXT) {
+            if (res == UNWIND_ERROR) {
+                state->key.flags |= SAMPLE_KEY_FLAG_STACK_TRUNCATED;
+            }
+            unwind_submit(state);
+            return 0;
+        }
+    }
+    bpf_tail_call(ctx, &progs, PROG_IDX_DWARF);
+    // the tail calls limit is reached
+    state->key.flags |= SAMPLE_KEY_FLAG_STACK_TRUNCATED;
+    unwind_submit(state);
+    return 0;
+}
+
+#endif
+
+static __always_inline rb_sample_state_t *rb_get_state() {
+    u32 zero = 0;
+    return bpf_map_lookup_elem(&rb_state_heap, &zero);
+}
+
+// rb_current_ec reads the execution context of the main thread of the VM.
+static __always_inline int rb_current_ec(rb_pid_data *pid_data, void **ec) {
+    rb_offset_config *offsets = &pid_data->offsets;
+    void *ptr = NULL;
+    if (bpf_probe_read_user(&ptr, sizeof(ptr), (void *) pid_data->current) || ptr == NULL) {
+        return -1;
+    }
+    if (offsets->vm_main_thread == -1) {
+        *ec = ptr;
+        return 0;
+    }
+    if (bpf_probe_read_user(&ptr, sizeof(ptr), ptr + offsets->vm_main_thread) || ptr == NULL) {
+        return -1;
+    }
+    if (bpf_probe_read_user(&ptr, sizeof(ptr), ptr + offsets->thread_ec) || ptr == NULL) {
+        return -1;
+    }
+    *ec = ptr;
+    return 0;
+}
+
+// rb_read_str reads the chars of a String, the embedded ones or the ones on
+// the heap.
+static __always_inline int rb_read_str(void *str, rb_offset_config *offsets, char *buf, u32 size) {
+    u64 flags = 0;
+    if (bpf_probe_read_user(&flags, sizeof(flags), str)) {
+        return -1;
+    }
+    if ((flags & RUBY_T_MASK) != RUBY_T_STRING) {
+        return -1;
+    }
+    void *ptr = str + offsets->rstring_embed;
+    if (flags & RUBY_FL_USER1) { // RSTRING_NOEMBED
+        if (bpf_probe_read_user(&ptr, sizeof(ptr), str + offsets->rstring_heap_ptr)) {
+            return -1;
+        }
+    }
+    if (bpf_probe_read_user_str(buf, size, ptr) < 0) {
+        return -1;
+    }
+    return 0;
+}
+
+// rb_read_path reads the path of a pathobj, a String or an Array of the
+// path and the realpath.
+static __always_inline int rb_read_path(void *pathobj, rb_offset_config *offsets, char *buf, u32 size) {
+    u64 flags = 0;
+    if (bpf_probe_read_user(&flags, sizeof(flags), pathobj)) {
+        return -1;
+    }
+    if ((flags & RUBY_T_MASK) == RUBY_T_ARRAY) {
+        void *items = pathobj + offsets->rarray_embed;
+        if (!(flags & RUBY_FL_USER1)) { // RARRAY_EMBED_FLAG
+            if (bpf_probe_read_user(&items, sizeof(items), pathobj + offsets->rarray_heap_ptr)) {
+                return -1;
+            }
+        }
+        if (bpf_probe_read_user(&pathobj, sizeof(pathobj), items)) {
+            return -1;
+        }
+    }
+    return rb_read_str(pathobj, offsets, buf, size);
+}
+
+// rb_read_frame reads the symbol of the control frame at state->cfp and
+// moves state->cfp to the caller. It returns 1 if the frame is a ruby one,
+// 0 if it is a native one, -1 on error.
+static __always_inline int rb_read_frame(rb_sample_state_t *state, rb_symbol *sym) {
+    rb_offset_config *offsets = &state->offsets;
+    void *cfp = (void *) state->cfp;
+    state->cfp += offsets->cfp_size;
+
+    u64 pc = 0;
+    void *iseq = NULL;
+    if (bpf_probe_read_user(&pc, sizeof(pc), cfp + offsets->cfp_pc)) {
+        return -1;
+    }
+    if (bpf_probe_read_user(&iseq, sizeof(iseq), cfp + offsets->cfp_iseq)) {
+        return -1;
+    }
+    if (pc == 0 || iseq == NULL) {
+        return 0;
+    }
+    u64 flags = 0;
+    if (bpf_probe_read_user(&flags, sizeof(flags), iseq)) {
+        return -1;
+    }
+    if ((flags & RUBY_T_MASK) != RUBY_T_IMEMO ||
+        ((flags >> RUBY_FL_USHIFT) & RUBY_IMEMO_MASK) != RUBY_IMEMO_ISEQ) {
+        return 0; // an ifunc of a block implemented in C
+    }
+    void *body = NULL;
+    if (bpf_probe_read_user(&body, sizeof(body), iseq + offsets->iseq_body) || body == NULL) {
+        return -1;
+    }
+    void *location = body + offsets->body_location;
+
+    // the symbol is reused, clear the left-overs of the previous one for the
+    // deduplication
+    __builtin_memset(sym, 0, sizeof(*sym));
+
+    void *ptr = NULL;
+    if (bpf_probe_read_user(&ptr, sizeof(ptr), location + offsets->location_label)) {
+        return -1;
+    }
+    if (rb_read_str(ptr, offsets, sym->label, sizeof(sym->label))) {
+        return -1;
+    }
+    if (bpf_probe_read_user(&ptr, sizeof(ptr), location + offsets->location_pathobj)) {
+        return -1;
+    }
+    if (rb_read_path(ptr, offsets, sym->path, sizeof(sym->path))) {
+        return -1;
+    }
+    u64 lineno = 0;
+    if (bpf_probe_read_user(&lineno, sizeof(lineno), location + offsets->location_first_lineno)) {
+        return -1;
+    }
+    if (offsets->first_lineno_fixnum) {
+        sym->lineno = (uint32_t) (lineno >> 1);
+    } else {
+        sym->lineno = (uint32_t) lineno;
+    }
+    return 1;
+}
+
+// rb_get_symbol_id is get_symbol_id of pyperf, the ids are unique per cpu.
+static __always_inline int rb_get_symbol_id(rb_sample_state_t *state, rb_symbol *sym, rb_symbol_id *out) {
+    rb_symbol_id *id = bpf_map_lookup_elem(&rb_symbols, sym);
+    if (id) {
+        *out = *id;
+        return 0;
+    }
+    state->symbol_counter++;
+    rb_symbol_id new_id = state->symbol_counter * 512 + state->cur_cpu;
+    if (bpf_map_update_elem(&rb_symbols, sym, &new_id, BPF_NOEXIST) == 0) {
+        *out = new_id;
+        return 0;
+    }
+    id = bpf_map_lookup_elem(&rb_symbols, sym);
+    if (id) {
+        *out = *id;
+        return 0;
+    }
+    return -1;
+}
+
+static __always_inline int rb_submit_sample(rb_sample_state_t *state) {
+    rb_event *event = &state->event;
+    if (event->stack_len < RUBY_STACK_MAX_LEN) {
+        event->stack[event->stack_len] = 0;
+    }
+    u64 h = MurmurHash64A(&event->stack, event->stack_len * sizeof(event->stack[0]), 0);
+    event->k.user_stack = h;
+    if (bpf_map_update_elem(&ruby_stacks, &h, &event->stack, BPF_ANY)) {
+        return -1;
+    }
+    u32 *val = bpf_map_lookup_elem(&counts, &event->k);
+    if (val) {
+        (*val)++;
+    } else {
+        u32 one = 1;
+        bpf_map_update_elem(&counts, &event->k, &one, BPF_NOEXIST);
+    }
+    return 0;
+}
+
+// rbperf_collect is tail called by do_perf_event for the main thread of the
+// ruby processes, it finds the top control frame and tail calls
+// read_ruby_stack.
+SEC("perf_event")
+int rbperf_collect(struct bpf_perf_event_data *ctx) {
+    u32 pid = 0;
+    current_pid(global_config.ns_pid_ino, &pid);
+    if (pid == 0) {
+        return 0;
+    }
+    rb_pid_data *pid_data = bpf_map_lookup_elem(&rb_pid_config, &pid);
+    if (pid_data == NULL) {
+        return 0;
+    }
+    rb_sample_state_t *state = rb_get_state();
+    if (state == NULL) {
+        return 0;
+    }
+    state->offsets = pid_data->offsets;
+    state->cur_cpu = bpf_get_smp_processor_id();
+    state->ruby_stack_prog_call_cnt = 0;
+
+    rb_event *event = &state->event;
+    event->stack_len = 0;
+    event->k.pid = pid;
+    event->k.flags = 0;
+    if (pid_data->collect_kernel) {
+        event->k.kern_stack = bpf_get_stackid(ctx, &stacks, KERN_STACKID_FLAGS);
+    } else {
+        event->k.kern_stack = -1;
+    }
+
+    void *ec = NULL;
+    if (rb_current_ec(pid_data, &ec)) {
+        return 0;
+    }
+    rb_offset_config *offsets = &state->offsets;
+    u64 vm_stack = 0, vm_stack_size = 0;
+    if (bpf_probe_read_user(&state->cfp, sizeof(state->cfp), ec + offsets->ec_cfp)) {
+        return 0;
+    }
+    if (bpf_probe_read_user(&vm_stack, sizeof(vm_stack), ec + offsets->ec_vm_stack)) {
+        return 0;
+    }
+    if (bpf_probe_read_user(&vm_stack_size, sizeof(vm_stack_size), ec + offsets->ec_vm_stack_size)) {
+        return 0;
+    }
+    // the control frames grow down from the end of the vm stack
+    state->end_cfp = vm_stack + vm_stack_size * sizeof(u64);
+    bpf_tail_call(ctx, &progs, PROG_IDX_RUBY_STACK);
+    return 0;
+}
+
+// read_ruby_stack reads RUBY_STACK_FRAMES_PER_PROG control frames, from the
+// top one, and tail calls itself for the next ones.
+SEC("perf_event")
+int read_ruby_stack(struct bpf_perf_event_data *ctx) {
+    rb_sample_state_t *state = rb_get_state();
+    if (state == NULL) {
+        return 0;
+    }
+    state->ruby_stack_prog_call_cnt++;
+    rb_event *event = &state->event;
+    rb_symbol *sym = &state->sym;
+    int done = 0;
+    for (int i = 0; i < RUBY_STACK_FRAMES_PER_PROG; i++) {
+        if (state->cfp >= state->end_cfp) {
+            done = 1;
+            break;
+        }
+        int res = rb_read_frame(state, sym);
+        if (res < 0) {
+            return 0;
+        }
+        if (res == 0) {
+            continue;
+        }
+        rb_symbol_id id = 0;
+        if (rb_get_symbol_id(state, sym, &id)) {
+            return 0;
+        }
+        u32 len = event->stack_len;
+        if (len < RUBY_STACK_MAX_LEN) {
+            event->stack[len] = id;
+            event->stack_len = len + 1;
+        }
+    }
+    event->k.flags = SAMPLE_KEY_FLAG_RUBY_STACK;
+    if (!done) {
+        event->k.flags |= SAMPLE_KEY_FLAG_STACK_TRUNCATED;
+        if (state->ruby_stack_prog_call_cnt < RUBY_STACK_PROG_CNT) {
+            bpf_tail_call(ctx, &progs, PROG_IDX_RUBY_STACK);
+        }
+    }
+    rb_submit_sample(state);
+    return 0;
+}
+
+
 SEC("kprobe/disassociate_ctty")
 int BPF_KPROBE(disassociate_ctty, int on_exit) {
     if (!on_exit) {
@@ -123,4 +631,310 @@ int BPF_KPROBE(exec, void *_) {
     return 0;
 }
 
+// sched_process_exec fires once the new image is loaded, unlike the execve
+// kprobes, so the process info is read from the new executable.
+SEC("tracepoint/sched/sched_process_exec")
+int sched_process_exec(void *ctx) {
+    u32 pid = 0;
+    current_pid(global_config.ns_pid_ino, &pid);
+    if (pid == 0) {
+        return 0;
+    }
+    struct pid_event event = {
+            .op  = OP_REQUEST_EXEC_PROCESS_INFO,
+            .pid = pid
+    };
+    bpf_perf_event_output(ctx, &events, BPF_F_CURRENT_CPU, &event, sizeof(event));
+    return 0;
+}
+
+struct throttle_start {
+    uint64_t ts;
+    struct sample_key key;
+};
+
+// the stack running when a cfs_rq ran out of its CPU quota, by cfs_rq
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, u64);
+    __type(value, struct throttle_start);
+    __uint(max_entries, PROFILE_MAPS_SIZE);
+} throttle_starts SEC(".maps");
+
+// throttled nanoseconds by the stack running when the throttling began
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, struct sample_key);
+    __type(value, u64);
+    __uint(max_entries, PROFILE_MAPS_SIZE);
+} throttled_time SEC(".maps");
+
+SEC("kprobe/throttle_cfs_rq")
+int BPF_KPROBE(throttle_cfs_rq, void *cfs_rq) {
+    u32 tgid = 0;
+    current_pid(global_config.ns_pid_ino, &tgid);
+    if (tgid == 0) {
+        return 0;
+    }
+    struct pid_config *config = bpf_map_lookup_elem(&pids, &tgid);
+    if (config == NULL || config->type == PROFILING_TYPE_ERROR || config->type == PROFILING_TYPE_UNKNOWN) {
+        return 0;
+    }
+    struct throttle_start start = {};
+    start.ts = bpf_ktime_get_ns();
+    start.key.pid = tgid;
+    start.key.kern_stack = -1;
+    start.key.user_stack = -1;
+    if (config->collect_kernel) {
+        start.key.kern_stack = bpf_get_stackid(ctx, &stacks, KERN_STACKID_FLAGS);
+    }
+    if (config->collect_user) {
+        start.key.user_stack = bpf_get_stackid(ctx, &stacks, USER_STACKID_FLAGS);
+    }
+    u64 k = (u64) cfs_rq;
+    bpf_map_update_elem(&throttle_starts, &k, &start, BPF_ANY);
+    return 0;
+}
+
+SEC("kprobe/unthrottle_cfs_rq")
+int BPF_KPROBE(unthrottle_cfs_rq, void *cfs_rq) {
+    u64 k = (u64) cfs_rq;
+    struct throttle_start *start = bpf_map_lookup_elem(&throttle_starts, &k);
+    if (start == NULL) {
+        return 0;
+    }
+    u64 delta = bpf_ktime_get_ns() - start->ts;
+    u64 *val = bpf_map_lookup_elem(&throttled_time, &start->key);
+    if (val) {
+        __sync_fetch_and_add(val, delta);
+    } else {
+        bpf_map_update_elem(&throttled_time, &start->key, &delta, BPF_NOEXIST);
+    }
+    bpf_map_delete_elem(&throttle_starts, &k);
+    return 0;
+}
+
+// the off-CPU time shorter than the threshold is not recorded, to skip the
+// context switches of the busy threads
+const volatile u64 offcpu_threshold_ns;
+
+// the stack of the threads switched out, by thread id
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, u32);
+    __type(value, struct throttle_start);
+    __uint(max_entries, PROFILE_MAPS_SIZE);
+} offcpu_starts SEC(".maps");
+
+// off-CPU nanoseconds by the stack the threads were switched out in
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, struct sample_key);
+    __type(value, u64);
+    __uint(max_entries, PROFILE_MAPS_SIZE);
+} offcpu_time SEC(".maps");
+
+// sched_switch runs in the context of the previous task: its stack is
+// recorded when it is switched out, and the time it spent off-CPU, blocked
+// or waiting for a CPU, is accounted to the stack when it is switched in.
+SEC("tracepoint/sched/sched_switch")
+int sched_switch(struct trace_event_raw_sched_switch *ctx) {
+    u32 next = (u32) ctx->next_pid;
+    u64 now = bpf_ktime_get_ns();
+    struct throttle_start *start = bpf_map_lookup_elem(&offcpu_starts, &next);
+    if (start != NULL) {
+        u64 delta = now - start->ts;
+        if (delta >= offcpu_threshold_ns) {
+            u64 *val = bpf_map_lookup_elem(&offcpu_time, &start->key);
+            if (val) {
+                __sync_fetch_and_add(val, delta);
+            } else {
+                bpf_map_update_elem(&offcpu_time, &start->key, &delta, BPF_NOEXIST);
+            }
+        }
+        bpf_map_delete_elem(&offcpu_starts, &next);
+    }
+
+    u32 prev = (u32) bpf_get_current_pid_tgid();
+    if (prev == 0) {
+        return 0; // idle
+    }
+    u32 tgid = 0;
+    current_pid(global_config.ns_pid_ino, &tgid);
+    if (tgid == 0) {
+        return 0;
+    }
+    struct pid_config *config = bpf_map_lookup_elem(&pids, &tgid);
+    if (config == NULL || config->type == PROFILING_TYPE_ERROR || config->type == PROFILING_TYPE_UNKNOWN) {
+        return 0;
+    }
+    if (config->filter_threads) {
+        u32 tid = 0;
+        current_tid(global_config.ns_pid_ino, &tid);
+        if (tid == 0 || bpf_map_lookup_elem(&threads, &tid) == NULL) {
+            return 0;
+        }
+    }
+    struct throttle_start switched = {};
+    switched.ts = now;
+    switched.key.pid = tgid;
+    switched.key.kern_stack = -1;
+    switched.key.user_stack = -1;
+    if (config->collect_kernel) {
+        switched.key.kern_stack = bpf_get_stackid(ctx, &stacks, KERN_STACKID_FLAGS);
+    }
+    if (config->collect_user) {
+        switched.key.user_stack = bpf_get_stackid(ctx, &stacks, USER_STACKID_FLAGS);
+    }
+    bpf_map_update_elem(&offcpu_starts, &prev, &switched, BPF_ANY);
+    return 0;
+}
+
+#define MAX_PROBES 64
+
+#define PROBE_VALUE_COUNT 0
+#define PROBE_VALUE_REGISTER 1
+#define PROBE_VALUE_LATENCY 2
+
+// the sample source of a probe, by the probe index, the attach cookie
+struct probe_config {
+    u8 value;
+    u8 collect_stack;
+    // size in bytes of the value read from the register
+    u8 size;
+    u8 _pad;
+    // offset in pt_regs of the register the value is read from
+    u32 regs_offset;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, u32);
+    __type(value, struct probe_config);
+    __uint(max_entries, MAX_PROBES);
+} probe_configs SEC(".maps");
+
+struct probe_sample_key {
+    u32 probe;
+    u32 _pad;
+    struct sample_key key;
+};
+
+// the values of the probes by the stack they were hit in
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, struct probe_sample_key);
+    __type(value, u64);
+    __uint(max_entries, PROFILE_MAPS_SIZE);
+} probe_values SEC(".maps");
+
+struct probe_start_key {
+    u64 pid_tgid;
+    u32 probe;
+    u32 _pad;
+};
+
+struct probe_start {
+    u64 ts;
+    struct probe_sample_key key;
+};
+
+// the entries of the functions the latency is profiled of, by thread
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, struct probe_start_key);
+    __type(value, struct probe_start);
+    __uint(max_entries, PROFILE_MAPS_SIZE);
+} probe_starts SEC(".maps");
+
+// perf_event___cookie checks for the bpf_cookie of the perf events, added
+// along with bpf_get_attach_cookie in 5.15, so the probe programs still load
+// on the older kernels, where they are not attached.
+struct perf_event___cookie {
+    u64 bpf_cookie;
+} __attribute__((preserve_access_index));
+
+static __always_inline int probe_index(struct pt_regs *ctx, u32 *probe) {
+    if (!bpf_core_field_exists(((struct perf_event___cookie *) 0)->bpf_cookie)) {
+        return -1;
+    }
+    *probe = (u32) bpf_get_attach_cookie(ctx);
+    return 0;
+}
+
+static __always_inline void probe_add(struct probe_sample_key *key, u64 value) {
+    u64 *val = bpf_map_lookup_elem(&probe_values, key);
+    if (val) {
+        __sync_fetch_and_add(val, value);
+    } else {
+        bpf_map_update_elem(&probe_values, key, &value, BPF_NOEXIST);
+    }
+}
+
+SEC("uprobe")
+int probe_entry(struct pt_regs *ctx) {
+    u32 tgid = 0;
+    current_pid(global_config.ns_pid_ino, &tgid);
+    if (tgid == 0) {
+        return 0;
+    }
+    struct pid_config *config = bpf_map_lookup_elem(&pids, &tgid);
+    if (config == NULL || config->type == PROFILING_TYPE_ERROR || config->type == PROFILING_TYPE_UNKNOWN) {
+        return 0;
+    }
+    u32 probe = 0;
+    if (probe_index(ctx, &probe)) {
+        return 0;
+    }
+    struct probe_config *pc = bpf_map_lookup_elem(&probe_configs, &probe);
+    if (pc == NULL) {
+        return 0;
+    }
+    struct probe_sample_key key = {};
+    key.probe = probe;
+    key.key.pid = tgid;
+    key.key.kern_stack = -1;
+    key.key.user_stack = -1;
+    if (pc->collect_stack) {
+        if (config->collect_kernel) {
+            key.key.kern_stack = bpf_get_stackid(ctx, &stacks, KERN_STACKID_FLAGS);
+        }
+        if (config->collect_user) {
+            key.key.user_stack = bpf_get_stackid(ctx, &stacks, USER_STACKID_FLAGS);
+        }
+    }
+    if (pc->value == PROBE_VALUE_LATENCY) {
+        struct probe_start_key sk = {.pid_tgid = bpf_get_current_pid_tgid(), .probe = probe};
+        struct probe_start start = {.ts = bpf_ktime_get_ns(), .key = key};
+        bpf_map_update_elem(&probe_starts, &sk, &start, BPF_ANY);
+        return 0;
+    }
+    u64 value = 1;
+    if (pc->value == PROBE_VALUE_REGISTER) {
+        if (bpf_probe_read_kernel(&value, sizeof(value), (void *) ctx + pc->regs_offset)) {
+            return 0;
+        }
+        if (pc->size < 8) {
+            value &= (1ULL << (pc->size * 8)) - 1;
+        }
+    }
+    probe_add(&key, value);
+    return 0;
+}
+
+SEC("uretprobe")
+int probe_return(struct pt_regs *ctx) {
+    struct probe_start_key sk = {.pid_tgid = bpf_get_current_pid_tgid()};
+    if (probe_index(ctx, &sk.probe)) {
+        return 0;
+    }
+    struct probe_start *start = bpf_map_lookup_elem(&probe_starts, &sk);
+    if (start == NULL) {
+        return 0;
+    }
+    probe_add(&start->key, bpf_ktime_get_ns() - start->ts);
+    bpf_map_delete_elem(&probe_starts, &sk);
+    return 0;
+}
+
 char _license[] SEC("license") = "GPL";
@@ -9,12 +9,13 @@
 #define PROFILING_TYPE_FRAMEPOINTERS 2
 #define PROFILING_TYPE_PYTHON 3
 #define PROFILING_TYPE_ERROR 4
+#define PROFILING_TYPE_RUBY 5
 
 struct pid_config {
     uint8_t type;
     uint8_t collect_user;
     uint8_t collect_kernel;
-    uint8_t padding_;
+    uint8_t filter_threads;
 };
 
 #define OP_REQUEST_UNKNOWN_PROCESS_INFO 1
@@ -40,6 +41,15 @@ struct {
 } pids SEC(".maps");
 
 
+// the threads of the pids with filter_threads set, which are profiled
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u32);
+    __type(value, u8);
+    __uint(max_entries, 16384);
+} threads SEC(".maps");
+
+
 struct {
     __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
     __uint(key_size, sizeof(u32));
@@ -49,14 +59,46 @@ struct {
 
 struct {
     __uint(type, BPF_MAP_TYPE_PROG_ARRAY);
-    __uint(max_entries, 1);
+    __uint(max_entries, 4);
     __type(key, int);
     __array(values, int (void *));
 } progs SEC(".maps");
 
 #define PROG_IDX_PYTHON 0
+#define PROG_IDX_DWARF 1
+#define PROG_IDX_RUBY 2
+#define PROG_IDX_RUBY_STACK 3
+
+// a sample of the framepointers profiling, sent to user space as is, when
+// the raw samples are enabled
+struct raw_sample {
+    uint64_t ts;
+    uint32_t pid;
+    uint32_t tid;
+    uint32_t cpu;
+    uint32_t padding_;
+    int64_t kern_stack;
+    int64_t user_stack;
+};
+struct raw_sample rs__;
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+    __uint(key_size, sizeof(u32));
+    __uint(value_size, sizeof(u32));
+} raw_samples SEC(".maps");
+
+// raw_samples_enabled[0] is set by user space to enable the raw samples
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, u32);
+    __type(value, u32);
+    __uint(max_entries, 1);
+} raw_samples_enabled SEC(".maps");
 
 #include "stacks.h"
+#include "unwind.h"
+#include "rbperf.h"
 
 
 
@@ -419,28 +419,35 @@ static __always_inline int get_first_arg_cell(void *frame_ptr, void *code_ptr, p
     return 0;
 }
 
+// unresolved_class_name marks the class name of the method as unresolved,
+// user space emits an explicit marker instead of a wrong class name
+static __always_inline int unresolved_class_name(py_symbol *symbol) {
+    symbol->classname_type.type = PYSTR_TYPE_UNRESOLVED;
+    symbol->classname_type.size_codepoints = 0;
+    return 0;
+}
+
 static __always_inline int
 get_class_name(void *cur_frame, void *code_ptr, py_offset_config *offsets, bool first_self, py_symbol *symbol) {
     void *ptr = NULL, *ptr_ob_type = NULL;
     // Read class name from $frame->f_localsplus[0]->ob_type->tp_name.
     try_read_or_fail(ptr, cur_frame + offsets->VFrame_localsplus)
     if (!ptr) {
+        // before 3.11 the first argument captured by a closure is moved to a
+        // cell, and its slot is cleared
         try_or_fail(get_first_arg_cell(cur_frame, code_ptr, offsets, &ptr))
     }
     if (!ptr) {
         log_debug("first arg NULL");
-        symbol->classname_type.type = PYSTR_TYPE_1BYTE | PYSTR_TYPE_ASCII;
-        symbol->classname_type.size_codepoints = 0;
-        return 0;
+        return unresolved_class_name(symbol);
     }
     try_read_or_fail(ptr_ob_type, ptr + offsets->PyObject_ob_type)
     if (ptr_ob_type == (void *) offsets->PyCell_Type) {
+        // since 3.11 the slot of the first argument holds the cell
         try_read_or_fail(ptr, ptr + offsets->PyCellObject_ob_ref)
         log_debug("ob_ref %Llx", ptr);
         if (!ptr) {
-            symbol->classname_type.type = PYSTR_TYPE_1BYTE | PYSTR_TYPE_ASCII;
-            symbol->classname_type.size_codepoints = 0;
-            return 0;
+            return unresolved_class_name(symbol);
         }
     }
     if (first_self) {
@@ -449,9 +456,11 @@ get_class_name(void *cur_frame, void *code_ptr, py_offset_config *offsets, bool
     }
     try_read_or_fail(ptr_ob_type, ptr + offsets->PyObject_ob_type)
     if (ptr_ob_type != (void*)  offsets->PyType_Type) {
-        symbol->classname_type.type = PYSTR_TYPE_1BYTE | PYSTR_TYPE_ASCII;
-        symbol->classname_type.size_codepoints = 0;
-        return 0;
+        // the type of a class with a metaclass is the metaclass, a type
+        try_read_or_fail(ptr_ob_type, ptr_ob_type + offsets->PyObject_ob_type)
+        if (ptr_ob_type != (void*)  offsets->PyType_Type) {
+            return unresolved_class_name(symbol);
+        }
     }
 
     // https://github.com/python/cpython/blob/d73501602f863a54c872ce103cd3fa119e38bac9/Include/cpython/object.h#L106
@@ -13,6 +13,8 @@
 #define PYSTR_TYPE_ASCII  8
 #define PYSTR_TYPE_UTF8   16
 #define PYSTR_TYPE_NOT_COMPACT  32
+// the class name of a method could not be resolved
+#define PYSTR_TYPE_UNRESOLVED  64
 
 
 struct py_str_type {
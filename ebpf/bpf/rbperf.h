@@ -0,0 +1,117 @@
+#ifndef PYROSCOPE_RBPERF_H
+#define PYROSCOPE_RBPERF_H
+
+#include "hash.h"
+
+// The ruby stacks are read from the control frames of the main thread of the
+// VM, like rbperf does: the frames of the other threads are not reachable
+// without their execution context, their samples are unwound with the frame
+// pointers.
+
+#define RUBY_STACK_FRAMES_PER_PROG 32
+#define RUBY_STACK_PROG_CNT 3
+#define RUBY_STACK_MAX_LEN (RUBY_STACK_FRAMES_PER_PROG * RUBY_STACK_PROG_CNT)
+#define RUBY_LABEL_LEN 64
+#define RUBY_PATH_LEN 128
+
+// the flags of the objects, see include/ruby/internal/value_type.h and
+// include/ruby/internal/fl_type.h
+#define RUBY_T_MASK 0x1f
+#define RUBY_T_STRING 0x05
+#define RUBY_T_ARRAY 0x07
+#define RUBY_T_IMEMO 0x1a
+#define RUBY_FL_USHIFT 12
+#define RUBY_FL_USER1 (1 << (RUBY_FL_USHIFT + 1))
+#define RUBY_IMEMO_MASK 0x0f
+#define RUBY_IMEMO_ISEQ 7
+
+typedef struct {
+    int16_t vm_main_thread; // rb_vm_t.ractor.main_thread, -1 if the ec is read directly
+    int16_t thread_ec;
+    int16_t ec_vm_stack;
+    int16_t ec_vm_stack_size;
+    int16_t ec_cfp;
+    int16_t cfp_pc;
+    int16_t cfp_iseq;
+    int16_t cfp_size; // sizeof(rb_control_frame_t)
+    int16_t iseq_body;
+    int16_t body_location;
+    int16_t location_pathobj;
+    int16_t location_label;
+    int16_t location_first_lineno;
+    int16_t rstring_embed; // RString.as.ary pre 3.2 or RString.as.embed.ary post 3.2
+    int16_t rstring_heap_ptr;
+    int16_t rarray_embed;
+    int16_t rarray_heap_ptr;
+    int16_t padding_;
+    uint8_t first_lineno_fixnum; // first_lineno is a VALUE pre 3.2, an int post 3.2
+    uint8_t padding2_[3];
+} rb_offset_config;
+
+typedef struct {
+    rb_offset_config offsets;
+    // the address of ruby_current_vm_ptr, or of
+    // ruby_current_execution_context_ptr if offsets.vm_main_thread is -1
+    uint64_t current;
+    uint8_t collect_kernel;
+    uint8_t padding_[7];
+} rb_pid_data;
+
+typedef struct {
+    char label[RUBY_LABEL_LEN];
+    char path[RUBY_PATH_LEN];
+    // the first line of the method, the line of the instruction is not
+    // recorded as the positions of the instructions are compressed
+    uint32_t lineno;
+    uint32_t padding_;
+} rb_symbol;
+
+typedef uint32_t rb_symbol_id;
+
+typedef struct {
+    struct sample_key k;
+    uint32_t stack_len;
+    rb_symbol_id stack[RUBY_STACK_MAX_LEN];
+} rb_event;
+
+typedef struct {
+    int64_t symbol_counter;
+    rb_offset_config offsets;
+    uint32_t cur_cpu;
+    uint64_t cfp;
+    uint64_t end_cfp;
+    int64_t ruby_stack_prog_call_cnt;
+    rb_symbol sym;
+    rb_event event;
+    uint64_t padding; // satisfy verifier for hash function
+} rb_sample_state_t;
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(key_size, sizeof(u32));
+    __uint(value_size, RUBY_STACK_MAX_LEN * sizeof(rb_symbol_id));
+    __uint(max_entries, PROFILE_MAPS_SIZE);
+} ruby_stacks SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __type(key, u32);
+    __type(value, rb_sample_state_t);
+    __uint(max_entries, 1);
+} rb_state_heap SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, rb_symbol);
+    __type(value, rb_symbol_id);
+    __uint(max_entries, 16384);
+} rb_symbols SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, pid_t);
+    __type(value, rb_pid_data);
+    __uint(max_entries, 10240);
+} rb_pid_config SEC(".maps");
+
+#endif // PYROSCOPE_RBPERF_H
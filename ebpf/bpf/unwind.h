@@ -0,0 +1,93 @@
+#ifndef PYROSCOPE_UNWIND_H
+#define PYROSCOPE_UNWIND_H
+
+// The DWARF unwinding of the user stacks of the binaries compiled without
+// frame pointers. User space loads the .eh_frame unwind tables of the
+// binaries into unwind_rows, and registers the mappings of the binaries of a
+// process in unwind_pids. The addresses outside of those mappings are
+// unwound with the frame pointers.
+
+#define UNWIND_CFA_END 0
+#define UNWIND_CFA_RSP 1
+#define UNWIND_CFA_RBP 2
+#define UNWIND_CFA_UNSUPPORTED 3
+#define UNWIND_CFA_LAST_FRAME 4
+
+#define MAX_UNWIND_MAPPINGS 64
+// the binary search steps, enough for 2^21 rows
+#define UNWIND_SEARCH_STEPS 21
+// the frames unwound by a run of the program, which tail calls itself for
+// the next ones, to keep the verifier complexity low
+#define UNWIND_FRAMES_PER_CALL 16
+
+// a row of the unwind table of a binary, valid from pc to the pc of the next
+// row
+struct unwind_row {
+    // virtual address in the binary
+    u32 pc;
+    s16 cfa_offset;
+    u8 cfa_type;
+    // offset from the cfa of the saved rbp, in 8 bytes words, 0 if rbp is
+    // not changed
+    s8 rbp_offset;
+};
+
+struct unwind_mapping {
+    u64 start;
+    u64 end;
+    // bias is subtracted from the addresses of the mapping to get the virtual
+    // addresses of the binary
+    u64 bias;
+    u32 rows_start;
+    u32 rows_count;
+};
+
+struct unwind_pid {
+    u32 count;
+    u32 _pad;
+    struct unwind_mapping mappings[MAX_UNWIND_MAPPINGS];
+};
+
+struct unwind_state {
+    u64 ip;
+    u64 sp;
+    u64 bp;
+    u32 depth;
+    u32 _pad;
+    struct sample_key key;
+    u64 stack[PERF_MAX_STACK_DEPTH];
+};
+
+// the rows of the unwind tables of all the binaries, resized by user space
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, u32);
+    __type(value, struct unwind_row);
+    __uint(max_entries, 1);
+} unwind_rows SEC(".maps");
+
+// the mappings with unwind tables of the processes, resized by user space
+// when the DWARF unwinding is enabled
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u32);
+    __type(value, struct unwind_pid);
+    __uint(max_entries, 1);
+} unwind_pids SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __type(key, u32);
+    __type(value, struct unwind_state);
+    __uint(max_entries, 1);
+} unwind_state SEC(".maps");
+
+// the user stacks unwound with the tables, by the hash of the stack
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(key_size, sizeof(u32));
+    __uint(value_size, PERF_MAX_STACK_DEPTH * sizeof(u64));
+    __uint(max_entries, PROFILE_MAPS_SIZE);
+} dwarf_stacks SEC(".maps");
+
+#endif // PYROSCOPE_UNWIND_H
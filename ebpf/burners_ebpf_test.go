@@ -0,0 +1,110 @@
+package ebpfspy
+
+import (
+	_ "embed"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/pyroscope/ebpf/cpp/demangle"
+	"github.com/grafana/pyroscope/ebpf/metrics"
+	"github.com/grafana/pyroscope/ebpf/sd"
+	"github.com/grafana/pyroscope/ebpf/symtab"
+	"github.com/grafana/pyroscope/ebpf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+//go:embed cpp_ebpf_expected.txt
+var cppEBPFExpected []byte
+
+//go:embed rust_ebpf_expected.txt
+var rustEBPFExpected []byte
+
+//go:embed ruby_ebpf_expected.txt
+var rubyEBPFExpected []byte
+
+//go:embed php_ebpf_expected.txt
+var phpEBPFExpected []byte
+
+// TestEBPFBurners profiles the CPU burner images of the unwinders, see
+// testutil/burners, and checks their profiles against the expected ones.
+func TestEBPFBurners(t *testing.T) {
+	burners := []testutil.Burner{
+		{Name: "cpp", Expected: cppEBPFExpected, Skip: "the expected profile is not generated from the burner yet"},
+		{Name: "rust", Expected: rustEBPFExpected, Skip: "the expected profile is not generated from the burner yet, it needs the regenerated bpf objects"},
+		{Name: "ruby", Expected: rubyEBPFExpected, Skip: "the rbperf programs are missing from the bpf objects, they need to be regenerated"},
+		{Name: "php", Expected: phpEBPFExpected, Skip: "the php stacks are not unwound yet"},
+	}
+
+	l := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	l = log.With(l, "ts", log.DefaultTimestampUTC, "caller", log.Caller(5))
+
+	for _, burner := range burners {
+		t.Run(burner.Name, func(t *testing.T) {
+			if burner.Skip != "" {
+				t.Skip(burner.Skip)
+			}
+			l := log.With(l, "test", t.Name())
+
+			testutil.PullImage(t, l, burner.Image())
+			c := testutil.RunContainer(t, l, burner.Image())
+			defer c.Kill()
+
+			profiler := startBurnerProfiler(t, l, c.ContainerID)
+			defer profiler.Stop()
+
+			// the binaries without frame pointers are unwound with their
+			// unwind tables once they are detected, after a few rounds
+			testutil.RequireStacks(t, l, burner.Expected, 30*time.Second, func(stacks map[string]struct{}) {
+				for stack := range collectProfiles(t, l, profiler) {
+					stacks[stack] = struct{}{}
+				}
+			})
+		})
+	}
+}
+
+func startBurnerProfiler(t *testing.T, l log.Logger, containerID string) Session {
+	l = log.With(l, "component", "ebpf-session")
+	targetFinder, err := sd.NewTargetFinder(os.DirFS("/"), l,
+		sd.TargetsOptions{
+			Targets: []sd.DiscoveryTarget{
+				{
+					"__container_id__": containerID,
+					"service_name":     containerID,
+				},
+			},
+			ContainerCacheSize: 1024,
+			TargetsOnly:        true,
+		})
+	require.NoError(t, err)
+	options := SessionOptions{
+		CollectUser:    true,
+		SampleRate:     97,
+		Metrics:        metrics.New(nil),
+		RubyEnabled:    true,
+		DWARFUnwinding: DWARFUnwindingOptions{Enabled: true},
+		SymbolOptions: symtab.SymbolOptions{
+			DemangleOptions: demangle.DemangleSimplified,
+		},
+		CacheOptions: symtab.CacheOptions{
+			BuildIDCacheOptions: symtab.GCacheOptions{
+				Size: 128, KeepRounds: 128,
+			},
+			SameFileCacheOptions: symtab.GCacheOptions{
+				Size: 128, KeepRounds: 128,
+			},
+			PidCacheOptions: symtab.GCacheOptions{
+				Size: 128, KeepRounds: 128,
+			},
+		},
+	}
+	s, err := NewSession(l, targetFinder, options)
+	require.NoError(t, err)
+
+	err = s.Start()
+	_ = l.Log("err", err, "msg", "session.Start")
+	require.NoError(t, err, "Try running as privileged root user")
+	return s
+}
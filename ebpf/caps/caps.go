@@ -0,0 +1,178 @@
+//go:build linux
+
+// Package caps drops the capabilities of the profiler once the BPF objects
+// are loaded and attached, keeping only the ones needed to read the maps and
+// the processes, to shrink the attack surface of the profiler.
+package caps
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+type Capability uint
+
+// The capabilities needed by the profiler, see capabilities(7).
+const (
+	CapDACReadSearch Capability = 2
+	CapSetPCap       Capability = 8
+	CapSysPtrace     Capability = 19
+	CapSysAdmin      Capability = 21
+	CapSysResource   Capability = 24
+	CapPerfmon       Capability = 38
+	CapBPF           Capability = 39
+)
+
+var names = map[Capability]string{
+	CapDACReadSearch: "cap_dac_read_search",
+	CapSetPCap:       "cap_setpcap",
+	CapSysPtrace:     "cap_sys_ptrace",
+	CapSysAdmin:      "cap_sys_admin",
+	CapSysResource:   "cap_sys_resource",
+	CapPerfmon:       "cap_perfmon",
+	CapBPF:           "cap_bpf",
+}
+
+func (c Capability) String() string {
+	if n, ok := names[c]; ok {
+		return n
+	}
+	return "cap_" + strconv.Itoa(int(c))
+}
+
+// Set is a set of capabilities, the bit n is the capability n.
+type Set uint64
+
+func NewSet(caps ...Capability) Set {
+	var s Set
+	for _, c := range caps {
+		s |= 1 << c
+	}
+	return s
+}
+
+func (s Set) Has(c Capability) bool { return s&(1<<c) != 0 }
+
+func (s Set) String() string {
+	var res []string
+	for c := Capability(0); c < 64; c++ {
+		if s.Has(c) {
+			res = append(res, c.String())
+		}
+	}
+	return strings.Join(res, ",")
+}
+
+// ErrCgo is returned when the capabilities of all the threads can not be
+// changed at once, which the runtime does not support in the cgo binaries.
+var ErrCgo = errors.New("dropping the capabilities of all the threads is not supported in the binaries built with cgo")
+
+const capLastCapPath = "/proc/sys/kernel/cap_last_cap"
+
+// LastCap returns the highest capability known to the kernel.
+func LastCap() (Capability, error) {
+	data, err := os.ReadFile(capLastCapPath)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", capLastCapPath, err)
+	}
+	return Capability(n), nil
+}
+
+// Profiling returns the capabilities the profiler needs once the programs
+// are loaded and attached:
+//   - cap_bpf and cap_perfmon to read and update the maps and to load the
+//     programs loaded lazily, such as the python one. The kernels before 5.8
+//     have no cap_bpf, cap_sys_admin is kept instead;
+//   - cap_sys_ptrace to read the /proc of the processes, their memory maps,
+//     executables and root filesystems;
+//   - cap_dac_read_search to read the binaries of the processes.
+func Profiling(lastCap Capability) Set {
+	s := NewSet(CapSysPtrace, CapDACReadSearch)
+	if lastCap < CapBPF {
+		return s | NewSet(CapSysAdmin)
+	}
+	return s | NewSet(CapBPF, CapPerfmon)
+}
+
+// Effective returns the effective capabilities of the calling thread.
+func Effective() (Set, error) {
+	data, err := capget()
+	if err != nil {
+		return 0, err
+	}
+	return Set(data[0].Effective) | Set(data[1].Effective)<<32, nil
+}
+
+// Drop reduces the capabilities of the process to the keep set, in all its
+// threads: the permitted and effective sets are reduced, the inheritable and
+// ambient sets are cleared, and the bounding set is reduced if the process
+// has cap_setpcap. The capabilities can not be regained afterwards, so the
+// BPF objects must be loaded and attached before.
+func Drop(keep Set) error {
+	lastCap, err := LastCap()
+	if err != nil {
+		return err
+	}
+	current, err := capget()
+	if err != nil {
+		return err
+	}
+	// The bounding set is reduced first, cap_setpcap is dropped with the
+	// others below.
+	if Set(current[0].Effective).Has(CapSetPCap) {
+		for c := Capability(0); c <= lastCap; c++ {
+			if keep.Has(c) {
+				continue
+			}
+			if err = unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil && !errors.Is(err, unix.EINVAL) {
+				return fmt.Errorf("drop %s from the bounding set: %w", c, err)
+			}
+		}
+	}
+	if err = unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil && !errors.Is(err, unix.EINVAL) {
+		return fmt.Errorf("clear the ambient capabilities: %w", err)
+	}
+	data := reduce(current, keep)
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	_, _, errno := syscall.AllThreadsSyscall(unix.SYS_CAPSET,
+		uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0)
+	switch {
+	case errno == syscall.ENOTSUP:
+		return ErrCgo
+	case errno != 0:
+		return fmt.Errorf("capset: %w", errno)
+	}
+	return nil
+}
+
+// reduce returns the capabilities with the permitted and effective sets
+// reduced to the keep set, and no inheritable capabilities.
+func reduce(current [2]unix.CapUserData, keep Set) [2]unix.CapUserData {
+	var res [2]unix.CapUserData
+	for i := range res {
+		k := uint32(keep >> (32 * i))
+		res[i].Permitted = current[i].Permitted & k
+		res[i].Effective = current[i].Effective & k
+	}
+	return res
+}
+
+func capget() ([2]unix.CapUserData, error) {
+	var data [2]unix.CapUserData
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return data, fmt.Errorf("capget: %w", err)
+	}
+	return data, nil
+}
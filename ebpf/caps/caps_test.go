@@ -0,0 +1,83 @@
+//go:build linux
+
+package caps
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestProfiling(t *testing.T) {
+	require.Equal(t, "cap_dac_read_search,cap_sys_ptrace,cap_perfmon,cap_bpf", Profiling(40).String())
+	// No cap_bpf before 5.8.
+	require.Equal(t, "cap_dac_read_search,cap_sys_ptrace,cap_sys_admin", Profiling(37).String())
+}
+
+func TestReduce(t *testing.T) {
+	all := [2]unix.CapUserData{
+		{Effective: ^uint32(0), Permitted: ^uint32(0), Inheritable: ^uint32(0)},
+		{Effective: 0x1ff, Permitted: 0x1ff, Inheritable: 0x1ff},
+	}
+	res := reduce(all, NewSet(CapSysPtrace, CapBPF))
+	require.Equal(t, uint32(1<<CapSysPtrace), res[0].Effective)
+	require.Equal(t, uint32(1<<CapSysPtrace), res[0].Permitted)
+	require.Equal(t, uint32(1<<(CapBPF-32)), res[1].Effective)
+	require.Equal(t, uint32(1<<(CapBPF-32)), res[1].Permitted)
+	require.Zero(t, res[0].Inheritable)
+	require.Zero(t, res[1].Inheritable)
+
+	// The capabilities not held are not added.
+	res = reduce([2]unix.CapUserData{}, NewSet(CapSysPtrace, CapBPF))
+	require.Equal(t, [2]unix.CapUserData{}, res)
+}
+
+const dropEnv = "CAPS_TEST_DROP"
+
+func TestDrop(t *testing.T) {
+	if os.Getenv(dropEnv) != "" {
+		// The child process, the capabilities of the test process are kept.
+		keep := NewSet(CapSysPtrace, CapDACReadSearch)
+		if err := Drop(keep); err != nil {
+			if errors.Is(err, ErrCgo) {
+				os.Exit(3)
+			}
+			t.Fatal(err)
+		}
+		effective, err := Effective()
+		require.NoError(t, err)
+		require.Equal(t, keep, effective)
+		// /proc of the other processes is still readable.
+		_, err = os.ReadFile("/proc/1/maps")
+		require.NoError(t, err)
+		// The capabilities are not regained.
+		hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+		data := [2]unix.CapUserData{{Effective: ^uint32(0), Permitted: ^uint32(0)}}
+		require.Error(t, unix.Capset(&hdr, &data[0]))
+		return
+	}
+	effective, err := Effective()
+	require.NoError(t, err)
+	if !effective.Has(CapSysAdmin) {
+		t.Skip("the test requires cap_sys_admin")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDrop$", "-test.v")
+	cmd.Env = append(os.Environ(), dropEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 3 {
+		t.Skip(ErrCgo.Error())
+	}
+	require.NoError(t, err, string(out))
+}
+
+func TestCapabilityString(t *testing.T) {
+	require.Equal(t, "cap_bpf", CapBPF.String())
+	require.Equal(t, "cap_"+strconv.Itoa(5), Capability(5).String())
+	require.Equal(t, "", Set(0).String())
+}
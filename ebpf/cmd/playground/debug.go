@@ -0,0 +1,48 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	ebpfspy "github.com/grafana/pyroscope/ebpf"
+)
+
+// serveDebug serves the debug endpoints of the session:
+//
+//	/debug/build-ids lists the binaries seen in the profiled processes, with
+//	their build ids, paths, sizes and symbolization status, as json, or as
+//	the tab separated build id, status and path lines with ?format=text, for
+//	the symbol upload pipelines.
+//
+//	/debug/log-levels lists the log levels of the components, see
+//	serveLogLevels.
+func serveDebug(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/log-levels", serveLogLevels)
+	mux.HandleFunc("/debug/build-ids", func(w http.ResponseWriter, r *http.Request) {
+		info, ok := session.DebugInfo().(ebpfspy.SessionDebugInfo)
+		if !ok {
+			http.Error(w, "unexpected session debug info", http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, e := range info.BuildIDs {
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", e.BuildID, e.Status, strings.Join(e.Paths, ","))
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(info.BuildIDs)
+	})
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			_ = level.Error(logger).Log("msg", "debug server failed", "addr", addr, "err", err)
+		}
+	}()
+}
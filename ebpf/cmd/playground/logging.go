@@ -0,0 +1,162 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+var logFormat = flag.String("log.format", "logfmt",
+	"format of the logs, logfmt or json")
+
+var logLevel = flag.String("log.level", "info",
+	"level of the logs of all the components: debug, info, warn or error, see /debug/log-levels")
+
+// The components of the logs, each with its own level.
+const (
+	componentMain    = "main"
+	componentSession = "session"
+	componentSD      = "sd"
+	componentPush    = "push"
+)
+
+var levelRanks = map[string]int32{
+	"debug": 0,
+	"info":  1,
+	"warn":  2,
+	"error": 3,
+}
+
+// logLevels are the levels of the components, adjustable at runtime.
+type logLevels struct {
+	mutex  sync.Mutex
+	levels map[string]*atomic.Int32
+}
+
+var componentLevels = &logLevels{levels: make(map[string]*atomic.Int32)}
+
+func (l *logLevels) get(component string) *atomic.Int32 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	lvl, ok := l.levels[component]
+	if !ok {
+		lvl = new(atomic.Int32)
+		lvl.Store(levelRanks[*logLevel])
+		l.levels[component] = lvl
+	}
+	return lvl
+}
+
+// set changes the level of the component, or of all the components if the
+// component is empty.
+func (l *logLevels) set(component, lvl string) error {
+	rank, ok := levelRanks[lvl]
+	if !ok {
+		return fmt.Errorf("unknown log level %q", lvl)
+	}
+	if component != "" {
+		l.get(component).Store(rank)
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, v := range l.levels {
+		v.Store(rank)
+	}
+	return nil
+}
+
+func (l *logLevels) all() map[string]string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	names := make(map[int32]string, len(levelRanks))
+	for name, rank := range levelRanks {
+		names[rank] = name
+	}
+	res := make(map[string]string, len(l.levels))
+	for component, v := range l.levels {
+		res[component] = names[v.Load()]
+	}
+	return res
+}
+
+// levelFilter drops the records below the level of the component, the
+// records without level are kept.
+type levelFilter struct {
+	next  log.Logger
+	level *atomic.Int32
+}
+
+func (f *levelFilter) Log(keyvals ...interface{}) error {
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] != level.Key() {
+			continue
+		}
+		if v, ok := keyvals[i+1].(level.Value); ok {
+			if rank, known := levelRanks[v.String()]; known && rank < f.level.Load() {
+				return nil
+			}
+		}
+		break
+	}
+	return f.next.Log(keyvals...)
+}
+
+// newBaseLogger returns the logger writing the errors to stderr and the other
+// records to stdout, in the format of the -log.format flag. The json records
+// have a timestamp, for the log pipelines.
+func newBaseLogger() log.Logger {
+	newLogger := func(w io.Writer) log.Logger {
+		if *logFormat == "json" {
+			return log.With(log.NewJSONLogger(log.NewSyncWriter(w)), "ts", log.DefaultTimestampUTC)
+		}
+		return log.NewLogfmtLogger(log.NewSyncWriter(w))
+	}
+	if *logFormat != "json" && *logFormat != "logfmt" {
+		panic(fmt.Errorf("unknown log format %q", *logFormat))
+	}
+	if _, ok := levelRanks[*logLevel]; !ok {
+		panic(fmt.Errorf("unknown log level %q", *logLevel))
+	}
+	return &splitLog{
+		err:  newLogger(os.Stderr),
+		rest: newLogger(os.Stdout),
+	}
+}
+
+// componentLogger returns the logger of the component, filtered by the level
+// of the component and with a component key.
+func componentLogger(base log.Logger, component string) log.Logger {
+	return log.With(&levelFilter{next: base, level: componentLevels.get(component)}, "component", component)
+}
+
+// serveLogLevels serves the levels of the components as json, and changes the
+// level of a component with a PUT or POST ?component=session&level=debug, of
+// all the components if the component is omitted.
+func serveLogLevels(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		component, lvl := r.URL.Query().Get("component"), r.URL.Query().Get("level")
+		if err := componentLevels.set(component, lvl); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = level.Info(logger).Log("msg", "log level changed", "target_component", component, "level", lvl)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(componentLevels.all())
+}
@@ -4,7 +4,6 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -13,21 +12,17 @@ import (
 	"strings"
 	"time"
 
-	"connectrpc.com/connect"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/grafana/pyroscope/ebpf/cpp/demangle"
 	ebpfmetrics "github.com/grafana/pyroscope/ebpf/metrics"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
-	commonconfig "github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/relabel"
 
 	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
-	"github.com/grafana/pyroscope/api/gen/proto/go/push/v1/pushv1connect"
-	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	ebpfspy "github.com/grafana/pyroscope/ebpf"
 	"github.com/grafana/pyroscope/ebpf/pprof"
 	"github.com/grafana/pyroscope/ebpf/sd"
@@ -42,12 +37,21 @@ var discoverFreq = flag.Duration("discover.freq",
 
 var collectFreq = flag.Duration("collect.freq",
 	15*time.Second,
-	"")
+	"collection interval, if not set in the session options of the config")
+
+var debugListen = flag.String("debug.listen", "",
+	"address of the debug endpoints, like /debug/build-ids, disabled if empty")
 
 var (
 	config  *Config
 	logger  log.Logger
 	session ebpfspy.Session
+	// pushLogger is the logger of the push results.
+	pushLogger log.Logger
+
+	frameScrubber = pprof.NewFrameScrubber()
+	tracker       = newSeriesTracker()
+	symbolTables  = pprof.NewSymbolTables()
 )
 
 type splitLog struct {
@@ -74,18 +78,18 @@ func (s splitLog) Log(keyvals ...interface{}) error {
 func main() {
 	config = getConfig()
 
-	logger = &splitLog{
-		err:  log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr)),
-		rest: log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout)),
-	}
+	baseLogger := newBaseLogger()
+	logger = componentLogger(baseLogger, componentMain)
+	pushLogger = componentLogger(baseLogger, componentPush)
 
-	targetFinder, err := sd.NewTargetFinder(os.DirFS("/"), logger, convertTargetOptions())
+	targetFinder, err := sd.NewTargetFinder(os.DirFS("/"), componentLogger(baseLogger, componentSD), convertTargetOptions())
 	if err != nil {
 		panic(fmt.Errorf("ebpf target finder create: %w", err))
 	}
+	targetFinder.Subscribe(tracker.onTargetEvents)
 	options := convertSessionOptions()
 	session, err = ebpfspy.NewSession(
-		logger,
+		componentLogger(baseLogger, componentSession),
 		targetFinder,
 		options,
 	)
@@ -93,27 +97,42 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	if *debugListen != "" {
+		serveDebug(*debugListen)
+	}
 
-	profiles := make(chan *pushv1.PushRequest, 128)
-	go ingest(profiles)
+	profilesRouter, err := newRouter(config.Routes, *server)
+	if err != nil {
+		panic(fmt.Errorf("profiles router create: %w", err))
+	}
+	httpClient, err := newPushClient(config.Push)
+	if err != nil {
+		panic(fmt.Errorf("push client create: %w", err))
+	}
+	profilesRouter.start(httpClient)
 
 	discoverTicker := time.NewTicker(*discoverFreq)
-	collectTicker := time.NewTicker(*collectFreq)
+	collectTicker := ebpfspy.NewCollectTicker(options.Collect)
 
 	for {
 		select {
 		case <-discoverTicker.C:
 			session.UpdateTargets(convertTargetOptions())
-		case <-collectTicker.C:
-			collectProfiles(profiles)
+		case tick := <-collectTicker.C:
+			collectProfiles(profilesRouter, tick)
 		}
 	}
 }
 
-func collectProfiles(profiles chan *pushv1.PushRequest) {
+func collectProfiles(profilesRouter *router, tick time.Time) {
+	start, duration := config.SessionOptions.Collect.Window(tick)
 	builders := pprof.NewProfileBuilders(pprof.BuildersOptions{
 		SampleRate:    int64(config.SessionOptions.SampleRate),
 		PerPIDProfile: true,
+		FrameFilter:   frameScrubber.Filter,
+		Time:          start,
+		Duration:      duration,
+		SymbolTables:  symbolTables,
 	})
 	err := pprof.Collect(builders, session)
 
@@ -122,58 +141,40 @@ func collectProfiles(profiles chan *pushv1.PushRequest) {
 	}
 	level.Debug(logger).Log("msg", "ebpf collectProfiles done", "profiles", len(builders.Builders))
 
-	for _, builder := range builders.Builders {
-		protoLabels := make([]*typesv1.LabelPair, 0, builder.Labels.Len())
-		for _, label := range builder.Labels {
-			protoLabels = append(protoLabels, &typesv1.LabelPair{
-				Name: label.Name, Value: label.Value,
-			})
-		}
+	var size int64
+	for _, builder := range builders.Sorted() {
+		protoLabels := pushLabels(builder.Labels, builder.Labels.Len())
 
 		buf := bytes.NewBuffer(nil)
-		_, err := builder.Write(buf)
+		n, err := builder.Write(buf)
 		if err != nil {
 			panic(err)
 		}
+		size += n
 		req := &pushv1.PushRequest{Series: []*pushv1.RawProfileSeries{{
 			Labels: protoLabels,
 			Samples: []*pushv1.RawSample{{
 				RawProfile: buf.Bytes(),
 			}},
 		}}}
+		e := profilesRouter.route(builder.Labels)
 		select {
-		case profiles <- req:
+		case e.profiles <- req:
 		default:
-			_ = level.Error(logger).Log("err", "dropping profile", "target", builder.Labels.String())
+			_ = level.Error(pushLogger).Log("err", "dropping profile", "target", builder.Labels.String(), "server", e.server, "tenant", e.tenantID)
 		}
+		tracker.sent(builder)
 
 	}
+	level.Debug(logger).Log("msg", "ebpf profiles written", "profiles", len(builders.Builders), "bytes", size,
+		"symbol_tables", symbolTables.Len())
+	tracker.sendEndOfSeries(profilesRouter)
 
 	if err != nil {
 		panic(err)
 	}
 }
 
-func ingest(profiles chan *pushv1.PushRequest) {
-	httpClient, err := commonconfig.NewClientFromConfig(commonconfig.DefaultHTTPClientConfig, "http_playground")
-	if err != nil {
-		panic(err)
-	}
-	client := pushv1connect.NewPusherServiceClient(httpClient, *server)
-
-	for {
-		it := <-profiles
-		res, err := client.Push(context.TODO(), connect.NewRequest(it))
-		if err != nil {
-			fmt.Println(err)
-		}
-		if res != nil {
-			fmt.Println(res)
-		}
-	}
-
-}
-
 func convertTargetOptions() sd.TargetsOptions {
 	targets := relabelProcessTargets(getProcessTargets(), config.RelabelConfig)
 	o := config.TargetsOptions
@@ -190,16 +191,18 @@ func getConfig() *Config {
 
 	var config = new(Config)
 	*config = defaultConfig
-	if *configFile == "" {
-		return config
-	}
-	configBytes, err := os.ReadFile(*configFile)
-	if err != nil {
-		panic(err)
+	if *configFile != "" {
+		configBytes, err := os.ReadFile(*configFile)
+		if err != nil {
+			panic(err)
+		}
+		err = json.Unmarshal(configBytes, config)
+		if err != nil {
+			panic(err)
+		}
 	}
-	err = json.Unmarshal(configBytes, config)
-	if err != nil {
-		panic(err)
+	if config.SessionOptions.Collect.Interval == 0 {
+		config.SessionOptions.Collect.Interval = *collectFreq
 	}
 	return config
 }
@@ -212,12 +215,14 @@ var defaultConfig = Config{
 		ContainerCacheSize: 1024,
 	},
 	RelabelConfig: nil,
+	Push:          defaultPushConfig,
 	SessionOptions: ebpfspy.SessionOptions{
 		CollectUser:               true,
 		CollectKernel:             true,
 		UnknownSymbolModuleOffset: true,
 		UnknownSymbolAddress:      true,
 		PythonEnabled:             true,
+		RubyEnabled:               true,
 		CacheOptions: symtab.CacheOptions{
 
 			PidCacheOptions: symtab.GCacheOptions{
@@ -232,6 +237,14 @@ var defaultConfig = Config{
 				Size:       239,
 				KeepRounds: 8,
 			},
+			NegativeCacheOptions: symtab.NegativeCacheOptions{
+				Size: 239,
+				TTL:  10 * time.Minute,
+			},
+			ElfParserOptions: symtab.ElfParserOptions{
+				Workers:  2,
+				Deadline: 5 * time.Second,
+			},
 		},
 		SymbolOptions: symtab.SymbolOptions{
 			GoTableFallback:    true,
@@ -243,6 +256,9 @@ var defaultConfig = Config{
 		VerifierLogSize:          1024 * 1024 * 1024,
 		PythonBPFErrorLogEnabled: true,
 		PythonBPFDebugLogEnabled: true,
+		Collect: ebpfspy.CollectOptions{
+			Align: true,
+		},
 		BPFMapsOptions: ebpfspy.BPFMapsOptions{
 			PIDMapSize:     2048,
 			SymbolsMapSize: 16384,
@@ -253,6 +269,8 @@ var defaultConfig = Config{
 type Config struct {
 	TargetsOptions sd.TargetsOptions
 	RelabelConfig  []*RelabelConfig
+	Routes         []*RouteConfig
+	Push           PushConfig
 	SessionOptions ebpfspy.SessionOptions
 }
 
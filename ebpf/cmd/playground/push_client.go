@@ -0,0 +1,73 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	commonconfig "github.com/prometheus/common/config"
+	"golang.org/x/sys/unix"
+)
+
+// PushConfig configures the HTTP client the profiles are pushed with.
+type PushConfig struct {
+	// HTTPClientConfig is the proxy and TLS configuration of the client.
+	// The proxy is either proxy_url, an http, https or socks5 URL, or taken
+	// from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables of the
+	// environment with proxy_from_environment, the default.
+	HTTPClientConfig commonconfig.HTTPClientConfig
+
+	// Interface is the network interface the connections are bound to, for
+	// example on the nodes with a dedicated egress interface.
+	Interface string
+
+	// SourceAddress is the local IPv4 or IPv6 address the connections are
+	// made from.
+	SourceAddress string
+}
+
+var defaultPushConfig = func() PushConfig {
+	cfg := commonconfig.DefaultHTTPClientConfig
+	cfg.ProxyFromEnvironment = true
+	return PushConfig{HTTPClientConfig: cfg}
+}()
+
+func newPushClient(cfg PushConfig) (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if cfg.SourceAddress != "" {
+		ip := net.ParseIP(cfg.SourceAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address %q", cfg.SourceAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	if cfg.Interface != "" {
+		iface := cfg.Interface
+		dialer.Control = func(_, _ string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) {
+				err = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface)
+			}); cerr != nil {
+				return cerr
+			}
+			if err != nil {
+				return fmt.Errorf("bind to interface %s: %w", iface, err)
+			}
+			return nil
+		}
+	}
+	// The dialer connects over IPv4 and IPv6, whichever is available, so
+	// the client works on the IPv6 only clusters as well.
+	return commonconfig.NewClientFromConfig(cfg.HTTPClientConfig, "http_playground",
+		commonconfig.WithDialContextFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}))
+}
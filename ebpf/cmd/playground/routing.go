@@ -0,0 +1,191 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/push/v1/pushv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/ebpf/sd"
+)
+
+const tenantHeader = "X-Scope-OrgID"
+
+// RouteConfig sends the profiles of the targets matching the regex to a
+// different server or tenant. The values of the source labels are joined
+// with the separator and matched the same way as in RelabelConfig. The
+// first matching route is used; the profiles of the targets matching no
+// route are sent to the -server flag endpoint, to the tenant of their
+// sd.LabelTenantID label if set, see sd.NamespaceDefaults.
+type RouteConfig struct {
+	SourceLabels []string
+
+	Separator string
+
+	Regex string
+
+	// Server is the -server flag endpoint if empty.
+	Server string
+
+	// TenantID is sent in the X-Scope-OrgID header if set.
+	TenantID string
+}
+
+type route struct {
+	sourceLabels []string
+	separator    string
+	regex        relabel.Regexp
+	endpoint     *endpoint
+}
+
+type endpoint struct {
+	server   string
+	tenantID string
+	profiles chan *pushv1.PushRequest
+}
+
+type router struct {
+	routes   []route
+	fallback *endpoint
+	// tenants are the endpoints of the sd.LabelTenantID tenants, created
+	// when the first profile of the tenant is routed.
+	tenants    map[string]*endpoint
+	httpClient *http.Client
+}
+
+func newRouter(cfg []*RouteConfig, defaultServer string) (*router, error) {
+	endpoints := make(map[[2]string]*endpoint)
+	getEndpoint := func(server, tenantID string) *endpoint {
+		if server == "" {
+			server = defaultServer
+		}
+		k := [2]string{server, tenantID}
+		e, ok := endpoints[k]
+		if !ok {
+			e = &endpoint{
+				server:   server,
+				tenantID: tenantID,
+				profiles: make(chan *pushv1.PushRequest, 128),
+			}
+			endpoints[k] = e
+		}
+		return e
+	}
+	r := &router{fallback: getEndpoint("", ""), tenants: make(map[string]*endpoint)}
+	for i, c := range cfg {
+		regex, err := relabel.NewRegexp(c.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %w", i, err)
+		}
+		separator := c.Separator
+		if separator == "" {
+			separator = relabel.DefaultRelabelConfig.Separator
+		}
+		r.routes = append(r.routes, route{
+			sourceLabels: c.SourceLabels,
+			separator:    separator,
+			regex:        regex,
+			endpoint:     getEndpoint(c.Server, c.TenantID),
+		})
+	}
+	return r, nil
+}
+
+func (r *router) route(lbls labels.Labels) *endpoint {
+	values := make([]string, 0, 4)
+	for _, rt := range r.routes {
+		values = values[:0]
+		for _, name := range rt.sourceLabels {
+			values = append(values, lbls.Get(name))
+		}
+		if rt.regex.MatchString(strings.Join(values, rt.separator)) {
+			return rt.endpoint
+		}
+	}
+	if tenantID := lbls.Get(sd.LabelTenantID); tenantID != "" {
+		return r.tenantEndpoint(tenantID)
+	}
+	return r.fallback
+}
+
+func (r *router) tenantEndpoint(tenantID string) *endpoint {
+	e, ok := r.tenants[tenantID]
+	if !ok {
+		e = &endpoint{
+			server:   r.fallback.server,
+			tenantID: tenantID,
+			profiles: make(chan *pushv1.PushRequest, 128),
+		}
+		r.tenants[tenantID] = e
+		if r.httpClient != nil {
+			go e.ingest(r.httpClient)
+		}
+	}
+	return e
+}
+
+// start pushes the profiles routed to the endpoints with the client.
+func (r *router) start(httpClient *http.Client) {
+	r.httpClient = httpClient
+	for _, e := range r.endpoints() {
+		go e.ingest(httpClient)
+	}
+}
+
+// pushLabels returns the labels of the profiles of the target, without the
+// labels used for routing only.
+func pushLabels(lbls labels.Labels, capacity int) []*typesv1.LabelPair {
+	res := make([]*typesv1.LabelPair, 0, capacity)
+	for _, label := range lbls {
+		if label.Name == sd.LabelTenantID {
+			continue
+		}
+		res = append(res, &typesv1.LabelPair{Name: label.Name, Value: label.Value})
+	}
+	return res
+}
+
+func (r *router) endpoints() []*endpoint {
+	res := []*endpoint{r.fallback}
+	seen := map[*endpoint]bool{r.fallback: true}
+	for _, rt := range r.routes {
+		if !seen[rt.endpoint] {
+			seen[rt.endpoint] = true
+			res = append(res, rt.endpoint)
+		}
+	}
+	for _, e := range r.tenants {
+		res = append(res, e)
+	}
+	return res
+}
+
+func (e *endpoint) ingest(httpClient *http.Client) {
+	client := pushv1connect.NewPusherServiceClient(httpClient, e.server)
+
+	for {
+		it := <-e.profiles
+		req := connect.NewRequest(it)
+		if e.tenantID != "" {
+			req.Header().Set(tenantHeader, e.tenantID)
+		}
+		_, err := client.Push(context.TODO(), req)
+		if err != nil {
+			_ = level.Error(pushLogger).Log("err", err, "msg", "push failed", "event", "push",
+				"server", e.server, "tenant", e.tenantID, "series", len(it.Series))
+			continue
+		}
+		_ = level.Debug(pushLogger).Log("msg", "push done", "event", "push",
+			"server", e.server, "tenant", e.tenantID, "series", len(it.Series))
+	}
+}
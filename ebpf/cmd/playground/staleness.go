@@ -0,0 +1,115 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/google/pprof/profile"
+	"github.com/prometheus/prometheus/model/labels"
+
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/ebpf/pprof"
+	"github.com/grafana/pyroscope/ebpf/sd"
+)
+
+// labelNameEndOfSeries marks the series of a target that is gone, so that
+// the server tells a stopped service from a broken agent, the same way as
+// the Prometheus staleness markers. The marker is an empty profile with
+// no duration.
+const labelNameEndOfSeries = "__end_of_series__"
+
+// seriesTracker remembers the series sent for each target, and sends their
+// end of series markers once the target is removed. The markers are sent
+// after the next collection, so that they follow the last profiles of the
+// target.
+type seriesTracker struct {
+	mu      sync.Mutex
+	series  map[uint64]*trackedSeries
+	removed []*trackedSeries
+}
+
+type trackedSeries struct {
+	labels labels.Labels
+	// profiles are the sample types sent, by the profile type.
+	profiles map[string]*profile.Profile
+}
+
+func newSeriesTracker() *seriesTracker {
+	return &seriesTracker{series: make(map[uint64]*trackedSeries)}
+}
+
+func (t *seriesTracker) onTargetEvents(events []sd.TargetEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, e := range events {
+		if e.Type != sd.TargetRemoved {
+			continue
+		}
+		h, _ := e.Target.Labels()
+		if s, ok := t.series[h]; ok {
+			delete(t.series, h)
+			t.removed = append(t.removed, s)
+		}
+	}
+}
+
+func (t *seriesTracker) sent(builder *pprof.ProfileBuilder) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := builder.Labels.Hash()
+	s, ok := t.series[h]
+	if !ok {
+		s = &trackedSeries{
+			labels:   builder.Labels,
+			profiles: make(map[string]*profile.Profile),
+		}
+		t.series[h] = s
+	}
+	p := builder.Profile
+	k := p.PeriodType.Type
+	if _, ok = s.profiles[k]; !ok {
+		s.profiles[k] = &profile.Profile{
+			SampleType: p.SampleType,
+			PeriodType: p.PeriodType,
+			Period:     p.Period,
+		}
+	}
+}
+
+// sendEndOfSeries sends the markers of the targets removed since the
+// previous call.
+func (t *seriesTracker) sendEndOfSeries(profilesRouter *router) {
+	t.mu.Lock()
+	removed := t.removed
+	t.removed = nil
+	t.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	for _, s := range removed {
+		protoLabels := pushLabels(s.labels, s.labels.Len()+1)
+		protoLabels = append(protoLabels, &typesv1.LabelPair{
+			Name: labelNameEndOfSeries, Value: "true",
+		})
+		series := &pushv1.RawProfileSeries{Labels: protoLabels}
+		for _, p := range s.profiles {
+			p.TimeNanos = now
+			buf := bytes.NewBuffer(nil)
+			if err := p.Write(buf); err != nil {
+				_ = level.Error(logger).Log("err", err, "msg", "writing end of series marker", "target", s.labels.String())
+				continue
+			}
+			series.Samples = append(series.Samples, &pushv1.RawSample{RawProfile: buf.Bytes()})
+		}
+		e := profilesRouter.route(s.labels)
+		select {
+		case e.profiles <- &pushv1.PushRequest{Series: []*pushv1.RawProfileSeries{series}}:
+		default:
+			_ = level.Error(pushLogger).Log("err", "dropping end of series marker", "target", s.labels.String(), "server", e.server, "tenant", e.tenantID)
+		}
+	}
+}
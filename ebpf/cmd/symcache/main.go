@@ -0,0 +1,50 @@
+// symcache runs the shared symbol cache server, so that the profilers of a
+// node, configured with the same symtab.CacheOptions.SharedCacheSocket,
+// parse the symbol tables of the ELF files only once.
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/metrics"
+	"github.com/grafana/pyroscope/ebpf/symtab"
+)
+
+var socket = flag.String("socket", "/run/pyroscope/symtab.sock", "unix socket path")
+var size = flag.Int("size", 239, "number of symbol tables to keep")
+var keepRounds = flag.Int("keep-rounds", 8, "number of minutes to keep the unused symbol tables")
+
+func main() {
+	flag.Parse()
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+
+	server, err := symtab.NewSharedCacheServer(logger, symtab.SharedCacheServerOptions{
+		Socket: *socket,
+		CacheOptions: symtab.GCacheOptions{
+			Size:       *size,
+			KeepRounds: *keepRounds,
+		},
+	}, metrics.NewSymtabMetrics(nil))
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to create shared cache server", "err", err)
+		os.Exit(1)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		_ = server.Close()
+	}()
+
+	level.Info(logger).Log("msg", "serving the shared symbol cache", "socket", *socket)
+	if err = server.Serve(); err != nil {
+		level.Error(logger).Log("msg", "failed to serve the shared symbol cache", "err", err)
+		os.Exit(1)
+	}
+}
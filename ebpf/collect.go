@@ -0,0 +1,111 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultCollectInterval = 15 * time.Second
+
+// CollectOptions configures how often the profiles of the session are
+// collected by its owner, with a CollectTicker.
+type CollectOptions struct {
+	// Interval between the collections, 15s by default.
+	Interval time.Duration
+	// Align aligns the collections to the multiples of the Interval since
+	// the unix epoch, for example at :00, :15, :30 and :45 for 15s, the way
+	// the metrics are scraped, so that the profiles line up with the
+	// metrics of the same targets.
+	Align bool
+}
+
+func (o CollectOptions) interval() time.Duration {
+	if o.Interval <= 0 {
+		return defaultCollectInterval
+	}
+	return o.Interval
+}
+
+// next returns the time of the collection following the one at t.
+func (o CollectOptions) next(t time.Time) time.Time {
+	interval := o.interval()
+	if !o.Align {
+		return t.Add(interval)
+	}
+	ns := t.UnixNano()
+	return time.Unix(0, ns-ns%int64(interval)+int64(interval))
+}
+
+// Window returns the time range of the profiles collected at t.
+func (o CollectOptions) Window(t time.Time) (start time.Time, duration time.Duration) {
+	interval := o.interval()
+	return t.Add(-interval), interval
+}
+
+// CollectTicker delivers the times of the collections to C. Unlike
+// time.Ticker, the aligned collections do not drift from the wall clock
+// boundaries. Like time.Ticker, the ticks are dropped if the receiver
+// falls behind.
+type CollectTicker struct {
+	C <-chan time.Time
+	c chan time.Time
+
+	mu      sync.Mutex
+	options CollectOptions
+	timer   *time.Timer
+}
+
+func NewCollectTicker(options CollectOptions) *CollectTicker {
+	c := make(chan time.Time, 1)
+	t := &CollectTicker{C: c, c: c}
+	t.Reset(options)
+	return t
+}
+
+// Reset changes the options of the ticker, for example on a session update.
+// The next collection is scheduled from now.
+func (t *CollectTicker) Reset(options CollectOptions) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopLocked()
+	t.options = options
+	t.scheduleLocked(options.next(time.Now()))
+}
+
+func (t *CollectTicker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopLocked()
+}
+
+func (t *CollectTicker) stopLocked() {
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
+
+func (t *CollectTicker) scheduleLocked(at time.Time) {
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(at), func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.timer != timer {
+			// stopped or reset
+			return
+		}
+		select {
+		case t.c <- at:
+		default:
+		}
+		next := t.options.next(at)
+		if now := time.Now(); next.Before(now) {
+			// The process was suspended, skip the missed collections.
+			next = t.options.next(now)
+		}
+		t.scheduleLocked(next)
+	})
+	t.timer = timer
+}
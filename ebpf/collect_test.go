@@ -0,0 +1,42 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectOptionsNext(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 20, 7, 500, time.UTC)
+
+	aligned := CollectOptions{Interval: 15 * time.Second, Align: true}
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 20, 15, 0, time.UTC), aligned.next(now).UTC())
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC), aligned.next(aligned.next(now)).UTC())
+
+	notAligned := CollectOptions{Interval: 15 * time.Second}
+	assert.Equal(t, now.Add(15*time.Second), notAligned.next(now))
+
+	defaults := CollectOptions{Align: true}
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 20, 15, 0, time.UTC), defaults.next(now).UTC())
+
+	start, duration := aligned.Window(time.Date(2024, 5, 1, 10, 20, 15, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 20, 0, 0, time.UTC), start.UTC())
+	assert.Equal(t, 15*time.Second, duration)
+}
+
+func TestCollectTicker(t *testing.T) {
+	ticker := NewCollectTicker(CollectOptions{Interval: 20 * time.Millisecond, Align: true})
+	defer ticker.Stop()
+	for i := 0; i < 3; i++ {
+		select {
+		case tick := <-ticker.C:
+			require.Zero(t, tick.UnixNano()%int64(20*time.Millisecond))
+		case <-time.After(time.Second):
+			t.Fatal("no tick")
+		}
+	}
+}
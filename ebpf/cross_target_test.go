@@ -0,0 +1,72 @@
+package ebpfspy
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/pyroscope/ebpf/metrics"
+	"github.com/grafana/pyroscope/ebpf/sd"
+	"github.com/grafana/pyroscope/ebpf/symtab"
+	"github.com/grafana/pyroscope/ebpf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEBPFCrossTargetLeakage profiles two containers running at the same
+// time and checks that no sample of one is attributed to the other.
+func TestEBPFCrossTargetLeakage(t *testing.T) {
+	images := []string{
+		"pyroscope/ebpf-testdata-rideshare:3.11-slim",
+		"pyroscope/ebpf-testdata-rideshare:3.12-alpine",
+	}
+	const ridesharePort = "5000"
+
+	l := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	l = log.With(l, "ts", log.DefaultTimestampUTC, "caller", log.Caller(5))
+
+	for _, img := range images {
+		testutil.PullImage(t, l, img)
+	}
+	containers := testutil.RunContainersWithPort(t, l, ridesharePort, images...)
+	for _, c := range containers {
+		defer c.Kill()
+	}
+
+	var targets []sd.DiscoveryTarget
+	for i, c := range containers {
+		targets = append(targets, sd.DiscoveryTarget{
+			"__container_id__": c.ContainerID,
+			"service_name":     images[i],
+		})
+	}
+	targetFinder, err := sd.NewTargetFinder(os.DirFS("/"), l, sd.TargetsOptions{
+		Targets:            targets,
+		ContainerCacheSize: 1024,
+		TargetsOnly:        true,
+	})
+	require.NoError(t, err)
+	cacheOptions := symtab.GCacheOptions{Size: 128, KeepRounds: 128}
+	profiler, err := NewSession(l, targetFinder, SessionOptions{
+		CollectUser: true,
+		SampleRate:  97,
+		Metrics:     metrics.New(nil),
+		CacheOptions: symtab.CacheOptions{
+			BuildIDCacheOptions:  cacheOptions,
+			SameFileCacheOptions: cacheOptions,
+			PidCacheOptions:      cacheOptions,
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, profiler.Start(), "Try running as privileged root user")
+	defer profiler.Stop()
+
+	for _, c := range containers {
+		loadgen(t, l, c.Url(), 2)
+	}
+
+	expected := make(map[string]testutil.Attribution, len(containers))
+	for i, c := range containers {
+		expected[images[i]] = testutil.Attribution{Pids: c.Pids()}
+	}
+	testutil.AssertNoCrossTargetLeakage(t, testutil.CollectSamples(t, profiler), expected)
+}
@@ -0,0 +1,90 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/grafana/pyroscope/ebpf/sd"
+)
+
+// KernelContext is the context of the kernel code of a sample.
+type KernelContext uint8
+
+const (
+	KernelContextTask KernelContext = iota
+	KernelContextSoftIRQ
+	KernelContextHardIRQ
+)
+
+// Frame returns the pseudo frame tagging the kernel frames of the samples
+// taken in the context.
+func (c KernelContext) Frame() string {
+	switch c {
+	case KernelContextSoftIRQ:
+		return "[softirq]"
+	case KernelContextHardIRQ:
+		return "[hardirq]"
+	default:
+		return "[task]"
+	}
+}
+
+var (
+	softIRQEntries = []string{"__do_softirq", "handle_softirqs", "do_softirq"}
+	hardIRQEntries = []string{
+		"common_interrupt", "do_IRQ", "handle_irq_event", "generic_handle_irq",
+		"handle_domain_irq", "gic_handle_irq", "__handle_irq_event_percpu",
+	}
+	hardIRQPrefixes = []string{"__sysvec_", "sysvec_"}
+)
+
+// kernelContextOf returns the context of the kernel stack, ordered from the
+// root to the leaf, by the entry points of the interrupt handlers. The
+// innermost context wins: a hardirq interrupting a softirq is a hardirq.
+func kernelContextOf(stack []string) KernelContext {
+	for i := len(stack) - 1; i >= 0; i-- {
+		sym := stack[i]
+		if slices.Contains(hardIRQEntries, sym) {
+			return KernelContextHardIRQ
+		}
+		for _, p := range hardIRQPrefixes {
+			if strings.HasPrefix(sym, p) {
+				return KernelContextHardIRQ
+			}
+		}
+		if slices.Contains(softIRQEntries, sym) {
+			return KernelContextSoftIRQ
+		}
+	}
+	return KernelContextTask
+}
+
+// softIRQTarget is the per-host pseudo-target the softirq samples are
+// attributed to, instead of the process they interrupted.
+var softIRQTarget = sd.NewTarget("", 0, sd.DiscoveryTarget{
+	"service_name": "kernel/softirq",
+})
+
+// appendKernelStack appends the kernel stack to sb, tagged with its context
+// if enabled. If the samples taken in softirq context are attributed to the
+// pseudo-target, it returns the pseudo-target, and sb holds the kernel stack
+// only.
+func (s *session) appendKernelStack(sb *stackBuilder, kStack []byte, target *sd.Target, stats *StackResolveStats) *sd.Target {
+	begin := len(sb.stack)
+	s.WalkStack(sb, kStack, s.symCache.GetKallsyms(), stats)
+	if !s.options.TagKernelContext && !s.options.SoftIRQPseudoTarget {
+		return target
+	}
+	kctx := kernelContextOf(sb.stack[begin:])
+	if kctx == KernelContextSoftIRQ && s.options.SoftIRQPseudoTarget {
+		sb.stack = append(sb.stack[:0], sb.stack[begin:]...)
+		sb.stack = slices.Insert(sb.stack, 0, kctx.Frame())
+		return softIRQTarget
+	}
+	if s.options.TagKernelContext && len(sb.stack) > begin {
+		sb.stack = slices.Insert(sb.stack, begin, kctx.Frame())
+	}
+	return target
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKernelContextOf(t *testing.T) {
+	testcases := []struct {
+		stack    []string
+		expected KernelContext
+	}{
+		{[]string{"entry_SYSCALL_64", "do_syscall_64", "__x64_sys_write"}, KernelContextTask},
+		{[]string{"asm_common_interrupt", "irq_exit_rcu", "__do_softirq", "net_rx_action"}, KernelContextSoftIRQ},
+		{[]string{"asm_sysvec_apic_timer_interrupt", "irq_exit_rcu", "handle_softirqs", "net_rx_action"}, KernelContextSoftIRQ},
+		{[]string{"__do_softirq", "net_rx_action", "common_interrupt", "handle_irq_event"}, KernelContextHardIRQ},
+		{[]string{"asm_sysvec_call_function", "__sysvec_call_function", "generic_smp_call_function"}, KernelContextHardIRQ},
+		{nil, KernelContextTask},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.expected, kernelContextOf(tc.stack), tc.stack)
+	}
+}
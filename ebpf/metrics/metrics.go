@@ -1,19 +1,48 @@
 package metrics
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/metric"
+)
 
 type Metrics struct {
 	Symtab *SymtabMetrics
 	Python *PythonMetrics
+	Ruby   *RubyMetrics
 }
 
-func New(reg prometheus.Registerer) *Metrics {
+type Option func(*options)
+
+type options struct {
+	meterProvider metric.MeterProvider
+}
+
+// WithMeterProvider emits the metrics through the OpenTelemetry meter
+// provider too, for the agents without a Prometheus scrape endpoint.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(o *options) {
+		o.meterProvider = mp
+	}
+}
+
+func New(reg prometheus.Registerer, opts ...Option) *Metrics {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
 	res := &Metrics{
 		Symtab: NewSymtabMetrics(reg),
 		Python: NewPythonMetrics(reg),
+		Ruby:   NewRubyMetrics(reg),
 	}
 	if reg != nil {
 		reg.MustRegister()
 	}
+	if o.meterProvider != nil {
+		meter := o.meterProvider.Meter(meterName)
+		registerOTel(meter, res.Symtab.collectors()...)
+		registerOTel(meter, res.Python.collectors()...)
+		registerOTel(meter, res.Ruby.collectors()...)
+	}
 	return res
 }
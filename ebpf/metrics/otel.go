@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const meterName = "github.com/grafana/pyroscope/ebpf/metrics"
+
+// descRegexp extracts the name and the help of a metric from the description
+// of a collector, prometheus.Desc has no accessors for them.
+var descRegexp = regexp.MustCompile(`^Desc{fqName: ("[^"]+"), help: ("(?:[^"\\]|\\.)*")`)
+
+// registerOTel creates an observable instrument for each of the collectors,
+// reporting the values the collector has at the collection time: the counters
+// as counters and the rest as gauges.
+func registerOTel(meter metric.Meter, collectors ...prometheus.Collector) {
+	for _, c := range collectors {
+		descs := make(chan *prometheus.Desc, 1)
+		go func() {
+			c.Describe(descs)
+			close(descs)
+		}()
+		for desc := range descs {
+			name, help, err := parseDesc(desc)
+			if err != nil {
+				otel.Handle(err)
+				continue
+			}
+			switch c.(type) {
+			case prometheus.Counter, *prometheus.CounterVec:
+				_, err = meter.Float64ObservableCounter(name,
+					metric.WithDescription(help),
+					metric.WithFloat64Callback(collectorCallback(c)))
+			default:
+				_, err = meter.Float64ObservableGauge(name,
+					metric.WithDescription(help),
+					metric.WithFloat64Callback(collectorCallback(c)))
+			}
+			if err != nil {
+				otel.Handle(err)
+			}
+		}
+	}
+}
+
+func collectorCallback(c prometheus.Collector) metric.Float64Callback {
+	return func(_ context.Context, o metric.Float64Observer) error {
+		ch := make(chan prometheus.Metric, 16)
+		go func() {
+			c.Collect(ch)
+			close(ch)
+		}()
+		var err error
+		for m := range ch {
+			var d dto.Metric
+			if werr := m.Write(&d); werr != nil {
+				err = werr
+				continue
+			}
+			attrs := make([]attribute.KeyValue, 0, len(d.GetLabel()))
+			for _, l := range d.GetLabel() {
+				attrs = append(attrs, attribute.String(l.GetName(), l.GetValue()))
+			}
+			var v float64
+			switch {
+			case d.Counter != nil:
+				v = d.Counter.GetValue()
+			case d.Gauge != nil:
+				v = d.Gauge.GetValue()
+			default:
+				v = d.GetUntyped().GetValue()
+			}
+			o.Observe(v, metric.WithAttributes(attrs...))
+		}
+		return err
+	}
+}
+
+func parseDesc(desc *prometheus.Desc) (string, string, error) {
+	m := descRegexp.FindStringSubmatch(desc.String())
+	if m == nil {
+		return "", "", fmt.Errorf("metrics: unexpected collector description %s", desc)
+	}
+	name, err := strconv.Unquote(m[1])
+	if err != nil {
+		return "", "", fmt.Errorf("metrics: unexpected collector description %s: %w", desc, err)
+	}
+	help, err := strconv.Unquote(m[2])
+	if err != nil {
+		return "", "", fmt.Errorf("metrics: unexpected collector description %s: %w", desc, err)
+	}
+	return name, help, nil
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestMeterProvider(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	reg := prometheus.NewRegistry()
+	m := New(reg, WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))))
+
+	m.Symtab.KnownSymbols.WithLabelValues("foo").Add(3)
+	m.Symtab.KnownSymbols.WithLabelValues("bar").Add(1)
+	m.Python.LostSamples.Add(2)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	values := map[string]float64{}
+	for _, sm := range rm.ScopeMetrics[0].Metrics {
+		sum, ok := sm.Data.(metricdata.Sum[float64])
+		require.True(t, ok, sm.Name)
+		require.True(t, sum.IsMonotonic, sm.Name)
+		for _, dp := range sum.DataPoints {
+			service, _ := dp.Attributes.Value(attribute.Key("service_name"))
+			values[sm.Name+"/"+service.AsString()] = dp.Value
+		}
+	}
+	require.Equal(t, 3.0, values["pyroscope_symtab_known_symbols_total/foo"])
+	require.Equal(t, 1.0, values["pyroscope_symtab_known_symbols_total/bar"])
+	require.Equal(t, 2.0, values["pyroscope_pyperf_lost_samples_total/"])
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.NotEmpty(t, families)
+}
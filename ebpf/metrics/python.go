@@ -51,15 +51,19 @@ func NewPythonMetrics(reg prometheus.Registerer) *PythonMetrics {
 	}
 
 	if reg != nil {
-		reg.MustRegister(
-			m.PidDataError,
-			m.LostSamples,
-			m.SymbolLookup,
-			m.StacktraceError,
-			m.UnknownSymbols,
-			m.ProcessInitSuccess,
-		)
+		reg.MustRegister(m.collectors()...)
 	}
 
 	return m
 }
+
+func (m *PythonMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.PidDataError,
+		m.LostSamples,
+		m.SymbolLookup,
+		m.StacktraceError,
+		m.UnknownSymbols,
+		m.ProcessInitSuccess,
+	}
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type RubyMetrics struct {
+	PidDataError       *prometheus.CounterVec
+	SymbolLookup       *prometheus.CounterVec
+	UnknownSymbols     *prometheus.CounterVec
+	ProcessInitSuccess *prometheus.CounterVec
+	Load               prometheus.Counter
+	LoadError          prometheus.Counter
+}
+
+func NewRubyMetrics(reg prometheus.Registerer) *RubyMetrics {
+	m := &RubyMetrics{
+		PidDataError: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_rbperf_pid_data_errors_total",
+			Help: "Total number of errors while trying to collect ruby data (version and addresses) from a running process",
+		}, []string{"service_name"}),
+		SymbolLookup: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_rbperf_symbol_lookup_total",
+			Help: "Total number of symbol lookups",
+		}, []string{"service_name"}),
+		UnknownSymbols: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_rbperf_unknown_symbols_total",
+			Help: "Total number of unknown symbols",
+		}, []string{"service_name"}),
+		ProcessInitSuccess: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_rbperf_process_init_success_total",
+			Help: "Total number of successful init calls",
+		}, []string{"service_name"}),
+		Load: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_rbperf_load",
+			Help: "Total number of rbperf loads",
+		}),
+		LoadError: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_rbperf_load_error_total",
+			Help: "Total number of rbperf load errors",
+		}),
+	}
+
+	if reg != nil {
+		reg.MustRegister(m.collectors()...)
+	}
+
+	return m
+}
+
+func (m *RubyMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.PidDataError,
+		m.SymbolLookup,
+		m.UnknownSymbols,
+		m.ProcessInitSuccess,
+	}
+}
@@ -9,6 +9,9 @@ type SymtabMetrics struct {
 	UnknownSymbols *prometheus.CounterVec
 	UnknownModules *prometheus.CounterVec
 	UnknownStacks  *prometheus.CounterVec
+
+	NoFramePointersBinaries *prometheus.GaugeVec
+	ElfParseTimeouts        prometheus.Counter
 }
 
 func NewSymtabMetrics(reg prometheus.Registerer) *SymtabMetrics {
@@ -37,18 +40,32 @@ func NewSymtabMetrics(reg prometheus.Registerer) *SymtabMetrics {
 			Name: "pyroscope_symtab_unknown_stacks_total",
 			Help: "Total number of stacks with unknowns > knowns",
 		}, []string{"service_name"}),
+		NoFramePointersBinaries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pyroscope_symtab_no_frame_pointers_binaries",
+			Help: "Binaries likely compiled without frame pointers, set to 1 for each binary detected",
+		}, []string{"binary", "build_id"}),
+		ElfParseTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_symtab_elf_parse_timeouts_total",
+			Help: "Total number of elf symbol tables not parsed before the deadline",
+		}),
 	}
 
 	if reg != nil {
-		reg.MustRegister(
-			m.ElfErrors,
-			m.ProcErrors,
-			m.KnownSymbols,
-			m.UnknownSymbols,
-			m.UnknownModules,
-			m.UnknownStacks,
-		)
+		reg.MustRegister(m.collectors()...)
 	}
 
 	return m
 }
+
+func (m *SymtabMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.ElfErrors,
+		m.ProcErrors,
+		m.KnownSymbols,
+		m.UnknownSymbols,
+		m.UnknownModules,
+		m.UnknownStacks,
+		m.NoFramePointersBinaries,
+		m.ElfParseTimeouts,
+	}
+}
@@ -5,6 +5,7 @@ package ebpfspy
 import (
 	"fmt"
 	"syscall"
+	"unsafe"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
@@ -35,6 +36,16 @@ func newPerfEvent(cpu int, sampleRate int) (*perfEvent, error) {
 	return &perfEvent{fd: fd}, nil
 }
 
+// setSampleRate changes the sampling frequency of the perf event.
+func (pe *perfEvent) setSampleRate(sampleRate int) error {
+	freq := uint64(sampleRate)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(pe.fd), uintptr(unix.PERF_EVENT_IOC_PERIOD), uintptr(unsafe.Pointer(&freq)))
+	if errno != 0 {
+		return fmt.Errorf("setting perf event sample rate: %w", errno)
+	}
+	return nil
+}
+
 func (pe *perfEvent) Close() error {
 	_ = syscall.Close(pe.fd)
 	if pe.link != nil {
@@ -0,0 +1,92 @@
+package pprof
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// CollapsedStacks returns the values of the profile by stack, in the collapsed
+// format: the function names from the root frame, separated by semicolons.
+// The values of the samples with the same stack are summed up.
+func CollapsedStacks(p *profile.Profile, valueIndex int) map[string]int64 {
+	stacks := make(map[string]int64, len(p.Sample))
+	names := make([]string, 0, 64)
+	for _, s := range p.Sample {
+		names = names[:0]
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			for j := len(s.Location[i].Line) - 1; j >= 0; j-- {
+				names = append(names, s.Location[i].Line[j].Function.Name)
+			}
+		}
+		stacks[strings.Join(names, ";")] += s.Value[valueIndex]
+	}
+	return stacks
+}
+
+// FormatCollapsed returns the collapsed stacks of the profile, one
+// "stack value" line per stack, sorted. It is stable across runs and suited
+// for golden files.
+func FormatCollapsed(p *profile.Profile, valueIndex int) string {
+	stacks := CollapsedStacks(p, valueIndex)
+	lines := make([]string, 0, len(stacks))
+	for stack, v := range stacks {
+		lines = append(lines, fmt.Sprintf("%s %d", stack, v))
+	}
+	slices.Sort(lines)
+	return strings.Join(lines, "\n")
+}
+
+// DiffProfiles compares the profiles structurally: their sample types, period
+// and values by stack. The ids, the order of the samples, locations and
+// functions, and the timestamps are ignored. It returns a description of the
+// differences, or an empty string if the profiles are equal.
+func DiffProfiles(expected, actual *profile.Profile) string {
+	if e, a := formatValueTypes(expected.SampleType), formatValueTypes(actual.SampleType); e != a {
+		return fmt.Sprintf("sample types: expected %s, actual %s", e, a)
+	}
+	var diff []string
+	if expected.Period != actual.Period {
+		diff = append(diff, fmt.Sprintf("period: expected %d, actual %d", expected.Period, actual.Period))
+	}
+	for i := range expected.SampleType {
+		e, a := CollapsedStacks(expected, i), CollapsedStacks(actual, i)
+		for _, stack := range sortedKeys(e, a) {
+			ev, eok := e[stack]
+			av, aok := a[stack]
+			switch {
+			case !aok:
+				diff = append(diff, fmt.Sprintf("%s: missing stack %s", expected.SampleType[i].Type, stack))
+			case !eok:
+				diff = append(diff, fmt.Sprintf("%s: unexpected stack %s", expected.SampleType[i].Type, stack))
+			case ev != av:
+				diff = append(diff, fmt.Sprintf("%s: stack %s: expected %d, actual %d", expected.SampleType[i].Type, stack, ev, av))
+			}
+		}
+	}
+	return strings.Join(diff, "\n")
+}
+
+func formatValueTypes(types []*profile.ValueType) string {
+	res := make([]string, 0, len(types))
+	for _, t := range types {
+		res = append(res, t.Type+"/"+t.Unit)
+	}
+	return "[" + strings.Join(res, " ") + "]"
+}
+
+func sortedKeys(a, b map[string]int64) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -1,9 +1,12 @@
 package pprof
 
 import (
+	"cmp"
 	"fmt"
 	"io"
 	"reflect"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 	"unsafe"
@@ -31,6 +34,15 @@ type SampleType uint32
 
 var SampleTypeCpu = SampleType(0)
 var SampleTypeMem = SampleType(1)
+var SampleTypeThrottle = SampleType(2)
+var SampleTypeProbe = SampleType(3)
+var SampleTypeOffCPU = SampleType(4)
+
+// ProbeType is the sample type of the custom profiles of the probes.
+type ProbeType struct {
+	Name string
+	Unit string
+}
 
 type SampleAggregation bool
 
@@ -53,17 +65,43 @@ type ProfileSample struct {
 	Stack       []string
 	Value       uint64
 	Value2      uint64
+	// SampleRate of the CPU samples, if it differs from the sample rate of
+	// the builders, for example, when the session raises the sample rate
+	// under CPU pressure.
+	SampleRate int64
+	// CgroupID of the process, if known. The samples of the restarts of a
+	// container, with the same labels, are not merged in a profile.
+	CgroupID uint64
+	// BuildID of the executable of the process, if known.
+	BuildID string
+	// Probe is the sample type of the SampleTypeProbe samples.
+	Probe ProbeType
 }
 
+// FrameFilter rewrites the frames of the stack of a sample of the target,
+// before they are written to a profile. It returns nil to drop the sample.
+type FrameFilter func(target *sd.Target, stack []string) []string
+
 type BuildersOptions struct {
 	SampleRate    int64
 	PerPIDProfile bool
+	FrameFilter   FrameFilter
+	// Time and Duration of the profiles, the time range of the collection.
+	// By default, the profiles are timed at their creation, with no duration.
+	Time     time.Time
+	Duration time.Duration
+	// SymbolTables, if set, are shared with the builders of the previous
+	// rounds. The per pid profiles of the processes with a known build id
+	// reuse their mapping and functions.
+	SymbolTables *SymbolTables
 }
 
 type builderHashKey struct {
 	labelsHash uint64
 	pid        uint32
 	sampleType SampleType
+	cgroupID   uint64
+	probe      ProbeType
 }
 
 type ProfileBuilders struct {
@@ -72,6 +110,9 @@ type ProfileBuilders struct {
 }
 
 func NewProfileBuilders(options BuildersOptions) *ProfileBuilders {
+	if options.SymbolTables != nil {
+		options.SymbolTables.nextRound()
+	}
 	return &ProfileBuilders{Builders: make(map[builderHashKey]*ProfileBuilder), opt: options}
 }
 
@@ -82,6 +123,12 @@ func Collect(builders *ProfileBuilders, collector SamplesCollector) error {
 }
 
 func (b *ProfileBuilders) AddSample(sample *ProfileSample) {
+	if b.opt.FrameFilter != nil && len(sample.Stack) > 0 {
+		sample.Stack = b.opt.FrameFilter(sample.Target, sample.Stack)
+		if sample.Stack == nil {
+			return
+		}
+	}
 	bb := b.BuilderForSample(sample)
 	if sample.Aggregation == SampleAggregated {
 		bb.CreateSample(sample)
@@ -90,10 +137,39 @@ func (b *ProfileBuilders) AddSample(sample *ProfileSample) {
 	}
 }
 
+// Sorted returns the builders ordered by their labels, sample type, pid and
+// cgroup, so the profiles are written in the same order on every run.
+func (b *ProfileBuilders) Sorted() []*ProfileBuilder {
+	keys := make([]builderHashKey, 0, len(b.Builders))
+	for k := range b.Builders {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(i, j builderHashKey) int {
+		if c := labels.Compare(b.Builders[i].Labels, b.Builders[j].Labels); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(i.sampleType, j.sampleType); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(i.probe.Name, j.probe.Name); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(i.pid, j.pid); c != 0 {
+			return c
+		}
+		return cmp.Compare(i.cgroupID, j.cgroupID)
+	})
+	res := make([]*ProfileBuilder, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, b.Builders[k])
+	}
+	return res
+}
+
 func (b *ProfileBuilders) BuilderForSample(sample *ProfileSample) *ProfileBuilder {
 	labelsHash, labels := sample.Target.Labels()
 
-	k := builderHashKey{labelsHash: labelsHash, sampleType: sample.SampleType}
+	k := builderHashKey{labelsHash: labelsHash, sampleType: sample.SampleType, cgroupID: sample.CgroupID, probe: sample.Probe}
 	if b.opt.PerPIDProfile {
 		k.pid = sample.Pid
 	}
@@ -109,26 +185,42 @@ func (b *ProfileBuilders) BuilderForSample(sample *ProfileSample) *ProfileBuilde
 		sampleType = []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}}
 		periodType = &profile.ValueType{Type: "cpu", Unit: "nanoseconds"}
 		period = time.Second.Nanoseconds() / b.opt.SampleRate
+	} else if sample.SampleType == SampleTypeThrottle {
+		sampleType = []*profile.ValueType{{Type: "throttled", Unit: "nanoseconds"}}
+		periodType = &profile.ValueType{Type: "throttled", Unit: "nanoseconds"}
+		period = 1
+	} else if sample.SampleType == SampleTypeOffCPU {
+		sampleType = []*profile.ValueType{{Type: "off_cpu", Unit: "nanoseconds"}}
+		periodType = &profile.ValueType{Type: "off_cpu", Unit: "nanoseconds"}
+		period = 1
+	} else if sample.SampleType == SampleTypeProbe {
+		sampleType = []*profile.ValueType{{Type: sample.Probe.Name, Unit: sample.Probe.Unit}}
+		periodType = &profile.ValueType{Type: sample.Probe.Name, Unit: sample.Probe.Unit}
+		period = 1
 	} else {
 		sampleType = []*profile.ValueType{{Type: "alloc_objects", Unit: "count"}, {Type: "alloc_space", Unit: "bytes"}}
 		periodType = &profile.ValueType{Type: "space", Unit: "bytes"}
 		period = 512 * 1024 // todo
 	}
+	var symbols *symbolTable
+	mapping := &profile.Mapping{ID: 1}
+	if b.opt.SymbolTables != nil && b.opt.PerPIDProfile && sample.BuildID != "" {
+		symbols = b.opt.SymbolTables.table(sample.BuildID)
+		mapping = symbols.mapping
+	}
 	builder := &ProfileBuilder{
 		locations:          make(map[string]*profile.Location),
 		functions:          make(map[string]*profile.Function),
 		sampleHashToSample: make(map[uint64]*profile.Sample),
 		Labels:             labels,
+		symbols:            symbols,
 		Profile: &profile.Profile{
-			Mapping: []*profile.Mapping{
-				{
-					ID: 1,
-				},
-			},
-			SampleType: sampleType,
-			Period:     period,
-			PeriodType: periodType,
-			TimeNanos:  time.Now().UnixNano(),
+			Mapping:       []*profile.Mapping{mapping},
+			SampleType:    sampleType,
+			Period:        period,
+			PeriodType:    periodType,
+			TimeNanos:     b.profileTime().UnixNano(),
+			DurationNanos: b.opt.Duration.Nanoseconds(),
 		},
 		tmpLocationIDs: make([]uint64, 0, 128),
 		tmpLocations:   make([]*profile.Location, 0, 128),
@@ -138,12 +230,20 @@ func (b *ProfileBuilders) BuilderForSample(sample *ProfileSample) *ProfileBuilde
 	return res
 }
 
+func (b *ProfileBuilders) profileTime() time.Time {
+	if b.opt.Time.IsZero() {
+		return time.Now()
+	}
+	return b.opt.Time
+}
+
 type ProfileBuilder struct {
 	locations          map[string]*profile.Location
 	functions          map[string]*profile.Function
 	sampleHashToSample map[uint64]*profile.Sample
 	Profile            *profile.Profile
 	Labels             labels.Labels
+	symbols            *symbolTable
 
 	tmpLocations   []*profile.Location
 	tmpLocationIDs []uint64
@@ -207,18 +307,62 @@ func (p *ProfileBuilder) addFunction(function string) *profile.Function {
 	}
 
 	id := uint64(len(p.Profile.Function) + 1)
-	f = &profile.Function{
-		ID:   id,
-		Name: function,
+	if p.symbols != nil {
+		f = p.symbols.function(function)
+		f.ID = id
+	} else {
+		f = &profile.Function{
+			ID:   id,
+			Name: function,
+		}
 	}
 	p.Profile.Function = append(p.Profile.Function, f)
 	p.functions[function] = f
 	return f
 }
 
+// Sort orders the samples by their stacks, from the root frame, and numbers
+// the locations and functions in the order the sorted samples reference them.
+// The samples come from the bpf maps in no particular order, sorting makes
+// the encoded profile, including its string table, deterministic.
+func (p *ProfileBuilder) Sort() {
+	slices.SortStableFunc(p.Profile.Sample, func(a, b *profile.Sample) int {
+		for i, j := len(a.Location)-1, len(b.Location)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+			if c := strings.Compare(a.Location[i].Line[0].Function.Name, b.Location[j].Line[0].Function.Name); c != 0 {
+				return c
+			}
+		}
+		return len(a.Location) - len(b.Location)
+	})
+	locations := make([]*profile.Location, 0, len(p.Profile.Location))
+	functions := make([]*profile.Function, 0, len(p.Profile.Function))
+	seen := make(map[*profile.Location]struct{}, len(p.Profile.Location))
+	for _, s := range p.Profile.Sample {
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			loc := s.Location[i]
+			if _, ok := seen[loc]; ok {
+				continue
+			}
+			seen[loc] = struct{}{}
+			locations = append(locations, loc)
+			loc.ID = uint64(len(locations))
+			// every location has its own function
+			f := loc.Line[0].Function
+			functions = append(functions, f)
+			f.ID = uint64(len(functions))
+		}
+	}
+	p.Profile.Location = locations
+	p.Profile.Function = functions
+}
+
+// Write encodes the gzipped profile to dst and returns the number of bytes
+// written.
 func (p *ProfileBuilder) Write(dst io.Writer) (int64, error) {
+	p.Sort()
+	cw := &countingWriter{w: dst}
 	gzipWriter := gzipWriterPool.Get().(*gzip.Writer)
-	gzipWriter.Reset(dst)
+	gzipWriter.Reset(cw)
 	defer func() {
 		gzipWriter.Reset(io.Discard)
 		gzipWriterPool.Put(gzipWriter)
@@ -229,9 +373,20 @@ func (p *ProfileBuilder) Write(dst io.Writer) (int64, error) {
 	}
 	err = gzipWriter.Close()
 	if err != nil {
-		return 0, fmt.Errorf("ebpf profile encode %w", err)
+		return cw.n, fmt.Errorf("ebpf profile encode %w", err)
 	}
-	return 0, nil
+	return cw.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
 
 func uint64Bytes(s []uint64) []byte {
@@ -247,7 +402,8 @@ func uint64Bytes(s []uint64) []byte {
 }
 func (p *ProfileBuilder) newSample(inputSample *ProfileSample) *profile.Sample {
 	sample := new(profile.Sample)
-	if inputSample.SampleType == SampleTypeCpu {
+	if inputSample.SampleType == SampleTypeCpu || inputSample.SampleType == SampleTypeThrottle || inputSample.SampleType == SampleTypeProbe ||
+		inputSample.SampleType == SampleTypeOffCPU {
 		sample.Value = []int64{0}
 	} else {
 		sample.Value = []int64{0, 0}
@@ -258,7 +414,14 @@ func (p *ProfileBuilder) newSample(inputSample *ProfileSample) *profile.Sample {
 
 func (p *ProfileBuilder) addValue(inputSample *ProfileSample, sample *profile.Sample) {
 	if inputSample.SampleType == SampleTypeCpu {
-		sample.Value[0] += int64(inputSample.Value) * p.Profile.Period
+		period := p.Profile.Period
+		if inputSample.SampleRate > 0 {
+			period = time.Second.Nanoseconds() / inputSample.SampleRate
+		}
+		sample.Value[0] += int64(inputSample.Value) * period
+	} else if inputSample.SampleType == SampleTypeThrottle || inputSample.SampleType == SampleTypeProbe ||
+		inputSample.SampleType == SampleTypeOffCPU {
+		sample.Value[0] += int64(inputSample.Value)
 	} else {
 		sample.Value[0] += int64(inputSample.Value)
 		sample.Value[1] += int64(inputSample.Value2)
@@ -49,6 +49,202 @@ func TestBackAndForth(t *testing.T) {
 	assert.Equal(t, 4242*period, stacks["a;b;d"])
 }
 
+func TestSampleRateOverride(t *testing.T) {
+	builders := NewProfileBuilders(BuildersOptions{
+		SampleRate: int64(97),
+	})
+
+	s := sample([]string{"a", "b", "c"}, 10)
+	s.SampleRate = 997
+	builders.AddSample(s)
+	builders.AddSample(sample([]string{"a", "b", "d"}, 10))
+
+	builder := builders.BuilderForSample(s)
+	stacks := stackCollapse(builder.Profile)
+	assert.Equal(t, 10*(time.Second.Nanoseconds()/997), stacks["a;b;c"])
+	assert.Equal(t, 10*(time.Second.Nanoseconds()/97), stacks["a;b;d"])
+}
+
+func TestThrottleSamples(t *testing.T) {
+	builders := NewProfileBuilders(BuildersOptions{
+		SampleRate: int64(97),
+	})
+
+	s := sample([]string{"a", "b", "c"}, 239)
+	s.SampleType = SampleTypeThrottle
+	builders.AddSample(s)
+	builders.AddSample(sample([]string{"a", "b", "c"}, 1))
+	assert.Equal(t, 2, len(builders.Builders))
+
+	builder := builders.BuilderForSample(s)
+	assert.Equal(t, "throttled", builder.Profile.SampleType[0].Type)
+	assert.Equal(t, int64(239), stackCollapse(builder.Profile)["a;b;c"])
+}
+
+func TestOffCPUSamples(t *testing.T) {
+	builders := NewProfileBuilders(BuildersOptions{
+		SampleRate: int64(97),
+	})
+
+	s := sample([]string{"a", "b", "c"}, 1500000)
+	s.SampleType = SampleTypeOffCPU
+	builders.AddSample(s)
+	builders.AddSample(sample([]string{"a", "b", "c"}, 1))
+	assert.Equal(t, 2, len(builders.Builders))
+
+	builder := builders.BuilderForSample(s)
+	assert.Equal(t, "off_cpu", builder.Profile.SampleType[0].Type)
+	assert.Equal(t, "nanoseconds", builder.Profile.SampleType[0].Unit)
+	assert.Equal(t, int64(1500000), stackCollapse(builder.Profile)["a;b;c"])
+}
+
+func TestCgroupIDSplitsBuilders(t *testing.T) {
+	builders := NewProfileBuilders(BuildersOptions{
+		SampleRate: int64(97),
+	})
+
+	s := sample([]string{"a", "b", "c"}, 1)
+	s.CgroupID = 42
+	builders.AddSample(s)
+	restarted := sample([]string{"a", "b", "c"}, 2)
+	restarted.CgroupID = 43
+	builders.AddSample(restarted)
+	assert.Equal(t, 2, len(builders.Builders))
+	assert.Equal(t, time.Second.Nanoseconds()/97, stackCollapse(builders.BuilderForSample(s).Profile)["a;b;c"])
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	stacks := [][]string{
+		{"c", "b", "a"},
+		{"d", "b", "a"},
+		{"e"},
+		{"b", "a"},
+	}
+	write := func(order []int) []byte {
+		builders := NewProfileBuilders(BuildersOptions{SampleRate: 97})
+		for _, i := range order {
+			builders.AddSample(sample(stacks[i], uint64(i+1)))
+		}
+		builder := builders.BuilderForSample(sample(nil, 0))
+		builder.Profile.TimeNanos = 0
+		buf := bytes.NewBuffer(nil)
+		_, err := builder.Write(buf)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+	expected := write([]int{0, 1, 2, 3})
+	require.Equal(t, expected, write([]int{3, 2, 1, 0}))
+	require.Equal(t, expected, write([]int{2, 0, 3, 1}))
+
+	parsed, err := profile.Parse(bytes.NewBuffer(expected))
+	require.NoError(t, err)
+	period := time.Second.Nanoseconds() / 97
+	assert.Equal(t, fmt.Sprintf("a;b %d\na;b;c %d\na;b;d %d\ne %d", 4*period, 1*period, 2*period, 3*period),
+		FormatCollapsed(parsed, 0))
+}
+
+func TestSymbolTablesReuse(t *testing.T) {
+	tables := NewSymbolTables()
+	round := func(buildID string, stack []string) (*ProfileBuilder, []byte) {
+		builders := NewProfileBuilders(BuildersOptions{SampleRate: 97, PerPIDProfile: true, SymbolTables: tables})
+		s := sample(stack, 1)
+		s.BuildID = buildID
+		builders.AddSample(s)
+		builder := builders.BuilderForSample(s)
+		buf := bytes.NewBuffer(nil)
+		n, err := builder.Write(buf)
+		require.NoError(t, err)
+		require.Equal(t, int64(buf.Len()), n)
+		return builder, buf.Bytes()
+	}
+
+	first, _ := round("abc", []string{"a", "b", "c"})
+	second, raw := round("abc", []string{"a", "b", "d"})
+	assert.Same(t, first.Profile.Mapping[0], second.Profile.Mapping[0])
+	// the functions are numbered from the root frame, a and b are reused
+	assert.Same(t, first.Profile.Function[1], second.Profile.Function[1])
+	assert.Same(t, first.Profile.Function[2], second.Profile.Function[2])
+	assert.NotSame(t, first.Profile.Function[0], second.Profile.Function[0])
+	assert.Equal(t, 1, tables.Len())
+
+	parsed, err := profile.Parse(bytes.NewBuffer(raw))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", parsed.Mapping[0].BuildID)
+	assert.Equal(t, map[string]int64{"a;b;d": time.Second.Nanoseconds() / 97}, stackCollapse(parsed))
+
+	// the table of a binary not seen in the previous round is dropped
+	round("def", []string{"a"})
+	round("def", []string{"a"})
+	assert.Equal(t, 1, tables.Len())
+}
+
+func TestSortedBuilders(t *testing.T) {
+	builders := NewProfileBuilders(BuildersOptions{SampleRate: 97, PerPIDProfile: true})
+	for _, pid := range []uint32{3, 1, 2} {
+		s := sample([]string{"a"}, 1)
+		s.Pid = pid
+		builders.AddSample(s)
+	}
+	other := sample([]string{"a"}, 1)
+	other.Target = sd.NewTarget("", 1, sd.DiscoveryTarget{"foo": "baz"})
+	builders.AddSample(other)
+
+	sorted := builders.Sorted()
+	require.Equal(t, 4, len(sorted))
+	assert.Equal(t, "bar", sorted[0].Labels.Get("foo"))
+	assert.Equal(t, "baz", sorted[3].Labels.Get("foo"))
+}
+
+func TestDiffProfiles(t *testing.T) {
+	build := func(samples ...*ProfileSample) *profile.Profile {
+		builders := NewProfileBuilders(BuildersOptions{SampleRate: 97})
+		for _, s := range samples {
+			builders.AddSample(s)
+		}
+		return builders.BuilderForSample(samples[0]).Profile
+	}
+	expected := build(sample([]string{"b", "a"}, 1), sample([]string{"c", "a"}, 2))
+	assert.Empty(t, DiffProfiles(expected, build(sample([]string{"c", "a"}, 2), sample([]string{"b", "a"}, 1))))
+
+	period := time.Second.Nanoseconds() / 97
+	diff := DiffProfiles(expected, build(sample([]string{"c", "a"}, 3), sample([]string{"d", "a"}, 1)))
+	assert.Equal(t, strings.Join([]string{
+		"cpu: missing stack a;b",
+		fmt.Sprintf("cpu: stack a;c: expected %d, actual %d", 2*period, 3*period),
+		"cpu: unexpected stack a;d",
+	}, "\n"), diff)
+}
+
+func TestFrameScrubber(t *testing.T) {
+	builders := NewProfileBuilders(BuildersOptions{
+		SampleRate:  97,
+		FrameFilter: NewFrameScrubber().Filter,
+	})
+	scrubbed := sd.NewTarget("", 1, sd.DiscoveryTarget{
+		"foo":                   "scrubbed",
+		sd.OptionFrameDropRegex: "secrets",
+		sd.OptionFrameTrimRegex: "^/home/[^/]+/",
+	})
+	invalid := sd.NewTarget("", 1, sd.DiscoveryTarget{
+		"foo":                   "invalid",
+		sd.OptionFrameDropRegex: "(",
+	})
+	for _, target := range []*sd.Target{testTarget, scrubbed, invalid} {
+		s := sample([]string{"/home/alice/app/main.py f", "/srv/secrets/load.py g", "python"}, 1)
+		s.Target = target
+		builders.AddSample(s)
+	}
+	require.Equal(t, 2, len(builders.Builders))
+
+	period := time.Second.Nanoseconds() / 97
+	assert.Equal(t, map[string]int64{"/home/alice/app/main.py f;/srv/secrets/load.py g;python": period},
+		stackCollapse(builders.BuilderForSample(sample(nil, 0)).Profile))
+	s := sample(nil, 0)
+	s.Target = scrubbed
+	assert.Equal(t, map[string]int64{"app/main.py f;python": period},
+		stackCollapse(builders.BuilderForSample(s).Profile))
+}
+
 var testTarget = sd.NewTarget("", 1, sd.DiscoveryTarget{"foo": "bar"})
 
 func sample(stack []string, v uint64) *ProfileSample {
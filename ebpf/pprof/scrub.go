@@ -0,0 +1,65 @@
+package pprof
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/grafana/pyroscope/ebpf/sd"
+)
+
+// FrameScrubber is a FrameFilter configured by the options of the targets:
+// the frames matching sd.OptionFrameDropRegex are dropped, and the parts of
+// the frames matching sd.OptionFrameTrimRegex are removed, for example to
+// trim the home directories from the paths. A sample of a target with an
+// invalid regex is dropped, so nothing the options were meant to scrub is
+// written.
+type FrameScrubber struct {
+	mu      sync.Mutex
+	regexes map[string]*regexp.Regexp
+}
+
+func NewFrameScrubber() *FrameScrubber {
+	return &FrameScrubber{regexes: make(map[string]*regexp.Regexp)}
+}
+
+func (s *FrameScrubber) Filter(target *sd.Target, stack []string) []string {
+	drop, ok := s.option(target, sd.OptionFrameDropRegex)
+	if !ok {
+		return nil
+	}
+	trim, ok := s.option(target, sd.OptionFrameTrimRegex)
+	if !ok {
+		return nil
+	}
+	if drop == nil && trim == nil {
+		return stack
+	}
+	res := make([]string, 0, len(stack))
+	for _, frame := range stack {
+		if drop != nil && drop.MatchString(frame) {
+			continue
+		}
+		if trim != nil {
+			frame = trim.ReplaceAllLiteralString(frame, "")
+		}
+		res = append(res, frame)
+	}
+	return res
+}
+
+// option returns the compiled regex of the target option, nil if the target
+// has no such option, and false if the regex is invalid.
+func (s *FrameScrubber) option(target *sd.Target, name string) (*regexp.Regexp, bool) {
+	expr, ok := target.Get(name)
+	if !ok || expr == "" {
+		return nil, true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	re, ok := s.regexes[expr]
+	if !ok {
+		re, _ = regexp.Compile(expr)
+		s.regexes[expr] = re
+	}
+	return re, re != nil
+}
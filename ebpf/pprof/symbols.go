@@ -0,0 +1,77 @@
+package pprof
+
+import (
+	"sync"
+
+	"github.com/google/pprof/profile"
+)
+
+// SymbolTables keeps the mappings and functions of the profiles across
+// collection rounds, by the build id of the executable of the processes.
+// The profiles of the processes running the same binary, in the same round
+// or in the following ones, reuse the functions and their names instead of
+// allocating them again from the symbolized stacks.
+//
+// A pprof profile is self-contained, the names of the functions are still
+// encoded in the string table of every profile. The builders sharing the
+// tables number the functions when written, they must be written one at a
+// time.
+type SymbolTables struct {
+	mutex  sync.Mutex
+	tables map[string]*symbolTable
+	round  uint64
+}
+
+type symbolTable struct {
+	mapping   *profile.Mapping
+	functions map[string]*profile.Function
+	round     uint64
+}
+
+func NewSymbolTables() *SymbolTables {
+	return &SymbolTables{tables: make(map[string]*symbolTable)}
+}
+
+// nextRound starts a collection round, the tables of the binaries not seen
+// in the previous round are dropped.
+func (s *SymbolTables) nextRound() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for buildID, t := range s.tables {
+		if t.round < s.round {
+			delete(s.tables, buildID)
+		}
+	}
+	s.round++
+}
+
+func (s *SymbolTables) table(buildID string) *symbolTable {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t := s.tables[buildID]
+	if t == nil {
+		t = &symbolTable{
+			mapping:   &profile.Mapping{ID: 1, BuildID: buildID},
+			functions: make(map[string]*profile.Function),
+		}
+		s.tables[buildID] = t
+	}
+	t.round = s.round
+	return t
+}
+
+// Len returns the number of binaries the tables are kept for.
+func (s *SymbolTables) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.tables)
+}
+
+func (t *symbolTable) function(name string) *profile.Function {
+	f, ok := t.functions[name]
+	if !ok {
+		f = &profile.Function{Name: name}
+		t.functions[name] = f
+	}
+	return f
+}
@@ -0,0 +1,105 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log/level"
+)
+
+const defaultCPUPressurePath = "/proc/pressure/cpu"
+
+// PressureTriggerOptions configures the session to profile at the SampleRate
+// only while the CPU is under pressure, and at the BaselineSampleRate
+// otherwise. The pressure is the "some avg10" value of the pressure stall
+// information (PSI): the percentage of the last 10 seconds during which at
+// least one task was waiting for a CPU.
+type PressureTriggerOptions struct {
+	Enabled bool
+	// PSIPath is the pressure file, /proc/pressure/cpu for the host or the
+	// cpu.pressure file of a cgroup v2, for example
+	// /sys/fs/cgroup/kubepods.slice/cpu.pressure.
+	PSIPath string
+	// Threshold is the pressure percentage above which the SampleRate is used.
+	Threshold          float64
+	BaselineSampleRate int
+}
+
+// targetSampleRate returns the sample rate the perf events should be
+// sampling at for the current options and CPU pressure.
+func (s *session) targetSampleRate() int {
+	t := s.options.PressureTrigger
+	if !t.Enabled || t.BaselineSampleRate <= 0 {
+		return s.options.SampleRate
+	}
+	path := t.PSIPath
+	if path == "" {
+		path = defaultCPUPressurePath
+	}
+	pressure, err := readCPUPressure(path)
+	if err != nil {
+		// Without the pressure, profile at the full rate rather than miss
+		// the data when it is needed.
+		_ = level.Error(s.logger).Log("msg", "reading cpu pressure", "path", path, "err", err)
+		return s.options.SampleRate
+	}
+	if pressure > t.Threshold {
+		return s.options.SampleRate
+	}
+	return t.BaselineSampleRate
+}
+
+// updateSampleRateLocked changes the sample rate of the perf events, if the
+// CPU pressure crossed the threshold. It is called between the collection
+// rounds, so that all the samples of a round are taken at the same rate.
+func (s *session) updateSampleRateLocked() {
+	if !s.started {
+		return
+	}
+	rate := s.targetSampleRate()
+	if rate == s.sampleRate {
+		return
+	}
+	for _, pe := range s.perfEvents {
+		if err := pe.setSampleRate(rate); err != nil {
+			_ = level.Error(s.logger).Log("msg", "updating perf event sample rate", "rate", rate, "err", err)
+			return
+		}
+	}
+	_ = level.Debug(s.logger).Log("msg", "sample rate updated", "from", s.sampleRate, "to", rate)
+	s.sampleRate = rate
+}
+
+// readCPUPressure returns the "some avg10" value of the pressure file.
+func readCPUPressure(path string) (float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return parseCPUPressure(data)
+}
+
+func parseCPUPressure(data []byte) (float64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "some" {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(f, "avg10="); ok {
+				return strconv.ParseFloat(v, 64)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no some avg10 value found")
+}
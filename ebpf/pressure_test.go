@@ -0,0 +1,22 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCPUPressure(t *testing.T) {
+	v, err := parseCPUPressure([]byte("some avg10=12.34 avg60=5.00 avg300=1.00 total=123456\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 12.34, v)
+
+	_, err = parseCPUPressure([]byte("full avg10=1.00 avg60=0.00 avg300=0.00 total=0\n"))
+	assert.Error(t, err)
+
+	_, err = parseCPUPressure([]byte("some avg10=x avg60=0.00 avg300=0.00 total=0\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,57 @@
+// Package procfs resolves the paths of the processes in the procfs their pids
+// are reported in.
+package procfs
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+)
+
+const defaultRoot = "/proc"
+
+var root atomic.Value
+
+// SetRoot sets the mount point of the procfs of the pid namespace of the
+// profiled processes, process wide. It must be the procfs of the host, or of
+// the outermost namespace of the processes, for example, /host/proc when the
+// profiler runs in a container with the procfs of the host mounted, so that
+// the processes of nested container runtimes, such as kind or dind, are
+// resolved. An empty root resets it to /proc.
+func SetRoot(r string) {
+	if r == "" {
+		r = defaultRoot
+	}
+	root.Store(filepath.Clean(r))
+}
+
+// Root returns the mount point of the procfs, /proc by default.
+func Root() string {
+	if r, ok := root.Load().(string); ok {
+		return r
+	}
+	return defaultRoot
+}
+
+// Path returns the path of the elements of the process in the procfs, for
+// example Path(42, "maps") is /proc/42/maps.
+func Path(pid uint32, elem ...string) string {
+	return filepath.Join(append([]string{Root(), strconv.FormatUint(uint64(pid), 10)}, elem...)...)
+}
+
+// RootFS returns the path of the root filesystem of the process, the files
+// of the mount namespace of the process are resolved in.
+func RootFS(pid uint32) string {
+	return Path(pid, "root")
+}
+
+// PIDNamespacePath returns the path of the pid namespace the pids are
+// translated to by the bpf programs: the namespace of the profiler for
+// /proc, and the namespace of the init process of the procfs otherwise.
+func PIDNamespacePath() string {
+	r := Root()
+	if r == defaultRoot {
+		return filepath.Join(r, "self", "ns", "pid")
+	}
+	return filepath.Join(r, "1", "ns", "pid")
+}
@@ -0,0 +1,54 @@
+//go:build linux
+
+package procfs
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestNestedPIDNamespace checks that a process in nested user and pid
+// namespaces is resolved by its pid in the namespace of the profiler.
+func TestNestedPIDNamespace(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("user namespaces are not available: %v", err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	pid := uint32(cmd.Process.Pid)
+
+	comm, err := os.ReadFile(Path(pid, "comm"))
+	require.NoError(t, err)
+	require.Equal(t, "sleep", strings.TrimSpace(string(comm)))
+
+	status, err := os.ReadFile(Path(pid, "status"))
+	require.NoError(t, err)
+	var nspid []string
+	for _, line := range strings.Split(string(status), "\n") {
+		if v, ok := strings.CutPrefix(line, "NSpid:"); ok {
+			nspid = strings.Fields(v)
+		}
+	}
+	// the pid in the namespace of the profiler, and 1 in the nested one
+	require.Equal(t, []string{strconv.Itoa(cmd.Process.Pid), "1"}, nspid)
+
+	ns, err := os.Readlink(Path(pid, "ns", "pid"))
+	require.NoError(t, err)
+	self, err := os.Readlink(PIDNamespacePath())
+	require.NoError(t, err)
+	require.NotEqual(t, self, ns)
+}
@@ -0,0 +1,23 @@
+package procfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPath(t *testing.T) {
+	defer SetRoot("")
+
+	assert.Equal(t, "/proc/42/maps", Path(42, "maps"))
+	assert.Equal(t, "/proc/42", Path(42))
+	assert.Equal(t, "/proc/42/root", RootFS(42))
+	assert.Equal(t, "/proc/self/ns/pid", PIDNamespacePath())
+
+	SetRoot("/host/proc/")
+	assert.Equal(t, "/host/proc/42/maps", Path(42, "maps"))
+	assert.Equal(t, "/host/proc/1/ns/pid", PIDNamespacePath())
+
+	SetRoot("")
+	assert.Equal(t, "/proc", Root())
+}
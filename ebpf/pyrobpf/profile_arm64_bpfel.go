@@ -18,7 +18,7 @@ type ProfilePidConfig struct {
 	Type          uint8
 	CollectUser   uint8
 	CollectKernel uint8
-	Padding       uint8
+	FilterThreads uint8
 }
 
 type ProfilePidEvent struct {
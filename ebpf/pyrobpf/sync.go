@@ -8,14 +8,30 @@ type ProfilingType uint8
 //#define PROFILING_TYPE_FRAMEPOINTERS 2
 //#define PROFILING_TYPE_PYTHON 3
 //#define PROFILING_TYPE_ERROR 4
+//#define PROFILING_TYPE_RUBY 5
 
 var (
 	ProfilingTypeUnknown       ProfilingType = 1
 	ProfilingTypeFramepointers ProfilingType = 2
 	ProfilingTypePython        ProfilingType = 3
 	ProfilingTypeError         ProfilingType = 4
+	ProfilingTypeRuby          ProfilingType = 5
 )
 
+func (t ProfilingType) String() string {
+	switch t {
+	case ProfilingTypeFramepointers:
+		return "framepointers"
+	case ProfilingTypePython:
+		return "python"
+	case ProfilingTypeError:
+		return "error"
+	case ProfilingTypeRuby:
+		return "ruby"
+	}
+	return "unknown"
+}
+
 //#define OP_REQUEST_UNKNOWN_PROCESS_INFO 1
 //#define OP_PID_DEAD 2
 //#define OP_REQUEST_EXEC_PROCESS_INFO 3
@@ -30,10 +46,14 @@ var (
 
 //#define SAMPLE_KEY_FLAG_PYTHON_STACK 1
 //#define SAMPLE_KEY_FLAG_STACK_TRUNCATED 2
+//#define SAMPLE_KEY_FLAG_DWARF_STACK 4
+//#define SAMPLE_KEY_FLAG_RUBY_STACK 8
 
 type SampleKeyFlag uint32
 
 var (
 	SampleKeyFlagPythonStack    SampleKeyFlag = 1
 	SampleKeyFlagStackTruncated SampleKeyFlag = 2
+	SampleKeyFlagDWARFStack     SampleKeyFlag = 4
+	SampleKeyFlagRubyStack      SampleKeyFlag = 8
 )
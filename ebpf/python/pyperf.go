@@ -28,6 +28,9 @@ type Perf struct {
 type Proc struct { // consider merging with symtab.ProcTable
 	PerfPyPidData *PerfPyPidData
 	SymbolOptions *symtab.SymbolOptions
+	// Qualnames is set if the names of the symbols of the process are the
+	// co_qualname of the code objects, instead of the co_name.
+	Qualnames bool
 }
 
 func NewPerf(logger log.Logger, metrics *metrics.PythonMetrics, pidDataHasMap *ebpf.Map, symbolsHashMap *ebpf.Map) (*Perf, error) {
@@ -52,6 +55,7 @@ func (s *Perf) NewProc(pid uint32, data *PerfPyPidData, options *symtab.SymbolOp
 		return prev, nil
 	}
 
+	qualnames := options.PythonQualnames && useQualnames(data)
 	err := s.pidDataHashMap.Update(pid, data, ebpf.UpdateAny)
 	if err != nil { // should never happen
 		return nil, fmt.Errorf("updating pid data hash map: %w", err)
@@ -60,6 +64,7 @@ func (s *Perf) NewProc(pid uint32, data *PerfPyPidData, options *symtab.SymbolOp
 	n := &Proc{
 		PerfPyPidData: data,
 		SymbolOptions: options,
+		Qualnames:     qualnames,
 	}
 	s.pidCache[pid] = n
 	return n, nil
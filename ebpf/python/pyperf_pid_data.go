@@ -8,11 +8,13 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/procfs"
 	"github.com/grafana/pyroscope/ebpf/symtab"
 )
 
 func GetPyPerfPidData(l log.Logger, pid uint32, collectKernel bool) (*PerfPyPidData, error) {
-	mapsFD, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	mapsPath := procfs.Path(pid, "maps")
+	mapsFD, err := os.Open(mapsPath)
 	if err != nil {
 		return nil, fmt.Errorf("reading proc maps %d: %w", pid, err)
 	}
@@ -21,7 +23,7 @@ func GetPyPerfPidData(l log.Logger, pid uint32, collectKernel bool) (*PerfPyPidD
 	info, err := GetProcInfo(bufio.NewScanner(mapsFD))
 
 	if err != nil {
-		return nil, fmt.Errorf("GetPythonProcInfo error %s: %w", fmt.Sprintf("/proc/%d/maps", pid), err)
+		return nil, fmt.Errorf("GetPythonProcInfo error %s: %w", mapsPath, err)
 	}
 	var pythonMeat []*symtab.ProcMap
 	if info.LibPythonMaps == nil {
@@ -30,7 +32,7 @@ func GetPyPerfPidData(l log.Logger, pid uint32, collectKernel bool) (*PerfPyPidD
 		pythonMeat = info.LibPythonMaps
 	}
 	base_ := pythonMeat[0]
-	pythonPath := fmt.Sprintf("/proc/%d/root%s", pid, base_.Pathname)
+	pythonPath := procfs.RootFS(pid) + base_.Pathname
 	pythonFD, err := os.Open(pythonPath)
 	if err != nil {
 		return nil, fmt.Errorf("could not open python path %s %w", pythonPath, err)
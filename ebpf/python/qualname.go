@@ -0,0 +1,38 @@
+package python
+
+import "strings"
+
+// useQualnames makes the bpf program read the co_qualname of the code
+// objects instead of their co_name. The co_qualname was added in 3.11, it
+// follows the co_name in PyCodeObject since. It reports if the qualnames are
+// used.
+func useQualnames(data *PerfPyPidData) bool {
+	if data.Version.Major != 3 || data.Version.Minor < 11 || data.Offsets.PyCodeObjectCoName == -1 {
+		return false
+	}
+	data.Offsets.PyCodeObjectCoName += 8 // sizeof(PyObject *)
+	return true
+}
+
+// QualifiedName returns the name of a python function qualified with its
+// class, the same way on all the versions. With the qualnames of 3.11+, it is
+// the last two parts of the qualname: the enclosing functions and classes are
+// dropped. Before 3.11, it is reconstructed from the class of the self or cls
+// first argument.
+func QualifiedName(classname, name string, qualnames bool) string {
+	if qualnames {
+		if i := strings.LastIndexByte(name, '.'); i > 0 {
+			if j := strings.LastIndexByte(name[:i], '.'); j >= 0 {
+				name = name[j+1:]
+			}
+		}
+		if strings.HasPrefix(name, "<locals>.") {
+			name = name[len("<locals>."):]
+		}
+		return name
+	}
+	if classname == "" {
+		return name
+	}
+	return classname + "." + name
+}
@@ -0,0 +1,35 @@
+package python
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQualifiedName(t *testing.T) {
+	testcases := []struct {
+		classname, name string
+		qualnames       bool
+		expected        string
+	}{
+		{"", "order_car", false, "order_car"},
+		{"Flask", "run", false, "Flask.run"},
+		{"", "order_car", true, "order_car"},
+		{"Flask", "Flask.run", true, "Flask.run"},
+		{"", "Outer.Inner.method", true, "Inner.method"},
+		{"", "handler.<locals>.inner", true, "inner"},
+		{"", "handler.<locals>.Local.method", true, "Local.method"},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.expected, QualifiedName(tc.classname, tc.name, tc.qualnames), tc)
+	}
+
+	data := &PerfPyPidData{}
+	data.Version.Major, data.Version.Minor = 3, 10
+	data.Offsets.PyCodeObjectCoName = 104
+	assert.False(t, useQualnames(data))
+	assert.Equal(t, int16(104), data.Offsets.PyCodeObjectCoName)
+	data.Version.Minor = 11
+	assert.True(t, useQualnames(data))
+	assert.Equal(t, int16(112), data.Offsets.PyCodeObjectCoName)
+}
@@ -38,6 +38,10 @@ func TestPythonString(t *testing.T) {
 			buf: []int8{0x20},
 			typ: &PerfPyStrType{Type: uint8(PyStrTypeNotCompact), SizeCodepoints: 239},
 			res: "",
+		}, {
+			buf: []int8{0x20},
+			typ: &PerfPyStrType{Type: uint8(PyStrTypeUnresolved)},
+			res: "",
 		},
 	}
 	for _, testdatum := range testdata {
@@ -105,6 +105,7 @@ func (e PyError) String() string {
 //#define PYSTR_TYPE_ASCII  8
 //#define PYSTR_TYPE_UTF8   16
 //#define PYSTR_TYPE_NOT_COMPACT  32
+//#define PYSTR_TYPE_UNRESOLVED  64
 
 type PyStrType uint8
 
@@ -115,4 +116,7 @@ var (
 	PyStrTypeAscii      PyStrType = 8
 	PyStrTypeUtf8       PyStrType = 16
 	PyStrTypeNotCompact PyStrType = 32
+	// PyStrTypeUnresolved is the type of the class name of a method, which
+	// could not be resolved
+	PyStrTypeUnresolved PyStrType = 64
 )
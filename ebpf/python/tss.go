@@ -4,11 +4,13 @@ import (
 	"encoding/binary"
 	"fmt"
 	"os"
+
+	"github.com/grafana/pyroscope/ebpf/procfs"
 )
 
 // todo split offsets validation and offset usage into separate routines
 func GetTSSKey(pid uint32, version Version, offsets *UserOffsets, autoTLSkeyAddr, pyRuntime uint64, libc *PerfLibc) (int32, error) {
-	fd, err := os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+	fd, err := os.Open(procfs.Path(pid, "mem"))
 	if err != nil {
 		return 0, fmt.Errorf("python memory open failed   %w", err)
 	}
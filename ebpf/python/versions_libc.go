@@ -10,6 +10,7 @@ import (
 
 	log2 "github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/procfs"
 )
 
 var reMuslVersion = regexp.MustCompile("1\\.([12])\\.(\\d+)\\D")
@@ -89,7 +90,7 @@ func GetLibc(l log2.Logger, pid uint32, info ProcInfo) (PerfLibc, error) {
 		return PerfLibc{}, fmt.Errorf("could not determine libc version %d, no libc found", pid)
 	}
 	if info.Musl != nil {
-		muslPath := fmt.Sprintf("/proc/%d/root%s", pid, info.Musl[0].Pathname)
+		muslPath := procfs.RootFS(pid) + info.Musl[0].Pathname
 		muslVersion, err := GetMuslVersionFromFile(muslPath)
 		if err != nil {
 			return PerfLibc{}, fmt.Errorf("couldnot determine musl version %s %w", muslPath, err)
@@ -108,7 +109,7 @@ func GetLibc(l log2.Logger, pid uint32, info ProcInfo) (PerfLibc, error) {
 		return res, nil
 	}
 
-	glibcPath := fmt.Sprintf("/proc/%d/root%s", pid, info.Glibc[0].Pathname)
+	glibcPath := procfs.RootFS(pid) + info.Glibc[0].Pathname
 	glibcVersion, err := GetGlibcVersionFromFile(glibcPath)
 	if err != nil {
 		return PerfLibc{}, fmt.Errorf("couldnot determine glibc version %s %w", glibcPath, err)
@@ -0,0 +1,46 @@
+package ruby
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+
+	"github.com/grafana/pyroscope/ebpf/symtab"
+)
+
+type ProcInfo struct {
+	RubyMaps    []*symtab.ProcMap
+	LibRubyMaps []*symtab.ProcMap
+}
+
+// reRuby matches the ruby executables, ruby or ruby3.1, and the libruby
+// shared libraries, libruby.so.3.3.0 or libruby-3.1.so.3.1.2.
+var reRuby = regexp.MustCompile(`/((?:lib)?ruby)(?:-?\d+\.\d+)?(?:\.so(?:\.[\d.]+)?)?$`)
+
+// GetProcInfo parses /proc/pid/map of a ruby process.
+func GetProcInfo(s *bufio.Scanner) (ProcInfo, error) {
+	res := ProcInfo{}
+	for s.Scan() {
+		line := s.Bytes()
+		m, err := symtab.ParseProcMapLine(line, false)
+		if err != nil {
+			return res, err
+		}
+		if m.Pathname == "" {
+			continue
+		}
+		matches := reRuby.FindStringSubmatch(m.Pathname)
+		if matches == nil {
+			continue
+		}
+		if matches[1] == "ruby" {
+			res.RubyMaps = append(res.RubyMaps, m)
+		} else {
+			res.LibRubyMaps = append(res.LibRubyMaps, m)
+		}
+	}
+	if res.LibRubyMaps == nil && res.RubyMaps == nil {
+		return res, fmt.Errorf("no ruby found")
+	}
+	return res, nil
+}
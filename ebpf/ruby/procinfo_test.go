@@ -0,0 +1,42 @@
+package ruby
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRubyProcInfo(t *testing.T) {
+	maps := `55d4c6a00000-55d4c6a01000 r--p 00000000 00:2a 1585                       /usr/local/bin/ruby
+55d4c6a01000-55d4c6a02000 r-xp 00001000 00:2a 1585                       /usr/local/bin/ruby
+55d4c6a02000-55d4c6a03000 r--p 00002000 00:2a 1585                       /usr/local/bin/ruby
+55d4c7d6e000-55d4c7f7c000 rw-p 00000000 00:00 0                          [heap]
+7f1c3a400000-7f1c3a4a6000 r--p 00000000 00:2a 1602                       /usr/local/lib/libruby.so.3.3.5
+7f1c3a4a6000-7f1c3a7a4000 r-xp 000a6000 00:2a 1602                       /usr/local/lib/libruby.so.3.3.5
+7f1c3a7a4000-7f1c3a8b9000 r--p 003a4000 00:2a 1602                       /usr/local/lib/libruby.so.3.3.5
+7f1c3a8b9000-7f1c3a8c0000 rw-p 004b9000 00:2a 1602                       /usr/local/lib/libruby.so.3.3.5
+7f1c3a8d2000-7f1c3a8d8000 r--p 00000000 00:2a 1649                       /usr/local/lib/ruby/3.3.0/x86_64-linux/monitor.so
+7f1c3aa00000-7f1c3aa28000 r--p 00000000 00:2a 1230                       /usr/lib/x86_64-linux-gnu/libc.so.6
+7ffd7b9f0000-7ffd7ba11000 rw-p 00000000 00:00 0                          [stack]`
+	info, err := GetProcInfo(bufio.NewScanner(strings.NewReader(maps)))
+	require.NoError(t, err)
+	require.Len(t, info.RubyMaps, 3)
+	require.Len(t, info.LibRubyMaps, 4)
+	assert.Equal(t, "/usr/local/lib/libruby.so.3.3.5", info.LibRubyMaps[0].Pathname)
+
+	maps = `5581e2a00000-5581e2a6b000 r--p 00000000 08:01 2097                       /usr/bin/ruby3.1
+5581e2a6b000-5581e2a6c000 r-xp 0006b000 08:01 2097                       /usr/bin/ruby3.1
+7f9c4e200000-7f9c4e28c000 r--p 00000000 08:01 3001                       /usr/lib/x86_64-linux-gnu/libruby-3.1.so.3.1.2`
+	info, err = GetProcInfo(bufio.NewScanner(strings.NewReader(maps)))
+	require.NoError(t, err)
+	require.Len(t, info.RubyMaps, 2)
+	require.Len(t, info.LibRubyMaps, 1)
+
+	maps = `5581e2a00000-5581e2a6b000 r--p 00000000 08:01 2097                       /usr/bin/rubocop-server
+7f9c4e200000-7f9c4e28c000 r--p 00000000 08:01 3001                       /usr/lib/x86_64-linux-gnu/libc.so.6`
+	_, err = GetProcInfo(bufio.NewScanner(strings.NewReader(maps)))
+	require.Error(t, err)
+}
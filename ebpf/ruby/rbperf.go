@@ -0,0 +1,144 @@
+package ruby
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/metrics"
+)
+
+type Perf struct {
+	logger         log.Logger
+	pidDataHashMap *ebpf.Map
+	symbolsHashMp  *ebpf.Map
+	metrics        *metrics.RubyMetrics
+
+	pidCache    map[uint32]*Proc
+	prevSymbols map[uint32]*PerfRbSymbol
+}
+
+type Proc struct {
+	PerfRbPidData *PerfRbPidData
+	Version       Version
+}
+
+func NewPerf(logger log.Logger, metrics *metrics.RubyMetrics, pidDataHasMap *ebpf.Map, symbolsHashMap *ebpf.Map) *Perf {
+	return &Perf{
+		logger:         logger,
+		pidDataHashMap: pidDataHasMap,
+		symbolsHashMp:  symbolsHashMap,
+		pidCache:       make(map[uint32]*Proc),
+		metrics:        metrics,
+	}
+}
+
+func (s *Perf) FindProc(pid uint32) *Proc {
+	return s.pidCache[pid]
+}
+
+func (s *Perf) NewProc(pid uint32, data *PerfRbPidData, version Version, serviceName string) (*Proc, error) {
+	prev := s.pidCache[pid]
+	if prev != nil {
+		return prev, nil
+	}
+	err := s.pidDataHashMap.Update(pid, data, ebpf.UpdateAny)
+	if err != nil { // should never happen
+		return nil, fmt.Errorf("updating pid data hash map: %w", err)
+	}
+	s.metrics.ProcessInitSuccess.WithLabelValues(serviceName).Inc()
+	n := &Proc{
+		PerfRbPidData: data,
+		Version:       version,
+	}
+	s.pidCache[pid] = n
+	return n, nil
+}
+
+func (s *Perf) GetLazySymbols() *LazySymbols {
+	return &LazySymbols{
+		symbols: s.prevSymbols,
+		fresh:   false,
+		perf:    s,
+	}
+}
+
+func (s *Perf) GetSymbols(svcReason string) (map[uint32]*PerfRbSymbol, error) {
+	s.metrics.SymbolLookup.WithLabelValues(svcReason).Inc()
+	var (
+		m       = s.symbolsHashMp
+		mapSize = m.MaxEntries()
+	)
+	keys := make([]PerfRbSymbol, mapSize)
+	values := make([]uint32, mapSize)
+	cursor := new(ebpf.MapBatchCursor)
+	n, err := m.BatchLookup(cursor, keys, values, &ebpf.BatchOptions{})
+	if n > 0 {
+		_ = level.Debug(s.logger).Log("msg", "GetSymbols BatchLookup", "count", n)
+		res := make(map[uint32]*PerfRbSymbol, n)
+		for i := 0; i < n; i++ {
+			res[values[i]] = &keys[i]
+		}
+		s.prevSymbols = res
+		return res, nil
+	}
+	if errors.Is(err, ebpf.ErrKeyNotExist) {
+		return nil, nil
+	}
+	// batch not supported, iterate
+	res := make(map[uint32]*PerfRbSymbol)
+	it := m.Iterate()
+	v := uint32(0)
+	for {
+		k := new(PerfRbSymbol)
+		if !it.Next(k, &v) {
+			if err := it.Err(); err != nil {
+				return nil, fmt.Errorf("map %s iteration : %w", m.String(), err)
+			}
+			break
+		}
+		res[v] = k
+	}
+	_ = level.Debug(s.logger).Log("msg", "GetSymbols iter", "count", len(res))
+	s.prevSymbols = res
+	return res, nil
+}
+
+func (s *Perf) RemoveDeadPID(pid uint32) {
+	delete(s.pidCache, pid)
+	err := s.pidDataHashMap.Delete(pid)
+	if err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		_ = level.Error(s.logger).Log("msg", "[rbperf] deleting pid data hash map", "err", err)
+	}
+}
+
+// LazySymbols tries to reuse a map from previous profile collection.
+// If found a new symbols, then full dump ( GetSymbols ) is performed.
+type LazySymbols struct {
+	perf    *Perf
+	symbols map[uint32]*PerfRbSymbol
+	fresh   bool
+}
+
+func (s *LazySymbols) GetSymbol(symID uint32, svc string) (*PerfRbSymbol, error) {
+	symbol, ok := s.symbols[symID]
+	if ok {
+		return symbol, nil
+	}
+	if s.fresh {
+		return nil, fmt.Errorf("symbol %d not found", symID)
+	}
+	symbols, err := s.perf.GetSymbols(svc)
+	if err != nil {
+		return nil, fmt.Errorf("symbols refresh failed: %w", err)
+	}
+	s.symbols = symbols
+	s.fresh = true
+	symbol, ok = symbols[symID]
+	if ok {
+		return symbol, nil
+	}
+	return nil, fmt.Errorf("symbol %d not found", symID)
+}
@@ -0,0 +1,125 @@
+package ruby
+
+import (
+	"bufio"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/grafana/pyroscope/ebpf/procfs"
+	"github.com/grafana/pyroscope/ebpf/symtab"
+)
+
+func GetRbPerfPidData(pid uint32, collectKernel bool) (*PerfRbPidData, Version, error) {
+	mapsPath := procfs.Path(pid, "maps")
+	mapsFD, err := os.Open(mapsPath)
+	if err != nil {
+		return nil, Version{}, fmt.Errorf("reading proc maps %d: %w", pid, err)
+	}
+	defer mapsFD.Close()
+
+	info, err := GetProcInfo(bufio.NewScanner(mapsFD))
+	if err != nil {
+		return nil, Version{}, fmt.Errorf("GetRubyProcInfo error %s: %w", mapsPath, err)
+	}
+	var rubyMeat []*symtab.ProcMap
+	if info.LibRubyMaps == nil {
+		rubyMeat = info.RubyMaps
+	} else {
+		rubyMeat = info.LibRubyMaps
+	}
+	base_ := rubyMeat[0]
+	rubyPath := procfs.RootFS(pid) + base_.Pathname
+	ef, err := elf.Open(rubyPath)
+	if err != nil {
+		return nil, Version{}, fmt.Errorf("opening elf %s: %w", rubyPath, err)
+	}
+	defer ef.Close()
+
+	symbols, err := rubySymbols(ef)
+	if err != nil {
+		return nil, Version{}, fmt.Errorf("reading symbols from elf %s: %w", rubyPath, err)
+	}
+	versionSym, ok := symbols["ruby_version"]
+	if !ok {
+		return nil, Version{}, fmt.Errorf("ruby_version not found %s", rubyPath)
+	}
+	versionStr, err := readSymbolString(ef, versionSym)
+	if err != nil {
+		return nil, Version{}, fmt.Errorf("reading ruby_version %s: %w", rubyPath, err)
+	}
+	version, err := ParseVersion(versionStr)
+	if err != nil {
+		return nil, Version{}, err
+	}
+	offsets, err := GetOffsets(version)
+	if err != nil {
+		return nil, version, err
+	}
+
+	baseAddr := base_.StartAddr
+	if ef.FileHeader.Type == elf.ET_EXEC {
+		baseAddr = 0
+	}
+	currentName := "ruby_current_vm_ptr"
+	if offsets.VMMainThread == -1 {
+		currentName = "ruby_current_execution_context_ptr"
+	}
+	current, ok := symbols[currentName]
+	if !ok {
+		return nil, version, fmt.Errorf("missing symbol %s %s %v", currentName, rubyPath, version)
+	}
+
+	data := &PerfRbPidData{
+		Offsets: offsets,
+		Current: baseAddr + current.Value,
+	}
+	if collectKernel {
+		data.CollectKernel = 1
+	} else {
+		data.CollectKernel = 0
+	}
+	return data, version, nil
+}
+
+// rubySymbols returns the dynamic symbols of the binary, libruby and the
+// ruby executables export the VM globals, or the symbols of the symtab for
+// the executables which do not.
+func rubySymbols(ef *elf.File) (map[string]elf.Symbol, error) {
+	res := make(map[string]elf.Symbol)
+	symbols, err := ef.DynamicSymbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, err
+	}
+	for _, sym := range symbols {
+		res[sym.Name] = sym
+	}
+	if _, ok := res["ruby_version"]; ok {
+		return res, nil
+	}
+	symbols, err = ef.Symbols()
+	if err != nil {
+		return nil, err
+	}
+	for _, sym := range symbols {
+		res[sym.Name] = sym
+	}
+	return res, nil
+}
+
+// readSymbolString reads the nul terminated string a symbol points to.
+func readSymbolString(ef *elf.File, sym elf.Symbol) (string, error) {
+	if int(sym.Section) >= len(ef.Sections) {
+		return "", fmt.Errorf("invalid section %d", sym.Section)
+	}
+	sec := ef.Sections[sym.Section]
+	if sym.Value < sec.Addr || sym.Value+sym.Size > sec.Addr+sec.Size || sym.Size > 64 {
+		return "", fmt.Errorf("invalid symbol %s", sym.Name)
+	}
+	buf := make([]byte, sym.Size)
+	if _, err := sec.ReadAt(buf, int64(sym.Value-sec.Addr)); err != nil {
+		return "", err
+	}
+	return CString(buf), nil
+}
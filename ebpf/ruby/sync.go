@@ -0,0 +1,56 @@
+package ruby
+
+import "bytes"
+
+const MapNameSymbols = "rb_symbols"
+
+// The structs mirror the ones of bpf/rbperf.h, the rbperf programs and maps
+// are part of the profile bpf objects.
+
+// PerfRbOffsetConfig mirrors rb_offset_config.
+type PerfRbOffsetConfig struct {
+	VMMainThread        int16
+	ThreadEC            int16
+	ECVMStack           int16
+	ECVMStackSize       int16
+	ECCfp               int16
+	CfpPC               int16
+	CfpIseq             int16
+	CfpSize             int16
+	IseqBody            int16
+	BodyLocation        int16
+	LocationPathobj     int16
+	LocationLabel       int16
+	LocationFirstLineno int16
+	RStringEmbed        int16
+	RStringHeapPtr      int16
+	RArrayEmbed         int16
+	RArrayHeapPtr       int16
+	Padding             int16
+	FirstLinenoFixnum   uint8
+	Padding2            [3]uint8
+}
+
+// PerfRbPidData mirrors rb_pid_data.
+type PerfRbPidData struct {
+	Offsets       PerfRbOffsetConfig
+	Current       uint64
+	CollectKernel uint8
+	Padding       [7]uint8
+}
+
+// PerfRbSymbol mirrors rb_symbol.
+type PerfRbSymbol struct {
+	Label   [64]byte
+	Path    [128]byte
+	Lineno  uint32
+	Padding uint32
+}
+
+// CString returns the string of a nul terminated char array.
+func CString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,99 @@
+package ruby
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Version struct {
+	Major, Minor, Patch int
+}
+
+func (p *Version) Compare(other *Version) int {
+	major := p.Major - other.Major
+	if major != 0 {
+		return major
+	}
+
+	minor := p.Minor - other.Minor
+	if minor != 0 {
+		return minor
+	}
+	return p.Patch - other.Patch
+}
+
+func (p *Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", p.Major, p.Minor, p.Patch)
+}
+
+// ParseVersion parses the ruby_version of a ruby binary, 3.3.0 for example.
+func ParseVersion(s string) (Version, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("invalid ruby version %q", s)
+	}
+	var v [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid ruby version %q", s)
+		}
+		v[i] = n
+	}
+	return Version{Major: v[0], Minor: v[1], Patch: v[2]}, nil
+}
+
+// GetOffsets returns the offsets of the VM structs of a ruby version. The
+// structs do not change in the patch releases, the offsets are the same for
+// all the patch versions of a minor version. The offsets are the same on
+// amd64 and arm64.
+func GetOffsets(version Version) (PerfRbOffsetConfig, error) {
+	o, ok := rubyVersions[Version{Major: version.Major, Minor: version.Minor}]
+	if !ok {
+		return PerfRbOffsetConfig{}, fmt.Errorf("unsupported ruby version %v", version.String())
+	}
+	return o, nil
+}
+
+var rubyVersions = map[Version]PerfRbOffsetConfig{
+	// the ec of the thread holding the GVL is ruby_current_execution_context_ptr
+	{Major: 2, Minor: 6}: rubyOffsets(-1, -1, 56, 16, true),
+	{Major: 2, Minor: 7}: rubyOffsets(-1, -1, 56, 16, true),
+	// ruby_current_vm_ptr->ractor.main_thread->ec
+	{Major: 3, Minor: 0}: rubyOffsets(40, 40, 56, 16, true),
+	// rb_control_frame_t has both __bp__ and jit_return
+	{Major: 3, Minor: 1}: rubyOffsets(40, 40, 64, 16, true),
+	// rb_thread_t.nt is added before ec, the strings are embedded after len
+	// and first_lineno is an int
+	{Major: 3, Minor: 2}: rubyOffsets(40, 48, 56, 24, false),
+	{Major: 3, Minor: 3}: rubyOffsets(40, 48, 56, 24, false),
+}
+
+// rubyOffsets returns the offsets of a version, the offsets not passed are
+// the same on all the supported versions.
+func rubyOffsets(vmMainThread, threadEC, cfpSize, rstringEmbed int16, firstLinenoFixnum bool) PerfRbOffsetConfig {
+	o := PerfRbOffsetConfig{
+		VMMainThread:        vmMainThread,
+		ThreadEC:            threadEC,
+		ECVMStack:           0,
+		ECVMStackSize:       8,
+		ECCfp:               16,
+		CfpPC:               0,
+		CfpIseq:             16,
+		CfpSize:             cfpSize,
+		IseqBody:            16,
+		BodyLocation:        64,
+		LocationPathobj:     0,
+		LocationLabel:       16,
+		LocationFirstLineno: 24,
+		RStringEmbed:        rstringEmbed,
+		RStringHeapPtr:      24,
+		RArrayEmbed:         16,
+		RArrayHeapPtr:       32,
+	}
+	if firstLinenoFixnum {
+		o.FirstLinenoFixnum = 1
+	}
+	return o
+}
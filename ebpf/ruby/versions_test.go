@@ -0,0 +1,45 @@
+package ruby
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("3.3.5")
+	require.NoError(t, err)
+	assert.Equal(t, Version{3, 3, 5}, v)
+
+	for _, s := range []string{"", "3.3", "3.3.x", "3.3.0.1"} {
+		_, err = ParseVersion(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestGetOffsets(t *testing.T) {
+	o, err := GetOffsets(Version{3, 3, 5})
+	require.NoError(t, err)
+	assert.Equal(t, int16(48), o.ThreadEC)
+	assert.Equal(t, uint8(0), o.FirstLinenoFixnum)
+
+	o, err = GetOffsets(Version{3, 1, 0})
+	require.NoError(t, err)
+	assert.Equal(t, int16(64), o.CfpSize)
+
+	o, err = GetOffsets(Version{2, 7, 8})
+	require.NoError(t, err)
+	assert.Equal(t, int16(-1), o.VMMainThread)
+
+	_, err = GetOffsets(Version{2, 5, 9})
+	require.Error(t, err)
+}
+
+func TestStructSizes(t *testing.T) {
+	// the sizes of the structs of bpf/rbperf.h
+	assert.Equal(t, uintptr(40), unsafe.Sizeof(PerfRbOffsetConfig{}))
+	assert.Equal(t, uintptr(56), unsafe.Sizeof(PerfRbPidData{}))
+	assert.Equal(t, uintptr(200), unsafe.Sizeof(PerfRbSymbol{}))
+}
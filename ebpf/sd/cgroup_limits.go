@@ -0,0 +1,130 @@
+package sd
+
+import (
+	"bufio"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const (
+	LabelCPUQuota    = "cpu_quota_us"
+	LabelCPUPeriod   = "cpu_period_us"
+	LabelMemoryLimit = "memory_limit_bytes"
+
+	// resourceLimitsRefreshInterval is how often the cgroup limits of the
+	// container targets are read again, to follow the resizes.
+	resourceLimitsRefreshInterval = time.Minute
+
+	// cgroup v1 reports no memory limit as the maximum page aligned int64
+	memoryUnlimited = 1 << 62
+)
+
+// resourceLimits are the cgroup limits of a container. The empty values are
+// the unlimited ones.
+type resourceLimits struct {
+	cpuQuota    string
+	cpuPeriod   string
+	memoryLimit string
+}
+
+func (l resourceLimits) apply(t *Target) *Target {
+	b := labels.NewBuilder(t.labels)
+	b.Set(LabelCPUQuota, l.cpuQuota)
+	b.Set(LabelCPUPeriod, l.cpuPeriod)
+	b.Set(LabelMemoryLimit, l.memoryLimit)
+	return &Target{
+		labels:      b.Labels(),
+		serviceName: t.serviceName,
+	}
+}
+
+// limitsTarget is a container target with the labels of its cgroup limits.
+type limitsTarget struct {
+	base    *Target
+	target  *Target
+	limits  resourceLimits
+	checked time.Time
+}
+
+// withResourceLimits returns the target of the container with the labels of
+// its cgroup limits, read from the cgroup of the pid.
+func (tf *targetFinder) withResourceLimits(pid uint32, cid containerID, t *Target) *Target {
+	lt := tf.cid2limits[cid]
+	now := time.Now()
+	if lt != nil && lt.base == t && now.Sub(lt.checked) < resourceLimitsRefreshInterval {
+		return lt.target
+	}
+	limits, err := readResourceLimits(tf.fs, pid)
+	if err != nil {
+		if lt != nil && lt.base == t {
+			return lt.target
+		}
+		return t
+	}
+	if lt != nil && lt.base == t && lt.limits == limits {
+		lt.checked = now
+		return lt.target
+	}
+	lt = &limitsTarget{base: t, target: limits.apply(t), limits: limits, checked: now}
+	tf.cid2limits[cid] = lt
+	return lt.target
+}
+
+// readResourceLimits reads the CPU quota and period, and the memory limit of
+// the cgroup of the pid, from the cgroup v2 or v1 hierarchies.
+func readResourceLimits(fsys fs.FS, pid uint32) (resourceLimits, error) {
+	f, err := fsys.Open(procPath(pid, "cgroup"))
+	if err != nil {
+		return resourceLimits{}, err
+	}
+	defer f.Close()
+
+	var limits resourceLimits
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		controllers, path := parts[1], parts[2]
+		if parts[0] == "0" && controllers == "" {
+			// cgroup v2
+			dir := "sys/fs/cgroup" + path
+			if fields := strings.Fields(readCgroupFile(fsys, dir+"/cpu.max")); len(fields) == 2 && fields[0] != "max" {
+				limits.cpuQuota, limits.cpuPeriod = fields[0], fields[1]
+			}
+			if v := readCgroupFile(fsys, dir+"/memory.max"); v != "max" {
+				limits.memoryLimit = v
+			}
+			continue
+		}
+		for _, c := range strings.Split(controllers, ",") {
+			dir := "sys/fs/cgroup/" + controllers + path
+			switch c {
+			case "cpu":
+				if quota := readCgroupFile(fsys, dir+"/cpu.cfs_quota_us"); quota != "" && quota != "-1" {
+					limits.cpuQuota = quota
+					limits.cpuPeriod = readCgroupFile(fsys, dir+"/cpu.cfs_period_us")
+				}
+			case "memory":
+				v := readCgroupFile(fsys, dir+"/memory.limit_in_bytes")
+				if n, err := strconv.ParseUint(v, 10, 64); err == nil && n < memoryUnlimited {
+					limits.memoryLimit = v
+				}
+			}
+		}
+	}
+	return limits, scanner.Err()
+}
+
+func readCgroupFile(fsys fs.FS, path string) string {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,67 @@
+package sd
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/grafana/pyroscope/ebpf/util"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testContainerID = "9a7c72f122922fe3445ba85ce72c507c8976c0f3d919403fda7c22dfe516f66f"
+
+func TestReadResourceLimits(t *testing.T) {
+	v2 := fstest.MapFS{
+		"proc/1/cgroup":                            {Data: []byte("0::/kubepods.slice/cri-containerd-" + testContainerID + ".scope\n")},
+		"proc/2/cgroup":                            {Data: []byte("0::/unlimited.scope\n")},
+		"sys/fs/cgroup/unlimited.scope/cpu.max":    {Data: []byte("max 100000\n")},
+		"sys/fs/cgroup/unlimited.scope/memory.max": {Data: []byte("max\n")},
+		"sys/fs/cgroup/kubepods.slice/cri-containerd-" + testContainerID + ".scope/cpu.max":    {Data: []byte("50000 100000\n")},
+		"sys/fs/cgroup/kubepods.slice/cri-containerd-" + testContainerID + ".scope/memory.max": {Data: []byte("536870912\n")},
+	}
+	limits, err := readResourceLimits(v2, 1)
+	require.NoError(t, err)
+	require.Equal(t, resourceLimits{cpuQuota: "50000", cpuPeriod: "100000", memoryLimit: "536870912"}, limits)
+	limits, err = readResourceLimits(v2, 2)
+	require.NoError(t, err)
+	require.Equal(t, resourceLimits{}, limits)
+
+	v1 := fstest.MapFS{
+		"proc/1/cgroup": {Data: []byte("12:blkio:/docker/" + testContainerID + "\n" +
+			"4:cpu,cpuacct:/docker/" + testContainerID + "\n" +
+			"9:memory:/docker/" + testContainerID + "\n")},
+		"sys/fs/cgroup/cpu,cpuacct/docker/" + testContainerID + "/cpu.cfs_quota_us":  {Data: []byte("200000\n")},
+		"sys/fs/cgroup/cpu,cpuacct/docker/" + testContainerID + "/cpu.cfs_period_us": {Data: []byte("100000\n")},
+		"sys/fs/cgroup/memory/docker/" + testContainerID + "/memory.limit_in_bytes":  {Data: []byte("9223372036854771712\n")},
+	}
+	limits, err = readResourceLimits(v1, 1)
+	require.NoError(t, err)
+	require.Equal(t, resourceLimits{cpuQuota: "200000", cpuPeriod: "100000"}, limits)
+}
+
+func TestTargetFinderResourceLimitsLabels(t *testing.T) {
+	fsys := fstest.MapFS{
+		"proc/1/cgroup": {Data: []byte("0::/kubepods.slice/cri-containerd-" + testContainerID + ".scope\n")},
+		"sys/fs/cgroup/kubepods.slice/cri-containerd-" + testContainerID + ".scope/cpu.max":    {Data: []byte("50000 100000\n")},
+		"sys/fs/cgroup/kubepods.slice/cri-containerd-" + testContainerID + ".scope/memory.max": {Data: []byte("max\n")},
+	}
+	tf, err := NewTargetFinder(fsys, util.TestLogger(t), TargetsOptions{
+		Targets: []DiscoveryTarget{{
+			"__container_id__": testContainerID,
+			"service_name":     "foo",
+		}},
+		TargetsOnly:          true,
+		ContainerCacheSize:   1024,
+		ResourceLimitsLabels: true,
+	})
+	require.NoError(t, err)
+
+	target := tf.FindTarget(1)
+	require.NotNil(t, target)
+	require.Equal(t, "50000", target.labels.Get(LabelCPUQuota))
+	require.Equal(t, "100000", target.labels.Get(LabelCPUPeriod))
+	require.Equal(t, "", target.labels.Get(LabelMemoryLimit))
+	require.Equal(t, "foo", target.ServiceName())
+	require.Same(t, target, tf.FindTarget(1))
+}
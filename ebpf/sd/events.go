@@ -0,0 +1,82 @@
+package sd
+
+import "github.com/prometheus/prometheus/model/labels"
+
+// TargetEventType is the kind of change of a target.
+type TargetEventType int
+
+const (
+	TargetAdded TargetEventType = iota
+	TargetUpdated
+	TargetRemoved
+)
+
+func (t TargetEventType) String() string {
+	switch t {
+	case TargetAdded:
+		return "added"
+	case TargetUpdated:
+		return "updated"
+	case TargetRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// TargetEvent is a change of the targets, made by TargetFinder.Update. Target
+// is the new target for the added and updated events, and the removed target
+// for the removed ones.
+type TargetEvent struct {
+	Type   TargetEventType
+	Target *Target
+}
+
+// TargetEventsCallback receives the changes of an update. It is called
+// after the update is applied, with no lock of the TargetFinder held, so it
+// may call the TargetFinder. It should not block.
+type TargetEventsCallback func(events []TargetEvent)
+
+type subscription struct {
+	cb TargetEventsCallback
+}
+
+// Subscribe registers the callback to be called with the changes of each
+// update. The returned function unsubscribes it.
+func (tf *targetFinder) Subscribe(cb TargetEventsCallback) func() {
+	s := &subscription{cb: cb}
+	tf.sync.Lock()
+	defer tf.sync.Unlock()
+	tf.subscriptions = append(tf.subscriptions, s)
+	return func() {
+		tf.sync.Lock()
+		defer tf.sync.Unlock()
+		for i, it := range tf.subscriptions {
+			if it == s {
+				tf.subscriptions = append(tf.subscriptions[:i:i], tf.subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// diffTargets returns the changes between the targets, keyed by the
+// container id or the pid.
+func diffTargets[K comparable](prev, next map[K]*Target, events []TargetEvent) []TargetEvent {
+	for k, t := range next {
+		p, ok := prev[k]
+		if !ok {
+			events = append(events, TargetEvent{Type: TargetAdded, Target: t})
+		} else if labels.Equal(p.labels, t.labels) {
+			// keep the previous target, so the targets are stable
+			next[k] = p
+		} else {
+			events = append(events, TargetEvent{Type: TargetUpdated, Target: t})
+		}
+	}
+	for k, p := range prev {
+		if _, ok := next[k]; !ok {
+			events = append(events, TargetEvent{Type: TargetRemoved, Target: p})
+		}
+	}
+	return events
+}
@@ -0,0 +1,57 @@
+package sd
+
+// LabelTenantID is the label of the tenant the profiles of the target are
+// sent to. It is set by the NamespaceDefaults and is not a label of the
+// profiles: the agent sends the profiles to the tenant and drops it.
+const LabelTenantID = "__tenant_id__"
+
+const labelKubernetesNamespace = "__meta_kubernetes_namespace"
+
+// NamespaceDefaults are the default labels and the tenant of the targets of
+// a kubernetes namespace, for example the team owning the namespace.
+type NamespaceDefaults struct {
+	Namespace string
+	// Labels are added to the targets of the namespace, the labels of the
+	// targets take precedence.
+	Labels map[string]string
+	// TenantID, if set, is the LabelTenantID of the targets, unless they
+	// have one already.
+	TenantID string
+}
+
+// withNamespaceDefaults returns a copy of the target with the default labels
+// of its kubernetes namespace.
+func withNamespaceDefaults(target DiscoveryTarget, defaults map[string]*NamespaceDefaults) DiscoveryTarget {
+	if len(defaults) == 0 {
+		return target
+	}
+	d := defaults[target[labelKubernetesNamespace]]
+	if d == nil {
+		return target
+	}
+	res := make(DiscoveryTarget, len(target)+len(d.Labels)+1)
+	for k, v := range d.Labels {
+		res[k] = v
+	}
+	if d.TenantID != "" {
+		res[LabelTenantID] = d.TenantID
+	}
+	for k, v := range target {
+		res[k] = v
+	}
+	return res
+}
+
+func namespaceDefaultsByName(defaults []NamespaceDefaults) map[string]*NamespaceDefaults {
+	if len(defaults) == 0 {
+		return nil
+	}
+	res := make(map[string]*NamespaceDefaults, len(defaults))
+	for i := range defaults {
+		// the first defaults of a namespace win
+		if _, ok := res[defaults[i].Namespace]; !ok {
+			res[defaults[i].Namespace] = &defaults[i]
+		}
+	}
+	return res
+}
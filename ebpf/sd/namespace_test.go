@@ -0,0 +1,42 @@
+package sd
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/ebpf/util"
+)
+
+func TestNamespaceDefaults(t *testing.T) {
+	options := TargetsOptions{
+		Targets: []DiscoveryTarget{
+			{"__process_pid__": "1", "__meta_kubernetes_namespace": "payments", "service_name": "checkout"},
+			{"__process_pid__": "2", "__meta_kubernetes_namespace": "payments", "team": "billing", "__tenant_id__": "billing"},
+			{"__process_pid__": "3", "__meta_kubernetes_namespace": "default", "service_name": "web"},
+		},
+		TargetsOnly:        true,
+		ContainerCacheSize: 1024,
+		NamespaceDefaults: []NamespaceDefaults{
+			{Namespace: "payments", Labels: map[string]string{"team": "payments"}, TenantID: "payments"},
+		},
+	}
+	tf, err := NewTargetFinder(fstest.MapFS{}, util.TestLogger(t), options)
+	require.NoError(t, err)
+
+	target := tf.FindTarget(1)
+	require.Equal(t, "payments", target.labels.Get("team"))
+	require.Equal(t, "payments", target.labels.Get(LabelTenantID))
+	require.Equal(t, "checkout", target.labels.Get("service_name"))
+
+	// the labels of the target take precedence
+	target = tf.FindTarget(2)
+	require.Equal(t, "billing", target.labels.Get("team"))
+	require.Equal(t, "billing", target.labels.Get(LabelTenantID))
+
+	target = tf.FindTarget(3)
+	require.Empty(t, target.labels.Get("team"))
+	require.Empty(t, target.labels.Get(LabelTenantID))
+	require.Len(t, options.Targets[0], 3)
+}
@@ -0,0 +1,104 @@
+package sd
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	labelK8sNamespace      = "__meta_kubernetes_namespace"
+	labelK8sPodName        = "__meta_kubernetes_pod_name"
+	labelK8sPodUID         = "__meta_kubernetes_pod_uid"
+	labelK8sControllerName = "__meta_kubernetes_pod_controller_name"
+	labelK8sContainerMeta  = "__meta_kubernetes_pod_container_"
+)
+
+// podServiceNameLabels are the pod labels the service name of a pod target is
+// taken from, in order of preference.
+var podServiceNameLabels = []string{
+	labelServiceNameK8s,
+	"__meta_kubernetes_pod_label_app_kubernetes_io_name",
+	"__meta_kubernetes_pod_label_app",
+}
+
+// aggregatePods merges the container targets of each pod into one pod target.
+// The pod target has the labels all the containers of the pod have in common,
+// and the service name of the pod. The targets not from kubernetes are
+// returned as is.
+func aggregatePods(targets []DiscoveryTarget) []DiscoveryTarget {
+	res := make([]DiscoveryTarget, 0, len(targets))
+	pods := make(map[string]int)
+	for _, target := range targets {
+		key := podKey(target)
+		if key == "" || pidFromTarget(target) != 0 || containerIDFromTarget(target) == "" {
+			res = append(res, target)
+			continue
+		}
+		i, ok := pods[key]
+		if !ok {
+			pods[key] = len(res)
+			res = append(res, podTarget(target))
+			continue
+		}
+		pod := res[i]
+		for k, v := range pod {
+			if k != labelServiceName && !strings.HasPrefix(k, labelContainerIDs) && target[k] != v {
+				delete(pod, k)
+			}
+		}
+		pod[labelContainerIDs+string(containerIDFromTarget(target))] = ""
+	}
+	return res
+}
+
+// labelContainerIDs prefixes the ids of the containers of a pod target. They
+// are reserved labels, not added to the profiles.
+const labelContainerIDs = "__pod_container_id_"
+
+func podTarget(target DiscoveryTarget) DiscoveryTarget {
+	pod := make(DiscoveryTarget, len(target))
+	for k, v := range target {
+		if k == labelContainerID || k == labelServiceName || strings.HasPrefix(k, labelK8sContainerMeta) {
+			continue
+		}
+		pod[k] = v
+	}
+	pod[labelServiceName] = podServiceName(target)
+	pod[labelContainerIDs+string(containerIDFromTarget(target))] = ""
+	return pod
+}
+
+func podKey(target DiscoveryTarget) string {
+	if uid := target[labelK8sPodUID]; uid != "" {
+		return uid
+	}
+	ns, name := target[labelK8sNamespace], target[labelK8sPodName]
+	if ns == "" || name == "" {
+		return ""
+	}
+	return ns + "/" + name
+}
+
+func podServiceName(target DiscoveryTarget) string {
+	for _, l := range podServiceNameLabels {
+		if v := target[l]; v != "" {
+			return v
+		}
+	}
+	name := target[labelK8sControllerName]
+	if name == "" {
+		name = target[labelK8sPodName]
+	}
+	return fmt.Sprintf("ebpf/%s/%s", target[labelK8sNamespace], name)
+}
+
+// podContainerIDs returns the ids of the containers of a pod target.
+func podContainerIDs(target DiscoveryTarget) []containerID {
+	var res []containerID
+	for k := range target {
+		if cid, ok := strings.CutPrefix(k, labelContainerIDs); ok {
+			res = append(res, containerID(cid))
+		}
+	}
+	return res
+}
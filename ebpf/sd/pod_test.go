@@ -0,0 +1,68 @@
+package sd
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/grafana/pyroscope/ebpf/util"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregatePods(t *testing.T) {
+	const (
+		appID   = "9a7c72f122922fe3445ba85ce72c507c8976c0f3d919403fda7c22dfe516f66f"
+		envoyID = "57ac76ffc93d7e7735ca186bc67115656967fc8aecbe1f65526c4c48b033e6a5"
+		otherID = "a534eb629135e43beb13213976e37bb2ab95cba4c0d1d0b4e27c6bc4d8091b83"
+	)
+	container := func(cid, name string) DiscoveryTarget {
+		return DiscoveryTarget{
+			"__meta_kubernetes_pod_container_id":                 "containerd://" + cid,
+			"__meta_kubernetes_pod_container_name":               name,
+			"__meta_kubernetes_namespace":                        "shop",
+			"__meta_kubernetes_pod_name":                         "checkout-7d9f",
+			"__meta_kubernetes_pod_uid":                          "7e5f5ac0-1af4-49ab-8938-664970a26cfd",
+			"__meta_kubernetes_pod_label_app_kubernetes_io_name": "checkout",
+			"namespace": "shop",
+			"container": name,
+		}
+	}
+	fsys := fstest.MapFS{
+		"proc/1/cgroup": {Data: []byte("0::/kubepods/burstable/pod7e5f5ac0/" + appID + "\n")},
+		"proc/2/cgroup": {Data: []byte("0::/kubepods/burstable/pod7e5f5ac0/" + envoyID + "\n")},
+		"proc/3/cgroup": {Data: []byte("0::/docker/" + otherID + "\n")},
+	}
+	tf, err := NewTargetFinder(fsys, util.TestLogger(t), TargetsOptions{
+		Targets: []DiscoveryTarget{
+			container(appID, "app"),
+			container(envoyID, "istio-proxy"),
+			{"__container_id__": otherID, "service_name": "other"},
+		},
+		TargetsOnly:        true,
+		ContainerCacheSize: 1024,
+		AggregatePods:      true,
+	})
+	require.NoError(t, err)
+
+	app, envoy := tf.FindTarget(1), tf.FindTarget(2)
+	require.NotNil(t, app)
+	require.Same(t, app, envoy)
+	require.Equal(t, `{__name__="process_cpu", namespace="shop", service_name="checkout"}`, app.String())
+
+	other := tf.FindTarget(3)
+	require.NotNil(t, other)
+	require.Equal(t, "other", other.ServiceName())
+	require.Equal(t, otherID, other.labels.Get(labelContainerID))
+}
+
+func TestPodServiceName(t *testing.T) {
+	require.Equal(t, "ebpf/shop/checkout", podServiceName(DiscoveryTarget{
+		"__meta_kubernetes_namespace":           "shop",
+		"__meta_kubernetes_pod_name":            "checkout-7d9f",
+		"__meta_kubernetes_pod_controller_name": "checkout",
+	}))
+	require.Equal(t, "payments", podServiceName(DiscoveryTarget{
+		"__meta_kubernetes_namespace":     "shop",
+		"__meta_kubernetes_pod_label_app": "payments",
+	}))
+}
@@ -2,8 +2,10 @@ package sd
 
 import (
 	"bufio"
-	"fmt"
 	"regexp"
+	"strings"
+
+	"github.com/grafana/pyroscope/ebpf/procfs"
 )
 
 var (
@@ -12,7 +14,7 @@ var (
 )
 
 func (tf *targetFinder) getContainerIDFromPID(pid uint32) containerID {
-	f, err := tf.fs.Open(fmt.Sprintf("proc/%d/cgroup", pid))
+	f, err := tf.fs.Open(procPath(pid, "cgroup"))
 	if err != nil {
 		return ""
 	}
@@ -29,6 +31,12 @@ func (tf *targetFinder) getContainerIDFromPID(pid uint32) containerID {
 	return ""
 }
 
+// procPath returns the path of the file of the process in the procfs,
+// relative to the root of the fs of the target finder.
+func procPath(pid uint32, name string) string {
+	return strings.TrimPrefix(procfs.Path(pid, name), "/")
+}
+
 func getContainerIDFromCGroup(line []byte) string {
 	matches := cgroupContainerIDRe.FindSubmatch(line)
 	if len(matches) <= 1 {
@@ -0,0 +1,47 @@
+package sd
+
+import (
+	"io/fs"
+	"runtime"
+	"strings"
+)
+
+// LabelResourceAttributePrefix is the prefix of the target labels carrying
+// the metadata of the host and of the agent, such as the kernel release.
+// Pyroscope stores these labels as resource attributes of the profiles,
+// which can be queried but are not indexed: they do not create series.
+const LabelResourceAttributePrefix = "__resource_"
+
+const (
+	ResourceAttributeHostKernelRelease = "host_kernel_release"
+	ResourceAttributeHostArch          = "host_arch"
+	ResourceAttributeAgentVersion      = "agent_version"
+)
+
+// HostResourceAttributes returns the resource attributes of the host, read
+// from the procfs of the file system.
+func HostResourceAttributes(fsys fs.FS) map[string]string {
+	attrs := map[string]string{
+		ResourceAttributeHostArch: runtime.GOARCH,
+	}
+	if release, err := fs.ReadFile(fsys, "proc/sys/kernel/osrelease"); err == nil {
+		attrs[ResourceAttributeHostKernelRelease] = strings.TrimSpace(string(release))
+	}
+	return attrs
+}
+
+// withResourceAttributes returns a copy of the target with the resource
+// attributes labels. The labels of the target take precedence.
+func withResourceAttributes(target DiscoveryTarget, attrs map[string]string) DiscoveryTarget {
+	if len(attrs) == 0 {
+		return target
+	}
+	res := make(DiscoveryTarget, len(target)+len(attrs))
+	for k, v := range attrs {
+		res[LabelResourceAttributePrefix+k] = v
+	}
+	for k, v := range target {
+		res[k] = v
+	}
+	return res
+}
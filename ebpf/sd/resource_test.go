@@ -0,0 +1,46 @@
+package sd
+
+import (
+	"runtime"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/ebpf/util"
+)
+
+func TestHostResourceAttributes(t *testing.T) {
+	attrs := HostResourceAttributes(fstest.MapFS{
+		"proc/sys/kernel/osrelease": {Data: []byte("6.8.0-45-generic\n")},
+	})
+	require.Equal(t, map[string]string{
+		ResourceAttributeHostKernelRelease: "6.8.0-45-generic",
+		ResourceAttributeHostArch:          runtime.GOARCH,
+	}, attrs)
+}
+
+func TestTargetResourceAttributes(t *testing.T) {
+	options := TargetsOptions{
+		Targets: []DiscoveryTarget{
+			{"__process_pid__": "239", "service_name": "a", "__resource_agent_version": "v1.2.3"},
+		},
+		DefaultTarget:      DiscoveryTarget{"service_name": "default"},
+		ContainerCacheSize: 1024,
+		ResourceAttributes: map[string]string{
+			ResourceAttributeHostKernelRelease: "6.8.0-45-generic",
+			ResourceAttributeAgentVersion:      "v1.0.0",
+		},
+	}
+	tf, err := NewTargetFinder(fstest.MapFS{}, util.TestLogger(t), options)
+	require.NoError(t, err)
+
+	target := tf.FindTarget(239)
+	require.Equal(t, "6.8.0-45-generic", target.labels.Get("__resource_host_kernel_release"))
+	require.Equal(t, "v1.2.3", target.labels.Get("__resource_agent_version"))
+
+	target = tf.FindTarget(1)
+	require.Equal(t, "default", target.labels.Get("service_name"))
+	require.Equal(t, "v1.0.0", target.labels.Get("__resource_agent_version"))
+	require.Len(t, options.Targets[0], 3)
+}
@@ -40,10 +40,15 @@ const (
 	OptionGoTableFallback          = labelMetaPyroscopeOptionsPrefix + "go_table_fallback"
 	OptionCollectKernel            = labelMetaPyroscopeOptionsPrefix + "collect_kernel"
 	OptionPythonFullFilePath       = labelMetaPyroscopeOptionsPrefix + "python_full_file_path"
+	OptionPythonQualnames          = labelMetaPyroscopeOptionsPrefix + "python_qualnames"
 	OptionPythonEnabled            = labelMetaPyroscopeOptionsPrefix + "python_enabled"
 	OptionPythonBPFDebugLogEnabled = labelMetaPyroscopeOptionsPrefix + "python_bpf_debug_log"
 	OptionPythonBPFErrorLogEnabled = labelMetaPyroscopeOptionsPrefix + "python_bpf_error_log"
+	OptionRubyEnabled              = labelMetaPyroscopeOptionsPrefix + "ruby_enabled"
 	OptionDemangle                 = labelMetaPyroscopeOptionsPrefix + "demangle"
+	OptionThreadNameRegex          = labelMetaPyroscopeOptionsPrefix + "thread_name_regex"
+	OptionFrameDropRegex           = labelMetaPyroscopeOptionsPrefix + "frame_drop_regex"
+	OptionFrameTrimRegex           = labelMetaPyroscopeOptionsPrefix + "frame_trim_regex"
 )
 
 type Target struct {
@@ -69,7 +74,9 @@ func NewTarget(cid containerID, pid uint32, target DiscoveryTarget) *Target {
 	for k, v := range target {
 		if strings.HasPrefix(k, model.ReservedLabelPrefix) &&
 			k != labels.MetricName &&
-			!strings.HasPrefix(k, labelMetaPyroscopeOptionsPrefix) {
+			!strings.HasPrefix(k, labelMetaPyroscopeOptionsPrefix) &&
+			!strings.HasPrefix(k, LabelResourceAttributePrefix) &&
+			k != LabelTenantID {
 			continue
 		}
 		lset[k] = v
@@ -148,12 +155,28 @@ type TargetFinder interface {
 	RemoveDeadPID(pid uint32)
 	DebugInfo() []map[string]string
 	Update(args TargetsOptions)
+	Subscribe(cb TargetEventsCallback) func()
 }
 type TargetsOptions struct {
 	Targets            []DiscoveryTarget
 	TargetsOnly        bool
 	DefaultTarget      DiscoveryTarget
 	ContainerCacheSize int
+	// ResourceLimitsLabels adds the cpu_quota_us, cpu_period_us and
+	// memory_limit_bytes labels of the cgroup limits to the container targets.
+	ResourceLimitsLabels bool
+	// AggregatePods merges the targets of the containers of a kubernetes pod
+	// into one target, with the service name of the pod, and the labels the
+	// containers have in common. The pod targets have no resource limits
+	// labels.
+	AggregatePods bool
+	// ResourceAttributes are added to all the targets as resource attributes
+	// labels, e.g., the HostResourceAttributes and the agent version. The
+	// names are without the LabelResourceAttributePrefix.
+	ResourceAttributes map[string]string
+	// NamespaceDefaults are the default labels and the tenants of the targets
+	// by kubernetes namespace.
+	NamespaceDefaults []NamespaceDefaults
 }
 
 type targetFinder struct {
@@ -166,6 +189,11 @@ type targetFinder struct {
 	defaultTarget    *Target
 	fs               fs.FS
 
+	resourceLimitsLabels bool
+	cid2limits           map[containerID]*limitsTarget
+
+	subscriptions []*subscription
+
 	sync sync.Mutex
 }
 
@@ -178,6 +206,7 @@ func NewTargetFinder(fs fs.FS, l log.Logger, options TargetsOptions) (TargetFind
 		l:                l,
 		containerIDCache: containerIDCache,
 		fs:               fs,
+		cid2limits:       make(map[containerID]*limitsTarget),
 	}
 	res.setTargets(options)
 	return res, nil
@@ -202,17 +231,37 @@ func (tf *targetFinder) RemoveDeadPID(pid uint32) {
 
 func (tf *targetFinder) Update(args TargetsOptions) {
 	tf.sync.Lock()
-	defer tf.sync.Unlock()
-	tf.setTargets(args)
+	events := tf.setTargets(args)
 	tf.resizeContainerIDCache(args.ContainerCacheSize)
+	subscriptions := tf.subscriptions
+	tf.sync.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+	for _, s := range subscriptions {
+		s.cb(events)
+	}
 }
 
-func (tf *targetFinder) setTargets(opts TargetsOptions) {
+func (tf *targetFinder) setTargets(opts TargetsOptions) []TargetEvent {
 	_ = level.Debug(tf.l).Log("msg", "set targets", "count", len(opts.Targets))
 	containerID2Target := make(map[containerID]*Target)
 	pid2Target := make(map[uint32]*Target)
-	for _, target := range opts.Targets {
-		if pid := pidFromTarget(target); pid != 0 {
+	targets := opts.Targets
+	if opts.AggregatePods {
+		targets = aggregatePods(targets)
+	}
+	namespaceDefaults := namespaceDefaultsByName(opts.NamespaceDefaults)
+	for _, target := range targets {
+		target = withResourceAttributes(target, opts.ResourceAttributes)
+		target = withNamespaceDefaults(target, namespaceDefaults)
+		if cids := podContainerIDs(target); len(cids) > 0 {
+			t := NewTarget("", 0, target)
+			for _, cid := range cids {
+				containerID2Target[cid] = t
+			}
+		} else if pid := pidFromTarget(target); pid != 0 {
 			t := NewTarget("", pid, target)
 			pid2Target[pid] = t
 		} else if cid := containerIDFromTarget(target); cid != "" {
@@ -223,15 +272,24 @@ func (tf *targetFinder) setTargets(opts TargetsOptions) {
 	if len(opts.Targets) > 0 && len(containerID2Target) == 0 && len(pid2Target) == 0 {
 		_ = level.Warn(tf.l).Log("msg", "No targets found")
 	}
+	events := diffTargets(tf.cid2target, containerID2Target, nil)
+	events = diffTargets(tf.pid2target, pid2Target, events)
 	tf.cid2target = containerID2Target
 	tf.pid2target = pid2Target
+	tf.resourceLimitsLabels = opts.ResourceLimitsLabels
+	for cid := range tf.cid2limits {
+		if _, ok := containerID2Target[cid]; !ok || !opts.ResourceLimitsLabels {
+			delete(tf.cid2limits, cid)
+		}
+	}
 	if opts.TargetsOnly {
 		tf.defaultTarget = nil
 	} else {
-		t := NewTarget("", 0, opts.DefaultTarget)
+		t := NewTarget("", 0, withResourceAttributes(opts.DefaultTarget, opts.ResourceAttributes))
 		tf.defaultTarget = t
 	}
-	_ = level.Debug(tf.l).Log("msg", "created targets", "cid2target", len(tf.cid2target), "pid2target", len(tf.pid2target))
+	_ = level.Debug(tf.l).Log("msg", "created targets", "cid2target", len(tf.cid2target), "pid2target", len(tf.pid2target), "changes", len(events))
+	return events
 }
 
 func (tf *targetFinder) findTarget(pid uint32) *Target {
@@ -239,13 +297,15 @@ func (tf *targetFinder) findTarget(pid uint32) *Target {
 		return target
 	}
 	cid, ok := tf.containerIDCache.Get(pid)
-	if ok {
-		return tf.cid2target[cid]
+	if !ok {
+		cid = tf.getContainerIDFromPID(pid)
+		tf.containerIDCache.Add(pid, cid)
 	}
-
-	cid = tf.getContainerIDFromPID(pid)
-	tf.containerIDCache.Add(pid, cid)
-	return tf.cid2target[cid]
+	target := tf.cid2target[cid]
+	if target != nil && tf.resourceLimitsLabels && target.labels.Has(labelContainerID) {
+		return tf.withResourceLimits(pid, cid, target)
+	}
+	return target
 }
 
 func (tf *targetFinder) resizeContainerIDCache(size int) {
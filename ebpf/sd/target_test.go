@@ -5,7 +5,9 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
+	"testing/fstest"
 
 	"github.com/grafana/pyroscope/ebpf/util"
 
@@ -183,3 +185,41 @@ func TestPreferPIDOverContainerID(t *testing.T) {
 	require.Equal(t, "ebpf/foo/bar", target.labels.Get("service_name"))
 	require.Equal(t, "/bin/dash", target.labels.Get("exe"))
 }
+
+func TestTargetEvents(t *testing.T) {
+	options := TargetsOptions{
+		Targets: []DiscoveryTarget{
+			{"__container_id__": "a", "service_name": "a"},
+			{"__container_id__": "b", "service_name": "b"},
+			{"__process_pid__": "239", "service_name": "c"},
+		},
+		TargetsOnly:        true,
+		ContainerCacheSize: 1024,
+	}
+	tf, err := NewTargetFinder(fstest.MapFS{}, util.TestLogger(t), options)
+	require.NoError(t, err)
+
+	var events []string
+	unsubscribe := tf.Subscribe(func(es []TargetEvent) {
+		for _, e := range es {
+			events = append(events, e.Type.String()+" "+e.Target.ServiceName())
+		}
+	})
+	tf.Update(options)
+	require.Empty(t, events)
+
+	options.Targets = []DiscoveryTarget{
+		{"__container_id__": "a", "service_name": "a"},
+		{"__container_id__": "b", "service_name": "b", "version": "2"},
+		{"__container_id__": "d", "service_name": "d"},
+	}
+	tf.Update(options)
+	slices.Sort(events)
+	require.Equal(t, []string{"added d", "removed c", "updated b"}, events)
+
+	unsubscribe()
+	events = nil
+	options.Targets = nil
+	tf.Update(options)
+	require.Empty(t, events)
+}
@@ -27,9 +27,11 @@ import (
 	"github.com/grafana/pyroscope/ebpf/cpuonline"
 	"github.com/grafana/pyroscope/ebpf/metrics"
 	"github.com/grafana/pyroscope/ebpf/pprof"
+	"github.com/grafana/pyroscope/ebpf/procfs"
 	"github.com/grafana/pyroscope/ebpf/pyrobpf"
 	"github.com/grafana/pyroscope/ebpf/python"
 	"github.com/grafana/pyroscope/ebpf/rlimit"
+	"github.com/grafana/pyroscope/ebpf/ruby"
 	"github.com/grafana/pyroscope/ebpf/sd"
 	"github.com/grafana/pyroscope/ebpf/symtab"
 	"github.com/samber/lo"
@@ -41,6 +43,7 @@ type SessionOptions struct {
 	UnknownSymbolModuleOffset bool // use libfoo.so+0xef instead of libfoo.so for unknown symbols
 	UnknownSymbolAddress      bool // use 0xcafebabe instead of [unknown]
 	PythonEnabled             bool
+	RubyEnabled               bool
 	CacheOptions              symtab.CacheOptions
 	SymbolOptions             symtab.SymbolOptions
 	Metrics                   *metrics.Metrics
@@ -49,11 +52,55 @@ type SessionOptions struct {
 	PythonBPFErrorLogEnabled  bool
 	PythonBPFDebugLogEnabled  bool
 	BPFMapsOptions            BPFMapsOptions
+	PressureTrigger           PressureTriggerOptions
+	// ProcFSRoot is the procfs the pids of the processes are resolved in,
+	// /proc by default. See procfs.SetRoot, it is process wide.
+	ProcFSRoot string
+	// ThrottlingProfileEnabled enables the profile of the CPU throttled time
+	// of the targets, by the stack running when the CFS throttling began.
+	// The session fails to start if the profile can't be loaded.
+	ThrottlingProfileEnabled bool
+	// OffCPUEnabled enables the profile of the time the threads of the
+	// targets spend off-CPU, blocked on I/O, locks and syscalls or waiting
+	// for a CPU, by the stack they were switched out in. The sched_switch
+	// tracepoint fires on every context switch of the host. The session fails
+	// to start if the profile can't be loaded.
+	OffCPUEnabled bool
+	// OffCPUThresholdUs is the shortest off-CPU time, in microseconds,
+	// recorded in the off-CPU profile.
+	OffCPUThresholdUs uint32
+	// DWARFUnwinding enables the unwinding of the user stacks of the binaries
+	// compiled without frame pointers with their .eh_frame unwind tables.
+	DWARFUnwinding DWARFUnwindingOptions
+	// ProbeProfiles are the custom profiles of the hits of uprobes and USDT
+	// probes, see ProbeProfileOptions. The session fails to start if a probe
+	// can't be loaded or attached.
+	ProbeProfiles []ProbeProfileOptions
+	// TagKernelContext inserts a [task], [softirq] or [hardirq] frame above
+	// the kernel frames of the samples.
+	TagKernelContext bool
+	// SoftIRQPseudoTarget attributes the samples taken in softirq context to
+	// the kernel/softirq service of the host, instead of the interrupted
+	// process.
+	SoftIRQPseudoTarget bool
+	// RawSamples delivers each sample as it is taken, in addition to the
+	// profiles.
+	RawSamples RawSamplesOptions
+	// Collect is how often the owner of the session collects the profiles.
+	Collect CollectOptions
+	// DropCapabilities drops the capabilities of the process once the BPF
+	// objects are loaded and attached, keeping only the ones needed to read
+	// the maps and the processes. It requires a binary built without cgo.
+	DropCapabilities bool
 }
 
 type BPFMapsOptions struct {
 	PIDMapSize     uint32
 	SymbolsMapSize uint32
+	// PinPath is a directory on a bpffs, for example /sys/fs/bpf/pyroscope,
+	// the maps of the samples not collected yet are pinned in, to keep them
+	// across the restarts of the profiler. The maps are not unpinned on stop.
+	PinPath string
 }
 
 type Session interface {
@@ -70,6 +117,8 @@ type SessionDebugInfo struct {
 	PidCache symtab.GCacheDebugInfo[symtab.ProcTableDebugInfo] `alloy:"pid_cache,attr,optional" river:"pid_cache,attr,optional"`
 	Arch     string                                            `alloy:"arch,attr" river:"arch,attr"`
 	Kernel   string                                            `alloy:"kernel,attr" river:"kernel,attr"`
+	Unwind   []UnwindDebugInfo                                 `alloy:"unwind,block,optional" river:"unwind,block,optional"`
+	BuildIDs []symtab.BuildIDInventoryEntry                    `alloy:"build_ids,block,optional" river:"build_ids,block,optional"`
 }
 
 type pids struct {
@@ -97,6 +146,8 @@ type session struct {
 
 	options     SessionOptions
 	roundNumber int
+	// sampleRate is the current sample rate of the perf events
+	sampleRate int
 
 	// all the Session methods should be guarded by mutex
 	// all the goroutines accessing fields should be guarded by mutex and check for started field
@@ -116,8 +167,36 @@ type session struct {
 	pyperfBpf    python.PerfObjects
 	pyperfError  error
 
+	rbperf      *ruby.Perf
+	rbperfBpf   rbperfObjects
+	rbperfError error
+
+	throttleBpf throttleObjects
+
+	offCPUBpf offCPUObjects
+
+	dwarf dwarfUnwinder
+
+	probesBpf probeObjects
+	probes    []probe
+
+	threads       *ebpf.Map
+	threadFilters map[uint32]*threadFilter
+
+	rawSamples        *ebpf.Map
+	rawSamplesEnabled *ebpf.Map
+	rawSamplesReader  *perf.Reader
+
 	pids            pids
 	pidExecRequests chan uint32
+	// execTracepoint is set if the exec events come from the
+	// sched_process_exec tracepoint, after the new image is loaded.
+	execTracepoint bool
+
+	unwindStats *unwindStats
+	unwindStack unwindStack
+
+	unsubscribeTargets func()
 }
 
 func NewSession(
@@ -126,6 +205,7 @@ func NewSession(
 
 	sessionOptions SessionOptions,
 ) (Session, error) {
+	procfs.SetRoot(sessionOptions.ProcFSRoot)
 	symCache, err := symtab.NewSymbolCache(logger, sessionOptions.CacheOptions, sessionOptions.Metrics.Symtab)
 	if err != nil {
 		return nil, err
@@ -142,6 +222,8 @@ func NewSession(
 			dead:    make(map[uint32]struct{}),
 			all:     make(map[uint32]procInfoLite),
 		},
+		threadFilters: make(map[uint32]*threadFilter),
+		unwindStats:   newUnwindStats(),
 	}, nil
 }
 
@@ -181,7 +263,19 @@ func (s *session) Start() error {
 			return fmt.Errorf("pyrobpf rewrite constants %w", err)
 		}
 	}
-	err = spec.LoadAndAssign(&s.bpf, opts)
+	if err = s.loadThreadsMap(spec, opts); err != nil {
+		s.stopLocked()
+		return fmt.Errorf("create threads map: %w", err)
+	}
+	if err = s.loadRawSamplesMaps(spec, opts); err != nil {
+		s.stopLocked()
+		return fmt.Errorf("create raw samples maps: %w", err)
+	}
+	if err = s.loadUnwindMaps(spec, opts); err != nil {
+		s.stopLocked()
+		return fmt.Errorf("create unwind maps: %w", err)
+	}
+	err = s.loadAndAssignPinned(spec, pinnedProfileMaps, &s.bpf, opts)
 	if err != nil {
 		s.logVerifierError(err)
 		s.stopLocked()
@@ -195,19 +289,63 @@ func (s *session) Start() error {
 		s.stopLocked()
 		return fmt.Errorf("perf new reader for events map: %w", err)
 	}
-	s.perfEvents, err = attachPerfEvents(s.options.SampleRate, s.bpf.DoPerfEvent)
+	if s.rawSamples != nil {
+		s.rawSamplesReader, err = perf.NewReader(s.rawSamples, 16*os.Getpagesize())
+		if err != nil {
+			_ = eventsReader.Close()
+			s.stopLocked()
+			return fmt.Errorf("perf new reader for raw samples map: %w", err)
+		}
+	}
+	s.sampleRate = s.targetSampleRate()
+	s.perfEvents, err = attachPerfEvents(s.sampleRate, s.bpf.DoPerfEvent)
 	if err != nil {
 		s.stopLocked()
 		return fmt.Errorf("attach perf events: %w", err)
 	}
 
-	err = s.linkKProbes()
+	s.execTracepoint = true
+	if err = s.linkExecTracepointLocked(spec); err != nil {
+		_ = level.Warn(s.logger).Log("msg", "falling back to the execve kprobes, the caches are not primed on exec", "err", err)
+		s.execTracepoint = false
+	}
+	err = s.linkKProbes(!s.execTracepoint)
 	if err != nil {
 		s.stopLocked()
 		return fmt.Errorf("link kprobes: %w", err)
 	}
+	if s.options.ThrottlingProfileEnabled {
+		if err = s.loadThrottleLocked(spec); err != nil {
+			s.stopLocked()
+			return fmt.Errorf("load throttling profile: %w", err)
+		}
+	}
+	if s.options.OffCPUEnabled {
+		if err = s.loadOffCPULocked(spec); err != nil {
+			s.stopLocked()
+			return fmt.Errorf("load off-cpu profile: %w", err)
+		}
+	}
+	if s.options.DWARFUnwinding.Enabled {
+		if err = s.loadDWARFLocked(spec); err != nil {
+			s.stopLocked()
+			return fmt.Errorf("load dwarf unwinding: %w", err)
+		}
+	}
+	if len(s.options.ProbeProfiles) > 0 {
+		if err = s.loadProbesLocked(spec); err != nil {
+			s.stopLocked()
+			return fmt.Errorf("load probe profiles: %w", err)
+		}
+	}
 
 	s.eventsReader = eventsReader
+	if s.options.DropCapabilities {
+		if err = s.dropCapabilitiesLocked(); err != nil {
+			s.stopLocked()
+			return fmt.Errorf("drop capabilities: %w", err)
+		}
+	}
 	pidInfoRequests := make(chan uint32, 1024)
 	pidExecRequests := make(chan uint32, 1024)
 	deadPIDsEvents := make(chan uint32, 1024)
@@ -216,6 +354,7 @@ func (s *session) Start() error {
 	s.deadPIDEvents = deadPIDsEvents
 	s.wg.Add(4)
 	s.started = true
+	s.unsubscribeTargets = s.targetFinder.Subscribe(s.onTargetEvents)
 	go func() {
 		defer s.wg.Done()
 		s.readEvents(eventsReader, pidInfoRequests, pidExecRequests, deadPIDsEvents)
@@ -232,6 +371,14 @@ func (s *session) Start() error {
 		defer s.wg.Done()
 		s.processPIDExecRequests(pidExecRequests)
 	}()
+	if s.rawSamplesReader != nil {
+		rawSamplesReader, maxRate := s.rawSamplesReader, s.options.RawSamples.MaxRate
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.readRawSamples(rawSamplesReader, maxRate)
+		}()
+	}
 	return nil
 }
 
@@ -271,12 +418,32 @@ func (s *session) CollectProfiles(cb pprof.CollectProfilesCallback) error {
 	s.symCache.NextRound()
 	s.roundNumber++
 
-	err := s.collectRegularProfile(cb)
+	throttleStacks, err := s.collectThrottleProfile(cb)
+	if err != nil {
+		return fmt.Errorf("collect throttle profile: %w", err)
+	}
+	if throttleStacks == nil {
+		throttleStacks = map[uint32]bool{}
+	}
+	if err = s.collectOffCPUProfile(cb, throttleStacks); err != nil {
+		return fmt.Errorf("collect off-cpu profile: %w", err)
+	}
+	if err = s.collectProbeProfiles(cb, throttleStacks); err != nil {
+		return fmt.Errorf("collect probe profiles: %w", err)
+	}
+	err = s.collectRegularProfile(cb)
 	if err != nil {
 		return err
 	}
+	if len(throttleStacks) > 0 {
+		if err = s.clearStacksMap(throttleStacks, s.bpf.Stacks); err != nil {
+			return fmt.Errorf("clear stacks map %w", err)
+		}
+	}
 
 	s.cleanup()
+	s.refreshThreadFiltersLocked()
+	s.updateSampleRateLocked()
 
 	return nil
 }
@@ -290,6 +457,8 @@ func (s *session) DebugInfo() interface{} {
 		PidCache: s.symCache.PidCacheDebugInfo(),
 		Arch:     runtime.GOARCH,
 		Kernel:   string(pv),
+		Unwind:   s.unwindStats.DebugInfo(),
+		BuildIDs: s.symCache.BuildIDInventory(),
 	}
 }
 
@@ -303,18 +472,30 @@ func (s *session) collectRegularProfile(cb pprof.CollectProfilesCallback) error
 
 	knownStacks := map[uint32]bool{}
 	knownPythonStacks := map[uint32]bool{}
+	knownDWARFStacks := map[uint32]bool{}
+	knownRubyStacks := map[uint32]bool{}
 	var pySymbols *python.LazySymbols
 	if s.pyperf != nil {
 		pySymbols = s.pyperf.GetLazySymbols()
 	}
+	var rbSymbols *ruby.LazySymbols
+	if s.rbperf != nil {
+		rbSymbols = s.rbperf.GetLazySymbols()
+	}
 
 	for i := range keys {
 		ck := &keys[i]
 		value := values[i]
 		isPythonStack := ck.Flags&uint32(pyrobpf.SampleKeyFlagPythonStack) != 0
+		isDWARFStack := ck.Flags&uint32(pyrobpf.SampleKeyFlagDWARFStack) != 0
+		isRubyStack := ck.Flags&uint32(pyrobpf.SampleKeyFlagRubyStack) != 0
 		if ck.UserStack > 0 {
 			if isPythonStack {
 				knownPythonStacks[uint32(ck.UserStack)] = true
+			} else if isRubyStack {
+				knownRubyStacks[uint32(ck.UserStack)] = true
+			} else if isDWARFStack {
+				knownDWARFStacks[uint32(ck.UserStack)] = true
 			} else {
 				knownStacks[uint32(ck.UserStack)] = true
 			}
@@ -337,6 +518,10 @@ func (s *session) collectRegularProfile(cb pprof.CollectProfilesCallback) error
 		if s.options.CollectUser {
 			if isPythonStack {
 				uStack = s.GetPythonStack(ck.UserStack) //todo lookup batch
+			} else if isRubyStack {
+				uStack = s.GetRubyStack(ck.UserStack)
+			} else if isDWARFStack {
+				uStack = s.GetDWARFStack(ck.UserStack)
 			} else {
 				uStack = s.GetStack(ck.UserStack)
 			}
@@ -354,6 +539,10 @@ func (s *session) collectRegularProfile(cb pprof.CollectProfilesCallback) error
 				if pyProc != nil {
 					s.WalkPythonStack(sb, uStack, target, pyProc, pySymbols, &stats)
 				}
+			} else if isRubyStack {
+				if s.rbperf != nil && s.rbperf.FindProc(ck.Pid) != nil {
+					s.WalkRubyStack(sb, uStack, target, rbSymbols, &stats)
+				}
 			} else {
 				proc := s.symCache.GetProcTableCached(pk)
 				if proc == nil {
@@ -365,12 +554,18 @@ func (s *session) collectRegularProfile(cb pprof.CollectProfilesCallback) error
 					// it may succeed if we have same binary loaded in another process, not doing it for now
 					continue
 				} else {
+					s.unwindStack.reset()
+					stats.unwind = &s.unwindStack
 					s.WalkStack(sb, uStack, proc, &stats)
+					stats.unwind = nil
+					if binary := s.unwindStats.record(s.pids.all[ck.Pid].exe, &s.unwindStack); binary != "" {
+						s.reportNoFramePointers(ck.Pid, binary)
+					}
 				}
 			}
 		}
 		if s.options.CollectKernel {
-			s.WalkStack(sb, kStack, s.symCache.GetKallsyms(), &stats)
+			target = s.appendKernelStack(sb, kStack, target, &stats)
 		}
 		if len(sb.stack) == 1 {
 			continue // only comm
@@ -383,6 +578,9 @@ func (s *session) collectRegularProfile(cb pprof.CollectProfilesCallback) error
 			SampleType:  pprof.SampleTypeCpu,
 			Stack:       sb.stack,
 			Value:       uint64(value),
+			SampleRate:  int64(s.sampleRate),
+			CgroupID:    s.pids.all[ck.Pid].cgroupID,
+			BuildID:     s.pids.all[ck.Pid].buildID,
 		})
 		s.collectMetrics(target, &stats, sb)
 	}
@@ -398,6 +596,16 @@ func (s *session) collectRegularProfile(cb pprof.CollectProfilesCallback) error
 			return fmt.Errorf("clear stacks map %w", err)
 		}
 	}
+	if s.rbperfBpf.RubyStacks != nil && len(knownRubyStacks) > 0 {
+		if err = s.clearStacksMap(knownRubyStacks, s.rbperfBpf.RubyStacks); err != nil {
+			return fmt.Errorf("clear stacks map %w", err)
+		}
+	}
+	if s.dwarf.bpf.DwarfStacks != nil && len(knownDWARFStacks) > 0 {
+		if err = s.clearStacksMap(knownDWARFStacks, s.dwarf.bpf.DwarfStacks); err != nil {
+			return fmt.Errorf("clear stacks map %w", err)
+		}
+	}
 	return nil
 }
 
@@ -439,10 +647,23 @@ func (s *session) stopLocked() {
 		_ = kprobe.Close()
 	}
 	s.kprobes = nil
+	s.throttleBpf.Close()
+	s.offCPUBpf.Close()
+	s.dwarf.Close()
+	s.probesBpf.Close()
+	s.probes = nil
 	_ = s.bpf.Close()
+	if s.threads != nil {
+		_ = s.threads.Close()
+		s.threads = nil
+	}
+	s.closeRawSamplesLocked()
+	clear(s.threadFilters)
 	if s.pyperf != nil {
 		s.pyperf = nil
 	}
+	s.rbperfBpf.Close()
+	s.rbperf = nil
 	if s.eventsReader != nil {
 		err := s.eventsReader.Close()
 		if err != nil {
@@ -462,6 +683,10 @@ func (s *session) stopLocked() {
 		close(s.pidExecRequests)
 		s.pidExecRequests = nil
 	}
+	if s.unsubscribeTargets != nil {
+		s.unsubscribeTargets()
+		s.unsubscribeTargets = nil
+	}
 	s.started = false
 }
 
@@ -471,6 +696,7 @@ func (s *session) setPidConfig(pid uint32, pi procInfoLite, collectUser bool, co
 		Type:          uint8(pi.typ),
 		CollectUser:   uint8FromBool(collectUser),
 		CollectKernel: uint8FromBool(collectKernel),
+		FilterThreads: uint8FromBool(s.threadFilters[pid] != nil),
 	}
 
 	if err := s.bpf.Pids.Update(&pid, config, ebpf.UpdateAny); err != nil {
@@ -523,6 +749,8 @@ type StackResolveStats struct {
 	known          uint32
 	unknownSymbols uint32
 	unknownModules uint32
+	// unwind is filled with the unwinding of the stack, if set.
+	unwind *unwindStack
 }
 
 func (s *StackResolveStats) add(other StackResolveStats) {
@@ -538,13 +766,16 @@ func (s *session) WalkStack(sb *stackBuilder, stack []byte, resolver symtab.Symb
 		return
 	}
 	begin := len(sb.stack)
-	for i := 0; i < 127; i++ {
+	for i := 0; i < maxStackDepth; i++ {
 		instructionPointerBytes := stack[i*8 : i*8+8]
 		instructionPointer := binary.LittleEndian.Uint64(instructionPointerBytes)
 		if instructionPointer == 0 {
 			break
 		}
 		sym := resolver.Resolve(instructionPointer)
+		if stats.unwind != nil {
+			stats.unwind.addFrame(sym.Module)
+		}
 		var name string
 		if sym.Name != "" {
 			name = sym.Name
@@ -645,6 +876,9 @@ func (s *session) processPidInfoRequests(pidInfoRequests <-chan uint32) {
 				s.saveUnknownPIDLocked(pid)
 			} else {
 				s.startProfilingLocked(pid, target)
+				if s.options.CollectUser && s.pids.all[pid].typ == pyrobpf.ProfilingTypeFramepointers {
+					s.primeProcTableLocked(pid, target)
+				}
 			}
 		}()
 	}
@@ -655,17 +889,42 @@ func (s *session) startProfilingLocked(pid uint32, target *sd.Target) {
 		return
 	}
 	typ := s.selectProfilingType(pid, target)
+	if !s.checkCgroupEpochLocked(pid, typ.cgroupID) {
+		s.saveUnknownPIDLocked(pid)
+		return
+	}
+	_ = level.Debug(s.logger).Log("msg", "target attached", "event", "target_attach",
+		"pid", pid, "exe", typ.exe, "unwinder", typ.typ, "target", target.String())
 	if typ.typ == pyrobpf.ProfilingTypePython {
+		s.removeUnwindPidLocked(pid)
 		go s.tryStartPythonProfiling(pid, target, typ)
 		return
 	}
+	if typ.typ == pyrobpf.ProfilingTypeRuby {
+		s.removeUnwindPidLocked(pid)
+		go s.tryStartRubyProfiling(pid, target, typ)
+		return
+	}
 	if s.pyperf != nil {
 		pyproc := s.pyperf.FindProc(pid)
 		if pyproc != nil {
 			s.pyperf.RemoveDeadPID(pid)
 		}
 	}
+	if s.rbperf != nil && s.rbperf.FindProc(pid) != nil {
+		s.rbperf.RemoveDeadPID(pid)
+	}
+	if err := s.updateThreadFilterLocked(pid, target); err != nil {
+		s.removeUnwindPidLocked(pid)
+		s.disableThreadFilteredPidLocked(pid, typ, err)
+		return
+	}
 	s.setPidConfig(pid, typ, s.options.CollectUser, s.collectKernelEnabled(target))
+	if typ.typ == pyrobpf.ProfilingTypeFramepointers {
+		s.updateUnwindPidLocked(pid)
+	} else {
+		s.removeUnwindPidLocked(pid)
+	}
 }
 
 type procInfoLite struct {
@@ -673,15 +932,19 @@ type procInfoLite struct {
 	comm string
 	exe  string
 	typ  pyrobpf.ProfilingType
+	// cgroupID tells apart the restarts of a container, 0 if unknown
+	cgroupID uint64
+	// buildID of the executable, empty if unknown
+	buildID string
 }
 
 func (s *session) selectProfilingType(pid uint32, target *sd.Target) procInfoLite {
-	exePath, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	exePath, err := os.Readlink(procfs.Path(pid, "exe"))
 	if err != nil {
 		_ = s.procErrLogger(err).Log("err", err, "msg", "select profiling type failed", "pid", pid)
 		return procInfoLite{pid: pid, typ: pyrobpf.ProfilingTypeError}
 	}
-	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	comm, err := os.ReadFile(procfs.Path(pid, "comm"))
 	if err != nil {
 		_ = s.procErrLogger(err).Log("err", err, "msg", "select profiling type failed", "pid", pid)
 		return procInfoLite{pid: pid, typ: pyrobpf.ProfilingTypeError}
@@ -690,11 +953,20 @@ func (s *session) selectProfilingType(pid uint32, target *sd.Target) procInfoLit
 		comm = comm[:len(comm)-1]
 	}
 	exe := filepath.Base(exePath)
+	cgroupID, err := readCgroupID(pid)
+	if err != nil {
+		_ = s.procErrLogger(err).Log("err", err, "msg", "read cgroup id failed", "pid", pid)
+	}
 
 	if s.pythonEnabled(target) && strings.HasPrefix(exe, "python") || exe == "uwsgi" {
-		return procInfoLite{pid: pid, comm: string(comm), typ: pyrobpf.ProfilingTypePython}
+		return procInfoLite{pid: pid, comm: string(comm), exe: exePath, typ: pyrobpf.ProfilingTypePython, cgroupID: cgroupID}
+	}
+	if s.rubyEnabled(target) && strings.HasPrefix(exe, "ruby") {
+		return procInfoLite{pid: pid, comm: string(comm), exe: exePath, typ: pyrobpf.ProfilingTypeRuby, cgroupID: cgroupID,
+			buildID: readBuildID(procfs.Path(pid, "exe"))}
 	}
-	return procInfoLite{pid: pid, comm: string(comm), typ: pyrobpf.ProfilingTypeFramepointers}
+	return procInfoLite{pid: pid, comm: string(comm), exe: exePath, typ: pyrobpf.ProfilingTypeFramepointers, cgroupID: cgroupID,
+		buildID: readBuildID(procfs.Path(pid, "exe"))}
 }
 
 func (s *session) procErrLogger(err error) log.Logger {
@@ -748,12 +1020,17 @@ func (s *session) processPIDExecRequests(requests chan uint32) {
 				s.saveUnknownPIDLocked(pid)
 			} else {
 				s.startProfilingLocked(pid, target)
+				// the execve kprobes fire before the new image is loaded,
+				// the symbols of the old executable would be primed
+				if s.execTracepoint && s.options.CollectUser && s.pids.all[pid].typ == pyrobpf.ProfilingTypeFramepointers {
+					s.primeProcTableLocked(pid, target)
+				}
 			}
 		}()
 	}
 }
 
-func (s *session) linkKProbes() error {
+func (s *session) linkKProbes(execKprobes bool) error {
 	type hook struct {
 		kprobe   string
 		prog     *ebpf.Program
@@ -763,8 +1040,12 @@ func (s *session) linkKProbes() error {
 
 	hooks = []hook{
 		{kprobe: "disassociate_ctty", prog: s.bpf.DisassociateCtty, required: true},
-		{kprobe: "sys_execve", prog: s.bpf.Exec, required: false},
-		{kprobe: "sys_execveat", prog: s.bpf.Exec, required: false},
+	}
+	if execKprobes {
+		hooks = append(hooks,
+			hook{kprobe: "sys_execve", prog: s.bpf.Exec, required: false},
+			hook{kprobe: "sys_execveat", prog: s.bpf.Exec, required: false},
+		)
 	}
 	for _, it := range hooks {
 		kp, err := link.Kprobe(it.kprobe, it.prog, nil)
@@ -791,11 +1072,16 @@ func (s *session) cleanup() {
 		if s.pyperf != nil {
 			s.pyperf.RemoveDeadPID(pid)
 		}
+		if s.rbperf != nil {
+			s.rbperf.RemoveDeadPID(pid)
+		}
 		s.targetFinder.RemoveDeadPID(pid)
+		s.removeThreadFilterLocked(pid)
+		s.removeUnwindPidLocked(pid)
 	}
 
 	for pid := range s.pids.unknown {
-		_, err := os.Stat(fmt.Sprintf("/proc/%d", pid))
+		_, err := os.Stat(procfs.Path(pid))
 		if err != nil {
 			if !errors.Is(err, os.ErrNotExist) {
 				_ = level.Error(s.logger).Log("msg", "cleanup stat pid", "pid", pid, "err", err)
@@ -823,7 +1109,7 @@ func (s *session) checkStalePids() {
 	n, err := m.BatchLookup(cursor, keys, values, new(ebpf.BatchOptions))
 	_ = level.Debug(s.logger).Log("msg", "check stale pids", "count", n)
 	for i := 0; i < n; i++ {
-		_, err := os.Stat(fmt.Sprintf("/proc/%d/status", keys[i]))
+		_, err := os.Stat(procfs.Path(keys[i], "status"))
 		if err != nil {
 			if !errors.Is(err, os.ErrNotExist) {
 				_ = level.Error(s.logger).Log("msg", "check stale pids", "err", err)
@@ -882,6 +1168,9 @@ func overrideSymbolOptions(t *sd.Target, opt *symtab.SymbolOptions) {
 	if v, present := t.GetFlag(sd.OptionPythonFullFilePath); present {
 		opt.PythonFullFilePath = v
 	}
+	if v, present := t.GetFlag(sd.OptionPythonQualnames); present {
+		opt.PythonQualnames = v
+	}
 	if v, present := t.Get(sd.OptionDemangle); present {
 		opt.DemangleOptions = demangle.ConvertDemangleOptions(v)
 	}
@@ -903,6 +1192,14 @@ func (s *session) pythonEnabled(target *sd.Target) bool {
 	return enabled
 }
 
+func (s *session) rubyEnabled(target *sd.Target) bool {
+	enabled := s.options.RubyEnabled
+	if v, present := target.GetFlag(sd.OptionRubyEnabled); present {
+		enabled = v
+	}
+	return enabled
+}
+
 func (s *session) pythonBPFDebugLogEnabled(target *sd.Target) bool {
 	enabled := s.options.PythonBPFDebugLogEnabled
 	if v, present := target.GetFlag(sd.OptionPythonBPFDebugLogEnabled); present {
@@ -938,7 +1235,7 @@ func (s *stackBuilder) append(sym string) {
 }
 
 func getPIDNamespace() (dev uint64, ino uint64, err error) {
-	stat, err := os.Stat("/proc/self/ns/pid")
+	stat, err := os.Stat(procfs.PIDNamespacePath())
 	if err != nil {
 		return 0, 0, err
 	}
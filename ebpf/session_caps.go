@@ -0,0 +1,33 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"fmt"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/caps"
+)
+
+// dropCapabilitiesLocked drops the capabilities not needed to collect the
+// profiles once the BPF objects are loaded and attached, see caps.Profiling.
+// The session can not be started again after it is stopped.
+func (s *session) dropCapabilitiesLocked() error {
+	lastCap, err := caps.LastCap()
+	if err != nil {
+		return err
+	}
+	keep := caps.Profiling(lastCap)
+	if err = caps.Drop(keep); err != nil {
+		return err
+	}
+	effective, err := caps.Effective()
+	if err != nil {
+		return err
+	}
+	if extra := effective &^ keep; extra != 0 {
+		return fmt.Errorf("capabilities %s left after the drop", extra)
+	}
+	_ = level.Info(s.logger).Log("msg", "dropped the capabilities", "effective", effective)
+	return nil
+}
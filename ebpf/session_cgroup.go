@@ -0,0 +1,57 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/procfs"
+	"github.com/grafana/pyroscope/ebpf/symtab"
+)
+
+// readCgroupID returns the id of the cgroup v2 of the pid: the inode of its
+// directory. A container restarted in place keeps its id, but gets a new
+// cgroup.
+func readCgroupID(pid uint32) (uint64, error) {
+	f, err := os.Open(procfs.Path(pid, "cgroup"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			stat, err := os.Stat("/sys/fs/cgroup" + path)
+			if err != nil {
+				return 0, err
+			}
+			if st, ok := stat.Sys().(*syscall.Stat_t); ok {
+				return st.Ino, nil
+			}
+			return 0, fmt.Errorf("could not determine cgroup inode")
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// checkCgroupEpochLocked drops the state cached for the pid, if the pid was
+// seen before in another cgroup: the process of a container restarted in
+// place reusing the pid. It returns false if the pid is not a target anymore.
+func (s *session) checkCgroupEpochLocked(pid uint32, cgroupID uint64) bool {
+	prev, ok := s.pids.all[pid]
+	if !ok || prev.cgroupID == 0 || cgroupID == 0 || prev.cgroupID == cgroupID {
+		return true
+	}
+	_ = level.Debug(s.logger).Log("msg", "pid cgroup changed, dropping cached state", "pid", pid)
+	s.symCache.RemoveDeadPID(symtab.PidKey(pid))
+	if s.pyperf != nil {
+		s.pyperf.RemoveDeadPID(pid)
+	}
+	s.targetFinder.RemoveDeadPID(pid)
+	return s.targetFinder.FindTarget(pid) != nil
+}
@@ -0,0 +1,444 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/cilium/ebpf"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/procfs"
+	"github.com/grafana/pyroscope/ebpf/pyrobpf"
+	"github.com/grafana/pyroscope/ebpf/symtab"
+	elf2 "github.com/grafana/pyroscope/ebpf/symtab/elf"
+)
+
+// DWARFUnwindingOptions configures the unwinding of the user stacks of the
+// binaries compiled without frame pointers, with the .eh_frame unwind tables
+// of the binaries. The tables are loaded once a binary is detected as
+// compiled without frame pointers, see unwindStats. x86_64 only, the session
+// fails to start if the unwinding is enabled and can't be loaded.
+type DWARFUnwindingOptions struct {
+	Enabled bool
+	// MaxRows is the size of the map holding the rows of the unwind tables
+	// of all the binaries, 1<<20 by default. A row takes 8 bytes.
+	MaxRows uint32
+	// MaxProcesses is the number of processes the tables are used for,
+	// 1024 by default.
+	MaxProcesses uint32
+}
+
+const (
+	defaultUnwindMaxRows      = 1 << 20
+	defaultUnwindMaxProcesses = 1024
+	// maxUnwindMappings is MAX_UNWIND_MAPPINGS of the bpf program.
+	maxUnwindMappings = 64
+	// maxUnwindTableRows is the largest table the bpf program binary
+	// searches in UNWIND_SEARCH_STEPS steps.
+	maxUnwindTableRows = 1<<21 - 1
+	progIdxDWARF       = 1
+)
+
+// dwarfObjects are the program and maps of the DWARF unwinding. The program
+// is tail called by do_perf_event for the processes registered in the
+// unwind_pids map.
+type dwarfObjects struct {
+	DwarfUnwind *ebpf.Program `ebpf:"dwarf_unwind"`
+	UnwindRows  *ebpf.Map     `ebpf:"unwind_rows"`
+	DwarfStacks *ebpf.Map     `ebpf:"dwarf_stacks"`
+}
+
+func (o *dwarfObjects) Close() {
+	_ = o.DwarfUnwind.Close()
+	_ = o.UnwindRows.Close()
+	_ = o.DwarfStacks.Close()
+	*o = dwarfObjects{}
+}
+
+// unwindRow mirrors struct unwind_row.
+type unwindRow struct {
+	PC        uint32
+	CFAOffset int16
+	CFAType   uint8
+	RBPOffset int8
+}
+
+// unwindMapping mirrors struct unwind_mapping.
+type unwindMapping struct {
+	Start     uint64
+	End       uint64
+	Bias      uint64
+	RowsStart uint32
+	RowsCount uint32
+}
+
+// unwindPid mirrors struct unwind_pid.
+type unwindPid struct {
+	Count    uint32
+	Pad      uint32
+	Mappings [maxUnwindMappings]unwindMapping
+}
+
+// unwindTable is the unwind table of a binary, loaded in the unwind_rows map
+// at rowsStart.
+type unwindTable struct {
+	rowsStart uint32
+	rowsCount uint32
+	// progs are the PT_LOAD segments the bias of the mappings is found with.
+	progs   []elf.ProgHeader
+	elfType elf.Type
+	err     error
+}
+
+type unwindFile struct {
+	dev   uint64
+	inode uint64
+}
+
+// dwarfUnwinder is the state of the DWARF unwinding, guarded by the session
+// mutex.
+type dwarfUnwinder struct {
+	bpf dwarfObjects
+	// pids and state are shared with do_perf_event.
+	pids  *ebpf.Map
+	state *ebpf.Map
+
+	tables map[unwindFile]*unwindTable
+	// registered are the pids with mappings in the pids map.
+	registered map[uint32]struct{}
+	// nextRow is the first free row of the rows map, the rows are never
+	// freed.
+	nextRow uint32
+	maxRows uint32
+	full    bool
+}
+
+func (d *dwarfUnwinder) enabled() bool {
+	return d.bpf.DwarfUnwind != nil
+}
+
+func (d *dwarfUnwinder) Close() {
+	d.bpf.Close()
+	if d.pids != nil {
+		_ = d.pids.Close()
+		d.pids = nil
+	}
+	if d.state != nil {
+		_ = d.state.Close()
+		d.state = nil
+	}
+	d.tables = nil
+	d.registered = nil
+	d.nextRow = 0
+	d.full = false
+}
+
+// loadUnwindMaps creates the maps shared by do_perf_event and the DWARF
+// unwinding program. The unwinding is not available if the spec has no
+// unwind_pids map.
+func (s *session) loadUnwindMaps(spec *ebpf.CollectionSpec, opts *ebpf.CollectionOptions) error {
+	pidsSpec, ok := spec.Maps["unwind_pids"]
+	if !ok || !s.options.DWARFUnwinding.Enabled {
+		return nil
+	}
+	stateSpec, ok := spec.Maps["unwind_state"]
+	if !ok {
+		return nil
+	}
+	pidsSpec = pidsSpec.Copy()
+	pidsSpec.MaxEntries = s.options.DWARFUnwinding.MaxProcesses
+	if pidsSpec.MaxEntries == 0 {
+		pidsSpec.MaxEntries = defaultUnwindMaxProcesses
+	}
+	pids, err := ebpf.NewMap(pidsSpec)
+	if err != nil {
+		return err
+	}
+	state, err := ebpf.NewMap(stateSpec)
+	if err != nil {
+		_ = pids.Close()
+		return err
+	}
+	if opts.MapReplacements == nil {
+		opts.MapReplacements = make(map[string]*ebpf.Map)
+	}
+	opts.MapReplacements["unwind_pids"] = pids
+	opts.MapReplacements["unwind_state"] = state
+	s.dwarf.pids = pids
+	s.dwarf.state = state
+	return nil
+}
+
+func (s *session) loadDWARFLocked(spec *ebpf.CollectionSpec) error {
+	if runtime.GOARCH != "amd64" {
+		return fmt.Errorf("dwarf unwinding is not supported on %s", runtime.GOARCH)
+	}
+	if _, ok := spec.Programs["dwarf_unwind"]; !ok || s.dwarf.pids == nil {
+		return errors.New("dwarf_unwind program not found, the bpf objects need to be regenerated")
+	}
+	maxRows := s.options.DWARFUnwinding.MaxRows
+	if maxRows == 0 {
+		maxRows = defaultUnwindMaxRows
+	}
+	spec.Maps["unwind_rows"].MaxEntries = maxRows
+	opts := &ebpf.CollectionOptions{
+		Programs: s.progOptions(),
+		MapReplacements: map[string]*ebpf.Map{
+			"stacks":       s.bpf.Stacks,
+			"counts":       s.bpf.Counts,
+			"progs":        s.bpf.Progs,
+			"pids":         s.bpf.Pids,
+			"unwind_pids":  s.dwarf.pids,
+			"unwind_state": s.dwarf.state,
+		},
+	}
+	if err := spec.LoadAndAssign(&s.dwarf.bpf, opts); err != nil {
+		s.logVerifierError(err)
+		s.dwarf.bpf.Close()
+		return fmt.Errorf("load dwarf bpf objects: %w", err)
+	}
+	if err := s.bpf.Progs.Update(uint32(progIdxDWARF), s.dwarf.bpf.DwarfUnwind, ebpf.UpdateAny); err != nil {
+		s.dwarf.bpf.Close()
+		return fmt.Errorf("update progs map: %w", err)
+	}
+	s.dwarf.maxRows = maxRows
+	s.dwarf.tables = make(map[unwindFile]*unwindTable)
+	s.dwarf.registered = make(map[uint32]struct{})
+	return nil
+}
+
+// loadUnwindTableLocked loads the unwind table of the binary of a process
+// into the rows map, and registers the mappings of the binary in all the
+// processes profiled with frame pointers. It reports whether the binary is
+// unwound with the table.
+func (s *session) loadUnwindTableLocked(pid uint32, binary string) bool {
+	if !s.dwarf.enabled() {
+		return false
+	}
+	maps, err := readProcMaps(pid)
+	if err != nil {
+		_ = level.Debug(s.logger).Log("msg", "read proc maps", "pid", pid, "err", err)
+		return false
+	}
+	var file *unwindFile
+	for _, m := range maps {
+		if m.Pathname == binary {
+			file = &unwindFile{dev: m.Dev, inode: m.Inode}
+			break
+		}
+	}
+	if file == nil {
+		return false
+	}
+	t := s.dwarf.tables[*file]
+	if t == nil {
+		t = s.newUnwindTableLocked(filepath.Join(procfs.RootFS(pid), binary))
+		s.dwarf.tables[*file] = t
+		if t.err != nil {
+			_ = level.Warn(s.logger).Log("msg", "unwind table not loaded", "binary", binary, "pid", pid, "err", t.err)
+			return false
+		}
+		_ = level.Info(s.logger).Log("msg", "unwind table loaded", "binary", binary, "rows", t.rowsCount)
+	}
+	if t.err != nil {
+		return false
+	}
+	for p, pi := range s.pids.all {
+		if pi.typ == pyrobpf.ProfilingTypeFramepointers {
+			s.updateUnwindPidLocked(p)
+		}
+	}
+	return true
+}
+
+func (s *session) newUnwindTableLocked(path string) *unwindTable {
+	t := &unwindTable{}
+	f, err := elf2.NewMMapedElfFile(path)
+	if err != nil {
+		t.err = err
+		return t
+	}
+	defer f.Close()
+	rows, err := f.UnwindTable()
+	if err != nil {
+		t.err = err
+		return t
+	}
+	bpfRows, err := convertUnwindRows(rows)
+	if err != nil {
+		t.err = err
+		return t
+	}
+	if len(bpfRows) > maxUnwindTableRows {
+		t.err = fmt.Errorf("unwind table too large: %d rows", len(bpfRows))
+		return t
+	}
+	if uint64(s.dwarf.nextRow)+uint64(len(bpfRows)) > uint64(s.dwarf.maxRows) {
+		if !s.dwarf.full {
+			_ = level.Warn(s.logger).Log("msg", "unwind rows map is full, increase the max rows", "max_rows", s.dwarf.maxRows)
+			s.dwarf.full = true
+		}
+		t.err = errors.New("unwind rows map is full")
+		return t
+	}
+	start := s.dwarf.nextRow
+	if err = writeUnwindRows(s.dwarf.bpf.UnwindRows, start, bpfRows); err != nil {
+		t.err = err
+		return t
+	}
+	s.dwarf.nextRow += uint32(len(bpfRows))
+	t.rowsStart = start
+	t.rowsCount = uint32(len(bpfRows))
+	t.progs = f.Progs
+	t.elfType = f.Type
+	return t
+}
+
+func writeUnwindRows(m *ebpf.Map, start uint32, rows []unwindRow) error {
+	keys := make([]uint32, len(rows))
+	for i := range keys {
+		keys[i] = start + uint32(i)
+	}
+	_, err := m.BatchUpdate(keys, rows, nil)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ebpf.ErrNotSupported) {
+		return fmt.Errorf("update unwind rows: %w", err)
+	}
+	for i := range rows {
+		if err = m.Update(keys[i], &rows[i], ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("update unwind rows: %w", err)
+		}
+	}
+	return nil
+}
+
+// updateUnwindPidLocked registers the mappings of the process with unwind
+// tables in the pids map, or removes the process if it has none.
+func (s *session) updateUnwindPidLocked(pid uint32) {
+	if !s.dwarf.enabled() || len(s.dwarf.tables) == 0 {
+		return
+	}
+	maps, err := readProcMaps(pid)
+	if err != nil {
+		s.removeUnwindPidLocked(pid)
+		return
+	}
+	var value unwindPid
+	for _, m := range maps {
+		t := s.dwarf.tables[unwindFile{dev: m.Dev, inode: m.Inode}]
+		if t == nil || t.err != nil {
+			continue
+		}
+		bias, ok := unwindBias(t.elfType, t.progs, m)
+		if !ok {
+			continue
+		}
+		if value.Count == maxUnwindMappings {
+			break
+		}
+		value.Mappings[value.Count] = unwindMapping{
+			Start:     m.StartAddr,
+			End:       m.EndAddr,
+			Bias:      bias,
+			RowsStart: t.rowsStart,
+			RowsCount: t.rowsCount,
+		}
+		value.Count++
+	}
+	if value.Count == 0 {
+		s.removeUnwindPidLocked(pid)
+		return
+	}
+	if err = s.dwarf.pids.Update(&pid, &value, ebpf.UpdateAny); err != nil {
+		_ = level.Error(s.logger).Log("msg", "updating unwind pids map", "pid", pid, "err", err)
+		return
+	}
+	s.dwarf.registered[pid] = struct{}{}
+}
+
+func (s *session) removeUnwindPidLocked(pid uint32) {
+	if _, ok := s.dwarf.registered[pid]; !ok {
+		return
+	}
+	delete(s.dwarf.registered, pid)
+	if err := s.dwarf.pids.Delete(&pid); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		_ = level.Error(s.logger).Log("msg", "deleting from unwind pids map", "pid", pid, "err", err)
+	}
+}
+
+// GetDWARFStack returns the user stack unwound by the dwarf_unwind program.
+func (s *session) GetDWARFStack(stackId int64) []byte {
+	if stackId < 0 || s.dwarf.bpf.DwarfStacks == nil {
+		return nil
+	}
+	res, err := s.dwarf.bpf.DwarfStacks.LookupBytes(uint32(stackId))
+	if err != nil {
+		return nil
+	}
+	return res
+}
+
+func readProcMaps(pid uint32) ([]*symtab.ProcMap, error) {
+	data, err := os.ReadFile(procfs.Path(pid, "maps"))
+	if err != nil {
+		return nil, err
+	}
+	return symtab.ParseProcMapsExecutableModules(data, true)
+}
+
+// unwindBias returns the value subtracted from the addresses of the mapping
+// to get the virtual addresses of the binary.
+func unwindBias(typ elf.Type, progs []elf.ProgHeader, m *symtab.ProcMap) (uint64, bool) {
+	if typ == elf.ET_EXEC {
+		return 0, true
+	}
+	for _, p := range progs {
+		if p.Type != elf.PT_LOAD || p.Flags&elf.PF_X == 0 {
+			continue
+		}
+		alignedOff := p.Off
+		if p.Align > 1 {
+			alignedOff &^= p.Align - 1
+		}
+		if m.Offset < alignedOff || m.Offset >= p.Off+p.Filesz {
+			continue
+		}
+		// the virtual address the mapping starts at
+		vaddr := p.Vaddr - (p.Off - m.Offset)
+		return m.StartAddr - vaddr, true
+	}
+	return 0, false
+}
+
+// convertUnwindRows converts the rows to the compact ones of the bpf
+// program. The rules which do not fit are unsupported, the unwinding stops
+// there.
+func convertUnwindRows(rows []elf2.UnwindRow) ([]unwindRow, error) {
+	res := make([]unwindRow, 0, len(rows))
+	for _, r := range rows {
+		if r.PC > math.MaxUint32 {
+			return nil, fmt.Errorf("unwind row pc %x out of range", r.PC)
+		}
+		row := unwindRow{PC: uint32(r.PC), CFAType: uint8(r.CFAType)}
+		switch r.CFAType {
+		case elf2.UnwindCFARSP, elf2.UnwindCFARBP:
+			if r.CFAOffset < math.MinInt16 || r.CFAOffset > math.MaxInt16 ||
+				r.RBPOffset%8 != 0 || r.RBPOffset/8 < math.MinInt8 || r.RBPOffset/8 > math.MaxInt8 {
+				row.CFAType = uint8(elf2.UnwindCFAUnsupported)
+				break
+			}
+			row.CFAOffset = int16(r.CFAOffset)
+			row.RBPOffset = int8(r.RBPOffset / 8)
+		}
+		res = append(res, row)
+	}
+	return res, nil
+}
@@ -0,0 +1,96 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"debug/elf"
+	"math"
+	"testing"
+	"unsafe"
+
+	"github.com/grafana/pyroscope/ebpf/symtab"
+	elf2 "github.com/grafana/pyroscope/ebpf/symtab/elf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertUnwindRows(t *testing.T) {
+	rows, err := convertUnwindRows([]elf2.UnwindRow{
+		{PC: 0x1000, CFAType: elf2.UnwindCFARSP, CFAOffset: 8},
+		{PC: 0x1001, CFAType: elf2.UnwindCFARSP, CFAOffset: 16, RBPOffset: -16},
+		{PC: 0x1004, CFAType: elf2.UnwindCFARBP, CFAOffset: 16, RBPOffset: -16},
+		{PC: 0x1010, CFAType: elf2.UnwindCFARSP, CFAOffset: 1 << 20},
+		{PC: 0x1020, CFAType: elf2.UnwindCFARSP, CFAOffset: 16, RBPOffset: -12},
+		{PC: 0x1030, CFAType: elf2.UnwindCFALastFrame},
+		{PC: 0x1040, CFAType: elf2.UnwindCFAEnd},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []unwindRow{
+		{PC: 0x1000, CFAType: uint8(elf2.UnwindCFARSP), CFAOffset: 8},
+		{PC: 0x1001, CFAType: uint8(elf2.UnwindCFARSP), CFAOffset: 16, RBPOffset: -2},
+		{PC: 0x1004, CFAType: uint8(elf2.UnwindCFARBP), CFAOffset: 16, RBPOffset: -2},
+		{PC: 0x1010, CFAType: uint8(elf2.UnwindCFAUnsupported)},
+		{PC: 0x1020, CFAType: uint8(elf2.UnwindCFAUnsupported)},
+		{PC: 0x1030, CFAType: uint8(elf2.UnwindCFALastFrame)},
+		{PC: 0x1040, CFAType: uint8(elf2.UnwindCFAEnd)},
+	}, rows)
+
+	_, err = convertUnwindRows([]elf2.UnwindRow{{PC: math.MaxUint32 + 1, CFAType: elf2.UnwindCFARSP, CFAOffset: 8}})
+	require.Error(t, err)
+}
+
+func TestUnwindStructSizes(t *testing.T) {
+	// the sizes of the structs of bpf/unwind.h
+	assert.Equal(t, uintptr(8), unsafe.Sizeof(unwindRow{}))
+	assert.Equal(t, uintptr(32), unsafe.Sizeof(unwindMapping{}))
+	assert.Equal(t, uintptr(8+32*maxUnwindMappings), unsafe.Sizeof(unwindPid{}))
+}
+
+func TestUnwindBias(t *testing.T) {
+	progs := []elf.ProgHeader{
+		{Type: elf.PT_LOAD, Flags: elf.PF_R, Off: 0, Vaddr: 0, Filesz: 0x1234, Align: 0x1000},
+		{Type: elf.PT_LOAD, Flags: elf.PF_R | elf.PF_X, Off: 0x2000, Vaddr: 0x3000, Filesz: 0x5000, Align: 0x1000},
+		{Type: elf.PT_LOAD, Flags: elf.PF_R | elf.PF_W, Off: 0x7100, Vaddr: 0x9100, Filesz: 0x100, Align: 0x1000},
+	}
+	testcases := []struct {
+		name  string
+		typ   elf.Type
+		m     symtab.ProcMap
+		bias  uint64
+		found bool
+	}{
+		{
+			name:  "shared library",
+			typ:   elf.ET_DYN,
+			m:     symtab.ProcMap{StartAddr: 0x7f0000003000, EndAddr: 0x7f0000008000, Offset: 0x2000},
+			bias:  0x7f0000000000,
+			found: true,
+		},
+		{
+			name:  "mapping starting inside the segment",
+			typ:   elf.ET_DYN,
+			m:     symtab.ProcMap{StartAddr: 0x7f0000004000, EndAddr: 0x7f0000008000, Offset: 0x3000},
+			bias:  0x7f0000000000,
+			found: true,
+		},
+		{
+			name:  "executable",
+			typ:   elf.ET_EXEC,
+			m:     symtab.ProcMap{StartAddr: 0x403000, EndAddr: 0x408000, Offset: 0x2000},
+			bias:  0,
+			found: true,
+		},
+		{
+			name: "no executable segment",
+			typ:  elf.ET_DYN,
+			m:    symtab.ProcMap{StartAddr: 0x7f0000009000, EndAddr: 0x7f000000a000, Offset: 0x7000},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			bias, found := unwindBias(tc.typ, progs, &tc.m)
+			assert.Equal(t, tc.found, found)
+			assert.Equal(t, tc.bias, bias)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/grafana/pyroscope/ebpf/sd"
+	"github.com/grafana/pyroscope/ebpf/symtab"
+)
+
+// execObjects is the program notifying the exec of the processes from the
+// sched_process_exec tracepoint. Unlike the execve kprobes, the tracepoint
+// fires once the new image is loaded: the target and the symbols of the new
+// executable are resolved right away, instead of at the first sample.
+type execObjects struct {
+	SchedProcessExec *ebpf.Program `ebpf:"sched_process_exec"`
+}
+
+func (o *execObjects) Close() {
+	_ = o.SchedProcessExec.Close()
+	*o = execObjects{}
+}
+
+// linkExecTracepointLocked attaches the sched_process_exec tracepoint.
+func (s *session) linkExecTracepointLocked(spec *ebpf.CollectionSpec) error {
+	if _, ok := spec.Programs["sched_process_exec"]; !ok {
+		return errors.New("sched_process_exec program not found, the bpf objects need to be regenerated")
+	}
+	var objs execObjects
+	opts := &ebpf.CollectionOptions{
+		Programs: s.progOptions(),
+		MapReplacements: map[string]*ebpf.Map{
+			"events": s.bpf.ProfileMaps.Events,
+		},
+	}
+	if err := spec.LoadAndAssign(&objs, opts); err != nil {
+		s.logVerifierError(err)
+		return fmt.Errorf("load exec bpf objects: %w", err)
+	}
+	tp, err := link.Tracepoint("sched", "sched_process_exec", objs.SchedProcessExec, nil)
+	// The link holds a reference to the program.
+	objs.Close()
+	if err != nil {
+		return fmt.Errorf("link tracepoint sched_process_exec: %w", err)
+	}
+	s.kprobes = append(s.kprobes, tp)
+	return nil
+}
+
+// primeProcTableLocked replaces the symbol table of a process which has
+// just exec'ed, and loads the symbols of the binaries mapped, so that the
+// first samples of short-lived processes are resolved. At this point only
+// the executable and the dynamic loader are mapped, the shared libraries
+// are loaded when the first address in them is resolved.
+func (s *session) primeProcTableLocked(pid uint32, target *sd.Target) {
+	pk := symtab.PidKey(pid)
+	s.symCache.RemoveDeadPID(pk)
+	proc := s.symCache.NewProcTable(pk, s.targetSymbolOptions(target))
+	if proc.Error() == nil {
+		proc.LoadElfTables()
+	}
+}
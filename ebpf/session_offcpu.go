@@ -0,0 +1,71 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/grafana/pyroscope/ebpf/pprof"
+)
+
+// offCPUObjects are the program and maps of the off-CPU profile. The
+// sched_switch tracepoint records the stack of the threads switched out, and
+// accounts the time until they are switched in again to the stack.
+type offCPUObjects struct {
+	SchedSwitch  *ebpf.Program `ebpf:"sched_switch"`
+	OffcpuStarts *ebpf.Map     `ebpf:"offcpu_starts"`
+	OffcpuTime   *ebpf.Map     `ebpf:"offcpu_time"`
+}
+
+func (o *offCPUObjects) Close() {
+	_ = o.SchedSwitch.Close()
+	_ = o.OffcpuStarts.Close()
+	_ = o.OffcpuTime.Close()
+	*o = offCPUObjects{}
+}
+
+func (s *session) loadOffCPULocked(spec *ebpf.CollectionSpec) error {
+	if _, ok := spec.Programs["sched_switch"]; !ok {
+		return errors.New("sched_switch program not found, the bpf objects need to be regenerated")
+	}
+	threshold := uint64(s.options.OffCPUThresholdUs) * uint64(time.Microsecond)
+	if err := spec.RewriteConstants(map[string]interface{}{"offcpu_threshold_ns": threshold}); err != nil {
+		return fmt.Errorf("rewrite off-cpu threshold: %w", err)
+	}
+	opts := &ebpf.CollectionOptions{
+		Programs: s.progOptions(),
+		MapReplacements: map[string]*ebpf.Map{
+			"stacks": s.bpf.Stacks,
+			"pids":   s.bpf.Pids,
+		},
+	}
+	if s.threads != nil {
+		opts.MapReplacements["threads"] = s.threads
+	}
+	if err := spec.LoadAndAssign(&s.offCPUBpf, opts); err != nil {
+		s.logVerifierError(err)
+		s.offCPUBpf.Close()
+		return fmt.Errorf("load off-cpu bpf objects: %w", err)
+	}
+	tp, err := link.Tracepoint("sched", "sched_switch", s.offCPUBpf.SchedSwitch, nil)
+	if err != nil {
+		s.offCPUBpf.Close()
+		return fmt.Errorf("link tracepoint sched_switch: %w", err)
+	}
+	s.kprobes = append(s.kprobes, tp)
+	return nil
+}
+
+// collectOffCPUProfile reports the off-CPU time by the stack the threads were
+// switched out in. The stacks of the samples are added to knownStacks.
+func (s *session) collectOffCPUProfile(cb pprof.CollectProfilesCallback, knownStacks map[uint32]bool) error {
+	m := s.offCPUBpf.OffcpuTime
+	if m == nil {
+		return nil
+	}
+	return s.collectStackTimes(cb, m, pprof.SampleTypeOffCPU, knownStacks)
+}
@@ -0,0 +1,54 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cilium/ebpf"
+	"github.com/go-kit/log/level"
+)
+
+// The maps holding the samples not collected yet are pinned to the bpffs when
+// BPFMapsOptions.PinPath is set. A new version of the profiler started with
+// the same PinPath, for example during a rolling upgrade, reuses them and
+// collects the samples taken before the restart. The other maps, and the
+// programs, are created and verified again.
+var (
+	pinnedProfileMaps = []string{"counts", "stacks"}
+	pinnedPythonMaps  = []string{"python_stacks", "py_symbols"}
+	pinnedRubyMaps    = []string{"ruby_stacks", "rb_symbols"}
+)
+
+// loadAndAssignPinned loads the spec, reusing the maps pinned in the PinPath.
+// If the pinned maps are not compatible with the spec, for example after an
+// upgrade changing them, they are unpinned and created again.
+func (s *session) loadAndAssignPinned(spec *ebpf.CollectionSpec, pinned []string, to interface{}, opts *ebpf.CollectionOptions) error {
+	pinPath := s.options.BPFMapsOptions.PinPath
+	if pinPath == "" {
+		return spec.LoadAndAssign(to, opts)
+	}
+	if err := os.MkdirAll(pinPath, 0o700); err != nil {
+		return fmt.Errorf("create pin path: %w", err)
+	}
+	for _, name := range pinned {
+		if m, ok := spec.Maps[name]; ok {
+			m.Pinning = ebpf.PinByName
+		}
+	}
+	opts.Maps.PinPath = pinPath
+	err := spec.LoadAndAssign(to, opts)
+	if !errors.Is(err, ebpf.ErrMapIncompatible) {
+		return err
+	}
+	_ = level.Warn(s.logger).Log("msg", "pinned maps are incompatible, creating them again", "path", pinPath, "err", err)
+	for _, name := range pinned {
+		if err := os.Remove(filepath.Join(pinPath, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unpin map %s: %w", name, err)
+		}
+	}
+	return spec.LoadAndAssign(to, opts)
+}
@@ -0,0 +1,424 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/pprof"
+	"github.com/grafana/pyroscope/ebpf/pyrobpf"
+	"github.com/grafana/pyroscope/ebpf/symtab"
+	elf2 "github.com/grafana/pyroscope/ebpf/symtab/elf"
+	"github.com/samber/lo"
+)
+
+// maxProbeProfiles is the size of the probe_configs map.
+const maxProbeProfiles = 64
+
+const (
+	ProbeValueCount   = "count"
+	ProbeValueLatency = "latency"
+	// ProbeValueArg is the prefix of the argument values, arg1 is the first
+	// argument of the function or of the USDT probe.
+	ProbeValueArg = "arg"
+)
+
+// ProbeProfileOptions defines a custom profile of the hits of a uprobe or of
+// a USDT probe, by the stack the probe is hit in. The probes are hit in all
+// the processes running the binary, only the hits in the targets are
+// profiled. The probes need a 5.15+ kernel.
+type ProbeProfileOptions struct {
+	// Name of the profile, the sample type of the profiles.
+	Name string
+	// Binary is the path of the executable or of the shared library the
+	// probe is in, in the filesystem of the profiler, for example
+	// /proc/<pid>/root/usr/bin/app for a binary of a container.
+	Binary string
+	// Symbol is the function the uprobe is attached to.
+	Symbol string
+	// USDT is the probe the uprobe is attached to, as provider:name.
+	// Either Symbol or USDT is set.
+	USDT string
+	// Value of the samples, ProbeValueCount by default:
+	//   - count: the number of hits.
+	//   - latency: the nanoseconds spent in the function, Symbol only.
+	//   - argN: the sum of the Nth argument, passed in a register.
+	Value string
+	// Unit of the values of the argument, count by default.
+	Unit string
+	// Stack collects the stacks of the hits, otherwise the samples have
+	// only the frame of the process.
+	Stack bool
+}
+
+// probeObjects are the programs and maps of the probe profiles. The probes
+// are attached with their index in the probe_configs map as the cookie.
+type probeObjects struct {
+	ProbeEntry   *ebpf.Program `ebpf:"probe_entry"`
+	ProbeReturn  *ebpf.Program `ebpf:"probe_return"`
+	ProbeConfigs *ebpf.Map     `ebpf:"probe_configs"`
+	ProbeValues  *ebpf.Map     `ebpf:"probe_values"`
+	ProbeStarts  *ebpf.Map     `ebpf:"probe_starts"`
+}
+
+func (o *probeObjects) Close() {
+	_ = o.ProbeEntry.Close()
+	_ = o.ProbeReturn.Close()
+	_ = o.ProbeConfigs.Close()
+	_ = o.ProbeValues.Close()
+	_ = o.ProbeStarts.Close()
+	*o = probeObjects{}
+}
+
+const (
+	probeValueCount    = 0
+	probeValueRegister = 1
+	probeValueLatency  = 2
+)
+
+// probeConfig is the struct probe_config of the bpf program.
+type probeConfig struct {
+	Value        uint8
+	CollectStack uint8
+	Size         uint8
+	_            uint8
+	RegsOffset   uint32
+}
+
+// probeSampleKey is the struct probe_sample_key of the bpf program.
+type probeSampleKey struct {
+	Probe uint32
+	_     uint32
+	Key   pyrobpf.ProfileSampleKey
+}
+
+// probe is a probe profile resolved for attaching.
+type probe struct {
+	options ProbeProfileOptions
+	typ     pprof.ProbeType
+	config  probeConfig
+	symbol  string
+	uprobe  link.UprobeOptions
+}
+
+// resolveProbe validates the options of a probe profile and finds where the
+// probe is attached and where its value is read from.
+func resolveProbe(o ProbeProfileOptions, arch string) (probe, error) {
+	p := probe{options: o, symbol: o.Symbol}
+	if o.Name == "" || o.Binary == "" {
+		return p, errors.New("the name and the binary of the probe are required")
+	}
+	if (o.Symbol == "") == (o.USDT == "") {
+		return p, errors.New("either the symbol or the usdt probe is required")
+	}
+	p.typ = pprof.ProbeType{Name: o.Name, Unit: "count"}
+	if o.Unit != "" {
+		p.typ.Unit = o.Unit
+	}
+	if o.Stack {
+		p.config.CollectStack = 1
+	}
+
+	var usdtArgs []elf2.USDTArg
+	if o.USDT != "" {
+		provider, name, ok := strings.Cut(o.USDT, ":")
+		if !ok {
+			return p, fmt.Errorf("wrong usdt probe %q, expected provider:name", o.USDT)
+		}
+		f, err := elf2.NewMMapedElfFile(o.Binary)
+		if err != nil {
+			return p, err
+		}
+		defer f.Close()
+		probes, err := f.USDTProbes()
+		if err != nil {
+			return p, err
+		}
+		usdt, ok := lo.Find(probes, func(it elf2.USDTProbe) bool {
+			return it.Provider == provider && it.Name == name
+		})
+		if !ok {
+			return p, fmt.Errorf("usdt probe %s not found in %s", o.USDT, o.Binary)
+		}
+		if p.uprobe.Address, err = f.FileOffset(usdt.Location); err != nil {
+			return p, err
+		}
+		if usdt.Semaphore != 0 {
+			if p.uprobe.RefCtrOffset, err = f.FileOffset(usdt.Semaphore); err != nil {
+				return p, err
+			}
+		}
+		if usdtArgs, err = elf2.ParseUSDTArgs(usdt.Args); err != nil {
+			return p, err
+		}
+		p.symbol = provider + "_" + name
+	}
+
+	switch {
+	case o.Value == "" || o.Value == ProbeValueCount:
+		p.config.Value = probeValueCount
+	case o.Value == ProbeValueLatency:
+		if o.Symbol == "" {
+			return p, errors.New("the latency is profiled for the functions only")
+		}
+		p.config.Value = probeValueLatency
+		p.typ.Unit = "nanoseconds"
+	case strings.HasPrefix(o.Value, ProbeValueArg):
+		n, err := strconv.Atoi(strings.TrimPrefix(o.Value, ProbeValueArg))
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("wrong probe value %q", o.Value)
+		}
+		register, size := "", 8
+		if o.USDT != "" {
+			if n > len(usdtArgs) {
+				return p, fmt.Errorf("usdt probe %s has %d arguments", o.USDT, len(usdtArgs))
+			}
+			register, size = usdtArgs[n-1].Register, abs(usdtArgs[n-1].Size)
+			if register == "" {
+				return p, fmt.Errorf("argument %d of usdt probe %s is not passed in a register", n, o.USDT)
+			}
+		} else {
+			args := functionArgRegisters[arch]
+			if n > len(args) {
+				return p, fmt.Errorf("argument %d is not passed in a register", n)
+			}
+			register = args[n-1]
+		}
+		offset, ok := ptRegsOffset(arch, register)
+		if !ok {
+			return p, fmt.Errorf("unsupported register %s", register)
+		}
+		if size < 1 || size > 8 {
+			return p, fmt.Errorf("unsupported argument size %d", size)
+		}
+		p.config.Value = probeValueRegister
+		p.config.RegsOffset = offset
+		p.config.Size = uint8(size)
+	default:
+		return p, fmt.Errorf("wrong probe value %q", o.Value)
+	}
+	return p, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// functionArgRegisters are the registers of the integer arguments of the
+// functions in the calling conventions of the architectures.
+var functionArgRegisters = map[string][]string{
+	"amd64": {"rdi", "rsi", "rdx", "rcx", "r8", "r9"},
+	"arm64": {"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7"},
+}
+
+// amd64PtRegs are the offsets of the registers in the struct pt_regs.
+var amd64PtRegs = map[string]uint32{
+	"r15": 0, "r14": 8, "r13": 16, "r12": 24, "rbp": 32, "rbx": 40, "r11": 48, "r10": 56,
+	"r9": 64, "r8": 72, "rax": 80, "rcx": 88, "rdx": 96, "rsi": 104, "rdi": 112, "rsp": 152,
+}
+
+// ptRegsOffset returns the offset in the struct pt_regs of the register,
+// named the way the USDT arguments name it.
+func ptRegsOffset(arch string, register string) (uint32, bool) {
+	switch arch {
+	case "amd64":
+		off, ok := amd64PtRegs[amd64Register(register)]
+		return off, ok
+	case "arm64":
+		if register == "sp" {
+			return 31 * 8, true
+		}
+		if len(register) < 2 || register[0] != 'x' && register[0] != 'w' {
+			return 0, false
+		}
+		n, err := strconv.Atoi(register[1:])
+		if err != nil || n < 0 || n > 30 {
+			return 0, false
+		}
+		return uint32(n) * 8, true
+	}
+	return 0, false
+}
+
+// amd64Register returns the 64 bit register of the lower part of it,
+// for example rax for eax, ax and al, r8 for r8d.
+func amd64Register(r string) string {
+	switch r {
+	case "eax", "ax", "al":
+		return "rax"
+	case "ebx", "bx", "bl":
+		return "rbx"
+	case "ecx", "cx", "cl":
+		return "rcx"
+	case "edx", "dx", "dl":
+		return "rdx"
+	case "esi", "si", "sil":
+		return "rsi"
+	case "edi", "di", "dil":
+		return "rdi"
+	case "ebp", "bp", "bpl":
+		return "rbp"
+	case "esp", "sp", "spl":
+		return "rsp"
+	}
+	if strings.HasPrefix(r, "r") && len(r) > 2 {
+		if t := strings.TrimRight(r, "dwb"); t != r {
+			return t
+		}
+	}
+	return r
+}
+
+func (s *session) loadProbesLocked(spec *ebpf.CollectionSpec) error {
+	if _, ok := spec.Programs["probe_entry"]; !ok {
+		return errors.New("probe_entry program not found, the bpf objects need to be regenerated")
+	}
+	if len(s.options.ProbeProfiles) > maxProbeProfiles {
+		return fmt.Errorf("too many probe profiles: %d, at most %d", len(s.options.ProbeProfiles), maxProbeProfiles)
+	}
+	probes := make([]probe, 0, len(s.options.ProbeProfiles))
+	for _, o := range s.options.ProbeProfiles {
+		p, err := resolveProbe(o, runtime.GOARCH)
+		if err != nil {
+			return fmt.Errorf("probe profile %s: %w", o.Name, err)
+		}
+		probes = append(probes, p)
+	}
+	opts := &ebpf.CollectionOptions{
+		Programs: s.progOptions(),
+		MapReplacements: map[string]*ebpf.Map{
+			"stacks": s.bpf.Stacks,
+			"pids":   s.bpf.Pids,
+		},
+	}
+	if err := spec.LoadAndAssign(&s.probesBpf, opts); err != nil {
+		s.logVerifierError(err)
+		s.probesBpf.Close()
+		return fmt.Errorf("load probes bpf objects: %w", err)
+	}
+	var links []link.Link
+	fail := func(err error) error {
+		for _, l := range links {
+			_ = l.Close()
+		}
+		s.probesBpf.Close()
+		return err
+	}
+	for i := range probes {
+		p := &probes[i]
+		if err := s.probesBpf.ProbeConfigs.Put(uint32(i), &p.config); err != nil {
+			return fail(fmt.Errorf("update probe configs map: %w", err))
+		}
+		ex, err := link.OpenExecutable(p.options.Binary)
+		if err != nil {
+			return fail(fmt.Errorf("probe profile %s: %w", p.options.Name, err))
+		}
+		p.uprobe.Cookie = uint64(i)
+		l, err := ex.Uprobe(p.symbol, s.probesBpf.ProbeEntry, &p.uprobe)
+		if err != nil {
+			return fail(fmt.Errorf("probe profile %s: link uprobe: %w", p.options.Name, err))
+		}
+		links = append(links, l)
+		if p.config.Value == probeValueLatency {
+			l, err = ex.Uretprobe(p.symbol, s.probesBpf.ProbeReturn, &p.uprobe)
+			if err != nil {
+				return fail(fmt.Errorf("probe profile %s: link uretprobe: %w", p.options.Name, err))
+			}
+			links = append(links, l)
+		}
+	}
+	s.probes = probes
+	s.kprobes = append(s.kprobes, links...)
+	return nil
+}
+
+// collectProbeProfiles reports the values of the probes by the stack they
+// were hit in. The stacks of the samples are added to knownStacks, to be
+// cleared after the regular profile is collected, as the stacks map is
+// shared.
+func (s *session) collectProbeProfiles(cb pprof.CollectProfilesCallback, knownStacks map[uint32]bool) error {
+	m := s.probesBpf.ProbeValues
+	if m == nil {
+		return nil
+	}
+	var (
+		keys   []probeSampleKey
+		values []uint64
+		k      probeSampleKey
+		v      uint64
+	)
+	it := m.Iterate()
+	for it.Next(&k, &v) {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("map %s iteration : %w", m.String(), err)
+	}
+
+	sb := &stackBuilder{}
+	for i := range keys {
+		pk := &keys[i]
+		if err := m.Delete(pk); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("clear probe values map: %w", err)
+		}
+		ck := &pk.Key
+		if ck.UserStack >= 0 {
+			knownStacks[uint32(ck.UserStack)] = true
+		}
+		if ck.KernStack >= 0 {
+			knownStacks[uint32(ck.KernStack)] = true
+		}
+		if int(pk.Probe) >= len(s.probes) {
+			continue
+		}
+		target := s.targetFinder.FindTarget(ck.Pid)
+		if target == nil {
+			continue
+		}
+		if _, ok := s.pids.dead[ck.Pid]; ok {
+			continue
+		}
+
+		stats := StackResolveStats{}
+		sb.reset()
+		sb.append(s.comm(ck.Pid))
+		if s.options.CollectUser && ck.UserStack >= 0 {
+			proc := s.symCache.GetProcTableCached(symtab.PidKey(ck.Pid))
+			if proc == nil {
+				proc = s.symCache.NewProcTable(symtab.PidKey(ck.Pid), s.targetSymbolOptions(target))
+			}
+			if proc.Error() != nil {
+				continue
+			}
+			s.WalkStack(sb, s.GetStack(ck.UserStack), proc, &stats)
+		}
+		if s.options.CollectKernel && ck.KernStack >= 0 {
+			s.WalkStack(sb, s.GetStack(ck.KernStack), s.symCache.GetKallsyms(), &stats)
+		}
+		lo.Reverse(sb.stack)
+		cb(pprof.ProfileSample{
+			Target:      target,
+			Pid:         ck.Pid,
+			Aggregation: pprof.SampleAggregated,
+			SampleType:  pprof.SampleTypeProbe,
+			Probe:       s.probes[pk.Probe].typ,
+			Stack:       sb.stack,
+			Value:       values[i],
+			CgroupID:    s.pids.all[ck.Pid].cgroupID,
+			BuildID:     s.pids.all[ck.Pid].buildID,
+		})
+	}
+	_ = level.Debug(s.logger).Log("msg", "collectProbeProfiles", "count", len(keys))
+	return nil
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"testing"
+
+	"github.com/grafana/pyroscope/ebpf/pprof"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveProbe(t *testing.T) {
+	p, err := resolveProbe(ProbeProfileOptions{Name: "allocs", Binary: "/bin/app", Symbol: "malloc", Value: "arg1", Unit: "bytes"}, "amd64")
+	require.NoError(t, err)
+	assert.Equal(t, pprof.ProbeType{Name: "allocs", Unit: "bytes"}, p.typ)
+	assert.Equal(t, probeConfig{Value: probeValueRegister, Size: 8, RegsOffset: 112}, p.config)
+	assert.Equal(t, "malloc", p.symbol)
+
+	p, err = resolveProbe(ProbeProfileOptions{Name: "read", Binary: "/bin/app", Symbol: "read", Value: "latency", Stack: true}, "arm64")
+	require.NoError(t, err)
+	assert.Equal(t, pprof.ProbeType{Name: "read", Unit: "nanoseconds"}, p.typ)
+	assert.Equal(t, probeConfig{Value: probeValueLatency, CollectStack: 1}, p.config)
+
+	for _, o := range []ProbeProfileOptions{
+		{Binary: "/bin/app", Symbol: "malloc"},
+		{Name: "allocs", Binary: "/bin/app"},
+		{Name: "allocs", Binary: "/bin/app", Symbol: "malloc", USDT: "libc:memory_malloc_retry"},
+		{Name: "allocs", Binary: "/bin/app", Symbol: "malloc", Value: "arg7"},
+		{Name: "allocs", Binary: "/bin/app", Symbol: "malloc", Value: "arg0"},
+		{Name: "allocs", Binary: "/bin/app", Symbol: "malloc", Value: "size"},
+	} {
+		_, err = resolveProbe(o, "amd64")
+		assert.Error(t, err, o)
+	}
+}
+
+func TestPtRegsOffset(t *testing.T) {
+	for _, tc := range []struct {
+		arch, register string
+		offset         uint32
+	}{
+		{"amd64", "rdi", 112},
+		{"amd64", "edi", 112},
+		{"amd64", "r8d", 72},
+		{"amd64", "r12", 24},
+		{"amd64", "rsp", 152},
+		{"arm64", "x0", 0},
+		{"arm64", "w3", 24},
+		{"arm64", "sp", 248},
+	} {
+		offset, ok := ptRegsOffset(tc.arch, tc.register)
+		assert.True(t, ok, tc.register)
+		assert.Equal(t, tc.offset, offset, tc.register)
+	}
+	_, ok := ptRegsOffset("amd64", "xmm0")
+	assert.False(t, ok)
+	_, ok = ptRegsOffset("arm64", "x31")
+	assert.False(t, ok)
+}
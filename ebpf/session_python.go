@@ -12,6 +12,7 @@ import (
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/btf"
 	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/procfs"
 	"github.com/grafana/pyroscope/ebpf/pyrobpf"
 	"github.com/grafana/pyroscope/ebpf/python"
 	"github.com/grafana/pyroscope/ebpf/sd"
@@ -74,6 +75,10 @@ func (s *session) startPythonProfiling(pid uint32, target *sd.Target, pi procInf
 	}
 	_ = level.Info(s.logger).Log("msg", "pyperf process profiling init success", "pid", pid,
 		"py_data", fmt.Sprintf("%+v", pyData), "target", target.String())
+	if err = s.updateThreadFilterLocked(pid, target); err != nil {
+		s.disableThreadFilteredPidLocked(pid, pi, err)
+		return false
+	}
 	s.setPidConfig(pid, pi, s.options.CollectUser, s.options.CollectKernel)
 	return false
 }
@@ -138,7 +143,7 @@ func (s *session) loadPyPerf(cause *sd.Target) (*python.Perf, error) {
 		spec.Maps[python.MapNameSymbols].MaxEntries = s.options.BPFMapsOptions.SymbolsMapSize
 	}
 
-	err = spec.LoadAndAssign(&s.pyperfBpf, opts)
+	err = s.loadAndAssignPinned(spec, pinnedPythonMaps, &s.pyperfBpf, opts)
 	if err != nil {
 		s.logVerifierError(err)
 		return nil, fmt.Errorf("pyperf load %w", err)
@@ -192,17 +197,14 @@ func (s *session) WalkPythonStack(sb *stackBuilder, stack []byte, target *sd.Tar
 				}
 			}
 			classname := python.PythonString(sym.Classname[:], &sym.ClassnameType)
+			if sym.ClassnameType.Type&uint8(python.PyStrTypeUnresolved) != 0 {
+				classname = pythonUnresolvedMethod
+			}
 			name := python.PythonString(sym.Name[:], &sym.NameType)
 			if skipPythonFrame(classname, filename, name) {
 				continue
 			}
-			var frame string
-			if classname == "" {
-				frame = filename + " " + name
-			} else {
-				frame = filename + " " + classname + "." + name
-			}
-			sb.append(frame)
+			sb.append(pythonFrame(filename, classname, name, proc.Qualnames))
 			stats.known += 1
 		} else {
 			sb.append("pyperf_unknown")
@@ -214,6 +216,14 @@ func (s *session) WalkPythonStack(sb *stackBuilder, stack []byte, target *sd.Tar
 	lo.Reverse(sb.stack[begin:end])
 }
 
+// pythonUnresolvedMethod replaces the class name of a method, with a self or
+// cls first argument, the class of which could not be resolved.
+const pythonUnresolvedMethod = "[unresolved method]"
+
+func pythonFrame(filename, classname, name string, qualnames bool) string {
+	return filename + " " + python.QualifiedName(classname, name, qualnames)
+}
+
 func skipPythonFrame(classname string, filename string, name string) bool {
 	// for now only skip _Py_InitCleanup frames in userspace
 	// https://github.com/python/cpython/blob/9eb2489266c4c1f115b8f72c0728db737cc8a815/Python/specialize.c#L2534
@@ -221,7 +231,7 @@ func skipPythonFrame(classname string, filename string, name string) bool {
 }
 
 func processAlive(pid uint32) bool {
-	_, err := os.Stat(fmt.Sprintf("/proc/%d", pid))
+	_, err := os.Stat(procfs.Path(pid))
 	return err == nil
 }
 
@@ -0,0 +1,16 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPythonFrame(t *testing.T) {
+	assert.Equal(t, "server.py cell_cls_issue", pythonFrame("server.py", "", "cell_cls_issue", false))
+	assert.Equal(t, "server.py CellSelfIssue.bar", pythonFrame("server.py", "CellSelfIssue", "bar", false))
+	assert.Equal(t, "server.py [unresolved method].__call__", pythonFrame("server.py", pythonUnresolvedMethod, "__call__", false))
+	assert.Equal(t, "server.py CellSelfIssue.bar", pythonFrame("server.py", pythonUnresolvedMethod, "CellSelfIssue.bar", true))
+}
@@ -0,0 +1,222 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/sd"
+	"github.com/grafana/pyroscope/ebpf/symtab"
+	"github.com/samber/lo"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultRawSamplesMaxRate is the default cap of the raw samples delivered
+// per second.
+const DefaultRawSamplesMaxRate = 1000
+
+// RawSample is a CPU sample of a target, delivered as it is taken instead of
+// aggregated in the profile of the round.
+type RawSample struct {
+	Target    *sd.Target
+	Pid       uint32
+	Tid       uint32
+	CPU       uint32
+	Timestamp time.Time
+	// Stack is ordered from the leaf frame, like pprof.ProfileSample.Stack.
+	Stack []string
+}
+
+type RawSamplesOptions struct {
+	// Callback receives the raw samples of the processes profiled with frame
+	// pointers, the raw samples are enabled if it is set. It is called from
+	// a goroutine of the session and should not block. The samples are
+	// still aggregated in the profiles. The session fails to start if the
+	// bpf objects can't deliver the raw samples.
+	Callback func(RawSample)
+	// MaxRate caps the raw samples delivered per second, the samples above
+	// it are dropped. DefaultRawSamplesMaxRate if 0.
+	MaxRate int
+}
+
+// the size of struct raw_sample
+const rawSampleSize = 40
+
+// loadRawSamplesMaps creates the maps the bpf program sends the raw samples
+// through, shared with the programs of the spec, if the raw samples are
+// enabled. It fails if the spec has no raw samples maps.
+func (s *session) loadRawSamplesMaps(spec *ebpf.CollectionSpec, opts *ebpf.CollectionOptions) error {
+	if s.options.RawSamples.Callback == nil {
+		return nil
+	}
+	samplesSpec, ok := spec.Maps["raw_samples"]
+	enabledSpec, ok2 := spec.Maps["raw_samples_enabled"]
+	if !ok || !ok2 {
+		return errors.New("raw_samples maps not found, the bpf objects need to be regenerated")
+	}
+	samples, err := ebpf.NewMap(samplesSpec)
+	if err != nil {
+		return err
+	}
+	enabled, err := ebpf.NewMap(enabledSpec)
+	if err != nil {
+		_ = samples.Close()
+		return err
+	}
+	zero, one := uint32(0), uint32(1)
+	if err = enabled.Update(&zero, &one, ebpf.UpdateAny); err != nil {
+		_ = samples.Close()
+		_ = enabled.Close()
+		return err
+	}
+	if opts.MapReplacements == nil {
+		opts.MapReplacements = make(map[string]*ebpf.Map)
+	}
+	opts.MapReplacements["raw_samples"] = samples
+	opts.MapReplacements["raw_samples_enabled"] = enabled
+	s.rawSamples = samples
+	s.rawSamplesEnabled = enabled
+	return nil
+}
+
+func (s *session) closeRawSamplesLocked() {
+	if s.rawSamplesReader != nil {
+		_ = s.rawSamplesReader.Close()
+		s.rawSamplesReader = nil
+	}
+	if s.rawSamples != nil {
+		_ = s.rawSamples.Close()
+		s.rawSamples = nil
+	}
+	if s.rawSamplesEnabled != nil {
+		_ = s.rawSamplesEnabled.Close()
+		s.rawSamplesEnabled = nil
+	}
+}
+
+func (s *session) readRawSamples(reader *perf.Reader, maxRate int) {
+	if maxRate <= 0 {
+		maxRate = DefaultRawSamplesMaxRate
+	}
+	limiter := rateLimiter{max: maxRate}
+	bootTime := monotonicBootTime()
+	sb := &stackBuilder{}
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, perf.ErrClosed) {
+				return
+			}
+			_ = level.Error(s.logger).Log("msg", "reading from raw samples reader", "err", err)
+			continue
+		}
+		if record.LostSamples != 0 {
+			_ = level.Debug(s.logger).Log("msg", "raw samples ring buffer full, dropped samples", "n", record.LostSamples)
+		}
+		if len(record.RawSample) < rawSampleSize {
+			continue
+		}
+		dropped, ok := limiter.allow(time.Now())
+		if dropped > 0 {
+			_ = level.Warn(s.logger).Log("msg", "raw samples rate limited, dropped samples", "n", dropped)
+		}
+		if !ok {
+			continue
+		}
+		raw := record.RawSample
+		sample := RawSample{
+			Timestamp: bootTime.Add(time.Duration(binary.LittleEndian.Uint64(raw[0:8]))),
+			Pid:       binary.LittleEndian.Uint32(raw[8:12]),
+			Tid:       binary.LittleEndian.Uint32(raw[12:16]),
+			CPU:       binary.LittleEndian.Uint32(raw[16:20]),
+		}
+		kernStack := int64(binary.LittleEndian.Uint64(raw[24:32]))
+		userStack := int64(binary.LittleEndian.Uint64(raw[32:40]))
+		if cb := s.resolveRawSample(&sample, kernStack, userStack, sb); cb != nil {
+			cb(sample)
+		}
+	}
+}
+
+// resolveRawSample symbolizes the stacks of the sample, while they are still
+// in the stacks map. It returns the callback to deliver the sample to, or nil
+// if the sample is dropped.
+func (s *session) resolveRawSample(sample *RawSample, kernStack, userStack int64, sb *stackBuilder) func(RawSample) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.started {
+		return nil
+	}
+	if _, ok := s.pids.dead[sample.Pid]; ok {
+		return nil
+	}
+	target := s.targetFinder.FindTarget(sample.Pid)
+	if target == nil {
+		return nil
+	}
+	stats := StackResolveStats{}
+	sb.reset()
+	sb.append(s.comm(sample.Pid))
+	if s.options.CollectUser {
+		pk := symtab.PidKey(sample.Pid)
+		proc := s.symCache.GetProcTableCached(pk)
+		if proc == nil {
+			proc = s.symCache.NewProcTable(pk, s.targetSymbolOptions(target))
+		}
+		if proc.Error() != nil {
+			return nil
+		}
+		s.WalkStack(sb, s.GetStack(userStack), proc, &stats)
+	}
+	if s.options.CollectKernel {
+		target = s.appendKernelStack(sb, s.GetStack(kernStack), target, &stats)
+	}
+	if len(sb.stack) == 1 {
+		return nil
+	}
+	lo.Reverse(sb.stack)
+	sample.Target = target
+	sample.Stack = slices.Clone(sb.stack)
+	return s.options.RawSamples.Callback
+}
+
+// rateLimiter allows up to max events per second.
+type rateLimiter struct {
+	max     int
+	window  time.Time
+	n       int
+	dropped int
+}
+
+// allow reports if the event is allowed, and the number of the events dropped
+// in the previous second, when a new second begins.
+func (l *rateLimiter) allow(now time.Time) (int, bool) {
+	dropped := 0
+	if now.Sub(l.window) >= time.Second {
+		dropped = l.dropped
+		l.window, l.n, l.dropped = now, 0, 0
+	}
+	if l.n >= l.max {
+		l.dropped++
+		return dropped, false
+	}
+	l.n++
+	return dropped, true
+}
+
+// monotonicBootTime returns the wall clock time of the zero of the monotonic
+// clock the bpf timestamps are taken from.
+func monotonicBootTime() time.Time {
+	var ts unix.Timespec
+	now := time.Now()
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return now
+	}
+	return now.Add(-time.Duration(ts.Nano()))
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawSamplesRateLimiter(t *testing.T) {
+	l := rateLimiter{max: 2}
+	now := time.Unix(1700000000, 0)
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		dropped, ok := l.allow(now.Add(time.Duration(i) * time.Millisecond))
+		assert.Equal(t, 0, dropped)
+		if ok {
+			allowed++
+		}
+	}
+	assert.Equal(t, 2, allowed)
+
+	dropped, ok := l.allow(now.Add(time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 3, dropped)
+}
@@ -0,0 +1,231 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/pyrobpf"
+	"github.com/grafana/pyroscope/ebpf/ruby"
+	"github.com/grafana/pyroscope/ebpf/sd"
+	"github.com/samber/lo"
+)
+
+const (
+	progIdxRuby      = 2
+	progIdxRubyStack = 3
+)
+
+// rbperfObjects are the programs and maps of the ruby profiling. The
+// rbperf_collect program is tail called by do_perf_event for the main thread
+// of the processes registered in the rb_pid_config map.
+type rbperfObjects struct {
+	RbperfCollect *ebpf.Program `ebpf:"rbperf_collect"`
+	ReadRubyStack *ebpf.Program `ebpf:"read_ruby_stack"`
+	RbPidConfig   *ebpf.Map     `ebpf:"rb_pid_config"`
+	RbSymbols     *ebpf.Map     `ebpf:"rb_symbols"`
+	RubyStacks    *ebpf.Map     `ebpf:"ruby_stacks"`
+}
+
+func (o *rbperfObjects) Close() {
+	_ = o.RbperfCollect.Close()
+	_ = o.ReadRubyStack.Close()
+	_ = o.RbPidConfig.Close()
+	_ = o.RbSymbols.Close()
+	_ = o.RubyStacks.Close()
+	*o = rbperfObjects{}
+}
+
+func (s *session) tryStartRubyProfiling(pid uint32, target *sd.Target, pi procInfoLite) {
+	const nTries = 4
+	for i := 0; i < nTries; i++ {
+		shouldRetry := s.startRubyProfiling(pid, target, pi, i == nTries-1)
+		if !shouldRetry {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startRubyProfiling registers the process in rbperf. The processes rbperf
+// fails for are profiled with the frame pointers.
+func (s *session) startRubyProfiling(pid uint32, target *sd.Target, pi procInfoLite, lastAttempt bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.started {
+		return false
+	}
+	_, dead := s.pids.dead[pid]
+	if dead {
+		return false
+	}
+	collectKernel := s.collectKernelEnabled(target)
+	fallback := func() {
+		pi.typ = pyrobpf.ProfilingTypeFramepointers
+		if err := s.updateThreadFilterLocked(pid, target); err != nil {
+			s.disableThreadFilteredPidLocked(pid, pi, err)
+			return
+		}
+		s.setPidConfig(pid, pi, s.options.CollectUser, collectKernel)
+		s.updateUnwindPidLocked(pid)
+	}
+	rbPerf := s.getRbPerfLocked()
+	if rbPerf == nil {
+		fallback()
+		return false
+	}
+
+	rbData, version, err := ruby.GetRbPerfPidData(pid, collectKernel)
+	svc := target.ServiceName()
+	if err != nil {
+		alive := processAlive(pid)
+		if alive && lastAttempt {
+			s.options.Metrics.Ruby.PidDataError.WithLabelValues(svc).Inc()
+			_ = level.Error(s.logger).Log("err", err, "msg", "rbperf get ruby process data failed", "pid", pid, "target", target.String())
+		} else {
+			_ = level.Debug(s.logger).Log("err", err, "msg", "rbperf get ruby process data failed", "pid", pid, "target", target.String())
+		}
+		if !alive || lastAttempt {
+			fallback()
+		}
+		return alive && !lastAttempt
+	}
+	if _, err = rbPerf.NewProc(pid, rbData, version, svc); err != nil {
+		_ = level.Error(s.logger).Log("err", err, "msg", "rbperf process profiling init failed", "pid", pid)
+		fallback()
+		return false
+	}
+	_ = level.Info(s.logger).Log("msg", "rbperf process profiling init success", "pid", pid,
+		"version", version.String(), "target", target.String())
+	if err = s.updateThreadFilterLocked(pid, target); err != nil {
+		s.disableThreadFilteredPidLocked(pid, pi, err)
+		return false
+	}
+	s.setPidConfig(pid, pi, s.options.CollectUser, collectKernel)
+	return false
+}
+
+// may return nil if loadRbPerf returns error
+func (s *session) getRbPerfLocked() *ruby.Perf {
+	if s.rbperf != nil {
+		return s.rbperf
+	}
+	if s.rbperfError != nil {
+		return nil
+	}
+	s.options.Metrics.Ruby.Load.Inc()
+	rbperf, err := s.loadRbPerf()
+	if err != nil {
+		s.rbperfError = err
+		s.options.Metrics.Ruby.LoadError.Inc()
+		_ = level.Error(s.logger).Log("err", err, "msg", "load rbperf")
+		return nil
+	}
+	s.rbperf = rbperf
+	return s.rbperf
+}
+
+func (s *session) loadRbPerf() (*ruby.Perf, error) {
+	defer btf.FlushKernelSpec() // save some memory
+
+	spec, err := pyrobpf.LoadProfile()
+	if err != nil {
+		return nil, fmt.Errorf("rbperf load %w", err)
+	}
+	if _, ok := spec.Programs["rbperf_collect"]; !ok {
+		return nil, errors.New("rbperf_collect program not found, the bpf objects need to be regenerated")
+	}
+	_, nsIno, err := getPIDNamespace()
+	if !os.IsNotExist(err) {
+		if err != nil {
+			return nil, fmt.Errorf("unable to get pid namespace %w", err)
+		}
+		err = spec.RewriteConstants(map[string]interface{}{
+			"global_config": pyrobpf.ProfileGlobalConfigT{
+				NsPidIno: nsIno,
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("rbperf rewrite constants %w", err)
+		}
+	}
+	if s.options.BPFMapsOptions.SymbolsMapSize != 0 {
+		spec.Maps[ruby.MapNameSymbols].MaxEntries = s.options.BPFMapsOptions.SymbolsMapSize
+	}
+	opts := &ebpf.CollectionOptions{
+		Programs: s.progOptions(),
+		MapReplacements: map[string]*ebpf.Map{
+			"stacks": s.bpf.Stacks,
+			"counts": s.bpf.Counts,
+			"progs":  s.bpf.Progs,
+		},
+	}
+	if err = s.loadAndAssignPinned(spec, pinnedRubyMaps, &s.rbperfBpf, opts); err != nil {
+		s.logVerifierError(err)
+		return nil, fmt.Errorf("rbperf load %w", err)
+	}
+	rbperf := ruby.NewPerf(s.logger, s.options.Metrics.Ruby, s.rbperfBpf.RbPidConfig, s.rbperfBpf.RbSymbols)
+	if err = s.bpf.Progs.Update(uint32(progIdxRubyStack), s.rbperfBpf.ReadRubyStack, ebpf.UpdateAny); err != nil {
+		s.rbperfBpf.Close()
+		return nil, fmt.Errorf("rbperf link %w", err)
+	}
+	if err = s.bpf.Progs.Update(uint32(progIdxRuby), s.rbperfBpf.RbperfCollect, ebpf.UpdateAny); err != nil {
+		s.rbperfBpf.Close()
+		return nil, fmt.Errorf("rbperf link %w", err)
+	}
+	_ = level.Info(s.logger).Log("msg", "rbperf loaded")
+	return rbperf, nil
+}
+
+func (s *session) GetRubyStack(stackId int64) []byte {
+	if s.rbperfBpf.RubyStacks == nil {
+		return nil
+	}
+	res, err := s.rbperfBpf.RubyStacks.LookupBytes(uint32(stackId))
+	if err != nil {
+		return nil
+	}
+	return res
+}
+
+func (s *session) WalkRubyStack(sb *stackBuilder, stack []byte, target *sd.Target, rbSymbols *ruby.LazySymbols, stats *StackResolveStats) {
+	if len(stack) == 0 {
+		return
+	}
+
+	svc := target.ServiceName()
+
+	begin := len(sb.stack)
+	for len(stack) >= 4 {
+		symbolID := binary.LittleEndian.Uint32(stack[:4])
+		stack = stack[4:]
+		if symbolID == 0 {
+			break
+		}
+		sym, err := rbSymbols.GetSymbol(symbolID, svc)
+		if err == nil {
+			sb.append(rubyFrame(ruby.CString(sym.Label[:]), ruby.CString(sym.Path[:]), sym.Lineno))
+			stats.known += 1
+		} else {
+			sb.append("rbperf_unknown")
+			s.options.Metrics.Ruby.UnknownSymbols.WithLabelValues(svc).Inc()
+			stats.unknownSymbols += 1
+		}
+	}
+	end := len(sb.stack)
+	lo.Reverse(sb.stack[begin:end])
+}
+
+// rubyFrame formats a ruby frame as method@file:line, the line is the first
+// line of the method.
+func rubyFrame(label, path string, lineno uint32) string {
+	return label + "@" + path + ":" + strconv.FormatUint(uint64(lineno), 10)
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRubyFrame(t *testing.T) {
+	assert.Equal(t, "burn@/burner.rb:4", rubyFrame("burn", "/burner.rb", 4))
+	assert.Equal(t, "block in work@app.rb:12", rubyFrame("block in work", "app.rb", 12))
+}
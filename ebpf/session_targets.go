@@ -0,0 +1,57 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"github.com/cilium/ebpf"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/pyrobpf"
+	"github.com/grafana/pyroscope/ebpf/sd"
+)
+
+// onTargetEvents reacts to the changes of the targets as soon as they are
+// made: the pids of the removed targets are not sampled anymore, and the
+// options of the updated targets are applied to their pids. The pids of the
+// added targets are picked up from the unknown pids by UpdateTargets.
+func (s *session) onTargetEvents(events []sd.TargetEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.started {
+		return
+	}
+	updated := false
+	for _, e := range events {
+		_ = level.Debug(s.logger).Log("msg", "target changed", "event", e.Type, "target", e.Target.String())
+		updated = updated || e.Type == sd.TargetUpdated
+	}
+	for pid, pi := range s.pids.all {
+		target := s.targetFinder.FindTarget(pid)
+		if target == nil {
+			s.stopProfilingLocked(pid)
+			continue
+		}
+		if updated {
+			if err := s.updateThreadFilterLocked(pid, target); err != nil {
+				s.disableThreadFilteredPidLocked(pid, pi, err)
+				continue
+			}
+			s.setPidConfig(pid, pi, s.options.CollectUser, s.collectKernelEnabled(target))
+		}
+	}
+}
+
+// stopProfilingLocked stops the sampling of a live pid which is not a target
+// anymore. It is started again if a target of the pid is added.
+func (s *session) stopProfilingLocked(pid uint32) {
+	_ = level.Debug(s.logger).Log("msg", "target detached", "event", "target_detach", "pid", pid)
+	delete(s.pids.all, pid)
+	s.removeThreadFilterLocked(pid)
+	if s.pyperf != nil {
+		s.pyperf.RemoveDeadPID(pid)
+	}
+	config := &pyrobpf.ProfilePidConfig{Type: uint8(pyrobpf.ProfilingTypeUnknown)}
+	if err := s.bpf.Pids.Update(&pid, config, ebpf.UpdateAny); err != nil {
+		_ = level.Error(s.logger).Log("msg", "updating pids map", "err", err)
+	}
+	s.saveUnknownPIDLocked(pid)
+}
@@ -0,0 +1,153 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/procfs"
+	"github.com/grafana/pyroscope/ebpf/pyrobpf"
+	"github.com/grafana/pyroscope/ebpf/sd"
+)
+
+// threadFilter restricts the profiling of a process to its threads with a
+// name matching the regex. The matching threads are listed from the procfs,
+// when the profiling of the process starts and on every collection round, and
+// written to the threads map the bpf program filters the samples with.
+type threadFilter struct {
+	re   *regexp.Regexp
+	tids map[uint32]struct{}
+}
+
+var errNoThreadsMap = errors.New("threads map not found, the bpf objects need to be regenerated")
+
+// loadThreadsMap creates the threads map, shared with the programs of the
+// spec. The filtering is not available if the spec has no threads map: the
+// pids of the targets with a thread filter are not profiled.
+func (s *session) loadThreadsMap(spec *ebpf.CollectionSpec, opts *ebpf.CollectionOptions) error {
+	ms, ok := spec.Maps["threads"]
+	if !ok {
+		return nil
+	}
+	m, err := ebpf.NewMap(ms)
+	if err != nil {
+		return err
+	}
+	if opts.MapReplacements == nil {
+		opts.MapReplacements = make(map[string]*ebpf.Map)
+	}
+	opts.MapReplacements["threads"] = m
+	s.threads = m
+	return nil
+}
+
+// updateThreadFilterLocked sets up the thread filter of the pid, if the target
+// has the thread name regex option. It returns an error if the filter can't
+// be set up, see disableThreadFilteredPidLocked.
+func (s *session) updateThreadFilterLocked(pid uint32, target *sd.Target) error {
+	expr, ok := target.Get(sd.OptionThreadNameRegex)
+	if !ok {
+		s.removeThreadFilterLocked(pid)
+		return nil
+	}
+	if s.threads == nil {
+		return errNoThreadsMap
+	}
+	f := s.threadFilters[pid]
+	if f == nil || f.re.String() != "^(?:"+expr+")$" {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			s.removeThreadFilterLocked(pid)
+			return fmt.Errorf("invalid thread name regex %q: %w", expr, err)
+		}
+		s.removeThreadFilterLocked(pid)
+		f = &threadFilter{re: re, tids: make(map[uint32]struct{})}
+		s.threadFilters[pid] = f
+	}
+	s.refreshThreadFilterLocked(pid, f)
+	return nil
+}
+
+// disableThreadFilteredPidLocked stops the sampling of a pid whose thread
+// filter can't be set up: sampling all its threads would report the threads
+// the filter excludes. The filter is set up again when the target changes.
+func (s *session) disableThreadFilteredPidLocked(pid uint32, pi procInfoLite, err error) {
+	_ = level.Error(s.logger).Log("msg", "thread filter failed, the pid is not profiled", "pid", pid, "err", err)
+	s.pids.all[pid] = pi
+	config := &pyrobpf.ProfilePidConfig{Type: uint8(pyrobpf.ProfilingTypeError)}
+	if err := s.bpf.Pids.Update(&pid, config, ebpf.UpdateAny); err != nil {
+		_ = level.Error(s.logger).Log("msg", "updating pids map", "err", err)
+	}
+}
+
+// refreshThreadFiltersLocked follows the threads started, renamed and exited
+// since the last round.
+func (s *session) refreshThreadFiltersLocked() {
+	for pid, f := range s.threadFilters {
+		s.refreshThreadFilterLocked(pid, f)
+	}
+}
+
+func (s *session) refreshThreadFilterLocked(pid uint32, f *threadFilter) {
+	entries, err := os.ReadDir(procfs.Path(pid, "task"))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			_ = level.Error(s.logger).Log("msg", "listing threads", "pid", pid, "err", err)
+		}
+		return
+	}
+	matching := make(map[uint32]struct{}, len(f.tids))
+	for _, e := range entries {
+		tid, err := strconv.ParseUint(e.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		comm, err := os.ReadFile(procfs.Path(pid, "task", e.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		if f.re.MatchString(strings.TrimSuffix(string(comm), "\n")) {
+			matching[uint32(tid)] = struct{}{}
+		}
+	}
+	one := uint8(1)
+	for tid := range matching {
+		if _, ok := f.tids[tid]; ok {
+			continue
+		}
+		if err := s.threads.Update(&tid, &one, ebpf.UpdateAny); err != nil {
+			_ = level.Error(s.logger).Log("msg", "updating threads map", "tid", tid, "err", err)
+			delete(matching, tid)
+		}
+	}
+	for tid := range f.tids {
+		if _, ok := matching[tid]; !ok {
+			s.deleteThreadLocked(tid)
+		}
+	}
+	f.tids = matching
+}
+
+func (s *session) removeThreadFilterLocked(pid uint32) {
+	f := s.threadFilters[pid]
+	if f == nil {
+		return
+	}
+	for tid := range f.tids {
+		s.deleteThreadLocked(tid)
+	}
+	delete(s.threadFilters, pid)
+}
+
+func (s *session) deleteThreadLocked(tid uint32) {
+	if err := s.threads.Delete(&tid); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		_ = level.Error(s.logger).Log("msg", "deleting from threads map", "tid", tid, "err", err)
+	}
+}
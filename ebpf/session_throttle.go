@@ -0,0 +1,163 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/pprof"
+	"github.com/grafana/pyroscope/ebpf/pyrobpf"
+	"github.com/grafana/pyroscope/ebpf/symtab"
+	"github.com/samber/lo"
+)
+
+// throttleObjects are the programs and maps of the CPU throttling profile.
+// The kprobes record the stack running when a cfs_rq runs out of its CPU
+// quota, and account the time until the cfs_rq is unthrottled to the stack.
+type throttleObjects struct {
+	ThrottleCfsRq   *ebpf.Program `ebpf:"throttle_cfs_rq"`
+	UnthrottleCfsRq *ebpf.Program `ebpf:"unthrottle_cfs_rq"`
+	ThrottleStarts  *ebpf.Map     `ebpf:"throttle_starts"`
+	ThrottledTime   *ebpf.Map     `ebpf:"throttled_time"`
+}
+
+func (o *throttleObjects) Close() {
+	_ = o.ThrottleCfsRq.Close()
+	_ = o.UnthrottleCfsRq.Close()
+	_ = o.ThrottleStarts.Close()
+	_ = o.ThrottledTime.Close()
+	*o = throttleObjects{}
+}
+
+func (s *session) loadThrottleLocked(spec *ebpf.CollectionSpec) error {
+	if _, ok := spec.Programs["throttle_cfs_rq"]; !ok {
+		return errors.New("throttle_cfs_rq program not found, the bpf objects need to be regenerated")
+	}
+	opts := &ebpf.CollectionOptions{
+		Programs: s.progOptions(),
+		MapReplacements: map[string]*ebpf.Map{
+			"stacks": s.bpf.Stacks,
+			"pids":   s.bpf.Pids,
+		},
+	}
+	if err := spec.LoadAndAssign(&s.throttleBpf, opts); err != nil {
+		s.logVerifierError(err)
+		s.throttleBpf.Close()
+		return fmt.Errorf("load throttle bpf objects: %w", err)
+	}
+	var kprobes []link.Link
+	for _, it := range []struct {
+		kprobe string
+		prog   *ebpf.Program
+	}{
+		{kprobe: "throttle_cfs_rq", prog: s.throttleBpf.ThrottleCfsRq},
+		{kprobe: "unthrottle_cfs_rq", prog: s.throttleBpf.UnthrottleCfsRq},
+	} {
+		// the functions are static and may be inlined by the compiler
+		kp, err := link.Kprobe(it.kprobe, it.prog, nil)
+		if err != nil {
+			for _, kp := range kprobes {
+				_ = kp.Close()
+			}
+			s.throttleBpf.Close()
+			return fmt.Errorf("link kprobe %s: %w", it.kprobe, err)
+		}
+		kprobes = append(kprobes, kp)
+	}
+	s.kprobes = append(s.kprobes, kprobes...)
+	return nil
+}
+
+// collectThrottleProfile reports the throttled time by the stack running when
+// the throttling began. It returns the stacks of the samples, to be cleared
+// after the regular profile is collected, as the stacks map is shared.
+func (s *session) collectThrottleProfile(cb pprof.CollectProfilesCallback) (map[uint32]bool, error) {
+	m := s.throttleBpf.ThrottledTime
+	if m == nil {
+		return nil, nil
+	}
+	knownStacks := map[uint32]bool{}
+	if err := s.collectStackTimes(cb, m, pprof.SampleTypeThrottle, knownStacks); err != nil {
+		return nil, err
+	}
+	return knownStacks, nil
+}
+
+// collectStackTimes reports the nanoseconds of the map, by sample key, as
+// samples of the sample type, and clears the map. The stacks of the samples
+// are added to knownStacks.
+func (s *session) collectStackTimes(cb pprof.CollectProfilesCallback, m *ebpf.Map, sampleType pprof.SampleType, knownStacks map[uint32]bool) error {
+	var (
+		keys   []pyrobpf.ProfileSampleKey
+		values []uint64
+		k      pyrobpf.ProfileSampleKey
+		v      uint64
+	)
+	it := m.Iterate()
+	for it.Next(&k, &v) {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("map %s iteration : %w", m.String(), err)
+	}
+
+	sb := &stackBuilder{}
+	for i := range keys {
+		ck := &keys[i]
+		if err := m.Delete(ck); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("clear map %s: %w", m.String(), err)
+		}
+		if ck.UserStack >= 0 {
+			knownStacks[uint32(ck.UserStack)] = true
+		}
+		if ck.KernStack >= 0 {
+			knownStacks[uint32(ck.KernStack)] = true
+		}
+		target := s.targetFinder.FindTarget(ck.Pid)
+		if target == nil {
+			continue
+		}
+		if _, ok := s.pids.dead[ck.Pid]; ok {
+			continue
+		}
+
+		stats := StackResolveStats{}
+		sb.reset()
+		sb.append(s.comm(ck.Pid))
+		if s.options.CollectUser {
+			pk := symtab.PidKey(ck.Pid)
+			proc := s.symCache.GetProcTableCached(pk)
+			if proc == nil {
+				proc = s.symCache.NewProcTable(pk, s.targetSymbolOptions(target))
+			}
+			if proc.Error() != nil {
+				continue
+			}
+			s.WalkStack(sb, s.GetStack(ck.UserStack), proc, &stats)
+		}
+		if s.options.CollectKernel {
+			s.WalkStack(sb, s.GetStack(ck.KernStack), s.symCache.GetKallsyms(), &stats)
+		}
+		if len(sb.stack) == 1 {
+			continue // only comm
+		}
+		lo.Reverse(sb.stack)
+		cb(pprof.ProfileSample{
+			Target:      target,
+			Pid:         ck.Pid,
+			Aggregation: pprof.SampleAggregated,
+			SampleType:  sampleType,
+			Stack:       sb.stack,
+			Value:       values[i],
+			CgroupID:    s.pids.all[ck.Pid].cgroupID,
+			BuildID:     s.pids.all[ck.Pid].buildID,
+		})
+	}
+	_ = level.Debug(s.logger).Log("msg", "collectStackTimes", "map", m.String(), "count", len(keys))
+	return nil
+}
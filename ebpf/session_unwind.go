@@ -0,0 +1,311 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/procfs"
+	elf2 "github.com/grafana/pyroscope/ebpf/symtab/elf"
+)
+
+// maxUnwindBinaries bounds the number of binaries the unwind outcomes are
+// tracked for. The outcomes of the binaries seen after the limit is
+// reached are not tracked.
+const maxUnwindBinaries = 4096
+
+// maxStackDepth is the number of frames the bpf program collects.
+// Deeper stacks are truncated.
+const maxStackDepth = 127
+
+// A binary is flagged as likely compiled without frame pointers if at least
+// noFramePointersMinSamples samples are taken in it, and the stacks of
+// most of them are not deeper than shallowStackFrames: the unwinding stops
+// right after the leaf frame.
+const (
+	noFramePointersMinSamples   = 100
+	noFramePointersShallowRatio = 0.9
+	shallowStackFrames          = 2
+)
+
+const noFramePointersHint = "rebuild the binary with frame pointers: -fno-omit-frame-pointer for C/C++, -C force-frame-pointers=yes for Rust"
+
+// sframeHint is reported for the binaries without frame pointers which have
+// SFrame unwind tables. The tables are detected, but the bpf program only
+// unwinds with frame pointers so far.
+const sframeHint = "the binary has .sframe unwind tables, which are not used for unwinding yet, " + noFramePointersHint
+
+// dwarfHint is reported for the binaries without frame pointers unwound with
+// their .eh_frame unwind tables, see DWARFUnwindingOptions.
+const dwarfHint = "the binary is unwound with its .eh_frame unwind table, the stacks deeper than the limits of the bpf unwinder are truncated"
+
+type unwindOutcome int
+
+const (
+	unwindFull unwindOutcome = iota
+	unwindTruncated
+	unwindFailed
+)
+
+// unwindStack describes the unwinding of a native user stack,
+// it is filled by WalkStack.
+type unwindStack struct {
+	frames int
+	// modules are the distinct binaries of the frames, from the leaf.
+	modules []string
+	// rootModule is the binary of the outermost frame,
+	// empty if the frame is not in any mapping.
+	rootModule string
+	// outerModule is the binary of the outermost frame in a mapping.
+	outerModule string
+	// leafModule is the binary of the innermost frame.
+	leafModule string
+}
+
+func (u *unwindStack) reset() {
+	u.frames = 0
+	u.modules = u.modules[:0]
+	u.rootModule = ""
+	u.outerModule = ""
+	u.leafModule = ""
+}
+
+func (u *unwindStack) addFrame(module string) {
+	u.frames++
+	u.rootModule = module
+	if u.frames == 1 {
+		u.leafModule = module
+	}
+	if module == "" {
+		return
+	}
+	u.outerModule = module
+	for _, m := range u.modules {
+		if m == module {
+			return
+		}
+	}
+	u.modules = append(u.modules, module)
+}
+
+type unwindCounts struct {
+	full      uint64
+	truncated uint64
+	failed    uint64
+
+	// leaf is the number of samples taken in the binary,
+	// shallow is the number of those with a shallow stack.
+	leaf    uint64
+	shallow uint64
+
+	noFramePointers bool
+	buildID         string
+	// sframe is set if the binary has an SFrame unwind table.
+	sframe bool
+	// dwarf is set if the binary is unwound with its .eh_frame table.
+	dwarf bool
+}
+
+// unwindStats tracks the outcomes of the native user stack unwinding per
+// binary. A stack unwinds fully if the frame pointers chain ends in a
+// mapped binary before the maximum depth. If the chain ends in an unmapped
+// address, the stack is truncated, and the outermost binary found, which
+// likely lacks frame pointers, is blamed for it; the frames of the other
+// binaries unwound fine. If no frame is found at all, the unwinding failed
+// and the executable of the process is blamed for it.
+// It is guarded by the session mutex.
+type unwindStats struct {
+	binaries map[string]*unwindCounts
+}
+
+func newUnwindStats() *unwindStats {
+	return &unwindStats{binaries: make(map[string]*unwindCounts)}
+}
+
+// record accounts the outcome of the unwinding of a sample of a process
+// running the executable exe. It returns the binary the sample is taken in
+// if the binary has just been detected as compiled without frame pointers.
+func (u *unwindStats) record(exe string, stack *unwindStack) string {
+	if stack.frames == 0 || len(stack.modules) == 0 {
+		if exe != "" { // kernel threads have no user stack
+			u.add(exe, unwindFailed)
+		}
+		return ""
+	}
+	outcome := unwindFull
+	if stack.rootModule == "" || stack.frames >= maxStackDepth {
+		outcome = unwindTruncated
+	}
+	for _, m := range stack.modules {
+		if m != stack.outerModule {
+			u.add(m, unwindFull)
+		}
+	}
+	u.add(stack.outerModule, outcome)
+	return u.addLeaf(stack.leafModule, stack.frames <= shallowStackFrames)
+}
+
+func (u *unwindStats) add(binary string, outcome unwindOutcome) {
+	c := u.binaries[binary]
+	if c == nil {
+		if len(u.binaries) >= maxUnwindBinaries {
+			return
+		}
+		c = new(unwindCounts)
+		u.binaries[binary] = c
+	}
+	switch outcome {
+	case unwindFull:
+		c.full++
+	case unwindTruncated:
+		c.truncated++
+	case unwindFailed:
+		c.failed++
+	}
+}
+
+func (u *unwindStats) addLeaf(binary string, shallow bool) string {
+	c := u.binaries[binary]
+	if c == nil || c.noFramePointers {
+		return ""
+	}
+	c.leaf++
+	if shallow {
+		c.shallow++
+	}
+	if c.leaf >= noFramePointersMinSamples && float64(c.shallow) >= noFramePointersShallowRatio*float64(c.leaf) {
+		c.noFramePointers = true
+		return binary
+	}
+	return ""
+}
+
+func (u *unwindStats) setBinaryInfo(binary string, info binaryInfo) {
+	if c := u.binaries[binary]; c != nil {
+		c.buildID = info.buildID
+		c.sframe = info.sframe
+		c.dwarf = info.dwarf
+	}
+}
+
+// UnwindDebugInfo is the stack unwinding report of a binary.
+type UnwindDebugInfo struct {
+	Binary      string  `alloy:"binary,attr,optional" river:"binary,attr,optional"`
+	Full        uint64  `alloy:"full,attr,optional" river:"full,attr,optional"`
+	Truncated   uint64  `alloy:"truncated,attr,optional" river:"truncated,attr,optional"`
+	Failed      uint64  `alloy:"failed,attr,optional" river:"failed,attr,optional"`
+	SuccessRate float64 `alloy:"success_rate,attr,optional" river:"success_rate,attr,optional"`
+	// NoFramePointers is set if the binary is likely
+	// compiled without frame pointers.
+	NoFramePointers bool   `alloy:"no_frame_pointers,attr,optional" river:"no_frame_pointers,attr,optional"`
+	BuildID         string `alloy:"build_id,attr,optional" river:"build_id,attr,optional"`
+	// SFrame is set if the binary without frame pointers has an SFrame
+	// unwind table.
+	SFrame bool `alloy:"sframe,attr,optional" river:"sframe,attr,optional"`
+	// DWARF is set if the binary without frame pointers is unwound with its
+	// .eh_frame unwind table.
+	DWARF bool   `alloy:"dwarf,attr,optional" river:"dwarf,attr,optional"`
+	Hint  string `alloy:"hint,attr,optional" river:"hint,attr,optional"`
+}
+
+// DebugInfo returns the unwinding report of the binaries, the binaries
+// with the lowest success rate first.
+func (u *unwindStats) DebugInfo() []UnwindDebugInfo {
+	res := make([]UnwindDebugInfo, 0, len(u.binaries))
+	for binary, c := range u.binaries {
+		info := UnwindDebugInfo{
+			Binary:          binary,
+			Full:            c.full,
+			Truncated:       c.truncated,
+			Failed:          c.failed,
+			SuccessRate:     float64(c.full) / float64(c.full+c.truncated+c.failed),
+			NoFramePointers: c.noFramePointers,
+			BuildID:         c.buildID,
+			SFrame:          c.sframe,
+			DWARF:           c.dwarf,
+		}
+		if c.noFramePointers {
+			info.Hint = noFramePointersHint
+			if c.dwarf {
+				info.Hint = dwarfHint
+			} else if c.sframe {
+				info.Hint = sframeHint
+			}
+		}
+		res = append(res, info)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].SuccessRate != res[j].SuccessRate {
+			return res[i].SuccessRate < res[j].SuccessRate
+		}
+		ti := res[i].Full + res[i].Truncated + res[i].Failed
+		tj := res[j].Full + res[j].Truncated + res[j].Failed
+		if ti != tj {
+			return ti > tj
+		}
+		return res[i].Binary < res[j].Binary
+	})
+	return res
+}
+
+// reportNoFramePointers reports a binary detected as compiled without frame
+// pointers, the build id and the unwind tables are read from the file in the
+// root filesystem of the process the binary has been seen in. The binary is
+// unwound with its .eh_frame table from then on, if the DWARF unwinding is
+// enabled.
+func (s *session) reportNoFramePointers(pid uint32, binary string) {
+	info := readBinaryInfo(filepath.Join(procfs.RootFS(pid), binary))
+	info.dwarf = s.loadUnwindTableLocked(pid, binary)
+	s.unwindStats.setBinaryInfo(binary, info)
+	msg, hint := "binary is likely compiled without frame pointers, its stacks are truncated", noFramePointersHint
+	if info.dwarf {
+		msg, hint = "binary is likely compiled without frame pointers, its stacks are unwound with its .eh_frame table", dwarfHint
+	} else if info.sframe {
+		hint = sframeHint
+	}
+	_ = level.Warn(s.logger).Log("msg", msg,
+		"event", "unwind_truncated", "binary", binary, "build_id", info.buildID, "sframe", info.sframe, "dwarf", info.dwarf, "pid", pid, "hint", hint)
+	if m := s.options.Metrics.Symtab; m != nil {
+		m.NoFramePointersBinaries.WithLabelValues(binary, info.buildID).Set(1)
+	}
+}
+
+type binaryInfo struct {
+	buildID string
+	sframe  bool
+	dwarf   bool
+}
+
+// readBinaryInfo returns the build id of the elf file and whether it has a
+// valid SFrame unwind table.
+func readBinaryInfo(path string) binaryInfo {
+	var res binaryInfo
+	f, err := elf2.NewMMapedElfFile(path)
+	if err != nil {
+		return res
+	}
+	defer f.Close()
+	if id, err := f.BuildID(); err == nil {
+		res.buildID = id.ID
+	}
+	if _, err := f.SFrame(); err == nil {
+		res.sframe = true
+	}
+	return res
+}
+
+// readBuildID returns the build id of the elf file, or an empty string.
+func readBuildID(path string) string {
+	f, err := elf2.NewMMapedElfFile(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	id, err := f.BuildID()
+	if err != nil {
+		return ""
+	}
+	return id.ID
+}
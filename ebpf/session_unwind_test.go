@@ -0,0 +1,74 @@
+//go:build linux
+
+package ebpfspy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnwindStats(t *testing.T) {
+	u := newUnwindStats()
+	var stack unwindStack
+	walk := func(modules ...string) *unwindStack {
+		stack.reset()
+		for _, m := range modules {
+			stack.addFrame(m)
+		}
+		return &stack
+	}
+
+	u.record("/bin/app", walk("/bin/app", "/bin/app", "/lib/libc.so.6"))
+	// The frame pointers chain is broken in libfoo.
+	u.record("/bin/app", walk("/lib/libfoo.so", "/bin/app", "/lib/libfoo.so", ""))
+	u.record("/bin/app", walk())
+	// Kernel threads have no user stack.
+	u.record("", walk())
+
+	assert.Equal(t, []UnwindDebugInfo{
+		{Binary: "/lib/libfoo.so", Truncated: 1, SuccessRate: 0},
+		{Binary: "/bin/app", Full: 2, Failed: 1, SuccessRate: 2.0 / 3.0},
+		{Binary: "/lib/libc.so.6", Full: 1, SuccessRate: 1},
+	}, u.DebugInfo())
+}
+
+func TestUnwindStats_NoFramePointers(t *testing.T) {
+	u := newUnwindStats()
+	var stack unwindStack
+	walk := func(modules ...string) *unwindStack {
+		stack.reset()
+		for _, m := range modules {
+			stack.addFrame(m)
+		}
+		return &stack
+	}
+
+	for i := 0; i < noFramePointersMinSamples-1; i++ {
+		assert.Empty(t, u.record("/bin/app", walk("/lib/libfoo.so", "")))
+		assert.Empty(t, u.record("/bin/app", walk("/bin/app", "/bin/app", "/lib/libc.so.6")))
+	}
+	assert.Equal(t, "/lib/libfoo.so", u.record("/bin/app", walk("/lib/libfoo.so", "")))
+	// The binary is reported once.
+	assert.Empty(t, u.record("/bin/app", walk("/lib/libfoo.so", "")))
+	u.setBinaryInfo("/lib/libfoo.so", binaryInfo{buildID: "cafebabe"})
+
+	info := u.DebugInfo()
+	assert.Equal(t, "/lib/libfoo.so", info[0].Binary)
+	assert.True(t, info[0].NoFramePointers)
+	assert.Equal(t, "cafebabe", info[0].BuildID)
+	assert.Equal(t, noFramePointersHint, info[0].Hint)
+	for _, b := range info[1:] {
+		assert.False(t, b.NoFramePointers, b.Binary)
+	}
+
+	u.setBinaryInfo("/lib/libfoo.so", binaryInfo{buildID: "cafebabe", sframe: true})
+	info = u.DebugInfo()
+	assert.True(t, info[0].SFrame)
+	assert.Equal(t, sframeHint, info[0].Hint)
+
+	u.setBinaryInfo("/lib/libfoo.so", binaryInfo{buildID: "cafebabe", sframe: true, dwarf: true})
+	info = u.DebugInfo()
+	assert.True(t, info[0].DWARF)
+	assert.Equal(t, dwarfHint, info[0].Hint)
+}
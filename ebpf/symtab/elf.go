@@ -28,6 +28,16 @@ type ElfTable struct {
 	loadedCached bool
 	err          error
 
+	// pending is the result of the parsing of the symbol table, if it
+	// has not completed before the deadline, and pendingCache caches the
+	// symbol table once parsed.
+	pending      <-chan elfParseResult
+	pendingCache func(SymbolNameResolver)
+	// stat identifies the file in the negative cache.
+	stat Stat
+	// buildID identifies the file in the build id inventory.
+	buildID elf2.BuildID
+
 	options ElfTableOptions
 	logger  log.Logger
 	procMap *ProcMap
@@ -35,7 +45,10 @@ type ElfTable struct {
 type SymbolOptions struct {
 	GoTableFallback    bool
 	PythonFullFilePath bool
-	DemangleOptions    []demangle.Option
+	// PythonQualnames names the python functions by their qualified names,
+	// the same way on all the python versions, see python.Proc.Qualnames.
+	PythonQualnames bool
+	DemangleOptions []demangle.Option
 }
 
 var DefaultSymbolOptions = &SymbolOptions{
@@ -43,7 +56,11 @@ var DefaultSymbolOptions = &SymbolOptions{
 }
 
 type ElfTableOptions struct {
-	ElfCache      *ElfCache
+	ElfCache  *ElfCache
+	ElfParser *ElfParser
+	// SharedCache, if set, resolves the files with a build id with the
+	// shared cache server, see SharedCacheServer.
+	SharedCache   *SharedCacheClient
 	Metrics       *metrics.SymtabMetrics
 	SymbolOptions *SymbolOptions
 }
@@ -96,6 +113,18 @@ func (et *ElfTable) load() {
 	et.loaded = true
 	fsElfFilePath := path.Join(et.fs, et.elfFilePath)
 
+	fileInfo, err := os.Stat(fsElfFilePath)
+	if err != nil {
+		et.onLoadError(err)
+		return
+	}
+	et.stat = statFromFileInfo(fileInfo)
+	if err = et.options.ElfCache.GetErrorByStat(et.stat); err != nil {
+		// The file is known to be hopeless, do not parse it again.
+		et.err = err
+		return
+	}
+
 	me, err := elf2.NewMMapedElfFile(fsElfFilePath)
 	if err != nil {
 		et.onLoadError(err)
@@ -111,6 +140,9 @@ func (et *ElfTable) load() {
 	if err != nil {
 		level.Error(et.logger).Log("msg", "failed to get build id", "err", err, "f", et.elfFilePath, "fs", et.fs)
 	}
+	et.buildID = buildID
+	inventory := et.options.ElfCache.Inventory
+	inventory.seen(buildID, et.elfFilePath, fileInfo.Size())
 
 	symbols := et.options.ElfCache.GetSymbolsByBuildID(buildID)
 	if symbols != nil {
@@ -118,49 +150,117 @@ func (et *ElfTable) load() {
 		et.loadedCached = true
 		return
 	}
-	fileInfo, err := os.Stat(fsElfFilePath)
-	if err != nil {
-		et.onLoadError(err)
-		return
-	}
-	symbols = et.options.ElfCache.GetSymbolsByStat(statFromFileInfo(fileInfo))
+	symbols = et.options.ElfCache.GetSymbolsByStat(et.stat)
 	if symbols != nil {
 		et.table = symbols
 		et.loadedCached = true
 		return
 	}
+	if et.loadShared(buildID) {
+		return
+	}
 
 	debugFilePath := et.findDebugFile(buildID, me)
 	if debugFilePath != "" {
-		debugMe, err := elf2.NewMMapedElfFile(path.Join(et.fs, debugFilePath))
-		if err != nil {
-			et.onLoadError(err)
-			return
+		et.parse(path.Join(et.fs, debugFilePath), func(symbols SymbolNameResolver) {
+			et.options.ElfCache.CacheByBuildID(buildID, symbols)
+			inventory.setStatus(buildID, SymbolsStatusDebugFile, debugFilePath, nil)
+		})
+		return
+	}
+
+	et.parse(fsElfFilePath, func(symbols SymbolNameResolver) {
+		if buildID.Empty() {
+			et.options.ElfCache.CacheByStat(et.stat, symbols)
+		} else {
+			et.options.ElfCache.CacheByBuildID(buildID, symbols)
+			inventory.setStatus(buildID, SymbolsStatusSymbols, "", nil)
 		}
-		defer debugMe.Close() // todo do not close if it is the selected elf
+	})
+}
 
-		symbols, err = et.createSymbolTable(debugMe)
+// parse creates the symbol table of the file in the parser pool. If the
+// parsing does not complete before the deadline, the addresses are resolved
+// to the module only, until the table is available.
+func (et *ElfTable) parse(fpath string, cache func(SymbolNameResolver)) {
+	et.pending = et.options.ElfParser.parse(func() (SymbolNameResolver, error) {
+		me, err := elf2.NewMMapedElfFile(fpath)
 		if err != nil {
-			et.onLoadError(err)
-			return
+			return nil, err
 		}
-		et.table = symbols
-		et.options.ElfCache.CacheByBuildID(buildID, symbols)
-		return
+		defer me.Close() // todo do not close if it is the selected elf
+		return et.createSymbolTable(me)
+	})
+	et.pendingCache = cache
+	if res, ok := et.options.ElfParser.wait(et.pending); ok {
+		et.onParsed(res)
+	} else {
+		level.Warn(et.logger).Log("msg", "elf symbol table parsing deadline exceeded", "f", et.elfFilePath, "fs", et.fs)
 	}
+}
 
-	symbols, err = et.createSymbolTable(me)
+// loadShared loads the symbol table from the shared cache server. The
+// file is parsed locally if the server is not available.
+func (et *ElfTable) loadShared(buildID elf2.BuildID) bool {
+	if et.options.SharedCache == nil || buildID.Empty() {
+		return false
+	}
+	symbols, err := newSharedSymbolNameResolver(et.options.SharedCache, SharedLoadArgs{
+		FS:              et.fs,
+		ElfFilePath:     et.elfFilePath,
+		BuildID:         buildID,
+		GoTableFallback: et.options.SymbolOptions.GoTableFallback,
+		DemangleOptions: et.options.SymbolOptions.DemangleOptions,
+	})
 	if err != nil {
-		et.onLoadError(err)
-		return
+		level.Debug(et.logger).Log("msg", "failed to load elf table from the shared cache", "err", err, "f", et.elfFilePath, "fs", et.fs)
+		return false
 	}
-
 	et.table = symbols
-	if buildID.Empty() {
-		et.options.ElfCache.CacheByStat(statFromFileInfo(fileInfo), symbols)
-	} else {
-		et.options.ElfCache.CacheByBuildID(buildID, symbols)
+	et.loadedCached = true
+	et.options.ElfCache.CacheByBuildID(buildID, symbols)
+	et.options.ElfCache.Inventory.setStatus(buildID, SymbolsStatusSharedCache, "", nil)
+	return true
+}
+
+// parseSymbols creates the symbol table of the file, or of its debug file,
+// synchronously. The table is not relocated: the addresses are relative to
+// the base of the file.
+func (et *ElfTable) parseSymbols(buildID elf2.BuildID) (SymbolNameResolver, error) {
+	me, err := elf2.NewMMapedElfFile(path.Join(et.fs, et.elfFilePath))
+	if err != nil {
+		return nil, err
+	}
+	defer me.Close()
+	fileBuildID, err := me.BuildID()
+	if err != nil {
+		return nil, err
 	}
+	if fileBuildID != buildID {
+		return nil, fmt.Errorf("build id mismatch %s %s", fileBuildID.ID, buildID.ID)
+	}
+	debugFilePath := et.findDebugFile(buildID, me)
+	if debugFilePath == "" {
+		return et.createSymbolTable(me)
+	}
+	debugMe, err := elf2.NewMMapedElfFile(path.Join(et.fs, debugFilePath))
+	if err != nil {
+		return nil, err
+	}
+	defer debugMe.Close()
+	return et.createSymbolTable(debugMe)
+}
+
+func (et *ElfTable) onParsed(res elfParseResult) {
+	cache := et.pendingCache
+	et.pending = nil
+	et.pendingCache = nil
+	if res.err != nil {
+		et.onLoadError(res.err)
+		return
+	}
+	et.table = res.symbols
+	cache(res.symbols)
 }
 
 func (et *ElfTable) createSymbolTable(me *elf2.MMapedElfFile) (SymbolNameResolver, error) {
@@ -222,6 +322,13 @@ func (et *ElfTable) Resolve(pc uint64) string {
 	if !et.loaded {
 		et.load()
 	}
+	if et.pending != nil {
+		select {
+		case res := <-et.pending:
+			et.onParsed(res)
+		default:
+		}
+	}
 	if et.err != nil {
 		return ""
 	}
@@ -348,6 +455,11 @@ func (et *ElfTable) DebugInfo() elf2.SymTabDebugInfo {
 
 func (et *ElfTable) onLoadError(err error) {
 	et.err = err
+	et.options.ElfCache.Inventory.setStatus(et.buildID, SymbolsStatusError, "", err)
+	if !errors.Is(err, errElfBaseNotFound) {
+		// The base depends on the mapping, not on the file only.
+		et.options.ElfCache.CacheErrorByStat(et.stat, err)
+	}
 	var l log.Logger
 	if errors.Is(err, os.ErrNotExist) {
 		l = level.Debug(et.logger)
@@ -0,0 +1,656 @@
+package elf
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// The .eh_frame section holds the DWARF call frame information of the
+// functions, kept in the stripped binaries for the C++ exceptions. It tells,
+// for every instruction, how to compute the canonical frame address (CFA),
+// the value of the stack pointer before the call, and where the caller
+// registers are saved relative to it, which is enough to unwind the stacks of
+// the binaries compiled without frame pointers.
+// https://refspecs.linuxfoundation.org/LSB_5.0.0/LSB-Core-generic/LSB-Core-generic/ehframechpt.html
+
+var (
+	ErrNoEHFrameSection = fmt.Errorf("eh_frame section not found")
+)
+
+// UnwindCFAType is how the CFA of an unwind row is computed.
+type UnwindCFAType uint8
+
+const (
+	// UnwindCFAEnd marks the addresses after a function with no unwind
+	// information, until the next row.
+	UnwindCFAEnd UnwindCFAType = iota
+	// UnwindCFARSP and UnwindCFARBP are the CFA at an offset from rsp or
+	// rbp.
+	UnwindCFARSP
+	UnwindCFARBP
+	// UnwindCFAUnsupported is a CFA computed with a DWARF expression or from
+	// another register, or a caller rbp not saved at an offset from the CFA.
+	UnwindCFAUnsupported
+	// UnwindCFALastFrame marks the outermost frames, with an undefined
+	// return address.
+	UnwindCFALastFrame
+)
+
+// UnwindRow is the unwind rule of the instructions from PC to the PC of the
+// next row, for x86_64: the return address is saved right below the CFA.
+type UnwindRow struct {
+	// PC is the virtual address of the first instruction of the row.
+	PC        uint64
+	CFAType   UnwindCFAType
+	CFAOffset int64
+	// RBPOffset is the offset from the CFA the rbp of the caller is saved
+	// at, 0 if rbp is not changed.
+	RBPOffset int64
+}
+
+// x86_64 DWARF register numbers.
+const (
+	dwarfRegRBP = 6
+	dwarfRegRSP = 7
+	dwarfRegRA  = 16
+)
+
+// UnwindTable returns the unwind rows of the .eh_frame section of the file,
+// sorted by PC. Only x86_64 is supported.
+func (f *MMapedElfFile) UnwindTable() ([]UnwindRow, error) {
+	if f.Machine != elf.EM_X86_64 {
+		return nil, fmt.Errorf("%s: unwind tables of %s are not supported", f.fpath, f.Machine)
+	}
+	s := f.Section(".eh_frame")
+	if s == nil || s.Type == elf.SHT_NOBITS {
+		return nil, ErrNoEHFrameSection
+	}
+	data, err := f.SectionData(s)
+	if err != nil {
+		return nil, fmt.Errorf("reading .eh_frame %w", err)
+	}
+	rows, err := ParseEHFrame(data, s.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.fpath, err)
+	}
+	return rows, nil
+}
+
+// ParseEHFrame parses an x86_64 .eh_frame section loaded at addr into unwind
+// rows sorted by PC. The consecutive rows with the same rule are merged, and
+// the addresses between the functions are covered by UnwindCFAEnd rows.
+func ParseEHFrame(data []byte, addr uint64) ([]UnwindRow, error) {
+	p := ehFrameParser{data: data, addr: addr, cies: make(map[uint64]*cie)}
+	var rows []UnwindRow
+	for off := uint64(0); off < uint64(len(data)); {
+		entry, next, err := p.entry(off)
+		if err != nil {
+			return nil, fmt.Errorf("eh_frame entry at %x: %w", off, err)
+		}
+		if next == 0 {
+			break // terminator
+		}
+		if entry != nil {
+			fdeRows, err := p.fde(entry)
+			if err != nil {
+				return nil, fmt.Errorf("eh_frame fde at %x: %w", off, err)
+			}
+			rows = append(rows, fdeRows...)
+		}
+		off = next
+	}
+	return compactUnwindRows(rows), nil
+}
+
+// compactUnwindRows sorts the rows, drops the end rows of the functions
+// followed right away by another one, and merges the rows with the same rule.
+func compactUnwindRows(rows []UnwindRow) []UnwindRow {
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].PC != rows[j].PC {
+			return rows[i].PC < rows[j].PC
+		}
+		// the rows of a function win over the end of the previous one
+		return rows[i].CFAType == UnwindCFAEnd && rows[j].CFAType != UnwindCFAEnd
+	})
+	res := rows[:0]
+	for _, r := range rows {
+		n := len(res)
+		if n > 0 && res[n-1].PC == r.PC {
+			res = res[:n-1]
+			n--
+		}
+		if n > 0 && res[n-1].sameRule(&r) {
+			continue
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+func (r *UnwindRow) sameRule(other *UnwindRow) bool {
+	return r.CFAType == other.CFAType && r.CFAOffset == other.CFAOffset && r.RBPOffset == other.RBPOffset
+}
+
+type cie struct {
+	codeAlign   uint64
+	dataAlign   int64
+	raRegister  uint64
+	fdeEncoding byte
+	// augmentation is set if the fdes have augmentation data.
+	augmentation bool
+	instructions []byte
+}
+
+type fdeEntry struct {
+	cie          *cie
+	pcBegin      uint64
+	pcRange      uint64
+	instructions []byte
+}
+
+type ehFrameParser struct {
+	data []byte
+	addr uint64
+	cies map[uint64]*cie
+}
+
+// entry parses the cie or fde at off. It returns the fde, nil for a cie, and
+// the offset of the next entry, 0 at the terminator.
+func (p *ehFrameParser) entry(off uint64) (*fdeEntry, uint64, error) {
+	r := &ehReader{data: p.data, off: off}
+	length := uint64(r.u32())
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if length == 0 {
+		return nil, 0, nil
+	}
+	if length == 0xffffffff {
+		length = r.u64()
+	}
+	start := r.off
+	next := start + length
+	if r.err != nil || next > uint64(len(p.data)) || next < start {
+		return nil, 0, errors.New("truncated entry")
+	}
+	r.data = p.data[:next]
+	idOff := r.off
+	id := uint64(r.u32())
+	if id == 0 {
+		c, err := p.cie(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		p.cies[off] = c
+		return nil, next, nil
+	}
+	if id > idOff {
+		return nil, 0, fmt.Errorf("invalid cie pointer %x", id)
+	}
+	c, ok := p.cies[idOff-id]
+	if !ok {
+		var err error
+		if c, err = p.cieAt(idOff - id); err != nil {
+			return nil, 0, err
+		}
+	}
+	fde := &fdeEntry{cie: c}
+	fde.pcBegin = r.pointer(c.fdeEncoding, p.addr)
+	fde.pcRange = r.pointer(c.fdeEncoding&0x0f, p.addr)
+	if c.augmentation {
+		r.skip(r.uleb())
+	}
+	fde.instructions = r.rest()
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	return fde, next, nil
+}
+
+// cieAt parses the cie at off, for the fdes before their cie.
+func (p *ehFrameParser) cieAt(off uint64) (*cie, error) {
+	if _, _, err := p.entry(off); err != nil {
+		return nil, err
+	}
+	c, ok := p.cies[off]
+	if !ok {
+		return nil, fmt.Errorf("no cie at %x", off)
+	}
+	return c, nil
+}
+
+func (p *ehFrameParser) cie(r *ehReader) (*cie, error) {
+	c := &cie{fdeEncoding: dwEHPEAbsPtr}
+	version := r.u8()
+	if version != 1 && version != 3 {
+		return nil, fmt.Errorf("unsupported cie version %d", version)
+	}
+	augmentation := r.cstring()
+	if len(augmentation) >= 2 && augmentation[:2] == "eh" {
+		r.skip(8)
+	}
+	c.codeAlign = r.uleb()
+	c.dataAlign = r.sleb()
+	if version == 1 {
+		c.raRegister = uint64(r.u8())
+	} else {
+		c.raRegister = r.uleb()
+	}
+	if len(augmentation) > 0 && augmentation[0] == 'z' {
+		c.augmentation = true
+		augLen := r.uleb()
+		augEnd := r.off + augLen
+		for _, a := range augmentation[1:] {
+			switch a {
+			case 'R':
+				c.fdeEncoding = r.u8()
+			case 'P':
+				r.pointer(r.u8(), p.addr)
+			case 'L':
+				r.u8()
+			case 'S', 'B':
+			default:
+				// the unknown augmentations are skipped with the length
+			}
+		}
+		r.off = augEnd
+	}
+	if c.raRegister != dwarfRegRA {
+		return nil, fmt.Errorf("unsupported return address register %d", c.raRegister)
+	}
+	c.instructions = r.rest()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return c, nil
+}
+
+// cfaState is the unwind rule at an instruction, the CFA and the rule of
+// rbp.
+type cfaState struct {
+	cfaRegister uint64
+	cfaOffset   int64
+	cfaExpr     bool
+	rbp         rbpRule
+	rbpOffset   int64
+	raUndefined bool
+}
+
+type rbpRule uint8
+
+const (
+	rbpSameValue rbpRule = iota
+	rbpOffset
+	rbpUnsupported
+)
+
+func (s *cfaState) row(pc uint64) UnwindRow {
+	row := UnwindRow{PC: pc, CFAOffset: s.cfaOffset}
+	switch {
+	case s.raUndefined:
+		row.CFAType = UnwindCFALastFrame
+	case s.cfaExpr || s.rbp == rbpUnsupported:
+		row.CFAType = UnwindCFAUnsupported
+	case s.cfaRegister == dwarfRegRSP:
+		row.CFAType = UnwindCFARSP
+	case s.cfaRegister == dwarfRegRBP:
+		row.CFAType = UnwindCFARBP
+	default:
+		row.CFAType = UnwindCFAUnsupported
+	}
+	if row.CFAType != UnwindCFARSP && row.CFAType != UnwindCFARBP {
+		row.CFAOffset = 0
+		return row
+	}
+	if s.rbp == rbpOffset {
+		row.RBPOffset = s.rbpOffset
+	}
+	return row
+}
+
+// fde runs the call frame instructions of the cie and the fde, and returns a
+// row for every change of the rule, and an end row after the function.
+func (p *ehFrameParser) fde(fde *fdeEntry) ([]UnwindRow, error) {
+	var (
+		initial cfaState
+		state   cfaState
+		stack   []cfaState
+		rows    []UnwindRow
+	)
+	loc := fde.pcBegin
+	emit := func() {
+		if n := len(rows); n > 0 && rows[n-1].PC == loc {
+			rows[n-1] = state.row(loc)
+			return
+		}
+		rows = append(rows, state.row(loc))
+	}
+	run := func(instructions []byte, inCIE bool) error {
+		r := &ehReader{data: instructions}
+		for r.off < uint64(len(instructions)) && r.err == nil {
+			op := r.u8()
+			operand := uint64(op & 0x3f)
+			switch op & 0xc0 {
+			case dwCFAAdvanceLoc:
+				emit()
+				loc += operand * fde.cie.codeAlign
+				continue
+			case dwCFAOffset:
+				state.setOffset(operand, int64(r.uleb())*fde.cie.dataAlign)
+				continue
+			case dwCFARestore:
+				state.restore(operand, &initial)
+				continue
+			}
+			switch op {
+			case dwCFANop:
+			case dwCFASetLoc:
+				emit()
+				loc = r.pointer(fde.cie.fdeEncoding, p.addr)
+			case dwCFAAdvanceLoc1:
+				emit()
+				loc += uint64(r.u8()) * fde.cie.codeAlign
+			case dwCFAAdvanceLoc2:
+				emit()
+				loc += uint64(r.u16()) * fde.cie.codeAlign
+			case dwCFAAdvanceLoc4:
+				emit()
+				loc += uint64(r.u32()) * fde.cie.codeAlign
+			case dwCFAOffsetExtended:
+				reg := r.uleb()
+				state.setOffset(reg, int64(r.uleb())*fde.cie.dataAlign)
+			case dwCFAOffsetExtendedSF:
+				reg := r.uleb()
+				state.setOffset(reg, r.sleb()*fde.cie.dataAlign)
+			case dwCFAGNUNegativeOffsetExtended:
+				reg := r.uleb()
+				state.setOffset(reg, -int64(r.uleb())*fde.cie.dataAlign)
+			case dwCFARestoreExtended:
+				state.restore(r.uleb(), &initial)
+			case dwCFAUndefined:
+				state.setUndefined(r.uleb())
+			case dwCFASameValue:
+				if r.uleb() == dwarfRegRBP {
+					state.rbp = rbpSameValue
+				}
+			case dwCFARegister:
+				state.setUnsupported(r.uleb())
+				r.uleb()
+			case dwCFARememberState:
+				stack = append(stack, state)
+			case dwCFARestoreState:
+				if len(stack) == 0 {
+					return errors.New("restore state with an empty stack")
+				}
+				state = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			case dwCFADefCFA:
+				state.cfaRegister = r.uleb()
+				state.cfaOffset = int64(r.uleb())
+				state.cfaExpr = false
+			case dwCFADefCFASF:
+				state.cfaRegister = r.uleb()
+				state.cfaOffset = r.sleb() * fde.cie.dataAlign
+				state.cfaExpr = false
+			case dwCFADefCFARegister:
+				state.cfaRegister = r.uleb()
+				state.cfaExpr = false
+			case dwCFADefCFAOffset:
+				state.cfaOffset = int64(r.uleb())
+			case dwCFADefCFAOffsetSF:
+				state.cfaOffset = r.sleb() * fde.cie.dataAlign
+			case dwCFADefCFAExpression:
+				r.skip(r.uleb())
+				state.cfaExpr = true
+			case dwCFAExpression, dwCFAValExpression:
+				state.setUnsupported(r.uleb())
+				r.skip(r.uleb())
+			case dwCFAValOffset, dwCFAValOffsetSF:
+				state.setUnsupported(r.uleb())
+				r.uleb()
+			case dwCFAGNUArgsSize:
+				r.uleb()
+			default:
+				return fmt.Errorf("unsupported call frame instruction %x", op)
+			}
+		}
+		if inCIE {
+			initial = state
+		}
+		return r.err
+	}
+	if err := run(fde.cie.instructions, true); err != nil {
+		return nil, fmt.Errorf("cie: %w", err)
+	}
+	if err := run(fde.instructions, false); err != nil {
+		return nil, err
+	}
+	end := fde.pcBegin + fde.pcRange
+	if loc < end {
+		emit()
+	}
+	rows = append(rows, UnwindRow{PC: end, CFAType: UnwindCFAEnd})
+	return rows, nil
+}
+
+func (s *cfaState) setOffset(reg uint64, offset int64) {
+	if reg == dwarfRegRBP {
+		s.rbp = rbpOffset
+		s.rbpOffset = offset
+	}
+}
+
+func (s *cfaState) setUnsupported(reg uint64) {
+	if reg == dwarfRegRBP {
+		s.rbp = rbpUnsupported
+	}
+}
+
+func (s *cfaState) setUndefined(reg uint64) {
+	switch reg {
+	case dwarfRegRA:
+		s.raUndefined = true
+	case dwarfRegRBP:
+		s.rbp = rbpSameValue
+	}
+}
+
+func (s *cfaState) restore(reg uint64, initial *cfaState) {
+	switch reg {
+	case dwarfRegRBP:
+		s.rbp = initial.rbp
+		s.rbpOffset = initial.rbpOffset
+	case dwarfRegRA:
+		s.raUndefined = initial.raUndefined
+	}
+}
+
+// DWARF call frame instructions, the first three have their operand in the
+// low 6 bits.
+const (
+	dwCFAAdvanceLoc = 0x40
+	dwCFAOffset     = 0x80
+	dwCFARestore    = 0xc0
+
+	dwCFANop                       = 0x00
+	dwCFASetLoc                    = 0x01
+	dwCFAAdvanceLoc1               = 0x02
+	dwCFAAdvanceLoc2               = 0x03
+	dwCFAAdvanceLoc4               = 0x04
+	dwCFAOffsetExtended            = 0x05
+	dwCFARestoreExtended           = 0x06
+	dwCFAUndefined                 = 0x07
+	dwCFASameValue                 = 0x08
+	dwCFARegister                  = 0x09
+	dwCFARememberState             = 0x0a
+	dwCFARestoreState              = 0x0b
+	dwCFADefCFA                    = 0x0c
+	dwCFADefCFARegister            = 0x0d
+	dwCFADefCFAOffset              = 0x0e
+	dwCFADefCFAExpression          = 0x0f
+	dwCFAExpression                = 0x10
+	dwCFAOffsetExtendedSF          = 0x11
+	dwCFADefCFASF                  = 0x12
+	dwCFADefCFAOffsetSF            = 0x13
+	dwCFAValOffset                 = 0x14
+	dwCFAValOffsetSF               = 0x15
+	dwCFAValExpression             = 0x16
+	dwCFAGNUArgsSize               = 0x2e
+	dwCFAGNUNegativeOffsetExtended = 0x2f
+)
+
+// The pointer encodings of .eh_frame: the format in the low 4 bits, and how
+// it applies in the high ones.
+const (
+	dwEHPEAbsPtr  = 0x00
+	dwEHPEULEB128 = 0x01
+	dwEHPEUData2  = 0x02
+	dwEHPEUData4  = 0x03
+	dwEHPEUData8  = 0x04
+	dwEHPESLEB128 = 0x09
+	dwEHPESData2  = 0x0a
+	dwEHPESData4  = 0x0b
+	dwEHPESData8  = 0x0c
+
+	dwEHPEPCRel    = 0x10
+	dwEHPEIndirect = 0x80
+	dwEHPEOmit     = 0xff
+)
+
+type ehReader struct {
+	data []byte
+	off  uint64
+	err  error
+}
+
+func (r *ehReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.data)) || r.off > uint64(len(r.data))-n {
+		r.err = errors.New("unexpected end of data")
+		return nil
+	}
+	res := r.data[r.off : r.off+n]
+	r.off += n
+	return res
+}
+
+func (r *ehReader) skip(n uint64) { r.bytes(n) }
+
+func (r *ehReader) rest() []byte {
+	if r.err != nil || r.off > uint64(len(r.data)) {
+		return nil
+	}
+	res := r.data[r.off:]
+	r.off = uint64(len(r.data))
+	return res
+}
+
+func (r *ehReader) u8() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *ehReader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *ehReader) u32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *ehReader) u64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *ehReader) uleb() uint64 {
+	var res uint64
+	for shift := uint(0); r.err == nil; shift += 7 {
+		b := r.u8()
+		if shift < 64 {
+			res |= uint64(b&0x7f) << shift
+		}
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return res
+}
+
+func (r *ehReader) sleb() int64 {
+	var res int64
+	shift := uint(0)
+	for r.err == nil {
+		b := r.u8()
+		if shift < 64 {
+			res |= int64(b&0x7f) << shift
+		}
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				res |= -1 << shift
+			}
+			break
+		}
+	}
+	return res
+}
+
+func (r *ehReader) cstring() string {
+	start := r.off
+	for r.err == nil && r.u8() != 0 {
+	}
+	if r.err != nil {
+		return ""
+	}
+	return string(r.data[start : r.off-1])
+}
+
+// pointer reads a pointer with the encoding, the pc relative pointers are
+// relative to the address of the pointer, the section being loaded at addr.
+// The indirect pointers are not dereferenced.
+func (r *ehReader) pointer(encoding byte, addr uint64) uint64 {
+	if encoding == dwEHPEOmit {
+		return 0
+	}
+	pos := addr + r.off
+	var v uint64
+	switch encoding & 0x0f {
+	case dwEHPEAbsPtr, dwEHPEUData8, dwEHPESData8:
+		v = r.u64()
+	case dwEHPEULEB128:
+		v = r.uleb()
+	case dwEHPEUData2:
+		v = uint64(r.u16())
+	case dwEHPEUData4:
+		v = uint64(r.u32())
+	case dwEHPESLEB128:
+		v = uint64(r.sleb())
+	case dwEHPESData2:
+		v = uint64(int64(int16(r.u16())))
+	case dwEHPESData4:
+		v = uint64(int64(int32(r.u32())))
+	default:
+		r.err = fmt.Errorf("unsupported pointer encoding %x", encoding)
+		return 0
+	}
+	if encoding&0x70 == dwEHPEPCRel {
+		v += pos
+	}
+	return v
+}
@@ -0,0 +1,91 @@
+package elf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnwindTable(t *testing.T) {
+	me, err := NewMMapedElfFile("./testdata/elfs/elf")
+	require.NoError(t, err)
+	defer me.Close()
+	rows, err := me.UnwindTable()
+	require.NoError(t, err)
+	expected := []UnwindRow{
+		// .plt, with a DWARF expression after the first entry
+		{PC: 0x1020, CFAType: UnwindCFARSP, CFAOffset: 16},
+		{PC: 0x1026, CFAType: UnwindCFARSP, CFAOffset: 24},
+		{PC: 0x1030, CFAType: UnwindCFAUnsupported},
+		// .plt.got and .plt.sec merged with _start, up to its undefined
+		// return address
+		{PC: 0x1040, CFAType: UnwindCFARSP, CFAOffset: 8},
+		{PC: 0x1064, CFAType: UnwindCFALastFrame},
+		{PC: 0x1086, CFAType: UnwindCFAEnd},
+		// push rbp; mov rbp, rsp; ...; pop rbp; ret
+		{PC: 0x1149, CFAType: UnwindCFARSP, CFAOffset: 8},
+		{PC: 0x114e, CFAType: UnwindCFARSP, CFAOffset: 16, RBPOffset: -16},
+		{PC: 0x1151, CFAType: UnwindCFARBP, CFAOffset: 16, RBPOffset: -16},
+		{PC: 0x115d, CFAType: UnwindCFARSP, CFAOffset: 8, RBPOffset: -16},
+		{PC: 0x115e, CFAType: UnwindCFARSP, CFAOffset: 8},
+		{PC: 0x1163, CFAType: UnwindCFARSP, CFAOffset: 16, RBPOffset: -16},
+		{PC: 0x1166, CFAType: UnwindCFARBP, CFAOffset: 16, RBPOffset: -16},
+		{PC: 0x1172, CFAType: UnwindCFAEnd},
+	}
+	require.Equal(t, expected, rows)
+}
+
+func TestParseEHFrame(t *testing.T) {
+	cie := []byte{
+		0x14, 0, 0, 0, // length
+		0, 0, 0, 0, // cie id
+		1,           // version
+		'z', 'R', 0, // augmentation
+		1,          // code alignment
+		0x78,       // data alignment -8
+		16,         // return address register
+		1,          // augmentation length
+		0x1b,       // pc relative sdata4 fde pointers
+		0x0c, 7, 8, // def_cfa rsp+8
+		0x90, 1, // offset ra at cfa-8
+		0, 0, // nop
+	}
+	fde := []byte{
+		0x1c, 0, 0, 0, // length
+		0x1c, 0, 0, 0, // cie pointer
+		0xe0, 0x0f, 0, 0, // pc begin, relative to its address 0x1020
+		0x20, 0, 0, 0, // pc range
+		0,        // augmentation length
+		0x41,     // advance 1
+		0x0e, 16, // def_cfa_offset 16
+		0x86, 2, // offset rbp at cfa-16
+		0x0a,    // remember_state
+		0x44,    // advance 4
+		0x0d, 6, // def_cfa_register rbp
+		0x48,       // advance 8
+		0x0b,       // restore_state
+		0, 0, 0, 0, // nop
+	}
+	terminator := []byte{0, 0, 0, 0}
+	data := append(append(cie, fde...), terminator...)
+	rows, err := ParseEHFrame(data, 0x1000)
+	require.NoError(t, err)
+	require.Equal(t, []UnwindRow{
+		{PC: 0x2000, CFAType: UnwindCFARSP, CFAOffset: 8},
+		{PC: 0x2001, CFAType: UnwindCFARSP, CFAOffset: 16, RBPOffset: -16},
+		{PC: 0x2005, CFAType: UnwindCFARBP, CFAOffset: 16, RBPOffset: -16},
+		{PC: 0x200d, CFAType: UnwindCFARSP, CFAOffset: 16, RBPOffset: -16},
+		{PC: 0x2020, CFAType: UnwindCFAEnd},
+	}, rows)
+
+	_, err = ParseEHFrame(data[:len(cie)+10], 0x1000)
+	require.ErrorContains(t, err, "truncated")
+}
+
+func TestNoEHFrameSection(t *testing.T) {
+	me, err := NewMMapedElfFile("./testdata/elfs/elf.debug")
+	require.NoError(t, err)
+	defer me.Close()
+	_, err = me.UnwindTable()
+	require.ErrorIs(t, err, ErrNoEHFrameSection)
+}
@@ -0,0 +1,127 @@
+package elf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// SFrame is the simple frame format, a compact unwind table emitted into the
+// .sframe section by the recent toolchains (binutils 2.40+, -Wa,--gsframe).
+// It describes the CFA, the frame pointer and the return address of every
+// instruction range with a couple of offsets, which makes it much cheaper to
+// unwind with than the DWARF call frame information.
+// https://sourceware.org/binutils/docs/sframe-spec.html
+
+var (
+	ErrNoSFrameSection = fmt.Errorf("sframe section not found")
+)
+
+const (
+	sframeMagic      = 0xdee2
+	sframeVersion2   = 2
+	sframeHeaderSize = 28
+	sframeFDESize    = 20
+
+	SFrameFlagFDESorted    = 0x1
+	SFrameFlagFramePointer = 0x2
+)
+
+type SFrameABI uint8
+
+const (
+	SFrameABIAarch64BE SFrameABI = 1
+	SFrameABIAarch64LE SFrameABI = 2
+	SFrameABIAmd64LE   SFrameABI = 3
+)
+
+func (a SFrameABI) String() string {
+	switch a {
+	case SFrameABIAarch64BE:
+		return "aarch64_be"
+	case SFrameABIAarch64LE:
+		return "aarch64_le"
+	case SFrameABIAmd64LE:
+		return "amd64_le"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(a))
+}
+
+// SFrameHeader is the header of the .sframe section.
+type SFrameHeader struct {
+	Version uint8
+	Flags   uint8
+	ABI     SFrameABI
+	// CFAFixedFPOffset and CFAFixedRAOffset are the offsets of the saved
+	// frame pointer and return address from the CFA, shared by all the
+	// frames, 0 if they are tracked per frame.
+	CFAFixedFPOffset int8
+	CFAFixedRAOffset int8
+	NumFDEs          uint32
+	NumFREs          uint32
+	FRELen           uint32
+	// FDEOffset and FREOffset are relative to the end of the header.
+	FDEOffset uint32
+	FREOffset uint32
+	// Size is the size of the header, including the auxiliary header.
+	Size uint32
+}
+
+// SFrame returns the header of the .sframe section of the file.
+func (f *MMapedElfFile) SFrame() (SFrameHeader, error) {
+	s := f.Section(".sframe")
+	if s == nil {
+		return SFrameHeader{}, ErrNoSFrameSection
+	}
+	data, err := f.SectionData(s)
+	if err != nil {
+		return SFrameHeader{}, fmt.Errorf("reading .sframe %w", err)
+	}
+	h, err := ParseSFrameHeader(data)
+	if err != nil {
+		return SFrameHeader{}, fmt.Errorf("%s: %w", f.fpath, err)
+	}
+	return h, nil
+}
+
+// ParseSFrameHeader parses and validates the header of an .sframe section.
+// Only the version 2 of the format, in little endian, is supported.
+func ParseSFrameHeader(data []byte) (SFrameHeader, error) {
+	if len(data) < sframeHeaderSize {
+		return SFrameHeader{}, fmt.Errorf(".sframe is too small: %d bytes", len(data))
+	}
+	if magic := binary.LittleEndian.Uint16(data); magic != sframeMagic {
+		return SFrameHeader{}, fmt.Errorf("wrong .sframe magic %x", magic)
+	}
+	h := SFrameHeader{
+		Version:          data[2],
+		Flags:            data[3],
+		ABI:              SFrameABI(data[4]),
+		CFAFixedFPOffset: int8(data[5]),
+		CFAFixedRAOffset: int8(data[6]),
+		NumFDEs:          binary.LittleEndian.Uint32(data[8:]),
+		NumFREs:          binary.LittleEndian.Uint32(data[12:]),
+		FRELen:           binary.LittleEndian.Uint32(data[16:]),
+		FDEOffset:        binary.LittleEndian.Uint32(data[20:]),
+		FREOffset:        binary.LittleEndian.Uint32(data[24:]),
+		Size:             sframeHeaderSize + uint32(data[7]),
+	}
+	if h.Version != sframeVersion2 {
+		return SFrameHeader{}, fmt.Errorf("unsupported .sframe version %d", h.Version)
+	}
+	if h.ABI != SFrameABIAmd64LE && h.ABI != SFrameABIAarch64LE {
+		return SFrameHeader{}, fmt.Errorf("unsupported .sframe abi %s", h.ABI)
+	}
+	size := uint64(len(data)) - uint64(h.Size)
+	if uint64(h.Size) > uint64(len(data)) ||
+		uint64(h.FDEOffset)+uint64(h.NumFDEs)*sframeFDESize > size ||
+		uint64(h.FREOffset)+uint64(h.FRELen) > size {
+		return SFrameHeader{}, fmt.Errorf("truncated .sframe: %d fdes, %d bytes of fres in %d bytes",
+			h.NumFDEs, h.FRELen, len(data))
+	}
+	return h, nil
+}
+
+// FramePointer tells if the frame pointer is preserved in all the functions.
+func (h *SFrameHeader) FramePointer() bool {
+	return h.Flags&SFrameFlagFramePointer != 0
+}
@@ -0,0 +1,49 @@
+package elf
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sframeSection(version uint8, abi SFrameABI, numFDEs uint32, freLen uint32) []byte {
+	data := make([]byte, sframeHeaderSize+int(numFDEs)*sframeFDESize+int(freLen))
+	binary.LittleEndian.PutUint16(data, sframeMagic)
+	data[2] = version
+	data[3] = SFrameFlagFDESorted
+	data[4] = uint8(abi)
+	data[6] = 0xf0 // -16
+	binary.LittleEndian.PutUint32(data[8:], numFDEs)
+	binary.LittleEndian.PutUint32(data[12:], numFDEs)
+	binary.LittleEndian.PutUint32(data[16:], freLen)
+	binary.LittleEndian.PutUint32(data[24:], numFDEs*sframeFDESize)
+	return data
+}
+
+func TestParseSFrameHeader(t *testing.T) {
+	h, err := ParseSFrameHeader(sframeSection(2, SFrameABIAmd64LE, 3, 12))
+	require.NoError(t, err)
+	require.Equal(t, SFrameABIAmd64LE, h.ABI)
+	require.Equal(t, uint32(3), h.NumFDEs)
+	require.Equal(t, int8(-16), h.CFAFixedRAOffset)
+	require.Equal(t, uint32(sframeHeaderSize), h.Size)
+	require.False(t, h.FramePointer())
+
+	_, err = ParseSFrameHeader(sframeSection(1, SFrameABIAmd64LE, 3, 12))
+	require.ErrorContains(t, err, "version")
+	_, err = ParseSFrameHeader(sframeSection(2, SFrameABIAarch64BE, 3, 12))
+	require.ErrorContains(t, err, "abi")
+	_, err = ParseSFrameHeader(sframeSection(2, SFrameABIAmd64LE, 3, 12)[:sframeHeaderSize+40])
+	require.ErrorContains(t, err, "truncated")
+	_, err = ParseSFrameHeader([]byte{0xe2, 0xde})
+	require.ErrorContains(t, err, "too small")
+}
+
+func TestNoSFrameSection(t *testing.T) {
+	me, err := NewMMapedElfFile("./testdata/elfs/elf")
+	require.NoError(t, err)
+	defer me.Close()
+	_, err = me.SFrame()
+	require.ErrorIs(t, err, ErrNoSFrameSection)
+}
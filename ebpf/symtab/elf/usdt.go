@@ -0,0 +1,184 @@
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// USDTProbe is a statically defined tracing probe, described by a note of
+// the .note.stapsdt section.
+// https://sourceware.org/systemtap/wiki/UserSpaceProbeImplementation
+type USDTProbe struct {
+	Provider string
+	Name     string
+	// Location and Semaphore are the virtual addresses of the probe and of
+	// its semaphore, 0 if the probe has no semaphore.
+	Location  uint64
+	Semaphore uint64
+	// Args is the specification of the arguments, like "-4@%edi 8@%rsi".
+	Args string
+}
+
+var (
+	ErrNoUSDTSection = fmt.Errorf(".note.stapsdt section not found")
+)
+
+const usdtNoteType = 3
+
+// USDTProbes returns the USDT probes of the file. The addresses are adjusted
+// for the prelinking of the file, as found in the .stapsdt.base section.
+func (f *MMapedElfFile) USDTProbes() ([]USDTProbe, error) {
+	s := f.Section(".note.stapsdt")
+	if s == nil {
+		return nil, ErrNoUSDTSection
+	}
+	if f.Class != elf.ELFCLASS64 || f.ByteOrder != binary.LittleEndian {
+		return nil, fmt.Errorf("usdt probes of %s: only 64 bit little endian files are supported", f.fpath)
+	}
+	data, err := f.SectionData(s)
+	if err != nil {
+		return nil, fmt.Errorf("reading .note.stapsdt %w", err)
+	}
+	var base uint64
+	if b := f.Section(".stapsdt.base"); b != nil {
+		base = b.Addr
+	}
+	return parseUSDTNotes(data, base)
+}
+
+func parseUSDTNotes(data []byte, base uint64) ([]USDTProbe, error) {
+	var res []USDTProbe
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, fmt.Errorf("truncated .note.stapsdt")
+		}
+		nameSize := binary.LittleEndian.Uint32(data)
+		descSize := binary.LittleEndian.Uint32(data[4:])
+		typ := binary.LittleEndian.Uint32(data[8:])
+		nameEnd := 12 + uint64(nameSize)
+		descStart := align4(nameEnd)
+		descEnd := descStart + uint64(descSize)
+		if descEnd > uint64(len(data)) {
+			return nil, fmt.Errorf("truncated .note.stapsdt")
+		}
+		name := data[12:nameEnd]
+		desc := data[descStart:descEnd]
+		data = data[min(align4(descEnd), uint64(len(data))):]
+		if typ != usdtNoteType || string(bytes.TrimRight(name, "\x00")) != "stapsdt" {
+			continue
+		}
+		if len(desc) < 24 {
+			return nil, fmt.Errorf("truncated stapsdt note")
+		}
+		p := USDTProbe{
+			Location:  binary.LittleEndian.Uint64(desc),
+			Semaphore: binary.LittleEndian.Uint64(desc[16:]),
+		}
+		if noteBase := binary.LittleEndian.Uint64(desc[8:]); base != 0 {
+			p.Location += base - noteBase
+			if p.Semaphore != 0 {
+				p.Semaphore += base - noteBase
+			}
+		}
+		strs := strings.SplitN(string(desc[24:]), "\x00", 4)
+		if len(strs) < 3 {
+			return nil, fmt.Errorf("wrong stapsdt note")
+		}
+		p.Provider, p.Name, p.Args = strs[0], strs[1], strs[2]
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+func align4(n uint64) uint64 {
+	return (n + 3) &^ 3
+}
+
+// FileOffset converts a virtual address of the file to the offset of its
+// content in the file, as expected by the uprobes.
+func (f *InMemElfFile) FileOffset(addr uint64) (uint64, error) {
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD && p.Vaddr <= addr && addr < p.Vaddr+p.Memsz {
+			return addr - p.Vaddr + p.Off, nil
+		}
+	}
+	return 0, fmt.Errorf("address %x is not in a loadable segment", addr)
+}
+
+// USDTArg is an argument of a USDT probe, only the arguments passed in
+// registers are supported.
+type USDTArg struct {
+	// Size of the argument in bytes, negative if the argument is signed.
+	Size     int
+	Register string
+}
+
+// ParseUSDTArgs parses the specification of the arguments of a USDT probe.
+// The arguments not passed in a register are returned with an empty
+// register name.
+func ParseUSDTArgs(spec string) ([]USDTArg, error) {
+	var res []USDTArg
+	for _, arg := range splitUSDTArgs(spec) {
+		size, loc, ok := strings.Cut(arg, "@")
+		if !ok {
+			return nil, fmt.Errorf("wrong usdt argument %q", arg)
+		}
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("wrong usdt argument size %q", arg)
+		}
+		a := USDTArg{Size: n}
+		// %rdi on amd64, x0 on arm64
+		if reg, ok := strings.CutPrefix(loc, "%"); ok && isRegisterName(reg) {
+			a.Register = reg
+		} else if isRegisterName(loc) && (loc[0] == 'x' || loc[0] == 'w') {
+			a.Register = loc
+		}
+		res = append(res, a)
+	}
+	return res, nil
+}
+
+// splitUSDTArgs splits the arguments by the spaces, except the ones in the
+// arm64 memory operands, like [sp, 16].
+func splitUSDTArgs(spec string) []string {
+	var res []string
+	depth, start := 0, -1
+	for i, c := range spec {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ' ' && depth == 0:
+			if start >= 0 {
+				res = append(res, spec[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		res = append(res, spec[start:])
+	}
+	return res
+}
+
+func isRegisterName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
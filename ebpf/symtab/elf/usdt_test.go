@@ -0,0 +1,59 @@
+package elf
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func usdtNote(location, base, semaphore uint64, provider, name, args string) []byte {
+	desc := make([]byte, 24)
+	binary.LittleEndian.PutUint64(desc, location)
+	binary.LittleEndian.PutUint64(desc[8:], base)
+	binary.LittleEndian.PutUint64(desc[16:], semaphore)
+	desc = append(desc, provider+"\x00"+name+"\x00"+args+"\x00"...)
+	note := make([]byte, 12)
+	binary.LittleEndian.PutUint32(note, 8)
+	binary.LittleEndian.PutUint32(note[4:], uint32(len(desc)))
+	binary.LittleEndian.PutUint32(note[8:], usdtNoteType)
+	note = append(note, "stapsdt\x00"...)
+	note = append(note, desc...)
+	for len(note)%4 != 0 {
+		note = append(note, 0)
+	}
+	return note
+}
+
+func TestParseUSDTNotes(t *testing.T) {
+	data := append(usdtNote(0x1000, 0x3000, 0, "libc", "setjmp", "8@%rdi -4@%esi 8@%rdx"),
+		usdtNote(0x1100, 0x3000, 0x4000, "app", "request", "8@-8(%rbp)")...)
+
+	probes, err := parseUSDTNotes(data, 0)
+	require.NoError(t, err)
+	require.Equal(t, []USDTProbe{
+		{Provider: "libc", Name: "setjmp", Location: 0x1000, Args: "8@%rdi -4@%esi 8@%rdx"},
+		{Provider: "app", Name: "request", Location: 0x1100, Semaphore: 0x4000, Args: "8@-8(%rbp)"},
+	}, probes)
+
+	// prelinked at 0x3100
+	probes, err = parseUSDTNotes(data, 0x3100)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0x1100), probes[0].Location)
+	require.Equal(t, uint64(0x4100), probes[1].Semaphore)
+
+	_, err = parseUSDTNotes(data[:len(data)-8], 0)
+	require.Error(t, err)
+}
+
+func TestParseUSDTArgs(t *testing.T) {
+	args, err := ParseUSDTArgs("8@%rdi -4@%esi 8@-8(%rbp) 4@x1 8@[sp, 16]")
+	require.NoError(t, err)
+	require.Equal(t, []USDTArg{
+		{Size: 8, Register: "rdi"},
+		{Size: -4, Register: "esi"},
+		{Size: 8},
+		{Size: 4, Register: "x1"},
+		{Size: 8},
+	}, args)
+}
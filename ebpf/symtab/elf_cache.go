@@ -1,12 +1,39 @@
 package symtab
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/grafana/pyroscope/ebpf/symtab/elf"
+	lru "github.com/hashicorp/golang-lru/v2"
 )
 
 type ElfCache struct {
 	BuildIDCache  *GCache[elf.BuildID, SymbolNameResolver]
 	SameFileCache *GCache[Stat, SymbolNameResolver]
+
+	// NegativeCache holds the errors of the files the symbols could not be
+	// loaded from, so that the files are not parsed again before the TTL
+	// expires. Nil if disabled.
+	NegativeCache *lru.Cache[Stat, negativeEntry]
+	negativeTTL   time.Duration
+
+	// Inventory records the build ids of the binaries the symbols are
+	// loaded for.
+	Inventory *BuildIDInventory
+}
+
+// NegativeCacheOptions configures the caching of the files the symbols could
+// not be loaded from: unreadable, corrupted, or without symbols. The cache
+// is disabled if the size or the TTL is not set.
+type NegativeCacheOptions struct {
+	Size int
+	TTL  time.Duration
+}
+
+type negativeEntry struct {
+	err     error
+	expires time.Time
 }
 
 func NewElfCache(buildIDCacheOptions GCacheOptions, sameFileCacheOptions GCacheOptions) (*ElfCache, error) {
@@ -21,7 +48,8 @@ func NewElfCache(buildIDCacheOptions GCacheOptions, sameFileCacheOptions GCacheO
 	}
 	return &ElfCache{
 		BuildIDCache:  buildIdCache,
-		SameFileCache: statCache}, nil
+		SameFileCache: statCache,
+		Inventory:     NewBuildIDInventory()}, nil
 }
 
 func (e *ElfCache) GetSymbolsByBuildID(buildID elf.BuildID) SymbolNameResolver {
@@ -62,6 +90,48 @@ func (e *ElfCache) CacheByStat(s Stat, v SymbolNameResolver) {
 	e.SameFileCache.Cache(s, v)
 }
 
+// GetErrorByStat returns the error the symbols of the file could not be
+// loaded with, if not expired.
+func (e *ElfCache) GetErrorByStat(s Stat) error {
+	if e.NegativeCache == nil || s == (Stat{}) {
+		return nil
+	}
+	res, ok := e.NegativeCache.Get(s)
+	if !ok {
+		return nil
+	}
+	if time.Now().After(res.expires) {
+		e.NegativeCache.Remove(s)
+		return nil
+	}
+	return res.err
+}
+
+func (e *ElfCache) CacheErrorByStat(s Stat, err error) {
+	if e.NegativeCache == nil || s == (Stat{}) {
+		return
+	}
+	e.NegativeCache.Add(s, negativeEntry{err: err, expires: time.Now().Add(e.negativeTTL)})
+}
+
+func (e *ElfCache) UpdateNegative(options NegativeCacheOptions) error {
+	if options.Size <= 0 || options.TTL <= 0 {
+		e.NegativeCache = nil
+		return nil
+	}
+	e.negativeTTL = options.TTL
+	if e.NegativeCache != nil {
+		e.NegativeCache.Resize(options.Size)
+		return nil
+	}
+	c, err := lru.New[Stat, negativeEntry](options.Size)
+	if err != nil {
+		return fmt.Errorf("negative cache create %w", err)
+	}
+	e.NegativeCache = c
+	return nil
+}
+
 func (e *ElfCache) Update(buildIDCacheOptions GCacheOptions, sameFileCacheOptions GCacheOptions) {
 	e.BuildIDCache.Update(buildIDCacheOptions)
 	e.SameFileCache.Update(sameFileCacheOptions)
@@ -77,6 +147,10 @@ func (e *ElfCache) Cleanup() {
 	e.SameFileCache.Cleanup()
 }
 
+func (e *ElfCache) roundSize() int {
+	return e.BuildIDCache.RoundSize() + e.SameFileCache.RoundSize()
+}
+
 type ElfCacheDebugInfo struct {
 	BuildIDCache  GCacheDebugInfo[elf.SymTabDebugInfo] `alloy:"build_id_cache,attr,optional" river:"build_id_cache,attr,optional"`
 	SameFileCache GCacheDebugInfo[elf.SymTabDebugInfo] `alloy:"same_file_cache,attr,optional" river:"same_file_cache,attr,optional"`
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/grafana/pyroscope/ebpf/metrics"
 	"github.com/grafana/pyroscope/ebpf/util"
@@ -152,6 +153,38 @@ func TestElfCacheBuildIDProcessDeath(t *testing.T) {
 	require.Error(t, f2.err)
 }
 
+func TestElfCacheNegative(t *testing.T) {
+	elfCache, _ := NewElfCache(testCacheOptions, testCacheOptions)
+	require.NoError(t, elfCache.UpdateNegative(NegativeCacheOptions{Size: 32, TTL: time.Hour}))
+	logger := util.TestLogger(t)
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(root+"/elf", []byte("not an elf file"), 0o644))
+	newTable := func() *ElfTable {
+		return NewElfTable(logger, &ProcMap{StartAddr: 0x1000, Offset: 0x1000}, root, "/elf",
+			ElfTableOptions{
+				ElfCache: elfCache,
+				Metrics:  metrics.NewSymtabMetrics(nil),
+			})
+	}
+
+	f1 := newTable()
+	require.Equal(t, "", f1.Resolve(0x1149))
+	require.Error(t, f1.err)
+	require.Equal(t, 1, elfCache.NegativeCache.Len())
+
+	// The file is fixed in place, but the error is cached until the TTL expires.
+	src, err := os.ReadFile("elf/testdata/elfs/elf")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(root+"/elf", src, 0o644))
+	f2 := newTable()
+	require.Equal(t, "", f2.Resolve(0x1149))
+	require.Equal(t, f1.err, f2.err)
+
+	require.NoError(t, elfCache.UpdateNegative(NegativeCacheOptions{}))
+	f3 := newTable()
+	require.Equal(t, "iter", f3.Resolve(0x1149))
+}
+
 func copyFile(src, dst string) (int64, error) {
 	cleanSrc := filepath.Clean(src)
 	cleanDst := filepath.Clean(dst)
@@ -0,0 +1,99 @@
+package symtab
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grafana/pyroscope/ebpf/metrics"
+)
+
+// ElfParserOptions bounds the parsing of the symbol tables of the ELF files.
+type ElfParserOptions struct {
+	// Workers is the number of files parsed concurrently, 1 if not set.
+	Workers int
+	// Deadline is the time the resolution of an address waits for the symbol
+	// table of a file to be parsed. Past the deadline, the addresses in the
+	// file are resolved to the file name only, until the parsing completes.
+	// No deadline if not set.
+	Deadline time.Duration
+}
+
+type elfParseResult struct {
+	symbols SymbolNameResolver
+	err     error
+}
+
+// ElfParser parses the symbol tables in a bounded pool of workers, so that
+// an enormous or corrupted file can not stall the resolution of the
+// addresses in the other files. A nil ElfParser parses in the caller
+// goroutine.
+type ElfParser struct {
+	mu       sync.Mutex
+	workers  chan struct{}
+	deadline time.Duration
+
+	metrics *metrics.SymtabMetrics
+}
+
+func NewElfParser(options ElfParserOptions, metrics *metrics.SymtabMetrics) *ElfParser {
+	p := &ElfParser{metrics: metrics}
+	p.Update(options)
+	return p
+}
+
+func (p *ElfParser) Update(options ElfParserOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	workers := max(options.Workers, 1)
+	if p.workers == nil || cap(p.workers) != workers {
+		// The parsing in progress keeps the previous workers.
+		p.workers = make(chan struct{}, workers)
+	}
+	p.deadline = options.Deadline
+}
+
+// parse runs the parsing function in the pool and returns the channel the
+// result is delivered to.
+func (p *ElfParser) parse(f func() (SymbolNameResolver, error)) <-chan elfParseResult {
+	res := make(chan elfParseResult, 1)
+	if p == nil {
+		symbols, err := f()
+		res <- elfParseResult{symbols: symbols, err: err}
+		return res
+	}
+	p.mu.Lock()
+	workers := p.workers
+	p.mu.Unlock()
+	go func() {
+		workers <- struct{}{}
+		defer func() { <-workers }()
+		symbols, err := f()
+		res <- elfParseResult{symbols: symbols, err: err}
+	}()
+	return res
+}
+
+// wait waits for the result of the parsing until the deadline.
+// It returns false if the deadline is hit.
+func (p *ElfParser) wait(res <-chan elfParseResult) (elfParseResult, bool) {
+	var deadline time.Duration
+	if p != nil {
+		p.mu.Lock()
+		deadline = p.deadline
+		p.mu.Unlock()
+	}
+	if deadline <= 0 {
+		return <-res, true
+	}
+	t := time.NewTimer(deadline)
+	defer t.Stop()
+	select {
+	case r := <-res:
+		return r, true
+	case <-t.C:
+		if p.metrics != nil {
+			p.metrics.ElfParseTimeouts.Inc()
+		}
+		return elfParseResult{}, false
+	}
+}
@@ -0,0 +1,65 @@
+package symtab
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/pyroscope/ebpf/metrics"
+	"github.com/grafana/pyroscope/ebpf/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestElfParserDeadline(t *testing.T) {
+	m := metrics.NewSymtabMetrics(nil)
+	p := NewElfParser(ElfParserOptions{Workers: 1, Deadline: 10 * time.Millisecond}, m)
+
+	block := make(chan struct{})
+	stuck := p.parse(func() (SymbolNameResolver, error) {
+		<-block
+		return &noopSymbolNameResolver{}, nil
+	})
+	_, ok := p.wait(stuck)
+	assert.False(t, ok)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.ElfParseTimeouts))
+
+	// The only worker is busy.
+	queued := p.parse(func() (SymbolNameResolver, error) {
+		return &noopSymbolNameResolver{}, nil
+	})
+	_, ok = p.wait(queued)
+	assert.False(t, ok)
+
+	close(block)
+	res := <-stuck
+	require.NoError(t, res.err)
+	res = <-queued
+	require.NoError(t, res.err)
+	assert.NotNil(t, res.symbols)
+}
+
+func TestElfTableParseDeadline(t *testing.T) {
+	m := metrics.NewSymtabMetrics(nil)
+	p := NewElfParser(ElfParserOptions{Workers: 1, Deadline: time.Millisecond}, m)
+	// Occupy the only worker, so that the table is not parsed before the deadline.
+	block := make(chan struct{})
+	p.parse(func() (SymbolNameResolver, error) {
+		<-block
+		return nil, nil
+	})
+
+	elfCache, _ := NewElfCache(testCacheOptions, testCacheOptions)
+	table := NewElfTable(util.TestLogger(t), &ProcMap{StartAddr: 0x1000, Offset: 0x1000}, ".", "elf/testdata/elfs/elf",
+		ElfTableOptions{
+			ElfCache:  elfCache,
+			ElfParser: p,
+			Metrics:   m,
+		})
+	assert.Equal(t, "", table.Resolve(0x1149))
+
+	close(block)
+	require.Eventually(t, func() bool {
+		return table.Resolve(0x1149) == "iter"
+	}, time.Second, time.Millisecond)
+}
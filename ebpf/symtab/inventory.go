@@ -0,0 +1,132 @@
+package symtab
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/grafana/pyroscope/ebpf/symtab/elf"
+)
+
+// The symbolization status of the binaries of the inventory.
+const (
+	// SymbolsStatusPending is the status of the binaries being parsed.
+	SymbolsStatusPending = "pending"
+	// SymbolsStatusSymbols is the status of the binaries symbolized with
+	// their own symbol tables.
+	SymbolsStatusSymbols = "symbols"
+	// SymbolsStatusDebugFile is the status of the binaries symbolized with
+	// a separate debug file.
+	SymbolsStatusDebugFile = "debug_file"
+	// SymbolsStatusSharedCache is the status of the binaries symbolized by
+	// the shared cache server.
+	SymbolsStatusSharedCache = "shared_cache"
+	// SymbolsStatusError is the status of the binaries the symbols could not
+	// be loaded for, the stacks show their module only.
+	SymbolsStatusError = "error"
+)
+
+// maxInventoryBuildIDs bounds the number of the build ids of the inventory,
+// the binaries seen after the limit is reached are not tracked.
+const maxInventoryBuildIDs = 16384
+
+// maxInventoryPaths bounds the number of the paths a build id is seen at.
+const maxInventoryPaths = 8
+
+// BuildIDInventoryEntry describes a binary seen in the profiled processes.
+type BuildIDInventoryEntry struct {
+	BuildID string `alloy:"build_id,attr" river:"build_id,attr" json:"build_id"`
+	// Type of the build id, gnu or go.
+	Type string `alloy:"type,attr" river:"type,attr" json:"type"`
+	// Paths of the binary in the processes, in their root filesystems.
+	Paths     []string  `alloy:"paths,attr,optional" river:"paths,attr,optional" json:"paths"`
+	Size      int64     `alloy:"size,attr,optional" river:"size,attr,optional" json:"size"`
+	Status    string    `alloy:"status,attr" river:"status,attr" json:"status"`
+	DebugFile string    `alloy:"debug_file,attr,optional" river:"debug_file,attr,optional" json:"debug_file,omitempty"`
+	Error     string    `alloy:"error,attr,optional" river:"error,attr,optional" json:"error,omitempty"`
+	FirstSeen time.Time `alloy:"first_seen,attr,optional" river:"first_seen,attr,optional" json:"first_seen"`
+	LastSeen  time.Time `alloy:"last_seen,attr,optional" river:"last_seen,attr,optional" json:"last_seen"`
+}
+
+// BuildIDInventory records the build ids of the binaries loaded by the
+// profiled processes, for the symbol upload pipelines and the audit of the
+// code running on the node. It is safe for concurrent use.
+type BuildIDInventory struct {
+	mutex   sync.Mutex
+	entries map[elf.BuildID]*BuildIDInventoryEntry
+}
+
+func NewBuildIDInventory() *BuildIDInventory {
+	return &BuildIDInventory{entries: make(map[elf.BuildID]*BuildIDInventoryEntry)}
+}
+
+// seen records the binary, path is its path in the process.
+func (i *BuildIDInventory) seen(buildID elf.BuildID, path string, size int64) {
+	if i == nil || buildID.Empty() {
+		return
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	now := time.Now()
+	e := i.entries[buildID]
+	if e == nil {
+		if len(i.entries) >= maxInventoryBuildIDs {
+			return
+		}
+		e = &BuildIDInventoryEntry{
+			BuildID:   buildID.ID,
+			Type:      buildID.Typ,
+			Status:    SymbolsStatusPending,
+			FirstSeen: now,
+		}
+		i.entries[buildID] = e
+	}
+	e.LastSeen = now
+	e.Size = size
+	if len(e.Paths) < maxInventoryPaths && !slices.Contains(e.Paths, path) {
+		e.Paths = append(e.Paths, path)
+	}
+}
+
+// setStatus records how the binary is symbolized. The status of a binary
+// symbolized once is not downgraded by the failures of the later loads.
+func (i *BuildIDInventory) setStatus(buildID elf.BuildID, status string, debugFile string, err error) {
+	if i == nil || buildID.Empty() {
+		return
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	e := i.entries[buildID]
+	if e == nil {
+		return
+	}
+	if status == SymbolsStatusError && e.Status != SymbolsStatusPending && e.Status != SymbolsStatusError {
+		return
+	}
+	e.Status = status
+	e.DebugFile = debugFile
+	e.Error = ""
+	if err != nil {
+		e.Error = err.Error()
+	}
+}
+
+// Entries returns the binaries of the inventory, ordered by build id.
+func (i *BuildIDInventory) Entries() []BuildIDInventoryEntry {
+	if i == nil {
+		return nil
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	res := make([]BuildIDInventoryEntry, 0, len(i.entries))
+	for _, e := range i.entries {
+		c := *e
+		c.Paths = slices.Clone(e.Paths)
+		res = append(res, c)
+	}
+	slices.SortFunc(res, func(a, b BuildIDInventoryEntry) int {
+		return cmp.Compare(a.BuildID, b.BuildID)
+	})
+	return res
+}
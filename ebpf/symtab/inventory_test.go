@@ -0,0 +1,43 @@
+package symtab
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/grafana/pyroscope/ebpf/symtab/elf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildIDInventory(t *testing.T) {
+	i := NewBuildIDInventory()
+	app := elf.GNUBuildID("cafebabe")
+	libc := elf.GNUBuildID("0badf00d")
+
+	i.seen(app, "/usr/bin/app", 4096)
+	i.seen(app, "/app", 4096)
+	i.seen(app, "/app", 4096)
+	i.seen(libc, "/lib/libc.so.6", 8192)
+	i.seen(elf.BuildID{}, "/tmp/no-build-id", 1)
+
+	i.setStatus(app, SymbolsStatusDebugFile, "/usr/lib/debug/.build-id/ca/febabe.debug", nil)
+	// a later failure does not downgrade the status
+	i.setStatus(app, SymbolsStatusError, "", errors.New("too many open files"))
+	i.setStatus(libc, SymbolsStatusError, "", errors.New("no symbols"))
+
+	entries := i.Entries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "0badf00d", entries[0].BuildID)
+	assert.Equal(t, SymbolsStatusError, entries[0].Status)
+	assert.Equal(t, "no symbols", entries[0].Error)
+	assert.Equal(t, "cafebabe", entries[1].BuildID)
+	assert.Equal(t, "gnu", entries[1].Type)
+	assert.Equal(t, []string{"/usr/bin/app", "/app"}, entries[1].Paths)
+	assert.Equal(t, int64(4096), entries[1].Size)
+	assert.Equal(t, SymbolsStatusDebugFile, entries[1].Status)
+	assert.Empty(t, entries[1].Error)
+
+	var disabled *BuildIDInventory
+	disabled.seen(app, "/app", 1)
+	assert.Empty(t, disabled.Entries())
+}
@@ -3,11 +3,10 @@ package symtab
 import (
 	"fmt"
 	"os"
-	"path"
 	"slices"
-	"strconv"
 	"strings"
 
+	"github.com/grafana/pyroscope/ebpf/procfs"
 	"github.com/grafana/pyroscope/ebpf/symtab/elf"
 
 	"github.com/go-kit/log"
@@ -56,7 +55,7 @@ func NewProcTable(logger log.Logger, options ProcTableOptions) *ProcTable {
 		logger:     logger,
 		file2Table: make(map[file]*ElfTable),
 		options:    options,
-		rootFS:     path.Join("/proc", strconv.Itoa(options.Pid), "root"),
+		rootFS:     procfs.RootFS(uint32(options.Pid)),
 	}
 }
 
@@ -70,7 +69,7 @@ func (p *ProcTable) Refresh() {
 	if p.err != nil {
 		return
 	}
-	procMaps, err := os.ReadFile(fmt.Sprintf("/proc/%d/maps", p.options.Pid))
+	procMaps, err := os.ReadFile(procfs.Path(uint32(p.options.Pid), "maps"))
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			level.Error(p.logger).Log("msg", "failed to read /proc/pid/maps", "err", err)
@@ -167,6 +166,14 @@ func (p *ProcTable) createElfTable(m *ProcMap) *ElfTable {
 	return e
 }
 
+// LoadElfTables loads the symbols of the binaries mapped, which are
+// otherwise loaded when the first address in them is resolved.
+func (p *ProcTable) LoadElfTables() {
+	for _, e := range p.file2Table {
+		e.load()
+	}
+}
+
 func (p *ProcTable) Cleanup() {
 	for _, table := range p.file2Table {
 		table.Cleanup()
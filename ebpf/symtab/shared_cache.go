@@ -0,0 +1,332 @@
+package symtab
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/pyroscope/ebpf/metrics"
+	elf2 "github.com/grafana/pyroscope/ebpf/symtab/elf"
+	"github.com/ianlancetaylor/demangle"
+)
+
+// The shared cache lets several profilers running on the same node, such as
+// Alloy and a standalone agent, share the symbol tables of the ELF files:
+// the tables are parsed and kept by a server, and the profilers resolve the
+// addresses through a unix socket. Only the files with a build id are
+// shared, and the server must see the files at the same paths as the
+// profilers, that is, run in the same mount and pid namespaces.
+
+const sharedCacheServiceName = "SymbolCache"
+
+var errSharedTableNotLoaded = errors.New("symbol table not loaded")
+
+type sharedTableKey struct {
+	BuildID         elf2.BuildID
+	GoTableFallback bool
+	DemangleOptions string
+}
+
+// SharedLoadArgs asks the server to load the symbol table of a file.
+type SharedLoadArgs struct {
+	FS              string
+	ElfFilePath     string
+	BuildID         elf2.BuildID
+	GoTableFallback bool
+	DemangleOptions []demangle.Option
+}
+
+func (a *SharedLoadArgs) key() sharedTableKey {
+	return sharedTableKey{
+		BuildID:         a.BuildID,
+		GoTableFallback: a.GoTableFallback,
+		DemangleOptions: fmt.Sprint(a.DemangleOptions),
+	}
+}
+
+// SharedLoadReply describes the loaded symbol table.
+type SharedLoadReply struct {
+	Name string
+}
+
+// SharedResolveArgs asks the server to resolve an address relative to the
+// base of the file.
+type SharedResolveArgs struct {
+	Table SharedLoadArgs
+	Addr  uint64
+}
+
+type SharedResolveReply struct {
+	Name string
+}
+
+type SharedCacheServerOptions struct {
+	// Socket is the path of the unix socket the server listens on.
+	Socket       string
+	CacheOptions GCacheOptions
+}
+
+// SharedCacheServer keeps the symbol tables shared by the profilers.
+type SharedCacheServer struct {
+	logger   log.Logger
+	metrics  *metrics.SymtabMetrics
+	listener net.Listener
+	done     chan struct{}
+
+	mu     sync.Mutex
+	tables *GCache[sharedTableKey, SymbolNameResolver]
+}
+
+func NewSharedCacheServer(logger log.Logger, options SharedCacheServerOptions, metrics *metrics.SymtabMetrics) (*SharedCacheServer, error) {
+	tables, err := NewGCache[sharedTableKey, SymbolNameResolver](options.CacheOptions)
+	if err != nil {
+		return nil, fmt.Errorf("create shared cache %w", err)
+	}
+	// Remove the socket of a previous run.
+	if err = os.Remove(options.Socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove shared cache socket %w", err)
+	}
+	l, err := net.Listen("unix", options.Socket)
+	if err != nil {
+		return nil, fmt.Errorf("listen shared cache socket %w", err)
+	}
+	s := &SharedCacheServer{
+		logger:   logger,
+		metrics:  metrics,
+		listener: l,
+		done:     make(chan struct{}),
+		tables:   tables,
+	}
+	return s, nil
+}
+
+// Serve serves the profilers until the server is closed.
+func (s *SharedCacheServer) Serve() error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(sharedCacheServiceName, &sharedCacheService{s}); err != nil {
+		return err
+	}
+	go s.nextRounds()
+	srv.Accept(s.listener)
+	return nil
+}
+
+func (s *SharedCacheServer) Close() error {
+	close(s.done)
+	return s.listener.Close()
+}
+
+func (s *SharedCacheServer) nextRounds() {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.mu.Lock()
+			s.tables.NextRound()
+			s.tables.Cleanup()
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *SharedCacheServer) get(key sharedTableKey) SymbolNameResolver {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tables.Get(key)
+	if t != nil && t.IsDead() {
+		s.tables.Remove(key)
+		return nil
+	}
+	return t
+}
+
+func (s *SharedCacheServer) load(args *SharedLoadArgs) (SymbolNameResolver, error) {
+	key := args.key()
+	if t := s.get(key); t != nil {
+		return t, nil
+	}
+	// The table is parsed without the lock held: the other tables
+	// can be resolved meanwhile.
+	et := &ElfTable{
+		fs:          args.FS,
+		elfFilePath: args.ElfFilePath,
+		logger:      s.logger,
+		options: ElfTableOptions{
+			Metrics: s.metrics,
+			SymbolOptions: &SymbolOptions{
+				GoTableFallback: args.GoTableFallback,
+				DemangleOptions: args.DemangleOptions,
+			},
+		},
+	}
+	table, err := et.parseSymbols(args.BuildID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.tables.Get(key); t != nil {
+		return t, nil
+	}
+	s.tables.Cache(key, table)
+	return table, nil
+}
+
+func (s *SharedCacheServer) resolve(args *SharedResolveArgs) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tables.Get(args.Table.key())
+	if t == nil || t.IsDead() {
+		return "", errSharedTableNotLoaded
+	}
+	return t.Resolve(args.Addr), nil
+}
+
+// sharedCacheService is the rpc service of the server.
+type sharedCacheService struct {
+	s *SharedCacheServer
+}
+
+func (r *sharedCacheService) Load(args SharedLoadArgs, reply *SharedLoadReply) error {
+	table, err := r.s.load(&args)
+	if err != nil {
+		level.Debug(r.s.logger).Log("msg", "shared cache load failed", "f", args.ElfFilePath, "fs", args.FS, "err", err)
+		return err
+	}
+	reply.Name = table.DebugInfo().Name
+	return nil
+}
+
+func (r *sharedCacheService) Resolve(args SharedResolveArgs, reply *SharedResolveReply) error {
+	name, err := r.s.resolve(&args)
+	reply.Name = name
+	return err
+}
+
+// SharedCacheClient connects to a shared cache server. The connection is
+// established on the first use, and re-established after a failure, at most
+// every sharedCacheRedialInterval.
+type SharedCacheClient struct {
+	socket string
+
+	mu         sync.Mutex
+	client     *rpc.Client
+	lastFailed time.Time
+}
+
+const sharedCacheRedialInterval = 10 * time.Second
+
+var errSharedCacheUnavailable = errors.New("shared cache unavailable")
+
+func NewSharedCacheClient(socket string) *SharedCacheClient {
+	return &SharedCacheClient{socket: socket}
+}
+
+func (c *SharedCacheClient) call(method string, args any, reply any) error {
+	c.mu.Lock()
+	client := c.client
+	if client == nil {
+		if time.Since(c.lastFailed) < sharedCacheRedialInterval {
+			c.mu.Unlock()
+			return errSharedCacheUnavailable
+		}
+		var err error
+		if client, err = rpc.Dial("unix", c.socket); err != nil {
+			c.lastFailed = time.Now()
+			c.mu.Unlock()
+			return err
+		}
+		c.client = client
+	}
+	c.mu.Unlock()
+
+	err := client.Call(sharedCacheServiceName+"."+method, args, reply)
+	var serverErr rpc.ServerError
+	if err != nil && !errors.As(err, &serverErr) {
+		// The connection is broken.
+		c.mu.Lock()
+		if c.client == client {
+			_ = client.Close()
+			c.client = nil
+			c.lastFailed = time.Now()
+		}
+		c.mu.Unlock()
+	}
+	return err
+}
+
+func (c *SharedCacheClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err
+}
+
+// sharedSymbolNameResolver resolves the addresses with the shared cache
+// server. The resolved names are kept, and the table is dead once the server
+// does not have it anymore, so that it is loaded again.
+type sharedSymbolNameResolver struct {
+	client    *SharedCacheClient
+	table     SharedLoadArgs
+	tableName string
+	names     map[uint64]string
+	dead      bool
+}
+
+func newSharedSymbolNameResolver(client *SharedCacheClient, table SharedLoadArgs) (*sharedSymbolNameResolver, error) {
+	var reply SharedLoadReply
+	if err := client.call("Load", table, &reply); err != nil {
+		return nil, err
+	}
+	return &sharedSymbolNameResolver{
+		client:    client,
+		table:     table,
+		tableName: reply.Name,
+		names:     make(map[uint64]string),
+	}, nil
+}
+
+func (r *sharedSymbolNameResolver) Resolve(addr uint64) string {
+	if name, ok := r.names[addr]; ok {
+		return name
+	}
+	if r.dead {
+		return ""
+	}
+	var reply SharedResolveReply
+	if err := r.client.call("Resolve", SharedResolveArgs{Table: r.table, Addr: addr}, &reply); err != nil {
+		r.dead = true
+		return ""
+	}
+	r.names[addr] = reply.Name
+	return reply.Name
+}
+
+func (r *sharedSymbolNameResolver) IsDead() bool {
+	return r.dead
+}
+
+func (r *sharedSymbolNameResolver) DebugInfo() elf2.SymTabDebugInfo {
+	return elf2.SymTabDebugInfo{
+		Name: "shared " + r.tableName,
+		Size: len(r.names),
+		File: r.table.ElfFilePath,
+	}
+}
+
+func (r *sharedSymbolNameResolver) Refresh() {}
+
+func (r *sharedSymbolNameResolver) Cleanup() {}
@@ -0,0 +1,61 @@
+package symtab
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/pyroscope/ebpf/metrics"
+	"github.com/grafana/pyroscope/ebpf/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedCache(t *testing.T) {
+	logger := util.TestLogger(t)
+	socket := filepath.Join(t.TempDir(), "symtab.sock")
+	server, err := NewSharedCacheServer(logger, SharedCacheServerOptions{
+		Socket:       socket,
+		CacheOptions: testCacheOptions,
+	}, metrics.NewSymtabMetrics(nil))
+	require.NoError(t, err)
+	go func() { _ = server.Serve() }()
+	defer server.Close()
+
+	// Two profilers, each with its own elf cache.
+	newTable := func(elfFilePath string) *ElfTable {
+		elfCache, _ := NewElfCache(testCacheOptions, testCacheOptions)
+		client := NewSharedCacheClient(socket)
+		t.Cleanup(func() { _ = client.Close() })
+		return NewElfTable(logger, &ProcMap{StartAddr: 0x1000, Offset: 0x1000}, ".", elfFilePath,
+			ElfTableOptions{
+				ElfCache:    elfCache,
+				SharedCache: client,
+				Metrics:     metrics.NewSymtabMetrics(nil),
+			})
+	}
+	debug := newTable("elf/testdata/elfs/elf")
+	require.Equal(t, "iter", debug.Resolve(0x1149))
+	require.Equal(t, "main", debug.Resolve(0x115e))
+	require.IsType(t, &sharedSymbolNameResolver{}, debug.table)
+
+	// The stripped file has the same build id: the table parsed by the
+	// server for the first profiler is used.
+	stripped := newTable("elf/testdata/elfs/elf.stripped")
+	require.Equal(t, "iter", stripped.Resolve(0x1149))
+	require.Equal(t, "main", stripped.Resolve(0x115e))
+	require.NoError(t, stripped.err)
+}
+
+func TestSharedCacheUnavailable(t *testing.T) {
+	logger := util.TestLogger(t)
+	elfCache, _ := NewElfCache(testCacheOptions, testCacheOptions)
+	client := NewSharedCacheClient(filepath.Join(t.TempDir(), "symtab.sock"))
+	table := NewElfTable(logger, &ProcMap{StartAddr: 0x1000, Offset: 0x1000}, ".", "elf/testdata/elfs/elf",
+		ElfTableOptions{
+			ElfCache:    elfCache,
+			SharedCache: client,
+			Metrics:     metrics.NewSymtabMetrics(nil),
+		})
+	// The file is parsed locally.
+	require.Equal(t, "iter", table.Resolve(0x1149))
+	require.NotContains(t, table.DebugInfo().Name, "shared")
+}
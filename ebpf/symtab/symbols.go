@@ -16,9 +16,13 @@ type PidKey uint32
 type SymbolCache struct {
 	pidCache *GCache[PidKey, *ProcTable]
 
-	elfCache *ElfCache
-	kallsyms *SymbolTab
-	logger   log.Logger
+	elfCache  *ElfCache
+	elfParser *ElfParser
+	kallsyms  *SymbolTab
+	logger    log.Logger
+
+	sharedCacheSocket string
+	sharedCache       *SharedCacheClient
 
 	metrics *metrics.SymtabMetrics
 }
@@ -26,6 +30,11 @@ type CacheOptions struct {
 	PidCacheOptions      GCacheOptions
 	BuildIDCacheOptions  GCacheOptions
 	SameFileCacheOptions GCacheOptions
+	NegativeCacheOptions NegativeCacheOptions
+	ElfParserOptions     ElfParserOptions
+	// SharedCacheSocket is the unix socket of the shared cache server, if
+	// any, see SharedCacheServer.
+	SharedCacheSocket string
 }
 
 func NewSymbolCache(logger log.Logger, options CacheOptions, metrics *metrics.SymtabMetrics) (*SymbolCache, error) {
@@ -36,18 +45,38 @@ func NewSymbolCache(logger log.Logger, options CacheOptions, metrics *metrics.Sy
 	if err != nil {
 		return nil, fmt.Errorf("create elf cache %w", err)
 	}
+	if err = elfCache.UpdateNegative(options.NegativeCacheOptions); err != nil {
+		return nil, fmt.Errorf("create elf cache %w", err)
+	}
 
 	cache, err := NewGCache[PidKey, *ProcTable](options.PidCacheOptions)
 	if err != nil {
 		return nil, fmt.Errorf("create pid cache %w", err)
 	}
-	return &SymbolCache{
-		logger:   logger,
-		pidCache: cache,
-		kallsyms: nil,
-		elfCache: elfCache,
-		metrics:  metrics,
-	}, nil
+	sc := &SymbolCache{
+		logger:    logger,
+		pidCache:  cache,
+		kallsyms:  nil,
+		elfCache:  elfCache,
+		elfParser: NewElfParser(options.ElfParserOptions, metrics),
+		metrics:   metrics,
+	}
+	sc.updateSharedCache(options.SharedCacheSocket)
+	return sc, nil
+}
+
+func (sc *SymbolCache) updateSharedCache(socket string) {
+	if socket == sc.sharedCacheSocket {
+		return
+	}
+	if sc.sharedCache != nil {
+		_ = sc.sharedCache.Close()
+		sc.sharedCache = nil
+	}
+	sc.sharedCacheSocket = socket
+	if socket != "" {
+		sc.sharedCache = NewSharedCacheClient(socket)
+	}
 }
 
 func (sc *SymbolCache) NextRound() {
@@ -56,8 +85,17 @@ func (sc *SymbolCache) NextRound() {
 }
 
 func (sc *SymbolCache) Cleanup() {
+	pids, elfs := sc.pidCache.RoundSize(), sc.elfCache.roundSize()
 	sc.elfCache.Cleanup()
 	sc.pidCache.Cleanup()
+	if evicted := pids - sc.pidCache.RoundSize(); evicted > 0 {
+		_ = level.Debug(sc.logger).Log("msg", "symbol cache cleanup", "event", "cache_evict",
+			"cache", "pid", "evicted", evicted, "size", sc.pidCache.RoundSize())
+	}
+	if evicted := elfs - sc.elfCache.roundSize(); evicted > 0 {
+		_ = level.Debug(sc.logger).Log("msg", "symbol cache cleanup", "event", "cache_evict",
+			"cache", "elf", "evicted", evicted, "size", sc.elfCache.roundSize())
+	}
 }
 
 func (sc *SymbolCache) GetProcTableCached(pid PidKey) *ProcTable {
@@ -75,6 +113,8 @@ func (sc *SymbolCache) NewProcTable(pid PidKey, symbolOptions *SymbolOptions) *P
 		Pid: int(pid),
 		ElfTableOptions: ElfTableOptions{
 			ElfCache:      sc.elfCache,
+			ElfParser:     sc.elfParser,
+			SharedCache:   sc.sharedCache,
 			Metrics:       sc.metrics,
 			SymbolOptions: symbolOptions,
 		},
@@ -109,6 +149,11 @@ func (sc *SymbolCache) initKallsyms() SymbolTable {
 func (sc *SymbolCache) UpdateOptions(options CacheOptions) {
 	sc.pidCache.Update(options.PidCacheOptions)
 	sc.elfCache.Update(options.BuildIDCacheOptions, options.SameFileCacheOptions)
+	if err := sc.elfCache.UpdateNegative(options.NegativeCacheOptions); err != nil {
+		level.Error(sc.logger).Log("msg", "failed to update negative cache", "err", err)
+	}
+	sc.elfParser.Update(options.ElfParserOptions)
+	sc.updateSharedCache(options.SharedCacheSocket)
 }
 
 func (sc *SymbolCache) PidCacheDebugInfo() GCacheDebugInfo[ProcTableDebugInfo] {
@@ -125,6 +170,12 @@ func (sc *SymbolCache) ElfCacheDebugInfo() ElfCacheDebugInfo {
 	return sc.elfCache.DebugInfo()
 }
 
+// BuildIDInventory returns the build ids of the binaries of the profiled
+// processes.
+func (sc *SymbolCache) BuildIDInventory() []BuildIDInventoryEntry {
+	return sc.elfCache.Inventory.Entries()
+}
+
 func (sc *SymbolCache) RemoveDeadPID(pid PidKey) {
 	sc.pidCache.Remove(pid)
 }
@@ -0,0 +1,120 @@
+package testutil
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// BurnerImage is the repository of the CPU burner images, see
+// burners/README.md. The tag is the name of the burner.
+const BurnerImage = "pyroscope/ebpf-testdata-burner"
+
+// Burner is a CPU burner image and the stacks expected in its profile.
+type Burner struct {
+	Name     string
+	Expected []byte
+	// Skip is the reason the burner is not profiled yet, for the burners of
+	// the unwinders not implemented yet or whose expected profile is not
+	// generated from the burner yet.
+	Skip string
+}
+
+func (b Burner) Image() string {
+	return BurnerImage + ":" + b.Name
+}
+
+// RunContainer runs the image, which exposes no port.
+func RunContainer(t *testing.T, l log.Logger, image string) *Container {
+	container := &Container{
+		T: t,
+		L: log.With(l, "component", "docker"),
+	}
+	container.Run("docker", "run", "--rm", "-tid", image)
+	return container
+}
+
+// MatchStack reports whether the stack, the frames from the root separated
+// by semicolons, matches the pattern of an expected profile: the frames of
+// the pattern are compared as is, and a * frame matches any number of
+// frames, the ones of the runtime which differ between the images.
+func MatchStack(pattern, stack string) bool {
+	return matchFrames(strings.Split(pattern, ";"), strings.Split(stack, ";"))
+}
+
+func matchFrames(pattern, frames []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "*" {
+			for i := 0; i <= len(frames); i++ {
+				if matchFrames(pattern[1:], frames[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(frames) == 0 || frames[0] != pattern[0] {
+			return false
+		}
+		pattern, frames = pattern[1:], frames[1:]
+	}
+	return len(frames) == 0
+}
+
+// ExpectedStacks returns the patterns of an expected profile file, one per
+// line.
+func ExpectedStacks(expected []byte) []string {
+	var res []string
+	for _, line := range strings.Split(string(expected), "\n") {
+		if line != "" {
+			res = append(res, line)
+		}
+	}
+	return res
+}
+
+// RequireStacks collects the profiles until each pattern of the expected
+// profile matches a stack, or fails after the timeout. The stacks are
+// accumulated across the collections, collect adds the stacks of one.
+func RequireStacks(t *testing.T, l log.Logger, expected []byte, timeout time.Duration, collect func(stacks map[string]struct{})) {
+	patterns := ExpectedStacks(expected)
+	require.NotEmpty(t, patterns)
+	stacks := map[string]struct{}{}
+	var missing []string
+	deadline := time.Now().Add(timeout)
+	for {
+		time.Sleep(time.Second)
+		collect(stacks)
+		missing = missingStacks(patterns, stacks)
+		if len(missing) == 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	for stack := range stacks {
+		_ = l.Log("actual", stack)
+	}
+	for _, pattern := range missing {
+		_ = l.Log("missing", pattern)
+	}
+	require.Empty(t, missing, fmt.Sprintf("%d expected stacks not found", len(missing)))
+}
+
+func missingStacks(patterns []string, stacks map[string]struct{}) []string {
+	var missing []string
+	for _, pattern := range patterns {
+		found := false
+		for stack := range stacks {
+			if MatchStack(pattern, stack) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, pattern)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,31 @@
+// cpu-burner-cpp spins in main -> work_a -> burn and main -> work_b -> burn,
+// compiled with frame pointers.
+#include <cstdint>
+
+namespace burner {
+
+__attribute__((noinline)) uint64_t burn(uint64_t n) {
+    volatile uint64_t x = 0;
+    for (uint64_t i = 0; i < n; i++) {
+        x = x + i * i;
+    }
+    return x;
+}
+
+__attribute__((noinline)) uint64_t work_a() {
+    return burn(1 << 20) + 1;
+}
+
+__attribute__((noinline)) uint64_t work_b() {
+    return burn(1 << 21) + 2;
+}
+
+} // namespace burner
+
+int main() {
+    volatile uint64_t sum = 0;
+    for (;;) {
+        sum = sum + burner::work_a();
+        sum = sum + burner::work_b();
+    }
+}
@@ -0,0 +1,26 @@
+<?php
+// burner.php spins in {main} -> work_a -> burn and {main} -> work_b -> burn.
+
+function burn(int $n): int
+{
+    $x = 0;
+    for ($i = 0; $i < $n; $i++) {
+        $x = ($x + $i * $i) % PHP_INT_MAX;
+    }
+    return $x;
+}
+
+function work_a(): int
+{
+    return burn(200000) + 1;
+}
+
+function work_b(): int
+{
+    return burn(400000) + 2;
+}
+
+$sum = 0;
+while (true) {
+    $sum = ($sum + work_a() + work_b()) % PHP_INT_MAX;
+}
@@ -0,0 +1,26 @@
+# burner.rb spins in <main> -> work_a -> burn and <main> -> work_b -> burn,
+# without blocks, so that the stacks have no block frames.
+
+def burn(n)
+  x = 0
+  i = 0
+  while i < n
+    x += i * i
+    i += 1
+  end
+  x
+end
+
+def work_a
+  burn(200_000) + 1
+end
+
+def work_b
+  burn(400_000) + 2
+end
+
+sum = 0
+while true
+  sum += work_a
+  sum += work_b
+end
@@ -0,0 +1,30 @@
+// cpu-burner-rust spins in main -> work_a -> burn and main -> work_b -> burn.
+
+use std::hint::black_box;
+
+#[inline(never)]
+fn burn(n: u64) -> u64 {
+    let mut x: u64 = 0;
+    for i in 0..n {
+        x = black_box(x.wrapping_add(i.wrapping_mul(i)));
+    }
+    x
+}
+
+#[inline(never)]
+fn work_a() -> u64 {
+    black_box(burn(1 << 20)) + 1
+}
+
+#[inline(never)]
+fn work_b() -> u64 {
+    black_box(burn(1 << 21)) + 2
+}
+
+fn main() {
+    let mut sum: u64 = 0;
+    loop {
+        sum = black_box(sum.wrapping_add(work_a()));
+        sum = black_box(sum.wrapping_add(work_b()));
+    }
+}
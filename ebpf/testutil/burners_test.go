@@ -0,0 +1,29 @@
+package testutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchStack(t *testing.T) {
+	testcases := []struct {
+		pattern string
+		stack   string
+		match   bool
+	}{
+		{"app;main;burn", "app;main;burn", true},
+		{"app;main;burn", "app;main;burn;memset", false},
+		{"app;main;burn", "app;main", false},
+		{"app;*;main;burn", "app;main;burn", true},
+		{"app;*;main;burn", "app;_start;__libc_start_main;main;burn", true},
+		{"app;*;main;burn", "app;_start;main;work;burn", false},
+		{"app;*;bin;bin", "app;libc.so;bin;bin;bin", true},
+		{"app;*;bin;bin", "app;bin", false},
+		{"app;*", "app", true},
+		{"app;*", "python;main", false},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.match, MatchStack(tc.pattern, tc.stack), "%s %s", tc.pattern, tc.stack)
+	}
+}
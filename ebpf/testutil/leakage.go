@@ -0,0 +1,142 @@
+package testutil
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/pyroscope/ebpf/pprof"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+)
+
+// RunContainersWithPort runs the images concurrently, so that their
+// processes are profiled at the same time, and waits for their port.
+func RunContainersWithPort(t *testing.T, l log.Logger, port string, images ...string) []*Container {
+	containers := make([]*Container, len(images))
+	errs := make([]error, len(images))
+	wg := sync.WaitGroup{}
+	for i, image := range images {
+		containers[i] = &Container{
+			T:             t,
+			L:             log.With(l, "component", "docker", "image", image),
+			ContainerPort: port,
+		}
+		wg.Add(1)
+		go func(c *Container) {
+			defer wg.Done()
+			out, err := c.execute("docker", "run", "--rm", "-tid", "-p", port, image)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			c.ContainerID = strings.TrimSpace(string(out))
+		}(containers[i])
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			for _, c := range containers {
+				if c.ContainerID != "" {
+					c.Kill()
+				}
+			}
+			require.NoError(t, err, images[i])
+		}
+	}
+	for _, c := range containers {
+		c.WaitForPort()
+	}
+	return containers
+}
+
+// Pids returns the pids of the processes of the container, in the pid
+// namespace of the host.
+func (c *Container) Pids() []uint32 {
+	out, err := c.execute("docker", "top", c.ContainerID, "-o", "pid")
+	require.NoError(c.T, err)
+	var res []uint32
+	for _, line := range strings.Split(string(out), "\n")[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		pid, err := strconv.ParseUint(line, 10, 32)
+		require.NoError(c.T, err)
+		res = append(res, uint32(pid))
+	}
+	require.NotEmpty(c.T, res, "no processes in container %s", c.ContainerID)
+	return res
+}
+
+// Sample is a sample collected from a session, with the service name of the
+// target it is attributed to and its stack, root first.
+type Sample struct {
+	Target string
+	Pid    uint32
+	Stack  string
+}
+
+// CollectSamples collects the samples of the session.
+func CollectSamples(t *testing.T, collector pprof.SamplesCollector) []Sample {
+	var res []Sample
+	err := collector.CollectProfiles(func(ps pprof.ProfileSample) {
+		stack := slices.Clone(ps.Stack)
+		lo.Reverse(stack)
+		res = append(res, Sample{
+			Target: ps.Target.ServiceName(),
+			Pid:    ps.Pid,
+			Stack:  strings.Join(stack, ";"),
+		})
+	})
+	require.NoError(t, err)
+	return res
+}
+
+// Attribution is what the samples of a target are expected to come from.
+type Attribution struct {
+	// Pids of the processes of the target, usually the Pids of its
+	// container.
+	Pids []uint32
+	// Frames matches the frames only the workload of the target runs, for
+	// example the functions of its binary. Optional.
+	Frames *regexp.Regexp
+}
+
+// AssertNoCrossTargetLeakage checks that the samples of each target of
+// expected come from the pids of the target, and that their stacks have no
+// frames of the other targets. It guards against the attribution of the
+// samples to the wrong target and the symbol caches keyed by something
+// shared by the targets. Each target is required to have samples, so the
+// assertion does not pass on an empty profile.
+func AssertNoCrossTargetLeakage(t *testing.T, samples []Sample, expected map[string]Attribution) {
+	seen := make(map[string]bool, len(expected))
+	for _, s := range samples {
+		a, ok := expected[s.Target]
+		if !ok {
+			continue
+		}
+		seen[s.Target] = true
+		if len(a.Pids) > 0 {
+			require.Contains(t, a.Pids, s.Pid,
+				fmt.Sprintf("sample of pid %d attributed to target %s: %s", s.Pid, s.Target, s.Stack))
+		}
+		for other, oa := range expected {
+			if other == s.Target || oa.Frames == nil {
+				continue
+			}
+			for _, frame := range strings.Split(s.Stack, ";") {
+				require.False(t, oa.Frames.MatchString(frame),
+					fmt.Sprintf("frame %s of target %s in a sample of target %s: %s", frame, other, s.Target, s.Stack))
+			}
+		}
+	}
+	for target := range expected {
+		require.True(t, seen[target], fmt.Sprintf("no samples of target %s", target))
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"slices"
@@ -28,18 +29,36 @@ import (
 	"github.com/grafana/pyroscope/pkg/validation"
 )
 
+// UserHeader is the header identifying the user uploading a profile,
+// set by Grafana if the data source is configured to send the user header.
+const UserHeader = "X-Grafana-User"
+
+const cleanupInterval = time.Hour
+
+type Config struct {
+	RetentionPeriod time.Duration `yaml:"retention_period"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&cfg.RetentionPeriod, "adhoc-profiles.retention-period", 0, "How long the uploaded ad-hoc profiles are kept. 0 to keep them forever.")
+}
+
 type AdHocProfiles struct {
 	services.Service
 
 	logger log.Logger
 	limits frontend.Limits
 	bucket objstore.Bucket
+	cfg    Config
 }
 
+// AdHocProfile is the stored ad-hoc profile. The metadata fields precede
+// the profile data, so that they can be read without reading the data.
 type AdHocProfile struct {
 	Name       string    `json:"name"`
-	Data       string    `json:"data"`
+	UploadedBy string    `json:"uploadedBy,omitempty"`
 	UploadedAt time.Time `json:"uploadedAt"`
+	Data       string    `json:"data"`
 }
 
 func validRunes(r rune) bool {
@@ -69,19 +88,80 @@ func replaceInvalidRunes(id string) string {
 	}, id)
 }
 
-func NewAdHocProfiles(bucket objstore.Bucket, logger log.Logger, limits frontend.Limits) *AdHocProfiles {
+func NewAdHocProfiles(bucket objstore.Bucket, logger log.Logger, limits frontend.Limits, cfg Config) *AdHocProfiles {
 	a := &AdHocProfiles{
 		logger: logger,
 		bucket: bucket,
 		limits: limits,
+		cfg:    cfg,
 	}
 	a.Service = services.NewBasicService(nil, a.running, nil)
 	return a
 }
 
 func (a *AdHocProfiles) running(ctx context.Context) error {
-	<-ctx.Done()
-	return nil
+	if a.cfg.RetentionPeriod <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		a.deleteExpired(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// deleteExpired deletes the profiles of all tenants uploaded before the
+// retention period.
+func (a *AdHocProfiles) deleteExpired(ctx context.Context, now time.Time) {
+	deadline := now.Add(-a.cfg.RetentionPeriod)
+	err := a.bucket.Iter(ctx, "", func(dir string) error {
+		if !strings.HasSuffix(dir, "/") {
+			return nil
+		}
+		tenantID := strings.TrimSuffix(dir, "/")
+		bucket := a.getBucket(tenantID)
+		var expired []string
+		err := bucket.Iter(ctx, "", func(id string) error {
+			if uploadedAt, ok := uploadTime(id); ok && uploadedAt.Before(deadline) {
+				expired = append(expired, id)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err = bucket.Delete(ctx, id); err != nil && !bucket.IsObjNotFoundErr(err) {
+				level.Warn(a.logger).Log("msg", "failed to delete expired ad hoc profile", "tenant", tenantID, "id", id, "err", err)
+			}
+		}
+		if len(expired) > 0 {
+			level.Info(a.logger).Log("msg", "deleted expired ad hoc profiles", "tenant", tenantID, "count", len(expired))
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		level.Warn(a.logger).Log("msg", "failed to delete expired ad hoc profiles", "err", err)
+	}
+}
+
+// uploadTime returns the upload time encoded in the profile id.
+func uploadTime(id string) (time.Time, bool) {
+	separatorIndex := strings.IndexRune(id, '-')
+	if separatorIndex < 0 {
+		return time.Time{}, false
+	}
+	uid, err := ulid.Parse(id[0:separatorIndex])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ulid.Time(uid.Time()), true
 }
 
 func (a *AdHocProfiles) Upload(ctx context.Context, c *connect.Request[v1.AdHocProfilesUploadRequest]) (*connect.Response[v1.AdHocProfilesGetResponse], error) {
@@ -92,6 +172,7 @@ func (a *AdHocProfiles) Upload(ctx context.Context, c *connect.Request[v1.AdHocP
 
 	adHocProfile := AdHocProfile{
 		Name:       c.Msg.Name,
+		UploadedBy: c.Header().Get(UserHeader),
 		Data:       c.Msg.Profile,
 		UploadedAt: time.Now().UTC(),
 	}
@@ -147,28 +228,7 @@ func (a *AdHocProfiles) Get(ctx context.Context, c *connect.Request[v1.AdHocProf
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 
-	bucket := a.getBucket(tenantID)
-
-	id := c.Msg.GetId()
-	if !validID(id) {
-		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("id '%s' is invalid: can only contain [a-zA-Z0-9_-.]", id))
-	}
-
-	reader, err := bucket.Get(ctx, id)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to get profile")
-	}
-	defer func() {
-		_ = reader.Close()
-	}()
-
-	adHocProfileBytes, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, err
-	}
-
-	var adHocProfile AdHocProfile
-	err = json.Unmarshal(adHocProfileBytes, &adHocProfile)
+	adHocProfile, err := a.load(ctx, tenantID, c.Msg.GetId())
 	if err != nil {
 		return nil, err
 	}
@@ -178,7 +238,7 @@ func (a *AdHocProfiles) Get(ctx context.Context, c *connect.Request[v1.AdHocProf
 		return nil, errors.Wrapf(err, "could not determine max nodes")
 	}
 
-	profile, profileTypes, err := parse(&adHocProfile, c.Msg.ProfileType, maxNodes)
+	profile, profileTypes, err := parse(adHocProfile, c.Msg.ProfileType, maxNodes)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to parse profile")
 	}
@@ -198,6 +258,36 @@ func (a *AdHocProfiles) Get(ctx context.Context, c *connect.Request[v1.AdHocProf
 	}), nil
 }
 
+func (a *AdHocProfiles) load(ctx context.Context, tenantID, id string) (*AdHocProfile, error) {
+	if !validID(id) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("id '%s' is invalid: can only contain [a-zA-Z0-9_-.]", id))
+	}
+
+	bucket := a.getBucket(tenantID)
+	reader, err := bucket.Get(ctx, id)
+	if err != nil {
+		if bucket.IsObjNotFoundErr(err) {
+			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("profile '%s' not found", id))
+		}
+		return nil, errors.Wrapf(err, "failed to get profile")
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	adHocProfileBytes, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var adHocProfile AdHocProfile
+	err = json.Unmarshal(adHocProfileBytes, &adHocProfile)
+	if err != nil {
+		return nil, err
+	}
+	return &adHocProfile, nil
+}
+
 func (a *AdHocProfiles) List(ctx context.Context, c *connect.Request[v1.AdHocProfilesListRequest]) (*connect.Response[v1.AdHocProfilesListResponse], error) {
 	bucket, err := a.getBucketFromContext(ctx)
 	if err != nil {
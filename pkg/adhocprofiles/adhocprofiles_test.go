@@ -5,16 +5,21 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"connectrpc.com/connect"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/require"
 	thanosobjstore "github.com/thanos-io/objstore"
 
 	v1 "github.com/grafana/pyroscope/api/gen/proto/go/adhocprofiles/v1"
 	phlareobjstore "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/og/structs/flamebearer"
 	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/util"
 	"github.com/grafana/pyroscope/pkg/validation"
@@ -202,3 +207,142 @@ func TestAdHocProfiles_Upload(t *testing.T) {
 		})
 	}
 }
+
+func TestAdHocProfiles_DeleteExpired(t *testing.T) {
+	bucket := phlareobjstore.NewBucket(thanosobjstore.NewInMemBucket())
+	for _, key := range []string{
+		"tenant-a/adhoc/01HMXV8BF4EH71NBYZNPPVGJ2X-cpu.pprof",  // 2024-01-24T13:41:20Z
+		"tenant-a/adhoc/01HMXRV02963FK36GGRE9N6MPH-heap.pprof", // 2024-01-24T12:59:05Z
+		"tenant-b/adhoc/01HMXRV02963FK36GGRE9N6MPH-heap.pprof",
+		"tenant-b/adhoc/bad-id-should-be-ignored",
+	} {
+		require.NoError(t, bucket.Upload(context.Background(), key, bytes.NewReader([]byte{1})))
+	}
+	a := &AdHocProfiles{
+		logger: util.Logger,
+		bucket: bucket,
+		cfg:    Config{RetentionPeriod: time.Hour},
+	}
+	a.deleteExpired(context.Background(), time.Date(2024, 1, 24, 14, 30, 0, 0, time.UTC))
+
+	var remaining []string
+	require.NoError(t, bucket.Iter(context.Background(), "", func(name string) error {
+		remaining = append(remaining, name)
+		return nil
+	}, thanosobjstore.WithRecursiveIter()))
+	require.ElementsMatch(t, []string{
+		"tenant-a/adhoc/01HMXV8BF4EH71NBYZNPPVGJ2X-cpu.pprof",
+		"tenant-b/adhoc/bad-id-should-be-ignored",
+	}, remaining)
+}
+
+func TestAdHocProfiles_ListHandler(t *testing.T) {
+	bucket := phlareobjstore.NewBucket(thanosobjstore.NewInMemBucket())
+	for id, p := range map[string]AdHocProfile{
+		"01HMXV8BF4EH71NBYZNPPVGJ2X-cpu.pprof":  {Name: "cpu.pprof", UploadedBy: "alice", Data: "AAAA"},
+		"01HMXRV02963FK36GGRE9N6MPH-heap.pprof": {Name: "heap.pprof", UploadedBy: "bob", Data: "AAAA"},
+	} {
+		b, err := json.Marshal(p)
+		require.NoError(t, err)
+		require.NoError(t, bucket.Upload(context.Background(), "tenant/adhoc/"+id, bytes.NewReader(b)))
+	}
+	a := &AdHocProfiles{
+		logger: util.Logger,
+		bucket: bucket,
+		cfg:    Config{RetentionPeriod: time.Hour},
+	}
+
+	list := func(query string) listResponse {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/adhoc-profiles"+query, nil)
+		req = req.WithContext(tenant.InjectTenantID(req.Context(), "tenant"))
+		rec := httptest.NewRecorder()
+		a.ListHandler(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var res listResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+
+	require.Equal(t, []ProfileMetadata{
+		{
+			ID:         "01HMXV8BF4EH71NBYZNPPVGJ2X-cpu.pprof",
+			Name:       "cpu.pprof",
+			UploadedBy: "alice",
+			UploadedAt: 1706103680484,
+			ExpiresAt:  1706107280484,
+		},
+		{
+			ID:         "01HMXRV02963FK36GGRE9N6MPH-heap.pprof",
+			Name:       "heap.pprof",
+			UploadedBy: "bob",
+			UploadedAt: 1706101145673,
+			ExpiresAt:  1706104745673,
+		},
+	}, list("").Profiles)
+
+	profiles := list("?user=bob").Profiles
+	require.Len(t, profiles, 1)
+	require.Equal(t, "heap.pprof", profiles[0].Name)
+}
+
+func TestAdHocProfiles_DeleteHandler(t *testing.T) {
+	bucket := phlareobjstore.NewBucket(thanosobjstore.NewInMemBucket())
+	require.NoError(t, bucket.Upload(context.Background(), "tenant/adhoc/01HMXV8BF4EH71NBYZNPPVGJ2X-cpu.pprof", bytes.NewReader([]byte{1})))
+	a := &AdHocProfiles{
+		logger: util.Logger,
+		bucket: bucket,
+	}
+
+	del := func(id string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/adhoc-profiles/"+id, nil)
+		req = mux.SetURLVars(req.WithContext(tenant.InjectTenantID(req.Context(), "tenant")), map[string]string{"id": id})
+		rec := httptest.NewRecorder()
+		a.DeleteHandler(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, del("01HMXV8BF4EH71NBYZNPPVGJ2X-cpu.pprof"))
+	exists, err := bucket.Exists(context.Background(), "tenant/adhoc/01HMXV8BF4EH71NBYZNPPVGJ2X-cpu.pprof")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.Equal(t, http.StatusNotFound, del("01HMXV8BF4EH71NBYZNPPVGJ2X-cpu.pprof"))
+	require.Equal(t, http.StatusBadRequest, del("..%2Fother-tenant"))
+}
+
+func TestAdHocProfiles_DiffHandler(t *testing.T) {
+	bucket := phlareobjstore.NewBucket(thanosobjstore.NewInMemBucket())
+	rawProfile, err := os.ReadFile("testdata/cpu.pprof")
+	require.NoError(t, err)
+	b, err := json.Marshal(AdHocProfile{
+		Name: "cpu.pprof",
+		Data: base64.StdEncoding.EncodeToString(rawProfile),
+	})
+	require.NoError(t, err)
+	for _, id := range []string{"01HMXV8BF4EH71NBYZNPPVGJ2X-cpu.pprof", "01HMXRV02963FK36GGRE9N6MPH-cpu.pprof"} {
+		require.NoError(t, bucket.Upload(context.Background(), "tenant/adhoc/"+id, bytes.NewReader(b)))
+	}
+	a := &AdHocProfiles{
+		logger: util.Logger,
+		limits: validation.MockLimits{MaxFlameGraphNodesDefaultValue: 8192},
+		bucket: bucket,
+	}
+
+	diff := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/adhoc-profiles/diff"+query, nil)
+		req = req.WithContext(tenant.InjectTenantID(req.Context(), "tenant"))
+		rec := httptest.NewRecorder()
+		a.DiffHandler(rec, req)
+		return rec
+	}
+
+	rec := diff("?left=01HMXV8BF4EH71NBYZNPPVGJ2X-cpu.pprof&right=01HMXRV02963FK36GGRE9N6MPH-cpu.pprof")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var fb flamebearer.FlamebearerProfile
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&fb))
+	require.Equal(t, "double", fb.Metadata.Format)
+	require.NotZero(t, fb.LeftTicks)
+	require.Equal(t, fb.LeftTicks, fb.RightTicks)
+
+	require.Equal(t, http.StatusBadRequest, diff("?left=01HMXV8BF4EH71NBYZNPPVGJ2X-cpu.pprof").Code)
+	require.Equal(t, http.StatusNotFound, diff("?left=01HMXV8BF4EH71NBYZNPPVGJ2X-cpu.pprof&right=missing").Code)
+}
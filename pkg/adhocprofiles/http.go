@@ -0,0 +1,249 @@
+package adhocprofiles
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log/level"
+	"github.com/gorilla/mux"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/structs/flamebearer"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+// metadataReadSize is the size of the beginning of the stored profile read
+// to get its metadata.
+const metadataReadSize = 4 << 10
+
+// ProfileMetadata describes a stored ad-hoc profile.
+type ProfileMetadata struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	UploadedBy string `json:"uploadedBy,omitempty"`
+	// UploadedAt and ExpiresAt are Unix timestamps in milliseconds.
+	// ExpiresAt is not set, if the profiles are kept forever.
+	UploadedAt int64 `json:"uploadedAt"`
+	ExpiresAt  int64 `json:"expiresAt,omitempty"`
+}
+
+type listResponse struct {
+	Profiles []ProfileMetadata `json:"profiles"`
+}
+
+// ListHandler lists the ad-hoc profiles of the tenant, the most recent
+// first, for example, GET /api/v1/adhoc-profiles?user=admin. The optional
+// user parameter only lists the profiles uploaded by the user.
+func (a *AdHocProfiles) ListHandler(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := tenant.ExtractTenantIDFromContext(r.Context())
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeUnauthenticated, err))
+		return
+	}
+	profiles, err := a.listMetadata(r.Context(), tenantID, r.URL.Query().Get("user"))
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(listResponse{Profiles: profiles}); err != nil {
+		httputil.Error(w, err)
+	}
+}
+
+func (a *AdHocProfiles) listMetadata(ctx context.Context, tenantID, user string) ([]ProfileMetadata, error) {
+	bucket := a.getBucket(tenantID)
+	profiles := make([]ProfileMetadata, 0)
+	err := bucket.Iter(ctx, "", func(id string) error {
+		if !validID(id) {
+			return nil
+		}
+		uploadedAt, ok := uploadTime(id)
+		if !ok {
+			return nil
+		}
+		p := ProfileMetadata{
+			ID:         id,
+			Name:       id[strings.IndexRune(id, '-')+1:],
+			UploadedAt: uploadedAt.UnixMilli(),
+		}
+		if a.cfg.RetentionPeriod > 0 {
+			p.ExpiresAt = uploadedAt.Add(a.cfg.RetentionPeriod).UnixMilli()
+		}
+		reader, err := bucket.GetRange(ctx, id, 0, metadataReadSize)
+		if err != nil {
+			if bucket.IsObjNotFoundErr(err) {
+				return nil
+			}
+			return err
+		}
+		p.UploadedBy = readUploader(reader)
+		_ = reader.Close()
+		if user != "" && p.UploadedBy != user {
+			return nil
+		}
+		profiles = append(profiles, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(profiles, func(a, b ProfileMetadata) int {
+		return cmp.Compare(b.UploadedAt, a.UploadedAt)
+	})
+	return profiles, nil
+}
+
+// readUploader returns the uploadedBy field of the stored profile, without
+// decoding the profile data.
+func readUploader(r io.Reader) string {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return ""
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+		switch t {
+		case "data":
+			return ""
+		case "uploadedBy":
+			var user string
+			_ = dec.Decode(&user)
+			return user
+		}
+		var skip json.RawMessage
+		if err = dec.Decode(&skip); err != nil {
+			return ""
+		}
+	}
+	return ""
+}
+
+// DeleteHandler deletes an ad-hoc profile of the tenant, for example,
+// DELETE /api/v1/adhoc-profiles/01HZ5Y7T3E6W2CS8J0VQ4N9KGR-cpu.pprof.
+func (a *AdHocProfiles) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := tenant.ExtractTenantIDFromContext(r.Context())
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeUnauthenticated, err))
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if id == "" || !validID(id) {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, errors.New("id is invalid: can only contain [a-zA-Z0-9_-.]")))
+		return
+	}
+	bucket := a.getBucket(tenantID)
+	if err = bucket.Delete(r.Context(), id); err != nil {
+		if bucket.IsObjNotFoundErr(err) {
+			err = connect.NewError(connect.CodeNotFound, err)
+		}
+		httputil.Error(w, err)
+		return
+	}
+	level.Info(a.logger).Log("msg", "ad hoc profile deleted", "tenant", tenantID, "id", id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// DiffHandler returns the diff flame graph of two ad-hoc profiles of the
+// tenant, in the flamebearer format, for example,
+// GET /api/v1/adhoc-profiles/diff?left=<id>&right=<id>&profile_type=cpu.
+// The profile_type and max_nodes parameters are optional.
+func (a *AdHocProfiles) DiffHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeUnauthenticated, err))
+		return
+	}
+	params := r.URL.Query()
+	var profileType *string
+	if v := params.Get("profile_type"); v != "" {
+		profileType = &v
+	}
+	var maxNodes int64
+	if v := params.Get("max_nodes"); v != "" {
+		if maxNodes, err = strconv.ParseInt(v, 10, 64); err != nil {
+			httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, errors.New("'max_nodes' must be a number")))
+			return
+		}
+	}
+	if maxNodes, err = validation.ValidateMaxNodes(a.limits, []string{tenantID}, maxNodes); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+
+	var sides [2]*flamebearer.FlamebearerProfile
+	for i, id := range []string{params.Get("left"), params.Get("right")} {
+		if id == "" {
+			httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, errors.New("'left' and 'right' are required")))
+			return
+		}
+		adHocProfile, err := a.load(ctx, tenantID, id)
+		if err != nil {
+			httputil.Error(w, err)
+			return
+		}
+		if sides[i], _, err = parse(adHocProfile, profileType, maxNodes); err != nil {
+			httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+			return
+		}
+	}
+
+	fb, err := diff(sides[0], sides[1], maxNodes)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(fb); err != nil {
+		httputil.Error(w, err)
+	}
+}
+
+func diff(left, right *flamebearer.FlamebearerProfile, maxNodes int64) (*flamebearer.FlamebearerProfile, error) {
+	leftTree, err := toTree(left)
+	if err != nil {
+		return nil, err
+	}
+	rightTree, err := toTree(right)
+	if err != nil {
+		return nil, err
+	}
+	fg, err := phlaremodel.NewFlamegraphDiff(leftTree, rightTree, maxNodes)
+	if err != nil {
+		return nil, err
+	}
+	fb := phlaremodel.ExportDiffToFlamebearer(fg, &typesv1.ProfileType{SampleType: left.Metadata.Name})
+	fb.Metadata.Units = left.Metadata.Units
+	fb.Metadata.SampleRate = left.Metadata.SampleRate
+	return fb, nil
+}
+
+func toTree(p *flamebearer.FlamebearerProfile) (*phlaremodel.Tree, error) {
+	t, err := flamebearer.ProfileToTree(*p)
+	if err != nil {
+		return nil, err
+	}
+	var res phlaremodel.Tree
+	t.IterateStacks(func(_ string, self uint64, stack []string) {
+		// The stack is ordered from the leaf to the root.
+		stack = slices.Clone(stack)
+		slices.Reverse(stack)
+		res.InsertStack(int64(self), stack...)
+	})
+	return &res, nil
+}
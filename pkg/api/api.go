@@ -25,6 +25,7 @@ import (
 	"github.com/grafana/pyroscope/api/gen/proto/go/adhocprofiles/v1/adhocprofilesv1connect"
 	"github.com/grafana/pyroscope/api/gen/proto/go/capabilities/v1/capabilitiesv1connect"
 	"github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1/ingesterv1connect"
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
 	"github.com/grafana/pyroscope/api/gen/proto/go/push/v1/pushv1connect"
 	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
 	"github.com/grafana/pyroscope/api/gen/proto/go/settings/v1/settingsv1connect"
@@ -36,6 +37,8 @@ import (
 	"github.com/grafana/pyroscope/pkg/adhocprofiles"
 	"github.com/grafana/pyroscope/pkg/compactor"
 	"github.com/grafana/pyroscope/pkg/distributor"
+	"github.com/grafana/pyroscope/pkg/distributor/livetail"
+	"github.com/grafana/pyroscope/pkg/experiment/symbolizer"
 	"github.com/grafana/pyroscope/pkg/frontend"
 	"github.com/grafana/pyroscope/pkg/frontend/frontendpb/frontendpbconnect"
 	"github.com/grafana/pyroscope/pkg/ingester"
@@ -43,10 +46,13 @@ import (
 	"github.com/grafana/pyroscope/pkg/ingester/pyroscope"
 	"github.com/grafana/pyroscope/pkg/operations"
 	"github.com/grafana/pyroscope/pkg/querier"
+	"github.com/grafana/pyroscope/pkg/querier/partial"
 	"github.com/grafana/pyroscope/pkg/scheduler"
 	"github.com/grafana/pyroscope/pkg/scheduler/schedulerpb/schedulerpbconnect"
 	"github.com/grafana/pyroscope/pkg/settings"
 	"github.com/grafana/pyroscope/pkg/storegateway"
+	"github.com/grafana/pyroscope/pkg/util/apitoken"
+	"github.com/grafana/pyroscope/pkg/util/auditlog"
 	"github.com/grafana/pyroscope/pkg/util/delayhandler"
 	"github.com/grafana/pyroscope/pkg/validation/exporter"
 )
@@ -56,6 +62,9 @@ type Config struct {
 	HTTPAuthMiddleware middleware.Interface `yaml:"-"`
 	GrpcAuthMiddleware connect.Option       `yaml:"-"`
 	BaseURL            string               `yaml:"base-url"`
+
+	QueryAuditLog auditlog.Config `yaml:"query_audit_log"`
+	Tokens        apitoken.Config `yaml:"tokens"`
 }
 
 type API struct {
@@ -63,12 +72,22 @@ type API struct {
 	httpAuthMiddleware middleware.Interface
 	grpcGatewayMux     *grpcgw.ServeMux
 
-	cfg       Config
-	logger    log.Logger
-	indexPage *IndexPageContent
+	cfg         Config
+	logger      log.Logger
+	indexPage   *IndexPageContent
+	auditLogger *auditlog.Logger
+	tokens      *apitoken.Authenticator
 }
 
 func New(cfg Config, s *server.Server, grpcGatewayMux *grpcgw.ServeMux, logger log.Logger) (*API, error) {
+	auditLogger, err := auditlog.New(cfg.QueryAuditLog, logger)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := apitoken.New(cfg.Tokens, logger)
+	if err != nil {
+		return nil, err
+	}
 	api := &API{
 		cfg:                cfg,
 		httpAuthMiddleware: cfg.HTTPAuthMiddleware,
@@ -76,6 +95,8 @@ func New(cfg Config, s *server.Server, grpcGatewayMux *grpcgw.ServeMux, logger l
 		logger:             logger,
 		indexPage:          NewIndexPageContent(),
 		grpcGatewayMux:     grpcGatewayMux,
+		auditLogger:        auditLogger,
+		tokens:             tokens,
 	}
 
 	// If no authentication middleware is present in the config, use the default authentication middleware.
@@ -169,7 +190,7 @@ func (a *API) RegisterRuntimeConfig(runtimeConfigHandler http.HandlerFunc, userL
 }
 
 func (a *API) RegisterTenantSettings(ts *settings.TenantSettings) {
-	connectOptions := a.connectOptionsAuthRecovery()
+	connectOptions := a.connectOptionsTokenAuthRecovery(apitoken.ScopeAdmin)
 	settingsv1connect.RegisterSettingsServiceHandler(a.server.HTTP, ts, connectOptions...)
 
 	_, isUnimplemented := ts.CollectionRulesServiceHandler.(*settingsv1connect.UnimplementedCollectionRulesServiceHandler)
@@ -181,6 +202,18 @@ func (a *API) RegisterTenantSettings(ts *settings.TenantSettings) {
 	if !isUnimplemented {
 		settingsv1connect.RegisterRecordingRulesServiceHandler(a.server.HTTP, ts, connectOptions...)
 	}
+
+	if ts.Annotations != nil {
+		// The annotations are listed with a query token, and created by the
+		// deploy pipelines with an ingest token.
+		a.RegisterRoute("/settings/v1/annotations", ts.Annotations, a.registerOptionsReadPath()...)
+		a.RegisterRoute("/settings/v1/annotations", ts.Annotations,
+			a.WithTokenMiddleware(apitoken.ScopeIngest),
+			a.WithAuthMiddleware(),
+			WithMethod("POST"),
+			WithMethod("DELETE"),
+		)
+	}
 }
 
 // RegisterOverridesExporter registers the endpoints associated with the overrides exporter.
@@ -197,10 +230,32 @@ func (a *API) RegisterDistributor(d *distributor.Distributor, limits delayhandle
 	pyroscopeHandler := pyroscope.NewPyroscopeIngestHandler(d, a.logger)
 	otlpHandler := otlp.NewOTLPIngestHandler(d, a.logger, multitenancyEnabled)
 
+	pushOpts := a.connectOptionsAuthDelayRecovery(limits)
+	if c := d.Idempotency(); c != nil {
+		// The OTLP requests are not deduplicated: their gRPC responses
+		// can not be written by the HTTP handler.
+		pyroscopeHandler = c.Router(c.Handler(pyroscopeHandler))
+		pushOpts = append(pushOpts, connect.WithInterceptors(c.UnaryInterceptor(func() connect.AnyResponse {
+			return connect.NewResponse(&pushv1.PushResponse{})
+		})))
+		// The push requests are routed before the interceptors run.
+		_, pushHandler := pushv1connect.NewPusherServiceHandler(d, pushOpts...)
+		a.server.HTTP.Handle(pushv1connect.PusherServicePushProcedure, c.Router(pushHandler))
+	} else {
+		pushv1connect.RegisterPusherServiceHandler(a.server.HTTP, d, pushOpts...)
+	}
+
 	a.RegisterRoute("/ingest", pyroscopeHandler, writePathOpts...)
 	a.RegisterRoute("/pyroscope/ingest", pyroscopeHandler, writePathOpts...)
-	pushv1connect.RegisterPusherServiceHandler(a.server.HTTP, d, a.connectOptionsAuthDelayRecovery(limits)...)
 	a.RegisterRoute("/distributor/ring", d, a.registerOptionsRingPage()...)
+	if h := d.LiveTailHandler(); h != nil {
+		// The stream is not compressed: the gzip middleware buffers the events.
+		a.RegisterRoute(livetail.Path, h,
+			a.WithTokenMiddleware(apitoken.ScopeQuery),
+			a.WithAuthMiddleware(),
+			WithMethod("GET"),
+		)
+	}
 	a.indexPage.AddLinks(defaultWeight, "Distributor", []IndexPageLink{
 		{Desc: "Ring status", Path: "/distributor/ring"},
 	})
@@ -227,22 +282,43 @@ func (a *API) RegisterIngesterRing(r http.Handler) {
 }
 
 func (a *API) RegisterQuerierServiceHandler(svc querierv1connect.QuerierServiceHandler) {
-	querierv1connect.RegisterQuerierServiceHandler(a.server.HTTP, svc, a.connectOptionsAuthLogRecovery()...)
+	opts := append(a.connectOptionsAuthLogAuditRecovery(), connect.WithInterceptors(partial.UnaryInterceptor()))
+	querierv1connect.RegisterQuerierServiceHandler(a.server.HTTP, svc, opts...)
 }
 
 func (a *API) RegisterVCSServiceHandler(svc vcsv1connect.VCSServiceHandler) {
 	vcsv1connect.RegisterVCSServiceHandler(a.server.HTTP, svc, a.connectOptionsAuthLogRecovery()...)
 }
 
+func (a *API) RegisterSourceSnippets(h http.Handler) {
+	a.RegisterRoute("/vcs/v1/source-snippet", h, a.registerOptionsReadPath()...)
+}
+
 func (a *API) RegisterFeatureFlagsServiceHandler(svc capabilitiesv1connect.FeatureFlagsServiceHandler) {
 	capabilitiesv1connect.RegisterFeatureFlagsServiceHandler(a.server.HTTP, svc, a.connectOptionsAuthLogRecovery()...)
 }
 
-func (a *API) RegisterPyroscopeHandlers(client querierv1connect.QuerierServiceClient) {
-	handlers := querier.NewHTTPHandlers(client)
-	a.RegisterRoute("/pyroscope/render", http.HandlerFunc(handlers.Render), a.registerOptionsReadPath()...)
-	a.RegisterRoute("/pyroscope/render-diff", http.HandlerFunc(handlers.RenderDiff), a.registerOptionsReadPath()...)
-	a.RegisterRoute("/pyroscope/label-values", http.HandlerFunc(handlers.LabelValues), a.registerOptionsReadPath()...)
+func (a *API) RegisterPyroscopeHandlers(client querierv1connect.QuerierServiceClient, annotations querier.AnnotationLister) {
+	handlers := querier.NewHTTPHandlers(client, annotations)
+	a.RegisterRoute("/pyroscope/render", partial.Middleware(http.HandlerFunc(handlers.Render)), a.registerOptionsQueryPath()...)
+	a.RegisterRoute("/pyroscope/render-multi", partial.Middleware(http.HandlerFunc(handlers.RenderMulti)), a.registerOptionsQueryPath()...)
+	a.RegisterRoute("/pyroscope/render-diff", partial.Middleware(http.HandlerFunc(handlers.RenderDiff)), a.registerOptionsQueryPath()...)
+	a.RegisterRoute("/pyroscope/export", partial.Middleware(http.HandlerFunc(handlers.Export)), a.registerOptionsQueryPath()...)
+	a.RegisterRoute("/pyroscope/top-functions", partial.Middleware(http.HandlerFunc(handlers.TopFunctions)), a.registerOptionsQueryPath()...)
+	a.RegisterRoute("/pyroscope/stacktrace-search", partial.Middleware(http.HandlerFunc(handlers.StacktraceSearch)), a.registerOptionsQueryPath()...)
+	a.RegisterRoute("/pyroscope/span-profile", partial.Middleware(http.HandlerFunc(handlers.SpanProfile)), a.registerOptionsQueryPath()...)
+	a.RegisterRoute("/pyroscope/label-values", partial.Middleware(http.HandlerFunc(handlers.LabelValues)), a.registerOptionsQueryPath()...)
+	// The cardinality of the labels is an admin report.
+	a.RegisterRoute("/pyroscope/label-cardinality", partial.Middleware(http.HandlerFunc(handlers.LabelCardinality)),
+		a.WithTokenMiddleware(apitoken.ScopeAdmin),
+		a.WithAuthMiddleware(),
+		WithGzipMiddleware(),
+		a.WithAuditMiddleware(),
+		WithMethod("GET"),
+	)
+	a.RegisterRoute("/pyroscope/usage", partial.Middleware(http.HandlerFunc(handlers.Usage)), a.registerOptionsQueryPath()...)
+	a.RegisterRoute("/pyroscope/heatmap", partial.Middleware(http.HandlerFunc(handlers.Heatmap)), a.registerOptionsQueryPath()...)
+	a.RegisterRoute("/pyroscope/service-catalog", partial.Middleware(http.HandlerFunc(handlers.ServiceCatalog)), a.registerOptionsQueryPath()...)
 }
 
 // RegisterIngester registers the endpoints associated with the ingester.
@@ -272,6 +348,10 @@ func (a *API) RegisterCompactor(c *compactor.MultitenantCompactor) {
 		{Desc: "Ring status", Path: "/compactor/ring"},
 	})
 	a.RegisterRoute("/compactor/ring", http.HandlerFunc(c.RingHandler), a.registerOptionsRingPage()...)
+	a.RegisterRoute("/compactor/delete_tenant", http.HandlerFunc(c.DeleteTenant), a.WithTokenMiddleware(apitoken.ScopeAdmin), a.WithAuthMiddleware(), WithMethod("POST"))
+	a.RegisterRoute("/compactor/delete_tenant_status", http.HandlerFunc(c.DeleteTenantStatus), a.WithTokenMiddleware(apitoken.ScopeAdmin), a.WithAuthMiddleware(), WithMethod("GET"))
+	// The migration writes to another tenant than the one of the request.
+	a.RegisterRoute("/compactor/migrate_tenant", http.HandlerFunc(c.MigrateTenant), a.WithOperatorTokenMiddleware(), a.WithAuthMiddleware(), WithMethod("POST"))
 }
 
 // RegisterFrontendForQuerierHandler registers the endpoints associated with the query frontend.
@@ -298,6 +378,8 @@ func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
 		"",
 		"base URL for when the server is behind a reverse proxy with a different path",
 	)
+	cfg.QueryAuditLog.RegisterFlags(fs)
+	cfg.Tokens.RegisterFlags(fs)
 }
 
 func (a *API) RegisterAdmin(ad *operations.Admin) {
@@ -310,6 +392,13 @@ func (a *API) RegisterAdmin(ad *operations.Admin) {
 	})
 }
 
+func (a *API) RegisterSymbolizer(s *symbolizer.Symbolizer) {
+	a.RegisterRoute("/api/v1/symbols/{build_id}", http.HandlerFunc(s.UploadHandler), a.WithTokenMiddleware(apitoken.ScopeIngest), a.WithAuthMiddleware(), WithMethod("POST"))
+}
+
 func (a *API) RegisterAdHocProfiles(ahp *adhocprofiles.AdHocProfiles) {
-	adhocprofilesv1connect.RegisterAdHocProfileServiceHandler(a.server.HTTP, ahp, a.connectOptionsAuthRecovery()...)
+	adhocprofilesv1connect.RegisterAdHocProfileServiceHandler(a.server.HTTP, ahp, a.connectOptionsTokenAuthRecovery(apitoken.ScopeQuery)...)
+	a.RegisterRoute("/api/v1/adhoc-profiles", http.HandlerFunc(ahp.ListHandler), a.registerOptionsReadPath()...)
+	a.RegisterRoute("/api/v1/adhoc-profiles/diff", http.HandlerFunc(ahp.DiffHandler), a.registerOptionsReadPath()...)
+	a.RegisterRoute("/api/v1/adhoc-profiles/{id}", http.HandlerFunc(ahp.DeleteHandler), a.WithTokenMiddleware(apitoken.ScopeQuery), a.WithAuthMiddleware(), WithMethod("DELETE"))
 }
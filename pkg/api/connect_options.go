@@ -5,6 +5,8 @@ import (
 
 	connectapi "github.com/grafana/pyroscope/pkg/api/connect"
 	"github.com/grafana/pyroscope/pkg/util"
+	"github.com/grafana/pyroscope/pkg/util/apitoken"
+	"github.com/grafana/pyroscope/pkg/util/auditlog"
 	"github.com/grafana/pyroscope/pkg/util/delayhandler"
 )
 
@@ -16,10 +18,21 @@ func (a *API) connectInterceptorAuth() connect.HandlerOption {
 	return a.cfg.GrpcAuthMiddleware
 }
 
+func (a *API) connectInterceptorToken(scope apitoken.Scope) connect.HandlerOption {
+	if a.tokens == nil {
+		return connect.WithInterceptors()
+	}
+	return connect.WithInterceptors(a.tokens.NewConnect(scope))
+}
+
 func (a *API) connectInterceptorLog() connect.HandlerOption {
 	return connect.WithInterceptors(util.NewLogInterceptor(a.logger))
 }
 
+func (a *API) connectInterceptorAudit() connect.HandlerOption {
+	return connect.WithInterceptors(auditlog.NewConnect(a.auditLogger))
+}
+
 func (a *API) connectInterceptorDelay(limits delayhandler.Limits) connect.HandlerOption {
 	return connect.WithInterceptors(delayhandler.NewConnect(limits))
 }
@@ -32,10 +45,21 @@ func (a *API) connectOptionsAuthRecovery() []connect.HandlerOption {
 	return append(connectapi.DefaultHandlerOptions(), a.connectInterceptorAuth(), connectInterceptorRecovery())
 }
 
+func (a *API) connectOptionsTokenAuthRecovery(scope apitoken.Scope) []connect.HandlerOption {
+	return append(connectapi.DefaultHandlerOptions(), a.connectInterceptorToken(scope), a.connectInterceptorAuth(), connectInterceptorRecovery())
+}
+
 func (a *API) connectOptionsAuthDelayRecovery(limits delayhandler.Limits) []connect.HandlerOption {
-	return append(connectapi.DefaultHandlerOptions(), a.connectInterceptorAuth(), a.connectInterceptorDelay(limits), connectInterceptorRecovery())
+	return append(connectapi.DefaultHandlerOptions(), a.connectInterceptorToken(apitoken.ScopeIngest), a.connectInterceptorAuth(), a.connectInterceptorDelay(limits), connectInterceptorRecovery())
 }
 
 func (a *API) connectOptionsAuthLogRecovery() []connect.HandlerOption {
-	return append(connectapi.DefaultHandlerOptions(), a.connectInterceptorAuth(), a.connectInterceptorLog(), connectInterceptorRecovery())
+	return append(connectapi.DefaultHandlerOptions(), a.connectInterceptorToken(apitoken.ScopeQuery), a.connectInterceptorAuth(), a.connectInterceptorLog(), connectInterceptorRecovery())
+}
+
+func (a *API) connectOptionsAuthLogAuditRecovery() []connect.HandlerOption {
+	if a.auditLogger == nil {
+		return a.connectOptionsAuthLogRecovery()
+	}
+	return append(connectapi.DefaultHandlerOptions(), a.connectInterceptorToken(apitoken.ScopeQuery), a.connectInterceptorAuth(), a.connectInterceptorLog(), a.connectInterceptorAudit(), connectInterceptorRecovery())
 }
@@ -9,6 +9,8 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/grafana/dskit/middleware"
 
+	"github.com/grafana/pyroscope/pkg/util/apitoken"
+	"github.com/grafana/pyroscope/pkg/util/auditlog"
 	"github.com/grafana/pyroscope/pkg/util/delayhandler"
 	"github.com/grafana/pyroscope/pkg/util/gziphandler"
 )
@@ -27,6 +29,7 @@ type registerParams struct {
 func (r *registerParams) logFields(path string) []interface{} {
 	gzip := false
 	auth := false
+	token := false
 	for _, m := range r.middlewares {
 		if m.name == "gzip" {
 			gzip = true
@@ -34,6 +37,9 @@ func (r *registerParams) logFields(path string) []interface{} {
 		if m.name == "auth" {
 			auth = true
 		}
+		if m.name == "token" {
+			token = true
+		}
 	}
 	methods := strings.Join(r.methods, ",")
 	if len(r.methods) == 0 {
@@ -49,6 +55,7 @@ func (r *registerParams) logFields(path string) []interface{} {
 		"methods", methods,
 		pathField, path,
 		"auth", auth,
+		"token", token,
 		"gzip", gzip,
 	}
 }
@@ -81,12 +88,46 @@ func (a *API) WithAuthMiddleware() RegisterOption {
 	}
 }
 
+// WithTokenMiddleware requires an API token allowing the scope, if the
+// tokens are enabled. It must come before the auth middleware, which
+// extracts the tenant set by the token.
+func (a *API) WithTokenMiddleware(scope apitoken.Scope) RegisterOption {
+	return func(r *registerParams) {
+		if a.tokens != nil {
+			r.middlewares = append(r.middlewares, registerMiddleware{a.tokens.NewHTTP(scope), "token"})
+		}
+	}
+}
+
+// WithOperatorTokenMiddleware requires a cluster-level admin token: the
+// tokens of a tenant are not allowed. All the requests are rejected if the
+// tokens are disabled.
+func (a *API) WithOperatorTokenMiddleware() RegisterOption {
+	return func(r *registerParams) {
+		if a.tokens != nil {
+			r.middlewares = append(r.middlewares, registerMiddleware{a.tokens.NewHTTPOperator(), "token"})
+		} else {
+			r.middlewares = append(r.middlewares, registerMiddleware{apitoken.NewHTTPNoOperator(), "token"})
+		}
+	}
+}
+
 func WithGzipMiddleware() RegisterOption {
 	return func(r *registerParams) {
 		r.middlewares = append(r.middlewares, registerMiddleware{middleware.Func(gziphandler.GzipHandler), "gzip"})
 	}
 }
 
+// WithAuditMiddleware logs the queries to the audit log, if enabled. It
+// must come after the auth middleware, which sets the tenant.
+func (a *API) WithAuditMiddleware() RegisterOption {
+	return func(r *registerParams) {
+		if a.auditLogger != nil {
+			r.middlewares = append(r.middlewares, registerMiddleware{auditlog.NewHTTP(a.auditLogger), "audit"})
+		}
+	}
+}
+
 func (a *API) WithArtificialDelayMiddleware(limits delayhandler.Limits) RegisterOption {
 	return func(r *registerParams) {
 		r.middlewares = append(r.middlewares, registerMiddleware{middleware.Func(delayhandler.NewHTTP(limits)), "artificial_delay"})
@@ -95,6 +136,7 @@ func (a *API) WithArtificialDelayMiddleware(limits delayhandler.Limits) Register
 
 func (a *API) registerOptionsTenantPath() []RegisterOption {
 	return []RegisterOption{
+		a.WithTokenMiddleware(apitoken.ScopeQuery),
 		a.WithAuthMiddleware(),
 		WithGzipMiddleware(),
 		WithMethod("GET"),
@@ -105,8 +147,15 @@ func (a *API) registerOptionsReadPath() []RegisterOption {
 	return a.registerOptionsTenantPath()
 }
 
+// registerOptionsQueryPath is the read path of the profile queries, which
+// are logged to the audit log.
+func (a *API) registerOptionsQueryPath() []RegisterOption {
+	return append(a.registerOptionsReadPath(), a.WithAuditMiddleware())
+}
+
 func (a *API) registerOptionsWritePath(limits delayhandler.Limits) []RegisterOption {
 	return []RegisterOption{
+		a.WithTokenMiddleware(apitoken.ScopeIngest),
 		a.WithAuthMiddleware(),
 		a.WithArtificialDelayMiddleware(limits), // This middleware relies on the auth middleware, to determine the user's override
 		WithGzipMiddleware(),
@@ -68,6 +68,7 @@ type BlocksCleaner struct {
 	blocksMarkedForDeletion        prometheus.Counter
 	partialBlocksMarkedForDeletion prometheus.Counter
 	tenantBlocks                   *prometheus.GaugeVec
+	tenantBlocksSize               *prometheus.GaugeVec
 	tenantMarkedBlocks             *prometheus.GaugeVec
 	tenantPartialBlocks            *prometheus.GaugeVec
 	tenantBucketIndexLastUpdate    *prometheus.GaugeVec
@@ -124,6 +125,10 @@ func NewBlocksCleaner(cfg BlocksCleanerConfig, bucketClient objstore.Bucket, own
 			Name: "pyroscope_bucket_blocks_count",
 			Help: "Total number of blocks in the bucket. Includes blocks marked for deletion, but not partial blocks.",
 		}, []string{"user", "compaction_level"}),
+		tenantBlocksSize: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pyroscope_bucket_blocks_size_bytes",
+			Help: "Total size of the blocks in the bucket. Includes blocks marked for deletion, but not partial blocks.",
+		}, []string{"user"}),
 		tenantMarkedBlocks: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
 			Name: "pyroscope_bucket_blocks_marked_for_deletion_count",
 			Help: "Total number of blocks marked for deletion in the bucket.",
@@ -227,6 +232,7 @@ func (c *BlocksCleaner) refreshOwnedUsers(ctx context.Context) ([]string, map[st
 	for _, userID := range c.lastOwnedUsers {
 		if !isActive[userID] && !isDeleted[userID] {
 			c.tenantBlocks.DeleteLabelValues(userID)
+			c.tenantBlocksSize.DeleteLabelValues(userID)
 			c.tenantMarkedBlocks.DeleteLabelValues(userID)
 			c.tenantPartialBlocks.DeleteLabelValues(userID)
 			c.tenantBucketIndexLastUpdate.DeleteLabelValues(userID)
@@ -326,6 +332,7 @@ func (c *BlocksCleaner) deleteUserMarkedForDeletion(ctx context.Context, userID
 
 	// Given all blocks have been deleted, we can also remove the metrics.
 	c.tenantBlocks.DeleteLabelValues(userID)
+	c.tenantBlocksSize.DeleteLabelValues(userID)
 	c.tenantMarkedBlocks.DeleteLabelValues(userID)
 	c.tenantPartialBlocks.DeleteLabelValues(userID)
 
@@ -333,6 +340,16 @@ func (c *BlocksCleaner) deleteUserMarkedForDeletion(ctx context.Context, userID
 		level.Info(userLogger).Log("msg", "deleted blocks for tenant marked for deletion", "deletedBlocks", deletedBlocks)
 	}
 
+	quarantined, err := block.ListQuarantined(ctx, userLogger, userBucket)
+	if err != nil {
+		return err
+	}
+	for _, m := range quarantined {
+		if err = block.DeleteQuarantined(ctx, userLogger, userBucket, m.ID); err != nil {
+			return errors.Wrapf(err, "failed to delete quarantined block %s", m.ID)
+		}
+	}
+
 	mark, err := bucket.ReadTenantDeletionMark(ctx, c.bucketClient, userID)
 	if err != nil {
 		return errors.Wrap(err, "failed to read tenant deletion mark")
@@ -446,9 +463,12 @@ func (c *BlocksCleaner) cleanUser(ctx context.Context, userID string, userLogger
 
 func (c *BlocksCleaner) updateBlockCountMetrics(userID string, idx *bucketindex.Index) {
 	blocksPerCompactionLevel := make(map[int]int)
+	var size uint64
 	for _, blk := range idx.Blocks {
 		blocksPerCompactionLevel[blk.CompactionLevel]++
+		size += blk.SizeBytes
 	}
+	c.tenantBlocksSize.WithLabelValues(userID).Set(float64(size))
 	c.tenantBlocks.DeletePartialMatch(map[string]string{"user": userID})
 	for compactionLevel, count := range blocksPerCompactionLevel {
 		c.tenantBlocks.WithLabelValues(userID, strconv.Itoa(compactionLevel)).Set(float64(count))
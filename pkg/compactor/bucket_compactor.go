@@ -513,6 +513,18 @@ func (c *BucketCompactor) runCompactionJob(ctx context.Context, job *Job) (shoul
 		return false, nil, err
 	}
 
+	if c.quarantineEnabled {
+		quarantined, err := c.quarantineInvalidBlocks(ctx, jobLogger, toCompact, blocksToCompactDirs)
+		if err != nil {
+			ext.LogError(sp, err)
+			return false, nil, err
+		}
+		if quarantined {
+			// The job is planned again without the quarantined blocks.
+			return true, nil, nil
+		}
+	}
+
 	err = func() error {
 		sp, ctx := opentracing.StartSpanFromContext(ctx, "CompactBlocks")
 		compactionBegin := time.Now()
@@ -606,6 +618,35 @@ func (c *BucketCompactor) runCompactionJob(ctx context.Context, job *Job) (shoul
 	return true, compIDs, nil
 }
 
+// quarantineInvalidBlocks moves the downloaded blocks that are empty or
+// cannot be opened to the quarantine directory of the bucket, instead of
+// failing the compaction of the tenant on every attempt.
+func (c *BucketCompactor) quarantineInvalidBlocks(ctx context.Context, logger log.Logger, metas []*block.Meta, dirs []string) (quarantined bool, err error) {
+	for i, meta := range metas {
+		reason, details := validateSourceBlock(ctx, meta, dirs[i])
+		if reason == "" {
+			continue
+		}
+		if err = block.Quarantine(ctx, logger, c.bkt, meta.ULID, reason, details, c.metrics.blocksQuarantined); err != nil {
+			return false, errors.Wrapf(err, "quarantine block %s", meta.ULID)
+		}
+		quarantined = true
+	}
+	return quarantined, nil
+}
+
+// validateSourceBlock returns the reason to quarantine the block downloaded
+// to dir, if any.
+func validateSourceBlock(ctx context.Context, meta *block.Meta, dir string) (block.QuarantineReason, string) {
+	if meta.Stats.NumProfiles == 0 && meta.Stats.NumSeries == 0 && meta.Stats.NumSamples == 0 {
+		return block.EmptyBlockQuarantineReason, "the block has no profiles"
+	}
+	if err := phlaredb.ValidateLocalBlock(ctx, dir); err != nil {
+		return block.CorruptBlockQuarantineReason, err.Error()
+	}
+	return "", ""
+}
+
 // verifyCompactedBlocksTimeRanges does a full run over the compacted blocks
 // and verifies that they satisfy the min/maxTime from the source blocks
 func verifyCompactedBlocksTimeRanges(compIDs []ulid.ULID, sourceBlocksMinTime, sourceBlocksMaxTime int64, subDir string) error {
@@ -671,7 +712,10 @@ type BucketCompactorMetrics struct {
 	compactionBlocksVerificationFailed prometheus.Counter
 	blocksMarkedForDeletion            prometheus.Counter
 	blocksMarkedForNoCompact           prometheus.Counter
+	blocksQuarantined                  *prometheus.CounterVec
 	blocksMaxTimeDelta                 prometheus.Histogram
+	jobsPlanned                        *prometheus.CounterVec
+	jobsOwned                          *prometheus.CounterVec
 }
 
 // NewBucketCompactorMetrics makes a new BucketCompactorMetrics.
@@ -703,11 +747,23 @@ func NewBucketCompactorMetrics(blocksMarkedForDeletion prometheus.Counter, reg p
 			Help:        "Total number of blocks that were marked for no-compaction.",
 			ConstLabels: prometheus.Labels{"reason": block.OutOfOrderChunksNoCompactReason},
 		}),
+		blocksQuarantined: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_compactor_blocks_quarantined_total",
+			Help: "Total number of blocks that were moved to the quarantine directory of the bucket.",
+		}, []string{"reason"}),
 		blocksMaxTimeDelta: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
 			Name:    "pyroscope_compactor_block_max_time_delta_seconds",
 			Help:    "Difference between now and the max time of a block being compacted in seconds.",
 			Buckets: prometheus.LinearBuckets(86400, 43200, 8), // 1 to 5 days, in 12 hour intervals
 		}),
+		jobsPlanned: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_compactor_jobs_planned_total",
+			Help: "Total number of compaction jobs planned, including the jobs owned by the other compactors.",
+		}, []string{"stage"}),
+		jobsOwned: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_compactor_jobs_owned_total",
+			Help: "Total number of planned compaction jobs owned by the compactor.",
+		}, []string{"stage"}),
 	}
 }
 
@@ -732,6 +788,7 @@ type BucketCompactor struct {
 	sortJobs             JobsOrderFunc
 	waitPeriod           time.Duration
 	blockSyncConcurrency int
+	quarantineEnabled    bool
 	metrics              *BucketCompactorMetrics
 }
 
@@ -749,6 +806,7 @@ func NewBucketCompactor(
 	sortJobs JobsOrderFunc,
 	waitPeriod time.Duration,
 	blockSyncConcurrency int,
+	quarantineEnabled bool,
 	metrics *BucketCompactorMetrics,
 ) (*BucketCompactor, error) {
 	if concurrency <= 0 {
@@ -767,6 +825,7 @@ func NewBucketCompactor(
 		sortJobs:             sortJobs,
 		waitPeriod:           waitPeriod,
 		blockSyncConcurrency: blockSyncConcurrency,
+		quarantineEnabled:    quarantineEnabled,
 		metrics:              metrics,
 	}, nil
 }
@@ -873,6 +932,7 @@ func (c *BucketCompactor) Compact(ctx context.Context, maxCompactionTime time.Du
 			return errors.Wrap(err, "build compaction jobs")
 		}
 		sp.LogKV("discovered_jobs", len(jobs))
+		countJobsByStage(c.metrics.jobsPlanned, jobs)
 
 		// There is another check just before we start processing the job, but we can avoid sending it
 		// to the goroutine in the first place.
@@ -881,6 +941,7 @@ func (c *BucketCompactor) Compact(ctx context.Context, maxCompactionTime time.Du
 			return err
 		}
 		sp.LogKV("own_jobs", len(jobs))
+		countJobsByStage(c.metrics.jobsOwned, jobs)
 
 		// Record the difference between now and the max time for a block being compacted. This
 		// is used to detect compactors not being able to keep up with the rate of blocks being
@@ -951,6 +1012,19 @@ func (c *BucketCompactor) Compact(ctx context.Context, maxCompactionTime time.Du
 	return nil
 }
 
+// countJobsByStage adds the number of jobs of each compaction stage to
+// the counter.
+func countJobsByStage(counter *prometheus.CounterVec, jobs []*Job) {
+	var split int
+	for _, j := range jobs {
+		if j.UseSplitting() {
+			split++
+		}
+	}
+	counter.WithLabelValues(string(stageSplit)).Add(float64(split))
+	counter.WithLabelValues(string(stageMerge)).Add(float64(len(jobs) - split))
+}
+
 // blockMaxTimeDeltas returns a slice of the difference between now and the MaxTime of each
 // block that will be compacted as part of the provided jobs, in seconds.
 func (c *BucketCompactor) blockMaxTimeDeltas(now time.Time, jobs []*Job) []float64 {
@@ -233,7 +233,7 @@ func TestGroupCompactE2E(t *testing.T) {
 			splitBy:              phlaredb.SplitByFingerprint,
 			logger:               logger,
 			metrics:              newCompactorMetrics(nil),
-		}, dir, userbkt, 2, ownAllJobs, sortJobsByNewestBlocksFirst, 0, 4, metrics)
+		}, dir, userbkt, 2, ownAllJobs, sortJobsByNewestBlocksFirst, 0, 4, false, metrics)
 		require.NoError(t, err)
 
 		// Compaction on empty should not fail.
@@ -122,7 +122,7 @@ func TestBucketCompactor_FilterOwnJobs(t *testing.T) {
 	m := NewBucketCompactorMetrics(promauto.With(nil).NewCounter(prometheus.CounterOpts{}), nil)
 	for testName, testCase := range tests {
 		t.Run(testName, func(t *testing.T) {
-			bc, err := NewBucketCompactor(log.NewNopLogger(), nil, nil, nil, nil, "", nil, 2, testCase.ownJob, nil, 0, 4, m)
+			bc, err := NewBucketCompactor(log.NewNopLogger(), nil, nil, nil, nil, "", nil, 2, testCase.ownJob, nil, 0, 4, false, m)
 			require.NoError(t, err)
 
 			res, err := bc.filterOwnJobs(jobsFn())
@@ -152,7 +152,7 @@ func TestBlockMaxTimeDeltas(t *testing.T) {
 
 	metrics := NewBucketCompactorMetrics(promauto.With(nil).NewCounter(prometheus.CounterOpts{}), nil)
 	now := time.UnixMilli(1500002900159)
-	bc, err := NewBucketCompactor(log.NewNopLogger(), nil, nil, nil, nil, "", nil, 2, nil, nil, 0, 4, metrics)
+	bc, err := NewBucketCompactor(log.NewNopLogger(), nil, nil, nil, nil, "", nil, 2, nil, nil, 0, 4, false, metrics)
 	require.NoError(t, err)
 
 	deltas := bc.blockMaxTimeDeltas(now, []*Job{j1, j2})
@@ -326,3 +326,19 @@ func TestCompactedBlocksTimeRangeVerification(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateSourceBlock(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	reason, _ := validateSourceBlock(ctx, &block.Meta{ULID: ulid.MustNew(1, nil)}, dir)
+	assert.Equal(t, block.EmptyBlockQuarantineReason, reason)
+
+	// The block directory has no meta.json.
+	reason, details := validateSourceBlock(ctx, &block.Meta{
+		ULID:  ulid.MustNew(1, nil),
+		Stats: block.BlockStats{NumProfiles: 1, NumSeries: 1, NumSamples: 1},
+	}, dir)
+	assert.Equal(t, block.CorruptBlockQuarantineReason, reason)
+	assert.NotEmpty(t, details)
+}
@@ -102,6 +102,7 @@ type Config struct {
 	MaxCompactionTime          time.Duration `yaml:"max_compaction_time" category:"advanced"`
 	NoBlocksFileCleanupEnabled bool          `yaml:"no_blocks_file_cleanup_enabled" category:"experimental"`
 	DownsamplerEnabled         bool          `yaml:"downsampler_enabled" category:"advanced"`
+	QuarantineInvalidBlocks    bool          `yaml:"quarantine_invalid_blocks" category:"advanced"`
 
 	// Compactor concurrency options
 	MaxOpeningBlocksConcurrency int `yaml:"max_opening_blocks_concurrency" category:"advanced"` // Number of goroutines opening blocks before compaction.
@@ -155,6 +156,7 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet, logger log.Logger) {
 	// f.DurationVar(&cfg.TenantCleanupDelay, "compactor.tenant-cleanup-delay", 6*time.Hour, "For tenants marked for deletion, this is time between deleting of last block, and doing final cleanup (marker files, debug files) of the tenant.")
 	f.BoolVar(&cfg.NoBlocksFileCleanupEnabled, "compactor.no-blocks-file-cleanup-enabled", false, "If enabled, will delete the bucket-index, markers and debug files in the tenant bucket when there are no blocks left in the index.")
 	f.BoolVar(&cfg.DownsamplerEnabled, "compactor.downsampler-enabled", false, "If enabled, the compactor will downsample profiles in blocks at compaction level 3 and above. The original profiles are also kept.")
+	f.BoolVar(&cfg.QuarantineInvalidBlocks, "compactor.quarantine-invalid-blocks", true, "If enabled, the blocks that are empty or cannot be opened are moved to the quarantine directory of the tenant bucket, instead of failing the compaction of the tenant. The quarantined blocks are not queried.")
 	// compactor concurrency options
 	f.IntVar(&cfg.MaxOpeningBlocksConcurrency, "compactor.max-opening-blocks-concurrency", 16, "Number of goroutines opening blocks before compaction.")
 
@@ -767,6 +769,7 @@ func (c *MultitenantCompactor) compactUser(ctx context.Context, userID string) e
 		c.jobsOrder,
 		c.compactorCfg.CompactionWaitPeriod,
 		c.compactorCfg.BlockSyncConcurrency,
+		c.compactorCfg.QuarantineInvalidBlocks,
 		c.bucketCompactorMetrics,
 	)
 	if err != nil {
@@ -105,6 +105,24 @@ func (g *SplitAndMergeGrouper) Groups(blocks map[ulid.ULID]*block.Meta) (res []*
 	return res, nil
 }
 
+// PlanJobs returns the compaction jobs of the tenant blocks, in the order
+// the compactors run them by default, without compacting the blocks. Each
+// job may be run by a different compactor: the jobs with distinct sharding
+// keys can run in parallel. It is used to preview the compaction of a
+// tenant, e.g., before changing its number of shards or split groups.
+func PlanJobs(userID string, metas []*block.Meta, ranges []int64, shardCount, splitStageSize, splitGroupsCount uint32) ([]*Job, error) {
+	blocks := make(map[ulid.ULID]*block.Meta, len(metas))
+	for _, m := range metas {
+		blocks[m.ULID] = m
+	}
+	g := NewSplitAndMergeGrouper(userID, ranges, shardCount, splitStageSize, splitGroupsCount, log.NewNopLogger())
+	jobs, err := g.Groups(blocks)
+	if err != nil {
+		return nil, err
+	}
+	return sortJobsBySmallestRangeOldestBlocksFirst(jobs), nil
+}
+
 // planCompaction analyzes the input blocks and returns a list of compaction jobs that can be
 // run concurrently. Each returned job may belong either to this compactor instance or another one
 // in the cluster, so the caller should check if they belong to their instance before running them.
@@ -839,3 +839,27 @@ func TestGroupBlocksByRange(t *testing.T) {
 		})
 	}
 }
+
+func TestPlanJobs(t *testing.T) {
+	var metas []*block.Meta
+	for i := 1; i <= 4; i++ {
+		metas = append(metas, &block.Meta{ULID: ulid.MustNew(uint64(i), nil), MinTime: 0, MaxTime: 20})
+	}
+
+	jobs, err := PlanJobs("user-1", metas, []int64{20, 40}, 2, 2, 2)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, jobs)
+	var blocks int
+	for _, j := range jobs {
+		assert.True(t, j.UseSplitting())
+		blocks += len(j.IDs())
+	}
+	assert.Equal(t, 4, blocks)
+
+	// Without splitting, the blocks are merged by a single job.
+	jobs, err = PlanJobs("user-1", metas, []int64{20, 40}, 0, 0, 0)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	assert.False(t, jobs[0].UseSplitting())
+	assert.Len(t, jobs[0].IDs(), 4)
+}
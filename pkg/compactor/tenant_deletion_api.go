@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/grafana/mimir/blob/main/pkg/compactor/tenant_deletion_api.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Cortex Authors.
+
+package compactor
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucket"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/util"
+)
+
+// DeleteTenant marks the tenant for deletion. Ingesters reject the profiles
+// of the tenant, and the blocks cleaner deletes its blocks in the background.
+func (c *MultitenantCompactor) DeleteTenant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		// The auth middleware responds with 401 if the tenant is missing,
+		// so we do too for consistency.
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	err = bucket.WriteTenantDeletionMark(ctx, c.bucketClient, tenantID, c.cfgProvider, bucket.NewTenantDeletionMark(time.Now()))
+	if err != nil {
+		level.Error(c.logger).Log("msg", "failed to write tenant deletion mark", "tenant", tenantID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	level.Info(c.logger).Log("msg", "tenant deletion mark in blocks storage created", "tenant", tenantID)
+	w.WriteHeader(http.StatusOK)
+}
+
+type DeleteTenantStatusResponse struct {
+	TenantID      string `json:"tenant_id"`
+	BlocksDeleted bool   `json:"blocks_deleted"`
+}
+
+// DeleteTenantStatus reports whether all the blocks of the tenant
+// have been deleted from the storage.
+func (c *MultitenantCompactor) DeleteTenantStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	result := DeleteTenantStatusResponse{TenantID: tenantID}
+	result.BlocksDeleted, err = c.isBlocksForTenantDeleted(ctx, tenantID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	util.WriteJSONResponse(w, result)
+}
+
+func (c *MultitenantCompactor) isBlocksForTenantDeleted(ctx context.Context, tenantID string) (bool, error) {
+	errBlockFound := errors.New("block found")
+
+	tenantBucket := objstore.NewTenantBucketClient(tenantID, c.bucketClient, c.cfgProvider)
+	err := tenantBucket.Iter(ctx, "", func(name string) error {
+		if _, ok := block.IsBlockDir(name); !ok {
+			return nil
+		}
+		// Used as shortcut to stop iteration.
+		return errBlockFound
+	})
+
+	if errors.Is(err, errBlockFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/grafana/mimir/blob/main/pkg/compactor/tenant_deletion_api_test.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Cortex Authors.
+
+package compactor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	pyroscope_objstore "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucket"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+func TestDeleteTenant(t *testing.T) {
+	bkt := objstore.NewInMemBucket()
+	c := &MultitenantCompactor{
+		bucketClient: pyroscope_objstore.NewBucket(bkt),
+		logger:       log.NewNopLogger(),
+	}
+
+	{
+		resp := httptest.NewRecorder()
+		c.DeleteTenant(resp, &http.Request{})
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	}
+
+	{
+		ctx := tenant.InjectTenantID(context.Background(), "fake")
+		req := &http.Request{}
+		resp := httptest.NewRecorder()
+		c.DeleteTenant(resp, req.WithContext(ctx))
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		objs := bkt.Objects()
+		require.NotNil(t, objs["fake/phlaredb/"+bucket.TenantDeletionMarkPath])
+	}
+}
+
+func TestDeleteTenantStatus(t *testing.T) {
+	const username = "user"
+
+	for name, tc := range map[string]struct {
+		objects               map[string][]byte
+		expectedBlocksDeleted bool
+	}{
+		"empty": {
+			objects:               nil,
+			expectedBlocksDeleted: true,
+		},
+
+		"no user objects": {
+			objects: map[string][]byte{
+				"different-user/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E0/meta.json": []byte("data"),
+			},
+			expectedBlocksDeleted: true,
+		},
+
+		"non-block files": {
+			objects: map[string][]byte{
+				"user/phlaredb/deletion-mark.json": []byte("data"),
+			},
+			expectedBlocksDeleted: true,
+		},
+
+		"block files": {
+			objects: map[string][]byte{
+				"user/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E0/meta.json": []byte("data"),
+			},
+			expectedBlocksDeleted: false,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			bkt := objstore.NewInMemBucket()
+			for objName, data := range tc.objects {
+				require.NoError(t, bkt.Upload(context.Background(), objName, bytes.NewReader(data)))
+			}
+
+			c := &MultitenantCompactor{
+				bucketClient: pyroscope_objstore.NewBucket(bkt),
+				logger:       log.NewNopLogger(),
+			}
+
+			res, err := c.isBlocksForTenantDeleted(context.Background(), username)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedBlocksDeleted, res)
+		})
+	}
+}
+
+func TestMigrateTenant(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	for name, data := range map[string]string{
+		"source/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E0/meta.json":           "meta-a",
+		"source/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E0/index.tsdb":          "index-a",
+		"source/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E0/symbols/index.symdb": "symbols-a",
+		"source/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E1/meta.json":           "meta-b",
+		"source/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E1/profiles.parquet":    "profiles-b",
+		"source/phlaredb/bucket-index.json.gz":                           "index",
+		"target/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E1/meta.json":           "meta-b",
+		"target/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E1/profiles.parquet":    "profiles-b",
+		"other/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E2/meta.json":            "meta-c",
+	} {
+		require.NoError(t, bkt.Upload(ctx, name, bytes.NewReader([]byte(data))))
+	}
+
+	c := &MultitenantCompactor{
+		bucketClient: pyroscope_objstore.NewBucket(bkt),
+		logger:       log.NewNopLogger(),
+	}
+	migrate := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/compactor/migrate_tenant?"+query, nil)
+		resp := httptest.NewRecorder()
+		c.MigrateTenant(resp, req.WithContext(tenant.InjectTenantID(ctx, "source")))
+		return resp
+	}
+
+	require.Equal(t, http.StatusBadRequest, migrate("target=source").Code)
+	require.Equal(t, http.StatusBadRequest, migrate("target=").Code)
+	require.Equal(t, http.StatusBadRequest, migrate("target=target&mode=rename").Code)
+
+	resp := migrate("target=target&mode=move")
+	require.Equal(t, http.StatusOK, resp.Code)
+	var result MigrateTenantResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	assert.Equal(t, MigrateTenantResponse{
+		TenantID:       "source",
+		TargetTenantID: "target",
+		Mode:           "move",
+		BlocksCopied:   1,
+		BlocksSkipped:  1,
+	}, result)
+
+	objs := bkt.Objects()
+	assert.Equal(t, "meta-a", string(objs["target/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E0/meta.json"]))
+	assert.Equal(t, "index-a", string(objs["target/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E0/index.tsdb"]))
+	assert.Equal(t, "symbols-a", string(objs["target/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E0/symbols/index.symdb"]))
+	assert.NotContains(t, objs, "target/phlaredb/bucket-index.json.gz")
+	assert.NotContains(t, objs, "target/phlaredb/01EQK4QKFHVSZYVJ908Y7HH9E2/meta.json")
+
+	marked, err := bucket.TenantDeletionMarkExists(ctx, c.bucketClient, "source")
+	require.NoError(t, err)
+	assert.True(t, marked)
+	marked, err = bucket.TenantDeletionMarkExists(ctx, c.bucketClient, "target")
+	require.NoError(t, err)
+	assert.False(t, marked)
+}
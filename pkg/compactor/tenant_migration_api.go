@@ -0,0 +1,166 @@
+package compactor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	dskittenant "github.com/grafana/dskit/tenant"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	thanosobjstore "github.com/thanos-io/objstore"
+
+	"github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucket"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/util"
+)
+
+const (
+	migrateTenantModeCopy = "copy"
+	migrateTenantModeMove = "move"
+)
+
+type MigrateTenantResponse struct {
+	TenantID       string `json:"tenant_id"`
+	TargetTenantID string `json:"target_tenant_id"`
+	Mode           string `json:"mode"`
+	// BlocksCopied is the number of blocks copied by the request.
+	BlocksCopied int `json:"blocks_copied"`
+	// BlocksSkipped is the number of blocks already present in the target
+	// tenant, e.g. copied by a previous attempt.
+	BlocksSkipped int `json:"blocks_skipped"`
+}
+
+// MigrateTenant copies the blocks of the tenant to the target tenant, for
+// example, /compactor/migrate_tenant?target=tenant-b&mode=move.
+//
+// Blocks already present in the target tenant are skipped, therefore the
+// request can be retried if it fails midway. In the move mode, the source
+// tenant is marked for deletion once all the blocks have been copied.
+// The bucket index of the target tenant is updated by the blocks cleaner.
+//
+// The request writes to another tenant than the one of the request: it is
+// only allowed with a cluster-level admin token, see apitoken.Token.Operator,
+// and rejected if the tokens are disabled.
+func (c *MultitenantCompactor) MigrateTenant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	result := MigrateTenantResponse{
+		TenantID:       tenantID,
+		TargetTenantID: r.FormValue("target"),
+		Mode:           r.FormValue("mode"),
+	}
+	if result.Mode == "" {
+		result.Mode = migrateTenantModeCopy
+	}
+	if result.Mode != migrateTenantModeCopy && result.Mode != migrateTenantModeMove {
+		http.Error(w, fmt.Sprintf("invalid mode %q: must be %q or %q", result.Mode, migrateTenantModeCopy, migrateTenantModeMove), http.StatusBadRequest)
+		return
+	}
+	if result.TargetTenantID == "" {
+		http.Error(w, "the target tenant is required", http.StatusBadRequest)
+		return
+	}
+	if err = dskittenant.ValidTenantID(result.TargetTenantID); err != nil {
+		http.Error(w, fmt.Sprintf("invalid target tenant: %v", err), http.StatusBadRequest)
+		return
+	}
+	if result.TargetTenantID == tenantID {
+		http.Error(w, "the target tenant must differ from the source tenant", http.StatusBadRequest)
+		return
+	}
+
+	logger := log.With(c.logger, "tenant", tenantID, "target_tenant", result.TargetTenantID, "mode", result.Mode)
+	result.BlocksCopied, result.BlocksSkipped, err = c.copyTenantBlocks(ctx, logger, tenantID, result.TargetTenantID)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to migrate tenant blocks", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if result.Mode == migrateTenantModeMove {
+		err = bucket.WriteTenantDeletionMark(ctx, c.bucketClient, tenantID, c.cfgProvider, bucket.NewTenantDeletionMark(time.Now()))
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to write tenant deletion mark", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	level.Info(logger).Log("msg", "tenant blocks migrated", "copied", result.BlocksCopied, "skipped", result.BlocksSkipped)
+	util.WriteJSONResponse(w, result)
+}
+
+// copyTenantBlocks copies the blocks of the source tenant that are not
+// present in the target tenant.
+func (c *MultitenantCompactor) copyTenantBlocks(ctx context.Context, logger log.Logger, sourceID, targetID string) (copied, skipped int, err error) {
+	src := objstore.NewTenantBucketClient(sourceID, c.bucketClient, c.cfgProvider)
+	dst := objstore.NewTenantBucketClient(targetID, c.bucketClient, c.cfgProvider)
+
+	var blocks []ulid.ULID
+	err = src.Iter(ctx, "", func(name string) error {
+		if id, ok := block.IsBlockDir(name); ok {
+			blocks = append(blocks, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "list source tenant blocks")
+	}
+
+	for _, id := range blocks {
+		exists, err := dst.Exists(ctx, path.Join(id.String(), block.MetaFilename))
+		if err != nil {
+			return copied, skipped, errors.Wrapf(err, "check block %s in target tenant", id)
+		}
+		if exists {
+			skipped++
+			continue
+		}
+		if err = copyBlock(ctx, src, dst, id); err != nil {
+			return copied, skipped, errors.Wrapf(err, "copy block %s", id)
+		}
+		level.Debug(logger).Log("msg", "copied block", "block", id)
+		copied++
+	}
+
+	return copied, skipped, nil
+}
+
+// copyBlock copies the block objects from src to dst. The meta file is
+// uploaded last: blocks without it are considered partial and are not
+// queried, and will be copied again on retry.
+func copyBlock(ctx context.Context, src, dst objstore.Bucket, id ulid.ULID) error {
+	metaPath := path.Join(id.String(), block.MetaFilename)
+	err := src.Iter(ctx, id.String(), func(name string) error {
+		if strings.HasSuffix(name, thanosobjstore.DirDelim) || name == metaPath {
+			return nil
+		}
+		return copyObject(ctx, src, dst, name)
+	}, thanosobjstore.WithRecursiveIter())
+	if err != nil {
+		return err
+	}
+	return copyObject(ctx, src, dst, metaPath)
+}
+
+func copyObject(ctx context.Context, src, dst objstore.Bucket, name string) error {
+	r, err := src.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return dst.Upload(ctx, name, r)
+}
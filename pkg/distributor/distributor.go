@@ -11,6 +11,7 @@ import (
 	"math/rand"
 	"net/http"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -20,7 +21,6 @@ import (
 	"github.com/go-kit/log/level"
 	"github.com/google/uuid"
 	"github.com/grafana/dskit/kv"
-	"github.com/grafana/dskit/limiter"
 	"github.com/grafana/dskit/ring"
 	ring_client "github.com/grafana/dskit/ring/client"
 	"github.com/grafana/dskit/services"
@@ -40,7 +40,9 @@ import (
 	connectapi "github.com/grafana/pyroscope/pkg/api/connect"
 	"github.com/grafana/pyroscope/pkg/clientpool"
 	"github.com/grafana/pyroscope/pkg/distributor/aggregator"
+	"github.com/grafana/pyroscope/pkg/distributor/idempotency"
 	"github.com/grafana/pyroscope/pkg/distributor/ingest_limits"
+	"github.com/grafana/pyroscope/pkg/distributor/livetail"
 	distributormodel "github.com/grafana/pyroscope/pkg/distributor/model"
 	"github.com/grafana/pyroscope/pkg/distributor/sampling"
 	writepath "github.com/grafana/pyroscope/pkg/distributor/write_path"
@@ -77,6 +79,10 @@ type Config struct {
 
 	// Distributors ring
 	DistributorRing util.CommonRingConfig `yaml:"ring"`
+
+	LiveTail livetail.Config `yaml:"live_tail"`
+
+	Idempotency idempotency.Config `yaml:"idempotency"`
 }
 
 // RegisterFlags registers distributor-related flags.
@@ -84,6 +90,8 @@ func (cfg *Config) RegisterFlags(fs *flag.FlagSet, logger log.Logger) {
 	cfg.PoolConfig.RegisterFlagsWithPrefix("distributor", fs)
 	fs.DurationVar(&cfg.PushTimeout, "distributor.push.timeout", 5*time.Second, "Timeout when pushing data to ingester.")
 	cfg.DistributorRing.RegisterFlags("distributor.ring.", "collectors/", "distributors", fs, logger)
+	cfg.LiveTail.RegisterFlags(fs)
+	cfg.Idempotency.RegisterFlags(fs)
 }
 
 // Distributor coordinates replicates and distribution of log streams.
@@ -101,7 +109,8 @@ type Distributor struct {
 	distributorsLifecycler *ring.BasicLifecycler
 	distributorsRing       *ring.Ring
 	healthyInstancesCount  *atomic.Uint32
-	ingestionRateLimiter   *limiter.RateLimiter
+	ingestionRateLimiter   *rateLimiter
+	profilesRateLimiter    *rateLimiter
 	aggregator             *aggregator.MultiTenantAggregator[*pprof.ProfileMerge]
 	asyncRequests          sync.WaitGroup
 	ingestionLimitsSampler *ingest_limits.Sampler
@@ -120,11 +129,18 @@ type Distributor struct {
 
 	router        *writepath.Router
 	segmentWriter writepath.SegmentWriterClient
+
+	// liveTail is nil if the live tail is disabled.
+	liveTail *livetail.Hub
+	// idempotency is nil if the deduplication is disabled.
+	idempotency *idempotency.Cache
 }
 
 type Limits interface {
 	IngestionRateBytes(tenantID string) float64
 	IngestionBurstSizeBytes(tenantID string) int
+	IngestionRateProfiles(tenantID string) float64
+	IngestionBurstSizeProfiles(tenantID string) int
 	IngestionLimit(tenantID string) *ingest_limits.Config
 	DistributorSampling(tenantID string) *sampling.Config
 	IngestionTenantShardSize(tenantID string) int
@@ -139,8 +155,13 @@ type Limits interface {
 	MaxSessionsPerSeries(tenantID string) int
 	EnforceLabelsOrder(tenantID string) bool
 	IngestionRelabelingRules(tenantID string) []*relabel.Config
+	IngestionLabelSanitizer(tenantID string) validation.LabelSanitizer
+	IngestionDropTraceIDLabels(tenantID string) bool
 	DistributorUsageGroups(tenantID string) *validation.UsageGroupConfig
+	DistributorAggregationIgnoredLabels(tenantID string) []string
+	DistributorIndexedResourceAttributes(tenantID string) []string
 	validation.ProfileValidationLimits
+	validation.RequestValidationLimits
 	aggregator.Limits
 	writepath.Overrides
 }
@@ -182,6 +203,11 @@ func New(
 		profileSizeStats:        usagestats.NewMultiStatistics("distributor_profile_sizes", "lang"),
 	}
 
+	if config.LiveTail.Enabled {
+		d.liveTail = livetail.NewHub(config.LiveTail, reg)
+	}
+	d.idempotency = idempotency.New(config.Idempotency, d.idempotencyKeyOwner, logger, reg)
+
 	ingesterRoute := writepath.IngesterFunc(d.sendRequestsToIngester)
 	segmentWriterRoute := writepath.IngesterFunc(d.sendRequestsToSegmentWriter)
 	d.router = writepath.NewRouter(
@@ -204,7 +230,8 @@ func New(
 
 	subservices = append(subservices, distributorsLifecycler, distributorsRing, d.aggregator, d.ingestionLimitsSampler)
 
-	d.ingestionRateLimiter = limiter.NewRateLimiter(newGlobalRateStrategy(newIngestionRateStrategy(limits), d), 10*time.Second)
+	d.ingestionRateLimiter = newRateLimiter(newGlobalRateStrategy(newIngestionRateStrategy(limits), d), 10*time.Second)
+	d.profilesRateLimiter = newRateLimiter(newGlobalRateStrategy(newProfilesRateStrategy(limits), d), 10*time.Second)
 	d.distributorsLifecycler = distributorsLifecycler
 	d.distributorsRing = distributorsRing
 
@@ -243,16 +270,37 @@ func (d *Distributor) Push(ctx context.Context, grpcReq *connect.Request[pushv1.
 	req := &distributormodel.PushRequest{
 		Series: make([]*distributormodel.ProfileSeries, 0, len(grpcReq.Msg.Series)),
 	}
+	// The profiles are decompressed before the request is validated:
+	// the decompression is bounded by the profile size limit to protect
+	// from decompression bombs. The tenant is authenticated in PushParsed.
+	tenantID, _ := tenant.ExtractTenantIDFromContext(ctx)
+	maxProfileSize := int64(-1)
+	if limit := d.limits.MaxProfileSizeBytes(tenantID); limit != 0 {
+		maxProfileSize = int64(limit)
+	}
 
 	for _, grpcSeries := range grpcReq.Msg.Series {
 		series := &distributormodel.ProfileSeries{
 			Labels:  grpcSeries.Labels,
 			Samples: make([]*distributormodel.ProfileSample, 0, len(grpcSeries.Samples)),
 		}
-		for _, grpcSample := range grpcSeries.Samples {
-			profile, err := pprof.RawFromBytes(grpcSample.RawProfile)
+		for i, grpcSample := range grpcSeries.Samples {
+			profile, err := pprof.RawFromBytesWithLimit(grpcSample.RawProfile, maxProfileSize)
+			if errors.Is(err, pprof.ErrDecompressedSizeLimitExceeded) {
+				validation.DiscardedProfiles.WithLabelValues(string(validation.ProfileSizeLimit), tenantID).Inc()
+				validation.DiscardedBytes.WithLabelValues(string(validation.ProfileSizeLimit), tenantID).Add(float64(len(grpcSample.RawProfile)))
+				return nil, validation.NewInvalidArgumentError(
+					validation.NewErrorf(validation.ProfileSizeLimit, "the profile %d of the series %s exceeds the size limit once decompressed (max_profile_size_bytes, limit: %d)",
+						i, phlaremodel.LabelPairsString(grpcSeries.Labels), maxProfileSize).
+						WithMetadata("profile_index", strconv.Itoa(i), "series", phlaremodel.LabelPairsString(grpcSeries.Labels)))
+			}
 			if err != nil {
-				return nil, connect.NewError(connect.CodeInvalidArgument, err)
+				validation.DiscardedProfiles.WithLabelValues(string(validation.MalformedProfile), tenantID).Inc()
+				validation.DiscardedBytes.WithLabelValues(string(validation.MalformedProfile), tenantID).Add(float64(len(grpcSample.RawProfile)))
+				return nil, validation.NewInvalidArgumentError(
+					validation.NewErrorf(validation.MalformedProfile, "failed to parse the profile %d of the series %s: %v",
+						i, phlaremodel.LabelPairsString(grpcSeries.Labels), err).
+						WithMetadata("profile_index", strconv.Itoa(i), "series", phlaremodel.LabelPairsString(grpcSeries.Labels)))
 			}
 			sample := &distributormodel.ProfileSample{
 				Profile:    profile,
@@ -318,10 +366,18 @@ func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.Push
 	}
 
 	d.calculateRequestSize(req)
+	if err := validation.ValidateRequest(d.limits, tenantID, req.TotalBytesUncompressed, requestSamples(req)); err != nil {
+		_ = level.Debug(d.logger).Log("msg", "invalid push request", "err", err)
+		reason := string(validation.ReasonOf(err))
+		validation.DiscardedProfiles.WithLabelValues(reason, tenantID).Add(float64(req.TotalProfiles))
+		validation.DiscardedBytes.WithLabelValues(reason, tenantID).Add(float64(req.TotalBytesUncompressed))
+		return nil, validation.NewInvalidArgumentError(err)
+	}
 
 	// We don't support externally provided profile annotations right now.
 	// They are unfortunately part of the Push API so we explicitly clear them here.
 	req.ClearAnnotations()
+	req.ExtractResourceAttributes(d.limits.DistributorIndexedResourceAttributes(tenantID))
 	if err := d.checkIngestLimit(req); err != nil {
 		level.Debug(d.logger).Log("msg", "rejecting push request due to global ingest limit", "tenant", tenantID)
 		validation.DiscardedProfiles.WithLabelValues(string(validation.IngestLimitReached), tenantID).Add(float64(req.TotalProfiles))
@@ -376,7 +432,7 @@ func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.Push
 				validation.DiscardedProfiles.WithLabelValues(reason, tenantID).Add(float64(req.TotalProfiles))
 				validation.DiscardedBytes.WithLabelValues(reason, tenantID).Add(float64(req.TotalBytesUncompressed))
 				groups.CountDiscardedBytes(reason, req.TotalBytesUncompressed)
-				return nil, connect.NewError(connect.CodeInvalidArgument, err)
+				return nil, validation.NewInvalidArgumentError(err)
 			}
 
 			symbolsSize, samplesSize := profileSizeBytes(p.Profile)
@@ -415,10 +471,19 @@ func (d *Distributor) PushParsed(ctx context.Context, req *distributormodel.Push
 
 	// Reduce cardinality of the session_id label.
 	maxSessionsPerSeries := d.limits.MaxSessionsPerSeries(req.TenantID)
+	// The ignored labels, e.g., the instance of a function-as-a-service,
+	// are dropped, so that the profiles of all the replicas end up in the
+	// same series, whether they are aggregated or not.
+	ignoredLabels := d.aggregationIgnoredLabels(req.TenantID)
 	for _, series := range req.Series {
 		series.Labels = d.limitMaxSessionsPerSeries(maxSessionsPerSeries, series.Labels)
+		for _, name := range ignoredLabels {
+			series.Labels = phlaremodel.Labels(series.Labels).Delete(name)
+		}
 	}
 
+	d.publishLiveTail(req)
+
 	aggregated, err := d.aggregate(ctx, req)
 	if err != nil {
 		return nil, err
@@ -799,6 +864,73 @@ func (d *Distributor) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// Idempotency returns the cache the push requests are deduplicated with,
+// nil if the deduplication is disabled.
+func (d *Distributor) Idempotency() *idempotency.Cache {
+	return d.idempotency
+}
+
+var idempotencyRingOp = ring.NewOp([]ring.InstanceState{ring.ACTIVE}, nil)
+
+// idempotencyKeyOwner returns the address of the distributor the requests
+// with the idempotency key are routed to: the owner of the hash of the key
+// in the distributors ring.
+func (d *Distributor) idempotencyKeyOwner(key string) (string, bool, error) {
+	if d.distributorsRing == nil {
+		return "", true, nil
+	}
+	rs, err := d.distributorsRing.Get(TokenFor("", key), idempotencyRingOp, nil, nil, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if len(rs.Instances) == 0 {
+		return "", false, ring.ErrEmptyRing
+	}
+	addr := rs.Instances[0].Addr
+	return addr, addr == d.distributorsLifecycler.GetInstanceAddr(), nil
+}
+
+// LiveTailHandler returns the handler of the live tail API, or nil if the
+// live tail is disabled.
+func (d *Distributor) LiveTailHandler() http.Handler {
+	if d.liveTail == nil {
+		return nil
+	}
+	return livetail.NewHandler(d.liveTail, d.liveTailPeers, d.logger)
+}
+
+// liveTailPeers returns the addresses of the other healthy distributors.
+func (d *Distributor) liveTailPeers() ([]string, error) {
+	rs, err := d.distributorsRing.GetAllHealthy(ring.Reporting)
+	if err != nil {
+		return nil, err
+	}
+	self := d.distributorsLifecycler.GetInstanceAddr()
+	peers := make([]string, 0, len(rs.Instances))
+	for _, instance := range rs.Instances {
+		if instance.Addr != self {
+			peers = append(peers, instance.Addr)
+		}
+	}
+	return peers, nil
+}
+
+// publishLiveTail sends the profiles of the request to the live tail
+// subscribers of the tenant, if any.
+func (d *Distributor) publishLiveTail(req *distributormodel.PushRequest) {
+	if !d.liveTail.HasSubscribers(req.TenantID) {
+		return
+	}
+	for _, series := range req.Series {
+		for _, sample := range series.Samples {
+			p := sample.Profile.Profile
+			if err := d.liveTail.Publish(req.TenantID, series.Labels, p.TimeNanos, p.MarshalVT); err != nil {
+				level.Warn(d.logger).Log("msg", "failed to publish the profile to the live tail", "tenant", req.TenantID, "err", err)
+			}
+		}
+	}
+}
+
 // HealthyInstancesCount implements the ReadLifecycler interface
 //
 // We use a ring lifecycler delegate to count the number of members of the
@@ -808,6 +940,15 @@ func (d *Distributor) HealthyInstancesCount() int {
 	return int(d.healthyInstancesCount.Load())
 }
 
+// aggregationIgnoredLabels returns the labels removed from the series of
+// the tenant, if the aggregation is enabled for the tenant.
+func (d *Distributor) aggregationIgnoredLabels(tenantID string) []string {
+	if d.limits.DistributorAggregationWindow(tenantID) <= 0 || d.limits.DistributorAggregationPeriod(tenantID) <= 0 {
+		return nil
+	}
+	return d.limits.DistributorAggregationIgnoredLabels(tenantID)
+}
+
 func (d *Distributor) limitMaxSessionsPerSeries(maxSessionsPerSeries int, labels phlaremodel.Labels) phlaremodel.Labels {
 	if maxSessionsPerSeries == 0 {
 		return labels.Delete(phlaremodel.LabelNameSessionID)
@@ -825,14 +966,27 @@ func (d *Distributor) limitMaxSessionsPerSeries(maxSessionsPerSeries int, labels
 	return labels
 }
 
+// rateLimit checks the request against the bytes and the profiles rate
+// limits. The bytes reserved are given back if the profiles limit rejects
+// the request.
 func (d *Distributor) rateLimit(tenantID string, req *distributormodel.PushRequest) error {
-	if !d.ingestionRateLimiter.AllowN(time.Now(), tenantID, int(req.TotalBytesUncompressed)) {
+	now := time.Now()
+	bytes, ok := d.ingestionRateLimiter.reserve(now, tenantID, int(req.TotalBytesUncompressed))
+	if !ok {
 		validation.DiscardedProfiles.WithLabelValues(string(validation.RateLimited), tenantID).Add(float64(req.TotalProfiles))
 		validation.DiscardedBytes.WithLabelValues(string(validation.RateLimited), tenantID).Add(float64(req.TotalBytesUncompressed))
 		return connect.NewError(connect.CodeResourceExhausted,
 			fmt.Errorf("push rate limit (%s) exceeded while adding %s", humanize.IBytes(uint64(d.limits.IngestionRateBytes(tenantID))), humanize.IBytes(uint64(req.TotalBytesUncompressed))),
 		)
 	}
+	if _, ok = d.profilesRateLimiter.reserve(now, tenantID, int(req.TotalProfiles)); !ok {
+		bytes.CancelAt(now)
+		validation.DiscardedProfiles.WithLabelValues(string(validation.RateLimited), tenantID).Add(float64(req.TotalProfiles))
+		validation.DiscardedBytes.WithLabelValues(string(validation.RateLimited), tenantID).Add(float64(req.TotalBytesUncompressed))
+		return connect.NewError(connect.CodeResourceExhausted,
+			fmt.Errorf("push rate limit (%g profiles/s) exceeded while adding %d profiles", d.limits.IngestionRateProfiles(tenantID), req.TotalProfiles),
+		)
+	}
 	return nil
 }
 
@@ -850,6 +1004,15 @@ func (d *Distributor) calculateRequestSize(req *distributormodel.PushRequest) {
 	}
 }
 
+func requestSamples(req *distributormodel.PushRequest) (n int) {
+	for _, series := range req.Series {
+		for _, raw := range series.Samples {
+			n += len(raw.Profile.Sample)
+		}
+	}
+	return n
+}
+
 func (d *Distributor) checkIngestLimit(req *distributormodel.PushRequest) error {
 	l := d.limits.IngestionLimit(req.TenantID)
 	if l == nil {
@@ -1015,22 +1178,28 @@ type visitFunc func(*profilev1.Profile, []*typesv1.LabelPair, []*relabel.Config,
 
 func (d *Distributor) visitSampleSeries(req *distributormodel.PushRequest, visit visitFunc) error {
 	relabelingRules := d.limits.IngestionRelabelingRules(req.TenantID)
+	sanitizer := d.limits.IngestionLabelSanitizer(req.TenantID)
 	usageConfig := d.limits.DistributorUsageGroups(req.TenantID)
+	dropTraceIDs := d.limits.IngestionDropTraceIDLabels(req.TenantID)
 	var result []*distributormodel.ProfileSeries
 
 	for _, series := range req.Series {
 		usageGroups := d.usageGroupEvaluator.GetMatch(req.TenantID, usageConfig, series.Labels)
 		for _, p := range series.Samples {
+			if dropTraceIDs {
+				pprof.RemoveLabel(p.Profile.Profile, pprof.TraceIDLabelName)
+			}
 			visitor := &sampleSeriesVisitor{
-				tenantID: req.TenantID,
-				limits:   d.limits,
-				profile:  p.Profile,
+				tenantID:  req.TenantID,
+				limits:    d.limits,
+				sanitizer: sanitizer,
+				profile:   p.Profile,
 			}
 			if err := visit(p.Profile.Profile, series.Labels, relabelingRules, visitor); err != nil {
 				validation.DiscardedProfiles.WithLabelValues(string(validation.ReasonOf(err)), req.TenantID).Add(float64(req.TotalProfiles))
 				validation.DiscardedBytes.WithLabelValues(string(validation.ReasonOf(err)), req.TenantID).Add(float64(req.TotalBytesUncompressed))
 				usageGroups.CountDiscardedBytes(string(validation.ReasonOf(err)), req.TotalBytesUncompressed)
-				return connect.NewError(connect.CodeInvalidArgument, err)
+				return validation.NewInvalidArgumentError(err)
 			}
 			for _, s := range visitor.series {
 				s.Annotations = series.Annotations
@@ -1069,18 +1238,26 @@ func removeEmptySeries(req *distributormodel.PushRequest) {
 }
 
 type sampleSeriesVisitor struct {
-	tenantID string
-	limits   Limits
-	profile  *pprof.Profile
-	exp      *pprof.SampleExporter
-	series   []*distributormodel.ProfileSeries
+	tenantID  string
+	limits    Limits
+	sanitizer validation.LabelSanitizer
+	profile   *pprof.Profile
+	exp       *pprof.SampleExporter
+	series    []*distributormodel.ProfileSeries
 
 	discardedBytes    int
 	discardedProfiles int
 }
 
+func (v *sampleSeriesVisitor) SanitizeLabels(lb *phlaremodel.LabelsBuilder) {
+	v.sanitizer.Sanitize(lb)
+}
+
 func (v *sampleSeriesVisitor) ValidateLabels(labels phlaremodel.Labels) error {
-	return validation.ValidateLabels(v.limits, v.tenantID, labels)
+	if err := validation.ValidateLabels(v.limits, v.tenantID, labels); err != nil {
+		return err
+	}
+	return v.sanitizer.Validate(labels)
 }
 
 func (v *sampleSeriesVisitor) VisitProfile(labels phlaremodel.Labels) {
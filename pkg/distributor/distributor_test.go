@@ -2,6 +2,7 @@ package distributor
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
@@ -310,6 +311,53 @@ func Test_Limits(t *testing.T) {
 			expectedCode:             connect.CodeInvalidArgument,
 			expectedValidationReason: validation.LabelNameTooLong,
 		},
+		{
+			description: "request_size_limit",
+			pushReq: &pushv1.PushRequest{
+				Series: []*pushv1.RawProfileSeries{
+					{
+						Labels: []*typesv1.LabelPair{
+							{Name: "__name__", Value: "cpu"},
+							{Name: phlaremodel.LabelNameServiceName, Value: "svc"},
+						},
+						Samples: []*pushv1.RawSample{
+							{RawProfile: collectTestProfileBytes(t)},
+							{RawProfile: collectTestProfileBytes(t)},
+						},
+					},
+				},
+			},
+			overrides: validation.MockOverrides(func(defaults *validation.Limits, tenantLimits map[string]*validation.Limits) {
+				l := validation.MockDefaultLimits()
+				l.MaxRequestSizeBytes = 100
+				tenantLimits["user-1"] = l
+			}),
+			expectedCode:             connect.CodeInvalidArgument,
+			expectedValidationReason: validation.ProfileSizeLimit,
+		},
+		{
+			description: "request_samples_limit",
+			pushReq: &pushv1.PushRequest{
+				Series: []*pushv1.RawProfileSeries{
+					{
+						Labels: []*typesv1.LabelPair{
+							{Name: "__name__", Value: "cpu"},
+							{Name: phlaremodel.LabelNameServiceName, Value: "svc"},
+						},
+						Samples: []*pushv1.RawSample{{
+							RawProfile: hugeProfileBytes(t),
+						}},
+					},
+				},
+			},
+			overrides: validation.MockOverrides(func(defaults *validation.Limits, tenantLimits map[string]*validation.Limits) {
+				l := validation.MockDefaultLimits()
+				l.MaxRequestSamples = 100
+				tenantLimits["user-1"] = l
+			}),
+			expectedCode:             connect.CodeInvalidArgument,
+			expectedValidationReason: validation.SamplesLimit,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -347,6 +395,52 @@ func Test_Limits(t *testing.T) {
 	}
 }
 
+func Test_DecompressedSizeLimit(t *testing.T) {
+	mux := http.NewServeMux()
+	ing := newFakeIngester(t, false)
+	overrides := validation.MockOverrides(func(defaults *validation.Limits, tenantLimits map[string]*validation.Limits) {
+		l := validation.MockDefaultLimits()
+		l.MaxProfileSizeBytes = 1 << 10
+		tenantLimits["user-1"] = l
+	})
+	d, err := New(Config{
+		DistributorRing: ringConfig,
+	}, testhelper.NewMockRing([]ring.InstanceDesc{
+		{Addr: "foo"},
+	}, 3), &poolFactory{f: func(addr string) (client.PoolClient, error) {
+		return ing, nil
+	}}, overrides, nil, log.NewLogfmtLogger(os.Stdout), nil)
+	require.NoError(t, err)
+
+	// A compressed payload much smaller than its decompressed size.
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, err = gw.Write(make([]byte, 1<<20))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	discarded := validation.DiscardedProfiles.WithLabelValues(string(validation.ProfileSizeLimit), "user-1")
+	before := testutil.ToFloat64(discarded)
+
+	mux.Handle(pushv1connect.NewPusherServiceHandler(d, handlerOptions...))
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	client := pushv1connect.NewPusherServiceClient(http.DefaultClient, s.URL, clientOptions...)
+	_, err = client.Push(tenant.InjectTenantID(context.Background(), "user-1"), connect.NewRequest(&pushv1.PushRequest{
+		Series: []*pushv1.RawProfileSeries{{
+			Labels: []*typesv1.LabelPair{
+				{Name: "__name__", Value: "cpu"},
+				{Name: phlaremodel.LabelNameServiceName, Value: "svc"},
+			},
+			Samples: []*pushv1.RawSample{{RawProfile: buf.Bytes()}},
+		}},
+	}))
+	require.Error(t, err)
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	require.Equal(t, before+1, testutil.ToFloat64(discarded))
+}
+
 func Test_Sessions_Limit(t *testing.T) {
 	type testCase struct {
 		description    string
@@ -1346,6 +1440,62 @@ func Test_SampleLabels_Ingester(t *testing.T) {
 	}
 }
 
+func Test_SampleLabels_DropTraceIDLabels(t *testing.T) {
+	const tenantID = "tenant1"
+	pushReq := func() *distributormodel.PushRequest {
+		return &distributormodel.PushRequest{
+			TenantID: tenantID,
+			Series: []*distributormodel.ProfileSeries{{
+				Labels: []*typesv1.LabelPair{
+					{Name: "service_name", Value: "service"},
+					{Name: "__name__", Value: "cpu"},
+				},
+				Samples: []*distributormodel.ProfileSample{{
+					Profile: pprof2.RawFromProto(&profilev1.Profile{
+						StringTable: []string{"", "trace_id", "4bf92f3577b34da6a3ce929d0e0e4736", "0af7651916cd43dd8448eb211c80319c"},
+						Sample: []*profilev1.Sample{
+							{Value: []int64{1}, Label: []*profilev1.Label{{Key: 1, Str: 2}}},
+							{Value: []int64{2}, Label: []*profilev1.Label{{Key: 1, Str: 3}}},
+						},
+					}),
+				}},
+			}},
+		}
+	}
+
+	for _, drop := range []bool{false, true} {
+		t.Run(fmt.Sprintf("drop=%v", drop), func(t *testing.T) {
+			overrides := validation.MockOverrides(func(defaults *validation.Limits, tenantLimits map[string]*validation.Limits) {
+				l := validation.MockDefaultLimits()
+				l.IngestionDropTraceIDLabels = drop
+				tenantLimits[tenantID] = l
+			})
+			d, err := New(Config{
+				DistributorRing: ringConfig,
+			}, testhelper.NewMockRing([]ring.InstanceDesc{
+				{Addr: "foo"},
+			}, 3), &poolFactory{func(addr string) (client.PoolClient, error) {
+				return newFakeIngester(t, false), nil
+			}}, overrides, nil, log.NewLogfmtLogger(os.Stdout), nil)
+			require.NoError(t, err)
+
+			req := pushReq()
+			require.NoError(t, d.visitSampleSeries(req, visitSampleSeriesForIngester))
+			if !drop {
+				// Each trace is a series.
+				require.Len(t, req.Series, 2)
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", phlaremodel.Labels(req.Series[0].Labels).Get("trace_id"))
+				return
+			}
+			require.Len(t, req.Series, 1)
+			assert.Empty(t, phlaremodel.Labels(req.Series[0].Labels).Get("trace_id"))
+			for _, s := range req.Series[0].Samples[0].Profile.Sample {
+				assert.Empty(t, s.Label)
+			}
+		})
+	}
+}
+
 func Test_SampleLabels_SegmentWriter(t *testing.T) {
 	o := validation.MockDefaultOverrides()
 	defaultRelabelConfigs := o.IngestionRelabelingRules("")
@@ -2295,6 +2445,93 @@ func TestPush_Aggregation(t *testing.T) {
 	assert.Equal(t, len(sessions), maxSessions)
 }
 
+func TestPush_AggregationIgnoredLabels(t *testing.T) {
+	ingesterClient := newFakeIngester(t, false)
+	d, err := New(
+		Config{DistributorRing: ringConfig, PushTimeout: time.Second * 10},
+		testhelper.NewMockRing([]ring.InstanceDesc{{Addr: "foo"}}, 3),
+		&poolFactory{f: func(addr string) (client.PoolClient, error) { return ingesterClient, nil }},
+		validation.MockOverrides(func(defaults *validation.Limits, tenantLimits map[string]*validation.Limits) {
+			l := validation.MockDefaultLimits()
+			l.DistributorAggregationPeriod = model.Duration(time.Second)
+			l.DistributorAggregationWindow = model.Duration(time.Second)
+			l.DistributorAggregationIgnoredLabels = []string{"instance"}
+			tenantLimits["user-1"] = l
+		}),
+		nil, log.NewLogfmtLogger(os.Stdout), nil,
+	)
+	require.NoError(t, err)
+	ctx := tenant.InjectTenantID(context.Background(), "user-1")
+
+	const instances = 10
+	for i := 0; i < instances; i++ {
+		_, err := d.PushParsed(ctx, &distributormodel.PushRequest{
+			Series: []*distributormodel.ProfileSeries{
+				{
+					Labels: []*typesv1.LabelPair{
+						{Name: "service_name", Value: "lambda"},
+						{Name: "instance", Value: strconv.Itoa(i)},
+						{Name: "__name__", Value: "cpu"},
+					},
+					Samples: []*distributormodel.ProfileSample{
+						{
+							Profile: &pprof2.Profile{
+								Profile: testProfile(0),
+							},
+						},
+					},
+				},
+			},
+		})
+		require.NoError(t, err)
+	}
+	d.asyncRequests.Wait()
+
+	var sum int64
+	require.NotEmpty(t, ingesterClient.requests)
+	for _, r := range ingesterClient.requests {
+		for _, s := range r.Series {
+			assert.Empty(t, phlaremodel.Labels(s.Labels).Get("instance"))
+			assert.Equal(t, "lambda", phlaremodel.Labels(s.Labels).Get("service_name"))
+			p, err := pprof2.RawFromBytes(s.Samples[0].RawProfile)
+			require.NoError(t, err)
+			for _, x := range p.Sample {
+				sum += x.Value[0]
+			}
+		}
+	}
+
+	// RF * samples_per_profile * instances
+	assert.Equal(t, int64(3*2*instances), sum)
+
+	// The labels are also dropped from the requests that are not aggregated.
+	ingesterClient.mtx.Lock()
+	ingesterClient.requests = nil
+	ingesterClient.mtx.Unlock()
+	series := func(service string) *distributormodel.ProfileSeries {
+		return &distributormodel.ProfileSeries{
+			Labels: []*typesv1.LabelPair{
+				{Name: "service_name", Value: service},
+				{Name: "instance", Value: "1"},
+				{Name: "__name__", Value: "cpu"},
+			},
+			Samples: []*distributormodel.ProfileSample{{Profile: &pprof2.Profile{Profile: testProfile(0)}}},
+		}
+	}
+	_, err = d.PushParsed(ctx, &distributormodel.PushRequest{
+		Series: []*distributormodel.ProfileSeries{series("a"), series("b")},
+	})
+	require.NoError(t, err)
+	ingesterClient.mtx.Lock()
+	defer ingesterClient.mtx.Unlock()
+	require.NotEmpty(t, ingesterClient.requests)
+	for _, r := range ingesterClient.requests {
+		for _, s := range r.Series {
+			assert.Empty(t, phlaremodel.Labels(s.Labels).Get("instance"))
+		}
+	}
+}
+
 func testProfile(t int64) *profilev1.Profile {
 	return &profilev1.Profile{
 		SampleType: []*profilev1.ValueType{
@@ -0,0 +1,318 @@
+// Package idempotency deduplicates the push requests retried by the agents
+// after an ambiguous failure, such as a timeout, so that the samples of the
+// request are not counted twice.
+//
+// The agents send a unique key per request in the Idempotency-Key header,
+// and the same key on the retries of the request. The keys are kept by each
+// distributor in memory, so the requests are routed by the hash of their key
+// to the distributor owning it in the distributors ring: the retries of a
+// request are deduplicated whichever distributor receives them.
+//
+// The deduplication is best effort: the keys are lost when the owner
+// restarts, the retries are not deduplicated while the owner changes, for
+// example when a distributor joins or leaves the ring, and the requests are
+// handled by the distributor receiving them if their owner can't be reached.
+// The gRPC requests are not routed, as they can't be forwarded over HTTP/1.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+
+	// headerForwarded is set on the requests routed to the owner of their
+	// key, so that they are not routed again.
+	headerForwarded = "X-Pyroscope-Idempotency-Forwarded"
+)
+
+var errInProgress = errors.New("a request with the same idempotency key is in progress")
+
+type Config struct {
+	Window  time.Duration `yaml:"window"`
+	MaxKeys int           `yaml:"max_keys" category:"advanced"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&cfg.Window, "distributor.idempotency.window", 0, "Period the push requests with an Idempotency-Key header are deduplicated over: the requests with the key of a request accepted within the period are acknowledged but not ingested. The requests are routed to the distributor owning their key in the distributors ring. The deduplication is best effort: the keys are kept in memory, and are lost when their owner restarts or changes. 0 to disable.")
+	f.IntVar(&cfg.MaxKeys, "distributor.idempotency.max-keys", 100000, "Maximum number of idempotency keys kept per distributor. The requests are not deduplicated while the limit is reached.")
+}
+
+// Owner returns the address of the distributor owning the idempotency key,
+// and whether it is this distributor.
+type Owner func(idempotencyKey string) (addr string, self bool, err error)
+
+type key struct {
+	tenantID string
+	key      string
+}
+
+type entry struct {
+	done    bool
+	expires time.Time
+}
+
+// Cache keeps the keys of the requests accepted within the window.
+// A nil Cache does not deduplicate the requests.
+type Cache struct {
+	window  time.Duration
+	maxKeys int
+	owner   Owner
+	client  *http.Client
+	logger  log.Logger
+
+	mu   sync.Mutex
+	keys map[key]*entry
+
+	deduplicated *prometheus.CounterVec
+	forwarded    *prometheus.CounterVec
+}
+
+// New returns nil if the deduplication is disabled. The requests are not
+// routed if owner is nil.
+func New(cfg Config, owner Owner, logger log.Logger, reg prometheus.Registerer) *Cache {
+	if cfg.Window <= 0 {
+		return nil
+	}
+	return &Cache{
+		window:  cfg.Window,
+		maxKeys: cfg.MaxKeys,
+		owner:   owner,
+		client:  &http.Client{},
+		logger:  logger,
+		keys:    make(map[key]*entry),
+		deduplicated: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "distributor_deduplicated_requests_total",
+			Help:      "The number of push requests not ingested, because a request with the same idempotency key was accepted.",
+		}, []string{"tenant"}),
+		forwarded: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "distributor_idempotency_forwarded_requests_total",
+			Help:      "The number of push requests routed to the distributor owning their idempotency key, by result.",
+		}, []string{"result"}),
+	}
+}
+
+// Do calls push, unless a request with the same key was accepted within the
+// window, in which case it returns true. The key is only recorded if push
+// succeeds, so that the requests that failed can be retried.
+func (c *Cache) Do(ctx context.Context, idempotencyKey string, push func() error) (deduplicated bool, err error) {
+	if c == nil || idempotencyKey == "" {
+		return false, push()
+	}
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return false, push()
+	}
+	k := key{tenantID: tenantID, key: idempotencyKey}
+	switch s, err := c.begin(k, time.Now()); {
+	case err != nil:
+		return false, err
+	case s == duplicate:
+		return true, nil
+	case s == untracked:
+		return false, push()
+	}
+	if err = push(); err != nil {
+		c.abort(k)
+		return false, err
+	}
+	c.commit(k, time.Now())
+	return false, nil
+}
+
+type state int
+
+const (
+	recorded state = iota
+	duplicate
+	// untracked requests are pushed without deduplication, when the
+	// maximum number of keys is reached.
+	untracked
+)
+
+func (c *Cache) begin(k key, now time.Time) (state, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.keys[k]; ok {
+		if !e.done {
+			return 0, connect.NewError(connect.CodeAborted, errInProgress)
+		}
+		if now.Before(e.expires) {
+			c.deduplicated.WithLabelValues(k.tenantID).Inc()
+			return duplicate, nil
+		}
+		delete(c.keys, k)
+	}
+	if c.maxKeys > 0 && len(c.keys) >= c.maxKeys {
+		c.removeExpiredLocked(now)
+		if len(c.keys) >= c.maxKeys {
+			return untracked, nil
+		}
+	}
+	c.keys[k] = &entry{}
+	return recorded, nil
+}
+
+func (c *Cache) abort(k key) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.keys, k)
+}
+
+func (c *Cache) commit(k key, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.keys[k]; ok {
+		e.done = true
+		e.expires = now.Add(c.window)
+	}
+}
+
+func (c *Cache) removeExpiredLocked(now time.Time) {
+	for k, e := range c.keys {
+		if e.done && !now.Before(e.expires) {
+			delete(c.keys, k)
+		}
+	}
+}
+
+// UnaryInterceptor deduplicates the requests of the connect handlers. The
+// duplicate requests are acknowledged with an empty response of the type
+// returned by the empty function.
+func (c *Cache) UnaryInterceptor(empty func() connect.AnyResponse) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if req.Spec().IsClient {
+				return next(ctx, req)
+			}
+			var resp connect.AnyResponse
+			deduplicated, err := c.Do(ctx, req.Header().Get(HeaderIdempotencyKey), func() (err error) {
+				resp, err = next(ctx, req)
+				return err
+			})
+			if deduplicated {
+				return empty(), nil
+			}
+			return resp, err
+		}
+	}
+}
+
+// Handler deduplicates the requests of the HTTP handler. The requests
+// answered with a 2xx status are accepted; the duplicate requests are
+// answered with an empty 200 response.
+func (c *Cache) Handler(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		deduplicated, err := c.Do(r.Context(), r.Header.Get(HeaderIdempotencyKey), func() error {
+			next.ServeHTTP(sw, r)
+			if sw.status >= 300 {
+				return errNotAccepted
+			}
+			return nil
+		})
+		switch {
+		case deduplicated:
+			w.WriteHeader(http.StatusOK)
+		case err != nil && !errors.Is(err, errNotAccepted):
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+	})
+}
+
+var errNotAccepted = errors.New("request not accepted")
+
+// Router routes the requests with an idempotency key to the distributor
+// owning the key, so that the retries of a request are deduplicated by the
+// same distributor. The requests are handled by next if this distributor is
+// the owner, or if the owner can't be reached.
+func (c *Cache) Router(next http.Handler) http.Handler {
+	if c == nil || c.owner == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get(HeaderIdempotencyKey)
+		if idempotencyKey == "" || r.Header.Get(headerForwarded) != "" || isGRPC(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		addr, self, err := c.owner(idempotencyKey)
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "failed to find the owner of the idempotency key", "err", err)
+		}
+		if err != nil || self {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = c.forward(w, r, addr, body); err == nil {
+			c.forwarded.WithLabelValues("success").Inc()
+			return
+		}
+		c.forwarded.WithLabelValues("failure").Inc()
+		level.Warn(c.logger).Log("msg", "failed to route the request to the owner of the idempotency key", "owner", addr, "err", err)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forward sends the request to the distributor, and copies its response.
+// Nothing is written if the request fails.
+func (c *Cache) forward(w http.ResponseWriter, r *http.Request, addr string, body []byte) error {
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, "http://"+addr+r.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Set(headerForwarded, "true")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+	return nil
+}
+
+func isGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
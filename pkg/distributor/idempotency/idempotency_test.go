@@ -0,0 +1,155 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Cache_Do(t *testing.T) {
+	c := New(Config{Window: time.Minute, MaxKeys: 10}, nil, log.NewNopLogger(), prometheus.NewRegistry())
+	ctx := user.InjectOrgID(context.Background(), "tenant")
+
+	var pushed int
+	push := func() error { pushed++; return nil }
+
+	deduplicated, err := c.Do(ctx, "a", push)
+	require.NoError(t, err)
+	assert.False(t, deduplicated)
+	deduplicated, err = c.Do(ctx, "a", push)
+	require.NoError(t, err)
+	assert.True(t, deduplicated)
+	assert.Equal(t, 1, pushed)
+
+	// The keys are per tenant.
+	deduplicated, err = c.Do(user.InjectOrgID(context.Background(), "other"), "a", push)
+	require.NoError(t, err)
+	assert.False(t, deduplicated)
+	// The requests without a key are not deduplicated.
+	_, _ = c.Do(ctx, "", push)
+	_, _ = c.Do(ctx, "", push)
+	assert.Equal(t, 4, pushed)
+
+	// The failed requests can be retried.
+	_, err = c.Do(ctx, "b", func() error { return errors.New("timeout") })
+	require.Error(t, err)
+	deduplicated, err = c.Do(ctx, "b", push)
+	require.NoError(t, err)
+	assert.False(t, deduplicated)
+	assert.Equal(t, 5, pushed)
+
+	// The retries of a request in progress are rejected.
+	_, err = c.Do(ctx, "c", func() error {
+		_, err := c.Do(ctx, "c", push)
+		assert.Equal(t, connect.CodeAborted, connect.CodeOf(err))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, pushed)
+}
+
+func Test_Cache_Expiry(t *testing.T) {
+	c := New(Config{Window: time.Minute, MaxKeys: 1}, nil, log.NewNopLogger(), prometheus.NewRegistry())
+	now := time.Now()
+	a, b := key{"tenant", "a"}, key{"tenant", "b"}
+
+	s, err := c.begin(a, now)
+	require.NoError(t, err)
+	require.Equal(t, recorded, s)
+	c.commit(a, now)
+
+	// The limit is reached.
+	s, _ = c.begin(b, now)
+	assert.Equal(t, untracked, s)
+	s, _ = c.begin(a, now.Add(30*time.Second))
+	assert.Equal(t, duplicate, s)
+
+	// The expired keys are removed.
+	s, _ = c.begin(b, now.Add(2*time.Minute))
+	assert.Equal(t, recorded, s)
+	s, _ = c.begin(a, now.Add(2*time.Minute))
+	assert.Equal(t, untracked, s)
+}
+
+func Test_Cache_Handler(t *testing.T) {
+	c := New(Config{Window: time.Minute}, nil, log.NewNopLogger(), prometheus.NewRegistry())
+	var pushed int
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushed++
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	do := func(target string) int {
+		r := httptest.NewRequest(http.MethodPost, target, nil)
+		r.Header.Set(HeaderIdempotencyKey, "a")
+		r = r.WithContext(user.InjectOrgID(r.Context(), "tenant"))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, do("/ingest?fail=1"))
+	assert.Equal(t, http.StatusOK, do("/ingest"))
+	assert.Equal(t, http.StatusOK, do("/ingest"))
+	assert.Equal(t, 2, pushed)
+}
+
+func Test_Cache_Router(t *testing.T) {
+	var owner, local int
+	ownerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		owner++
+		assert.Equal(t, "true", r.Header.Get(headerForwarded))
+		assert.Equal(t, "/ingest?name=app", r.URL.RequestURI())
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "profile", string(body))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ownerServer.Close()
+	ownerAddr := strings.TrimPrefix(ownerServer.URL, "http://")
+
+	owners := map[string]string{"a": ownerAddr, "b": "self", "c": "127.0.0.1:1"}
+	c := New(Config{Window: time.Minute}, func(key string) (string, bool, error) {
+		return owners[key], owners[key] == "self", nil
+	}, log.NewNopLogger(), prometheus.NewRegistry())
+	h := c.Router(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		local++
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "profile", string(body))
+	}))
+	do := func(key string) int {
+		r := httptest.NewRequest(http.MethodPost, "/ingest?name=app", strings.NewReader("profile"))
+		if key != "" {
+			r.Header.Set(HeaderIdempotencyKey, key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusAccepted, do("a"))
+	assert.Equal(t, 1, owner)
+	assert.Equal(t, 0, local)
+	// The requests without a key, or owned by this distributor, are
+	// handled locally.
+	assert.Equal(t, http.StatusOK, do(""))
+	assert.Equal(t, http.StatusOK, do("b"))
+	assert.Equal(t, 2, local)
+	// The requests are handled locally if the owner can't be reached.
+	assert.Equal(t, http.StatusOK, do("c"))
+	assert.Equal(t, 3, local)
+	assert.Equal(t, 1, owner)
+}
@@ -0,0 +1,184 @@
+package livetail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/grafana/pyroscope/pkg/tenant"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+const (
+	// Path of the live tail API.
+	Path = "/pyroscope/live-tail"
+
+	keepAlivePeriod = 15 * time.Second
+)
+
+// Peers returns the addresses of the other distributors.
+type Peers func() ([]string, error)
+
+type handler struct {
+	hub    *Hub
+	peers  Peers
+	client *http.Client
+	logger log.Logger
+}
+
+// NewHandler returns the handler of the live tail API. The profiles are
+// streamed as server-sent events, e.g.:
+//
+//	GET /pyroscope/live-tail?query={service_name="checkout"}
+//
+//	event: profile
+//	data: {"labels":{...},"timeNanos":...,"profile":"<base64 pprof>"}
+func NewHandler(hub *Hub, peers Peers, logger log.Logger) http.Handler {
+	return &handler{
+		hub:    hub,
+		peers:  peers,
+		client: &http.Client{},
+		logger: logger,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := tenant.ExtractTenantIDFromContext(r.Context())
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeUnauthenticated, err))
+		return
+	}
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		query = "{}"
+	}
+	matchers, err := parser.ParseMetricSelector(query)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	sub, ok := h.hub.Subscribe(tenantID, matchers)
+	if !ok {
+		httputil.Error(w, connect.NewError(connect.CodeResourceExhausted, fmt.Errorf("too many live tail subscribers")))
+		return
+	}
+	defer h.hub.Unsubscribe(sub)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	if local, _ := strconv.ParseBool(r.URL.Query().Get("local")); !local {
+		h.relayPeers(ctx, r, query, sub)
+	}
+
+	// The stream outlives the write timeout of the server.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err = rc.Flush(); err != nil {
+		level.Warn(h.logger).Log("msg", "live tail streaming is not supported", "err", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAlivePeriod)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			_, err = w.Write([]byte(": keep-alive\n\n"))
+		case p := <-sub.C:
+			err = writeEvent(w, p)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, p *Profile) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: profile\ndata: %s\n\n", data)
+	return err
+}
+
+// relayPeers subscribes to the local streams of the other distributors,
+// and sends their profiles to the subscription.
+func (h *handler) relayPeers(ctx context.Context, r *http.Request, query string, sub *Subscription) {
+	if h.peers == nil {
+		return
+	}
+	peers, err := h.peers()
+	if err != nil {
+		level.Warn(h.logger).Log("msg", "failed to list the live tail peers", "err", err)
+		return
+	}
+	params := url.Values{"query": {query}, "local": {"true"}}
+	for _, addr := range peers {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+Path+"?"+params.Encode(), nil)
+		if err != nil {
+			level.Warn(h.logger).Log("msg", "failed to create the live tail request", "peer", addr, "err", err)
+			continue
+		}
+		req.Header.Set(user.OrgIDHeaderName, r.Header.Get(user.OrgIDHeaderName))
+		if authorization := r.Header.Get("Authorization"); authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		go func() {
+			if err := h.relay(ctx, req, sub); err != nil && ctx.Err() == nil {
+				level.Warn(h.logger).Log("msg", "live tail relay failed", "peer", addr, "err", err)
+			}
+		}()
+	}
+}
+
+func (h *handler) relay(ctx context.Context, req *http.Request, sub *Subscription) error {
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20)
+	for scanner.Scan() {
+		data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data: "))
+		if !ok {
+			continue
+		}
+		var p Profile
+		if err = json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		select {
+		case sub.C <- &p:
+		case <-ctx.Done():
+			return nil
+		default:
+			h.hub.metrics.dropped.Inc()
+		}
+	}
+	return scanner.Err()
+}
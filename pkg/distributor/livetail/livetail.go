@@ -0,0 +1,187 @@
+// Package livetail streams the profiles received by the distributors to
+// the subscribers in near real time, e.g., to live flame graph views.
+//
+// Each distributor only publishes the profiles it receives: the handler
+// relays the streams of the other distributors of the ring, so that the
+// subscribers get all the profiles of the tenant.
+package livetail
+
+import (
+	"flag"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+type Config struct {
+	Enabled        bool `yaml:"enabled"`
+	MaxSubscribers int  `yaml:"max_subscribers"`
+	BufferSize     int  `yaml:"buffer_size" category:"advanced"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "distributor.live-tail.enabled", false, "Enable the live tail API, streaming the received profiles matching a selector.")
+	f.IntVar(&cfg.MaxSubscribers, "distributor.live-tail.max-subscribers", 16, "Maximum number of live tail subscribers per distributor. 0 means no limit.")
+	f.IntVar(&cfg.BufferSize, "distributor.live-tail.buffer-size", 64, "Number of profiles buffered per live tail subscriber. The profiles are dropped if the subscriber is slower than the ingestion.")
+}
+
+// Profile is a profile received by a distributor.
+type Profile struct {
+	Labels map[string]string `json:"labels"`
+	// TimeNanos is the time of the profile, in nanoseconds since the epoch.
+	TimeNanos int64 `json:"timeNanos"`
+	// Profile is the pprof profile, not compressed.
+	Profile []byte `json:"profile"`
+}
+
+// Subscription receives the profiles of the tenant matching its matchers.
+type Subscription struct {
+	C chan *Profile
+
+	tenantID string
+	matchers []*labels.Matcher
+}
+
+func (s *Subscription) matches(ls []*typesv1.LabelPair) bool {
+	for _, m := range s.matchers {
+		if !m.Matches(phlaremodel.Labels(ls).Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+type hubMetrics struct {
+	subscribers prometheus.Gauge
+	published   prometheus.Counter
+	dropped     prometheus.Counter
+}
+
+// Hub dispatches the profiles to the subscribers.
+type Hub struct {
+	cfg     Config
+	metrics hubMetrics
+
+	mu          sync.RWMutex
+	count       int
+	subscribers map[string]map[*Subscription]struct{}
+}
+
+func NewHub(cfg Config, reg prometheus.Registerer) *Hub {
+	return &Hub{
+		cfg:         cfg,
+		subscribers: make(map[string]map[*Subscription]struct{}),
+		metrics: hubMetrics{
+			subscribers: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+				Namespace: "pyroscope",
+				Name:      "distributor_live_tail_subscribers",
+				Help:      "The current number of live tail subscribers.",
+			}),
+			published: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+				Namespace: "pyroscope",
+				Name:      "distributor_live_tail_profiles_published_total",
+				Help:      "The total number of profiles sent to the live tail subscribers.",
+			}),
+			dropped: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+				Namespace: "pyroscope",
+				Name:      "distributor_live_tail_profiles_dropped_total",
+				Help:      "The total number of profiles dropped because the live tail subscriber was too slow.",
+			}),
+		},
+	}
+}
+
+// Subscribe returns a subscription to the profiles of the tenant matching
+// the matchers, or false if there are too many subscribers. The
+// subscription must be cancelled with Unsubscribe.
+func (h *Hub) Subscribe(tenantID string, matchers []*labels.Matcher) (*Subscription, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cfg.MaxSubscribers > 0 && h.count >= h.cfg.MaxSubscribers {
+		return nil, false
+	}
+	s := &Subscription{
+		C:        make(chan *Profile, max(h.cfg.BufferSize, 1)),
+		tenantID: tenantID,
+		matchers: matchers,
+	}
+	subs, ok := h.subscribers[tenantID]
+	if !ok {
+		subs = make(map[*Subscription]struct{})
+		h.subscribers[tenantID] = subs
+	}
+	subs[s] = struct{}{}
+	h.count++
+	h.metrics.subscribers.Set(float64(h.count))
+	return s, true
+}
+
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs, ok := h.subscribers[s.tenantID]
+	if !ok {
+		return
+	}
+	if _, ok = subs[s]; !ok {
+		return
+	}
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(h.subscribers, s.tenantID)
+	}
+	h.count--
+	h.metrics.subscribers.Set(float64(h.count))
+}
+
+// HasSubscribers reports whether the tenant has subscribers: the
+// profiles don't need to be published otherwise.
+func (h *Hub) HasSubscribers(tenantID string) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers[tenantID]) > 0
+}
+
+// Publish sends the profile to the matching subscribers of the tenant. The
+// profile is only marshalled if there is a matching subscriber. Publish
+// never blocks: the profile is dropped for the subscribers with a full
+// buffer.
+func (h *Hub) Publish(tenantID string, ls []*typesv1.LabelPair, timeNanos int64, marshal func() ([]byte, error)) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var p *Profile
+	for s := range h.subscribers[tenantID] {
+		if !s.matches(ls) {
+			continue
+		}
+		if p == nil {
+			b, err := marshal()
+			if err != nil {
+				return err
+			}
+			p = &Profile{
+				Labels:    phlaremodel.Labels(ls).ToPrometheusLabels().Map(),
+				TimeNanos: timeNanos,
+				Profile:   b,
+			}
+		}
+		select {
+		case s.C <- p:
+			h.metrics.published.Inc()
+		default:
+			h.metrics.dropped.Inc()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package livetail
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+func marshal(b string) func() ([]byte, error) {
+	return func() ([]byte, error) { return []byte(b), nil }
+}
+
+func Test_Hub_Publish(t *testing.T) {
+	hub := NewHub(Config{MaxSubscribers: 2, BufferSize: 1}, prometheus.NewRegistry())
+	matchers, err := parser.ParseMetricSelector(`{service_name="checkout"}`)
+	require.NoError(t, err)
+	sub, ok := hub.Subscribe("tenant-a", matchers)
+	require.True(t, ok)
+	all, ok := hub.Subscribe("tenant-a", nil)
+	require.True(t, ok)
+	_, ok = hub.Subscribe("tenant-b", nil)
+	assert.False(t, ok, "too many subscribers")
+
+	assert.True(t, hub.HasSubscribers("tenant-a"))
+	assert.False(t, hub.HasSubscribers("tenant-b"))
+
+	checkout := phlaremodel.LabelsFromStrings("service_name", "checkout")
+	cart := phlaremodel.LabelsFromStrings("service_name", "cart")
+	require.NoError(t, hub.Publish("tenant-a", cart, 1, marshal("cart")))
+	require.NoError(t, hub.Publish("tenant-a", checkout, 2, marshal("checkout")))
+	require.NoError(t, hub.Publish("tenant-b", checkout, 3, marshal("other")))
+
+	p := <-sub.C
+	assert.Equal(t, "checkout", string(p.Profile))
+	assert.Equal(t, map[string]string{"service_name": "checkout"}, p.Labels)
+	assert.Len(t, sub.C, 0)
+	// The buffer of the subscriber is full: the second profile is dropped.
+	p = <-all.C
+	assert.Equal(t, "cart", string(p.Profile))
+	assert.Len(t, all.C, 0)
+
+	hub.Unsubscribe(sub)
+	hub.Unsubscribe(all)
+	assert.False(t, hub.HasSubscribers("tenant-a"))
+	_, ok = hub.Subscribe("tenant-b", nil)
+	assert.True(t, ok)
+}
+
+func Test_Handler(t *testing.T) {
+	hub := NewHub(Config{BufferSize: 8}, prometheus.NewRegistry())
+	h := NewHandler(hub, nil, log.NewNopLogger())
+	ctx, cancel := context.WithCancel(tenant.InjectTenantID(context.Background(), "tenant-a"))
+	defer cancel()
+
+	pr, pw := newPipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodGet, Path+`?query={service_name="checkout"}`, nil).WithContext(ctx)
+		h.ServeHTTP(pw, req)
+	}()
+
+	require.Eventually(t, func() bool { return hub.HasSubscribers("tenant-a") }, time.Second, 10*time.Millisecond)
+	require.NoError(t, hub.Publish("tenant-a", phlaremodel.LabelsFromStrings("service_name", "checkout"), 42, marshal("profile")))
+
+	var event, data string
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() && data == "" {
+		line := scanner.Text()
+		if v, ok := strings.CutPrefix(line, "event: "); ok {
+			event = v
+		} else if v, ok = strings.CutPrefix(line, "data: "); ok {
+			data = v
+		}
+	}
+	assert.Equal(t, "profile", event)
+	var p Profile
+	require.NoError(t, json.Unmarshal([]byte(data), &p))
+	assert.Equal(t, int64(42), p.TimeNanos)
+	assert.Equal(t, "profile", string(p.Profile))
+
+	cancel()
+	<-done
+	assert.False(t, hub.HasSubscribers("tenant-a"))
+}
+
+func Test_Handler_InvalidQuery(t *testing.T) {
+	h := NewHandler(NewHub(Config{}, prometheus.NewRegistry()), nil, log.NewNopLogger())
+	req := httptest.NewRequest(http.MethodGet, Path+"?query={", nil)
+	req = req.WithContext(tenant.InjectTenantID(req.Context(), "tenant-a"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// pipeWriter is a streaming http.ResponseWriter.
+type pipeWriter struct {
+	*io.PipeWriter
+	header http.Header
+}
+
+func newPipe() (*io.PipeReader, *pipeWriter) {
+	r, w := io.Pipe()
+	return r, &pipeWriter{PipeWriter: w, header: make(http.Header)}
+}
+
+func (w *pipeWriter) Header() http.Header { return w.header }
+func (w *pipeWriter) WriteHeader(int)     {}
+func (w *pipeWriter) Flush()              {}
@@ -2,6 +2,9 @@ package model
 
 import (
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 
 	v1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	"github.com/grafana/pyroscope/pkg/distributor/ingest_limits"
@@ -110,6 +113,40 @@ func (req *PushRequest) ClearAnnotations() {
 	}
 }
 
+// ProfileAnnotationKeyResourceAttributePrefix is the prefix of the keys of
+// the annotations holding the resource attributes of the profiles.
+const ProfileAnnotationKeyResourceAttributePrefix = "pyroscope.resource."
+
+// ExtractResourceAttributes moves the resource attributes labels of the
+// series to the profile annotations, which are not indexed: the attributes
+// do not create series. The attributes listed in indexed are also kept as
+// series labels, named without the prefix, to be used in query selectors.
+func (req *PushRequest) ExtractResourceAttributes(indexed []string) {
+	for _, series := range req.Series {
+		labels := series.Labels[:0]
+		var renamed bool
+		for _, l := range series.Labels {
+			name, ok := strings.CutPrefix(l.Name, phlaremodel.LabelNameResourceAttributePrefix)
+			if !ok || name == "" {
+				labels = append(labels, l)
+				continue
+			}
+			series.Annotations = append(series.Annotations, &v1.ProfileAnnotation{
+				Key:   ProfileAnnotationKeyResourceAttributePrefix + name,
+				Value: l.Value,
+			})
+			if slices.Contains(indexed, name) {
+				labels = append(labels, &v1.LabelPair{Name: name, Value: l.Value})
+				renamed = true
+			}
+		}
+		series.Labels = labels
+		if renamed {
+			sort.Sort(phlaremodel.Labels(series.Labels))
+		}
+	}
+}
+
 func (req *PushRequest) MarkThrottledTenant(l *ingest_limits.Config) error {
 	if l == nil {
 		return fmt.Errorf("no limit config provided")
@@ -223,3 +223,29 @@ func TestMarkThrottledUsageGroup(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractResourceAttributes(t *testing.T) {
+	req := &PushRequest{
+		Series: []*ProfileSeries{{
+			Labels: []*typesv1.LabelPair{
+				{Name: "__name__", Value: "process_cpu"},
+				{Name: "__resource_agent_version", Value: "v1.2.3"},
+				{Name: "__resource_host_kernel_release", Value: "6.8.0"},
+				{Name: "service_name", Value: "svc"},
+			},
+			Annotations: []*typesv1.ProfileAnnotation{{Key: "foo", Value: "bar"}},
+		}},
+	}
+	req.ExtractResourceAttributes([]string{"host_kernel_release"})
+
+	assert.Equal(t, []*typesv1.LabelPair{
+		{Name: "__name__", Value: "process_cpu"},
+		{Name: "host_kernel_release", Value: "6.8.0"},
+		{Name: "service_name", Value: "svc"},
+	}, req.Series[0].Labels)
+	assert.Equal(t, []*typesv1.ProfileAnnotation{
+		{Key: "foo", Value: "bar"},
+		{Key: "pyroscope.resource.agent_version", Value: "v1.2.3"},
+		{Key: "pyroscope.resource.host_kernel_release", Value: "6.8.0"},
+	}, req.Series[0].Annotations)
+}
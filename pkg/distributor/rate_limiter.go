@@ -0,0 +1,78 @@
+package distributor
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/grafana/dskit/limiter"
+)
+
+// rateLimiter is a per-tenant rate limiter, like limiter.RateLimiter, whose
+// tokens are reserved: a request checked against several limits gives the
+// tokens back to the limits it passed if another limit rejects it.
+type rateLimiter struct {
+	strategy      limiter.RateLimiterStrategy
+	recheckPeriod time.Duration
+
+	mu      sync.Mutex
+	tenants map[string]*tenantRateLimiter
+}
+
+type tenantRateLimiter struct {
+	limiter   *rate.Limiter
+	recheckAt time.Time
+}
+
+// newRateLimiter creates a rate limiter; the limits of the tenants are
+// updated from the strategy every recheckPeriod.
+func newRateLimiter(strategy limiter.RateLimiterStrategy, recheckPeriod time.Duration) *rateLimiter {
+	return &rateLimiter{
+		strategy:      strategy,
+		recheckPeriod: recheckPeriod,
+		tenants:       make(map[string]*tenantRateLimiter),
+	}
+}
+
+// reserve takes n tokens of the tenant, if they are available now. The
+// returned reservation must be canceled if the request is rejected later.
+func (l *rateLimiter) reserve(now time.Time, tenantID string, n int) (*rate.Reservation, bool) {
+	lim := l.tenantLimiter(now, tenantID)
+	// A canceled reservation which had to wait leaves the limiter with a
+	// last event in the future, and the tokens of the reservations canceled
+	// afterwards are only partially given back: the requests which would
+	// have to wait are rejected before reserving.
+	if lim.Limit() != rate.Inf && lim.TokensAt(now) < float64(n) {
+		return nil, false
+	}
+	r := lim.ReserveN(now, n)
+	if !r.OK() {
+		return nil, false
+	}
+	if r.DelayFrom(now) > 0 {
+		r.CancelAt(now)
+		return nil, false
+	}
+	return r, true
+}
+
+func (l *rateLimiter) tenantLimiter(now time.Time, tenantID string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t, ok := l.tenants[tenantID]
+	if !ok {
+		t = &tenantRateLimiter{
+			limiter:   rate.NewLimiter(rate.Limit(l.strategy.Limit(tenantID)), l.strategy.Burst(tenantID)),
+			recheckAt: now.Add(l.recheckPeriod),
+		}
+		l.tenants[tenantID] = t
+		return t.limiter
+	}
+	if now.After(t.recheckAt) {
+		t.limiter.SetLimitAt(now, rate.Limit(l.strategy.Limit(tenantID)))
+		t.limiter.SetBurstAt(now, l.strategy.Burst(tenantID))
+		t.recheckAt = now.Add(l.recheckPeriod)
+	}
+	return t.limiter
+}
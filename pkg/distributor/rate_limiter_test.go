@@ -0,0 +1,35 @@
+package distributor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticRateStrategy struct {
+	limit float64
+	burst int
+}
+
+func (s staticRateStrategy) Limit(string) float64 { return s.limit }
+func (s staticRateStrategy) Burst(string) int     { return s.burst }
+
+func Test_rateLimiter_reserve(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(staticRateStrategy{limit: 1, burst: 10}, time.Minute)
+
+	r, ok := l.reserve(now, "tenant", 8)
+	require.True(t, ok)
+	_, ok = l.reserve(now, "tenant", 8)
+	assert.False(t, ok, "the rejected reservation must not take the tokens")
+	_, ok = l.reserve(now, "other", 8)
+	assert.True(t, ok)
+
+	r.CancelAt(now)
+	_, ok = l.reserve(now, "tenant", 10)
+	assert.True(t, ok, "the canceled tokens must be given back")
+	_, ok = l.reserve(now, "tenant", 11)
+	assert.False(t, ok)
+}
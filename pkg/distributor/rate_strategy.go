@@ -6,6 +6,8 @@
 package distributor
 
 import (
+	"math"
+
 	"golang.org/x/time/rate"
 
 	"github.com/grafana/dskit/limiter"
@@ -70,6 +72,33 @@ func (s *ingestionRateStrategy) Burst(tenantID string) int {
 	return s.limits.IngestionBurstSizeBytes(tenantID)
 }
 
+type profilesRateStrategy struct {
+	limits Limits
+}
+
+// newProfilesRateStrategy limits the number of profiles ingested per second.
+// The limit is disabled if the tenant rate is not positive.
+func newProfilesRateStrategy(limits Limits) limiter.RateLimiterStrategy {
+	return &profilesRateStrategy{
+		limits: limits,
+	}
+}
+
+func (s *profilesRateStrategy) Limit(tenantID string) float64 {
+	if limit := s.limits.IngestionRateProfiles(tenantID); limit > 0 {
+		return limit
+	}
+	return float64(rate.Inf)
+}
+
+func (s *profilesRateStrategy) Burst(tenantID string) int {
+	if burst := s.limits.IngestionBurstSizeProfiles(tenantID); burst > 0 {
+		return burst
+	}
+	// Burst is ignored when limit = rate.Inf
+	return int(math.Ceil(s.limits.IngestionRateProfiles(tenantID)))
+}
+
 type infiniteStrategy struct{}
 
 func newInfiniteRateStrategy() limiter.RateLimiterStrategy {
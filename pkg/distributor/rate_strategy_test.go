@@ -33,6 +33,40 @@ func TestIngestionRateStrategy(t *testing.T) {
 		assert.Equal(t, strategy.Burst("test"), 10000*1024*1024)
 	})
 
+	t.Run("profiles rate limiter should share the limit across the number of distributors", func(t *testing.T) {
+		overrides, err := validation.NewOverrides(validation.Limits{
+			IngestionRateProfiles:      100,
+			IngestionBurstSizeProfiles: 500,
+		}, nil)
+		require.NoError(t, err)
+
+		mockRing := newReadLifecyclerMock()
+		mockRing.On("HealthyInstancesCount").Return(2)
+
+		strategy := newGlobalRateStrategy(newProfilesRateStrategy(overrides), mockRing)
+		assert.Equal(t, strategy.Limit("test"), float64(50))
+		assert.Equal(t, strategy.Burst("test"), 500)
+	})
+
+	t.Run("profiles rate limiter should default the burst to the limit", func(t *testing.T) {
+		overrides, err := validation.NewOverrides(validation.Limits{
+			IngestionRateProfiles: 10.5,
+		}, nil)
+		require.NoError(t, err)
+
+		strategy := newProfilesRateStrategy(overrides)
+		assert.Equal(t, strategy.Limit("test"), 10.5)
+		assert.Equal(t, strategy.Burst("test"), 11)
+	})
+
+	t.Run("profiles rate limiter should be disabled by default", func(t *testing.T) {
+		overrides, err := validation.NewOverrides(validation.Limits{}, nil)
+		require.NoError(t, err)
+
+		strategy := newProfilesRateStrategy(overrides)
+		assert.Equal(t, strategy.Limit("test"), float64(rate.Inf))
+	})
+
 	t.Run("infinite rate limiter should return unlimited settings", func(t *testing.T) {
 		strategy := newInfiniteRateStrategy()
 
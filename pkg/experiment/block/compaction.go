@@ -52,6 +52,14 @@ func WithCompactionDestination(storage objstore.Bucket) CompactionOption {
 	}
 }
 
+// WithCompactionTenantConfigProvider sets the provider of the S3 SSE config
+// of the tenants, used to upload the compacted blocks.
+func WithCompactionTenantConfigProvider(cfgProvider objstore.TenantConfigProvider) CompactionOption {
+	return func(p *compactionConfig) {
+		p.tenantConfig = cfgProvider
+	}
+}
+
 func WithSampleObserver(observer SampleObserver) CompactionOption {
 	return func(p *compactionConfig) {
 		p.sampleObserver = observer
@@ -64,6 +72,7 @@ type compactionConfig struct {
 	destination    objstore.Bucket
 	tempdir        string
 	sampleObserver SampleObserver
+	tenantConfig   objstore.TenantConfigProvider
 }
 
 type SampleObserver interface {
@@ -105,7 +114,12 @@ func Compact(
 
 	compacted := make([]*metastorev1.BlockMeta, 0, len(plan))
 	for _, p := range plan {
-		md, compactionErr := p.Compact(ctx, c.destination, c.tempdir, c.sampleObserver)
+		// The compacted blocks hold the data of a single tenant.
+		uploadCtx, err := objstore.ContextWithTenantSSEConfig(ctx, p.tenant, c.tenantConfig)
+		if err != nil {
+			return nil, err
+		}
+		md, compactionErr := p.Compact(uploadCtx, c.destination, c.tempdir, c.sampleObserver)
 		if compactionErr != nil {
 			return nil, compactionErr
 		}
@@ -54,6 +54,8 @@ type Worker struct {
 
 	exporter metrics.Exporter
 	ruler    metrics.Ruler
+
+	cfgProvider objstore.TenantConfigProvider
 }
 
 type Config struct {
@@ -112,6 +114,7 @@ func New(
 	reg prometheus.Registerer,
 	ruler metrics.Ruler,
 	exporter metrics.Exporter,
+	cfgProvider objstore.TenantConfigProvider,
 ) (*Worker, error) {
 	config.TempDir = filepath.Join(filepath.Clean(config.TempDir), "pyroscope-compactor")
 	_ = os.RemoveAll(config.TempDir)
@@ -126,6 +129,8 @@ func New(
 		metrics:  newMetrics(reg),
 		ruler:    ruler,
 		exporter: exporter,
+
+		cfgProvider: cfgProvider,
 	}
 	w.threads = config.JobConcurrency
 	if w.threads < 1 {
@@ -415,6 +420,7 @@ func (w *Worker) runCompaction(job *compactionJob) {
 	sourcedir := filepath.Join(tempdir, "source")
 	options := []block.CompactionOption{
 		block.WithCompactionTempDir(tempdir),
+		block.WithCompactionTenantConfigProvider(w.cfgProvider),
 		block.WithCompactionObjectOptions(
 			block.WithObjectMaxSizeLoadInMemory(w.config.SmallObjectSize),
 			block.WithObjectDownload(sourcedir),
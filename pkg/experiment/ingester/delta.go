@@ -0,0 +1,230 @@
+package ingester
+
+import (
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	prommodel "github.com/prometheus/common/model"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	"github.com/grafana/pyroscope/pkg/model"
+)
+
+const (
+	memoryProfileName = "memory"
+	// deltaSeriesTTL is the time after which the cumulative values of a
+	// series that is not ingested anymore are removed.
+	deltaSeriesTTL = time.Hour
+)
+
+// deltaProfiles computes the deltas of the cumulative sample types of the
+// memory profiles marked with the __delta__="true" label, such as the
+// allocations, as the ingesters do. Unlike the segment heads, the state
+// outlives the segments: the deltas are exact as long as the profiles of a
+// series are always ingested by the same segment writer.
+type deltaProfiles struct {
+	mu          sync.Mutex
+	series      map[deltaSeriesKey]*deltaSeries
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+type deltaSeriesKey struct {
+	tenant string
+	labels uint64
+}
+
+type deltaSeries struct {
+	updated time.Time
+	// The highest values of the cumulative sample types by stack.
+	values map[uint64][]int64
+}
+
+func newDeltaProfiles() *deltaProfiles {
+	return &deltaProfiles{
+		series:      make(map[deltaSeriesKey]*deltaSeries),
+		lastCleanup: time.Now(),
+		now:         time.Now,
+	}
+}
+
+// computeDelta replaces in place the cumulative values of the samples with
+// their difference to the previous profile of the series. The values of the
+// first profile of a series, and of the profiles following a reset, are
+// zeroed, as the delta can't be computed.
+func (d *deltaProfiles) computeDelta(tenantID string, labels model.Labels, p *profilev1.Profile, samples []*profilev1.Sample) {
+	if d == nil || !isDeltaSupported(labels) {
+		return
+	}
+	cumulative := cumulativeSampleTypes(p)
+	if len(cumulative) == 0 {
+		return
+	}
+
+	keys := make([]uint64, len(samples))
+	current := make(map[uint64][]int64, len(samples))
+	h := newStackHasher(p)
+	for i, s := range samples {
+		keys[i] = h.hash(s.LocationId)
+		values, ok := current[keys[i]]
+		if !ok {
+			values = make([]int64, len(cumulative))
+			current[keys[i]] = values
+		}
+		for j, idx := range cumulative {
+			values[j] += s.Value[idx]
+		}
+	}
+
+	ls := labels.Clone()
+	sort.Sort(ls)
+	k := deltaSeriesKey{tenant: tenantID, labels: ls.Hash()}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	d.removeStale(now)
+
+	s, ok := d.series[k]
+	reset := !ok
+	if ok {
+		for key, values := range current {
+			if isReset(s.values[key], values) {
+				reset = true
+				break
+			}
+		}
+	}
+	if reset {
+		// If we don't have the last profile, or the values were reset, we
+		// can't compute the delta.
+		d.series[k] = &deltaSeries{updated: now, values: current}
+		for _, sample := range samples {
+			for _, idx := range cumulative {
+				sample.Value[idx] = 0
+			}
+		}
+		return
+	}
+
+	// The stacks present several times in the profile get the delta of
+	// their sum in their first sample.
+	seen := make(map[uint64]struct{}, len(current))
+	for i, sample := range samples {
+		if _, dup := seen[keys[i]]; dup {
+			for _, idx := range cumulative {
+				sample.Value[idx] = 0
+			}
+			continue
+		}
+		seen[keys[i]] = struct{}{}
+		last := s.values[keys[i]]
+		values := current[keys[i]]
+		for j, idx := range cumulative {
+			sample.Value[idx] = values[j]
+			if last != nil {
+				sample.Value[idx] -= last[j]
+			}
+		}
+	}
+	// The stacks absent from the profile keep their last values.
+	for key, values := range current {
+		s.values[key] = values
+	}
+	s.updated = now
+}
+
+func (d *deltaProfiles) removeStale(now time.Time) {
+	if now.Sub(d.lastCleanup) < deltaSeriesTTL {
+		return
+	}
+	for k, s := range d.series {
+		if now.Sub(s.updated) >= deltaSeriesTTL {
+			delete(d.series, k)
+		}
+	}
+	d.lastCleanup = now
+}
+
+func isDeltaSupported(labels model.Labels) bool {
+	return labels.Get(model.LabelNameDelta) == "true" &&
+		labels.Get(prommodel.MetricNameLabel) == memoryProfileName
+}
+
+func cumulativeSampleTypes(p *profilev1.Profile) []int {
+	var cumulative []int
+	for i, st := range p.SampleType {
+		if st.Type >= 0 && st.Type < int64(len(p.StringTable)) &&
+			model.ProfileTypes.IsCumulative(p.StringTable[st.Type]) {
+			cumulative = append(cumulative, i)
+		}
+	}
+	return cumulative
+}
+
+func isReset(last, values []int64) bool {
+	for j := range last {
+		if values[j] < last[j] {
+			return true
+		}
+	}
+	return false
+}
+
+// stackHasher hashes the stacks of the samples by their symbols: the
+// location IDs are not stable across the profiles of a series.
+type stackHasher struct {
+	p         *profilev1.Profile
+	locations map[uint64]*profilev1.Location
+	functions map[uint64]*profilev1.Function
+	buf       []byte
+}
+
+func newStackHasher(p *profilev1.Profile) *stackHasher {
+	h := &stackHasher{
+		p:         p,
+		locations: make(map[uint64]*profilev1.Location, len(p.Location)),
+		functions: make(map[uint64]*profilev1.Function, len(p.Function)),
+	}
+	for _, loc := range p.Location {
+		h.locations[loc.Id] = loc
+	}
+	for _, fn := range p.Function {
+		h.functions[fn.Id] = fn
+	}
+	return h
+}
+
+func (h *stackHasher) hash(locationIDs []uint64) uint64 {
+	h.buf = h.buf[:0]
+	for _, id := range locationIDs {
+		loc, ok := h.locations[id]
+		if !ok {
+			continue
+		}
+		if len(loc.Line) == 0 {
+			h.buf = append(h.buf, 0)
+			h.buf = binary.LittleEndian.AppendUint64(h.buf, loc.Address)
+			continue
+		}
+		for _, line := range loc.Line {
+			h.buf = append(h.buf, 1)
+			if fn, ok := h.functions[line.FunctionId]; ok {
+				h.buf = append(h.buf, h.str(fn.Name)...)
+			}
+			h.buf = append(h.buf, 0)
+			h.buf = binary.LittleEndian.AppendUint64(h.buf, uint64(line.Line))
+		}
+	}
+	return xxhash.Sum64(h.buf)
+}
+
+func (h *stackHasher) str(i int64) string {
+	if i < 0 || i >= int64(len(h.p.StringTable)) {
+		return ""
+	}
+	return h.p.StringTable[i]
+}
@@ -0,0 +1,135 @@
+package ingester
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	"github.com/grafana/pyroscope/pkg/model"
+	pprofth "github.com/grafana/pyroscope/pkg/pprof/testhelper"
+)
+
+func deltaTestProfile(delta string) *pprofth.ProfileBuilder {
+	return pprofth.NewProfileBuilder(0).
+		MemoryProfile().
+		WithLabels(model.LabelNameServiceName, "svc", model.LabelNameDelta, delta)
+}
+
+func sampleValues(p *profilev1.Profile) [][]int64 {
+	values := make([][]int64, 0, len(p.Sample))
+	for _, s := range p.Sample {
+		values = append(values, s.Value)
+	}
+	return values
+}
+
+func computeDelta(d *deltaProfiles, tenantID string, p *pprofth.ProfileBuilder) [][]int64 {
+	d.computeDelta(tenantID, p.Labels, p.Profile, p.Profile.Sample)
+	return sampleValues(p.Profile)
+}
+
+func Test_DeltaProfiles(t *testing.T) {
+	d := newDeltaProfiles()
+
+	p := deltaTestProfile("true").
+		ForStacktraceString("foo", "bar").AddSamples(1, 1024, 1, 1024).
+		ForStacktraceString("baz").AddSamples(2, 2048, 2, 2048)
+	assert.Equal(t, [][]int64{
+		{0, 0, 1, 1024},
+		{0, 0, 2, 2048},
+	}, computeDelta(d, "t1", p), "the first profile of a series has no delta")
+
+	// The stacks are matched by their symbols, not their location IDs.
+	p = deltaTestProfile("true").
+		ForStacktraceString("qux").AddSamples(1, 1024, 1, 1024).
+		ForStacktraceString("baz").AddSamples(3, 3072, 1, 1024).
+		ForStacktraceString("foo", "bar").AddSamples(4, 4096, 0, 0).
+		ForStacktraceString("foo", "bar").AddSamples(1, 1024, 1, 1024)
+	assert.Equal(t, [][]int64{
+		{1, 1024, 1, 1024},
+		{1, 1024, 1, 1024},
+		{4, 4096, 0, 0},
+		{0, 0, 1, 1024},
+	}, computeDelta(d, "t1", p))
+
+	// The stacks absent from the previous profile keep their last values.
+	p = deltaTestProfile("true").
+		ForStacktraceString("foo", "bar").AddSamples(6, 6144, 0, 0)
+	assert.Equal(t, [][]int64{
+		{1, 1024, 0, 0},
+	}, computeDelta(d, "t1", p))
+
+	p = deltaTestProfile("true").
+		ForStacktraceString("baz").AddSamples(2, 2048, 1, 1024)
+	assert.Equal(t, [][]int64{
+		{0, 0, 1, 1024},
+	}, computeDelta(d, "t1", p), "a reset has no delta")
+
+	p = deltaTestProfile("true").
+		ForStacktraceString("baz").AddSamples(5, 5120, 1, 1024)
+	assert.Equal(t, [][]int64{
+		{3, 3072, 1, 1024},
+	}, computeDelta(d, "t1", p))
+
+	p = deltaTestProfile("true").
+		ForStacktraceString("baz").AddSamples(5, 5120, 1, 1024)
+	assert.Equal(t, [][]int64{
+		{0, 0, 1, 1024},
+	}, computeDelta(d, "t2", p), "the series of the tenants are distinct")
+}
+
+func Test_DeltaProfiles_Unmarked(t *testing.T) {
+	for _, delta := range []string{"", "false"} {
+		d := newDeltaProfiles()
+		for i := 0; i < 2; i++ {
+			p := deltaTestProfile(delta).
+				ForStacktraceString("foo", "bar").AddSamples(1, 1024, 1, 1024)
+			if delta == "" {
+				p.Labels = model.Labels(p.Labels).Delete(model.LabelNameDelta)
+			}
+			assert.Equal(t, [][]int64{{1, 1024, 1, 1024}}, computeDelta(d, "t1", p))
+		}
+		assert.Empty(t, d.series)
+	}
+
+	p := pprofth.NewProfileBuilder(0).
+		CPUProfile().
+		WithLabels(model.LabelNameServiceName, "svc", model.LabelNameDelta, "true").
+		ForStacktraceString("foo", "bar").AddSamples(1)
+	var d *deltaProfiles
+	assert.Equal(t, [][]int64{{1}}, computeDelta(d, "t1", p))
+	d = newDeltaProfiles()
+	assert.Equal(t, [][]int64{{1}}, computeDelta(d, "t1", p))
+	assert.Empty(t, d.series)
+}
+
+func Test_DeltaProfiles_RemoveStale(t *testing.T) {
+	now := time.Unix(0, 0)
+	d := newDeltaProfiles()
+	d.now = func() time.Time { return now }
+	d.lastCleanup = now
+
+	for _, svc := range []string{"svc1", "svc2"} {
+		p := deltaTestProfile("true").
+			WithLabels(model.LabelNameServiceName, svc).
+			ForStacktraceString("foo").AddSamples(1, 1024, 1, 1024)
+		computeDelta(d, "t1", p)
+	}
+	assert.Len(t, d.series, 2)
+
+	now = now.Add(deltaSeriesTTL / 2)
+	p := deltaTestProfile("true").
+		WithLabels(model.LabelNameServiceName, "svc1").
+		ForStacktraceString("foo").AddSamples(2, 2048, 1, 1024)
+	assert.Equal(t, [][]int64{{1, 1024, 1, 1024}}, computeDelta(d, "t1", p))
+	assert.Len(t, d.series, 2)
+
+	now = now.Add(deltaSeriesTTL / 2)
+	p = deltaTestProfile("true").
+		WithLabels(model.LabelNameServiceName, "svc1").
+		ForStacktraceString("foo").AddSamples(3, 3072, 1, 1024)
+	assert.Equal(t, [][]int64{{1, 1024, 1, 1024}}, computeDelta(d, "t1", p))
+	assert.Len(t, d.series, 1, "the series not ingested for an hour are removed")
+}
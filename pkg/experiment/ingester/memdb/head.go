@@ -65,7 +65,7 @@ func (h *Head) Ingest(p *profilev1.Profile, id uuid.UUID, externalLabels []*type
 		return
 	}
 
-	// Delta not supported.
+	// The deltas are computed by the segment writer.
 	externalLabels = phlaremodel.Labels(externalLabels).Delete(phlaremodel.LabelNameDelta)
 	// Label order is enforced to ensure that __profile_type__ and __service_name__ always
 	// come first in the label set. This is important for spatial locality: profiles are
@@ -182,7 +182,8 @@ func HasUnsymbolizedProfiles(symbols *symdb.Symbols) bool {
 	locations := symbols.Locations
 	mappings := symbols.Mappings
 	for _, loc := range locations {
-		if !mappings[loc.MappingId].HasFunctions {
+		// The locations symbolized by the agents have lines.
+		if len(loc.Line) == 0 && !mappings[loc.MappingId].HasFunctions {
 			return true
 		}
 	}
@@ -55,6 +55,8 @@ type segmentsWriter struct {
 	metrics             *segmentMetrics
 	headMetrics         *memdb.HeadMetrics
 	hedgedUploadLimiter *rate.Limiter
+
+	delta *deltaProfiles
 }
 
 type shard struct {
@@ -137,6 +139,7 @@ func newSegmentWriter(l log.Logger, metrics *segmentMetrics, hm *memdb.HeadMetri
 		bucket:      bucket,
 		shards:      make(map[shardKey]*shard),
 		metastore:   metastoreClient,
+		delta:       newDeltaProfiles(),
 	}
 	sw.hedgedUploadLimiter = rate.NewLimiter(rate.Limit(sw.config.UploadHedgeRateMax), int(sw.config.UploadHedgeRateBurst))
 	sw.ctx, sw.cancel = context.WithCancel(context.Background())
@@ -522,7 +525,14 @@ func (s *segment) ingest(tenantID string, p *profilev1.Profile, id uuid.UUID, la
 	//   worth it.
 	serviceName := model.Labels(labels).Get(model.LabelNameServiceName)
 	ds := s.datasetForIngest(datasetKey{tenant: tenantID, service: serviceName})
-	appender := &sampleAppender{dataset: ds, profile: p, id: id, annotations: annotations}
+	appender := &sampleAppender{
+		tenantID:    tenantID,
+		dataset:     ds,
+		delta:       s.sw.delta,
+		profile:     p,
+		id:          id,
+		annotations: annotations,
+	}
 	// Relabeling rules cannot be applied here: it should be done before the
 	// ingestion, in distributors. Otherwise, it may change the distribution
 	// key, including the "service_name" label, which we use to determine the
@@ -534,17 +544,21 @@ func (s *segment) ingest(tenantID string, p *profilev1.Profile, id uuid.UUID, la
 
 type sampleAppender struct {
 	id          uuid.UUID
+	tenantID    string
 	dataset     *memdb.Head
+	delta       *deltaProfiles
 	profile     *profilev1.Profile
 	exporter    *pprofmodel.SampleExporter
 	annotations []*typesv1.ProfileAnnotation
 }
 
 func (v *sampleAppender) VisitProfile(labels model.Labels) {
+	v.delta.computeDelta(v.tenantID, labels, v.profile, v.profile.Sample)
 	v.dataset.Ingest(v.profile, v.id, labels, v.annotations)
 }
 
 func (v *sampleAppender) VisitSampleSeries(labels model.Labels, samples []*profilev1.Sample) {
+	v.delta.computeDelta(v.tenantID, labels, v.profile, samples)
 	if v.exporter == nil {
 		v.exporter = pprofmodel.NewSampleExporter(v.profile)
 	}
@@ -553,6 +567,8 @@ func (v *sampleAppender) VisitSampleSeries(labels model.Labels, samples []*profi
 	v.dataset.Ingest(&n, v.id, labels, v.annotations)
 }
 
+func (v *sampleAppender) SanitizeLabels(*model.LabelsBuilder) {}
+
 func (v *sampleAppender) ValidateLabels(model.Labels) error { return nil }
 
 func (v *sampleAppender) Discarded(_, _ int) {}
@@ -367,6 +367,55 @@ func TestQueryMultipleSeriesSingleTenant(t *testing.T) {
 	require.Equal(t, expectedCollapsed, actualCollapsed)
 }
 
+func TestSegmentIngestCumulativeDeltas(t *testing.T) {
+	metas := make(chan *metastorev1.BlockMeta, 1)
+
+	sw := newTestSegmentWriter(t, defaultTestConfig())
+	defer sw.stop()
+	sw.client.On("AddBlock", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			metas <- args.Get(1).(*metastorev1.AddBlockRequest).Block
+		}).Return(new(metastorev1.AddBlockResponse), nil)
+
+	// The cumulative values of a series are kept across the segments.
+	var block *metastorev1.BlockMeta
+	for _, p := range []*pprofth.ProfileBuilder{
+		memProfile(1, 100, "svc1", "foo", "bar"),
+		memProfile(3, 200, "svc1", "foo", "bar"),
+	} {
+		p.WithLabels(model.LabelNameDelta, "true")
+		sw.ingestChunk(t, inputChunk{{shard: 1, tenant: "t1", profile: p}}, false)
+		block = <-metas
+	}
+
+	clients := sw.createBlocksFromMetas([]*metastorev1.BlockMeta{block})
+	defer func() {
+		for _, tc := range clients {
+			tc.f()
+		}
+	}()
+
+	for _, tc := range []struct {
+		profileType string
+		expected    int64
+	}{
+		{profileType: "memory:alloc_space:bytes:space:bytes", expected: 2 * 1024},
+		{profileType: "memory:inuse_space:bytes:space:bytes", expected: 3 * 1024},
+	} {
+		actual := sw.query(clients["t1"], &ingesterv1.SelectProfilesRequest{
+			LabelSelector: "{service_name=\"svc1\"}",
+			Type:          mustParseProfileSelector(t, tc.profileType),
+			Start:         200,
+			End:           201,
+		})
+		var total int64
+		for _, s := range actual.Sample {
+			total += s.Value[0]
+		}
+		assert.Equal(t, tc.expected, total, tc.profileType)
+	}
+}
+
 func TestDLQRecoveryMock(t *testing.T) {
 	chunk := inputChunk([]input{
 		{shard: 1, tenant: "tb", profile: cpuProfile(42, 239, "svc1", "kek", "foo", "bar")},
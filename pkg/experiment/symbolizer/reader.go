@@ -2,6 +2,7 @@ package symbolizer
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 
@@ -80,8 +81,22 @@ func (m *memoryBuffer) Bytes() []byte {
 	return m.data
 }
 
-// detectCompression checks if data is compressed and decompresses it if needed
-func detectCompression(data []byte) ([]byte, error) {
+var errDecompressedSizeLimit = errors.New("decompressed data exceeds the size limit")
+
+// detectCompression checks if data is compressed and decompresses it if needed.
+// The decompressed data is limited to maxSize bytes, unless maxSize is 0.
+func detectCompression(data []byte, maxSize int64) ([]byte, error) {
+	readAll := func(r io.Reader) ([]byte, error) {
+		if maxSize <= 0 {
+			return io.ReadAll(r)
+		}
+		b, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+		if err == nil && int64(len(b)) > maxSize {
+			return nil, errDecompressedSizeLimit
+		}
+		return b, err
+	}
+
 	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
 		r, err := gzip.NewReader(bytes.NewReader(data))
 		if err != nil {
@@ -89,7 +104,7 @@ func detectCompression(data []byte) ([]byte, error) {
 		}
 		defer r.Close()
 
-		decompressed, err := io.ReadAll(r)
+		decompressed, err := readAll(r)
 		if err != nil {
 			return nil, fmt.Errorf("decompress gzip data: %w", err)
 		}
@@ -105,7 +120,7 @@ func detectCompression(data []byte) ([]byte, error) {
 		}
 		defer r.Close()
 
-		decompressed, err := io.ReadAll(r)
+		decompressed, err := readAll(r)
 		if err != nil {
 			return nil, fmt.Errorf("decompress zstd data: %w", err)
 		}
@@ -9,9 +9,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path"
 	"path/filepath"
 	"time"
 
+	"github.com/dgraph-io/ristretto/v2"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,6 +21,7 @@ import (
 	googlev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	"github.com/grafana/pyroscope/lidia"
 	"github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/tenant"
 )
 
 type DebuginfodClient interface {
@@ -26,11 +29,26 @@ type DebuginfodClient interface {
 }
 
 type Config struct {
-	DebuginfodURL string `yaml:"debuginfod_url"`
+	DebuginfodURL     string `yaml:"debuginfod_url"`
+	InMemoryCacheSize int64  `yaml:"in_memory_cache_size"`
+	MaxUploadSize     int64  `yaml:"max_upload_size"`
 }
 
 func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
-	f.StringVar(&cfg.DebuginfodURL, "symbolizer.debuginfod-url", "https://debuginfod.elfutils.org", "URL of the debuginfod server")
+	f.StringVar(&cfg.DebuginfodURL, "symbolizer.debuginfod-url", "https://debuginfod.elfutils.org", "URL of the debuginfod server. If empty, only the symbols uploaded by tenants are used.")
+	f.Int64Var(&cfg.InMemoryCacheSize, "symbolizer.in-memory-cache-size", 256<<20, "Maximum size in bytes of the in-memory cache of symbol tables. 0 to disable.")
+	f.Int64Var(&cfg.MaxUploadSize, "symbolizer.max-upload-size", 1<<30, "Maximum size in bytes of a debug info file uploaded by a tenant, before and after decompression.")
+}
+
+// TenantSymbolsPrefix is the object store prefix of the symbols uploaded
+// by tenants, relative to the symbolizer bucket.
+const TenantSymbolsPrefix = "tenants"
+
+// TenantSymbolsPath returns the path of the symbol table of the build ID
+// uploaded by the tenant, relative to the symbolizer bucket. Symbol tables
+// are stored in the lidia format.
+func TenantSymbolsPath(tenantID, buildID string) string {
+	return path.Join(TenantSymbolsPrefix, tenantID, buildID)
 }
 
 type Symbolizer struct {
@@ -38,22 +56,48 @@ type Symbolizer struct {
 	client  DebuginfodClient
 	bucket  objstore.Bucket
 	metrics *metrics
+
+	maxUploadSize int64
+
+	// cache holds the symbol tables recently used, keyed by their
+	// object store path. Optional.
+	cache *ristretto.Cache[string, []byte]
 }
 
 func New(logger log.Logger, cfg Config, reg prometheus.Registerer, bucket objstore.Bucket) (*Symbolizer, error) {
 	metrics := newMetrics(reg)
 
-	client, err := NewDebuginfodClient(logger, cfg.DebuginfodURL, metrics)
-	if err != nil {
-		return nil, err
+	s := &Symbolizer{
+		logger:        logger,
+		bucket:        bucket,
+		metrics:       metrics,
+		maxUploadSize: cfg.MaxUploadSize,
+	}
+
+	if cfg.DebuginfodURL != "" {
+		client, err := NewDebuginfodClient(logger, cfg.DebuginfodURL, metrics)
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
+	}
+
+	if cfg.InMemoryCacheSize > 0 {
+		cache, err := ristretto.NewCache(&ristretto.Config[string, []byte]{
+			// Symbol tables are large: the number of items is expected to be
+			// low, assuming 1MB per table on average.
+			NumCounters: max(cfg.InMemoryCacheSize>>20, 1) * 10,
+			MaxCost:     cfg.InMemoryCacheSize,
+			BufferItems: 64,
+			Metrics:     true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create symbols cache: %w", err)
+		}
+		s.cache = cache
 	}
 
-	return &Symbolizer{
-		logger:  logger,
-		client:  client,
-		bucket:  bucket,
-		metrics: metrics,
-	}, nil
+	return s, nil
 }
 
 func (s *Symbolizer) SymbolizePprof(ctx context.Context, profile *googlev1.Profile) error {
@@ -298,7 +342,16 @@ func (s *Symbolizer) symbolizeWithTable(table *lidia.Table, req *request) {
 	}
 }
 
+// getLidiaBytes returns the symbol table of the build ID. The symbols
+// uploaded by the tenant take precedence over the ones shared by all
+// tenants, which are fetched from debuginfod and stored in the object store.
 func (s *Symbolizer) getLidiaBytes(ctx context.Context, buildID string) ([]byte, error) {
+	if tenantID, err := tenant.ExtractTenantIDFromContext(ctx); err == nil {
+		if lidiaBytes, err := s.fetchLidiaFromObjectStore(ctx, TenantSymbolsPath(tenantID, buildID)); err == nil {
+			return lidiaBytes, nil
+		}
+	}
+
 	if client, ok := s.client.(*DebuginfodHTTPClient); ok {
 		if found, _ := client.notFoundCache.Get(buildID); found {
 			return nil, buildIDNotFoundError{buildID: buildID}
@@ -318,13 +371,40 @@ func (s *Symbolizer) getLidiaBytes(ctx context.Context, buildID string) ([]byte,
 	if err := s.bucket.Upload(ctx, buildID, bytes.NewReader(lidiaBytes)); err != nil {
 		level.Warn(s.logger).Log("msg", "Failed to store debug info in objstore", "buildID", buildID, "err", err)
 	}
+	s.setCached(buildID, lidiaBytes)
 
 	return lidiaBytes, nil
 }
 
-// fetchLidiaFromObjectStore retrieves Lidia data from the object store
-func (s *Symbolizer) fetchLidiaFromObjectStore(ctx context.Context, buildID string) ([]byte, error) {
-	objstoreReader, err := s.bucket.Get(ctx, buildID)
+func (s *Symbolizer) getCached(key string) ([]byte, bool) {
+	if s.cache == nil {
+		return nil, false
+	}
+	lidiaBytes, ok := s.cache.Get(key)
+	if ok {
+		s.metrics.cacheOperations.WithLabelValues("symbols", "get", statusSuccess).Inc()
+	} else {
+		s.metrics.cacheOperations.WithLabelValues("symbols", "get", statusErrorNotFound).Inc()
+	}
+	return lidiaBytes, ok
+}
+
+func (s *Symbolizer) setCached(key string, lidiaBytes []byte) {
+	if s.cache == nil {
+		return
+	}
+	s.cache.Set(key, lidiaBytes, int64(len(lidiaBytes)))
+	s.metrics.cacheSizeBytes.WithLabelValues("symbols").Set(float64(s.cache.Metrics.CostAdded() - s.cache.Metrics.CostEvicted()))
+}
+
+// fetchLidiaFromObjectStore retrieves Lidia data from the object store,
+// or from the in-memory cache, if it has been fetched recently.
+func (s *Symbolizer) fetchLidiaFromObjectStore(ctx context.Context, key string) ([]byte, error) {
+	if data, ok := s.getCached(key); ok {
+		return data, nil
+	}
+
+	objstoreReader, err := s.bucket.Get(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -334,6 +414,7 @@ func (s *Symbolizer) fetchLidiaFromObjectStore(ctx context.Context, buildID stri
 	if err != nil {
 		return nil, fmt.Errorf("read content: %w", err)
 	}
+	s.setCached(key, data)
 
 	return data, nil
 }
@@ -364,6 +445,9 @@ func (s *Symbolizer) fetchLidiaFromDebuginfod(ctx context.Context, buildID strin
 }
 
 func (s *Symbolizer) fetchFromDebuginfod(ctx context.Context, buildID string) (io.ReadCloser, error) {
+	if s.client == nil {
+		return nil, buildIDNotFoundError{buildID: buildID}
+	}
 	debugReader, err := s.client.FetchDebuginfo(ctx, buildID)
 	if err != nil {
 		var bnfErr buildIDNotFoundError
@@ -380,7 +464,7 @@ func (s *Symbolizer) fetchFromDebuginfod(ctx context.Context, buildID string) (i
 }
 
 func (s *Symbolizer) processELFData(data []byte) (lidiaData []byte, err error) {
-	decompressedData, err := detectCompression(data)
+	decompressedData, err := detectCompression(data, 0)
 	if err != nil {
 		s.metrics.debugSymbolResolutionErrors.WithLabelValues("compression_error").Inc()
 		return nil, fmt.Errorf("detect compression: %w", err)
@@ -12,6 +12,7 @@ import (
 
 	googlev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	"github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/test/mocks/mockobjstore"
 	"github.com/grafana/pyroscope/pkg/test/mocks/mocksymbolizer"
 
@@ -335,6 +336,68 @@ func TestSymbolizationWithLidiaData(t *testing.T) {
 	require.NotEmpty(t, req2.locations[0].lines)
 }
 
+func TestSymbolizeWithTenantSymbols(t *testing.T) {
+	const buildID = "ffcf60c240417166980a43fbbfde486e0b3718e5"
+
+	lidiaData, err := extractGzipFile(t, "testdata/test_lidia_file.gz")
+	require.NoError(t, err)
+
+	// The symbols uploaded by the tenant are used: neither the shared
+	// symbols nor debuginfod are queried.
+	mockClient := mocksymbolizer.NewMockDebuginfodClient(t)
+	mockBucket := mockobjstore.NewMockBucket(t)
+	mockBucket.On("Get", mock.Anything, TenantSymbolsPath("tenant-a", buildID)).
+		Return(io.NopCloser(bytes.NewReader(lidiaData)), nil).Once()
+
+	sym := &Symbolizer{
+		logger:  log.NewNopLogger(),
+		client:  mockClient,
+		bucket:  mockBucket,
+		metrics: newMetrics(prometheus.NewRegistry()),
+	}
+
+	req := createRequest(t, buildID, 0x1b743d6)
+	sym.symbolize(tenant.InjectTenantID(context.Background(), "tenant-a"), req)
+	require.NotEmpty(t, req.locations[0].lines)
+	require.NotContains(t, req.locations[0].lines[0].FunctionName, "!0x")
+
+	// Other tenants fall back to the shared symbols.
+	mockBucket.On("Get", mock.Anything, TenantSymbolsPath("tenant-b", buildID)).
+		Return(nil, fmt.Errorf("not found")).Once()
+	mockBucket.On("Get", mock.Anything, buildID).
+		Return(io.NopCloser(bytes.NewReader(lidiaData)), nil).Once()
+
+	req = createRequest(t, buildID, 0x1b743d6)
+	sym.symbolize(tenant.InjectTenantID(context.Background(), "tenant-b"), req)
+	require.NotEmpty(t, req.locations[0].lines)
+}
+
+func TestSymbolizeWithInMemoryCache(t *testing.T) {
+	const buildID = "ffcf60c240417166980a43fbbfde486e0b3718e5"
+
+	lidiaData, err := extractGzipFile(t, "testdata/test_lidia_file.gz")
+	require.NoError(t, err)
+
+	mockBucket := mockobjstore.NewMockBucket(t)
+	mockBucket.On("Get", mock.Anything, buildID).
+		Return(io.NopCloser(bytes.NewReader(lidiaData)), nil).Once()
+
+	sym, err := New(log.NewNopLogger(), Config{InMemoryCacheSize: 64 << 20}, prometheus.NewRegistry(), mockBucket)
+	require.NoError(t, err)
+	require.Nil(t, sym.client)
+
+	req := createRequest(t, buildID, 0x1b743d6)
+	sym.symbolize(context.Background(), req)
+	require.NotEmpty(t, req.locations[0].lines)
+	sym.cache.Wait()
+
+	// The object store is not queried again.
+	req = createRequest(t, buildID, 0x1b743d6)
+	sym.symbolize(context.Background(), req)
+	require.NotEmpty(t, req.locations[0].lines)
+	require.NotContains(t, req.locations[0].lines[0].FunctionName, "!0x")
+}
+
 // TestSymbolizeWithObjectStore validates the symbolizer's behavior with the object store:
 // 1. First request: Object store miss → fetch from debuginfod → store Lidia data in object store
 // 2. Second request (same build-id, same address): Object store hit → use cached Lidia data
@@ -0,0 +1,135 @@
+package symbolizer
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log/level"
+	"github.com/gorilla/mux"
+
+	"github.com/grafana/pyroscope/pkg/tenant"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// UploadHandler stores the debug info file of a build ID for the tenant,
+// for example, POST /api/v1/symbols/2fa2055ef20fabc972d5751147e093275514b142.
+//
+// The request body is an ELF file with symbols (optionally, compressed with
+// gzip or zstd), e.g., the .debug file produced by objcopy --only-keep-debug.
+// The symbols are converted to a symbol table and used to symbolize the
+// profiles of the tenant, which allows to keep the deployed binaries stripped.
+func (s *Symbolizer) UploadHandler(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := tenant.ExtractTenantIDFromContext(r.Context())
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeUnauthenticated, err))
+		return
+	}
+	buildID, err := sanitizeBuildID(mux.Vars(r)["build_id"])
+	if err == nil && buildID == "" {
+		err = errors.New("build ID is required")
+	}
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, s.maxUploadSize+1))
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("read body: %w", err)))
+		return
+	}
+	if int64(len(data)) > s.maxUploadSize {
+		httputil.ErrorWithStatus(w, fmt.Errorf("debug info file exceeds the size limit of %d bytes", s.maxUploadSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if data, err = detectCompression(data, s.maxUploadSize); err != nil {
+		if errors.Is(err, errDecompressedSizeLimit) {
+			httputil.ErrorWithStatus(w, fmt.Errorf("decompressed debug info file exceeds the size limit of %d bytes", s.maxUploadSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	if err = checkBuildID(data, buildID); err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	lidiaBytes, err := s.processELFData(data)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+
+	key := TenantSymbolsPath(tenantID, buildID)
+	if err = s.bucket.Upload(r.Context(), key, bytes.NewReader(lidiaBytes)); err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInternal, fmt.Errorf("store symbols: %w", err)))
+		return
+	}
+	if s.cache != nil {
+		s.cache.Del(key)
+	}
+	level.Info(s.logger).Log("msg", "symbols uploaded", "tenant", tenantID, "buildID", buildID, "size", len(lidiaBytes))
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkBuildID verifies that the build ID matches the one of the ELF file,
+// if the file has one.
+func checkBuildID(data []byte, buildID string) error {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("parse ELF file: %w", err)
+	}
+	defer f.Close()
+	fileBuildID, err := BuildIDFromELF(f)
+	if err != nil {
+		return err
+	}
+	if fileBuildID != "" && fileBuildID != buildID {
+		return fmt.Errorf("build ID %s does not match the build ID of the file %s", buildID, fileBuildID)
+	}
+	return nil
+}
+
+const noteTypeGNUBuildID = 3
+
+// BuildIDFromELF returns the GNU build ID of the ELF file as a hex string,
+// or an empty string, if the file has no build ID note.
+func BuildIDFromELF(f *elf.File) (string, error) {
+	sec := f.Section(".note.gnu.build-id")
+	if sec == nil {
+		return "", nil
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return "", fmt.Errorf("read build ID note: %w", err)
+	}
+	for len(data) >= 12 {
+		nameSize := int(f.ByteOrder.Uint32(data[0:4]))
+		descSize := int(f.ByteOrder.Uint32(data[4:8]))
+		noteType := f.ByteOrder.Uint32(data[8:12])
+		nameEnd := 12 + align4(nameSize)
+		descEnd := nameEnd + align4(descSize)
+		if nameEnd+descSize > len(data) {
+			break
+		}
+		name := data[12 : 12+nameSize]
+		if noteType == noteTypeGNUBuildID && bytes.Equal(name, []byte("GNU\x00")) {
+			return hex.EncodeToString(data[nameEnd : nameEnd+descSize]), nil
+		}
+		if descEnd >= len(data) {
+			break
+		}
+		data = data[descEnd:]
+	}
+	return "", nil
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
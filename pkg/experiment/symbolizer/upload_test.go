@@ -0,0 +1,96 @@
+package symbolizer
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/gzip"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/lidia"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/test/mocks/mockobjstore"
+)
+
+const testBuildID = "2fa2055ef20fabc972d5751147e093275514b142"
+
+func TestBuildIDFromELF(t *testing.T) {
+	f, err := elf.Open("testdata/symbols.debug")
+	require.NoError(t, err)
+	defer f.Close()
+
+	buildID, err := BuildIDFromELF(f)
+	require.NoError(t, err)
+	require.Equal(t, testBuildID, buildID)
+}
+
+func TestUploadHandler(t *testing.T) {
+	elfData, err := os.ReadFile("testdata/symbols.debug")
+	require.NoError(t, err)
+
+	var uploaded []byte
+	mockBucket := mockobjstore.NewMockBucket(t)
+	mockBucket.On("Upload", mock.Anything, TenantSymbolsPath("tenant-a", testBuildID), mock.Anything).
+		Run(func(args mock.Arguments) {
+			uploaded, err = io.ReadAll(args.Get(2).(io.Reader))
+			require.NoError(t, err)
+		}).Return(nil).Once()
+
+	s, err := New(log.NewNopLogger(), Config{MaxUploadSize: 1 << 20}, prometheus.NewRegistry(), mockBucket)
+	require.NoError(t, err)
+
+	upload := func(ctx context.Context, buildID string, body []byte) int {
+		r := mux.NewRouter()
+		r.HandleFunc("/api/v1/symbols/{build_id}", s.UploadHandler)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/symbols/"+buildID, bytes.NewReader(body)).WithContext(ctx)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	ctx := tenant.InjectTenantID(context.Background(), "tenant-a")
+	require.Equal(t, http.StatusOK, upload(ctx, testBuildID, elfData))
+
+	// The uploaded symbols are stored as a symbol table.
+	table, err := lidia.OpenReader(NewReaderAtCloser(uploaded), lidia.WithCRC())
+	require.NoError(t, err)
+	frames, err := table.Lookup(nil, 0x3c5a)
+	require.NoError(t, err)
+	require.NotEmpty(t, frames)
+	require.Equal(t, "atoll_b", frames[0].FunctionName)
+	table.Close()
+
+	// The decompressed size is limited too.
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	_, err = gw.Write(make([]byte, 2<<20))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	for _, tc := range []struct {
+		ctx     context.Context
+		buildID string
+		body    []byte
+		code    int
+	}{
+		{ctx: context.Background(), buildID: testBuildID, body: elfData, code: http.StatusUnauthorized},
+		{ctx: ctx, buildID: "0123456789abcdef", body: elfData, code: http.StatusBadRequest},
+		{ctx: ctx, buildID: "build.id", body: elfData, code: http.StatusBadRequest},
+		{ctx: ctx, buildID: testBuildID, body: []byte("not an ELF file"), code: http.StatusBadRequest},
+		{ctx: ctx, buildID: testBuildID, body: make([]byte, 2<<20), code: http.StatusRequestEntityTooLarge},
+		{ctx: ctx, buildID: testBuildID, body: compressed.Bytes(), code: http.StatusRequestEntityTooLarge},
+	} {
+		require.Equal(t, tc.code, upload(tc.ctx, tc.buildID, tc.body), fmt.Sprintf("%s %d", tc.buildID, len(tc.body)))
+	}
+}
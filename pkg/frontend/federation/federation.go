@@ -0,0 +1,196 @@
+// Package federation fans out the queries to several Pyroscope clusters,
+// e.g., regional clusters, and merges their results.
+//
+// The series of each cluster are distinguished with the cluster label, which
+// can be used in the selectors to only query some of the clusters.
+package federation
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	connectapi "github.com/grafana/pyroscope/pkg/api/connect"
+	"github.com/grafana/pyroscope/pkg/util"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+type ClusterConfig struct {
+	Name        string         `yaml:"name"`
+	URL         string         `yaml:"url"`
+	TenantID    string         `yaml:"tenant_id"`
+	BearerToken flagext.Secret `yaml:"bearer_token"`
+}
+
+type Config struct {
+	LocalClusterName string          `yaml:"local_cluster_name"`
+	ClusterLabel     string          `yaml:"cluster_label"`
+	Clusters         []ClusterConfig `yaml:"clusters" doc:"description=Remote clusters queried with the local one. Each cluster has a name, the URL of its query API, and optionally the tenant ID and the bearer token of the requests."`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.LocalClusterName, "federation.local-cluster-name", "local", "Name of the local cluster, in the cluster label of its series, when the queries are federated.")
+	f.StringVar(&cfg.ClusterLabel, "federation.cluster-label", "cluster", "Label added to the series of each cluster, when the queries are federated.")
+}
+
+// Enabled reports whether the queries are federated: remote clusters are
+// configured.
+func (cfg *Config) Enabled() bool { return len(cfg.Clusters) > 0 }
+
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled() {
+		return nil
+	}
+	if cfg.ClusterLabel == "" {
+		return errors.New("federation: the cluster label is required")
+	}
+	names := map[string]struct{}{cfg.LocalClusterName: {}}
+	for _, c := range cfg.Clusters {
+		if c.Name == "" || c.URL == "" {
+			return errors.New("federation: the name and the URL of the clusters are required")
+		}
+		if _, ok := names[c.Name]; ok {
+			return fmt.Errorf("federation: duplicate cluster name %q", c.Name)
+		}
+		names[c.Name] = struct{}{}
+	}
+	return nil
+}
+
+type cluster struct {
+	name   string
+	client querierv1connect.QuerierServiceClient
+}
+
+// Federation is the querier service of the clusters.
+type Federation struct {
+	querierv1connect.UnimplementedQuerierServiceHandler
+
+	label    string
+	clusters []*cluster
+	limits   validation.FlameGraphLimits
+}
+
+// New returns the federation of the local querier service, e.g., the query
+// frontend, and of the remote clusters. The local cluster is not queried if
+// its name is empty.
+func New(cfg Config, local querierv1connect.QuerierServiceClient, limits validation.FlameGraphLimits) *Federation {
+	f := &Federation{label: cfg.ClusterLabel, limits: limits}
+	if cfg.LocalClusterName != "" {
+		f.clusters = append(f.clusters, &cluster{name: cfg.LocalClusterName, client: local})
+	}
+	httpClient := util.InstrumentedDefaultHTTPClient()
+	for _, c := range cfg.Clusters {
+		opts := append(connectapi.DefaultClientOptions(), connect.WithInterceptors(remoteInterceptor(c)))
+		f.clusters = append(f.clusters, &cluster{
+			name:   c.Name,
+			client: querierv1connect.NewQuerierServiceClient(httpClient, c.URL, opts...),
+		})
+	}
+	return f
+}
+
+// remoteInterceptor sets the tenant and the credentials of the requests
+// to the remote cluster.
+func remoteInterceptor(c ClusterConfig) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if c.TenantID != "" {
+				req.Header().Set(user.OrgIDHeaderName, c.TenantID)
+			} else if orgID, err := user.ExtractOrgID(ctx); err == nil {
+				req.Header().Set(user.OrgIDHeaderName, orgID)
+			}
+			if token := c.BearerToken.String(); token != "" {
+				req.Header().Set("Authorization", "Bearer "+token)
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// forEach calls fn concurrently for each of the clusters.
+func forEach(ctx context.Context, clusters []*cluster, fn func(context.Context, *cluster) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	for _, c := range clusters {
+		g.Go(func() error {
+			if err := fn(ctx, c); err != nil {
+				return fmt.Errorf("cluster %s: %w", c.name, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// selectClusters returns the clusters matching the cluster label matchers
+// of the selector, and the selector without these matchers.
+func (f *Federation) selectClusters(selector string) ([]*cluster, string, error) {
+	if s := strings.TrimSpace(selector); s == "" || s == "{}" {
+		return f.clusters, selector, nil
+	}
+	matchers, err := parser.ParseMetricSelector(selector)
+	if err != nil {
+		return nil, "", connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	var clusterMatchers []*labels.Matcher
+	rest := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		if m.Name == f.label {
+			clusterMatchers = append(clusterMatchers, m)
+			continue
+		}
+		rest = append(rest, m.String())
+	}
+	if len(clusterMatchers) == 0 {
+		return f.clusters, selector, nil
+	}
+	selected := make([]*cluster, 0, len(f.clusters))
+	for _, c := range f.clusters {
+		if matchesAll(clusterMatchers, c.name) {
+			selected = append(selected, c)
+		}
+	}
+	return selected, "{" + strings.Join(rest, ",") + "}", nil
+}
+
+func matchesAll(matchers []*labels.Matcher, v string) bool {
+	for _, m := range matchers {
+		if !m.Matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// clusterMatchers returns the matchers of the request to each of the
+// clusters. A cluster is only queried if one of the matchers selects it,
+// or if there are no matchers.
+func (f *Federation) clusterMatchers(matchers []string) (map[*cluster][]string, error) {
+	selected := make(map[*cluster][]string, len(f.clusters))
+	if len(matchers) == 0 {
+		for _, c := range f.clusters {
+			selected[c] = nil
+		}
+		return selected, nil
+	}
+	for _, m := range matchers {
+		clusters, rewritten, err := f.selectClusters(m)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range clusters {
+			selected[c] = append(selected[c], rewritten)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,151 @@
+package federation
+
+import (
+	"context"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+type fakeLimits struct{}
+
+func (fakeLimits) MaxFlameGraphNodesDefault(string) int { return 8192 }
+func (fakeLimits) MaxFlameGraphNodesMax(string) int     { return 0 }
+
+type fakeCluster struct {
+	querierv1connect.UnimplementedQuerierServiceHandler
+	stack     []string
+	selectors []string
+}
+
+func (c *fakeCluster) SelectMergeStacktraces(_ context.Context, req *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+	c.selectors = append(c.selectors, req.Msg.LabelSelector)
+	t := new(phlaremodel.Tree)
+	t.InsertStack(10, c.stack...)
+	return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{Tree: t.Bytes(-1)}), nil
+}
+
+func (c *fakeCluster) SelectSeries(_ context.Context, req *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error) {
+	c.selectors = append(c.selectors, req.Msg.LabelSelector)
+	return connect.NewResponse(&querierv1.SelectSeriesResponse{
+		Series: []*typesv1.Series{{
+			Labels: phlaremodel.LabelsFromStrings("service_name", "checkout"),
+			Points: []*typesv1.Point{{Timestamp: 1000, Value: 10}},
+		}},
+	}), nil
+}
+
+func newTestFederation() (*Federation, map[string]*fakeCluster) {
+	fakes := map[string]*fakeCluster{
+		"eu": {stack: []string{"main", "eu"}},
+		"us": {stack: []string{"main", "us"}},
+	}
+	f := &Federation{label: "cluster", limits: fakeLimits{}}
+	for _, name := range []string{"eu", "us"} {
+		f.clusters = append(f.clusters, &cluster{name: name, client: fakes[name]})
+	}
+	return f, fakes
+}
+
+func Test_selectClusters(t *testing.T) {
+	f, _ := newTestFederation()
+	for _, tc := range []struct {
+		selector string
+		clusters []string
+		rewrite  string
+	}{
+		{`{}`, []string{"eu", "us"}, `{}`},
+		{`{service_name="checkout"}`, []string{"eu", "us"}, `{service_name="checkout"}`},
+		{`{service_name="checkout", cluster="eu"}`, []string{"eu"}, `{service_name="checkout"}`},
+		{`{cluster=~"e.*|u.*", cluster!="us"}`, []string{"eu"}, `{}`},
+		{`{cluster="ap"}`, []string{}, `{}`},
+	} {
+		t.Run(tc.selector, func(t *testing.T) {
+			selected, rewrite, err := f.selectClusters(tc.selector)
+			require.NoError(t, err)
+			names := make([]string, 0, len(selected))
+			for _, c := range selected {
+				names = append(names, c.name)
+			}
+			assert.Equal(t, tc.clusters, names)
+			assert.Equal(t, tc.rewrite, rewrite)
+		})
+	}
+}
+
+func Test_SelectMergeStacktraces(t *testing.T) {
+	f, fakes := newTestFederation()
+	ctx := user.InjectOrgID(context.Background(), "tenant-a")
+
+	resp, err := f.SelectMergeStacktraces(ctx, connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+		LabelSelector: `{service_name="checkout"}`,
+		Format:        querierv1.ProfileFormat_PROFILE_FORMAT_TREE,
+	}))
+	require.NoError(t, err)
+	tree, err := phlaremodel.UnmarshalTree(resp.Msg.Tree)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), tree.Total())
+
+	resp, err = f.SelectMergeStacktraces(ctx, connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+		LabelSelector: `{service_name="checkout", cluster="us"}`,
+		Format:        querierv1.ProfileFormat_PROFILE_FORMAT_TREE,
+	}))
+	require.NoError(t, err)
+	tree, err = phlaremodel.UnmarshalTree(resp.Msg.Tree)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), tree.Total())
+	assert.Equal(t, []string{`{service_name="checkout"}`}, fakes["eu"].selectors)
+	assert.Equal(t, []string{`{service_name="checkout"}`, `{service_name="checkout"}`}, fakes["us"].selectors)
+}
+
+func Test_SelectSeries(t *testing.T) {
+	f, _ := newTestFederation()
+	ctx := user.InjectOrgID(context.Background(), "tenant-a")
+
+	resp, err := f.SelectSeries(ctx, connect.NewRequest(&querierv1.SelectSeriesRequest{
+		LabelSelector: `{}`,
+		GroupBy:       []string{"service_name", "cluster"},
+	}))
+	require.NoError(t, err)
+	require.Len(t, resp.Msg.Series, 2)
+	for _, s := range resp.Msg.Series {
+		assert.NotEmpty(t, phlaremodel.Labels(s.Labels).Get("cluster"))
+		assert.Equal(t, float64(10), s.Points[0].Value)
+	}
+
+	// The series of the clusters are summed if not grouped by cluster.
+	resp, err = f.SelectSeries(ctx, connect.NewRequest(&querierv1.SelectSeriesRequest{
+		LabelSelector: `{}`,
+		GroupBy:       []string{"service_name"},
+	}))
+	require.NoError(t, err)
+	require.Len(t, resp.Msg.Series, 1)
+	assert.Equal(t, float64(20), resp.Msg.Series[0].Points[0].Value)
+}
+
+func Test_LabelValues_ClusterLabel(t *testing.T) {
+	f, _ := newTestFederation()
+	resp, err := f.LabelValues(context.Background(), connect.NewRequest(&typesv1.LabelValuesRequest{
+		Name:     "cluster",
+		Matchers: []string{`{cluster!="us"}`},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eu"}, resp.Msg.Names)
+}
+
+func Test_Config_Validate(t *testing.T) {
+	cfg := Config{LocalClusterName: "local", ClusterLabel: "cluster"}
+	require.NoError(t, cfg.Validate())
+	cfg.Clusters = []ClusterConfig{{Name: "eu", URL: "http://eu"}}
+	require.NoError(t, cfg.Validate())
+	cfg.Clusters = append(cfg.Clusters, ClusterConfig{Name: "local", URL: "http://us"})
+	require.EqualError(t, cfg.Validate(), `federation: duplicate cluster name "local"`)
+}
@@ -0,0 +1,368 @@
+package federation
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+
+	"connectrpc.com/connect"
+	"github.com/grafana/dskit/tenant"
+	"golang.org/x/sync/errgroup"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func (f *Federation) ProfileTypes(ctx context.Context, c *connect.Request[querierv1.ProfileTypesRequest]) (*connect.Response[querierv1.ProfileTypesResponse], error) {
+	var mu sync.Mutex
+	types := make(map[string]*typesv1.ProfileType)
+	err := forEach(ctx, f.clusters, func(ctx context.Context, cl *cluster) error {
+		resp, err := cl.client.ProfileTypes(ctx, connect.NewRequest(c.Msg.CloneVT()))
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, t := range resp.Msg.ProfileTypes {
+			types[t.ID] = t
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &querierv1.ProfileTypesResponse{ProfileTypes: make([]*typesv1.ProfileType, 0, len(types))}
+	for _, t := range types {
+		resp.ProfileTypes = append(resp.ProfileTypes, t)
+	}
+	slices.SortFunc(resp.ProfileTypes, func(a, b *typesv1.ProfileType) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return connect.NewResponse(resp), nil
+}
+
+func (f *Federation) LabelNames(ctx context.Context, c *connect.Request[typesv1.LabelNamesRequest]) (*connect.Response[typesv1.LabelNamesResponse], error) {
+	selected, err := f.clusterMatchers(c.Msg.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	names := make(map[string]struct{})
+	if len(selected) > 0 {
+		names[f.label] = struct{}{}
+	}
+	err = forEach(ctx, clusters(selected), func(ctx context.Context, cl *cluster) error {
+		req := c.Msg.CloneVT()
+		req.Matchers = selected[cl]
+		resp, err := cl.client.LabelNames(ctx, connect.NewRequest(req))
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, n := range resp.Msg.Names {
+			names[n] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&typesv1.LabelNamesResponse{Names: sortedKeys(names)}), nil
+}
+
+func (f *Federation) LabelValues(ctx context.Context, c *connect.Request[typesv1.LabelValuesRequest]) (*connect.Response[typesv1.LabelValuesResponse], error) {
+	selected, err := f.clusterMatchers(c.Msg.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]struct{})
+	if c.Msg.Name == f.label {
+		for cl := range selected {
+			values[cl.name] = struct{}{}
+		}
+		return connect.NewResponse(&typesv1.LabelValuesResponse{Names: sortedKeys(values)}), nil
+	}
+	var mu sync.Mutex
+	err = forEach(ctx, clusters(selected), func(ctx context.Context, cl *cluster) error {
+		req := c.Msg.CloneVT()
+		req.Matchers = selected[cl]
+		resp, err := cl.client.LabelValues(ctx, connect.NewRequest(req))
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, v := range resp.Msg.Names {
+			values[v] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&typesv1.LabelValuesResponse{Names: sortedKeys(values)}), nil
+}
+
+func (f *Federation) Series(ctx context.Context, c *connect.Request[querierv1.SeriesRequest]) (*connect.Response[querierv1.SeriesResponse], error) {
+	selected, err := f.clusterMatchers(c.Msg.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	withClusterLabel := len(c.Msg.LabelNames) == 0 || slices.Contains(c.Msg.LabelNames, f.label)
+	var mu sync.Mutex
+	var result []*typesv1.Labels
+	err = forEach(ctx, clusters(selected), func(ctx context.Context, cl *cluster) error {
+		req := c.Msg.CloneVT()
+		req.Matchers = selected[cl]
+		resp, err := cl.client.Series(ctx, connect.NewRequest(req))
+		if err != nil {
+			return err
+		}
+		if withClusterLabel {
+			for _, ls := range resp.Msg.LabelsSet {
+				ls.Labels = phlaremodel.Labels(ls.Labels).InsertSorted(f.label, cl.name)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		result = append(result, resp.Msg.LabelsSet...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&querierv1.SeriesResponse{LabelsSet: result}), nil
+}
+
+func (f *Federation) SelectMergeStacktraces(ctx context.Context, c *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+	maxNodes, err := f.maxNodes(ctx, c.Msg.GetMaxNodes())
+	if err != nil {
+		return nil, err
+	}
+	c.Msg.MaxNodes = &maxNodes
+	t, err := f.selectMergeStacktracesTree(ctx, c.Msg)
+	if err != nil {
+		return nil, err
+	}
+	var resp querierv1.SelectMergeStacktracesResponse
+	switch c.Msg.Format {
+	default:
+		resp.Flamegraph = phlaremodel.NewFlameGraph(t, maxNodes)
+	case querierv1.ProfileFormat_PROFILE_FORMAT_TREE:
+		resp.Tree = t.Bytes(maxNodes)
+	}
+	return connect.NewResponse(&resp), nil
+}
+
+func (f *Federation) selectMergeStacktracesTree(ctx context.Context, msg *querierv1.SelectMergeStacktracesRequest) (*phlaremodel.Tree, error) {
+	selected, selector, err := f.selectClusters(msg.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	m := phlaremodel.NewFlameGraphMerger()
+	err = forEach(ctx, selected, func(ctx context.Context, cl *cluster) error {
+		req := msg.CloneVT()
+		req.LabelSelector = selector
+		req.Format = querierv1.ProfileFormat_PROFILE_FORMAT_TREE
+		resp, err := cl.client.SelectMergeStacktraces(ctx, connect.NewRequest(req))
+		if err != nil {
+			return err
+		}
+		if len(resp.Msg.Tree) > 0 {
+			return m.MergeTreeBytes(resp.Msg.Tree)
+		}
+		if resp.Msg.Flamegraph != nil {
+			m.MergeFlameGraph(resp.Msg.Flamegraph)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.Tree(), nil
+}
+
+func (f *Federation) SelectMergeSpanProfile(ctx context.Context, c *connect.Request[querierv1.SelectMergeSpanProfileRequest]) (*connect.Response[querierv1.SelectMergeSpanProfileResponse], error) {
+	maxNodes, err := f.maxNodes(ctx, c.Msg.GetMaxNodes())
+	if err != nil {
+		return nil, err
+	}
+	selected, selector, err := f.selectClusters(c.Msg.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	m := phlaremodel.NewFlameGraphMerger()
+	err = forEach(ctx, selected, func(ctx context.Context, cl *cluster) error {
+		req := c.Msg.CloneVT()
+		req.LabelSelector = selector
+		req.MaxNodes = &maxNodes
+		req.Format = querierv1.ProfileFormat_PROFILE_FORMAT_TREE
+		resp, err := cl.client.SelectMergeSpanProfile(ctx, connect.NewRequest(req))
+		if err != nil {
+			return err
+		}
+		if len(resp.Msg.Tree) > 0 {
+			return m.MergeTreeBytes(resp.Msg.Tree)
+		}
+		if resp.Msg.Flamegraph != nil {
+			m.MergeFlameGraph(resp.Msg.Flamegraph)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var resp querierv1.SelectMergeSpanProfileResponse
+	switch c.Msg.Format {
+	default:
+		resp.Flamegraph = phlaremodel.NewFlameGraph(m.Tree(), maxNodes)
+	case querierv1.ProfileFormat_PROFILE_FORMAT_TREE:
+		resp.Tree = m.Tree().Bytes(maxNodes)
+	}
+	return connect.NewResponse(&resp), nil
+}
+
+func (f *Federation) SelectMergeProfile(ctx context.Context, c *connect.Request[querierv1.SelectMergeProfileRequest]) (*connect.Response[profilev1.Profile], error) {
+	selected, selector, err := f.selectClusters(c.Msg.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	var m pprof.ProfileMerge
+	err = forEach(ctx, selected, func(ctx context.Context, cl *cluster) error {
+		req := c.Msg.CloneVT()
+		req.LabelSelector = selector
+		resp, err := cl.client.SelectMergeProfile(ctx, connect.NewRequest(req))
+		if err != nil {
+			return err
+		}
+		return m.Merge(resp.Msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(m.Profile()), nil
+}
+
+func (f *Federation) SelectSeries(ctx context.Context, c *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error) {
+	selected, selector, err := f.selectClusters(c.Msg.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	// The series of the clusters are summed, unless they are grouped by
+	// cluster.
+	withClusterLabel := len(c.Msg.GroupBy) == 0 || slices.Contains(c.Msg.GroupBy, f.label)
+	m := phlaremodel.NewTimeSeriesMerger(true)
+	err = forEach(ctx, selected, func(ctx context.Context, cl *cluster) error {
+		req := c.Msg.CloneVT()
+		req.LabelSelector = selector
+		req.GroupBy = slices.DeleteFunc(req.GroupBy, func(name string) bool { return name == f.label })
+		// The limit is applied to the merged series.
+		req.Limit = nil
+		resp, err := cl.client.SelectSeries(ctx, connect.NewRequest(req))
+		if err != nil {
+			return err
+		}
+		if withClusterLabel {
+			for _, s := range resp.Msg.Series {
+				s.Labels = phlaremodel.Labels(s.Labels).InsertSorted(f.label, cl.name)
+			}
+		}
+		m.MergeTimeSeries(resp.Msg.Series)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&querierv1.SelectSeriesResponse{Series: m.Top(int(c.Msg.GetLimit()))}), nil
+}
+
+func (f *Federation) Diff(ctx context.Context, c *connect.Request[querierv1.DiffRequest]) (*connect.Response[querierv1.DiffResponse], error) {
+	maxNodes, err := f.maxNodes(ctx, max(c.Msg.Left.GetMaxNodes(), c.Msg.Right.GetMaxNodes()))
+	if err != nil {
+		return nil, err
+	}
+	c.Msg.Left.MaxNodes = &maxNodes
+	c.Msg.Right.MaxNodes = &maxNodes
+	var left, right *phlaremodel.Tree
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		left, err = f.selectMergeStacktracesTree(ctx, c.Msg.Left)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		right, err = f.selectMergeStacktracesTree(ctx, c.Msg.Right)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	diff, err := phlaremodel.NewFlamegraphDiff(left, right, maxNodes)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return connect.NewResponse(&querierv1.DiffResponse{Flamegraph: diff}), nil
+}
+
+func (f *Federation) GetProfileStats(ctx context.Context, c *connect.Request[typesv1.GetProfileStatsRequest]) (*connect.Response[typesv1.GetProfileStatsResponse], error) {
+	var mu sync.Mutex
+	stats := new(typesv1.GetProfileStatsResponse)
+	err := forEach(ctx, f.clusters, func(ctx context.Context, cl *cluster) error {
+		resp, err := cl.client.GetProfileStats(ctx, connect.NewRequest(c.Msg.CloneVT()))
+		if err != nil {
+			return err
+		}
+		if !resp.Msg.DataIngested {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !stats.DataIngested || resp.Msg.OldestProfileTime < stats.OldestProfileTime {
+			stats.OldestProfileTime = resp.Msg.OldestProfileTime
+		}
+		stats.NewestProfileTime = max(stats.NewestProfileTime, resp.Msg.NewestProfileTime)
+		stats.DataIngested = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(stats), nil
+}
+
+func (f *Federation) maxNodes(ctx context.Context, n int64) (int64, error) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return 0, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	n, err = validation.ValidateMaxNodes(f.limits, tenantIDs, n)
+	if err != nil {
+		return 0, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return n, nil
+}
+
+func clusters(m map[*cluster][]string) []*cluster {
+	r := make([]*cluster, 0, len(m))
+	for c := range m {
+		r = append(r, c)
+	}
+	return r
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	r := make([]string, 0, len(m))
+	for k := range m {
+		r = append(r, k)
+	}
+	slices.Sort(r)
+	return r
+}
@@ -34,6 +34,7 @@ import (
 	"github.com/grafana/pyroscope/api/gen/proto/go/vcs/v1/vcsv1connect"
 	"github.com/grafana/pyroscope/pkg/frontend/frontendpb"
 	"github.com/grafana/pyroscope/pkg/frontend/vcs"
+	"github.com/grafana/pyroscope/pkg/querier/partial"
 	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/scheduler/schedulerdiscovery"
 	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
@@ -49,6 +50,9 @@ type Config struct {
 	WorkerConcurrency int               `yaml:"scheduler_worker_concurrency" category:"advanced"`
 	GRPCClientConfig  grpcclient.Config `yaml:"grpc_client_config" doc:"description=Configures the gRPC client used to communicate between the query-frontends and the query-schedulers."`
 
+	InteractiveQueries PriorityClassConfig `yaml:"interactive_queries" doc:"description=Limits the interactive queries, issued without the X-Pyroscope-Priority header."`
+	BatchQueries       PriorityClassConfig `yaml:"batch_queries" doc:"description=Limits the batch queries, such as scheduled reports, issued with the X-Pyroscope-Priority: batch header."`
+
 	// Used to find local IP address, that is sent to scheduler and querier-worker.
 	InfNames   []string `yaml:"instance_interface_names" category:"advanced" doc:"default=[<private network interfaces>]"`
 	Addr       string   `yaml:"instance_addr" category:"advanced"`
@@ -74,12 +78,20 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet, logger log.Logger) {
 	f.BoolVar(&cfg.EnableIPv6, "query-frontend.instance-enable-ipv6", false, "Enable using a IPv6 instance address. (default false)")
 	f.IntVar(&cfg.Port, "query-frontend.instance-port", 0, "Port to advertise to query-scheduler and querier (defaults to -server.http-listen-port).")
 	cfg.GRPCClientConfig.RegisterFlagsWithPrefix("query-frontend.grpc-client-config", f)
+	cfg.InteractiveQueries.RegisterFlagsWithPrefix("query-frontend.interactive-queries.", f)
+	cfg.BatchQueries.RegisterFlagsWithPrefix("query-frontend.batch-queries.", f)
 }
 
 func (cfg *Config) Validate() error {
 	if cfg.QuerySchedulerDiscovery.Mode == schedulerdiscovery.ModeRing && cfg.SchedulerAddress != "" {
 		return fmt.Errorf("scheduler address cannot be specified when query-scheduler service discovery mode is set to '%s'", cfg.QuerySchedulerDiscovery.Mode)
 	}
+	if err := cfg.InteractiveQueries.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.BatchQueries.Validate(); err != nil {
+		return err
+	}
 
 	return cfg.GRPCClientConfig.Validate()
 }
@@ -104,6 +116,10 @@ type Frontend struct {
 	schedulerWorkers        *frontendSchedulerWorkers
 	schedulerWorkersWatcher *services.FailureWatcher
 	requests                *requestsInProgress
+	heavyQueries            heavyQueries
+	inflightBytes           inflightBytes
+	querySeconds            *prometheus.CounterVec
+	priorityPools           [2]*priorityPool
 }
 
 type Limits interface {
@@ -114,6 +130,7 @@ type Limits interface {
 	QueryAnalysisEnabled(string) bool
 	SymbolizerEnabled(string) bool
 	validation.FlameGraphLimits
+	validation.QueryLimits
 }
 
 type frontendRequest struct {
@@ -162,7 +179,21 @@ func NewFrontend(cfg Config, limits Limits, log log.Logger, reg prometheus.Regis
 		schedulerWorkersWatcher: services.NewFailureWatcher(),
 		requests:                newRequestsInProgress(),
 		VCSServiceHandler:       vcs.New(log, reg),
+		querySeconds: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_query_frontend_query_seconds_total",
+			Help: "Total time spent by the frontend executing queries, per tenant.",
+		}, []string{"tenant"}),
 	}
+	queuedQueries := promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pyroscope_query_frontend_queued_queries",
+		Help: "Number of queries waiting for the concurrency limit of their priority class.",
+	}, []string{"priority"})
+	rejectedQueries := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "pyroscope_query_frontend_rejected_queries_total",
+		Help: "Total number of queries rejected because the queue of their priority class is full.",
+	}, []string{"priority"})
+	f.priorityPools[priorityInteractive] = newPriorityPool(priorityInteractive, cfg.InteractiveQueries, queuedQueries, rejectedQueries)
+	f.priorityPools[priorityBatch] = newPriorityPool(priorityBatch, cfg.BatchQueries, queuedQueries, rejectedQueries)
 	f.GRPCRoundTripper = &realFrontendRoundTripper{frontend: f}
 	// Randomize to avoid getting responses from queries sent before restart, which could lead to mixing results
 	// between different queries. Note that frontend verifies the user, so it cannot leak results between tenants.
@@ -295,6 +326,15 @@ enqueueAgain:
 		if stats.ShouldTrackHTTPGRPCResponse(resp.HttpResponse) {
 			stats.FromContext(ctx).Merge(resp.Stats) // Safe if stats is nil.
 		}
+		if sources := partial.FromContext(ctx); sources != nil && resp.HttpResponse != nil {
+			// The sources missing from the results of the sub-queries
+			// are missing from the results of the query.
+			for _, h := range resp.HttpResponse.Headers {
+				if http.CanonicalHeaderKey(h.Key) == partial.HeaderMissingSources {
+					sources.AddFromHeader(http.Header{partial.HeaderMissingSources: h.Values})
+				}
+			}
+		}
 
 		return resp.HttpResponse, nil
 	}
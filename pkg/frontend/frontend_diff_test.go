@@ -9,6 +9,7 @@ import (
 	"github.com/grafana/dskit/user"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 
 	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
@@ -49,6 +50,16 @@ func (m *mockLimits) MaxFlameGraphNodesMax(_ string) int {
 
 func (m *mockLimits) SymbolizerEnabled(s string) bool { return true }
 
+func (m *mockLimits) MaxQuerySeries(_ string) int { return 0 }
+
+func (m *mockLimits) MaxQueryBytes(_ string) int { return 0 }
+
+func (m *mockLimits) MaxConcurrentHeavyQueries(_ string) int { return 0 }
+
+func (m *mockLimits) HeavyQueryMinRange(_ string) time.Duration { return 0 }
+
+func (m *mockLimits) MaxInflightResponseBytes(_ string) int { return 0 }
+
 type mockRoundTripper struct {
 	callback func(ctx context.Context, req *httpgrpc.HTTPRequest) (*httpgrpc.HTTPResponse, error)
 }
@@ -62,7 +73,8 @@ func (m *mockRoundTripper) RoundTripGRPC(ctx context.Context, req *httpgrpc.HTTP
 
 func Test_Frontend_Diff(t *testing.T) {
 	frontend := Frontend{
-		limits: &mockLimits{},
+		limits:       &mockLimits{},
+		querySeconds: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "query_seconds_total"}, []string{"tenant"}),
 	}
 
 	ctx := user.InjectOrgID(context.Background(), "test")
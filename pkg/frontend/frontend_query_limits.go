@@ -0,0 +1,228 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/common/model"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+	validationutil "github.com/grafana/pyroscope/pkg/util/validation"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+// queryStats describes the execution of a query handled by the frontend.
+// The statistics are returned to the client in the response headers.
+type queryStats struct {
+	start        time.Time
+	splitQueries int
+	// Populated only if the query has been analyzed
+	// for the enforcement of the query limits.
+	analyzed bool
+	blocks   uint64
+	series   uint64
+	postings uint64
+	bytes    uint64
+	// responses reserves the bytes of the split query responses against
+	// the in-flight bytes limit of the tenant, nil if there is no limit.
+	responses *responseBytes
+}
+
+// reserveResponse accounts the size of a split query response before it is
+// merged. The query fails if the responses of the queries in progress of the
+// tenant would exceed the in-flight bytes limit.
+func (s *queryStats) reserveResponse(n int) error {
+	if s == nil || s.responses == nil {
+		return nil
+	}
+	return s.responses.reserve(int64(n))
+}
+
+func (s *queryStats) setHeaders(h http.Header) {
+	if s == nil {
+		// The query has not been executed.
+		return
+	}
+	h.Set(stats.HeaderWallTime, time.Since(s.start).String())
+	h.Set(stats.HeaderSplitQueries, strconv.Itoa(s.splitQueries))
+	if s.analyzed {
+		h.Set(stats.HeaderBlocks, strconv.FormatUint(s.blocks, 10))
+		h.Set(stats.HeaderSeries, strconv.FormatUint(s.series, 10))
+		h.Set(stats.HeaderPostings, strconv.FormatUint(s.postings, 10))
+		h.Set(stats.HeaderBytes, strconv.FormatUint(s.bytes, 10))
+	}
+}
+
+// heavyQueries tracks the number of heavy queries in progress per tenant.
+type heavyQueries struct {
+	mu       sync.Mutex
+	inflight map[string]int
+}
+
+func (h *heavyQueries) acquire(tenantID string, limit int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.inflight == nil {
+		h.inflight = make(map[string]int)
+	}
+	if h.inflight[tenantID] >= limit {
+		return false
+	}
+	h.inflight[tenantID]++
+	return true
+}
+
+func (h *heavyQueries) release(tenantID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.inflight[tenantID]--; h.inflight[tenantID] <= 0 {
+		delete(h.inflight, tenantID)
+	}
+}
+
+// inflightBytes tracks the bytes of the split query responses held by the
+// queries in progress per tenant.
+type inflightBytes struct {
+	mu    sync.Mutex
+	bytes map[string]int64
+}
+
+func (b *inflightBytes) acquire(tenantID string, n, limit int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bytes == nil {
+		b.bytes = make(map[string]int64)
+	}
+	if b.bytes[tenantID]+n > limit {
+		return false
+	}
+	b.bytes[tenantID] += n
+	return true
+}
+
+func (b *inflightBytes) release(tenantID string, n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bytes[tenantID] -= n; b.bytes[tenantID] <= 0 {
+		delete(b.bytes, tenantID)
+	}
+}
+
+// responseBytes are the bytes reserved by a query, released once the query
+// is done.
+type responseBytes struct {
+	inflight *inflightBytes
+	tenantID string
+	limit    int64
+
+	mu       sync.Mutex
+	reserved int64
+}
+
+func (r *responseBytes) reserve(n int64) error {
+	if !r.inflight.acquire(r.tenantID, n, r.limit) {
+		return connect.NewError(connect.CodeResourceExhausted,
+			validation.NewErrorf(validation.QueryLimit, validation.TooManyInflightBytesErrorMsg, r.limit))
+	}
+	r.mu.Lock()
+	r.reserved += n
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *responseBytes) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inflight.release(r.tenantID, r.reserved)
+	r.reserved = 0
+}
+
+// admitQuery enforces the query limits that can't be checked by looking at
+// the request alone. The query waits for a slot in the concurrency pool of
+// its priority class. Heavy queries are subject to the per-tenant concurrency
+// limit; if series or bytes limits are set, the query impact is estimated
+// with the query analysis before the query is executed. The split query
+// responses held by the queries of the tenant are subject to the in-flight
+// bytes limit, see queryStats.reserveResponse.
+//
+// The returned function must be called once the query is done: the time
+// spent is accounted to the tenant usage.
+func (f *Frontend) admitQuery(
+	ctx context.Context,
+	tenantIDs []string,
+	priority queryPriority,
+	interval model.Interval,
+	profileTypeID string,
+	labelSelector string,
+) (*queryStats, func(), error) {
+	s := &queryStats{start: time.Now()}
+	tenantID := tenant.JoinTenantIDs(tenantIDs)
+	releasePriority, err := f.priorityPools[priority].acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() {}
+	if limit := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxInflightResponseBytes); limit > 0 {
+		s.responses = &responseBytes{inflight: &f.inflightBytes, tenantID: tenantID, limit: int64(limit)}
+	}
+	done := func() {
+		f.querySeconds.WithLabelValues(tenantID).Add(time.Since(s.start).Seconds())
+		if s.responses != nil {
+			s.responses.release()
+		}
+		release()
+		releasePriority()
+	}
+
+	if limit := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxConcurrentHeavyQueries); limit > 0 {
+		minRange := validationutil.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, f.limits.HeavyQueryMinRange)
+		if interval.End.Sub(interval.Start) >= minRange {
+			if !f.heavyQueries.acquire(tenantID, limit) {
+				releasePriority()
+				return nil, nil, connect.NewError(connect.CodeResourceExhausted,
+					validation.NewErrorf(validation.QueryLimit, validation.TooManyHeavyQueriesErrorMsg, limit))
+			}
+			release = func() { f.heavyQueries.release(tenantID) }
+		}
+	}
+
+	maxSeries := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQuerySeries)
+	maxBytes := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQueryBytes)
+	if maxSeries == 0 && maxBytes == 0 {
+		return s, done, nil
+	}
+
+	req := connect.NewRequest(&querierv1.AnalyzeQueryRequest{
+		Start: int64(interval.Start),
+		End:   int64(interval.End),
+		Query: profileTypeID + labelSelector,
+	})
+	resp, err := connectgrpc.RoundTripUnary[querierv1.AnalyzeQueryRequest, querierv1.AnalyzeQueryResponse](
+		connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceAnalyzeQueryProcedure), f, req)
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	s.analyzed = true
+	for _, scope := range resp.Msg.QueryScopes {
+		s.blocks += scope.BlockCount
+		s.postings += scope.SeriesCount
+	}
+	if impact := resp.Msg.QueryImpact; impact != nil {
+		s.series = impact.TotalQueriedSeries
+		s.bytes = impact.TotalBytesInTimeRange
+	}
+	if err = validation.ValidateQueryImpact(f.limits, tenantIDs, s.series, s.bytes); err != nil {
+		done()
+		return nil, nil, connect.NewError(connect.CodeResourceExhausted, err)
+	}
+	return s, done, nil
+}
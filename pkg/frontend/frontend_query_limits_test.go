@@ -0,0 +1,54 @@
+package frontend
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+)
+
+func Test_responseBytes(t *testing.T) {
+	var inflight inflightBytes
+	a := &queryStats{responses: &responseBytes{inflight: &inflight, tenantID: "tenant", limit: 100}}
+	b := &queryStats{responses: &responseBytes{inflight: &inflight, tenantID: "tenant", limit: 100}}
+	other := &queryStats{responses: &responseBytes{inflight: &inflight, tenantID: "other", limit: 100}}
+
+	require.NoError(t, a.reserveResponse(60))
+	require.NoError(t, b.reserveResponse(40))
+	require.NoError(t, other.reserveResponse(100))
+
+	err := b.reserveResponse(1)
+	require.Error(t, err)
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+
+	a.responses.release()
+	require.NoError(t, b.reserveResponse(60))
+	b.responses.release()
+	other.responses.release()
+	assert.Empty(t, inflight.bytes)
+
+	var unlimited *queryStats
+	require.NoError(t, unlimited.reserveResponse(1<<30))
+	require.NoError(t, (&queryStats{}).reserveResponse(1<<30))
+}
+
+func Test_queryStats_setHeaders(t *testing.T) {
+	h := make(http.Header)
+	s := &queryStats{start: time.Now(), splitQueries: 2}
+	s.setHeaders(h)
+	assert.Equal(t, "2", h.Get(stats.HeaderSplitQueries))
+	assert.Empty(t, h.Get(stats.HeaderPostings))
+
+	s.analyzed = true
+	s.blocks, s.series, s.postings, s.bytes = 3, 10, 40, 1024
+	s.setHeaders(h)
+	assert.Equal(t, "3", h.Get(stats.HeaderBlocks))
+	assert.Equal(t, "10", h.Get(stats.HeaderSeries))
+	assert.Equal(t, "40", h.Get(stats.HeaderPostings))
+	assert.Equal(t, "1024", h.Get(stats.HeaderBytes))
+}
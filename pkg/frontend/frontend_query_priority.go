@@ -0,0 +1,131 @@
+package frontend
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+)
+
+// HeaderQueryPriority is the request header specifying the priority
+// class of the query. Queries without the header are interactive.
+const HeaderQueryPriority = "X-Pyroscope-Priority"
+
+// queryPriority is the class of a query: interactive queries issued by
+// users looking at dashboards, and batch queries issued by scheduled
+// reports and other automation. Each class has its own concurrency pool,
+// therefore batch queries never delay the interactive ones.
+type queryPriority int
+
+const (
+	priorityInteractive queryPriority = iota
+	priorityBatch
+)
+
+func (p queryPriority) String() string {
+	if p == priorityBatch {
+		return "batch"
+	}
+	return "interactive"
+}
+
+// queryPriorityFromHeader returns the priority class requested in the header.
+// Unknown values fall back to interactive.
+func queryPriorityFromHeader(h http.Header) queryPriority {
+	if strings.EqualFold(h.Get(HeaderQueryPriority), priorityBatch.String()) {
+		return priorityBatch
+	}
+	return priorityInteractive
+}
+
+// PriorityClassConfig configures the concurrency pool of a query priority class.
+type PriorityClassConfig struct {
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries" category:"advanced"`
+	MaxQueuedQueries     int `yaml:"max_queued_queries" category:"advanced"`
+}
+
+func (cfg *PriorityClassConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.IntVar(&cfg.MaxConcurrentQueries, prefix+"max-concurrent-queries", 0, "Maximum number of queries of the priority class executed concurrently by the query-frontend. 0 to disable the limit.")
+	f.IntVar(&cfg.MaxQueuedQueries, prefix+"max-queued-queries", 100, "Maximum number of queries of the priority class waiting for the concurrency limit. Queries above this limit are rejected. Only applies if the concurrency limit is set.")
+}
+
+func (cfg *PriorityClassConfig) Validate() error {
+	if cfg.MaxConcurrentQueries < 0 || cfg.MaxQueuedQueries < 0 {
+		return fmt.Errorf("query priority class limits cannot be negative")
+	}
+	return nil
+}
+
+// priorityPool limits the number of queries of a priority class executed
+// concurrently; queries above the limit wait in the queue, unless it is full.
+type priorityPool struct {
+	priority  queryPriority
+	slots     chan struct{}
+	queued    atomic.Int64
+	maxQueued int64
+
+	queuedGauge prometheus.Gauge
+	rejected    prometheus.Counter
+}
+
+func newPriorityPool(priority queryPriority, cfg PriorityClassConfig, queued *prometheus.GaugeVec, rejected *prometheus.CounterVec) *priorityPool {
+	p := &priorityPool{
+		priority:    priority,
+		maxQueued:   int64(cfg.MaxQueuedQueries),
+		queuedGauge: queued.WithLabelValues(priority.String()),
+		rejected:    rejected.WithLabelValues(priority.String()),
+	}
+	if cfg.MaxConcurrentQueries > 0 {
+		p.slots = make(chan struct{}, cfg.MaxConcurrentQueries)
+	}
+	return p
+}
+
+// acquire blocks until the query can be executed. The returned function
+// must be called once the query is done.
+func (p *priorityPool) acquire(ctx context.Context) (func(), error) {
+	if p == nil || p.slots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-p.slots }
+	select {
+	case p.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if p.queued.Inc() > p.maxQueued {
+		p.queued.Dec()
+		p.rejected.Inc()
+		return nil, connect.NewError(connect.CodeResourceExhausted,
+			fmt.Errorf("too many %s queries in progress, retry later", p.priority))
+	}
+	p.queuedGauge.Inc()
+	defer func() {
+		p.queued.Dec()
+		p.queuedGauge.Dec()
+	}()
+	select {
+	case p.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WithBatchPriority returns a client option marking the queries issued
+// by the client as batch queries, such as those of the scheduled reports.
+func WithBatchPriority() connect.ClientOption {
+	return connect.WithInterceptors(connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if req.Spec().IsClient {
+				req.Header().Set(HeaderQueryPriority, priorityBatch.String())
+			}
+			return next(ctx, req)
+		}
+	}))
+}
@@ -0,0 +1,64 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_queryPriorityFromHeader(t *testing.T) {
+	h := make(http.Header)
+	assert.Equal(t, priorityInteractive, queryPriorityFromHeader(h))
+	h.Set(HeaderQueryPriority, "Batch")
+	assert.Equal(t, priorityBatch, queryPriorityFromHeader(h))
+	h.Set(HeaderQueryPriority, "unknown")
+	assert.Equal(t, priorityInteractive, queryPriorityFromHeader(h))
+}
+
+func Test_priorityPool(t *testing.T) {
+	queued := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "queued"}, []string{"priority"})
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rejected"}, []string{"priority"})
+	p := newPriorityPool(priorityBatch, PriorityClassConfig{MaxConcurrentQueries: 1, MaxQueuedQueries: 1}, queued, rejected)
+	ctx := context.Background()
+
+	release, err := p.acquire(ctx)
+	require.NoError(t, err)
+
+	acquired := make(chan func())
+	go func() {
+		r, err := p.acquire(ctx)
+		assert.NoError(t, err)
+		acquired <- r
+	}()
+	require.Eventually(t, func() bool { return p.queued.Load() == 1 }, time.Second, time.Millisecond)
+
+	// The queue is full.
+	_, err = p.acquire(ctx)
+	require.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+
+	release()
+	(<-acquired)()
+
+	// A canceled query leaves the queue.
+	release, err = p.acquire(ctx)
+	require.NoError(t, err)
+	defer release()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = p.acquire(canceled)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(0), p.queued.Load())
+}
+
+func Test_priorityPool_Unlimited(t *testing.T) {
+	var p *priorityPool
+	release, err := p.acquire(context.Background())
+	require.NoError(t, err)
+	release()
+}
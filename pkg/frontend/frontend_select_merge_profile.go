@@ -45,6 +45,12 @@ func (f *Frontend) SelectMergeProfile(
 	c.Msg.Start = int64(validated.Start)
 	c.Msg.End = int64(validated.End)
 
+	qs, done, err := f.admitQuery(ctx, tenantIDs, queryPriorityFromHeader(c.Header()), validated.Interval, c.Msg.ProfileTypeID, c.Msg.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
 	g, ctx := errgroup.WithContext(ctx)
 	if maxConcurrent := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQueryParallelism); maxConcurrent > 0 {
 		g.SetLimit(maxConcurrent)
@@ -60,6 +66,7 @@ func (f *Frontend) SelectMergeProfile(
 	var m pprof.ProfileMerge
 	for intervals.Next() {
 		r := intervals.At()
+		qs.splitQueries++
 		g.Go(func() error {
 			req := connectgrpc.CloneRequest(c, &querierv1.SelectMergeProfileRequest{
 				ProfileTypeID:      c.Msg.ProfileTypeID,
@@ -75,6 +82,9 @@ func (f *Frontend) SelectMergeProfile(
 			if err != nil {
 				return err
 			}
+			if err = qs.reserveResponse(resp.Msg.SizeVT()); err != nil {
+				return err
+			}
 			return m.Merge(resp.Msg)
 		})
 	}
@@ -83,5 +93,7 @@ func (f *Frontend) SelectMergeProfile(
 		return nil, err
 	}
 
-	return connect.NewResponse(m.Profile()), nil
+	resp := connect.NewResponse(m.Profile())
+	qs.setHeaders(resp.Header())
+	return resp, nil
 }
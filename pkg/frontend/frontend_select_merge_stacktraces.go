@@ -13,6 +13,7 @@ import (
 	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
 	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
 	validationutil "github.com/grafana/pyroscope/pkg/util/validation"
 	"github.com/grafana/pyroscope/pkg/validation"
@@ -22,24 +23,28 @@ func (f *Frontend) SelectMergeStacktraces(
 	ctx context.Context,
 	c *connect.Request[querierv1.SelectMergeStacktracesRequest],
 ) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
-	t, err := f.selectMergeStacktracesTree(ctx, c)
+	t, qs, err := f.selectMergeStacktracesTree(ctx, c)
 	if err != nil {
 		return nil, err
 	}
 	var resp querierv1.SelectMergeStacktracesResponse
+	var truncation phlaremodel.TruncationStats
 	switch c.Msg.Format {
 	default:
-		resp.Flamegraph = phlaremodel.NewFlameGraph(t, c.Msg.GetMaxNodes())
+		resp.Flamegraph, truncation = phlaremodel.NewFlameGraphWithStats(t, c.Msg.GetMaxNodes())
 	case querierv1.ProfileFormat_PROFILE_FORMAT_TREE:
 		resp.Tree = t.Bytes(c.Msg.GetMaxNodes())
 	}
-	return connect.NewResponse(&resp), nil
+	r := connect.NewResponse(&resp)
+	qs.setHeaders(r.Header())
+	stats.SetTruncationHeaders(r.Header(), truncation.PrunedNodes, truncation.OtherValue)
+	return r, nil
 }
 
 func (f *Frontend) selectMergeStacktracesTree(
 	ctx context.Context,
 	c *connect.Request[querierv1.SelectMergeStacktracesRequest],
-) (*phlaremodel.Tree, error) {
+) (*phlaremodel.Tree, *queryStats, error) {
 	opentracing.SpanFromContext(ctx).
 		SetTag("start", model.Time(c.Msg.Start).Time().String()).
 		SetTag("end", model.Time(c.Msg.End).Time().String()).
@@ -50,20 +55,28 @@ func (f *Frontend) selectMergeStacktracesTree(
 	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceSelectMergeStacktracesProcedure)
 	tenantIDs, err := tenant.TenantIDs(ctx)
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		return nil, nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 
 	validated, err := validation.ValidateRangeRequest(f.limits, tenantIDs, model.Interval{Start: model.Time(c.Msg.Start), End: model.Time(c.Msg.End)}, model.Now())
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		return nil, nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 	if validated.IsEmpty {
-		return new(phlaremodel.Tree), nil
+		return new(phlaremodel.Tree), nil, nil
 	}
 	maxNodes, err := validation.ValidateMaxNodes(f.limits, tenantIDs, c.Msg.GetMaxNodes())
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		return nil, nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
+	// The per-tenant limit applies to the merged result as well.
+	c.Msg.MaxNodes = &maxNodes
+
+	qs, done, err := f.admitQuery(ctx, tenantIDs, queryPriorityFromHeader(c.Header()), validated.Interval, c.Msg.ProfileTypeID, c.Msg.LabelSelector)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
 
 	g, ctx := errgroup.WithContext(ctx)
 	if maxConcurrent := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQueryParallelism); maxConcurrent > 0 {
@@ -76,6 +89,7 @@ func (f *Frontend) selectMergeStacktracesTree(
 
 	for intervals.Next() {
 		r := intervals.At()
+		qs.splitQueries++
 		g.Go(func() error {
 			req := connectgrpc.CloneRequest(c, &querierv1.SelectMergeStacktracesRequest{
 				ProfileTypeID: c.Msg.ProfileTypeID,
@@ -91,6 +105,9 @@ func (f *Frontend) selectMergeStacktracesTree(
 			if err != nil {
 				return err
 			}
+			if err = qs.reserveResponse(resp.Msg.SizeVT()); err != nil {
+				return err
+			}
 			if len(resp.Msg.Tree) > 0 {
 				err = m.MergeTreeBytes(resp.Msg.Tree)
 			} else if resp.Msg.Flamegraph != nil {
@@ -102,8 +119,8 @@ func (f *Frontend) selectMergeStacktracesTree(
 	}
 
 	if err = g.Wait(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return m.Tree(), nil
+	return m.Tree(), qs, nil
 }
@@ -46,6 +46,12 @@ func (f *Frontend) SelectSeries(
 	c.Msg.Start = int64(validated.Start)
 	c.Msg.End = int64(validated.End)
 
+	qs, done, err := f.admitQuery(ctx, tenantIDs, queryPriorityFromHeader(c.Header()), validated.Interval, c.Msg.ProfileTypeID, c.Msg.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
 	g, ctx := errgroup.WithContext(ctx)
 	if maxConcurrent := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQueryParallelism); maxConcurrent > 0 {
 		g.SetLimit(maxConcurrent)
@@ -58,6 +64,7 @@ func (f *Frontend) SelectSeries(
 
 	for intervals.Next() {
 		r := intervals.At()
+		qs.splitQueries++
 		g.Go(func() error {
 			req := connectgrpc.CloneRequest(c, &querierv1.SelectSeriesRequest{
 				ProfileTypeID:      c.Msg.ProfileTypeID,
@@ -85,5 +92,7 @@ func (f *Frontend) SelectSeries(
 	}
 
 	series := m.Top(int(c.Msg.GetLimit()))
-	return connect.NewResponse(&querierv1.SelectSeriesResponse{Series: series}), nil
+	resp := connect.NewResponse(&querierv1.SelectSeriesResponse{Series: series})
+	qs.setHeaders(resp.Header())
+	return resp, nil
 }
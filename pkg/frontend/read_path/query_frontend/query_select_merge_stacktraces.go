@@ -11,6 +11,7 @@ import (
 	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
 	queryv1 "github.com/grafana/pyroscope/api/gen/proto/go/query/v1"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/validation"
 )
 
@@ -23,6 +24,7 @@ func (q *QueryFrontend) SelectMergeStacktraces(
 		return nil, err
 	}
 	var resp querierv1.SelectMergeStacktracesResponse
+	var truncation phlaremodel.TruncationStats
 	switch c.Msg.Format {
 	case querierv1.ProfileFormat_PROFILE_FORMAT_TREE:
 		resp.Tree = b
@@ -31,9 +33,11 @@ func (q *QueryFrontend) SelectMergeStacktraces(
 		if err != nil {
 			return nil, err
 		}
-		resp.Flamegraph = phlaremodel.NewFlameGraph(t, c.Msg.GetMaxNodes())
+		resp.Flamegraph, truncation = phlaremodel.NewFlameGraphWithStats(t, c.Msg.GetMaxNodes())
 	}
-	return connect.NewResponse(&resp), nil
+	r := connect.NewResponse(&resp)
+	stats.SetTruncationHeaders(r.Header(), truncation.PrunedNodes, truncation.OtherValue)
+	return r, nil
 }
 
 func (q *QueryFrontend) selectMergeStacktracesTree(
@@ -63,6 +67,8 @@ func (q *QueryFrontend) selectMergeStacktracesTree(
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
+	// The per-tenant limit applies to the merged result as well.
+	c.Msg.MaxNodes = &maxNodes
 	labelSelector, err := buildLabelSelectorWithProfileType(c.Msg.LabelSelector, c.Msg.ProfileTypeID)
 	if err != nil {
 		return nil, err
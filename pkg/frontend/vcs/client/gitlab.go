@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"connectrpc.com/connect"
+
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+)
+
+// GitlabClient returns a client of the GitLab instance at the base URL,
+// for example, https://gitlab.com. The token is a personal, group, or
+// project access token; it's optional for public repositories.
+func GitlabClient(baseURL, token string, client *http.Client) *gitlabClient {
+	return &gitlabClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		client:  client,
+	}
+}
+
+type gitlabClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func (gl *gitlabClient) GetFile(ctx context.Context, req FileRequest) (File, error) {
+	// https://docs.gitlab.com/ee/api/repository_files.html#get-raw-file-from-repository
+	project := url.PathEscape(req.Owner + "/" + req.Repo)
+	u := fmt.Sprintf("%s/api/v4/projects/%s/repository/files/%s/raw?ref=%s",
+		gl.baseURL, project, url.PathEscape(req.Path), url.QueryEscape(req.Ref))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return File{}, err
+	}
+	if gl.token != "" {
+		httpReq.Header.Set("PRIVATE-TOKEN", gl.token)
+	}
+
+	res, err := gl.client.Do(httpReq)
+	if err != nil {
+		return File{}, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return File{}, fmt.Errorf("%w: %s/%s: %s", ErrNotFound, req.Owner, req.Repo, req.Path)
+	default:
+		code := connectgrpc.HTTPToCode(int32(res.StatusCode))
+		return File{}, connect.NewError(code, fmt.Errorf("gitlab: unexpected status %s", res.Status))
+	}
+
+	content, err := io.ReadAll(res.Body)
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{
+		Content: string(content),
+		URL:     fmt.Sprintf("%s/%s/%s/-/blob/%s/%s", gl.baseURL, req.Owner, req.Repo, req.Ref, req.Path),
+	}, nil
+}
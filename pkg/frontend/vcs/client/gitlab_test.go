@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitlabGetFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("PRIVATE-TOKEN"))
+		assert.Equal(t, "main", r.URL.Query().Get("ref"))
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects/org%2Frepo/repository/files/src%2Fmain.go/raw":
+			_, _ = w.Write([]byte("package main\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := GitlabClient(server.URL+"/", "secret", server.Client())
+	file, err := c.GetFile(context.Background(), FileRequest{Owner: "org", Repo: "repo", Path: "src/main.go", Ref: "main"})
+	require.NoError(t, err)
+	assert.Equal(t, File{
+		Content: "package main\n",
+		URL:     server.URL + "/org/repo/-/blob/main/src/main.go",
+	}, file)
+
+	_, err = c.GetFile(context.Background(), FileRequest{Owner: "org", Repo: "repo", Path: "missing.go", Ref: "main"})
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
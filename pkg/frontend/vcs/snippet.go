@@ -0,0 +1,219 @@
+package vcs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	giturl "github.com/kubescape/go-git-url"
+	"github.com/kubescape/go-git-url/apis"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/oauth2"
+
+	vcsv1 "github.com/grafana/pyroscope/api/gen/proto/go/vcs/v1"
+	"github.com/grafana/pyroscope/pkg/frontend/vcs/client"
+	"github.com/grafana/pyroscope/pkg/frontend/vcs/source"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+const (
+	defaultSnippetContext = 10
+	maxSnippetContext     = 100
+
+	snippetCacheSize = 1024
+	// Files fetched at a branch or a tag may change: they are cached
+	// for a limited time, unlike the files fetched at a commit.
+	snippetCacheTTL = 5 * time.Minute
+)
+
+var commitSHA = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+type Limits interface {
+	SourceCodeRepository(tenantID, serviceName string) (validation.SourceCodeRepository, bool)
+}
+
+// SourceSnippets serves the source code of functions, fetched from the
+// repositories configured for the tenant, with the tenant credentials.
+type SourceSnippets struct {
+	logger     log.Logger
+	httpClient *http.Client
+	limits     Limits
+
+	// newClient creates the client of the repository. It's replaced in tests.
+	newClient func(ctx context.Context, repo giturl.IGitURL, token string) (source.VCSClient, error)
+
+	pinned *lru.Cache[string, *vcsv1.GetFileResponse]
+	recent *expirable.LRU[string, *vcsv1.GetFileResponse]
+}
+
+func NewSourceSnippets(logger log.Logger, reg prometheus.Registerer, limits Limits) *SourceSnippets {
+	pinned, _ := lru.New[string, *vcsv1.GetFileResponse](snippetCacheSize)
+	s := &SourceSnippets{
+		logger:     logger,
+		httpClient: client.InstrumentedHTTPClient(logger, reg),
+		limits:     limits,
+		pinned:     pinned,
+		recent:     expirable.NewLRU[string, *vcsv1.GetFileResponse](snippetCacheSize, nil, snippetCacheTTL),
+	}
+	s.newClient = s.repositoryClient
+	return s
+}
+
+// Snippet is the source code around a line of a file.
+type Snippet struct {
+	Repository string `json:"repository"`
+	Ref        string `json:"ref"`
+	Path       string `json:"path"`
+	// URL is the link to the file in the repository.
+	URL string `json:"url"`
+	// StartLine is the number of the first line of the snippet, starting from 1.
+	StartLine int      `json:"startLine"`
+	Lines     []string `json:"lines"`
+}
+
+// ServeHTTP returns the source code around the line of the file of a service,
+// for example, /vcs/v1/source-snippet?service_name=checkout&path=main.go&line=42.
+//
+// The file is fetched from the repository configured for the service, at the
+// git_ref parameter: the commit the service was built from, as found in the
+// service_git_ref label of its profiles. If the parameter is not set, the
+// file is fetched at the ref configured for the repository.
+func (s *SourceSnippets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tenantID, err := tenant.ExtractTenantIDFromContext(r.Context())
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeUnauthenticated, err))
+		return
+	}
+	params := r.URL.Query()
+	path := params.Get("path")
+	if path == "" {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, errors.New("'path' is required")))
+		return
+	}
+	line, err := strconv.Atoi(params.Get("line"))
+	if err != nil || line < 1 {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, errors.New("'line' must be a positive number")))
+		return
+	}
+	lines := defaultSnippetContext
+	if v := params.Get("context"); v != "" {
+		if lines, err = strconv.Atoi(v); err != nil || lines < 0 {
+			httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, errors.New("'context' must be a non-negative number")))
+			return
+		}
+		lines = min(lines, maxSnippetContext)
+	}
+
+	repo, ok := s.limits.SourceCodeRepository(tenantID, params.Get("service_name"))
+	if !ok {
+		httputil.Error(w, connect.NewError(connect.CodeNotFound, errors.New("no source code repository configured for the service")))
+		return
+	}
+	ref := params.Get("git_ref")
+	if ref == "" {
+		ref = repo.Ref
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	file, err := s.getFile(r.Context(), tenantID, repo, path, ref)
+	if err != nil {
+		if errors.Is(err, client.ErrNotFound) {
+			err = connect.NewError(connect.CodeNotFound, err)
+		}
+		httputil.Error(w, err)
+		return
+	}
+	content, err := base64.StdEncoding.DecodeString(file.Content)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInternal, err))
+		return
+	}
+
+	snippet := Snippet{
+		Repository: repo.URL,
+		Ref:        ref,
+		Path:       path,
+		URL:        file.URL,
+	}
+	snippet.StartLine, snippet.Lines = snippetLines(string(content), line, lines)
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(snippet); err != nil {
+		httputil.Error(w, err)
+	}
+}
+
+func (s *SourceSnippets) getFile(ctx context.Context, tenantID string, repo validation.SourceCodeRepository, path, ref string) (*vcsv1.GetFileResponse, error) {
+	key := strings.Join([]string{tenantID, repo.URL, repo.RootPath, ref, path}, "\x00")
+	if file, ok := s.pinned.Get(key); ok {
+		return file, nil
+	}
+	if file, ok := s.recent.Get(key); ok {
+		return file, nil
+	}
+
+	gitURL, err := giturl.NewGitURL(repo.URL)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	vcsClient, err := s.newClient(ctx, gitURL, repo.Token.String())
+	if err != nil {
+		return nil, err
+	}
+	file, err := source.NewFileFinder(
+		vcsClient,
+		gitURL,
+		path,
+		repo.RootPath,
+		ref,
+		http.DefaultClient,
+		log.With(s.logger, "repo", gitURL.GetRepoName()),
+	).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if commitSHA.MatchString(ref) {
+		s.pinned.Add(key, file)
+	} else {
+		s.recent.Add(key, file)
+	}
+	return file, nil
+}
+
+func (s *SourceSnippets) repositoryClient(ctx context.Context, repo giturl.IGitURL, token string) (source.VCSClient, error) {
+	switch repo.GetProvider() {
+	case apis.ProviderGitHub.String():
+		return client.GithubClient(ctx, &oauth2.Token{AccessToken: token}, s.httpClient)
+	case apis.ProviderGitLab.String():
+		return client.GitlabClient("https://"+repo.GetHostName(), token, s.httpClient), nil
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unsupported repository provider %q", repo.GetProvider()))
+	}
+}
+
+// snippetLines returns up to n lines before and after the line (numbered
+// from 1), and the number of the first line returned.
+func snippetLines(content string, line, n int) (int, []string) {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	start := max(line-n, 1)
+	end := min(line+n, len(lines))
+	if start > end {
+		return start, []string{}
+	}
+	return start, lines[start-1 : end]
+}
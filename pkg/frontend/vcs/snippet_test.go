@@ -0,0 +1,103 @@
+package vcs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-kit/log"
+	giturl "github.com/kubescape/go-git-url"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/frontend/vcs/client"
+	"github.com/grafana/pyroscope/pkg/frontend/vcs/source"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+type repositoryLimits map[string]validation.SourceCodeRepository
+
+func (l repositoryLimits) SourceCodeRepository(_, serviceName string) (validation.SourceCodeRepository, bool) {
+	r, ok := l[serviceName]
+	return r, ok
+}
+
+type fileGetter struct {
+	requests []client.FileRequest
+}
+
+func (f *fileGetter) GetFile(_ context.Context, req client.FileRequest) (client.File, error) {
+	f.requests = append(f.requests, req)
+	if req.Path != "src/main.c" {
+		return client.File{}, client.ErrNotFound
+	}
+	return client.File{
+		Content: "1\n2\n3\n4\n5\n6\n",
+		URL:     "https://github.com/org/repo/blob/" + req.Ref + "/" + req.Path,
+	}, nil
+}
+
+func Test_SourceSnippets(t *testing.T) {
+	const commit = "0123456789abcdef0123456789abcdef01234567"
+	files := new(fileGetter)
+	s := NewSourceSnippets(log.NewNopLogger(), prometheus.NewRegistry(), repositoryLimits{
+		"svc": {URL: "https://github.com/org/repo", Ref: "main"},
+	})
+	s.newClient = func(_ context.Context, _ giturl.IGitURL, token string) (source.VCSClient, error) {
+		return files, nil
+	}
+
+	get := func(params url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/vcs/v1/source-snippet?"+params.Encode(), nil)
+		s.ServeHTTP(w, req.WithContext(tenant.InjectTenantID(req.Context(), "tenant")))
+		return w
+	}
+
+	w := get(url.Values{"service_name": {"svc"}, "path": {"src/main.c"}, "line": {"2"}, "context": {"1"}, "git_ref": {commit}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var snippet Snippet
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &snippet))
+	assert.Equal(t, Snippet{
+		Repository: "https://github.com/org/repo",
+		Ref:        commit,
+		Path:       "src/main.c",
+		URL:        "https://github.com/org/repo/blob/" + commit + "/src/main.c",
+		StartLine:  1,
+		Lines:      []string{"1", "2", "3"},
+	}, snippet)
+
+	// The file pinned to the commit is cached.
+	w = get(url.Values{"service_name": {"svc"}, "path": {"src/main.c"}, "line": {"6"}, "context": {"1"}, "git_ref": {commit}})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &snippet))
+	assert.Equal(t, 5, snippet.StartLine)
+	assert.Equal(t, []string{"5", "6"}, snippet.Lines)
+	assert.Len(t, files.requests, 1)
+
+	// The ref of the repository is used by default.
+	w = get(url.Values{"service_name": {"svc"}, "path": {"src/main.c"}, "line": {"1"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &snippet))
+	assert.Equal(t, "main", snippet.Ref)
+	assert.Equal(t, "main", files.requests[1].Ref)
+
+	assert.Equal(t, http.StatusNotFound, get(url.Values{"service_name": {"other"}, "path": {"src/main.c"}, "line": {"1"}}).Code)
+	assert.Equal(t, http.StatusNotFound, get(url.Values{"service_name": {"svc"}, "path": {"src/missing.c"}, "line": {"1"}}).Code)
+	assert.Equal(t, http.StatusBadRequest, get(url.Values{"service_name": {"svc"}, "path": {"src/main.c"}}).Code)
+}
+
+func Test_snippetLines(t *testing.T) {
+	start, lines := snippetLines("a\nb\nc\n", 10, 2)
+	assert.Equal(t, 8, start)
+	assert.Empty(t, lines)
+
+	start, lines = snippetLines("a\nb\nc", 3, 0)
+	assert.Equal(t, 3, start)
+	assert.Equal(t, []string{"c"}, lines)
+}
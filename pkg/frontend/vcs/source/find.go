@@ -54,11 +54,7 @@ func (ff FileFinder) Find(ctx context.Context) (*vcsv1.GetFileResponse, error) {
 	// todo: add more languages support
 	default:
 		// by default we return the file content at the given path without any processing.
-		content, err := ff.fetchRepoFile(ctx, ff.path, ff.ref)
-		if err != nil {
-			return nil, err
-		}
-		return newFileResponse(content.Content, content.URL)
+		return ff.fetchRepoFile(ctx, ff.path, ff.ref)
 	}
 }
 
@@ -71,6 +71,7 @@ type Ingester struct {
 	limits              Limits
 	reg                 prometheus.Registerer
 	usageGroupEvaluator *validation.UsageGroupEvaluator
+	deletedTenants      *tenantDeletionChecker
 }
 
 type ingesterFlusherCompat struct {
@@ -109,6 +110,7 @@ func New(phlarectx context.Context, cfg Config, dbConfig phlaredb.Config, storag
 	}
 
 	i.usageGroupEvaluator = validation.NewUsageGroupEvaluator(i.logger)
+	i.deletedTenants = newTenantDeletionChecker(storageBucket, i.logger)
 
 	i.lifecycler, err = ring.NewLifecycler(
 		cfg.LifecyclerConfig,
@@ -152,9 +154,33 @@ func New(phlarectx context.Context, cfg Config, dbConfig phlaredb.Config, storag
 }
 
 func (i *Ingester) starting(ctx context.Context) error {
+	if err := i.replayWAL(); err != nil {
+		return err
+	}
 	return services.StartManagerAndAwaitHealthy(ctx, i.subservices)
 }
 
+// replayWAL opens the instances of the tenants with write-ahead logs to
+// replay, before the ingester joins the ring.
+func (i *Ingester) replayWAL() error {
+	entries, err := os.ReadDir(i.dbConfig.DataPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !phlaredb.HasWAL(filepath.Join(i.dbConfig.DataPath, entry.Name())) {
+			continue
+		}
+		if _, err = i.getOrCreateInstance(entry.Name()); err != nil {
+			return fmt.Errorf("failed to replay the write-ahead log of tenant %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
 func (i *Ingester) running(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
@@ -188,7 +214,7 @@ func (i *Ingester) getOrCreateInstance(tenantID string) (*instance, error) {
 	if !ok {
 		var err error
 
-		inst, err = newInstance(i.phlarectx, i.dbConfig, tenantID, i.localBucket, i.storageBucket, NewLimiter(tenantID, i.limits, i.lifecycler, i.cfg.LifecyclerConfig.RingConfig.ReplicationFactor))
+		inst, err = newInstance(i.phlarectx, i.dbConfig, tenantID, i.localBucket, i.storageBucket, i.limits, NewLimiter(tenantID, i.limits, i.lifecycler, i.cfg.LifecyclerConfig.RingConfig.ReplicationFactor))
 		if err != nil {
 			return nil, err
 		}
@@ -236,7 +262,7 @@ func (i *Ingester) getOrOpenInstance(tenantID string) (*instance, error) {
 	}
 
 	limiter := NewLimiter(tenantID, i.limits, i.lifecycler, i.cfg.LifecyclerConfig.RingConfig.ReplicationFactor)
-	inst, err = newInstance(i.phlarectx, i.dbConfig, tenantID, i.localBucket, i.storageBucket, limiter)
+	inst, err = newInstance(i.phlarectx, i.dbConfig, tenantID, i.localBucket, i.storageBucket, i.limits, limiter)
 	if err != nil {
 		return nil, err
 	}
@@ -260,6 +286,9 @@ func push[T any](ctx context.Context, i *Ingester, f func(*instance) (T, error))
 	if err != nil {
 		return res, connect.NewError(connect.CodeInvalidArgument, err)
 	}
+	if i.deletedTenants.markedForDeletion(tenantID) {
+		return res, connect.NewError(connect.CodeFailedPrecondition, errTenantMarkedForDeletion)
+	}
 	instance, err := i.getOrCreateInstance(tenantID)
 	if err != nil {
 		return res, connect.NewError(connect.CodeInternal, err)
@@ -29,7 +29,7 @@ type instance struct {
 	tenantID string
 }
 
-func newInstance(phlarectx context.Context, cfg phlaredb.Config, tenantID string, localBucket, storageBucket phlareobj.Bucket, limiter Limiter) (*instance, error) {
+func newInstance(phlarectx context.Context, cfg phlaredb.Config, tenantID string, localBucket, storageBucket phlareobj.Bucket, cfgProvider phlareobj.TenantConfigProvider, limiter Limiter) (*instance, error) {
 	cfg.DataPath = path.Join(cfg.DataPath, tenantID)
 
 	// TODO(kolesnikovae): Get rid of phlarectx and pass logger and registry directly.
@@ -55,7 +55,7 @@ func newInstance(phlarectx context.Context, cfg phlaredb.Config, tenantID string
 			inst.logger,
 			inst.reg,
 			db,
-			phlareobj.NewTenantBucketClient(tenantID, storageBucket, nil),
+			phlareobj.NewTenantBucketClient(tenantID, storageBucket, cfgProvider),
 			block.IngesterSource,
 			false,
 			false,
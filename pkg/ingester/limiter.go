@@ -9,6 +9,7 @@ import (
 	"github.com/samber/lo"
 
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
 	"github.com/grafana/pyroscope/pkg/util"
 	"github.com/grafana/pyroscope/pkg/validation"
 )
@@ -20,9 +21,13 @@ var (
 
 type RingCount interface {
 	HealthyInstancesCount() int
+	ZonesCount() int
 }
 
 type Limits interface {
+	// The S3 SSE config of the blocks shipped to the object storage.
+	phlareobj.TenantConfigProvider
+
 	MaxLocalSeriesPerTenant(tenantID string) int
 	MaxGlobalSeriesPerTenant(tenantID string) int
 	IngestionTenantShardSize(tenantID string) int
@@ -170,8 +175,13 @@ func (l *limiter) convertGlobalToLocalLimit(tenantID string, globalLimit int) in
 	// size, then we should honor the shard size because series/metadata won't
 	// be written to more ingesters than it.
 	if shardSize := l.limits.IngestionTenantShardSize(tenantID); shardSize > 0 {
+		// With zone-aware replication, the shard is evenly distributed across
+		// the zones, and the shard size is rounded up to a multiple of the
+		// number of zones. If the zone-awareness is disabled, all the instances
+		// belong to the same (empty) zone.
+		numZones := max(1, l.ring.ZonesCount())
 		// We use Min() to protect from the case the expected shard size is > available ingesters.
-		numIngesters = lo.Min([]int{numIngesters, util.ShuffleShardExpectedInstances(shardSize, 1)})
+		numIngesters = lo.Min([]int{numIngesters, util.ShuffleShardExpectedInstances(shardSize, numZones)})
 	}
 
 	return int((float64(globalLimit) / float64(numIngesters)) * float64(l.replicationFactor))
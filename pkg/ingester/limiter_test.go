@@ -36,21 +36,30 @@ func (f *fakeLimits) DistributorUsageGroups(userID string) *validation.UsageGrou
 	return &validation.UsageGroupConfig{}
 }
 
+func (f *fakeLimits) S3SSEType(string) string                 { return "" }
+func (f *fakeLimits) S3SSEKMSKeyID(string) string             { return "" }
+func (f *fakeLimits) S3SSEKMSEncryptionContext(string) string { return "" }
+
 type fakeRingCount struct {
 	healthyInstancesCount int
+	zonesCount            int
 }
 
 func (f *fakeRingCount) HealthyInstancesCount() int {
 	return f.healthyInstancesCount
 }
 
+func (f *fakeRingCount) ZonesCount() int {
+	return f.zonesCount
+}
+
 func TestGlobalMaxSeries(t *testing.T) {
 	// 5 series per user, 2 ingesters, replication factor 3.
 	// We should be able to push 7.5 series. (5 / 2 * 3 = 7.5)
 	activeSeriesTimeout = 200 * time.Millisecond
 	activeSeriesCleanup = 100 * time.Millisecond
 
-	limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 5}, &fakeRingCount{2}, 3)
+	limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 5}, &fakeRingCount{healthyInstancesCount: 2}, 3)
 	defer limiter.Stop()
 
 	for i := 0; i < 7; i++ {
@@ -89,7 +98,7 @@ func assertMaxSeries(t testing.TB, limiter Limiter, count int) {
 
 func TestLocalLimit(t *testing.T) {
 	t.Run("local limit", func(t *testing.T) {
-		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 5, maxLocalSeriesPerTenant: 1}, &fakeRingCount{5}, 3)
+		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 5, maxLocalSeriesPerTenant: 1}, &fakeRingCount{healthyInstancesCount: 5}, 3)
 		defer limiter.Stop()
 
 		// local limit of 1 series should take precedence over global limit of 5 series.
@@ -97,7 +106,7 @@ func TestLocalLimit(t *testing.T) {
 	})
 
 	t.Run("local limit enforced by diving global limit", func(t *testing.T) {
-		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 3}, &fakeRingCount{9}, 3)
+		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 3}, &fakeRingCount{healthyInstancesCount: 9}, 3)
 		defer limiter.Stop()
 
 		// local limit of 1 should be per ingester (globalLimit * replicationFactor) / ingesterNum
@@ -105,7 +114,7 @@ func TestLocalLimit(t *testing.T) {
 	})
 
 	t.Run("ensure we do not panic with zero ingesters", func(t *testing.T) {
-		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 3}, &fakeRingCount{0}, 3)
+		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 3}, &fakeRingCount{healthyInstancesCount: 0}, 3)
 		defer limiter.Stop()
 
 		// we can ingest as many series as we want
@@ -113,10 +122,19 @@ func TestLocalLimit(t *testing.T) {
 	})
 
 	t.Run("ensure we handle sharding correctly", func(t *testing.T) {
-		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 3, ingestionTenantShardSize: 3}, &fakeRingCount{9}, 3)
+		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 3, ingestionTenantShardSize: 3}, &fakeRingCount{healthyInstancesCount: 9}, 3)
 		defer limiter.Stop()
 
 		// local limit of 3 should be per ingester (globalLimit * replicationFactor) / shardSize
 		assertMaxSeries(t, limiter, 3)
 	})
+
+	t.Run("ensure we handle zone-aware sharding correctly", func(t *testing.T) {
+		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 6, ingestionTenantShardSize: 4}, &fakeRingCount{healthyInstancesCount: 9, zonesCount: 3}, 3)
+		defer limiter.Stop()
+
+		// The shard size is rounded up to 2 ingesters per zone, 6 in total:
+		// local limit of 3 should be per ingester (globalLimit * replicationFactor) / 6
+		assertMaxSeries(t, limiter, 3)
+	})
 }
@@ -0,0 +1,87 @@
+package ingester
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucket"
+)
+
+const (
+	tenantDeletionCheckInterval = time.Minute
+	tenantDeletionCheckTimeout  = 10 * time.Second
+)
+
+var errTenantMarkedForDeletion = errors.New("tenant is marked for deletion")
+
+// tenantDeletionChecker tells whether the tenant is marked for deletion in
+// the storage. The push requests never wait for the bucket: the result is
+// cached and refreshed in the background, one request per tenant at a time,
+// once it is older than the interval. Until the first check completes, the
+// tenant is considered not deleted. Errors are logged and the previous
+// result is kept.
+type tenantDeletionChecker struct {
+	bucket   phlareobj.Bucket
+	logger   log.Logger
+	interval time.Duration
+
+	mtx     sync.Mutex
+	tenants map[string]tenantDeletionStatus
+	// refreshes is the number of the checks in progress.
+	refreshes sync.WaitGroup
+}
+
+type tenantDeletionStatus struct {
+	marked     bool
+	checkedAt  time.Time
+	refreshing bool
+}
+
+func newTenantDeletionChecker(bucket phlareobj.Bucket, logger log.Logger) *tenantDeletionChecker {
+	return &tenantDeletionChecker{
+		bucket:   bucket,
+		logger:   logger,
+		interval: tenantDeletionCheckInterval,
+		tenants:  make(map[string]tenantDeletionStatus),
+	}
+}
+
+func (c *tenantDeletionChecker) markedForDeletion(tenantID string) bool {
+	if c.bucket == nil {
+		return false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	s := c.tenants[tenantID]
+	if !s.refreshing && time.Since(s.checkedAt) >= c.interval {
+		s.refreshing = true
+		c.tenants[tenantID] = s
+		c.refreshes.Add(1)
+		go c.refresh(tenantID)
+	}
+	return s.marked
+}
+
+func (c *tenantDeletionChecker) refresh(tenantID string) {
+	defer c.refreshes.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), tenantDeletionCheckTimeout)
+	defer cancel()
+	marked, err := bucket.TenantDeletionMarkExists(ctx, c.bucket, tenantID)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	s := c.tenants[tenantID]
+	s.refreshing = false
+	s.checkedAt = time.Now()
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "unable to check if tenant is marked for deletion", "tenant", tenantID, "err", err)
+	} else {
+		s.marked = marked
+	}
+	c.tenants[tenantID] = s
+}
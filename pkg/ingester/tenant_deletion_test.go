@@ -0,0 +1,44 @@
+package ingester
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucket"
+)
+
+func Test_TenantDeletionChecker(t *testing.T) {
+	ctx := context.Background()
+	bkt := phlareobj.NewBucket(objstore.NewInMemBucket())
+	c := newTenantDeletionChecker(bkt, log.NewNopLogger())
+
+	require.NoError(t, bucket.WriteTenantDeletionMark(ctx, bkt, "tenant-a", nil, bucket.NewTenantDeletionMark(time.Now())))
+	// The push is not blocked on the first check.
+	assert.False(t, c.markedForDeletion("tenant-a"))
+	c.refreshes.Wait()
+	assert.True(t, c.markedForDeletion("tenant-a"))
+
+	require.NoError(t, bkt.Delete(ctx, path.Join("tenant-a", "phlaredb", bucket.TenantDeletionMarkPath)))
+	// The previous result is cached.
+	assert.True(t, c.markedForDeletion("tenant-a"))
+	c.refreshes.Wait()
+	assert.True(t, c.markedForDeletion("tenant-a"))
+
+	c.interval = 0
+	assert.True(t, c.markedForDeletion("tenant-a"))
+	c.refreshes.Wait()
+	assert.False(t, c.markedForDeletion("tenant-a"))
+	c.refreshes.Wait()
+	assert.False(t, c.markedForDeletion("tenant-b"))
+	c.refreshes.Wait()
+
+	assert.False(t, newTenantDeletionChecker(nil, log.NewNopLogger()).markedForDeletion("tenant-a"))
+}
@@ -13,7 +13,33 @@ import (
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 )
 
+// TruncationStats describes the nodes removed from a flame graph when the
+// number of nodes is limited: the nodes below the minimal value are pruned
+// and their value is aggregated into the "other" nodes.
+type TruncationStats struct {
+	// PrunedNodes is the number of nodes removed, including the nodes
+	// of the subtrees of the pruned nodes.
+	PrunedNodes int64
+	// OtherValue is the total value aggregated into the "other" nodes.
+	OtherValue int64
+}
+
+// Truncated reports whether any node has been pruned.
+func (s TruncationStats) Truncated() bool {
+	return s.PrunedNodes > 0 || s.OtherValue > 0
+}
+
 func NewFlameGraph(t *Tree, maxNodes int64) *querierv1.FlameGraph {
+	fg, _ := NewFlameGraphWithStats(t, maxNodes)
+	return fg
+}
+
+// NewFlameGraphWithStats builds the flame graph of the tree limiting the
+// number of nodes to maxNodes, and reports the truncation applied.
+// The "other" nodes already present in the tree, e.g. in the result of
+// a truncated sub-query, are accounted as truncated as well.
+func NewFlameGraphWithStats(t *Tree, maxNodes int64) (*querierv1.FlameGraph, TruncationStats) {
+	var stats TruncationStats
 	var total, max int64
 	for _, node := range t.root {
 		total += node.total
@@ -78,9 +104,13 @@ func NewFlameGraph(t *Tree, maxNodes int64) *querierv1.FlameGraph {
 				current.xOffset += int(child.total)
 			} else {
 				otherTotal += child.total
+				if child.name != truncatedNodeName {
+					stats.PrunedNodes += subtreeSize(child)
+				}
 			}
 		}
 		if otherTotal != 0 {
+			stats.OtherValue += otherTotal
 			child := &node{
 				name:   "other",
 				parent: current.node,
@@ -116,7 +146,38 @@ func NewFlameGraph(t *Tree, maxNodes int64) *querierv1.FlameGraph {
 		Levels:  levels,
 		Total:   total,
 		MaxSelf: max,
+	}, stats
+}
+
+// subtreeSize returns the number of nodes in the subtree rooted at n.
+func subtreeSize(n *node) int64 {
+	var s int64
+	nodes := []*node{n}
+	for len(nodes) > 0 {
+		last := len(nodes) - 1
+		n, nodes = nodes[last], nodes[:last]
+		nodes = append(nodes, n.children...)
+		s++
 	}
+	return s
+}
+
+// ScaleFlameGraph multiplies the values of the flame graph by the factor,
+// in place.
+func ScaleFlameGraph(fg *querierv1.FlameGraph, factor int64) {
+	if fg == nil || factor == 1 {
+		return
+	}
+	for _, l := range fg.Levels {
+		// The fourth value of a node is the index in the names array.
+		for i := range l.Values {
+			if i%4 != 3 {
+				l.Values[i] *= factor
+			}
+		}
+	}
+	fg.Total *= factor
+	fg.MaxSelf *= factor
 }
 
 // ExportToFlamebearer exports the flamegraph to a Flamebearer struct.
@@ -124,17 +185,7 @@ func ExportToFlamebearer(fg *querierv1.FlameGraph, profileType *typesv1.ProfileT
 	if fg == nil {
 		fg = &querierv1.FlameGraph{}
 	}
-	unit := metadata.Units(profileType.SampleUnit)
-	sampleRate := uint32(100)
-
-	switch profileType.SampleType {
-	case "inuse_objects", "alloc_objects", "goroutine", "samples":
-		unit = metadata.ObjectsUnits
-	case "cpu":
-		unit = metadata.SamplesUnits
-		sampleRate = uint32(1_000_000_000)
-
-	}
+	def := ProfileTypes.Lookup(profileType)
 	levels := make([][]int, len(fg.Levels))
 	for i := range levels {
 		levels[i] = lo.Map(fg.Levels[i].Values, func(v int64, i int) int { return int(v) })
@@ -150,9 +201,9 @@ func ExportToFlamebearer(fg *querierv1.FlameGraph, profileType *typesv1.ProfileT
 			},
 			Metadata: flamebearer.FlamebearerMetadataV1{
 				Format:     "single",
-				Units:      unit,
+				Units:      metadata.Units(def.Unit),
 				Name:       profileType.SampleType,
-				SampleRate: sampleRate,
+				SampleRate: def.SampleRate,
 			},
 		},
 	}
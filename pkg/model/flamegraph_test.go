@@ -169,3 +169,48 @@ func Test_FlameGraphMerger(t *testing.T) {
 		require.Equal(t, new(Tree).String(), m.Tree().String())
 	})
 }
+
+func Test_NewFlameGraphWithStats(t *testing.T) {
+	newTree := func() *Tree {
+		s := new(Tree)
+		s.InsertStack(8, "a", "b")
+		s.InsertStack(1, "a", "c", "d")
+		s.InsertStack(1, "a", "c", "e")
+		s.InsertStack(2, "f")
+		return s
+	}
+
+	t.Run("not truncated", func(t *testing.T) {
+		_, stats := NewFlameGraphWithStats(newTree(), -1)
+		require.False(t, stats.Truncated())
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		fg, stats := NewFlameGraphWithStats(newTree(), 3)
+		require.True(t, stats.Truncated())
+		require.Equal(t, TruncationStats{PrunedNodes: 2, OtherValue: 2}, stats)
+		require.Contains(t, fg.Names, "other")
+		require.NotContains(t, fg.Names, "d")
+		require.Equal(t, int64(12), fg.Total)
+	})
+
+	t.Run("truncated sub-query result", func(t *testing.T) {
+		s := newTree()
+		s.InsertStack(3, "a", "other")
+		_, stats := NewFlameGraphWithStats(s, -1)
+		require.Equal(t, TruncationStats{OtherValue: 3}, stats)
+	})
+}
+
+func Test_ScaleFlameGraph(t *testing.T) {
+	s := new(Tree)
+	s.InsertStack(1, "a", "b")
+	s.InsertStack(2, "a", "c")
+	fg := NewFlameGraph(s, -1)
+	ScaleFlameGraph(fg, 10)
+
+	expected := new(Tree)
+	expected.InsertStack(10, "a", "b")
+	expected.InsertStack(20, "a", "c")
+	require.Equal(t, NewFlameGraph(expected, -1), fg)
+}
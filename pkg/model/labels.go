@@ -32,6 +32,12 @@ const (
 	LabelNameSessionID          = "__session_id__"
 	LabelNameType               = "__type__"
 	LabelNameUnit               = "__unit__"
+	// LabelNameStale marks the series that have not received profiles
+	// recently in the series responses, see validation.Limits.SeriesStaleAfter.
+	LabelNameStale = "__stale__"
+	// LabelNameEndOfSeries marks the empty profiles sent by the agents when
+	// a target is gone.
+	LabelNameEndOfSeries = "__end_of_series__"
 
 	LabelNameServiceGitRef     = "service_git_ref"
 	LabelNameServiceName       = "service_name"
@@ -43,6 +49,11 @@ const (
 
 	LabelNamePyroscopeSpy = "pyroscope_spy"
 
+	// LabelNameResourceAttributePrefix is the prefix of the labels carrying
+	// the metadata of the host and of the agent, e.g., the kernel release.
+	// These labels are stored as profile annotations instead of series labels.
+	LabelNameResourceAttributePrefix = "__resource_"
+
 	AttrProcessExecutableName = semconv.ProcessExecutableNameKey
 
 	AttrServiceName         = semconv.ServiceNameKey
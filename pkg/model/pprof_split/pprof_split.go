@@ -16,6 +16,9 @@ type SampleSeriesVisitor interface {
 	// Provided labels are the series labels processed with relabeling rules.
 	VisitProfile(phlaremodel.Labels)
 	VisitSampleSeries(phlaremodel.Labels, []*profilev1.Sample)
+	// SanitizeLabels is called after the relabeling rules are applied,
+	// and before the labels are validated.
+	SanitizeLabels(*phlaremodel.LabelsBuilder)
 	// ValidateLabels is called to validate the labels before
 	// they are passed to the visitor.
 	ValidateLabels(phlaremodel.Labels) error
@@ -41,14 +44,11 @@ func VisitSampleSeries(
 		// No sample labels in the profile.
 		// Relabel the series labels.
 		builder.Reset(labels)
-		if len(rules) > 0 {
-			keep := relabel.ProcessBuilder(builder, rules...)
-			if !keep {
-				// We drop the profile.
-				profilesDiscarded++
-				bytesDiscarded += profile.SizeVT()
-				return nil
-			}
+		if !relabelBuilder(builder, rules, visitor) {
+			// We drop the profile.
+			profilesDiscarded++
+			bytesDiscarded += profile.SizeVT()
+			return nil
 		}
 		if len(profile.Sample) > 0 {
 			labels = builder.Labels()
@@ -65,12 +65,9 @@ func VisitSampleSeries(
 	for _, group := range groups {
 		builder.Reset(labels)
 		addSampleLabelsToLabelsBuilder(builder, profile, group.Labels)
-		if len(rules) > 0 {
-			keep := relabel.ProcessBuilder(builder, rules...)
-			if !keep {
-				bytesDiscarded += sampleSize(group.Samples)
-				continue
-			}
+		if !relabelBuilder(builder, rules, visitor) {
+			bytesDiscarded += sampleSize(group.Samples)
+			continue
 		}
 		// add the group to the list.
 		groupsKept.add(profile.StringTable, builder.Labels(), group)
@@ -96,6 +93,16 @@ func VisitSampleSeries(
 	return nil
 }
 
+// relabelBuilder applies the relabeling rules and the visitor sanitization
+// to the labels, and reports whether the labels are kept.
+func relabelBuilder(builder *phlaremodel.LabelsBuilder, rules []*relabel.Config, visitor SampleSeriesVisitor) bool {
+	if len(rules) > 0 && !relabel.ProcessBuilder(builder, rules...) {
+		return false
+	}
+	visitor.SanitizeLabels(builder)
+	return true
+}
+
 // addSampleLabelsToLabelsBuilder: adds sample label that don't exists yet on the profile builder. So the existing labels take precedence.
 func addSampleLabelsToLabelsBuilder(b *phlaremodel.LabelsBuilder, p *profilev1.Profile, pl []*profilev1.Label) {
 	var name string
@@ -73,6 +73,10 @@ type sampleGroup struct {
 	samples []*profilev1.Sample
 }
 
+func (m *sampleSeriesMerger) SanitizeLabels(lb *phlaremodel.LabelsBuilder) {
+	m.visitor.SanitizeLabels(lb)
+}
+
 func (m *sampleSeriesMerger) ValidateLabels(labels phlaremodel.Labels) error {
 	return m.visitor.ValidateLabels(labels)
 }
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/og/storage/metadata"
+)
+
+// ProfileTypeDefinition describes the semantics of the values of a sample
+// type: how they are displayed, aggregated over time, and whether they are
+// cumulative.
+type ProfileTypeDefinition struct {
+	// SampleType is the sample type the definition applies to, for example,
+	// alloc_space or gpu_launches.
+	SampleType string `yaml:"sample_type"`
+	// Unit is the unit the values are displayed in: samples, objects,
+	// goroutines, bytes, lock_nanoseconds, lock_samples, or any other unit.
+	// If empty, the sample unit of the profile type is used.
+	Unit string `yaml:"unit"`
+	// SampleRate is the number of values per second, for the samples unit.
+	SampleRate uint32 `yaml:"sample_rate"`
+	// Aggregation is how the values are aggregated over time in the
+	// timelines: sum, or average for the values measuring a state, such as
+	// the memory in use.
+	Aggregation string `yaml:"aggregation"`
+	// Cumulative is true if the values accumulate since the start of the
+	// process: the difference with the previous profile of the series is
+	// stored, unless the profile is ingested with __delta__="false".
+	Cumulative bool `yaml:"cumulative"`
+}
+
+const (
+	AggregationSum     = "sum"
+	AggregationAverage = "average"
+
+	defaultSampleRate = 100
+)
+
+func (d *ProfileTypeDefinition) Validate() error {
+	if d.SampleType == "" {
+		return fmt.Errorf("the profile type sample type is required")
+	}
+	switch d.Aggregation {
+	case "", AggregationSum, AggregationAverage:
+	default:
+		return fmt.Errorf("invalid aggregation %q of the profile type %s: must be sum or average", d.Aggregation, d.SampleType)
+	}
+	return nil
+}
+
+// TimeSeriesAggregation returns the aggregation of the values over time.
+func (d *ProfileTypeDefinition) TimeSeriesAggregation() typesv1.TimeSeriesAggregationType {
+	if d.Aggregation == AggregationAverage {
+		return typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_AVERAGE
+	}
+	return typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM
+}
+
+// UnitConversion describes the conversion of the values of a sample type
+// to the units shared by the sample types measuring the same quantity.
+type UnitConversion struct {
+	Units      metadata.Units
+	SampleRate uint32
+	// Factor is the value the sample values are multiplied by.
+	Factor int64
+}
+
+// nanosecondsUnit is the sample unit of the sample types measuring time,
+// e.g. the off-CPU time.
+const nanosecondsUnit = "nanoseconds"
+
+// NormalizedUnits returns the conversion of the values to consistent units:
+// the values measuring time, the samples at the sample rate or the
+// nanoseconds, are converted to samples at the nanosecond rate, so that
+// the CPU and off-CPU time of different sample types can be compared.
+// The values of the other units are left as is.
+func (d *ProfileTypeDefinition) NormalizedUnits() UnitConversion {
+	c := UnitConversion{Units: metadata.Units(d.Unit), SampleRate: d.SampleRate, Factor: 1}
+	switch {
+	case d.Unit == nanosecondsUnit:
+		c.Units = metadata.SamplesUnits
+		c.SampleRate = 1_000_000_000
+	case c.Units == metadata.SamplesUnits && d.SampleRate > 0 && 1_000_000_000%d.SampleRate == 0:
+		c.Factor = int64(1_000_000_000 / d.SampleRate)
+		c.SampleRate = 1_000_000_000
+	}
+	return c
+}
+
+// builtinProfileTypes are the sample types of the profiles of the Go
+// runtime and the Pyroscope SDKs.
+var builtinProfileTypes = []ProfileTypeDefinition{
+	{SampleType: "cpu", Unit: string(metadata.SamplesUnits), SampleRate: 1_000_000_000},
+	{SampleType: "samples", Unit: string(metadata.ObjectsUnits)},
+	{SampleType: "goroutine", Unit: string(metadata.ObjectsUnits), Aggregation: AggregationAverage},
+	{SampleType: "alloc_objects", Unit: string(metadata.ObjectsUnits), Cumulative: true},
+	{SampleType: "alloc_space", Cumulative: true},
+	{SampleType: "inuse_objects", Unit: string(metadata.ObjectsUnits), Aggregation: AggregationAverage},
+	{SampleType: "inuse_space", Aggregation: AggregationAverage},
+}
+
+// The views of the memory profiles: the memory in use at the time of the
+// profile, live, or the memory allocated during the profile period.
+const (
+	HeapViewInUse = "inuse"
+	HeapViewAlloc = "alloc"
+
+	memoryProfileName = "memory"
+)
+
+// HeapViewProfileType returns the profile type of the view of the memory
+// profile type, measuring the same quantity, the space or the objects. For
+// example, the inuse view of memory:alloc_space:bytes:space:bytes is
+// memory:inuse_space:bytes:space:bytes.
+func HeapViewProfileType(pt *typesv1.ProfileType, view string) (*typesv1.ProfileType, error) {
+	if view != HeapViewInUse && view != HeapViewAlloc {
+		return nil, fmt.Errorf("invalid heap view %q: must be %s or %s", view, HeapViewInUse, HeapViewAlloc)
+	}
+	_, quantity, ok := strings.Cut(pt.SampleType, "_")
+	if pt.Name != memoryProfileName || !ok || !slices.Contains(heapSampleTypes, pt.SampleType) {
+		return nil, fmt.Errorf("the profile type %s has no %s view", pt.ID, view)
+	}
+	parts := strings.Split(pt.ID, ":")
+	parts[1] = view + "_" + quantity
+	return ParseProfileTypeSelector(strings.Join(parts, ":"))
+}
+
+var heapSampleTypes = []string{"alloc_objects", "alloc_space", "inuse_objects", "inuse_space"}
+
+// ProfileTypeRegistry holds the definitions of the known sample types.
+type ProfileTypeRegistry struct {
+	mtx   sync.RWMutex
+	types map[string]ProfileTypeDefinition
+}
+
+func NewProfileTypeRegistry(defs ...ProfileTypeDefinition) *ProfileTypeRegistry {
+	r := &ProfileTypeRegistry{types: make(map[string]ProfileTypeDefinition)}
+	r.Register(builtinProfileTypes...)
+	r.Register(defs...)
+	return r
+}
+
+// Register adds the definitions to the registry, replacing the definitions
+// of the same sample types.
+func (r *ProfileTypeRegistry) Register(defs ...ProfileTypeDefinition) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, d := range defs {
+		r.types[d.SampleType] = d
+	}
+}
+
+// Lookup returns the definition of the sample type of the profile type.
+// The sample types without definition are displayed in their sample unit,
+// summed over time, and not cumulative.
+func (r *ProfileTypeRegistry) Lookup(pt *typesv1.ProfileType) ProfileTypeDefinition {
+	r.mtx.RLock()
+	d, ok := r.types[pt.SampleType]
+	r.mtx.RUnlock()
+	if !ok {
+		d = ProfileTypeDefinition{SampleType: pt.SampleType}
+	}
+	if d.Unit == "" {
+		d.Unit = pt.SampleUnit
+	}
+	if d.SampleRate == 0 {
+		d.SampleRate = defaultSampleRate
+	}
+	return d
+}
+
+// IsCumulative reports whether the values of the sample type are cumulative.
+func (r *ProfileTypeRegistry) IsCumulative(sampleType string) bool {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.types[sampleType].Cumulative
+}
+
+// ProfileTypes is the registry of the sample types of the process, with the
+// definitions of the configuration registered on start-up.
+var ProfileTypes = NewProfileTypeRegistry()
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+func Test_ProfileTypeRegistry(t *testing.T) {
+	r := NewProfileTypeRegistry(
+		ProfileTypeDefinition{SampleType: "gpu_launches", Unit: "objects", Cumulative: true},
+		ProfileTypeDefinition{SampleType: "inuse_space", Unit: "megabytes"},
+	)
+
+	cpu := r.Lookup(&typesv1.ProfileType{SampleType: "cpu", SampleUnit: "nanoseconds"})
+	assert.Equal(t, "samples", cpu.Unit)
+	assert.Equal(t, uint32(1_000_000_000), cpu.SampleRate)
+	assert.Equal(t, typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM, cpu.TimeSeriesAggregation())
+
+	gpu := r.Lookup(&typesv1.ProfileType{SampleType: "gpu_launches", SampleUnit: "count"})
+	assert.Equal(t, "objects", gpu.Unit)
+	assert.Equal(t, uint32(100), gpu.SampleRate)
+	assert.True(t, r.IsCumulative("gpu_launches"))
+
+	// The built-in definition is replaced.
+	inuse := r.Lookup(&typesv1.ProfileType{SampleType: "inuse_space", SampleUnit: "bytes"})
+	assert.Equal(t, "megabytes", inuse.Unit)
+	assert.Equal(t, typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM, inuse.TimeSeriesAggregation())
+
+	unknown := r.Lookup(&typesv1.ProfileType{SampleType: "io", SampleUnit: "bytes"})
+	assert.Equal(t, ProfileTypeDefinition{SampleType: "io", Unit: "bytes", SampleRate: 100}, unknown)
+	assert.False(t, r.IsCumulative("io"))
+	assert.True(t, r.IsCumulative("alloc_space"))
+}
+
+func Test_ProfileTypeDefinition_Validate(t *testing.T) {
+	require.NoError(t, (&ProfileTypeDefinition{SampleType: "io", Aggregation: "average"}).Validate())
+	require.Error(t, (&ProfileTypeDefinition{Aggregation: "sum"}).Validate())
+	require.Error(t, (&ProfileTypeDefinition{SampleType: "io", Aggregation: "max"}).Validate())
+}
+
+func Test_ProfileTypeDefinition_NormalizedUnits(t *testing.T) {
+	r := NewProfileTypeRegistry()
+	for _, tc := range []struct {
+		pt       *typesv1.ProfileType
+		expected UnitConversion
+	}{
+		{
+			pt:       &typesv1.ProfileType{SampleType: "cpu", SampleUnit: "nanoseconds"},
+			expected: UnitConversion{Units: "samples", SampleRate: 1_000_000_000, Factor: 1},
+		},
+		{
+			pt:       &typesv1.ProfileType{SampleType: "wall", SampleUnit: "samples"},
+			expected: UnitConversion{Units: "samples", SampleRate: 1_000_000_000, Factor: 10_000_000},
+		},
+		{
+			pt:       &typesv1.ProfileType{SampleType: "offcpu", SampleUnit: "nanoseconds"},
+			expected: UnitConversion{Units: "samples", SampleRate: 1_000_000_000, Factor: 1},
+		},
+		{
+			pt:       &typesv1.ProfileType{SampleType: "alloc_space", SampleUnit: "bytes"},
+			expected: UnitConversion{Units: "bytes", SampleRate: 100, Factor: 1},
+		},
+	} {
+		def := r.Lookup(tc.pt)
+		assert.Equal(t, tc.expected, def.NormalizedUnits(), tc.pt.SampleType)
+	}
+}
+
+func Test_HeapViewProfileType(t *testing.T) {
+	pt, err := ParseProfileTypeSelector("memory:alloc_space:bytes:space:bytes")
+	require.NoError(t, err)
+
+	inuse, err := HeapViewProfileType(pt, HeapViewInUse)
+	require.NoError(t, err)
+	assert.Equal(t, "memory:inuse_space:bytes:space:bytes", inuse.ID)
+	assert.Equal(t, "inuse_space", inuse.SampleType)
+
+	alloc, err := HeapViewProfileType(inuse, HeapViewAlloc)
+	require.NoError(t, err)
+	assert.Equal(t, pt.ID, alloc.ID)
+
+	_, err = HeapViewProfileType(pt, "live")
+	require.Error(t, err)
+	cpu, err := ParseProfileTypeSelector("process_cpu:cpu:nanoseconds:cpu:nanoseconds")
+	require.NoError(t, err)
+	_, err = HeapViewProfileType(cpu, HeapViewInUse)
+	require.Error(t, err)
+}
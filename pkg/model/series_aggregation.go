@@ -0,0 +1,114 @@
+package model
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sort"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+// SeriesAggregation is the function combining the series of the replicas,
+// the series not distinguished by the group by labels, at every point.
+type SeriesAggregation string
+
+const (
+	// SeriesAggregationSum adds the values of the replicas, suited to the
+	// cumulative profile types, like the cpu.
+	SeriesAggregationSum SeriesAggregation = "sum"
+	// SeriesAggregationAvg averages the values of the replicas reporting at
+	// a point, suited to the gauges, like the memory in use or goroutines.
+	SeriesAggregationAvg SeriesAggregation = "avg"
+	// SeriesAggregationMax keeps the value of the heaviest replica.
+	SeriesAggregationMax SeriesAggregation = "max"
+)
+
+func ParseSeriesAggregation(s string) (SeriesAggregation, error) {
+	switch a := SeriesAggregation(s); a {
+	case "":
+		return SeriesAggregationSum, nil
+	case SeriesAggregationSum, SeriesAggregationAvg, SeriesAggregationMax:
+		return a, nil
+	}
+	return "", fmt.Errorf("unsupported series aggregation %q, expected one of sum, avg, max", s)
+}
+
+// AggregateSeries combines the series into one series per distinct value of
+// the groupBy labels. The series are expected to be split by replica, the
+// values of the replicas at a timestamp are combined with fn.
+func AggregateSeries(series []*typesv1.Series, groupBy []string, fn SeriesAggregation) []*typesv1.Series {
+	type point struct {
+		value       float64
+		count       int
+		annotations []*typesv1.ProfileAnnotation
+	}
+	type group struct {
+		labels Labels
+		points map[int64]*point
+	}
+	groups := make(map[uint64]*group)
+	for _, s := range series {
+		lbs := Labels(s.Labels).WithLabels(groupBy...)
+		h := lbs.Hash()
+		g, ok := groups[h]
+		if !ok {
+			g = &group{labels: lbs, points: make(map[int64]*point)}
+			groups[h] = g
+		}
+		for _, p := range s.Points {
+			a, ok := g.points[p.Timestamp]
+			if !ok {
+				a = &point{value: p.Value}
+				g.points[p.Timestamp] = a
+			} else if fn == SeriesAggregationMax {
+				a.value = max(a.value, p.Value)
+			} else {
+				a.value += p.Value
+			}
+			a.count++
+			a.annotations = append(a.annotations, p.Annotations...)
+		}
+	}
+	res := make([]*typesv1.Series, 0, len(groups))
+	for _, g := range groups {
+		s := &typesv1.Series{
+			Labels: g.labels,
+			Points: make([]*typesv1.Point, 0, len(g.points)),
+		}
+		for ts, a := range g.points {
+			v := a.value
+			if fn == SeriesAggregationAvg {
+				v /= float64(a.count)
+			}
+			s.Points = append(s.Points, &typesv1.Point{
+				Timestamp:   ts,
+				Value:       v,
+				Annotations: a.annotations,
+			})
+		}
+		slices.SortFunc(s.Points, func(a, b *typesv1.Point) int {
+			return cmp.Compare(a.Timestamp, b.Timestamp)
+		})
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return CompareLabelPairs(res[i].Labels, res[j].Labels) < 0
+	})
+	return res
+}
+
+// HeaviestSeries returns the series with the highest value, nil if there
+// are no points.
+func HeaviestSeries(series []*typesv1.Series) *typesv1.Series {
+	var res *typesv1.Series
+	var peak float64
+	for _, s := range series {
+		for _, p := range s.Points {
+			if res == nil || p.Value > peak {
+				res, peak = s, p.Value
+			}
+		}
+	}
+	return res
+}
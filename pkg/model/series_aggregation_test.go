@@ -0,0 +1,79 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/testhelper"
+)
+
+func Test_AggregateSeries(t *testing.T) {
+	series := []*typesv1.Series{
+		{
+			Labels: LabelsFromStrings("namespace", "a", "pod", "a-1"),
+			Points: []*typesv1.Point{{Timestamp: 1, Value: 10}, {Timestamp: 2, Value: 30}},
+		},
+		{
+			Labels: LabelsFromStrings("namespace", "a", "pod", "a-2"),
+			Points: []*typesv1.Point{{Timestamp: 1, Value: 20}},
+		},
+		{
+			Labels: LabelsFromStrings("namespace", "b", "pod", "b-1"),
+			Points: []*typesv1.Point{{Timestamp: 1, Value: 5}},
+		},
+	}
+	for _, tc := range []struct {
+		fn      SeriesAggregation
+		groupBy []string
+		out     []*typesv1.Series
+	}{
+		{
+			fn: SeriesAggregationSum,
+			out: []*typesv1.Series{
+				{Labels: Labels{}, Points: []*typesv1.Point{{Timestamp: 1, Value: 35}, {Timestamp: 2, Value: 30}}},
+			},
+		},
+		{
+			fn: SeriesAggregationAvg,
+			out: []*typesv1.Series{
+				{Labels: Labels{}, Points: []*typesv1.Point{{Timestamp: 1, Value: 35. / 3}, {Timestamp: 2, Value: 30}}},
+			},
+		},
+		{
+			fn:      SeriesAggregationAvg,
+			groupBy: []string{"namespace"},
+			out: []*typesv1.Series{
+				{Labels: LabelsFromStrings("namespace", "a"), Points: []*typesv1.Point{{Timestamp: 1, Value: 15}, {Timestamp: 2, Value: 30}}},
+				{Labels: LabelsFromStrings("namespace", "b"), Points: []*typesv1.Point{{Timestamp: 1, Value: 5}}},
+			},
+		},
+		{
+			fn:      SeriesAggregationMax,
+			groupBy: []string{"namespace"},
+			out: []*typesv1.Series{
+				{Labels: LabelsFromStrings("namespace", "a"), Points: []*typesv1.Point{{Timestamp: 1, Value: 20}, {Timestamp: 2, Value: 30}}},
+				{Labels: LabelsFromStrings("namespace", "b"), Points: []*typesv1.Point{{Timestamp: 1, Value: 5}}},
+			},
+		},
+	} {
+		t.Run(string(tc.fn), func(t *testing.T) {
+			testhelper.EqualProto(t, tc.out, AggregateSeries(series, tc.groupBy, tc.fn))
+		})
+	}
+
+	require.Equal(t, series[0], HeaviestSeries(series))
+	require.Nil(t, HeaviestSeries(nil))
+}
+
+func Test_ParseSeriesAggregation(t *testing.T) {
+	a, err := ParseSeriesAggregation("")
+	require.NoError(t, err)
+	require.Equal(t, SeriesAggregationSum, a)
+	a, err = ParseSeriesAggregation("max")
+	require.NoError(t, err)
+	require.Equal(t, SeriesAggregationMax, a)
+	_, err = ParseSeriesAggregation("p99")
+	require.Error(t, err)
+}
@@ -0,0 +1,220 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+)
+
+// SymbolNormalizationRule rewrites the function names at query time, so
+// that the frames of logically identical functions produced by different
+// builds are aggregated together. A rule is either one of the presets, or
+// a regular expression replacing all its matches in the function names.
+type SymbolNormalizationRule struct {
+	// Preset is the name of a built-in rule: strip_template_args,
+	// collapse_lambda_numbers, or trim_build_paths.
+	Preset string `yaml:"preset,omitempty" json:"preset,omitempty"`
+	// Regex is the regular expression matched against the function names.
+	// Unlike the relabeling rules, the expression is not anchored.
+	Regex string `yaml:"regex,omitempty" json:"regex,omitempty"`
+	// Replacement is the replacement of the matches, $1 or ${name} are
+	// expanded to the submatches.
+	Replacement string `yaml:"replacement,omitempty" json:"replacement,omitempty"`
+}
+
+const (
+	SymbolNormalizationStripTemplateArgs     = "strip_template_args"
+	SymbolNormalizationCollapseLambdaNumbers = "collapse_lambda_numbers"
+	SymbolNormalizationTrimBuildPaths        = "trim_build_paths"
+)
+
+var (
+	// The numbering of the anonymous functions and lambdas depends on the
+	// order they are compiled in, and changes between the builds.
+	lambdaNumbers = []struct {
+		re          *regexp.Regexp
+		replacement string
+	}{
+		// Go: main.handler.func1.2
+		{regexp.MustCompile(`\.func\d+(\.\d+)*`), ".funcN"},
+		// Java: Handler.lambda$run$0
+		{regexp.MustCompile(`lambda\$(\w+)\$\d+`), "lambda$$${1}$$N"},
+		// Java: Handler$$Lambda$123/0x0000000800c0b000.run
+		{regexp.MustCompile(`\$\$Lambda(\$\d+)?/(0x)?[0-9a-fA-F]+`), "$$$$Lambda"},
+		// C++: main::{lambda(int)#2}::operator()
+		{regexp.MustCompile(`\{lambda\(([^)]*)\)#\d+\}`), "{lambda(${1})#N}"},
+	}
+
+	// The absolute paths of the source files, at the start of the name or
+	// after a space or an opening parenthesis, for example, in the names of
+	// the Python and Node.js frames: "handler (/build/1234/app/main.py:12)".
+	buildPath = regexp.MustCompile(`(^|[\s(])([A-Za-z]:)?([/\\][^/\\\s:()]+)+[/\\]`)
+)
+
+func (r *SymbolNormalizationRule) Validate() error {
+	_, err := r.normalizer()
+	return err
+}
+
+func (r *SymbolNormalizationRule) normalizer() (func(string) string, error) {
+	if r.Preset != "" && r.Regex != "" {
+		return nil, fmt.Errorf("a symbol normalization rule must have either a preset or a regex")
+	}
+	switch r.Preset {
+	case SymbolNormalizationStripTemplateArgs:
+		return stripTemplateArgs, nil
+	case SymbolNormalizationCollapseLambdaNumbers:
+		return collapseLambdaNumbers, nil
+	case SymbolNormalizationTrimBuildPaths:
+		return trimBuildPaths, nil
+	case "":
+	default:
+		return nil, fmt.Errorf("unknown symbol normalization preset %q", r.Preset)
+	}
+	if r.Regex == "" {
+		return nil, fmt.Errorf("a symbol normalization rule must have either a preset or a regex")
+	}
+	re, err := regexp.Compile(r.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid symbol normalization regex %q: %w", r.Regex, err)
+	}
+	replacement := r.Replacement
+	return func(name string) string {
+		return re.ReplaceAllString(name, replacement)
+	}, nil
+}
+
+// stripTemplateArgs removes the template and generic arguments enclosed
+// in angle brackets: std::vector<int>::push_back becomes
+// std::vector::push_back. The names with unbalanced brackets are not
+// changed, and the comparison and shift operators are preserved.
+func stripTemplateArgs(name string) string {
+	if strings.IndexByte(name, '<') < 0 {
+		return name
+	}
+	var b strings.Builder
+	b.Grow(len(name))
+	var depth int
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case strings.HasSuffix(name[:i], "operator") && (c == '<' || c == '>'):
+			// operator<, operator<<, operator<=, operator>>, ...
+			j := i
+			for j < len(name) && (name[j] == '<' || name[j] == '>' || name[j] == '=') {
+				j++
+			}
+			if depth == 0 {
+				b.WriteString(name[i:j])
+			}
+			i = j - 1
+		case c == '>' && i > 0 && name[i-1] == '-':
+			// operator->
+			if depth == 0 {
+				b.WriteByte(c)
+			}
+		case c == '<':
+			depth++
+		case c == '>':
+			depth--
+			if depth < 0 {
+				return name
+			}
+		case depth == 0:
+			b.WriteByte(c)
+		}
+	}
+	if depth != 0 {
+		return name
+	}
+	return b.String()
+}
+
+func collapseLambdaNumbers(name string) string {
+	for _, l := range lambdaNumbers {
+		name = l.re.ReplaceAllString(name, l.replacement)
+	}
+	return name
+}
+
+// trimBuildPaths removes the directories of the absolute paths, keeping the
+// file names.
+func trimBuildPaths(name string) string {
+	return buildPath.ReplaceAllString(name, "$1")
+}
+
+// SymbolNormalizer applies the symbol normalization rules in order.
+type SymbolNormalizer struct {
+	fns []func(string) string
+}
+
+func NewSymbolNormalizer(rules []SymbolNormalizationRule) (*SymbolNormalizer, error) {
+	n := &SymbolNormalizer{fns: make([]func(string) string, 0, len(rules))}
+	for i := range rules {
+		fn, err := rules[i].normalizer()
+		if err != nil {
+			return nil, err
+		}
+		n.fns = append(n.fns, fn)
+	}
+	return n, nil
+}
+
+func (n *SymbolNormalizer) Normalize(name string) string {
+	for _, fn := range n.fns {
+		name = fn(name)
+	}
+	return name
+}
+
+// cached returns the normalization function memoizing the results: the same
+// names occur in many nodes of a tree.
+func (n *SymbolNormalizer) cached() func(string) string {
+	cache := make(map[string]string)
+	return func(name string) string {
+		v, ok := cache[name]
+		if !ok {
+			v = n.Normalize(name)
+			cache[name] = v
+		}
+		return v
+	}
+}
+
+// NormalizeTree renames the nodes of the tree, and merges the nodes whose
+// names become identical.
+func (n *SymbolNormalizer) NormalizeTree(t *Tree) {
+	if len(n.fns) == 0 || t == nil {
+		return
+	}
+	t.FormatNodeNames(n.cached())
+}
+
+// NormalizeProfile renames the functions of the profile. The functions
+// are not merged: the consumers of the profile aggregate them by name.
+func (n *SymbolNormalizer) NormalizeProfile(p *profilev1.Profile) {
+	if len(n.fns) == 0 || p == nil {
+		return
+	}
+	normalize := n.cached()
+	strs := make(map[string]int64)
+	for _, fn := range p.Function {
+		if fn.Name < 0 || fn.Name >= int64(len(p.StringTable)) {
+			continue
+		}
+		name := p.StringTable[fn.Name]
+		normalized := normalize(name)
+		if normalized == name {
+			continue
+		}
+		idx, ok := strs[normalized]
+		if !ok {
+			idx = int64(len(p.StringTable))
+			p.StringTable = append(p.StringTable, normalized)
+			strs[normalized] = idx
+		}
+		fn.Name = idx
+	}
+}
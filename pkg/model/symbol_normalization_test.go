@@ -0,0 +1,90 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+)
+
+func Test_SymbolNormalizer_Presets(t *testing.T) {
+	for _, tc := range []struct {
+		preset   string
+		name     string
+		expected string
+	}{
+		{SymbolNormalizationStripTemplateArgs, "std::vector<int, std::allocator<int>>::push_back", "std::vector::push_back"},
+		{SymbolNormalizationStripTemplateArgs, "main.Map[...]", "main.Map[...]"},
+		{SymbolNormalizationStripTemplateArgs, "Foo<Bar>::operator<<", "Foo::operator<<"},
+		{SymbolNormalizationStripTemplateArgs, "Foo<Bar>::operator->", "Foo::operator->"},
+		{SymbolNormalizationStripTemplateArgs, "a<b", "a<b"},
+		{SymbolNormalizationCollapseLambdaNumbers, "main.handler.func1.2", "main.handler.funcN"},
+		{SymbolNormalizationCollapseLambdaNumbers, "com.example.Handler.lambda$run$12", "com.example.Handler.lambda$run$N"},
+		{SymbolNormalizationCollapseLambdaNumbers, "com.example.Handler$$Lambda$123/0x0000000800c0b000.run", "com.example.Handler$$Lambda.run"},
+		{SymbolNormalizationCollapseLambdaNumbers, "main::{lambda(int)#2}::operator()", "main::{lambda(int)#N}::operator()"},
+		{SymbolNormalizationTrimBuildPaths, "handler (/build/1234/app/main.py:12)", "handler (main.py:12)"},
+		{SymbolNormalizationTrimBuildPaths, `C:\build\src\main.cpp`, "main.cpp"},
+		{SymbolNormalizationTrimBuildPaths, "github.com/grafana/pyroscope/pkg/model.Func", "github.com/grafana/pyroscope/pkg/model.Func"},
+	} {
+		t.Run(tc.preset+"/"+tc.name, func(t *testing.T) {
+			n, err := NewSymbolNormalizer([]SymbolNormalizationRule{{Preset: tc.preset}})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, n.Normalize(tc.name))
+		})
+	}
+}
+
+func Test_SymbolNormalizer_Regex(t *testing.T) {
+	n, err := NewSymbolNormalizer([]SymbolNormalizationRule{
+		{Regex: `^vendor/`},
+		{Regex: `\.v(\d+)\.`, Replacement: ".v${1}x."},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "github.com/foo.v2x.Bar", n.Normalize("vendor/github.com/foo.v2.Bar"))
+}
+
+func Test_SymbolNormalizationRule_Validate(t *testing.T) {
+	for _, r := range []SymbolNormalizationRule{
+		{},
+		{Preset: "unknown"},
+		{Preset: SymbolNormalizationTrimBuildPaths, Regex: "foo"},
+		{Regex: "("},
+	} {
+		assert.Error(t, r.Validate(), r)
+	}
+}
+
+func Test_SymbolNormalizer_NormalizeTree(t *testing.T) {
+	n, err := NewSymbolNormalizer([]SymbolNormalizationRule{{Preset: SymbolNormalizationCollapseLambdaNumbers}})
+	require.NoError(t, err)
+	tree := new(Tree)
+	tree.InsertStack(1, "main", "main.run.func1")
+	tree.InsertStack(2, "main", "main.run.func2")
+	tree.InsertStack(3, "main", "main.other")
+	n.NormalizeTree(tree)
+
+	expected := new(Tree)
+	expected.InsertStack(3, "main", "main.run.funcN")
+	expected.InsertStack(3, "main", "main.other")
+	assert.Equal(t, expected.String(), tree.String())
+}
+
+func Test_SymbolNormalizer_NormalizeProfile(t *testing.T) {
+	n, err := NewSymbolNormalizer([]SymbolNormalizationRule{{Preset: SymbolNormalizationStripTemplateArgs}})
+	require.NoError(t, err)
+	p := &profilev1.Profile{
+		StringTable: []string{"", "Foo<int>::bar", "Foo<long>::bar", "main"},
+		Function: []*profilev1.Function{
+			{Id: 1, Name: 1},
+			{Id: 2, Name: 2},
+			{Id: 3, Name: 3},
+		},
+	}
+	n.NormalizeProfile(p)
+	assert.Equal(t, []string{"", "Foo<int>::bar", "Foo<long>::bar", "main", "Foo::bar"}, p.StringTable)
+	assert.Equal(t, int64(4), p.Function[0].Name)
+	assert.Equal(t, int64(4), p.Function[1].Name)
+	assert.Equal(t, int64(3), p.Function[2].Name)
+}
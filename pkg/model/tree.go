@@ -14,7 +14,6 @@ import (
 	"github.com/xlab/treeprint"
 
 	"github.com/grafana/pyroscope/pkg/og/util/varint"
-	"github.com/grafana/pyroscope/pkg/slices"
 	"github.com/grafana/pyroscope/pkg/util/minheap"
 )
 
@@ -96,8 +95,7 @@ func (t *Tree) InsertStack(v int64, stack ...string) {
 }
 
 func (t *Tree) WriteCollapsed(dst io.Writer) {
-	t.IterateStacks(func(_ string, self int64, stack []string) {
-		slices.Reverse(stack)
+	t.IterateStacksFromRoot(func(self int64, stack []string) {
 		_, _ = fmt.Fprintf(dst, "%s %d\n", strings.Join(stack, ";"), self)
 	})
 }
@@ -167,6 +165,96 @@ func (t *Tree) Merge(src *Tree) {
 	t.root = dstRoot.children
 }
 
+// Subtract returns a new tree with the self value of every stack of t
+// decreased by the self value of the same stack in src. Stacks that
+// end up with a non-positive value are not included.
+func (t *Tree) Subtract(src *Tree) *Tree {
+	return t.combine(src, func(v, srcValue int64, _ bool) int64 {
+		return v - srcValue
+	})
+}
+
+// Intersect returns a new tree that only includes the stacks of t
+// that are also present in src. The values are taken from t.
+func (t *Tree) Intersect(src *Tree) *Tree {
+	return t.combine(src, func(v, _ int64, ok bool) int64 {
+		if !ok {
+			return 0
+		}
+		return v
+	})
+}
+
+// Exclude returns a new tree that only includes the stacks of t
+// that are not present in src. The values are taken from t.
+func (t *Tree) Exclude(src *Tree) *Tree {
+	return t.combine(src, func(v, _ int64, ok bool) int64 {
+		if ok {
+			return 0
+		}
+		return v
+	})
+}
+
+func (t *Tree) combine(src *Tree, fn func(v, srcValue int64, ok bool) int64) *Tree {
+	values := make(map[string]int64)
+	src.IterateStacksFromRoot(func(self int64, stack []string) {
+		values[strings.Join(stack, "\x00")] = self
+	})
+	r := new(Tree)
+	t.IterateStacksFromRoot(func(self int64, stack []string) {
+		srcValue, ok := values[strings.Join(stack, "\x00")]
+		if v := fn(self, srcValue, ok); v > 0 {
+			r.InsertStack(v, stack...)
+		}
+	})
+	return r
+}
+
+// Divide returns a copy of the tree with the self values divided by n, for
+// example, to average the profiles of n replicas. The stacks with a value
+// rounded down to zero are dropped.
+func (t *Tree) Divide(n int64) *Tree {
+	r := new(Tree)
+	if n <= 0 {
+		return r
+	}
+	t.IterateStacksFromRoot(func(self int64, stack []string) {
+		if v := self / n; v > 0 {
+			r.InsertStack(v, stack...)
+		}
+	})
+	return r
+}
+
+// IterateStacksFromRoot calls cb for every node with a self value, with
+// the stack from the root to the node. Unlike IterateStacks, it does not
+// rely on the parent links, which are not set in every tree, e.g. in the
+// merged ones, and it does not include the virtual root of the
+// unmarshalled trees. The stack is only valid until cb returns.
+func (t *Tree) IterateStacksFromRoot(cb func(self int64, stack []string)) {
+	type frame struct {
+		n     *node
+		depth int
+	}
+	nodes := make([]frame, 0, defaultDFSSize)
+	for _, n := range t.root {
+		nodes = append(nodes, frame{n: n})
+	}
+	stack := make([]string, 0, 64)
+	for len(nodes) > 0 {
+		f := nodes[len(nodes)-1]
+		nodes = nodes[:len(nodes)-1]
+		stack = append(stack[:f.depth], f.n.name)
+		if f.n.self > 0 {
+			cb(f.n.self, stack)
+		}
+		for _, c := range f.n.children {
+			nodes = append(nodes, frame{n: c, depth: f.depth + 1})
+		}
+	}
+}
+
 func (t *Tree) FormatNodeNames(fn func(string) string) {
 	nodes := make([]*node, 0, defaultDFSSize)
 	nodes = append(nodes, &node{children: t.root})
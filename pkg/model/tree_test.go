@@ -195,6 +195,65 @@ func Test_TreeMerge(t *testing.T) {
 	})
 }
 
+func Test_TreeSetOperations(t *testing.T) {
+	left := func() *Tree {
+		return newTree([]stacktraces{
+			{locations: []string{"c", "b", "a"}, value: 5},
+			{locations: []string{"d", "b", "a"}, value: 3},
+			{locations: []string{"b", "a"}, value: 2},
+			{locations: []string{"e"}, value: 1},
+		})
+	}
+	right := func() *Tree {
+		return newTree([]stacktraces{
+			{locations: []string{"c", "b", "a"}, value: 2},
+			{locations: []string{"d", "b", "a"}, value: 4},
+			{locations: []string{"f", "b", "a"}, value: 1},
+		})
+	}
+
+	t.Run("Tree.Subtract", func(t *testing.T) {
+		expected := newTree([]stacktraces{
+			{locations: []string{"c", "b", "a"}, value: 3},
+			{locations: []string{"b", "a"}, value: 2},
+			{locations: []string{"e"}, value: 1},
+		})
+		require.Equal(t, expected.String(), left().Subtract(right()).String())
+	})
+
+	t.Run("Tree.Intersect", func(t *testing.T) {
+		expected := newTree([]stacktraces{
+			{locations: []string{"c", "b", "a"}, value: 5},
+			{locations: []string{"d", "b", "a"}, value: 3},
+		})
+		require.Equal(t, expected.String(), left().Intersect(right()).String())
+	})
+
+	t.Run("Tree.Exclude", func(t *testing.T) {
+		expected := newTree([]stacktraces{
+			{locations: []string{"b", "a"}, value: 2},
+			{locations: []string{"e"}, value: 1},
+		})
+		require.Equal(t, expected.String(), left().Exclude(right()).String())
+	})
+
+	t.Run("Tree.Divide", func(t *testing.T) {
+		expected := newTree([]stacktraces{
+			{locations: []string{"c", "b", "a"}, value: 2},
+			{locations: []string{"d", "b", "a"}, value: 1},
+			{locations: []string{"b", "a"}, value: 1},
+		})
+		require.Equal(t, expected.String(), left().Divide(2).String())
+		require.Equal(t, new(Tree).String(), left().Divide(0).String())
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		require.Equal(t, left().String(), left().Subtract(new(Tree)).String())
+		require.Equal(t, new(Tree).String(), left().Intersect(new(Tree)).String())
+		require.Equal(t, new(Tree).String(), new(Tree).Exclude(right()).String())
+	})
+}
+
 func Test_Tree_minValue(t *testing.T) {
 	x := newTree([]stacktraces{
 		{locations: []string{"c", "b", "a"}, value: 1},
@@ -0,0 +1,108 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// EmailNotifier sends the events by email, through an SMTP server. The
+// title of the event is the subject, the text and the link the body.
+type EmailNotifier struct {
+	address  string
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+func NewEmailNotifier(address, from string, to []string, username, password string) *EmailNotifier {
+	return &EmailNotifier{
+		address:  address,
+		from:     from,
+		to:       to,
+		username: username,
+		password: password,
+	}
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, e *Event) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	host, _, err := net.SplitHostPort(n.address)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP server address: %w", err)
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", n.address)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.username != "" {
+		if err = c.Auth(smtp.PlainAuth("", n.username, n.password, host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(emailMessage(n.from, n.to, e, time.Now())); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// emailMessage returns the plain text message of the event.
+func emailMessage(from string, to []string, e *Event, date time.Time) []byte {
+	var b bytes.Buffer
+	header := func(k, v string) { _, _ = fmt.Fprintf(&b, "%s: %s\r\n", k, v) }
+	header("From", from)
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", e.Title))
+	header("Date", date.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	b.WriteString("\r\n")
+	body := e.Text
+	if e.URL != "" && body != "" {
+		body += "\n\n" + e.URL
+	} else if e.URL != "" {
+		body = e.URL
+	}
+	for _, line := range strings.Split(body, "\n") {
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,151 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/dskit/flagext"
+)
+
+type Config struct {
+	ExternalURL     string `yaml:"external_url"`
+	WebhookURL      string `yaml:"webhook_url"`
+	SlackWebhookURL string `yaml:"slack_webhook_url"`
+	SlackTemplate   string `yaml:"slack_template" category:"advanced"`
+
+	EmailSMTPAddress string                 `yaml:"email_smtp_address"`
+	EmailFrom        string                 `yaml:"email_from"`
+	EmailTo          flagext.StringSliceCSV `yaml:"email_to"`
+	EmailUsername    string                 `yaml:"email_username"`
+	EmailPassword    flagext.Secret         `yaml:"email_password"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.ExternalURL, "notifier.external-url", "", "The URL the Pyroscope UI is reachable at, used for the links in the notifications, for example https://pyroscope.example.com. If empty, the notifications have no links.")
+	f.StringVar(&cfg.WebhookURL, "notifier.webhook-url", "", "The URL the notifications are posted to, as JSON.")
+	f.StringVar(&cfg.SlackWebhookURL, "notifier.slack.webhook-url", "", "The Slack incoming webhook URL the notifications are posted to.")
+	f.StringVar(&cfg.SlackTemplate, "notifier.slack.template", "", "The Go template of the Slack message payload. The template is executed with the notification event, and must produce a JSON object. If empty, the default template is used.")
+	f.StringVar(&cfg.EmailSMTPAddress, "notifier.email.smtp-address", "", "The address of the SMTP server the notification emails are sent through, in the host:port format.")
+	f.StringVar(&cfg.EmailFrom, "notifier.email.from", "", "The sender address of the notification emails.")
+	f.Var(&cfg.EmailTo, "notifier.email.to", "Comma-separated list of the recipient addresses of the notification emails.")
+	f.StringVar(&cfg.EmailUsername, "notifier.email.username", "", "The username to authenticate to the SMTP server with. If empty, no authentication is used.")
+	f.Var(&cfg.EmailPassword, "notifier.email.password", "The password to authenticate to the SMTP server with.")
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.ExternalURL != "" {
+		if _, err := url.Parse(cfg.ExternalURL); err != nil {
+			return fmt.Errorf("invalid notifier external URL: %w", err)
+		}
+	}
+	if cfg.SlackTemplate != "" {
+		if _, err := parseSlackTemplate(cfg.SlackTemplate); err != nil {
+			return fmt.Errorf("invalid notifier Slack template: %w", err)
+		}
+	}
+	if cfg.EmailSMTPAddress != "" {
+		if _, _, err := net.SplitHostPort(cfg.EmailSMTPAddress); err != nil {
+			return fmt.Errorf("invalid notifier SMTP server address: %w", err)
+		}
+		if cfg.EmailFrom == "" || len(cfg.EmailTo) == 0 {
+			return errors.New("the notifier email sender and recipients are required")
+		}
+	}
+	return nil
+}
+
+// Event is a notification about profiling data, for example, a regression
+// detected between two versions of a service.
+type Event struct {
+	// Type identifies the kind of the event, for example, regression.
+	Type     string `json:"type"`
+	TenantID string `json:"tenant_id"`
+	Title    string `json:"title"`
+	Text     string `json:"text,omitempty"`
+	// Diff is the comparison the event refers to, if any.
+	Diff *Diff `json:"diff,omitempty"`
+	// URL is the link to the diff view, set by the notifier if the external
+	// URL is configured.
+	URL string `json:"url,omitempty"`
+	// Data holds the details of the event, specific to its type.
+	Data any `json:"data,omitempty"`
+}
+
+// Diff describes the two profile queries compared in the diff view.
+// The time ranges are in milliseconds.
+type Diff struct {
+	ProfileType   string `json:"profile_type"`
+	LeftSelector  string `json:"left_selector"`
+	LeftStart     int64  `json:"left_start"`
+	LeftEnd       int64  `json:"left_end"`
+	RightSelector string `json:"right_selector"`
+	RightStart    int64  `json:"right_start"`
+	RightEnd      int64  `json:"right_end"`
+}
+
+// URL returns the link to the diff view of the Pyroscope UI.
+func (d *Diff) URL(externalURL string) string {
+	seconds := func(ms int64) string { return strconv.FormatInt(ms/1000, 10) }
+	q := url.Values{}
+	q.Set("leftQuery", d.ProfileType+d.LeftSelector)
+	q.Set("leftFrom", seconds(d.LeftStart))
+	q.Set("leftUntil", seconds(d.LeftEnd))
+	q.Set("rightQuery", d.ProfileType+d.RightSelector)
+	q.Set("rightFrom", seconds(d.RightStart))
+	q.Set("rightUntil", seconds(d.RightEnd))
+	q.Set("from", seconds(min(d.LeftStart, d.RightStart)))
+	q.Set("until", seconds(max(d.LeftEnd, d.RightEnd)))
+	return strings.TrimSuffix(externalURL, "/") + "/comparison-diff?" + q.Encode()
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, e *Event) error
+}
+
+// New returns the notifier sending the events to all the configured sinks,
+// or nil if no sink is configured.
+func New(cfg Config) (Notifier, error) {
+	var n multiNotifier
+	if cfg.WebhookURL != "" {
+		n.sinks = append(n.sinks, NewWebhookNotifier(cfg.WebhookURL))
+	}
+	if cfg.SlackWebhookURL != "" {
+		slack, err := NewSlackNotifier(cfg.SlackWebhookURL, cfg.SlackTemplate)
+		if err != nil {
+			return nil, err
+		}
+		n.sinks = append(n.sinks, slack)
+	}
+	if cfg.EmailSMTPAddress != "" {
+		n.sinks = append(n.sinks, NewEmailNotifier(cfg.EmailSMTPAddress, cfg.EmailFrom, cfg.EmailTo, cfg.EmailUsername, cfg.EmailPassword.String()))
+	}
+	if len(n.sinks) == 0 {
+		return nil, nil
+	}
+	n.externalURL = cfg.ExternalURL
+	return &n, nil
+}
+
+type multiNotifier struct {
+	externalURL string
+	sinks       []Notifier
+}
+
+func (n *multiNotifier) Notify(ctx context.Context, e *Event) error {
+	if e.Diff != nil && e.URL == "" && n.externalURL != "" {
+		e.URL = e.Diff.URL(n.externalURL)
+	}
+	var errs []error
+	for _, s := range n.sinks {
+		if err := s.Notify(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
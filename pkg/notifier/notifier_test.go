@@ -0,0 +1,132 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDiff() *Diff {
+	return &Diff{
+		ProfileType:   "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		LeftSelector:  `{service_name="svc",version="v1"}`,
+		LeftStart:     1000000,
+		LeftEnd:       2000000,
+		RightSelector: `{service_name="svc",version="v2"}`,
+		RightStart:    3000000,
+		RightEnd:      4000000,
+	}
+}
+
+func Test_DiffURL(t *testing.T) {
+	u, err := url.Parse(testDiff().URL("https://pyroscope.example.com/"))
+	require.NoError(t, err)
+	assert.Equal(t, "/comparison-diff", u.Path)
+	assert.Equal(t, url.Values{
+		"leftQuery":  {`process_cpu:cpu:nanoseconds:cpu:nanoseconds{service_name="svc",version="v1"}`},
+		"leftFrom":   {"1000"},
+		"leftUntil":  {"2000"},
+		"rightQuery": {`process_cpu:cpu:nanoseconds:cpu:nanoseconds{service_name="svc",version="v2"}`},
+		"rightFrom":  {"3000"},
+		"rightUntil": {"4000"},
+		"from":       {"1000"},
+		"until":      {"4000"},
+	}, u.Query())
+}
+
+func Test_Notifier(t *testing.T) {
+	var webhook Event
+	webhookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&webhook))
+	}))
+	defer webhookServer.Close()
+	var slack map[string]string
+	slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&slack))
+	}))
+	defer slackServer.Close()
+
+	n, err := New(Config{
+		ExternalURL:     "https://pyroscope.example.com",
+		WebhookURL:      webhookServer.URL,
+		SlackWebhookURL: slackServer.URL,
+	})
+	require.NoError(t, err)
+	e := &Event{
+		Type:     "regression",
+		TenantID: "tenant-a",
+		Title:    "Performance regression in svc",
+		Text:     `encode: 20.00% -> 50.00%`,
+		Diff:     testDiff(),
+	}
+	require.NoError(t, n.Notify(context.Background(), e))
+
+	expectedURL := testDiff().URL("https://pyroscope.example.com")
+	assert.Equal(t, expectedURL, e.URL)
+	assert.Equal(t, *e, webhook)
+	assert.Equal(t, map[string]string{
+		"text": "*Performance regression in svc*\nencode: 20.00% -> 50.00%\n<" + expectedURL + "|Open the diff view>",
+	}, slack)
+}
+
+func Test_Notifier_NoSinks(t *testing.T) {
+	n, err := New(Config{ExternalURL: "https://pyroscope.example.com"})
+	require.NoError(t, err)
+	assert.Nil(t, n)
+}
+
+func Test_SlackNotifier_Template(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		body = string(b)
+	}))
+	defer server.Close()
+
+	n, err := NewSlackNotifier(server.URL, `{"channel": "#perf", "text": {{ json .Title }}}`)
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), &Event{Title: `"quoted"`}))
+	assert.Equal(t, `{"channel": "#perf", "text": "\"quoted\""}`, body)
+
+	n, err = NewSlackNotifier(server.URL, `{{ .Title }}`)
+	require.NoError(t, err)
+	require.Error(t, n.Notify(context.Background(), &Event{Title: "not json"}))
+
+	_, err = NewSlackNotifier(server.URL, `{{ .Title `)
+	require.Error(t, err)
+}
+
+func Test_WebhookNotifier_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	require.Error(t, NewWebhookNotifier(server.URL).Notify(context.Background(), &Event{}))
+}
+
+func Test_EmailMessage(t *testing.T) {
+	date := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	msg := emailMessage("pyroscope@example.com", []string{"a@example.com", "b@example.com"}, &Event{
+		Title: "Profiling report – week 19",
+		Text:  "line 1\nline 2",
+		URL:   "https://pyroscope.example.com",
+	}, date)
+	assert.Equal(t, "From: pyroscope@example.com\r\n"+
+		"To: a@example.com, b@example.com\r\n"+
+		"Subject: =?utf-8?q?Profiling_report_=E2=80=93_week_19?=\r\n"+
+		"Date: Mon, 06 May 2024 00:00:00 +0000\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"\r\n"+
+		"line 1\r\nline 2\r\n\r\nhttps://pyroscope.example.com\r\n", string(msg))
+}
@@ -0,0 +1,61 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+)
+
+// defaultSlackTemplate renders the title in bold, followed by the text and
+// the link to the diff view.
+const defaultSlackTemplate = `
+{{- $text := printf "*%s*" .Title -}}
+{{- if .Text }}{{ $text = printf "%s\n%s" $text .Text }}{{ end -}}
+{{- if .URL }}{{ $text = printf "%s\n<%s|Open the diff view>" $text .URL }}{{ end -}}
+{"text": {{ json $text }}}`
+
+// SlackNotifier posts the events to a Slack incoming webhook. The message
+// payload is rendered with a template.
+type SlackNotifier struct {
+	url      string
+	template *template.Template
+	client   *http.Client
+}
+
+func NewSlackNotifier(url, tmpl string) (*SlackNotifier, error) {
+	if tmpl == "" {
+		tmpl = defaultSlackTemplate
+	}
+	t, err := parseSlackTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return &SlackNotifier{
+		url:      url,
+		template: t,
+		client:   &http.Client{Timeout: webhookTimeout},
+	}, nil
+}
+
+func parseSlackTemplate(tmpl string) (*template.Template, error) {
+	return template.New("slack").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(tmpl)
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, e *Event) error {
+	var body bytes.Buffer
+	if err := n.template.Execute(&body, e); err != nil {
+		return fmt.Errorf("rendering Slack message: %w", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return fmt.Errorf("the Slack message is not valid JSON: %s", body.String())
+	}
+	return postJSON(ctx, n.client, n.url, body.Bytes())
+}
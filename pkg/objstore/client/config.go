@@ -110,6 +110,10 @@ func (cfg *StorageBackendConfig) Validate() error {
 		return cfg.S3.Validate()
 	case COS:
 		return cfg.COS.Validate()
+	case GCS:
+		return cfg.GCS.Validate()
+	case Azure:
+		return cfg.Azure.Validate()
 	default:
 		return nil
 	}
@@ -6,8 +6,18 @@
 package azure
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/go-kit/log"
 	"github.com/thanos-io/objstore"
 	"github.com/thanos-io/objstore/providers/azure"
@@ -36,5 +46,84 @@ func newBucketClient(cfg Config, name string, logger log.Logger, factory func(lo
 		bucketConfig.Endpoint = cfg.Endpoint
 	}
 
-	return factory(logger, bucketConfig, name, nil)
+	bkt, err := factory(logger, bucketConfig, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ImmutabilityPeriod <= 0 {
+		return bkt, nil
+	}
+
+	// The Thanos Azure client does not expose its container client,
+	// so we create another one to set the immutability policies.
+	containerClient, err := newContainerClient(bucketConfig)
+	if err != nil {
+		return nil, err
+	}
+	mode := blob.ImmutabilityPolicySettingUnlocked
+	if cfg.ImmutabilityPolicyMode == ImmutabilityPolicyModeLocked {
+		mode = blob.ImmutabilityPolicySettingLocked
+	}
+	return &immutableBucket{
+		Bucket:    bkt,
+		container: containerClient,
+		period:    cfg.ImmutabilityPeriod,
+		mode:      mode,
+	}, nil
+}
+
+func newContainerClient(cfg azure.Config) (*container.Client, error) {
+	opts := &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: int32(cfg.MaxRetries)},
+		},
+	}
+	if cfg.StorageConnectionString != "" {
+		return container.NewClientFromConnectionString(cfg.StorageConnectionString, cfg.ContainerName, opts)
+	}
+	containerURL := fmt.Sprintf("https://%s.%s/%s", cfg.StorageAccountName, cfg.Endpoint, cfg.ContainerName)
+	if cfg.StorageAccountKey != "" {
+		cred, err := azblob.NewSharedKeyCredential(cfg.StorageAccountName, cfg.StorageAccountKey)
+		if err != nil {
+			return nil, err
+		}
+		return container.NewClientWithSharedKeyCredential(containerURL, cred, opts)
+	}
+	var (
+		cred azcore.TokenCredential
+		err  error
+	)
+	if cfg.UserAssignedID != "" {
+		cred, err = azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID: azidentity.ClientID(cfg.UserAssignedID),
+		})
+	} else {
+		cred, err = azidentity.NewDefaultAzureCredential(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return container.NewClient(containerURL, cred, opts)
+}
+
+// immutableBucket sets a time-based immutability policy on every
+// uploaded blob.
+type immutableBucket struct {
+	objstore.Bucket
+	container *container.Client
+	period    time.Duration
+	mode      blob.ImmutabilityPolicySetting
+}
+
+func (b *immutableBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if err := b.Bucket.Upload(ctx, name, r); err != nil {
+		return err
+	}
+	_, err := b.container.NewBlobClient(name).SetImmutabilityPolicy(ctx, time.Now().Add(b.period), &blob.SetImmutabilityPolicyOptions{
+		Mode: &b.mode,
+	})
+	if err != nil {
+		return fmt.Errorf("setting immutability policy of %s: %w", name, err)
+	}
+	return nil
 }
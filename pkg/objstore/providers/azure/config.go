@@ -7,10 +7,19 @@ package azure
 
 import (
 	"flag"
+	"time"
 
 	"github.com/grafana/dskit/flagext"
+	"github.com/pkg/errors"
 )
 
+const (
+	ImmutabilityPolicyModeUnlocked = "unlocked"
+	ImmutabilityPolicyModeLocked   = "locked"
+)
+
+var errUnsupportedImmutabilityPolicyMode = errors.New("unsupported Azure immutability policy mode")
+
 // Config holds the config options for an Azure backend
 type Config struct {
 	StorageAccountName      string         `yaml:"account_name"`
@@ -20,6 +29,8 @@ type Config struct {
 	Endpoint                string         `yaml:"endpoint_suffix"`
 	MaxRetries              int            `yaml:"max_retries" category:"advanced"`
 	UserAssignedID          string         `yaml:"user_assigned_id" category:"advanced"`
+	ImmutabilityPeriod      time.Duration  `yaml:"immutability_period" category:"advanced"`
+	ImmutabilityPolicyMode  string         `yaml:"immutability_policy_mode" category:"advanced"`
 }
 
 // RegisterFlags registers the flags for Azure storage
@@ -36,4 +47,14 @@ func (cfg *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 	f.StringVar(&cfg.Endpoint, prefix+"azure.endpoint-suffix", "", "Azure storage endpoint suffix without schema. The account name will be prefixed to this value to create the FQDN. If set to empty string, default endpoint suffix is used.")
 	f.IntVar(&cfg.MaxRetries, prefix+"azure.max-retries", 3, "Number of retries for recoverable errors")
 	f.StringVar(&cfg.UserAssignedID, prefix+"azure.user-assigned-id", "", "User assigned managed identity. If empty, then System assigned identity is used.")
+	f.DurationVar(&cfg.ImmutabilityPeriod, prefix+"azure.immutability-period", 0, "If greater than 0, a time-based immutability policy expiring after this period is set on every uploaded blob, which then cannot be modified nor deleted until the policy expires. The container must have version-level immutability support enabled. The period should not be shorter than the retention of the blocks, otherwise compaction and retention will fail to delete them.")
+	f.StringVar(&cfg.ImmutabilityPolicyMode, prefix+"azure.immutability-policy-mode", ImmutabilityPolicyModeUnlocked, "Mode of the immutability policy set on the uploaded blobs. Supported values are: unlocked, locked. A locked policy cannot be removed nor shortened.")
+}
+
+// Validate config and returns error on failure
+func (cfg *Config) Validate() error {
+	if cfg.ImmutabilityPeriod > 0 && cfg.ImmutabilityPolicyMode != ImmutabilityPolicyModeUnlocked && cfg.ImmutabilityPolicyMode != ImmutabilityPolicyModeLocked {
+		return errUnsupportedImmutabilityPolicyMode
+	}
+	return nil
 }
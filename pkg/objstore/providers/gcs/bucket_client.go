@@ -7,6 +7,7 @@ package gcs
 
 import (
 	"context"
+	"io"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/common/model"
@@ -40,5 +41,31 @@ func NewBucketClient(ctx context.Context, cfg Config, name string, logger log.Lo
 		return nil, err
 	}
 
-	return gcs.NewBucket(ctx, logger, serialized, name, nil)
+	bkt, err := gcs.NewBucket(ctx, logger, serialized, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.KMSKeyName != "" {
+		return &kmsBucket{Bucket: bkt, kmsKeyName: cfg.KMSKeyName}, nil
+	}
+	return bkt, nil
+}
+
+// kmsBucket encrypts the uploaded objects with a customer-managed
+// encryption key, which the Thanos GCS client does not support.
+type kmsBucket struct {
+	*gcs.Bucket
+	kmsKeyName string
+}
+
+func (b *kmsBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	// Cancelling the context aborts the upload if the object can't be read.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := b.Handle().Object(name).NewWriter(ctx)
+	w.KMSKeyName = b.kmsKeyName
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Close()
 }
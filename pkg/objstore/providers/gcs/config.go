@@ -7,15 +7,24 @@ package gcs
 
 import (
 	"flag"
+	"regexp"
 	"time"
 
 	"github.com/grafana/dskit/flagext"
+	"github.com/pkg/errors"
+)
+
+var (
+	kmsKeyNameRegexp = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
+	errInvalidKMSKeyName = errors.New("invalid GCS KMS key name, expected projects/<project>/locations/<location>/keyRings/<key-ring>/cryptoKeys/<key>")
 )
 
 // Config holds the config options for GCS backend
 type Config struct {
 	BucketName     string         `yaml:"bucket_name"`
 	ServiceAccount flagext.Secret `yaml:"service_account" doc:"description_method=GCSServiceAccountLongDescription"`
+	KMSKeyName     string         `yaml:"kms_key_name" category:"advanced"`
 	HTTP           HTTPConfig     `yaml:"http"`
 }
 
@@ -28,9 +37,18 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 func (cfg *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 	f.StringVar(&cfg.BucketName, prefix+"gcs.bucket-name", "", "GCS bucket name")
 	f.Var(&cfg.ServiceAccount, prefix+"gcs.service-account", cfg.GCSServiceAccountShortDescription())
+	f.StringVar(&cfg.KMSKeyName, prefix+"gcs.kms-key-name", "", "Cloud KMS key used to encrypt the uploaded objects (customer-managed encryption key), in the format projects/<project>/locations/<location>/keyRings/<key-ring>/cryptoKeys/<key>. The service account must be allowed to use the key. If empty, the default encryption of the bucket is used.")
 	cfg.HTTP.RegisterFlagsWithPrefix(prefix, f)
 }
 
+// Validate config and returns error on failure
+func (cfg *Config) Validate() error {
+	if cfg.KMSKeyName != "" && !kmsKeyNameRegexp.MatchString(cfg.KMSKeyName) {
+		return errInvalidKMSKeyName
+	}
+	return nil
+}
+
 type HTTPConfig struct {
 	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout" category:"advanced"`
 	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout" category:"advanced"`
@@ -57,14 +57,10 @@ func (b *SSEBucketClient) ReaderAt(ctx context.Context, name string) (ReaderAtCl
 
 // Upload the contents of the reader as an object into the bucket.
 func (b *SSEBucketClient) Upload(ctx context.Context, name string, r io.Reader) error {
-	if sse, err := b.getCustomS3SSEConfig(); err != nil {
+	ctx, err := ContextWithTenantSSEConfig(ctx, b.userID, b.cfgProvider)
+	if err != nil {
 		return err
-	} else if sse != nil {
-		// If the underlying bucket client is not S3 and a custom S3 SSE config has been
-		// provided, the config option will be ignored.
-		ctx = s3.ContextWithSSEConfig(ctx, sse)
 	}
-
 	return b.bucket.Upload(ctx, name, r)
 }
 
@@ -78,26 +74,38 @@ func (b *SSEBucketClient) Name() string {
 	return b.bucket.Name()
 }
 
-func (b *SSEBucketClient) getCustomS3SSEConfig() (encrypt.ServerSide, error) {
-	if b.cfgProvider == nil {
+// ContextWithTenantSSEConfig returns the context of the uploads of the
+// objects of the tenant, with the S3 SSE config of the tenant, if overridden.
+// The cfgProvider can be nil. If the bucket client is not S3, the config is
+// ignored.
+func ContextWithTenantSSEConfig(ctx context.Context, userID string, cfgProvider TenantConfigProvider) (context.Context, error) {
+	sse, err := getCustomS3SSEConfig(userID, cfgProvider)
+	if err != nil || sse == nil {
+		return ctx, err
+	}
+	return s3.ContextWithSSEConfig(ctx, sse), nil
+}
+
+func getCustomS3SSEConfig(userID string, cfgProvider TenantConfigProvider) (encrypt.ServerSide, error) {
+	if cfgProvider == nil {
 		return nil, nil
 	}
 
 	// No S3 SSE override if the type override hasn't been provided.
-	sseType := b.cfgProvider.S3SSEType(b.userID)
+	sseType := cfgProvider.S3SSEType(userID)
 	if sseType == "" {
 		return nil, nil
 	}
 
 	cfg := phlare_s3.SSEConfig{
 		Type:                 sseType,
-		KMSKeyID:             b.cfgProvider.S3SSEKMSKeyID(b.userID),
-		KMSEncryptionContext: b.cfgProvider.S3SSEKMSEncryptionContext(b.userID),
+		KMSKeyID:             cfgProvider.S3SSEKMSKeyID(userID),
+		KMSEncryptionContext: cfgProvider.S3SSEKMSEncryptionContext(userID),
 	}
 
 	sse, err := cfg.BuildMinioConfig()
 	if err != nil {
-		return nil, errors.Wrapf(err, "unable to customise S3 SSE config for tenant %s", b.userID)
+		return nil, errors.Wrapf(err, "unable to customise S3 SSE config for tenant %s", userID)
 	}
 
 	return sse, nil
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"mime/multipart"
+	"strconv"
 	"strings"
 	"time"
 
@@ -212,13 +213,18 @@ func (p *RawProfile) createLabels(profile *pprof.Profile, md ingestion.Metadata)
 	ls = append(ls, &v1.LabelPair{
 		Name:  labels.MetricName,
 		Value: p.metricName(profile),
-	}, &v1.LabelPair{
-		Name:  phlaremodel.LabelNameDelta,
-		Value: "false",
 	}, &v1.LabelPair{
 		Name:  phlaremodel.LabelNamePyroscopeSpy,
 		Value: md.SpyName,
 	})
+	// The deltas of the cumulative sample types, such as the allocations of
+	// the heap profiles, are computed at ingestion unless the SDK sends the
+	// deltas, which is the default. The segment writers only compute the
+	// deltas of the profiles explicitly marked.
+	ls = append(ls, &v1.LabelPair{
+		Name:  phlaremodel.LabelNameDelta,
+		Value: strconv.FormatBool(p.isCumulative(profile)),
+	})
 
 	// Only add service_name if it doesn't exist
 	if !hasServiceName {
@@ -239,6 +245,19 @@ func (p *RawProfile) createLabels(profile *pprof.Profile, md ingestion.Metadata)
 	}
 	return ls
 }
+
+// isCumulative tells if the client declared a sample type of the profile
+// as cumulative in its sample type config. The default sample type mapping
+// is not used: the SDKs not sending the config send the deltas.
+func (p *RawProfile) isCumulative(profile *pprof.Profile) bool {
+	for _, st := range profile.Profile.SampleType {
+		if c := p.SampleTypeConfig[profile.StringTable[st.Type]]; c != nil && c.Cumulative {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *RawProfile) getSampleTypes() map[string]*tree.SampleTypeConfig {
 	sampleTypes := tree.DefaultSampleTypeMapping
 	if p.SampleTypeConfig != nil {
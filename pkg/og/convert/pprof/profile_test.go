@@ -157,3 +157,33 @@ func TestCreateLabels(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateLabelsCumulative(t *testing.T) {
+	profile := &pprof.Profile{
+		Profile: &profilev1.Profile{
+			SampleType: []*profilev1.ValueType{
+				{Type: 1, Unit: 2},
+				{Type: 3, Unit: 4},
+			},
+			StringTable: []string{"", "alloc_objects", "count", "inuse_space", "bytes"},
+		},
+	}
+	md := ingestion.Metadata{
+		LabelSet: labelset.New(map[string]string{"service_name": "test-service"}),
+		SpyName:  "gospy",
+	}
+
+	// Without the sample type config, the SDK sends the deltas.
+	p := RawProfile{}
+	assert.Equal(t, "false", phlaremodel.Labels(p.createLabels(profile, md)).Get(phlaremodel.LabelNameDelta))
+
+	p = RawProfile{
+		SampleTypeConfig: map[string]*tree.SampleTypeConfig{
+			"alloc_objects": {Units: "objects", Cumulative: true},
+			"inuse_space":   {Units: "bytes", Aggregation: "average"},
+		},
+	}
+	labels := phlaremodel.Labels(p.createLabels(profile, md))
+	assert.Equal(t, "true", labels.Get(phlaremodel.LabelNameDelta), "the deltas of the cumulative sample types are computed at ingestion")
+	assert.Equal(t, "memory", labels.Get("__name__"))
+}
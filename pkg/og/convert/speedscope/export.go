@@ -0,0 +1,77 @@
+package speedscope
+
+import (
+	"encoding/json"
+	"io"
+
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+const exporter = "pyroscope"
+
+// UnitFromSampleUnit maps a pprof sample unit to the closest speedscope unit.
+// Units speedscope does not know about are exported as "none".
+func UnitFromSampleUnit(sampleUnit string) string {
+	switch sampleUnit {
+	case "nanoseconds":
+		return string(unitNanoseconds)
+	case "microseconds":
+		return string(unitMicroseconds)
+	case "milliseconds":
+		return string(unitMilliseconds)
+	case "seconds":
+		return string(unitSeconds)
+	case "bytes":
+		return string(unitBytes)
+	default:
+		return string(unitNone)
+	}
+}
+
+// WriteTree encodes the tree as a single sampled speedscope profile.
+// Every stack with a non-zero self value becomes one sample weighted
+// by that value; frames are deduplicated by name.
+func WriteTree(w io.Writer, t *phlaremodel.Tree, name string, u string) error {
+	var (
+		frames  []frame
+		samples []sample
+		weights []float64
+		total   float64
+	)
+	frameIndex := make(map[string]int)
+	t.IterateStacksFromRoot(func(self int64, stack []string) {
+		s := make(sample, len(stack))
+		for i, fn := range stack {
+			idx, ok := frameIndex[fn]
+			if !ok {
+				idx = len(frames)
+				frameIndex[fn] = idx
+				frames = append(frames, frame{Name: fn})
+			}
+			s[i] = float64(idx)
+		}
+		samples = append(samples, s)
+		weights = append(weights, float64(self))
+		total += float64(self)
+	})
+
+	file := speedscopeFile{
+		Schema:   schema,
+		Shared:   shared{Frames: frames},
+		Name:     name,
+		Exporter: exporter,
+		Profiles: []profile{{
+			Type:       profileSampled,
+			Name:       name,
+			Unit:       unit(u),
+			StartValue: 0,
+			EndValue:   total,
+			Samples:    samples,
+			Weights:    weights,
+		}},
+	}
+	if file.Shared.Frames == nil {
+		file.Shared.Frames = []frame{}
+	}
+	return json.NewEncoder(w).Encode(file)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/grafana/pyroscope/api/model/labelset"
 	"github.com/grafana/pyroscope/pkg/og/ingestion"
 	"github.com/grafana/pyroscope/pkg/og/storage"
 	"github.com/grafana/pyroscope/pkg/og/storage/metadata"
@@ -32,6 +33,16 @@ func (p *RawProfile) Parse(ctx context.Context, putter storage.Putter, _ storage
 	return nil
 }
 
+// ParseTrees parses the profiles of a speedscope file. The profiles are
+// named after the name, suffixed with their unit if the file has
+// multiple profiles.
+func ParseTrees(rawData []byte, name string) ([]*storage.PutInput, error) {
+	return parseAll(rawData, ingestion.Metadata{
+		SampleRate: 100,
+		LabelSet:   labelset.New(map[string]string{"__name__": name}),
+	})
+}
+
 func parseAll(rawData []byte, md ingestion.Metadata) ([]*storage.PutInput, error) {
 	file := speedscopeFile{}
 	err := json.Unmarshal(rawData, &file)
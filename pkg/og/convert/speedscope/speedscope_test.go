@@ -1,13 +1,16 @@
 package speedscope
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"os"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	"github.com/grafana/pyroscope/api/model/labelset"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/og/ingestion"
 	"github.com/grafana/pyroscope/pkg/og/storage/metadata"
 
@@ -75,4 +78,49 @@ a;b;d 400
 		Expect(input.Val.String()).To(Equal(expectedResult))
 		Expect(input.SampleRate).To(Equal(uint32(100)))
 	})
+	It("Can export a tree that parses back", func() {
+		t := new(phlaremodel.Tree)
+		t.InsertStack(500, "a", "b")
+		t.InsertStack(500, "a", "b", "c")
+		t.InsertStack(400, "a", "b", "d")
+
+		var buf bytes.Buffer
+		Expect(WriteTree(&buf, t, "foo", UnitFromSampleUnit("bytes"))).To(Succeed())
+
+		key, err := labelset.Parse("foo")
+		Expect(err).ToNot(HaveOccurred())
+
+		ingester := new(mockIngester)
+		profile := &RawProfile{RawData: buf.Bytes()}
+		md := ingestion.Metadata{LabelSet: key, SampleRate: 100}
+		Expect(profile.Parse(context.Background(), ingester, nil, md)).To(Succeed())
+
+		Expect(ingester.actual).To(HaveLen(1))
+		input := ingester.actual[0]
+		Expect(input.Units).To(Equal(metadata.BytesUnits))
+		Expect(input.Val.String()).To(Equal(`a;b 500
+a;b;c 500
+a;b;d 400
+`))
+	})
+
+	It("Does not export the virtual root of an unmarshalled tree", func() {
+		src := new(phlaremodel.Tree)
+		src.InsertStack(500, "a", "b")
+		src.InsertStack(400, "a", "b", "d")
+		t, err := phlaremodel.UnmarshalTree(src.Bytes(-1))
+		Expect(err).ToNot(HaveOccurred())
+
+		var buf bytes.Buffer
+		Expect(WriteTree(&buf, t, "foo", UnitFromSampleUnit("bytes"))).To(Succeed())
+
+		var file speedscopeFile
+		Expect(json.Unmarshal(buf.Bytes(), &file)).To(Succeed())
+		names := make([]string, 0, len(file.Shared.Frames))
+		for _, f := range file.Shared.Frames {
+			names = append(names, f.Name)
+		}
+		Expect(names).To(ConsistOf("a", "b", "d"))
+		Expect(file.Profiles[0].EndValue).To(Equal(float64(900)))
+	})
 })
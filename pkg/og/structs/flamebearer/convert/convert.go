@@ -13,9 +13,12 @@ import (
 	"time"
 	"unicode"
 
+	jfrPprof "github.com/grafana/jfr-parser/pprof"
+
 	"github.com/grafana/pyroscope/pkg/og/agent/spy"
 	"github.com/grafana/pyroscope/pkg/og/convert/perf"
 	"github.com/grafana/pyroscope/pkg/og/convert/pprof"
+	"github.com/grafana/pyroscope/pkg/og/convert/speedscope"
 	"github.com/grafana/pyroscope/pkg/og/storage/metadata"
 	"github.com/grafana/pyroscope/pkg/og/storage/tree"
 	"github.com/grafana/pyroscope/pkg/og/structs/flamebearer"
@@ -45,6 +48,8 @@ const (
 	ProfileFileTypePprof      ProfileFileType = "pprof"
 	ProfileFileTypeCollapsed  ProfileFileType = "collapsed"
 	ProfileFileTypePerfScript ProfileFileType = "perf_script"
+	ProfileFileTypeSpeedscope ProfileFileType = "speedscope"
+	ProfileFileTypeJFR        ProfileFileType = "jfr"
 )
 
 type ConverterFn func(b []byte, name string, maxNodes int) ([]*flamebearer.FlamebearerProfile, error)
@@ -54,6 +59,8 @@ var formatConverters = map[ProfileFileType]ConverterFn{
 	ProfileFileTypePprof:      PprofToProfile,
 	ProfileFileTypeCollapsed:  CollapsedToProfile,
 	ProfileFileTypePerfScript: PerfScriptToProfile,
+	ProfileFileTypeSpeedscope: SpeedscopeToProfile,
+	ProfileFileTypeJFR:        JFRToProfile,
 }
 
 func FlamebearerFromFile(f ProfileFile, maxNodes int) ([]*flamebearer.FlamebearerProfile, error) {
@@ -107,6 +114,10 @@ func converterToFormat(f ConverterFn) ProfileFileType {
 		return ProfileFileTypeCollapsed
 	case reflect.ValueOf(PerfScriptToProfile).Pointer():
 		return ProfileFileTypePerfScript
+	case reflect.ValueOf(SpeedscopeToProfile).Pointer():
+		return ProfileFileTypeSpeedscope
+	case reflect.ValueOf(JFRToProfile).Pointer():
+		return ProfileFileTypeJFR
 	}
 	return "unknown"
 }
@@ -120,6 +131,13 @@ func converter(p ProfileFile) (ConverterFn, error) {
 	if f, ok := formatConverters[p.Type]; ok {
 		return f, nil
 	}
+	// Speedscope profiles are JSON files, often with the .json extension.
+	if isSpeedscope(p.Data) {
+		return SpeedscopeToProfile, nil
+	}
+	if bytes.HasPrefix(p.Data, jfrMagic) {
+		return JFRToProfile, nil
+	}
 	ext := strings.TrimPrefix(path.Ext(p.Name), ".")
 	if f, ok := formatConverters[ProfileFileType(ext)]; ok {
 		return f, nil
@@ -158,6 +176,15 @@ func converter(p ProfileFile) (ConverterFn, error) {
 	return CollapsedToProfile, nil
 }
 
+var (
+	jfrMagic         = []byte("FLR\x00")
+	speedscopeSchema = []byte("https://www.speedscope.app/file-format-schema.json")
+)
+
+func isSpeedscope(b []byte) bool {
+	return len(b) > 0 && b[0] == '{' && bytes.Contains(b, speedscopeSchema)
+}
+
 func JSONToProfile(b []byte, name string, maxNodes int) ([]*flamebearer.FlamebearerProfile, error) {
 	var profile flamebearer.FlamebearerProfile
 	if err := json.Unmarshal(b, &profile); err != nil {
@@ -192,6 +219,14 @@ func PprofToProfile(b []byte, name string, maxNodes int) ([]*flamebearer.Flamebe
 	if err := pprof.Decode(bytes.NewReader(b), p); err != nil {
 		return nil, fmt.Errorf("parsing pprof: %w", err)
 	}
+	fbs := pprofToProfiles(p, maxNodes)
+	if len(fbs) == 0 {
+		return nil, errors.New("no supported sample type found")
+	}
+	return fbs, nil
+}
+
+func pprofToProfiles(p *profilev1.Profile, maxNodes int) []*flamebearer.FlamebearerProfile {
 	fbs := make([]*flamebearer.FlamebearerProfile, 0)
 	for _, stype := range tree.SampleTypes(p) {
 		sampleRate := uint32(100)
@@ -219,12 +254,49 @@ func PprofToProfile(b []byte, name string, maxNodes int) ([]*flamebearer.Flamebe
 		})
 		fbs = append(fbs, &fb)
 	}
+	return fbs
+}
+
+func JFRToProfile(b []byte, name string, maxNodes int) ([]*flamebearer.FlamebearerProfile, error) {
+	profiles, err := jfrPprof.ParseJFR(b, &jfrPprof.ParseInput{SampleRate: 100}, new(jfrPprof.LabelsSnapshot))
+	if err != nil {
+		return nil, fmt.Errorf("parsing jfr: %w", err)
+	}
+	fbs := make([]*flamebearer.FlamebearerProfile, 0)
+	for _, p := range profiles.Profiles {
+		fbs = append(fbs, pprofToProfiles(p.Profile, maxNodes)...)
+	}
 	if len(fbs) == 0 {
 		return nil, errors.New("no supported sample type found")
 	}
 	return fbs, nil
 }
 
+func SpeedscopeToProfile(b []byte, name string, maxNodes int) ([]*flamebearer.FlamebearerProfile, error) {
+	profiles, err := speedscope.ParseTrees(b, name)
+	if err != nil {
+		return nil, fmt.Errorf("parsing speedscope: %w", err)
+	}
+	fbs := make([]*flamebearer.FlamebearerProfile, 0, len(profiles))
+	for _, p := range profiles {
+		fb := flamebearer.NewProfile(flamebearer.ProfileConfig{
+			Tree:     p.Val,
+			Name:     p.LabelSet.ServiceName(),
+			MaxNodes: maxNodes,
+			Metadata: metadata.Metadata{
+				SpyName:    "unknown",
+				SampleRate: p.SampleRate,
+				Units:      p.Units,
+			},
+		})
+		fbs = append(fbs, &fb)
+	}
+	if len(fbs) == 0 {
+		return nil, errors.New("no profiles found")
+	}
+	return fbs, nil
+}
+
 func CollapsedToProfile(b []byte, name string, maxNodes int) ([]*flamebearer.FlamebearerProfile, error) {
 	t := tree.New()
 	for _, line := range bytes.Split(b, []byte("\n")) {
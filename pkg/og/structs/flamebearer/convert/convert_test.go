@@ -623,6 +623,24 @@ var _ = Describe("Convert", func() {
 			Expect(len(b[0].FlamebearerProfileV1.Flamebearer.Levels)).To(Equal(2))
 		})
 	})
+
+	Describe("Speedscope", func() {
+		It("detects speedscope profiles with the json extension", func() {
+			m := ProfileFile{
+				Name: "profile.json",
+				Data: readFile("../../../convert/speedscope/testdata/two-sampled.speedscope.json"),
+			}
+
+			f, err := converter(m)
+			Expect(err).To(BeNil())
+			Expect(reflect.ValueOf(f).Pointer()).To(Equal(reflect.ValueOf(SpeedscopeToProfile).Pointer()))
+
+			b, err := f(m.Data, "profile", 1024)
+			Expect(err).To(BeNil())
+			Expect(b).To(HaveLen(2))
+			Expect(b[0].Metadata.Name).To(HavePrefix("profile."))
+		})
+	})
 })
 
 func readFile(path string) []byte {
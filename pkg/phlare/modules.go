@@ -34,23 +34,34 @@ import (
 	"google.golang.org/protobuf/encoding/protojson"
 	"gopkg.in/yaml.v3"
 
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
 	statusv1 "github.com/grafana/pyroscope/api/gen/proto/go/status/v1"
 	"github.com/grafana/pyroscope/pkg/adhocprofiles"
 	apiversion "github.com/grafana/pyroscope/pkg/api/version"
 	"github.com/grafana/pyroscope/pkg/compactor"
 	"github.com/grafana/pyroscope/pkg/distributor"
 	"github.com/grafana/pyroscope/pkg/embedded/grafana"
+	"github.com/grafana/pyroscope/pkg/experiment/metrics"
 	"github.com/grafana/pyroscope/pkg/experiment/query_backend"
+	"github.com/grafana/pyroscope/pkg/frontend"
+	"github.com/grafana/pyroscope/pkg/frontend/federation"
 	"github.com/grafana/pyroscope/pkg/ingester"
+	"github.com/grafana/pyroscope/pkg/notifier"
 	objstoreclient "github.com/grafana/pyroscope/pkg/objstore/client"
 	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
 	"github.com/grafana/pyroscope/pkg/operations"
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
+	"github.com/grafana/pyroscope/pkg/phlaredb/bucket"
 	"github.com/grafana/pyroscope/pkg/querier"
 	"github.com/grafana/pyroscope/pkg/querier/worker"
+	"github.com/grafana/pyroscope/pkg/regression"
+	"github.com/grafana/pyroscope/pkg/reports"
+	"github.com/grafana/pyroscope/pkg/ruler"
 	"github.com/grafana/pyroscope/pkg/scheduler"
 	"github.com/grafana/pyroscope/pkg/settings"
+	"github.com/grafana/pyroscope/pkg/settings/annotations"
 	"github.com/grafana/pyroscope/pkg/storegateway"
+	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/usagestats"
 	"github.com/grafana/pyroscope/pkg/util"
 	"github.com/grafana/pyroscope/pkg/util/build"
@@ -86,6 +97,11 @@ const (
 	EmbeddedGrafana   string = "embedded-grafana"
 	FeatureFlags      string = "feature-flags"
 
+	// Background evaluations of the profiles
+	Ruler              string = "ruler"
+	RegressionDetector string = "regression-detector"
+	ScheduledReports   string = "scheduled-reports"
+
 	// Experimental modules
 
 	Metastore            string = "metastore"
@@ -157,7 +173,7 @@ func (f *Phlare) initAdHocProfiles() (services.Service, error) {
 		return nil, nil
 	}
 
-	a := adhocprofiles.NewAdHocProfiles(f.storageBucket, f.logger, f.Overrides)
+	a := adhocprofiles.NewAdHocProfiles(f.storageBucket, f.logger, f.Overrides, f.Cfg.AdHocProfiles)
 	f.API.RegisterAdHocProfiles(a)
 	return a, nil
 }
@@ -241,6 +257,22 @@ func (f *Phlare) setupWorkerTimeout() {
 	}
 }
 
+// registerQuerierService registers the querier service of the read path,
+// federated with the remote clusters, if configured. The stale series of
+// the series responses are marked.
+func (f *Phlare) registerQuerierService(svc querierv1connect.QuerierServiceHandler) {
+	if f.Cfg.Federation.Enabled() {
+		svc = federation.New(f.Cfg.Federation, svc, f.Overrides)
+	}
+	svc = querier.NewStaleSeriesMarker(svc, f.Overrides)
+	var annotationLister querier.AnnotationLister
+	if f.Cfg.TenantSettings.Annotations.Enabled && f.storageBucket != nil {
+		annotationLister = annotations.NewReader(f.storageBucket, log.With(f.logger, "component", "annotations"))
+	}
+	f.API.RegisterPyroscopeHandlers(svc, annotationLister)
+	f.API.RegisterQuerierServiceHandler(svc)
+}
+
 func (f *Phlare) initQuerier() (services.Service, error) {
 	newQuerierParams := &querier.NewQuerierParams{
 		Cfg:             f.Cfg.Querier,
@@ -259,8 +291,7 @@ func (f *Phlare) initQuerier() (services.Service, error) {
 	}
 
 	if !f.isModuleActive(QueryFrontend) {
-		f.API.RegisterPyroscopeHandlers(querierSvc)
-		f.API.RegisterQuerierServiceHandler(querierSvc)
+		f.registerQuerierService(querierSvc)
 	}
 
 	qWorker, err := worker.NewQuerierWorker(
@@ -586,6 +617,101 @@ func (f *Phlare) initAdmin() (services.Service, error) {
 	return a, nil
 }
 
+func (f *Phlare) initRuler() (services.Service, error) {
+	if f.Cfg.Ruler.RemoteWriteAddress == "" {
+		level.Debug(f.logger).Log("msg", "no remote write address configured, the ruler will not be loaded")
+		return nil, nil
+	}
+
+	logger := log.With(f.logger, "component", Ruler)
+	metricsExporter, err := metrics.NewExporter(f.Cfg.Ruler.RemoteWriteAddress, logger, f.reg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to init remote write exporter")
+	}
+	address := f.Cfg.Ruler.QueryFrontendAddress
+	if address == "" {
+		address = fmt.Sprintf("http://127.0.0.1:%d", f.Cfg.Server.HTTPListenPort)
+	}
+	httpClient := &http.Client{Timeout: f.Cfg.Ruler.QueryTimeout}
+	client := querierv1connect.NewQuerierServiceClient(httpClient, address, f.auth, frontend.WithBatchPriority())
+	n, err := notifier.New(f.Cfg.Notifier)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to init notifier")
+	}
+
+	return ruler.New(f.Cfg.Ruler, client, metricsExporter, n, f.Overrides, f.listTenants, logger, f.reg), nil
+}
+
+func (f *Phlare) initRegressionDetector() (services.Service, error) {
+	if !f.Cfg.RegressionDetector.Enabled {
+		return nil, nil
+	}
+
+	address := f.Cfg.RegressionDetector.QueryFrontendAddress
+	if address == "" {
+		address = fmt.Sprintf("http://127.0.0.1:%d", f.Cfg.Server.HTTPListenPort)
+	}
+	client := querierv1connect.NewQuerierServiceClient(http.DefaultClient, address, f.auth, frontend.WithBatchPriority())
+	logger := log.With(f.logger, "component", RegressionDetector)
+	notifierCfg := f.Cfg.Notifier
+	if f.Cfg.RegressionDetector.DeprecatedWebhookURL != "" {
+		level.Warn(logger).Log("msg", "regression detection config has a deprecated regression-detection.webhook-url flag set. Please, use notifier.webhook-url instead.")
+		notifierCfg.WebhookURL = f.Cfg.RegressionDetector.DeprecatedWebhookURL
+	}
+	n, err := notifier.New(notifierCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to init notifier")
+	}
+
+	return regression.New(f.Cfg.RegressionDetector, client, n, f.listTenants, logger, f.reg), nil
+}
+
+func (f *Phlare) initScheduledReports() (services.Service, error) {
+	if !f.Cfg.Reports.Enabled {
+		return nil, nil
+	}
+
+	address := f.Cfg.Reports.QueryFrontendAddress
+	if address == "" {
+		address = fmt.Sprintf("http://127.0.0.1:%d", f.Cfg.Server.HTTPListenPort)
+	}
+	client := querierv1connect.NewQuerierServiceClient(http.DefaultClient, address, f.auth, frontend.WithBatchPriority())
+	n, err := notifier.New(f.Cfg.Notifier)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to init notifier")
+	}
+	if n == nil {
+		return nil, errors.New("the scheduled reports require a notifier webhook, Slack webhook, or SMTP server")
+	}
+
+	logger := log.With(f.logger, "component", ScheduledReports)
+	return reports.New(f.Cfg.Reports, client, n, f.listTenants, logger, f.reg), nil
+}
+
+// listTenants returns the tenants with profiles in the storage, and the
+// tenants with overrides. The background evaluations, such as the recording
+// rules, run for these tenants.
+func (f *Phlare) listTenants(ctx context.Context) ([]string, error) {
+	var tenants []string
+	if f.storageBucket != nil {
+		users, err := bucket.ListUsers(ctx, f.storageBucket)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, users...)
+	}
+	if f.TenantLimits != nil {
+		for tenantID := range f.TenantLimits.AllByTenantID() {
+			tenants = append(tenants, tenantID)
+		}
+	}
+	if !f.Cfg.MultitenancyEnabled {
+		tenants = append(tenants, tenant.DefaultTenantID)
+	}
+	slices.Sort(tenants)
+	return slices.Compact(tenants), nil
+}
+
 func (f *Phlare) initEmbeddedGrafana() (services.Service, error) {
 	return grafana.New(f.Cfg.EmbeddedGrafana, f.logger)
 }
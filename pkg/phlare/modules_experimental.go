@@ -78,9 +78,9 @@ func (f *Phlare) initQueryFrontendV1() (services.Service, error) {
 		return nil, err
 	}
 	f.API.RegisterFrontendForQuerierHandler(f.frontend)
-	f.API.RegisterQuerierServiceHandler(f.frontend)
-	f.API.RegisterPyroscopeHandlers(f.frontend)
+	f.registerQuerierService(f.frontend)
 	f.API.RegisterVCSServiceHandler(f.frontend)
+	f.API.RegisterSourceSnippets(vcs.NewSourceSnippets(log.With(f.logger, "component", "vcs-service"), f.reg, f.Overrides))
 	return f.frontend, nil
 }
 
@@ -99,9 +99,9 @@ func (f *Phlare) initQueryFrontendV2() (services.Service, error) {
 		f.reg,
 	)
 
-	f.API.RegisterQuerierServiceHandler(queryFrontend)
-	f.API.RegisterPyroscopeHandlers(queryFrontend)
+	f.registerQuerierService(queryFrontend)
 	f.API.RegisterVCSServiceHandler(vcsService)
+	f.API.RegisterSourceSnippets(vcs.NewSourceSnippets(log.With(f.logger, "component", "vcs-service"), f.reg, f.Overrides))
 
 	// New query frontend does not have any state.
 	// For simplicity, we return a no-op service.
@@ -142,9 +142,9 @@ func (f *Phlare) initQueryFrontendV12() (services.Service, error) {
 	)
 
 	f.API.RegisterFrontendForQuerierHandler(f.frontend)
-	f.API.RegisterQuerierServiceHandler(handler)
-	f.API.RegisterPyroscopeHandlers(handler)
+	f.registerQuerierService(handler)
 	f.API.RegisterVCSServiceHandler(vcsService)
+	f.API.RegisterSourceSnippets(vcs.NewSourceSnippets(log.With(f.logger, "component", "vcs-service"), f.reg, f.Overrides))
 
 	return f.frontend, nil
 }
@@ -254,6 +254,7 @@ func (f *Phlare) initCompactionWorker() (svc services.Service, err error) {
 		registerer,
 		ruler,
 		exporter,
+		f.Overrides,
 	)
 	if err != nil {
 		return nil, err
@@ -388,6 +389,7 @@ func (f *Phlare) initSymbolizer() (services.Service, error) {
 	}
 
 	f.symbolizer = sym
+	f.API.RegisterSymbolizer(sym)
 
 	return nil, nil
 }
@@ -41,6 +41,7 @@ import (
 
 	"github.com/grafana/pyroscope-go"
 
+	"github.com/grafana/pyroscope/pkg/adhocprofiles"
 	"github.com/grafana/pyroscope/pkg/api"
 	apiversion "github.com/grafana/pyroscope/pkg/api/version"
 	"github.com/grafana/pyroscope/pkg/cfg"
@@ -58,13 +59,19 @@ import (
 	querybackendclient "github.com/grafana/pyroscope/pkg/experiment/query_backend/client"
 	"github.com/grafana/pyroscope/pkg/experiment/symbolizer"
 	"github.com/grafana/pyroscope/pkg/frontend"
+	"github.com/grafana/pyroscope/pkg/frontend/federation"
 	"github.com/grafana/pyroscope/pkg/ingester"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/notifier"
 	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
 	objstoreclient "github.com/grafana/pyroscope/pkg/objstore/client"
 	"github.com/grafana/pyroscope/pkg/operations"
 	"github.com/grafana/pyroscope/pkg/phlaredb"
 	"github.com/grafana/pyroscope/pkg/querier"
 	"github.com/grafana/pyroscope/pkg/querier/worker"
+	"github.com/grafana/pyroscope/pkg/regression"
+	"github.com/grafana/pyroscope/pkg/reports"
+	"github.com/grafana/pyroscope/pkg/ruler"
 	"github.com/grafana/pyroscope/pkg/scheduler"
 	"github.com/grafana/pyroscope/pkg/scheduler/schedulerdiscovery"
 	"github.com/grafana/pyroscope/pkg/settings"
@@ -99,6 +106,15 @@ type Config struct {
 	Compactor         compactor.Config       `yaml:"compactor"`
 	TenantSettings    settings.Config        `yaml:"tenant_settings"`
 
+	Ruler              ruler.Config         `yaml:"ruler"`
+	RegressionDetector regression.Config    `yaml:"regression_detection"`
+	Reports            reports.Config       `yaml:"reports"`
+	Notifier           notifier.Config      `yaml:"notifier"`
+	AdHocProfiles      adhocprofiles.Config `yaml:"adhoc_profiles"`
+	Federation         federation.Config    `yaml:"federation"`
+
+	ProfileTypes []phlaremodel.ProfileTypeDefinition `yaml:"profile_types" doc:"description=Definitions of the sample types of custom profilers, or overrides of the built-in ones. Each definition has the sample type, the unit the values are displayed in, the number of values per second for the samples unit, the aggregation of the values over time (sum or average), and whether the values are cumulative."`
+
 	Storage       StorageConfig       `yaml:"storage"`
 	SelfProfiling SelfProfilingConfig `yaml:"self_profiling,omitempty"`
 
@@ -202,6 +218,12 @@ func (c *Config) RegisterFlagsWithContext(f *flag.FlagSet) {
 	c.API.RegisterFlags(f)
 	c.EmbeddedGrafana.RegisterFlags(f)
 	c.TenantSettings.RegisterFlags(f)
+	c.Ruler.RegisterFlags(f)
+	c.RegressionDetector.RegisterFlags(f)
+	c.Reports.RegisterFlags(f)
+	c.Notifier.RegisterFlags(f)
+	c.AdHocProfiles.RegisterFlags(f)
+	c.Federation.RegisterFlags(f)
 }
 
 // registerServerFlagsWithChangedDefaultValues registers *Config.Server flags, but overrides some defaults set by the dskit package.
@@ -276,6 +298,10 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	if err := c.Federation.Validate(); err != nil {
+		return err
+	}
+
 	if err := c.Worker.Validate(util.Logger); err != nil {
 		return err
 	}
@@ -288,6 +314,12 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	for i := range c.ProfileTypes {
+		if err := c.ProfileTypes[i].Validate(); err != nil {
+			return err
+		}
+	}
+
 	if err := c.Ingester.Validate(); err != nil {
 		return err
 	}
@@ -300,6 +332,25 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	if err := c.Ruler.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.RegressionDetector.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Reports.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Notifier.Validate(); err != nil {
+		return err
+	}
+	if c.RegressionDetector.DeprecatedWebhookURL != "" && c.Notifier.WebhookURL != "" {
+		return errors.New("both regression-detection.webhook-url and notifier.webhook-url are set, please use only notifier.webhook-url, as regression-detection.webhook-url is deprecated")
+	}
+
 	if err := c.Compactor.Validate(c.PhlareDB.MaxBlockDuration); err != nil {
 		return err
 	}
@@ -403,6 +454,7 @@ func New(cfg Config) (*Phlare, error) {
 		return nil, err
 	}
 
+	phlaremodel.ProfileTypes.Register(cfg.ProfileTypes...)
 	runtime.SetMutexProfileFraction(cfg.SelfProfiling.MutexProfileFraction)
 	runtime.SetBlockProfileRate(cfg.SelfProfiling.BlockProfileRate)
 
@@ -425,6 +477,7 @@ func New(cfg Config) (*Phlare, error) {
 	phlare.auth = connect.WithInterceptors(tenant.NewAuthInterceptor(cfg.MultitenancyEnabled))
 	phlare.Cfg.API.HTTPAuthMiddleware = util.AuthenticateUser(cfg.MultitenancyEnabled)
 	phlare.Cfg.API.GrpcAuthMiddleware = phlare.auth
+	phlare.Cfg.API.Tokens.MultitenancyEnabled = cfg.MultitenancyEnabled
 
 	return phlare, nil
 }
@@ -456,6 +509,9 @@ func (f *Phlare) setupModuleManager() error {
 	mm.RegisterModule(AdHocProfiles, f.initAdHocProfiles)
 	mm.RegisterModule(EmbeddedGrafana, f.initEmbeddedGrafana)
 	mm.RegisterModule(FeatureFlags, f.initFeatureFlags)
+	mm.RegisterModule(Ruler, f.initRuler)
+	mm.RegisterModule(RegressionDetector, f.initRegressionDetector)
+	mm.RegisterModule(ScheduledReports, f.initScheduledReports)
 
 	// Add dependencies
 	deps := map[string][]string{
@@ -470,6 +526,9 @@ func (f *Phlare) setupModuleManager() error {
 			Admin,
 			TenantSettings,
 			AdHocProfiles,
+			Ruler,
+			RegressionDetector,
+			ScheduledReports,
 		},
 
 		Server:            {GRPCGateway},
@@ -493,6 +552,10 @@ func (f *Phlare) setupModuleManager() error {
 		AdHocProfiles:     {API, Overrides, Storage},
 		EmbeddedGrafana:   {API},
 		FeatureFlags:      {API},
+
+		Ruler:              {API, Overrides, Storage},
+		RegressionDetector: {API, Overrides, Storage},
+		ScheduledReports:   {API, Overrides, Storage},
 	}
 
 	// Experimental modules.
@@ -507,7 +570,7 @@ func (f *Phlare) setupModuleManager() error {
 			SegmentWriterClient: {Overrides, API, SegmentWriterRing, PlacementAgent},
 			PlacementAgent:      {Overrides, API, Storage},
 			PlacementManager:    {Overrides, API, Storage},
-			Symbolizer:          {Overrides, Storage},
+			Symbolizer:          {Overrides, API, Storage},
 		}
 		for k, v := range experimentalModules {
 			deps[k] = v
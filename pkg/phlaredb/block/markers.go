@@ -126,6 +126,10 @@ func ReadMarker(ctx context.Context, logger log.Logger, bkt objstore.BucketReade
 		if version := marker.(*DeletionMark).Version; version != DeletionMarkVersion1 {
 			return errors.Errorf("unexpected deletion-mark file version %d, expected %d", version, DeletionMarkVersion1)
 		}
+	case QuarantineMarkFilename:
+		if version := marker.(*QuarantineMark).Version; version != QuarantineMarkVersion1 {
+			return errors.Errorf("unexpected quarantine-mark file version %d, expected %d", version, QuarantineMarkVersion1)
+		}
 	}
 	return nil
 }
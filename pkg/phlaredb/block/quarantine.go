@@ -0,0 +1,153 @@
+package block
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/runutil"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/objstore"
+)
+
+const (
+	// QuarantineDir is the directory of the tenant bucket the blocks that
+	// cannot be compacted are moved to. The blocks of the directory are not
+	// discovered by the components reading the blocks.
+	QuarantineDir = "quarantine"
+	// QuarantineMarkFilename is the known json filename storing details about
+	// why the block was quarantined.
+	QuarantineMarkFilename = "quarantine-mark.json"
+
+	// QuarantineMarkVersion1 is the version of quarantine-mark file.
+	QuarantineMarkVersion1 = 1
+)
+
+// QuarantineReason is a reason for a block to be quarantined.
+type QuarantineReason string
+
+const (
+	// EmptyBlockQuarantineReason is the reason of the blocks without profiles.
+	EmptyBlockQuarantineReason QuarantineReason = "empty"
+	// CorruptBlockQuarantineReason is the reason of the blocks that cannot be opened.
+	CorruptBlockQuarantineReason QuarantineReason = "corrupt"
+)
+
+// QuarantineMark stores the reason of the block being quarantined.
+type QuarantineMark struct {
+	// ID of the tsdb block.
+	ID ulid.ULID `json:"id"`
+	// Version of the file.
+	Version int `json:"version"`
+	// Details is a human readable string giving details of reason.
+	Details string `json:"details,omitempty"`
+
+	// QuarantineTime is a unix timestamp of when the block was quarantined.
+	QuarantineTime int64            `json:"quarantine_time"`
+	Reason         QuarantineReason `json:"reason"`
+}
+
+func (m *QuarantineMark) markerFilename() string { return QuarantineMarkFilename }
+
+// Quarantine moves the block to the quarantine directory of the bucket,
+// with a file which marks why the block was quarantined. The block is
+// copied before it is deleted: a block quarantined partially is quarantined
+// again on the next attempt.
+func Quarantine(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID, reason QuarantineReason, details string, quarantined *prometheus.CounterVec) error {
+	dst := path.Join(QuarantineDir, id.String())
+	if err := copyDirRec(ctx, bkt, id.String(), dst); err != nil {
+		return errors.Wrapf(err, "copy block %s to %s", id, dst)
+	}
+
+	mark, err := json.Marshal(QuarantineMark{
+		ID:      id,
+		Version: QuarantineMarkVersion1,
+
+		QuarantineTime: time.Now().Unix(),
+		Reason:         reason,
+		Details:        details,
+	})
+	if err != nil {
+		return errors.Wrap(err, "json encode quarantine mark")
+	}
+	m := path.Join(dst, QuarantineMarkFilename)
+	if err = bkt.Upload(ctx, m, bytes.NewBuffer(mark)); err != nil {
+		return errors.Wrapf(err, "upload file %s to bucket", m)
+	}
+
+	if err = Delete(ctx, logger, bkt, id); err != nil {
+		return errors.Wrapf(err, "delete quarantined block %s", id)
+	}
+	quarantined.WithLabelValues(string(reason)).Inc()
+	level.Warn(logger).Log("msg", "block has been quarantined", "block", id, "reason", reason, "details", details)
+	return nil
+}
+
+// copyDirRec copies all objects prefixed with src to dst.
+func copyDirRec(ctx context.Context, bkt objstore.Bucket, src, dst string) error {
+	return bkt.Iter(ctx, src, func(name string) (err error) {
+		target := path.Join(dst, strings.TrimPrefix(name, src))
+		if strings.HasSuffix(name, objstore.DirDelim) {
+			return copyDirRec(ctx, bkt, name, target)
+		}
+		r, err := bkt.Get(ctx, name)
+		if err != nil {
+			return errors.Wrapf(err, "get file %s", name)
+		}
+		defer runutil.CloseWithErrCapture(&err, r, "close file %s", name)
+		if err = bkt.Upload(ctx, target, r); err != nil {
+			return errors.Wrapf(err, "upload file %s", target)
+		}
+		return nil
+	})
+}
+
+// ListQuarantined returns the quarantine marks of the quarantined blocks.
+// The blocks without a mark, for example, the blocks being quarantined, are
+// returned with only their ID.
+func ListQuarantined(ctx context.Context, logger log.Logger, bkt objstore.Bucket) ([]*QuarantineMark, error) {
+	var marks []*QuarantineMark
+	err := bkt.Iter(ctx, QuarantineDir, func(name string) error {
+		id, ok := IsBlockDir(name)
+		if !ok {
+			return nil
+		}
+		m := &QuarantineMark{ID: id}
+		err := ReadMarker(ctx, logger, bkt, path.Join(QuarantineDir, id.String()), m)
+		if err != nil && !errors.Is(err, ErrorMarkerNotFound) {
+			return err
+		}
+		marks = append(marks, m)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "list quarantined blocks")
+	}
+	return marks, nil
+}
+
+// DeleteQuarantined permanently deletes the quarantined block. The
+// quarantine mark is deleted last, so that a block deleted partially is
+// still listed.
+func DeleteQuarantined(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID) error {
+	dir := path.Join(QuarantineDir, id.String())
+	markFile := path.Join(dir, QuarantineMarkFilename)
+	err := deleteDirRec(ctx, logger, bkt, dir, func(name string) bool {
+		return name == markFile
+	})
+	if err != nil {
+		return err
+	}
+	if err = bkt.Delete(ctx, markFile); err != nil && !bkt.IsObjNotFoundErr(err) {
+		return errors.Wrapf(err, "delete %s", markFile)
+	}
+	level.Info(logger).Log("msg", "deleted quarantined block", "block", id)
+	return nil
+}
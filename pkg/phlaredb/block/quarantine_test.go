@@ -0,0 +1,61 @@
+package block_test
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"go.uber.org/goleak"
+
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+	block_testutil "github.com/grafana/pyroscope/pkg/phlaredb/block/testutil"
+	"github.com/grafana/pyroscope/pkg/pprof/testhelper"
+)
+
+func TestQuarantine(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	ctx := context.Background()
+
+	bkt := objstore.NewInMemBucket()
+	meta, dir := block_testutil.CreateBlock(t, func() []*testhelper.ProfileBuilder {
+		return []*testhelper.ProfileBuilder{
+			testhelper.NewProfileBuilder(int64(1)).
+				CPUProfile().
+				WithLabels(
+					"job", "a",
+				).ForStacktraceString("foo", "bar", "baz").AddSamples(1),
+		}
+	})
+	require.NoError(t, block.Upload(ctx, log.NewNopLogger(), bkt, path.Join(dir, meta.ULID.String())))
+	uploaded := objects(t, bkt, meta.ULID)
+
+	quarantined := promauto.With(prometheus.NewRegistry()).NewCounterVec(prometheus.CounterOpts{Name: "test"}, []string{"reason"})
+	require.NoError(t, block.Quarantine(ctx, log.NewNopLogger(), bkt, meta.ULID, block.CorruptBlockQuarantineReason, "open block: EOF", quarantined))
+	require.Equal(t, float64(1), promtest.ToFloat64(quarantined.WithLabelValues(string(block.CorruptBlockQuarantineReason))))
+	require.Empty(t, objects(t, bkt, meta.ULID))
+
+	var moved []string
+	require.NoError(t, bkt.Iter(ctx, path.Join(block.QuarantineDir, meta.ULID.String()), func(name string) error {
+		moved = append(moved, name)
+		return nil
+	}, objstore.WithRecursiveIter()))
+	require.Len(t, moved, len(uploaded)+1)
+
+	marks, err := block.ListQuarantined(ctx, log.NewNopLogger(), bkt)
+	require.NoError(t, err)
+	require.Len(t, marks, 1)
+	require.Equal(t, meta.ULID, marks[0].ID)
+	require.Equal(t, block.CorruptBlockQuarantineReason, marks[0].Reason)
+	require.Equal(t, "open block: EOF", marks[0].Details)
+
+	require.NoError(t, block.DeleteQuarantined(ctx, log.NewNopLogger(), bkt, meta.ULID))
+	marks, err = block.ListQuarantined(ctx, log.NewNopLogger(), bkt)
+	require.NoError(t, err)
+	require.Empty(t, marks)
+}
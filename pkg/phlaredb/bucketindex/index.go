@@ -80,6 +80,10 @@ type Block struct {
 	// Block's compactor shard ID, copied from tsdb.CompactorShardIDExternalLabel label.
 	CompactorShardID string `json:"compactor_shard_id,omitempty"`
 	CompactionLevel  int    `json:"compaction_level,omitempty"`
+
+	// SizeBytes is the total size of the block files. It is zero
+	// for blocks indexed before the field was introduced.
+	SizeBytes uint64 `json:"size_bytes,omitempty"`
 }
 
 // Within returns whether the block contains samples within the provided range.
@@ -128,6 +132,7 @@ func BlockFromMeta(meta block.Meta) *Block {
 		MaxTime:          meta.MaxTime,
 		CompactorShardID: meta.Labels[sharding.CompactorShardIDLabel],
 		CompactionLevel:  meta.Compaction.Level,
+		SizeBytes:        meta.GetStats().TotalSizeBytes,
 	}
 }
 
@@ -54,6 +54,26 @@ func TestBlockFromMeta(t *testing.T) {
 				CompactionLevel:  0,
 			},
 		},
+		"meta.json with files": {
+			meta: block.Meta{
+				ULID:    blockID,
+				MinTime: model.Time(10),
+				MaxTime: model.Time(20),
+				Files: []block.File{
+					{RelPath: "index.tsdb", SizeBytes: 100},
+					{RelPath: "profiles.parquet", SizeBytes: 1000},
+					{RelPath: "symbols/index.symdb", SizeBytes: 10},
+				},
+				Compaction: block.BlockMetaCompaction{Level: 2},
+			},
+			expected: Block{
+				ID:              blockID,
+				MinTime:         model.Time(10),
+				MaxTime:         model.Time(20),
+				CompactionLevel: 2,
+				SizeBytes:       1110,
+			},
+		},
 	}
 
 	for testName, testData := range tests {
@@ -9,14 +9,7 @@ import (
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
 )
 
-const (
-	memoryProfileName   = "memory"
-	allocObjectTypeName = "alloc_objects"
-	allocSpaceTypeName  = "alloc_space"
-	blockProfileName    = "block"
-	contentionsTypeName = "contentions"
-	delayTypeName       = "delay"
-)
+const memoryProfileName = "memory"
 
 // deltaProfiles is a helper to compute delta of profiles.
 type deltaProfiles struct {
@@ -72,14 +65,12 @@ func (d *deltaProfiles) computeDelta(ps schemav1.InMemoryProfile) schemav1.Sampl
 }
 
 func isDeltaSupported(lbs phlaremodel.Labels) bool {
-	// only compute delta for allocs memory profile.
-	if lbs.Get(model.MetricNameLabel) == memoryProfileName {
-		ty := lbs.Get(phlaremodel.LabelNameType)
-		if ty == allocObjectTypeName || ty == allocSpaceTypeName {
-			return true
-		}
+	// only compute delta for the cumulative sample types of the memory
+	// profile, such as the allocations.
+	if lbs.Get(model.MetricNameLabel) != memoryProfileName {
+		return false
 	}
-	return false
+	return phlaremodel.ProfileTypes.IsCumulative(lbs.Get(phlaremodel.LabelNameType))
 }
 
 func deltaSamples(highest map[uint32]uint64, new schemav1.Samples) bool {
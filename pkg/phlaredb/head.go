@@ -71,6 +71,7 @@ type Head struct {
 	totalSamples  *atomic.Uint64
 	tables        []Table
 	delta         *deltaProfiles
+	wal           *wal // nil if the write-ahead log is disabled.
 
 	limiter   TenantLimiter
 	updatedAt *atomic.Time
@@ -140,6 +141,12 @@ func NewHead(phlarectx context.Context, cfg Config, limiter TenantLimiter) (*Hea
 
 	h.symdb = symdb.NewSymDB(symdbConfig)
 
+	if cfg.WALEnabled {
+		if h.wal, err = openWAL(h.headPath); err != nil {
+			return nil, err
+		}
+	}
+
 	h.wg.Add(1)
 	go h.loop()
 
@@ -168,6 +175,11 @@ func (h *Head) loop() {
 		select {
 		case <-symdbMetricsUpdateTicker.C:
 			h.updateSymbolsMemUsage(&memStats)
+			if h.wal != nil {
+				if err := h.wal.sync(); err != nil {
+					level.Error(h.logger).Log("msg", "failed to sync the write-ahead log", "err", err)
+				}
+			}
 		case <-h.stopCh:
 			return
 		}
@@ -194,6 +206,12 @@ func (h *Head) Ingest(ctx context.Context, p *profilev1.Profile, id uuid.UUID, a
 		return nil
 	}
 
+	if h.wal != nil {
+		if err := h.wal.append(p, id, annotations, externalLabels); err != nil {
+			return err
+		}
+	}
+
 	delta := phlaremodel.Labels(externalLabels).Get(phlaremodel.LabelNameDelta) != "false"
 	externalLabels = phlaremodel.Labels(externalLabels).Delete(phlaremodel.LabelNameDelta)
 
@@ -557,6 +575,11 @@ func (h *Head) flush(ctx context.Context) error {
 	// It must be guaranteed that no new inserts will happen
 	// after the call start.
 	h.inFlightProfiles.Wait()
+	if h.wal != nil {
+		if err := h.wal.close(); err != nil {
+			return errors.Wrap(err, "closing write-ahead log")
+		}
+	}
 	if h.profiles.index.totalProfiles.Load() == 0 {
 		level.Info(h.logger).Log("msg", "head empty - no block written")
 		return os.RemoveAll(h.headPath)
@@ -636,6 +659,12 @@ func (h *Head) flush(ctx context.Context) error {
 	if _, err := h.meta.WriteToFile(h.logger, h.headPath); err != nil {
 		return err
 	}
+	// The profiles are in the block: the write-ahead log is not needed anymore.
+	if h.wal != nil {
+		if err := os.Remove(filepath.Join(h.headPath, walFilename)); err != nil {
+			return err
+		}
+	}
 	h.metrics.blockDurationSeconds.Observe(h.meta.MaxTime.Sub(h.meta.MinTime).Seconds())
 	return nil
 }
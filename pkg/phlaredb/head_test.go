@@ -27,6 +27,7 @@ import (
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
 	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/pprof/testhelper"
 )
 
 type noLimit struct{}
@@ -318,6 +319,42 @@ func TestHead_SelectMatchingProfiles_Order(t *testing.T) {
 	}
 }
 
+func TestHeadIngestCumulativeDeltas(t *testing.T) {
+	head := newTestHead(t)
+	ctx := context.Background()
+
+	for i, v := range []int64{1, 3} {
+		p := testhelper.NewProfileBuilder(int64(time.Second)*int64(i+1)).
+			MemoryProfile().
+			WithLabels(phlaremodel.LabelNameDelta, "true").
+			ForStacktraceString("foo", "bar").
+			AddSamples(v, v*1024, v, v*1024)
+		require.NoError(t, head.Ingest(ctx, p.Profile, p.UUID, nil, p.Labels...))
+	}
+
+	for _, tc := range []struct {
+		profileType string
+		expected    int64
+	}{
+		{profileType: "memory:alloc_space:bytes:space:bytes", expected: 2 * 1024},
+		{profileType: "memory:inuse_space:bytes:space:bytes", expected: 4 * 1024},
+	} {
+		typ, err := phlaremodel.ParseProfileTypeSelector(tc.profileType)
+		require.NoError(t, err)
+		var total int64
+		for _, q := range head.Queriers() {
+			tree, err := q.SelectMergeByStacktraces(ctx, &ingestv1.SelectProfilesRequest{
+				LabelSelector: "{}",
+				Type:          typ,
+				End:           int64(model.Now()),
+			}, 0)
+			require.NoError(t, err)
+			total += tree.Total()
+		}
+		assert.Equal(t, tc.expected, total, tc.profileType)
+	}
+}
+
 func TestHeadFlush(t *testing.T) {
 	profilePaths := []string{
 		"testdata/heap",
@@ -42,6 +42,10 @@ type headMetrics struct {
 	flushedBlocksReasons        *prometheus.CounterVec
 	writtenProfileSegments      *prometheus.CounterVec
 	writtenProfileSegmentsBytes prometheus.Histogram
+
+	walReplayDuration    prometheus.Histogram
+	walReplayedProfiles  prometheus.Counter
+	walCorruptedSegments prometheus.Counter
 }
 
 func newHeadMetrics(reg prometheus.Registerer) *headMetrics {
@@ -154,6 +158,19 @@ func newHeadMetricsWithPrefix(reg prometheus.Registerer, prefix string) *headMet
 			Name: prefix + "_head_samples",
 			Help: "Number of samples in the head.",
 		}),
+		walReplayDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    prefix + "_head_wal_replay_duration_seconds",
+			Help:    "Time to replay the write-ahead logs of the heads at start-up in seconds.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+		}),
+		walReplayedProfiles: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: prefix + "_head_wal_replayed_profiles_total",
+			Help: "Total number of profiles replayed from the write-ahead logs.",
+		}),
+		walCorruptedSegments: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: prefix + "_head_wal_corrupted_segments_total",
+			Help: "Total number of corrupted write-ahead logs: the profiles after the corrupted record are lost.",
+		}),
 	}
 
 	m.register(reg)
@@ -183,6 +200,9 @@ func (m *headMetrics) register(reg prometheus.Registerer) {
 	m.flushedBlocksReasons = util.RegisterOrGet(reg, m.flushedBlocksReasons)
 	m.writtenProfileSegments = util.RegisterOrGet(reg, m.writtenProfileSegments)
 	m.writtenProfileSegmentsBytes = util.RegisterOrGet(reg, m.writtenProfileSegmentsBytes)
+	m.walReplayDuration = util.RegisterOrGet(reg, m.walReplayDuration)
+	m.walReplayedProfiles = util.RegisterOrGet(reg, m.walReplayedProfiles)
+	m.walCorruptedSegments = util.RegisterOrGet(reg, m.walCorruptedSegments)
 }
 
 func ContextWithHeadMetrics(ctx context.Context, reg prometheus.Registerer, prefix string) context.Context {
@@ -60,6 +60,8 @@ type Config struct {
 	MinDiskAvailablePercentage float64       `yaml:"min_disk_available_percentage"`
 	EnforcementInterval        time.Duration `yaml:"enforcement_interval"`
 	DisableEnforcement         bool          `yaml:"disable_enforcement"`
+
+	WALEnabled bool `yaml:"wal_enabled" category:"advanced"`
 }
 
 type ParquetConfig struct {
@@ -77,6 +79,7 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.Float64Var(&cfg.MinDiskAvailablePercentage, "pyroscopedb.retention-policy-min-disk-available-percentage", DefaultMinDiskAvailablePercentage, "Which percentage of free disk space to keep")
 	f.DurationVar(&cfg.EnforcementInterval, "pyroscopedb.retention-policy-enforcement-interval", DefaultRetentionPolicyEnforcementInterval, "How often to enforce disk retention")
 	f.BoolVar(&cfg.DisableEnforcement, "pyroscopedb.retention-policy-disable", false, "Disable retention policy enforcement")
+	f.BoolVar(&cfg.WALEnabled, "pyroscopedb.wal-enabled", false, "Write the received profiles to a write-ahead log, replayed at start-up if the heads were not flushed, e.g., after a crash.")
 }
 
 type TenantLimiter interface {
@@ -143,6 +146,9 @@ func New(phlarectx context.Context, cfg Config, limiter TenantLimiter, fs phlare
 	if err := f.blockQuerier.Sync(ctx); err != nil {
 		return nil, err
 	}
+	if err := f.replayWAL(ctx); err != nil {
+		return nil, err
+	}
 	return f, nil
 }
 
@@ -297,7 +303,15 @@ func (f *PhlareDB) Close() error {
 	f.wg.Wait()
 	errs := multierror.New()
 	for _, h := range f.heads {
-		errs.Add(h.Flush(f.phlarectx))
+		// The flushed heads are moved to the local blocks, so that they
+		// are found after a restart.
+		if err := h.Flush(f.phlarectx); err != nil {
+			errs.Add(err)
+			continue
+		}
+		if h.profiles.index.totalProfiles.Load() > 0 {
+			errs.Add(h.Move())
+		}
 	}
 	close(f.evictCh)
 	if err := f.blockQuerier.Close(); err != nil {
@@ -92,7 +92,10 @@ func (e *locationsBlockEncoder) encode(w io.Writer, locations []v1.InMemoryLocat
 	var folded bool
 	for i, loc := range locations {
 		e.mapping[i] = int32(loc.MappingId)
-		e.lineCount[i] = byte(len(loc.Line))
+		// The lines beyond the limit are dropped: the count must match
+		// the lines written, otherwise the lines of all the following
+		// locations are shifted.
+		e.lineCount[i] = byte(min(len(loc.Line), maxLocationLines))
 		for j := 0; j < len(loc.Line) && j < maxLocationLines; j++ {
 			e.lines = append(e.lines,
 				int32(loc.Line[j].FunctionId),
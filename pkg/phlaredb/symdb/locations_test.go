@@ -109,3 +109,23 @@ func Test_LocationsEncoding(t *testing.T) {
 		})
 	}
 }
+
+func Test_LocationsEncoding_MaxLines(t *testing.T) {
+	long := v1.InMemoryLocation{Line: make([]v1.InMemoryLine, maxLocationLines+45)}
+	for i := range long.Line {
+		long.Line[i] = v1.InMemoryLine{FunctionId: uint32(i + 1), Line: int32(i)}
+	}
+	short := v1.InMemoryLocation{Line: []v1.InMemoryLine{{FunctionId: 1, Line: 42}}}
+
+	var buf bytes.Buffer
+	w := newTestFileWriter(&buf)
+	h, err := writeSymbolsBlock(w, []v1.InMemoryLocation{long, short}, newLocationsEncoder())
+	require.NoError(t, err)
+
+	d, err := newLocationsDecoder(h)
+	require.NoError(t, err)
+	out := make([]v1.InMemoryLocation, h.Length)
+	require.NoError(t, d.decode(out, &buf))
+	require.Equal(t, long.Line[:maxLocationLines], out[0].Line)
+	require.Equal(t, short, out[1])
+}
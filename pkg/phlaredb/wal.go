@@ -0,0 +1,220 @@
+package phlaredb
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+const (
+	// walFilename is the name of the write-ahead log in the head directory.
+	walFilename = "wal"
+
+	walRecordHeaderSize = 8
+	maxWALRecordSize    = 256 << 20
+)
+
+var (
+	walCastagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	errWALCorrupted = errors.New("write-ahead log is corrupted")
+)
+
+// wal is the write-ahead log of a head: the profiles are appended to it
+// before they are ingested, and replayed if the process stops before the
+// head is flushed. The writes survive a restart of the process; the log
+// is synced to the disk periodically.
+//
+// Each record is the size and the CRC32 of its payload, followed by the
+// payload: a RawProfileSeries with a single sample.
+type wal struct {
+	mtx   sync.Mutex
+	f     *os.File
+	buf   []byte
+	dirty bool
+}
+
+func openWAL(dir string) (*wal, error) {
+	f, err := os.OpenFile(filepath.Join(dir, walFilename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &wal{f: f}, nil
+}
+
+func (w *wal) append(p *profilev1.Profile, id uuid.UUID, annotations []*typesv1.ProfileAnnotation, externalLabels []*typesv1.LabelPair) error {
+	raw, err := p.MarshalVT()
+	if err != nil {
+		return err
+	}
+	payload, err := (&pushv1.RawProfileSeries{
+		Labels:      externalLabels,
+		Samples:     []*pushv1.RawSample{{RawProfile: raw, ID: id.String()}},
+		Annotations: annotations,
+	}).MarshalVT()
+	if err != nil {
+		return err
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.buf = binary.BigEndian.AppendUint32(w.buf[:0], uint32(len(payload)))
+	w.buf = binary.BigEndian.AppendUint32(w.buf, crc32.Checksum(payload, walCastagnoli))
+	w.buf = append(w.buf, payload...)
+	if _, err = w.f.Write(w.buf); err != nil {
+		return errors.Wrap(err, "write-ahead log")
+	}
+	w.dirty = true
+	return nil
+}
+
+func (w *wal) sync() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	return w.f.Sync()
+}
+
+func (w *wal) close() error {
+	if err := w.sync(); err != nil {
+		_ = w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// replayWAL calls fn for each record of the write-ahead log. A torn or
+// corrupted record ends the replay: the records after it are lost, and
+// errWALCorrupted is returned.
+func replayWAL(path string, fn func(*pushv1.RawProfileSeries) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var header [walRecordHeaderSize]byte
+	for offset := 0; ; {
+		if _, err = io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("%w: torn record header at offset %d", errWALCorrupted, offset)
+		}
+		size := binary.BigEndian.Uint32(header[:4])
+		if size > maxWALRecordSize {
+			return fmt.Errorf("%w: invalid record size %d at offset %d", errWALCorrupted, size, offset)
+		}
+		payload := make([]byte, size)
+		if _, err = io.ReadFull(r, payload); err != nil {
+			return fmt.Errorf("%w: torn record at offset %d", errWALCorrupted, offset)
+		}
+		if crc32.Checksum(payload, walCastagnoli) != binary.BigEndian.Uint32(header[4:]) {
+			return fmt.Errorf("%w: checksum mismatch at offset %d", errWALCorrupted, offset)
+		}
+		var s pushv1.RawProfileSeries
+		if err = s.UnmarshalVT(payload); err != nil {
+			return fmt.Errorf("%w: %v at offset %d", errWALCorrupted, err, offset)
+		}
+		if err = fn(&s); err != nil {
+			return err
+		}
+		offset += walRecordHeaderSize + int(size)
+	}
+}
+
+// HasWAL reports whether there are write-ahead logs to replay in the
+// data path.
+func HasWAL(dataPath string) bool {
+	return len(walHeadDirs(dataPath)) > 0
+}
+
+func walHeadDirs(dataPath string) []string {
+	headPath := filepath.Join(dataPath, pathHead)
+	entries, err := os.ReadDir(headPath)
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err = os.Stat(filepath.Join(headPath, e.Name(), walFilename)); err == nil {
+			dirs = append(dirs, filepath.Join(headPath, e.Name()))
+		}
+	}
+	return dirs
+}
+
+// replayWAL ingests the profiles of the write-ahead logs of the heads that
+// were not flushed before the process stopped, and removes those heads.
+func (f *PhlareDB) replayWAL(ctx context.Context) error {
+	// The heads the profiles are replayed to are created in the same
+	// directory: the former heads are listed beforehand.
+	dirs := walHeadDirs(f.cfg.DataPath)
+	if len(dirs) == 0 {
+		return nil
+	}
+	start := time.Now()
+	var profiles, failed int
+	for _, dir := range dirs {
+		err := replayWAL(filepath.Join(dir, walFilename), func(s *pushv1.RawProfileSeries) error {
+			for _, sample := range s.Samples {
+				var p profilev1.Profile
+				if err := p.UnmarshalVT(sample.RawProfile); err != nil {
+					return fmt.Errorf("%w: %v", errWALCorrupted, err)
+				}
+				id, err := uuid.Parse(sample.ID)
+				if err != nil {
+					return fmt.Errorf("%w: %v", errWALCorrupted, err)
+				}
+				// The profiles rejected at ingestion were logged too.
+				if err = f.Ingest(ctx, &p, id, s.Annotations, s.Labels...); err != nil {
+					failed++
+					continue
+				}
+				profiles++
+			}
+			return nil
+		})
+		if errors.Is(err, errWALCorrupted) {
+			f.metrics.walCorruptedSegments.Inc()
+			level.Warn(f.logger).Log("msg", "write-ahead log replayed partially", "path", dir, "err", err)
+		} else if err != nil {
+			return errors.Wrapf(err, "replay write-ahead log %s", dir)
+		}
+		if err = os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	f.metrics.walReplayDuration.Observe(time.Since(start).Seconds())
+	f.metrics.walReplayedProfiles.Add(float64(profiles))
+	level.Info(f.logger).Log(
+		"msg", "write-ahead log replayed",
+		"heads", len(dirs),
+		"profiles", profiles,
+		"failed", failed,
+		"duration", time.Since(start),
+	)
+	return nil
+}
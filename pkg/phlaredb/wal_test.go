@@ -0,0 +1,95 @@
+package phlaredb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+func Test_WAL_Replay(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir)
+	require.NoError(t, err)
+	ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for i, id := range ids {
+		p := &profilev1.Profile{TimeNanos: int64(i)}
+		require.NoError(t, w.append(p, id, nil, []*typesv1.LabelPair{{Name: "service_name", Value: "svc"}}))
+	}
+	require.NoError(t, w.close())
+
+	var replayed []string
+	replay := func(s *pushv1.RawProfileSeries) error {
+		assert.Equal(t, "svc", s.Labels[0].Value)
+		replayed = append(replayed, s.Samples[0].ID)
+		return nil
+	}
+	path := filepath.Join(dir, walFilename)
+	require.NoError(t, replayWAL(path, replay))
+	assert.Equal(t, []string{ids[0].String(), ids[1].String(), ids[2].String()}, replayed)
+
+	// The last record is torn.
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-1))
+	replayed = nil
+	require.ErrorIs(t, replayWAL(path, replay), errWALCorrupted)
+	assert.Equal(t, []string{ids[0].String(), ids[1].String()}, replayed)
+}
+
+func Test_PhlareDB_ReplayWAL(t *testing.T) {
+	ctx := testContext(t)
+	db, err := New(ctx, Config{
+		DataPath:         ctx.dataDir,
+		MaxBlockDuration: time.Hour,
+		WALEnabled:       true,
+	}, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+	ingestProfiles(t, db, cpuProfileGenerator, 0, int64(5*time.Second), time.Second)
+	require.Len(t, db.heads, 1)
+	var totalProfiles int64
+	for _, h := range db.heads {
+		totalProfiles = h.profiles.index.totalProfiles.Load()
+	}
+	require.Positive(t, totalProfiles)
+
+	// Copy the write-ahead log of the head to another data path, as if the
+	// process had stopped before the head was flushed.
+	wals, err := filepath.Glob(filepath.Join(ctx.dataDir, pathHead, "*", walFilename))
+	require.NoError(t, err)
+	require.Len(t, wals, 1)
+	crashed := testContext(t)
+	crashedHead := filepath.Join(crashed.dataDir, pathHead, filepath.Base(filepath.Dir(wals[0])))
+	require.NoError(t, os.MkdirAll(crashedHead, 0o755))
+	data, err := os.ReadFile(wals[0])
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(crashedHead, walFilename), data, 0o644))
+
+	// The head is flushed and moved to the local blocks on close.
+	require.NoError(t, db.Close())
+	blocks, err := os.ReadDir(filepath.Join(ctx.dataDir, PathLocal))
+	require.NoError(t, err)
+	assert.Len(t, blocks, 1)
+
+	replayed, err := New(crashed, Config{
+		DataPath:         crashed.dataDir,
+		MaxBlockDuration: time.Hour,
+		WALEnabled:       true,
+	}, NoLimit, crashed.localBucketClient)
+	require.NoError(t, err)
+	require.Len(t, replayed.heads, 1)
+	for _, h := range replayed.heads {
+		assert.Equal(t, totalProfiles, h.profiles.index.totalProfiles.Load())
+	}
+	_, err = os.Stat(crashedHead)
+	assert.True(t, os.IsNotExist(err), "the replayed head is removed")
+	require.NoError(t, replayed.Close())
+}
@@ -85,6 +85,17 @@ func RawFromProto(pbp *profilev1.Profile) *Profile {
 }
 
 func RawFromBytes(input []byte) (_ *Profile, err error) {
+	return RawFromBytesWithLimit(input, -1)
+}
+
+// ErrDecompressedSizeLimitExceeded is returned when the decompressed size
+// of a profile exceeds the limit.
+var ErrDecompressedSizeLimitExceeded = errors.New("decompressed profile exceeds the size limit")
+
+// RawFromBytesWithLimit is like RawFromBytes, but it stops decompressing
+// the profile as soon as its size exceeds maxSize bytes, which protects
+// from decompression bombs. A negative maxSize disables the limit.
+func RawFromBytesWithLimit(input []byte, maxSize int64) (_ *Profile, err error) {
 	gzipReader := gzipReaderPool.Get().(*gzipReader)
 	buf := bufPool.Get().(*bytes.Buffer)
 	defer func() {
@@ -98,9 +109,15 @@ func RawFromBytes(input []byte) (_ *Profile, err error) {
 		return nil, err
 	}
 
+	if maxSize >= 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
 	if _, err = io.Copy(buf, r); err != nil {
 		return nil, errors.Wrap(err, "copy to buffer")
 	}
+	if maxSize >= 0 && int64(buf.Len()) > maxSize {
+		return nil, ErrDecompressedSizeLimitExceeded
+	}
 
 	rawSize := buf.Len()
 	pbp := new(profilev1.Profile)
@@ -413,9 +430,46 @@ func (p *Profile) Normalize() {
 	// Remove references to removed samples.
 	p.clearSampleReferences(removedSamples)
 	sanitizeProfile(p.Profile, &p.stats)
+	p.markSymbolizedMappings()
 	p.clearAddresses()
 }
 
+// markSymbolizedMappings sets the flags of the mappings the locations of
+// which all have line info, for the profiles symbolized by the agents not
+// setting them, e.g. the agents of the interpreted languages. Otherwise,
+// such profiles are considered unsymbolized. The flags are never cleared.
+func (p *Profile) markSymbolizedMappings() {
+	type mappingLines struct {
+		locations     int
+		noLines       bool
+		noFilenames   bool
+		noLineNumbers bool
+	}
+	mappings := make([]mappingLines, len(p.Mapping))
+	for _, l := range p.Location {
+		m := &mappings[l.MappingId-1]
+		m.locations++
+		if len(l.Line) == 0 {
+			m.noLines = true
+		}
+		for _, line := range l.Line {
+			if p.Function[line.FunctionId-1].Filename == 0 {
+				m.noFilenames = true
+			}
+			if line.Line <= 0 {
+				m.noLineNumbers = true
+			}
+		}
+	}
+	for i, m := range p.Mapping {
+		if s := mappings[i]; s.locations > 0 && !s.noLines {
+			m.HasFunctions = true
+			m.HasFilenames = m.HasFilenames || !s.noFilenames
+			m.HasLineNumbers = m.HasLineNumbers || !s.noLineNumbers
+		}
+	}
+}
+
 // Removes addresses from symbolized profiles.
 func (p *Profile) clearAddresses() {
 	for _, m := range p.Mapping {
@@ -966,6 +1020,9 @@ var uint32SlicePool zeropool.Pool[[]uint32]
 const (
 	ProfileIDLabelName = "profile_id" // For compatibility with the existing clients.
 	SpanIDLabelName    = "span_id"    // Will be supported in the future.
+	// TraceIDLabelName can be removed from the samples in distributors:
+	// span profiles are selected by the span IDs of the trace.
+	TraceIDLabelName = "trace_id"
 )
 
 func LabelID(p *profilev1.Profile, name string) int64 {
@@ -1043,6 +1100,19 @@ func RenameLabel(p *profilev1.Profile, oldName, newName string) {
 	}
 }
 
+// RemoveLabel removes the sample labels with the given name.
+func RemoveLabel(p *profilev1.Profile, name string) {
+	k := LabelID(p, name)
+	if k <= 0 {
+		return
+	}
+	for _, s := range p.Sample {
+		s.Label = slices.RemoveInPlace(s.Label, func(l *profilev1.Label, _ int) bool {
+			return l.Key == k
+		})
+	}
+}
+
 func ZeroLabelStrings(p *profilev1.Profile) {
 	// TODO: A true bitmap should be used instead.
 	st := slices.GrowLen(uint32SlicePool.Get(), len(p.StringTable))
@@ -77,8 +77,10 @@ func TestNormalizeProfile(t *testing.T) {
 			{LocationId: []uint64{1, 2}, Value: []int64{0, 1}, Label: []*profilev1.Label{}},
 		},
 		Mapping: []*profilev1.Mapping{{
-			Id:           1,
-			HasFunctions: true,
+			Id:             1,
+			HasFunctions:   true,
+			HasFilenames:   true,
+			HasLineNumbers: true,
 		}},
 		Location: []*profilev1.Location{
 			{Id: 1, MappingId: 1, Line: []*profilev1.Line{{FunctionId: 1, Line: 1}, {FunctionId: 2, Line: 3}}},
@@ -104,6 +106,50 @@ func TestNormalizeProfile(t *testing.T) {
 	}, pf.Profile)
 }
 
+func TestNormalizeProfile_SymbolizedMappings(t *testing.T) {
+	p := &profilev1.Profile{
+		SampleType: []*profilev1.ValueType{{Type: 1, Unit: 2}},
+		Sample: []*profilev1.Sample{
+			{LocationId: []uint64{1, 2}, Value: []int64{1}},
+			{LocationId: []uint64{3, 4}, Value: []int64{1}},
+		},
+		Mapping: []*profilev1.Mapping{
+			{Id: 1, Filename: 3},
+			{Id: 2, Filename: 4},
+		},
+		Location: []*profilev1.Location{
+			// Symbolized by the agent, without mapping.
+			{Id: 1, Line: []*profilev1.Line{{FunctionId: 1, Line: 10}}},
+			{Id: 2, Line: []*profilev1.Line{{FunctionId: 2, Line: 20}}},
+			// Symbolized, without file names.
+			{Id: 3, MappingId: 1, Address: 0x10, Line: []*profilev1.Line{{FunctionId: 3, Line: 5}}},
+			// Not symbolized.
+			{Id: 4, MappingId: 2, Address: 0x20},
+		},
+		Function: []*profilev1.Function{
+			{Id: 1, Name: 5, Filename: 6},
+			{Id: 2, Name: 7, Filename: 6},
+			{Id: 3, Name: 8},
+		},
+		StringTable: []string{"", "cpu", "nanoseconds", "libfoo.so", "libbar.so", "main", "main.py", "run", "foo"},
+		TimeNanos:   1,
+	}
+
+	pf := &Profile{Profile: p}
+	pf.Normalize()
+	require.Len(t, pf.Mapping, 3)
+	require.Equal(t, &profilev1.Mapping{Id: 1, Filename: 3, HasFunctions: true, HasLineNumbers: true}, pf.Mapping[0])
+	require.Equal(t, &profilev1.Mapping{Id: 2, Filename: 4}, pf.Mapping[1])
+	require.Equal(t, &profilev1.Mapping{Id: 3, HasFunctions: true, HasFilenames: true, HasLineNumbers: true}, pf.Mapping[2])
+	for _, l := range pf.Location {
+		if l.MappingId == 2 {
+			require.Equal(t, uint64(0x20), l.Address, "the addresses of the unsymbolized locations are kept")
+		} else {
+			require.Zero(t, l.Address)
+		}
+	}
+}
+
 func TestNormalizeProfile_NegativeSample(t *testing.T) {
 	currentTime = func() time.Time {
 		t, _ := time.Parse(time.RFC3339, "2020-01-01T00:00:00Z")
@@ -551,6 +597,26 @@ func TestFromProfile(t *testing.T) {
 	require.Equal(t, testhelper.FooBarProfile, outProfile)
 }
 
+func TestRawFromBytesWithLimit(t *testing.T) {
+	p, err := FromProfile(testhelper.FooBarProfile)
+	require.NoError(t, err)
+	for _, compress := range []bool{true, false} {
+		data, err := Marshal(p, compress)
+		require.NoError(t, err)
+		size := int64(p.SizeVT())
+
+		raw, err := RawFromBytesWithLimit(data, size)
+		require.NoError(t, err)
+		require.Equal(t, int(size), raw.rawSize)
+
+		_, err = RawFromBytesWithLimit(data, size-1)
+		require.ErrorIs(t, err, ErrDecompressedSizeLimitExceeded)
+
+		_, err = RawFromBytesWithLimit(data, -1)
+		require.NoError(t, err)
+	}
+}
+
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
 func RandStringBytes(n int) string {
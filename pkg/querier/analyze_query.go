@@ -128,7 +128,9 @@ func (q *Querier) getQueriedSeriesCount(ctx context.Context, req *querierv1.Anal
 	if err != nil {
 		return 0, err
 	}
-	if !q.limits.QueryAnalysisSeriesEnabled(tenantId) {
+	// The series are counted for the series limit even if the series
+	// analysis is disabled, otherwise the limit would never be exceeded.
+	if !q.limits.QueryAnalysisSeriesEnabled(tenantId) && q.limits.MaxQuerySeries(tenantId) <= 0 {
 		return 0, nil
 	}
 	matchers, err := createMatchersFromQuery(req.Query)
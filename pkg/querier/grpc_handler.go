@@ -3,8 +3,11 @@ package querier
 import (
 	"net/http"
 
+	"connectrpc.com/connect"
+
 	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
 	connectapi "github.com/grafana/pyroscope/pkg/api/connect"
+	"github.com/grafana/pyroscope/pkg/querier/partial"
 	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
 	httputil "github.com/grafana/pyroscope/pkg/util/http"
 )
@@ -15,7 +18,8 @@ type QuerierSvc interface {
 
 func NewGRPCHandler(svc QuerierSvc, useK6Middleware bool) connectgrpc.GRPCHandler {
 	mux := http.NewServeMux()
-	mux.Handle(querierv1connect.NewQuerierServiceHandler(svc, connectapi.DefaultHandlerOptions()...))
+	opts := append(connectapi.DefaultHandlerOptions(), connect.WithInterceptors(partial.UnaryInterceptor()))
+	mux.Handle(querierv1connect.NewQuerierServiceHandler(svc, opts...))
 
 	if useK6Middleware {
 		httpMiddleware := httputil.K6Middleware()
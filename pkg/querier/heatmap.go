@@ -0,0 +1,218 @@
+package querier
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/querier/timeline"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+const (
+	defaultHeatmapBuckets = 20
+	maxHeatmapBuckets     = 200
+	// maxHeatmapSteps limits the size of the heatmap, when the step is
+	// small compared to the time range.
+	maxHeatmapSteps = 11000
+)
+
+// HeatmapResponse is the distribution of the values of the series over
+// time: each cell counts the points of the series in the time step whose
+// value is in the bucket.
+type HeatmapResponse struct {
+	// Timestamps are the start of the time steps in milliseconds.
+	Timestamps []int64 `json:"timestamps"`
+	// Buckets are the upper bounds of the value buckets. The lower bound
+	// of the first bucket is Min.
+	Buckets []float64 `json:"buckets"`
+	Min     float64   `json:"min"`
+	// Counts are the counts of the points per time step and bucket:
+	// Counts[i][j] is the number of points in the time step i with
+	// a value in the bucket j.
+	Counts [][]int64 `json:"counts"`
+	// Series is the number of series in the heatmap.
+	Series int `json:"series"`
+}
+
+// Heatmap returns the heatmap of the series matching the query, e.g., to
+// show the spread of the CPU usage of the instances of a service over time.
+// For example, /pyroscope/heatmap?query=...&from=now-1h&until=now&step=15s&buckets=20&scale=log.
+//
+// Each point of a series is the value of the profiles in the time step:
+// with a step close to the profiling interval, every point is a single
+// profile, and the heatmap has the resolution of the ingested profiles.
+func (q *QueryHandlers) Heatmap(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	selectParams, _, err := parseSelectProfilesRequest(renderRequestFieldNames{}, req)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	params, err := parseHeatmapParams(req, selectParams.Start, selectParams.End)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+
+	// The series are not merged: the points are grouped by all the labels
+	// of the series.
+	series, err := q.client.Series(req.Context(), connect.NewRequest(&querierv1.SeriesRequest{
+		Matchers: []string{selectParams.LabelSelector},
+		Start:    selectParams.Start,
+		End:      selectParams.End,
+	}))
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	resp, err := q.client.SelectSeries(req.Context(), connect.NewRequest(&querierv1.SelectSeriesRequest{
+		ProfileTypeID: selectParams.ProfileTypeID,
+		LabelSelector: selectParams.LabelSelector,
+		Start:         selectParams.Start,
+		End:           selectParams.End,
+		Step:          params.step.Seconds(),
+		GroupBy:       seriesLabelNames(series.Msg.LabelsSet),
+	}))
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+
+	res := newHeatmap(resp.Msg.Series, selectParams.Start, selectParams.End, params)
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(res); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+}
+
+type heatmapParams struct {
+	step    time.Duration
+	buckets int
+	log     bool
+}
+
+func parseHeatmapParams(req *http.Request, start, end int64) (heatmapParams, error) {
+	p := heatmapParams{buckets: defaultHeatmapBuckets}
+	if v := req.Form.Get("step"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return p, fmt.Errorf("invalid step %q", v)
+		}
+		p.step = d
+	} else {
+		p.step = time.Duration(timeline.CalcPointInterval(start, end) * float64(time.Second))
+	}
+	if steps := time.Duration(end-start) * time.Millisecond / p.step; steps > maxHeatmapSteps {
+		return p, fmt.Errorf("the step is too small for the time range: %d steps, the maximum is %d", steps, maxHeatmapSteps)
+	}
+	if v := req.Form.Get("buckets"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxHeatmapBuckets {
+			return p, fmt.Errorf("invalid number of buckets %q: must be between 1 and %d", v, maxHeatmapBuckets)
+		}
+		p.buckets = n
+	}
+	switch scale := req.Form.Get("scale"); scale {
+	case "", "linear":
+	case "log":
+		p.log = true
+	default:
+		return p, fmt.Errorf("invalid scale %q: must be linear or log", scale)
+	}
+	return p, nil
+}
+
+// seriesLabelNames returns the names of the labels distinguishing the
+// series, without the profile type labels.
+func seriesLabelNames(labelsSet []*typesv1.Labels) []string {
+	var names []string
+	for _, ls := range labelsSet {
+		for _, l := range ls.Labels {
+			if phlaremodel.IsLabelAllowedForIngestion(l.Name) && !slices.Contains(names, l.Name) {
+				names = append(names, l.Name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func newHeatmap(series []*typesv1.Series, start, end int64, p heatmapParams) *HeatmapResponse {
+	stepMs := max(p.step.Milliseconds(), 1)
+	steps := int((end-start)/stepMs) + 1
+	res := &HeatmapResponse{
+		Timestamps: make([]int64, steps),
+		Counts:     make([][]int64, steps),
+		Series:     len(series),
+	}
+	for i := range res.Timestamps {
+		res.Timestamps[i] = start + int64(i)*stepMs
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, pt := range s.Points {
+			lo = min(lo, pt.Value)
+			hi = max(hi, pt.Value)
+		}
+	}
+	if math.IsInf(lo, 1) {
+		// No points.
+		return res
+	}
+	res.Min = lo
+	res.Buckets = heatmapBuckets(lo, hi, p.buckets, p.log)
+
+	for i := range res.Counts {
+		res.Counts[i] = make([]int64, len(res.Buckets))
+	}
+	for _, s := range series {
+		for _, pt := range s.Points {
+			i := int((pt.Timestamp - start) / stepMs)
+			if i < 0 || i >= steps {
+				continue
+			}
+			j, _ := slices.BinarySearch(res.Buckets, pt.Value)
+			res.Counts[i][min(j, len(res.Buckets)-1)]++
+		}
+	}
+	return res
+}
+
+// heatmapBuckets returns the upper bounds of n buckets between lo and hi.
+// The buckets grow exponentially with the log scale, if the values are
+// positive.
+func heatmapBuckets(lo, hi float64, n int, log bool) []float64 {
+	if lo == hi {
+		return []float64{hi}
+	}
+	buckets := make([]float64, n)
+	if log && lo > 0 {
+		factor := math.Pow(hi/lo, 1/float64(n))
+		for i := range buckets {
+			buckets[i] = lo * math.Pow(factor, float64(i+1))
+		}
+	} else {
+		width := (hi - lo) / float64(n)
+		for i := range buckets {
+			buckets[i] = lo + width*float64(i+1)
+		}
+	}
+	// Avoid rounding errors in the upper bound.
+	buckets[n-1] = hi
+	return buckets
+}
@@ -0,0 +1,78 @@
+package querier
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+func Test_newHeatmap(t *testing.T) {
+	series := []*typesv1.Series{
+		{Points: []*typesv1.Point{{Timestamp: 0, Value: 1}, {Timestamp: 10_000, Value: 10}}},
+		{Points: []*typesv1.Point{{Timestamp: 5_000, Value: 4}, {Timestamp: 15_000, Value: 6}}},
+		{Points: []*typesv1.Point{{Timestamp: 19_000, Value: 10}, {Timestamp: 30_000, Value: 10}}},
+	}
+	res := newHeatmap(series, 0, 20_000, heatmapParams{step: 10 * time.Second, buckets: 3})
+	assert.Equal(t, []int64{0, 10_000, 20_000}, res.Timestamps)
+	assert.Equal(t, 1.0, res.Min)
+	assert.Equal(t, []float64{4, 7, 10}, res.Buckets)
+	assert.Equal(t, [][]int64{
+		{2, 0, 0},
+		{0, 1, 2},
+		{0, 0, 0},
+	}, res.Counts)
+	assert.Equal(t, 3, res.Series)
+}
+
+func Test_newHeatmap_NoPoints(t *testing.T) {
+	res := newHeatmap(nil, 0, 20_000, heatmapParams{step: 10 * time.Second, buckets: 3})
+	assert.Len(t, res.Timestamps, 3)
+	assert.Empty(t, res.Buckets)
+}
+
+func Test_heatmapBuckets(t *testing.T) {
+	assert.InDeltaSlice(t, []float64{10, 100, 1000}, heatmapBuckets(1, 1000, 3, true), 1e-9)
+	assert.Equal(t, []float64{5}, heatmapBuckets(5, 5, 3, false))
+	// The log scale requires positive values.
+	assert.Equal(t, []float64{0, 5}, heatmapBuckets(-5, 5, 2, true))
+}
+
+func Test_parseHeatmapParams(t *testing.T) {
+	parse := func(v url.Values) (heatmapParams, error) {
+		req := &http.Request{Form: v}
+		return parseHeatmapParams(req, 0, time.Hour.Milliseconds())
+	}
+	p, err := parse(url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, defaultHeatmapBuckets, p.buckets)
+	assert.Positive(t, p.step)
+
+	p, err = parse(url.Values{"step": {"15s"}, "buckets": {"50"}, "scale": {"log"}})
+	require.NoError(t, err)
+	assert.Equal(t, heatmapParams{step: 15 * time.Second, buckets: 50, log: true}, p)
+
+	for _, v := range []url.Values{
+		{"step": {"0s"}},
+		{"step": {"100ms"}},
+		{"buckets": {"1000"}},
+		{"scale": {"sqrt"}},
+	} {
+		_, err = parse(v)
+		assert.Error(t, err, v)
+	}
+}
+
+func Test_seriesLabelNames(t *testing.T) {
+	names := seriesLabelNames([]*typesv1.Labels{
+		{Labels: phlaremodel.LabelsFromStrings("service_name", "a", "pod", "a-1", "__name__", "cpu")},
+		{Labels: phlaremodel.LabelsFromStrings("service_name", "b", "__session_id__", "x")},
+	})
+	assert.Equal(t, []string{"__session_id__", "pod", "service_name"}, names)
+}
@@ -1,11 +1,13 @@
 package querier
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -25,32 +27,55 @@ import (
 	"github.com/grafana/pyroscope/pkg/frontend/dot/graph"
 	"github.com/grafana/pyroscope/pkg/frontend/dot/report"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/convert/speedscope"
 	"github.com/grafana/pyroscope/pkg/og/structs/flamebearer"
 	"github.com/grafana/pyroscope/pkg/og/util/attime"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/querier/timeline"
+	"github.com/grafana/pyroscope/pkg/settings/annotations"
 	httputil "github.com/grafana/pyroscope/pkg/util/http"
 )
 
-func NewHTTPHandlers(client querierv1connect.QuerierServiceClient) *QueryHandlers {
-	return &QueryHandlers{client}
+// AnnotationLister lists the annotations of the tenant, returned with the
+// timelines of the render API.
+type AnnotationLister interface {
+	List(ctx context.Context, start, end int64) ([]annotations.Annotation, error)
+}
+
+// NewHTTPHandlers returns the handlers of the Pyroscope HTTP API. The
+// annotations are optional.
+func NewHTTPHandlers(client querierv1connect.QuerierServiceClient, annotations AnnotationLister) *QueryHandlers {
+	return &QueryHandlers{client: client, annotations: annotations}
 }
 
 type QueryHandlers struct {
-	client querierv1connect.QuerierServiceClient
+	client      querierv1connect.QuerierServiceClient
+	annotations AnnotationLister
 }
 
 // LabelValues only returns the label values for the given label name.
 // This is mostly for fulfilling the pyroscope API and won't be used in the future.
 // For example, /label-values?label=__name__ will return all the profile types.
+// The values can be narrowed down with match[] selectors and the from and
+// until parameters, e.g. /label-values?label=pod&match[]={namespace=~"prod-.*"}.
 func (q *QueryHandlers) LabelValues(w http.ResponseWriter, req *http.Request) {
-	label := req.URL.Query().Get("label")
+	if err := req.ParseForm(); err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	label := req.Form.Get("label")
 	if label == "" {
 		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, errors.New("label parameter is required")))
 		return
 	}
+	matchers, err := parseMatchers(req.Form)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
 	var res []string
 
-	if label == "__name__" {
+	if label == "__name__" && len(matchers) == 0 {
 		response, err := q.client.ProfileTypes(req.Context(), connect.NewRequest(&querierv1.ProfileTypesRequest{}))
 		if err != nil {
 			httputil.Error(w, err)
@@ -60,7 +85,13 @@ func (q *QueryHandlers) LabelValues(w http.ResponseWriter, req *http.Request) {
 			res = append(res, t.ID)
 		}
 	} else {
-		response, err := q.client.LabelValues(req.Context(), connect.NewRequest(&typesv1.LabelValuesRequest{}))
+		start, end := parseTimeRange(req.Form)
+		response, err := q.client.LabelValues(req.Context(), connect.NewRequest(&typesv1.LabelValuesRequest{
+			Name:     label,
+			Matchers: matchers,
+			Start:    start,
+			End:      end,
+		}))
 		if err != nil {
 			httputil.Error(w, err)
 			return
@@ -128,6 +159,15 @@ func (q *QueryHandlers) Render(w http.ResponseWriter, req *http.Request) {
 		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
 		return
 	}
+	exprReq, err := parseQueryExprRequest(req)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	if exprReq != nil {
+		q.renderQueryExpr(w, req, exprReq)
+		return
+	}
 	selectParams, profileType, err := parseSelectProfilesRequest(renderRequestFieldNames{}, req)
 	if err != nil {
 		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
@@ -135,7 +175,10 @@ func (q *QueryHandlers) Render(w http.ResponseWriter, req *http.Request) {
 	}
 
 	groupBy := req.URL.Query()["groupBy"]
-	var aggregation typesv1.TimeSeriesAggregationType
+	// The timeline aggregation defaults to the one of the profile type, for
+	// example, the average of the memory in use.
+	def := phlaremodel.ProfileTypes.Lookup(profileType)
+	aggregation := def.TimeSeriesAggregation()
 	if req.URL.Query().Has("aggregation") {
 		aggregationParam := req.URL.Query().Get("aggregation")
 		switch aggregationParam {
@@ -146,6 +189,14 @@ func (q *QueryHandlers) Render(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	// The series of the replicas are summed up by default, which inflates
+	// the gauges, like the memory in use, by the number of the replicas.
+	seriesAggregation, err := phlaremodel.ParseSeriesAggregation(req.URL.Query().Get("seriesAggregation"))
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+
 	format := req.URL.Query().Get("format")
 	if format == "dot" {
 		// We probably should distinguish max nodes of the source pprof
@@ -177,6 +228,11 @@ func (q *QueryHandlers) Render(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if seriesAggregation != phlaremodel.SeriesAggregationSum {
+		q.renderAggregatedReplicas(w, req, selectParams, profileType, groupBy, aggregation, seriesAggregation)
+		return
+	}
+
 	var resFlame *connect.Response[querierv1.SelectMergeStacktracesResponse]
 	g, gCtx := errgroup.WithContext(req.Context())
 	selectParamsClone := selectParams.CloneVT()
@@ -204,6 +260,15 @@ func (q *QueryHandlers) Render(w http.ResponseWriter, req *http.Request) {
 		return err
 	})
 
+	var resAnnotations []annotations.Annotation
+	if q.annotations != nil {
+		g.Go(func() error {
+			var err error
+			resAnnotations, err = q.listAnnotations(gCtx, selectParams)
+			return err
+		})
+	}
+
 	err = g.Wait()
 	if err != nil {
 		httputil.Error(w, err)
@@ -215,7 +280,9 @@ func (q *QueryHandlers) Render(w http.ResponseWriter, req *http.Request) {
 		seriesVal = resSeries.Msg.Series[0]
 	}
 
-	fb := phlaremodel.ExportToFlamebearer(resFlame.Msg.Flamegraph, profileType)
+	stats.CopyHeaders(w.Header(), resFlame.Header())
+	flame := resFlame.Msg.Flamegraph
+	fb := phlaremodel.ExportToFlamebearer(&querierv1.FlameGraph{Total: flame.GetTotal(), MaxSelf: flame.GetMaxSelf()}, profileType)
 	fb.Timeline = timeline.New(seriesVal, selectParams.Start, selectParams.End, int64(timelineStep))
 
 	if len(groupBy) > 0 {
@@ -234,12 +301,132 @@ func (q *QueryHandlers) Render(w http.ResponseWriter, req *http.Request) {
 	}
 
 	w.Header().Add("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(fb); err != nil {
+	res := renderResponse{
+		FlamebearerProfile: fb,
+		Annotations:        resAnnotations,
+	}
+	if nodes, value, ok := stats.TruncationFromHeaders(resFlame.Header()); ok {
+		res.Truncation = &renderTruncation{PrunedNodes: nodes, OtherValue: value}
+	}
+	if err := writeRenderResponse(w, flame, res); err != nil {
 		httputil.Error(w, err)
 		return
 	}
 }
 
+type renderResponse struct {
+	*flamebearer.FlamebearerProfile
+	Annotations []annotations.Annotation `json:"annotations,omitempty"`
+	Truncation  *renderTruncation        `json:"truncation,omitempty"`
+}
+
+// renderTruncation describes the nodes pruned from the flame graph
+// because of the max nodes limit.
+type renderTruncation struct {
+	PrunedNodes int64 `json:"prunedNodes"`
+	OtherValue  int64 `json:"otherValue"`
+}
+
+// listAnnotations returns the annotations of the time range applying to the
+// services of the selector: the annotations of a service not matching the
+// service_name matchers are filtered out, the annotations without service
+// are always returned.
+func (q *QueryHandlers) listAnnotations(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) ([]annotations.Annotation, error) {
+	selector, err := parser.ParseMetricSelector(req.LabelSelector)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	list, err := q.annotations.List(ctx, req.Start, req.End)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(list, func(a annotations.Annotation) bool {
+		if a.ServiceName == "" {
+			return false
+		}
+		for _, m := range selector {
+			if m.Name == phlaremodel.LabelNameServiceName && !m.Matches(a.ServiceName) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+const (
+	exportFormatSpeedscope = "speedscope"
+	exportFormatCollapsed  = "collapsed"
+)
+
+// Export returns the merged profile for the query in a format suitable
+// for external tooling: speedscope JSON or collapsed (folded) stacks.
+// For example, /pyroscope/export?format=collapsed&query=...&from=now-1h&until=now.
+func (q *QueryHandlers) Export(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	format := req.Form.Get("format")
+	switch format {
+	case exportFormatSpeedscope, exportFormatCollapsed:
+	case "folded":
+		format = exportFormatCollapsed
+	case "":
+		format = exportFormatSpeedscope
+	default:
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unsupported export format %q", format)))
+		return
+	}
+	exprReq, err := parseQueryExprRequest(req)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	if exprReq != nil {
+		tree, err := q.evalQueryExprRequest(req.Context(), exprReq)
+		if err != nil {
+			httputil.Error(w, err)
+			return
+		}
+		if err = writeExport(w, format, tree, exprReq.profileType); err != nil {
+			httputil.Error(w, err)
+		}
+		return
+	}
+	selectParams, profileType, err := parseSelectProfilesRequest(renderRequestFieldNames{}, req)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	selectParams.Format = querierv1.ProfileFormat_PROFILE_FORMAT_TREE
+	resp, err := q.client.SelectMergeStacktraces(req.Context(), connect.NewRequest(selectParams))
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	tree, err := phlaremodel.UnmarshalTree(resp.Msg.Tree)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInternal, err))
+		return
+	}
+	stats.CopyHeaders(w.Header(), resp.Header())
+	if err = writeExport(w, format, tree, profileType); err != nil {
+		httputil.Error(w, err)
+	}
+}
+
+func writeExport(w http.ResponseWriter, format string, tree *phlaremodel.Tree, profileType *typesv1.ProfileType) error {
+	switch format {
+	case exportFormatCollapsed:
+		w.Header().Add("Content-Type", "text/plain")
+		tree.WriteCollapsed(w)
+		return nil
+	default:
+		w.Header().Add("Content-Type", "application/json")
+		return speedscope.WriteTree(w, tree, profileType.ID, speedscope.UnitFromSampleUnit(profileType.SampleUnit))
+	}
+}
+
 func pprofToDotProfile(w io.Writer, p *profilev1.Profile, maxNodes int) error {
 	data, err := p.MarshalVT()
 	if err != nil {
@@ -274,7 +461,23 @@ func parseSelectProfilesRequest(fieldNames renderRequestFieldNames, req *http.Re
 	if err != nil {
 		return nil, nil, err
 	}
+	// The heap parameter selects the memory in use or allocated, in the unit
+	// of the profile type of the query.
+	if view := req.Form.Get("heap"); view != "" {
+		if ptype, err = phlaremodel.HeapViewProfileType(ptype, view); err != nil {
+			return nil, nil, err
+		}
+	}
+	p := newSelectMergeStacktracesRequest(fieldNames, req)
+	p.LabelSelector = selector
+	p.ProfileTypeID = ptype.ID
+	return p, ptype, nil
+}
 
+// newSelectMergeStacktracesRequest creates a request with the time range
+// and max nodes of the HTTP request. The caller is expected to set
+// the profile type and the label selector.
+func newSelectMergeStacktracesRequest(fieldNames renderRequestFieldNames, req *http.Request) *querierv1.SelectMergeStacktracesRequest {
 	v := req.URL.Query()
 
 	// parse time using pyroscope's attime parser
@@ -282,10 +485,8 @@ func parseSelectProfilesRequest(fieldNames renderRequestFieldNames, req *http.Re
 	end := model.TimeFromUnixNano(attime.Parse(v.Get(fieldNames.until)).UnixNano())
 
 	p := &querierv1.SelectMergeStacktracesRequest{
-		Start:         int64(start),
-		End:           int64(end),
-		LabelSelector: selector,
-		ProfileTypeID: ptype.ID,
+		Start: int64(start),
+		End:   int64(end),
 	}
 
 	var mn int64
@@ -297,7 +498,7 @@ func parseSelectProfilesRequest(fieldNames renderRequestFieldNames, req *http.Re
 	}
 	p.MaxNodes = &mn
 
-	return p, ptype, nil
+	return p
 }
 
 func parseQuery(fieldName string, req *http.Request) (string, *typesv1.ProfileType, error) {
@@ -305,10 +506,23 @@ func parseQuery(fieldName string, req *http.Request) (string, *typesv1.ProfileTy
 	if q == "" {
 		return "", nil, fmt.Errorf("'%s' is required", fieldName)
 	}
+	selector, profileType, err := parseProfileSelector(q)
+	if err != nil {
+		return "", nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to parse '%s'", fieldName))
+	}
+	if profileType == nil {
+		return "", nil, status.Error(codes.InvalidArgument, fmt.Sprintf("'%s' must contain a profile-type selection", fieldName))
+	}
+	return selector, profileType, nil
+}
 
+// parseProfileSelector parses a series selector and splits it into the
+// label selector and the profile type. The profile type is nil if the
+// selector does not specify it.
+func parseProfileSelector(q string) (string, *typesv1.ProfileType, error) {
 	parsedSelector, err := parser.ParseMetricSelector(q)
 	if err != nil {
-		return "", nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to parse '%s'", fieldName))
+		return "", nil, err
 	}
 
 	sel := make([]*labels.Matcher, 0, len(parsedSelector))
@@ -321,12 +535,12 @@ func parseQuery(fieldName string, req *http.Request) (string, *typesv1.ProfileTy
 		}
 	}
 	if nameLabel == nil {
-		return "", nil, status.Error(codes.InvalidArgument, fmt.Sprintf("'%s' must contain a profile-type selection", fieldName))
+		return convertMatchersToString(sel), nil, nil
 	}
 
 	profileSelector, err := phlaremodel.ParseProfileTypeSelector(nameLabel.Value)
 	if err != nil {
-		return "", nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to parse '%s'", fieldName))
+		return "", nil, err
 	}
 	return convertMatchersToString(sel), profileSelector, nil
 }
@@ -40,3 +40,27 @@ func Test_ParseQuery(t *testing.T) {
 
 	require.Equal(t, `{foo="bar",bar=~"buzz"}`, queryRequest.LabelSelector)
 }
+
+func Test_withLabelMatchers(t *testing.T) {
+	selector, err := withLabelMatchers(`{service_name="foo"}`, []*typesv1.LabelPair{
+		{Name: "pod", Value: "foo-1"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, `{service_name="foo",pod="foo-1"}`, selector)
+}
+
+func Test_ParseQuery_HeapView(t *testing.T) {
+	q := url.Values{
+		"query": []string{`memory:alloc_space:bytes:space:bytes{foo="bar"}`},
+		"from":  []string{"now-6h"},
+		"heap":  []string{"inuse"},
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost/render/render?%s", q.Encode()), nil)
+	require.NoError(t, err)
+	require.NoError(t, req.ParseForm())
+
+	queryRequest, ptype, err := parseSelectProfilesRequest(renderRequestFieldNames{}, req)
+	require.NoError(t, err)
+	require.Equal(t, "memory:inuse_space:bytes:space:bytes", ptype.ID)
+	require.Equal(t, ptype.ID, queryRequest.ProfileTypeID)
+}
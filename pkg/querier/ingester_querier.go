@@ -2,10 +2,12 @@ package querier
 
 import (
 	"context"
+	"time"
 
 	"connectrpc.com/connect"
 	"github.com/grafana/dskit/ring"
 	ring_client "github.com/grafana/dskit/ring/client"
+	"github.com/grafana/dskit/tenant"
 	"github.com/opentracing/opentracing-go"
 	otlog "github.com/opentracing/opentracing-go/log"
 	"github.com/prometheus/prometheus/promql/parser"
@@ -35,17 +37,47 @@ type IngesterQueryClient interface {
 	GetBlockStats(ctx context.Context, req *connect.Request[ingestv1.GetBlockStatsRequest]) (*connect.Response[ingestv1.GetBlockStatsResponse], error)
 }
 
+type IngesterLimits interface {
+	IngestionTenantShardSize(tenantID string) int
+}
+
 // IngesterQuerier helps with querying the ingesters.
 type IngesterQuerier struct {
 	ring ring.ReadRing
 	pool *ring_client.Pool
+
+	// limits is nil if shuffle sharding is disabled on the read path.
+	limits IngesterLimits
+	// shardLookback is the period within which the ingesters that have
+	// joined the tenant shard might still hold data of the tenant.
+	shardLookback time.Duration
 }
 
-func NewIngesterQuerier(pool *ring_client.Pool, ring ring.ReadRing) *IngesterQuerier {
+func NewIngesterQuerier(pool *ring_client.Pool, ring ring.ReadRing, limits IngesterLimits, shardLookback time.Duration) *IngesterQuerier {
 	return &IngesterQuerier{
-		ring: ring,
-		pool: pool,
+		ring:          ring,
+		pool:          pool,
+		limits:        limits,
+		shardLookback: shardLookback,
+	}
+}
+
+// tenantRing returns the ring of the ingesters that may hold the data of
+// the tenant: if shuffle sharding is enabled, only the ingesters of the
+// tenant shard are queried.
+func (q *IngesterQuerier) tenantRing(ctx context.Context) (ring.ReadRing, error) {
+	if q.limits == nil {
+		return q.ring, nil
+	}
+	tenantID, err := tenant.TenantID(ctx)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	shardSize := q.limits.IngestionTenantShardSize(tenantID)
+	if shardSize <= 0 {
+		return q.ring, nil
 	}
+	return q.ring.ShuffleShardWithLookback(tenantID, shardSize, q.shardLookback, time.Now()), nil
 }
 
 // readNoExtend is a ring.Operation that only selects instances marked as ring.ACTIVE.
@@ -54,7 +86,11 @@ var readNoExtend = ring.NewOp([]ring.InstanceState{ring.ACTIVE}, nil)
 
 // forAllIngesters runs f, in parallel, for all ingesters
 func forAllIngesters[T any](ctx context.Context, ingesterQuerier *IngesterQuerier, f QueryReplicaFn[T, IngesterQueryClient]) ([]ResponseFromReplica[T], error) {
-	replicationSet, err := ingesterQuerier.ring.GetReplicationSetForOperation(readNoExtend)
+	r, err := ingesterQuerier.tenantRing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replicationSet, err := r.GetReplicationSetForOperation(readNoExtend)
 	if err != nil {
 		return nil, err
 	}
@@ -71,12 +107,16 @@ func forAllIngesters[T any](ctx context.Context, ingesterQuerier *IngesterQuerie
 
 // forAllPlannedIngesters runs f, in parallel, for all ingesters part of the plan
 func forAllPlannedIngesters[T any](ctx context.Context, ingesterQuerier *IngesterQuerier, plan blockPlan, f QueryReplicaWithHintsFn[T, IngesterQueryClient]) ([]ResponseFromReplica[T], error) {
-	replicationSet, err := ingesterQuerier.ring.GetReplicationSetForOperation(readNoExtend)
+	r, err := ingesterQuerier.tenantRing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replicationSet, err := r.GetReplicationSetForOperation(readNoExtend)
 	if err != nil {
 		return nil, err
 	}
 
-	return forGivenPlan(ctx, plan, func(addr string) (IngesterQueryClient, error) {
+	return forGivenPlan(ctx, "ingester", plan, func(addr string) (IngesterQueryClient, error) {
 		client, err := ingesterQuerier.pool.GetClientFor(addr)
 		if err != nil {
 			return nil, err
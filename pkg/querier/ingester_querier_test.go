@@ -0,0 +1,54 @@
+package querier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/dskit/ring"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/testhelper"
+)
+
+type shardSizeLimits map[string]int
+
+func (l shardSizeLimits) IngestionTenantShardSize(tenantID string) int { return l[tenantID] }
+
+type shuffleShardRing struct {
+	testhelper.MockRing
+	shard ring.ReadRing
+}
+
+func (r shuffleShardRing) ShuffleShardWithLookback(string, int, time.Duration, time.Time) ring.ReadRing {
+	return r.shard
+}
+
+func Test_IngesterQuerier_TenantRing(t *testing.T) {
+	shard := testhelper.NewMockRing([]ring.InstanceDesc{{Addr: "1"}}, 1)
+	r := shuffleShardRing{
+		MockRing: testhelper.NewMockRing([]ring.InstanceDesc{{Addr: "1"}, {Addr: "2"}, {Addr: "3"}}, 1),
+		shard:    shard,
+	}
+	limits := shardSizeLimits{"tenant-a": 1}
+	ctx := user.InjectOrgID(context.Background(), "tenant-a")
+
+	actual, err := NewIngesterQuerier(nil, r, limits, time.Hour).tenantRing(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, shard, actual)
+
+	// Shuffle sharding is disabled on the read path.
+	actual, err = NewIngesterQuerier(nil, r, nil, time.Hour).tenantRing(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, r, actual)
+
+	// The tenant shard size is 0.
+	actual, err = NewIngesterQuerier(nil, r, limits, time.Hour).tenantRing(user.InjectOrgID(context.Background(), "tenant-b"))
+	require.NoError(t, err)
+	assert.Equal(t, r, actual)
+
+	_, err = NewIngesterQuerier(nil, r, limits, time.Hour).tenantRing(context.Background())
+	require.Error(t, err)
+}
@@ -0,0 +1,172 @@
+package querier
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/og/util/attime"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+const (
+	defaultLabelCardinalityLimit = 20
+	defaultLabelsTimeRange       = "now-1h"
+)
+
+type LabelCardinality struct {
+	Name string `json:"name"`
+	// Values is the number of distinct values of the label.
+	Values int `json:"values"`
+	// Series is the number of series that have the label.
+	Series int `json:"series"`
+	// TopValues lists the values present in the largest number of series.
+	TopValues []LabelValueCardinality `json:"topValues,omitempty"`
+}
+
+type LabelValueCardinality struct {
+	Value  string `json:"value"`
+	Series int    `json:"series"`
+}
+
+type LabelCardinalityResponse struct {
+	Labels []LabelCardinality `json:"labels"`
+	// TotalSeries is the number of series matching the selectors.
+	TotalSeries int `json:"totalSeries"`
+}
+
+// parseMatchers returns the series selectors of the match[] parameters.
+// The selectors are validated: the queriers expect well-formed matchers.
+func parseMatchers(v url.Values) ([]string, error) {
+	matchers := v["match[]"]
+	for _, m := range matchers {
+		if _, err := parser.ParseMetricSelector(m); err != nil {
+			return nil, fmt.Errorf("invalid match[] %q: %w", m, err)
+		}
+	}
+	return matchers, nil
+}
+
+// parseTimeRange returns the time range of the from and until parameters
+// in milliseconds. If omitted, the range is the last hour.
+func parseTimeRange(v url.Values) (start, end int64) {
+	from := v.Get("from")
+	if from == "" {
+		from = defaultLabelsTimeRange
+	}
+	until := v.Get("until")
+	if until == "" {
+		until = "now"
+	}
+	start = int64(model.TimeFromUnixNano(attime.Parse(from).UnixNano()))
+	end = int64(model.TimeFromUnixNano(attime.Parse(until).UnixNano()))
+	return start, end
+}
+
+// LabelCardinality reports the labels with the highest number of distinct
+// values in the series matching the selectors, which helps to identify
+// misconfigured agents that produce too many series.
+// For example, /pyroscope/label-cardinality?match[]={service_name="a"}&from=now-1h&limit=10.
+func (q *QueryHandlers) LabelCardinality(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	matchers, err := parseMatchers(req.Form)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	limit := defaultLabelCardinalityLimit
+	if s := req.Form.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid limit %q", s)))
+			return
+		}
+	}
+	topValues := 0
+	if s := req.Form.Get("topValues"); s != "" {
+		if topValues, err = strconv.Atoi(s); err != nil || topValues < 0 {
+			httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid topValues %q", s)))
+			return
+		}
+	}
+
+	start, end := parseTimeRange(req.Form)
+	resp, err := q.client.Series(req.Context(), connect.NewRequest(&querierv1.SeriesRequest{
+		Matchers:   matchers,
+		LabelNames: req.Form["labelNames"],
+		Start:      start,
+		End:        end,
+	}))
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+
+	labels := labelCardinality(resp.Msg.LabelsSet, topValues)
+	res := LabelCardinalityResponse{
+		Labels:      labels[:min(limit, len(labels))],
+		TotalSeries: len(resp.Msg.LabelsSet),
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+}
+
+// labelCardinality counts the distinct values of every label of the series.
+// The labels are ordered by the number of values, in descending order.
+func labelCardinality(series []*typesv1.Labels, topValues int) []LabelCardinality {
+	values := make(map[string]map[string]int)
+	for _, s := range series {
+		for _, l := range s.Labels {
+			v, ok := values[l.Name]
+			if !ok {
+				v = make(map[string]int)
+				values[l.Name] = v
+			}
+			v[l.Value]++
+		}
+	}
+	labels := make([]LabelCardinality, 0, len(values))
+	for name, v := range values {
+		c := LabelCardinality{Name: name, Values: len(v)}
+		for _, n := range v {
+			c.Series += n
+		}
+		if topValues > 0 {
+			c.TopValues = make([]LabelValueCardinality, 0, len(v))
+			for value, n := range v {
+				c.TopValues = append(c.TopValues, LabelValueCardinality{Value: value, Series: n})
+			}
+			slices.SortFunc(c.TopValues, func(a, b LabelValueCardinality) int {
+				if c := cmp.Compare(b.Series, a.Series); c != 0 {
+					return c
+				}
+				return strings.Compare(a.Value, b.Value)
+			})
+			c.TopValues = c.TopValues[:min(topValues, len(c.TopValues))]
+		}
+		labels = append(labels, c)
+	}
+	slices.SortFunc(labels, func(a, b LabelCardinality) int {
+		if c := cmp.Compare(b.Values, a.Values); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return labels
+}
@@ -0,0 +1,39 @@
+package querier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+func Test_LabelCardinality(t *testing.T) {
+	series := []*typesv1.Labels{
+		{Labels: phlaremodel.LabelsFromStrings("service_name", "a", "pod", "a-1")},
+		{Labels: phlaremodel.LabelsFromStrings("service_name", "a", "pod", "a-2")},
+		{Labels: phlaremodel.LabelsFromStrings("service_name", "a", "pod", "a-3")},
+		{Labels: phlaremodel.LabelsFromStrings("service_name", "b", "pod", "b-1", "region", "eu")},
+	}
+
+	assert.Equal(t, []LabelCardinality{
+		{Name: "pod", Values: 4, Series: 4},
+		{Name: "service_name", Values: 2, Series: 4},
+		{Name: "region", Values: 1, Series: 1},
+	}, labelCardinality(series, 0))
+
+	assert.Equal(t, []LabelCardinality{
+		{Name: "pod", Values: 4, Series: 4, TopValues: []LabelValueCardinality{
+			{Value: "a-1", Series: 1},
+		}},
+		{Name: "service_name", Values: 2, Series: 4, TopValues: []LabelValueCardinality{
+			{Value: "a", Series: 3},
+		}},
+		{Name: "region", Values: 1, Series: 1, TopValues: []LabelValueCardinality{
+			{Value: "eu", Series: 1},
+		}},
+	}, labelCardinality(series, 1))
+
+	assert.Empty(t, labelCardinality(nil, 0))
+}
@@ -0,0 +1,196 @@
+// Package partial implements the partial results mode of the queries: the
+// query succeeds even if some of its sources, such as a store-gateway or an
+// ingester, fail, and the response reports which sources are missing.
+package partial
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"connectrpc.com/connect"
+
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+)
+
+const (
+	// HeaderPartialResults is the request header allowing the query to
+	// return partial results.
+	HeaderPartialResults = "X-Pyroscope-Partial-Results"
+	// HeaderMissingSources is the response header listing the sources
+	// missing from the results, separated by commas. The header has the
+	// prefix of the query statistics headers, therefore it is copied to the
+	// responses of the HTTP API.
+	HeaderMissingSources = stats.HeaderPrefix + "Missing-Sources"
+)
+
+// Enabled returns true if the request headers allow partial results.
+func Enabled(h http.Header) bool {
+	v, _ := strconv.ParseBool(h.Get(HeaderPartialResults))
+	return v
+}
+
+// Sources tracks the sources missing from the results of a query.
+type Sources struct {
+	mu      sync.Mutex
+	missing map[string]struct{}
+}
+
+type sourcesKey struct{}
+
+// WithSources returns a context allowing partial results, and the sources
+// of the query.
+func WithSources(ctx context.Context) (context.Context, *Sources) {
+	s := &Sources{missing: make(map[string]struct{})}
+	return context.WithValue(ctx, sourcesKey{}, s), s
+}
+
+// FromContext returns the sources of the query, or nil if the query does not
+// allow partial results.
+func FromContext(ctx context.Context) *Sources {
+	s, _ := ctx.Value(sourcesKey{}).(*Sources)
+	return s
+}
+
+// Tolerate records the source as missing and returns nil if the query allows
+// partial results and the error is caused by the source; the error is
+// returned otherwise.
+func Tolerate(ctx context.Context, source string, err error) error {
+	s := FromContext(ctx)
+	if s == nil || err == nil || ctx.Err() != nil || !isSourceError(err) {
+		return err
+	}
+	s.Add(source)
+	return nil
+}
+
+// isSourceError returns false for the errors caused by the request, which
+// any other source would return as well.
+func isSourceError(err error) bool {
+	switch connect.CodeOf(err) {
+	case connect.CodeInvalidArgument,
+		connect.CodeFailedPrecondition,
+		connect.CodeOutOfRange,
+		connect.CodeResourceExhausted,
+		connect.CodePermissionDenied,
+		connect.CodeUnauthenticated,
+		connect.CodeCanceled:
+		return false
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// Add records the sources as missing.
+func (s *Sources) Add(sources ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, source := range sources {
+		if source != "" {
+			s.missing[source] = struct{}{}
+		}
+	}
+}
+
+// AddFromHeader records the sources reported missing in the response
+// headers of a sub-query.
+func (s *Sources) AddFromHeader(h http.Header) {
+	for _, v := range h.Values(HeaderMissingSources) {
+		s.Add(strings.Split(v, ",")...)
+	}
+}
+
+// Missing returns the missing sources, sorted.
+func (s *Sources) Missing() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	missing := make([]string, 0, len(s.missing))
+	for source := range s.missing {
+		missing = append(missing, source)
+	}
+	slices.Sort(missing)
+	return missing
+}
+
+// SetHeader sets the missing sources header, if any source is missing.
+func (s *Sources) SetHeader(h http.Header) {
+	if missing := s.Missing(); len(missing) > 0 {
+		h.Set(HeaderMissingSources, strings.Join(missing, ","))
+	}
+}
+
+// UnaryInterceptor enables the partial results of the requests with the
+// partial results header, and reports the missing sources in the response
+// headers.
+func UnaryInterceptor() connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if req.Spec().IsClient || !Enabled(req.Header()) {
+				return next(ctx, req)
+			}
+			ctx, s := WithSources(ctx)
+			resp, err := next(ctx, req)
+			if err != nil {
+				return nil, withMissingSources(err, s)
+			}
+			s.SetHeader(resp.Header())
+			return resp, nil
+		}
+	}
+}
+
+// Middleware enables the partial results of the HTTP requests with the
+// partial results header, or the partialResults=true query parameter, and
+// reports the missing sources in the response headers.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, _ := strconv.ParseBool(r.URL.Query().Get("partialResults"))
+		if !v && !Enabled(r.Header) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, s := WithSources(r.Context())
+		next.ServeHTTP(&headerWriter{ResponseWriter: w, sources: s}, r.WithContext(ctx))
+	})
+}
+
+// headerWriter sets the missing sources header before the response is
+// written.
+type headerWriter struct {
+	http.ResponseWriter
+	sources     *Sources
+	wroteHeader bool
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.sources.SetHeader(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// withMissingSources adds the missing sources to the metadata of the error:
+// the sources that failed before the query failed may explain the error.
+func withMissingSources(err error, s *Sources) error {
+	missing := s.Missing()
+	if len(missing) == 0 {
+		return err
+	}
+	var cerr *connect.Error
+	if !errors.As(err, &cerr) {
+		cerr = connect.NewError(connect.CodeOf(err), err)
+	}
+	cerr.Meta().Set(HeaderMissingSources, strings.Join(missing, ","))
+	return cerr
+}
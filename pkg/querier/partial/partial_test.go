@@ -0,0 +1,69 @@
+package partial
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Tolerate(t *testing.T) {
+	errUnavailable := connect.NewError(connect.CodeUnavailable, errors.New("connection refused"))
+
+	// Partial results are not allowed.
+	require.Equal(t, errUnavailable, Tolerate(context.Background(), "ingesters", errUnavailable))
+
+	ctx, s := WithSources(context.Background())
+	require.NoError(t, Tolerate(ctx, "ingesters", nil))
+	require.NoError(t, Tolerate(ctx, "store-gateway 10.0.0.2:9095", errUnavailable))
+	require.NoError(t, Tolerate(ctx, "store-gateway 10.0.0.1:9095", errors.New("EOF")))
+
+	// The errors caused by the request are not tolerated.
+	errInvalid := connect.NewError(connect.CodeInvalidArgument, errors.New("invalid selector"))
+	require.Equal(t, errInvalid, Tolerate(ctx, "ingesters", errInvalid))
+
+	assert.Equal(t, []string{"store-gateway 10.0.0.1:9095", "store-gateway 10.0.0.2:9095"}, s.Missing())
+
+	h := make(http.Header)
+	s.SetHeader(h)
+	assert.Equal(t, "store-gateway 10.0.0.1:9095,store-gateway 10.0.0.2:9095", h.Get(HeaderMissingSources))
+
+	_, merged := WithSources(context.Background())
+	merged.Add("ingesters")
+	merged.AddFromHeader(h)
+	assert.Equal(t, []string{"ingesters", "store-gateway 10.0.0.1:9095", "store-gateway 10.0.0.2:9095"}, merged.Missing())
+}
+
+func Test_Middleware(t *testing.T) {
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = Tolerate(r.Context(), "ingesters", errors.New("EOF"))
+		_, _ = w.Write([]byte("{}"))
+	}))
+
+	for _, tc := range []struct {
+		name     string
+		url      string
+		header   string
+		expected string
+	}{
+		{name: "disabled", url: "/pyroscope/render"},
+		{name: "query parameter", url: "/pyroscope/render?partialResults=true", expected: "ingesters"},
+		{name: "header", url: "/pyroscope/render", header: "true", expected: "ingesters"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.header != "" {
+				req.Header.Set(HeaderPartialResults, tc.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expected, rec.Header().Get(HeaderMissingSources))
+			assert.Equal(t, "{}", rec.Body.String())
+		})
+	}
+}
@@ -37,24 +37,30 @@ import (
 	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
 	"github.com/grafana/pyroscope/pkg/phlaredb/bucketindex"
 	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/querier/partial"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/storegateway"
 	"github.com/grafana/pyroscope/pkg/util/spanlogger"
 	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 type Config struct {
-	PoolConfig      clientpool.PoolConfig `yaml:"pool_config,omitempty"`
-	QueryStoreAfter time.Duration         `yaml:"query_store_after" category:"advanced"`
+	PoolConfig                      clientpool.PoolConfig `yaml:"pool_config,omitempty"`
+	QueryStoreAfter                 time.Duration         `yaml:"query_store_after" category:"advanced"`
+	ShuffleShardingIngestersEnabled bool                  `yaml:"shuffle_sharding_ingesters_enabled" category:"advanced"`
 }
 
 // RegisterFlags registers distributor-related flags.
 func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
 	cfg.PoolConfig.RegisterFlagsWithPrefix("querier", fs)
 	fs.DurationVar(&cfg.QueryStoreAfter, "querier.query-store-after", 4*time.Hour, "The time after which a metric should be queried from storage and not just ingesters. 0 means all queries are sent to store. If this option is enabled, the time range of the query sent to the store-gateway will be manipulated to ensure the query end is not more recent than 'now - query-store-after'.")
+	fs.BoolVar(&cfg.ShuffleShardingIngestersEnabled, "querier.shuffle-sharding-ingesters-enabled", false, "Fetch in-memory profiles from the minimum set of required ingesters, selecting only ingesters which may have received profiles of the tenant since 'now - query-store-after'. If this setting is false or the tenant shard size is 0, queriers always query all ingesters.")
 }
 
 type Limits interface {
 	QueryAnalysisSeriesEnabled(string) bool
+	MaxQuerySeries(string) int
+	SymbolNormalizationRules(string) []phlaremodel.SymbolNormalizationRule
 }
 
 type Querier struct {
@@ -70,6 +76,7 @@ type Querier struct {
 
 	storageBucket        phlareobj.Bucket
 	tenantConfigProvider phlareobj.TenantConfigProvider
+	bucketIndexLoader    *bucketindex.Loader
 
 	limits Limits
 }
@@ -104,6 +111,12 @@ func New(params *NewQuerierParams) (*Querier, error) {
 		Help:      "The current number of ingester clients.",
 	})
 
+	// Ingesters shuffle sharding on the read path requires the tenant shard size.
+	var ingesterLimits IngesterLimits
+	if params.Cfg.ShuffleShardingIngestersEnabled && params.Overrides != nil {
+		ingesterLimits = params.Overrides
+	}
+
 	// if a storage bucket is configured we need to create a store gateway querier
 	var storeGatewayQuerier *StoreGatewayQuerier
 	var err error
@@ -120,23 +133,43 @@ func New(params *NewQuerierParams) (*Querier, error) {
 		}
 	}
 
+	// The bucket index is cached, and kept up to date in the background.
+	var bucketIndexLoader *bucketindex.Loader
+	if params.StorageBucket != nil {
+		bucketIndexCfg := params.StoreGatewayCfg.BucketStoreConfig.BucketIndex
+		bucketIndexLoader = bucketindex.NewLoader(bucketindex.LoaderConfig{
+			CheckInterval:         time.Minute,
+			UpdateOnStaleInterval: params.StoreGatewayCfg.BucketStoreConfig.SyncInterval,
+			UpdateOnErrorInterval: bucketIndexCfg.UpdateOnErrorInterval,
+			IdleTimeout:           bucketIndexCfg.IdleTimeout,
+		}, params.StorageBucket, params.CfgProvider, params.Logger, params.Reg)
+	}
+
 	q := &Querier{
 		cfg:    params.Cfg,
 		logger: params.Logger,
 		ingesterQuerier: NewIngesterQuerier(
 			clientpool.NewIngesterPool(params.Cfg.PoolConfig, params.IngestersRing, params.PoolFactory, clientsMetrics, params.Logger, params.ClientOptions...),
 			params.IngestersRing,
+			ingesterLimits,
+			params.Cfg.QueryStoreAfter,
 		),
 		storeGatewayQuerier:  storeGatewayQuerier,
 		storageBucket:        params.StorageBucket,
 		tenantConfigProvider: params.CfgProvider,
-		limits:               params.Overrides,
+		bucketIndexLoader:    bucketIndexLoader,
+	}
+	if params.Overrides != nil {
+		q.limits = params.Overrides
 	}
 
 	svcs := []services.Service{q.ingesterQuerier.pool}
 	if storeGatewayQuerier != nil {
 		svcs = append(svcs, storeGatewayQuerier)
 	}
+	if bucketIndexLoader != nil {
+		svcs = append(svcs, bucketIndexLoader)
+	}
 	// should we watch for the ring module status ?
 	q.subservices, err = services.NewManager(svcs...)
 	if err != nil {
@@ -237,7 +270,7 @@ func (q *Querier) LabelValues(ctx context.Context, req *connect.Request[typesv1.
 		group.Go(func() error {
 			ir, err := q.labelValuesFromIngesters(gCtx, storeQueries.ingester.LabelValuesRequest(req.Msg))
 			if err != nil {
-				return err
+				return partial.Tolerate(gCtx, sourceIngesters, err)
 			}
 
 			lock.Lock()
@@ -251,7 +284,7 @@ func (q *Querier) LabelValues(ctx context.Context, req *connect.Request[typesv1.
 		group.Go(func() error {
 			ir, err := q.labelValuesFromStoreGateway(gCtx, storeQueries.storeGateway.LabelValuesRequest(req.Msg))
 			if err != nil {
-				return err
+				return partial.Tolerate(gCtx, sourceStoreGateways, err)
 			}
 
 			lock.Lock()
@@ -307,7 +340,7 @@ func (q *Querier) LabelNames(ctx context.Context, req *connect.Request[typesv1.L
 		group.Go(func() error {
 			ir, err := q.labelNamesFromIngesters(gCtx, storeQueries.ingester.LabelNamesRequest(req.Msg))
 			if err != nil {
-				return err
+				return partial.Tolerate(gCtx, sourceIngesters, err)
 			}
 
 			lock.Lock()
@@ -321,7 +354,7 @@ func (q *Querier) LabelNames(ctx context.Context, req *connect.Request[typesv1.L
 		group.Go(func() error {
 			ir, err := q.labelNamesFromStoreGateway(gCtx, storeQueries.storeGateway.LabelNamesRequest(req.Msg))
 			if err != nil {
-				return err
+				return partial.Tolerate(gCtx, sourceStoreGateways, err)
 			}
 
 			lock.Lock()
@@ -360,7 +393,7 @@ func (q *Querier) blockSelect(ctx context.Context, start, end model.Time) (block
 	// get first all blocks from store gateways, as they should be querier with a priority and also are the only ones containing duplicated blocks because of replication
 	if q.storeGatewayQuerier != nil {
 		res, err := q.blockSelectFromStoreGateway(ctx, ingesterReq)
-		if err != nil {
+		if err = partial.Tolerate(ctx, sourceStoreGateways, err); err != nil {
 			return nil, err
 		}
 
@@ -369,7 +402,7 @@ func (q *Querier) blockSelect(ctx context.Context, start, end model.Time) (block
 
 	if q.ingesterQuerier != nil {
 		res, err := q.blockSelectFromIngesters(ctx, ingesterReq)
-		if err != nil {
+		if err = partial.Tolerate(ctx, sourceIngesters, err); err != nil {
 			return nil, err
 		}
 		results.add(res, ingesterInstance)
@@ -427,7 +460,7 @@ func (q *Querier) Series(ctx context.Context, req *connect.Request[querierv1.Ser
 		group.Go(func() error {
 			ir, err := q.seriesFromIngesters(gCtx, storeQueries.ingester.SeriesRequest(req.Msg))
 			if err != nil {
-				return err
+				return partial.Tolerate(gCtx, sourceIngesters, err)
 			}
 
 			lock.Lock()
@@ -441,7 +474,7 @@ func (q *Querier) Series(ctx context.Context, req *connect.Request[querierv1.Ser
 		group.Go(func() error {
 			ir, err := q.seriesFromStoreGateway(gCtx, storeQueries.storeGateway.SeriesRequest(req.Msg))
 			if err != nil {
-				return err
+				return partial.Tolerate(gCtx, sourceStoreGateways, err)
 			}
 
 			lock.Lock()
@@ -486,7 +519,7 @@ func (q *Querier) Diff(ctx context.Context, req *connect.Request[querierv1.DiffR
 	g, gCtx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
-		res, err := q.selectTree(gCtx, req.Msg.Left)
+		res, err := q.selectNormalizedTree(gCtx, req.Msg.Left)
 		if err != nil {
 			return err
 		}
@@ -496,7 +529,7 @@ func (q *Querier) Diff(ctx context.Context, req *connect.Request[querierv1.DiffR
 	})
 
 	g.Go(func() error {
-		res, err := q.selectTree(gCtx, req.Msg.Right)
+		res, err := q.selectNormalizedTree(gCtx, req.Msg.Right)
 		if err != nil {
 			return err
 		}
@@ -548,12 +581,12 @@ func (q *Querier) GetProfileStats(ctx context.Context, req *connect.Request[type
 		}
 	}
 
-	if q.storageBucket != nil {
+	if q.bucketIndexLoader != nil {
 		tenantId, err := tenant.TenantID(ctx)
 		if err != nil {
 			return nil, err
 		}
-		index, err := bucketindex.ReadIndex(ctx, q.storageBucket, tenantId, q.tenantConfigProvider, q.logger)
+		index, err := q.bucketIndexLoader.GetIndex(ctx, tenantId)
 		if err != nil && !errors.Is(err, bucketindex.ErrIndexNotFound) {
 			return nil, err
 		}
@@ -592,19 +625,22 @@ func (q *Querier) SelectMergeStacktraces(ctx context.Context, req *connect.Reque
 		req.Msg.MaxNodes = &mn
 	}
 
-	t, err := q.selectTree(ctx, req.Msg)
+	t, err := q.selectNormalizedTree(ctx, req.Msg)
 	if err != nil {
 		return nil, err
 	}
 
 	var resp querierv1.SelectMergeStacktracesResponse
+	var truncation phlaremodel.TruncationStats
 	switch req.Msg.Format {
 	default:
-		resp.Flamegraph = phlaremodel.NewFlameGraph(t, req.Msg.GetMaxNodes())
+		resp.Flamegraph, truncation = phlaremodel.NewFlameGraphWithStats(t, req.Msg.GetMaxNodes())
 	case querierv1.ProfileFormat_PROFILE_FORMAT_TREE:
 		resp.Tree = t.Bytes(req.Msg.GetMaxNodes())
 	}
-	return connect.NewResponse(&resp), nil
+	r := connect.NewResponse(&resp)
+	stats.SetTruncationHeaders(r.Header(), truncation.PrunedNodes, truncation.OtherValue)
+	return r, nil
 }
 
 func (q *Querier) SelectMergeSpanProfile(ctx context.Context, req *connect.Request[querierv1.SelectMergeSpanProfileRequest]) (*connect.Response[querierv1.SelectMergeSpanProfileResponse], error) {
@@ -624,7 +660,7 @@ func (q *Querier) SelectMergeSpanProfile(ctx context.Context, req *connect.Reque
 		req.Msg.MaxNodes = &mn
 	}
 
-	t, err := q.selectSpanProfile(ctx, req.Msg)
+	t, err := q.selectNormalizedSpanProfile(ctx, req.Msg)
 	if err != nil {
 		return nil, err
 	}
@@ -639,6 +675,66 @@ func (q *Querier) SelectMergeSpanProfile(ctx context.Context, req *connect.Reque
 	return connect.NewResponse(&resp), nil
 }
 
+// symbolNormalizer returns the normalizer of the function names of the
+// tenant, or nil if the tenant has no symbol normalization rules.
+func (q *Querier) symbolNormalizer(ctx context.Context) (*phlaremodel.SymbolNormalizer, error) {
+	if q.limits == nil {
+		return nil, nil
+	}
+	tenantID, err := tenant.TenantID(ctx)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	rules := q.limits.SymbolNormalizationRules(tenantID)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	return phlaremodel.NewSymbolNormalizer(rules)
+}
+
+// selectNormalizedTree selects the tree and applies the symbol normalization
+// rules of the tenant to it. If the tenant has rules, the tree is selected
+// untruncated, as the frames merged by the rules could otherwise be already
+// folded in the "other" nodes by the ingesters and store-gateways: the tree
+// is truncated once normalized, when the response is built.
+func (q *Querier) selectNormalizedTree(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) (*phlaremodel.Tree, error) {
+	normalizer, err := q.symbolNormalizer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if normalizer == nil {
+		return q.selectTree(ctx, req)
+	}
+	req = req.CloneVT()
+	req.MaxNodes = nil
+	t, err := q.selectTree(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	normalizer.NormalizeTree(t)
+	return t, nil
+}
+
+// selectNormalizedSpanProfile is the selectNormalizedTree counterpart for
+// the span profiles.
+func (q *Querier) selectNormalizedSpanProfile(ctx context.Context, req *querierv1.SelectMergeSpanProfileRequest) (*phlaremodel.Tree, error) {
+	normalizer, err := q.symbolNormalizer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if normalizer == nil {
+		return q.selectSpanProfile(ctx, req)
+	}
+	req = req.CloneVT()
+	req.MaxNodes = nil
+	t, err := q.selectSpanProfile(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	normalizer.NormalizeTree(t)
+	return t, nil
+}
+
 func isEndpointNotExistingErr(err error) bool {
 	if err == nil {
 		return false
@@ -685,21 +781,21 @@ func (q *Querier) selectTree(ctx context.Context, req *querierv1.SelectMergeStac
 	}
 
 	g, gCtx := errgroup.WithContext(ctx)
-	var ingesterTree, storegatewayTree *phlaremodel.Tree
+	ingesterTree, storegatewayTree := new(phlaremodel.Tree), new(phlaremodel.Tree)
 	g.Go(func() error {
-		var err error
-		ingesterTree, err = q.selectTreeFromIngesters(gCtx, storeQueries.ingester.MergeStacktracesRequest(req), plan)
+		t, err := q.selectTreeFromIngesters(gCtx, storeQueries.ingester.MergeStacktracesRequest(req), plan)
 		if err != nil {
-			return err
+			return partial.Tolerate(gCtx, sourceIngesters, err)
 		}
+		ingesterTree = t
 		return nil
 	})
 	g.Go(func() error {
-		var err error
-		storegatewayTree, err = q.selectTreeFromStoreGateway(ctx, storeQueries.storeGateway.MergeStacktracesRequest(req), plan)
+		t, err := q.selectTreeFromStoreGateway(ctx, storeQueries.storeGateway.MergeStacktracesRequest(req), plan)
 		if err != nil {
-			return err
+			return partial.Tolerate(ctx, sourceStoreGateways, err)
 		}
+		storegatewayTree = t
 		return nil
 	})
 	if err := g.Wait(); err != nil {
@@ -709,6 +805,13 @@ func (q *Querier) selectTree(ctx context.Context, req *querierv1.SelectMergeStac
 	return storegatewayTree, nil
 }
 
+// The sources reported missing from the results of the queries allowing
+// partial results, if all the instances of a store fail.
+const (
+	sourceIngesters     = "ingesters"
+	sourceStoreGateways = "store-gateways"
+)
+
 type storeQuery struct {
 	start, end  model.Time
 	shouldQuery bool
@@ -850,6 +953,13 @@ func (q *Querier) SelectMergeProfile(ctx context.Context, req *connect.Request[q
 	if err != nil {
 		return nil, err
 	}
+	normalizer, err := q.symbolNormalizer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if normalizer != nil {
+		normalizer.NormalizeProfile(profile)
+	}
 	profile.DurationNanos = model.Time(req.Msg.End).UnixNano() - model.Time(req.Msg.Start).UnixNano()
 	profile.TimeNanos = model.Time(req.Msg.End).UnixNano()
 	return connect.NewResponse(profile), nil
@@ -892,14 +1002,14 @@ func (q *Querier) selectProfile(ctx context.Context, req *querierv1.SelectMergeP
 	g.Go(func() error {
 		ingesterProfile, err := q.selectProfileFromIngesters(gCtx, storeQueries.ingester.MergeProfileRequest(req), plan)
 		if err != nil {
-			return err
+			return partial.Tolerate(gCtx, sourceIngesters, err)
 		}
 		return merge.Merge(ingesterProfile)
 	})
 	g.Go(func() error {
 		storegatewayProfile, err := q.selectProfileFromStoreGateway(gCtx, storeQueries.storeGateway.MergeProfileRequest(req), plan)
 		if err != nil {
-			return err
+			return partial.Tolerate(gCtx, sourceStoreGateways, err)
 		}
 		return merge.Merge(storegatewayProfile)
 	})
@@ -1012,7 +1122,7 @@ func (q *Querier) selectSeries(ctx context.Context, req *connect.Request[querier
 
 	if storeQueries.ingester.shouldQuery {
 		ir, err := q.selectSeriesFromIngesters(ctx, storeQueries.ingester.MergeSeriesRequest(req.Msg, profileType), plan)
-		if err != nil {
+		if err = partial.Tolerate(ctx, sourceIngesters, err); err != nil {
 			return nil, err
 		}
 		responses = append(responses, ir...)
@@ -1020,7 +1130,7 @@ func (q *Querier) selectSeries(ctx context.Context, req *connect.Request[querier
 
 	if storeQueries.storeGateway.shouldQuery {
 		ir, err := q.selectSeriesFromStoreGateway(ctx, storeQueries.storeGateway.MergeSeriesRequest(req.Msg, profileType), plan)
-		if err != nil {
+		if err = partial.Tolerate(ctx, sourceStoreGateways, err); err != nil {
 			return nil, err
 		}
 		responses = append(responses, ir...)
@@ -1080,21 +1190,21 @@ func (q *Querier) selectSpanProfile(ctx context.Context, req *querierv1.SelectMe
 	}
 
 	g, gCtx := errgroup.WithContext(ctx)
-	var ingesterTree, storegatewayTree *phlaremodel.Tree
+	ingesterTree, storegatewayTree := new(phlaremodel.Tree), new(phlaremodel.Tree)
 	g.Go(func() error {
-		var err error
-		ingesterTree, err = q.selectSpanProfileFromIngesters(gCtx, storeQueries.ingester.MergeSpanProfileRequest(req), plan)
+		t, err := q.selectSpanProfileFromIngesters(gCtx, storeQueries.ingester.MergeSpanProfileRequest(req), plan)
 		if err != nil {
-			return err
+			return partial.Tolerate(gCtx, sourceIngesters, err)
 		}
+		ingesterTree = t
 		return nil
 	})
 	g.Go(func() error {
-		var err error
-		storegatewayTree, err = q.selectSpanProfileFromStoreGateway(gCtx, storeQueries.storeGateway.MergeSpanProfileRequest(req), plan)
+		t, err := q.selectSpanProfileFromStoreGateway(gCtx, storeQueries.storeGateway.MergeSpanProfileRequest(req), plan)
 		if err != nil {
-			return err
+			return partial.Tolerate(gCtx, sourceStoreGateways, err)
 		}
+		storegatewayTree = t
 		return nil
 	})
 	if err := g.Wait(); err != nil {
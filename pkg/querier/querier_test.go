@@ -41,6 +41,7 @@ import (
 	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/testhelper"
 	"github.com/grafana/pyroscope/pkg/util"
+	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 type poolFactory struct {
@@ -360,6 +361,49 @@ func Test_SelectMergeStacktraces(t *testing.T) {
 	}
 }
 
+func Test_SelectMergeStacktraces_SymbolNormalization(t *testing.T) {
+	now := time.Now().UnixMilli()
+	maxNodes := int64(1)
+	req := connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+		LabelSelector: `{app="foo"}`,
+		ProfileTypeID: "memory:inuse_space:bytes:space:byte",
+		Start:         now + 0,
+		End:           now + 2,
+		MaxNodes:      &maxNodes,
+	})
+	bidi := newFakeBidiClientStacktraces([]*ingestv1.ProfileSets{
+		{
+			LabelsSets: []*typesv1.Labels{{Labels: []*typesv1.LabelPair{{Name: "app", Value: "foo"}}}},
+			Profiles:   []*ingestv1.SeriesProfile{{Timestamp: now + 1, LabelIndex: 0}},
+		},
+	})
+	querier, err := New(&NewQuerierParams{
+		Cfg: Config{
+			PoolConfig: clientpool.PoolConfig{ClientCleanupPeriod: 1 * time.Millisecond},
+		},
+		IngestersRing: testhelper.NewMockRing([]ring.InstanceDesc{{Addr: "1"}}, 1),
+		PoolFactory: &poolFactory{func(addr string) (client.PoolClient, error) {
+			q := newFakeQuerier()
+			q.mockMergeStacktraces(bidi, []string{"a"}, false)
+			return q, nil
+		}},
+		Overrides: validation.MockOverrides(func(defaults *validation.Limits, tenantLimits map[string]*validation.Limits) {
+			defaults.SymbolNormalizationRules = []phlaremodel.SymbolNormalizationRule{{Regex: "^bu", Replacement: "b"}}
+		}),
+		Logger: log.NewLogfmtLogger(os.Stdout),
+	})
+	require.NoError(t, err)
+	flame, err := querier.SelectMergeStacktraces(tenant.InjectTenantID(context.Background(), "1234"), req)
+	require.NoError(t, err)
+
+	// The tree is selected untruncated, and truncated once normalized.
+	require.NotNil(t, bidi.request)
+	assert.Nil(t, bidi.request.MaxNodes)
+	assert.Contains(t, flame.Msg.Flamegraph.Names, "bzz")
+	assert.NotContains(t, flame.Msg.Flamegraph.Names, "buzz")
+	assert.Equal(t, int64(1), *req.Msg.MaxNodes)
+}
+
 func Test_SelectMergeProfiles(t *testing.T) {
 	for _, tc := range []struct {
 		blockSelect bool
@@ -779,6 +823,7 @@ type fakeBidiClientStacktraces struct {
 	batches  []*ingestv1.ProfileSets
 	kept     []testProfile
 	cur      *ingestv1.ProfileSets
+	request  *ingestv1.MergeProfilesStacktracesRequest
 }
 
 func newFakeBidiClientStacktraces(batches []*ingestv1.ProfileSets) *fakeBidiClientStacktraces {
@@ -793,6 +838,7 @@ func newFakeBidiClientStacktraces(batches []*ingestv1.ProfileSets) *fakeBidiClie
 
 func (f *fakeBidiClientStacktraces) Send(in *ingestv1.MergeProfilesStacktracesRequest) error {
 	if in.Request != nil {
+		f.request = in
 		return nil
 	}
 	for i, b := range in.Profiles {
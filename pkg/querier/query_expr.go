@@ -0,0 +1,381 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+	"golang.org/x/sync/errgroup"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/querier/timeline"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// Query expressions combine the profiles of several selectors, e.g.:
+//
+//	{service_name="a",version="2"} - {service_name="a",version="1"}
+//	process_cpu:cpu:nanoseconds:cpu:nanoseconds{service_name="a"} and {service_name="b"}
+//
+// Supported operators:
+//   - "+" merges the profiles.
+//   - "-" subtracts the right profile from the left one.
+//   - "and" keeps the stacks of the left profile present in the right one.
+//   - "unless" keeps the stacks of the left profile absent in the right one.
+//
+// As in PromQL, "and" and "unless" have lower precedence than "+" and "-".
+// Operators of the same precedence are left-associative; parentheses can
+// be used to change the order of evaluation. Selectors that don't specify
+// the profile type inherit it from the other operands.
+type queryExpr interface {
+	queryExpr()
+}
+
+type selectorExpr struct {
+	labelSelector string
+	profileType   *typesv1.ProfileType
+}
+
+type binaryExpr struct {
+	op       string
+	lhs, rhs queryExpr
+}
+
+func (*selectorExpr) queryExpr() {}
+func (*binaryExpr) queryExpr()   {}
+
+const (
+	opAdd    = "+"
+	opSub    = "-"
+	opAnd    = "and"
+	opUnless = "unless"
+)
+
+func parseQueryExpr(q string) (queryExpr, error) {
+	p := &queryExprParser{input: q}
+	return p.parse()
+}
+
+type queryExprParser struct {
+	input string
+	pos   int
+	// operators is set once the parser finds an operator, a parenthesis
+	// or anything after the first selector: the query is not a single
+	// selector.
+	operators bool
+}
+
+func (p *queryExprParser) parse() (queryExpr, error) {
+	e, err := p.parseSetExpr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		p.operators = true
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+	return e, nil
+}
+
+func (p *queryExprParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("failed to parse query at position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *queryExprParser) skipSpaces() {
+	for p.pos < len(p.input) && isSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// consumeOp advances past one of the given operators, if present.
+// Keyword operators must be followed by a delimiter.
+func (p *queryExprParser) consumeOp(ops ...string) (string, bool) {
+	p.skipSpaces()
+	rest := p.input[p.pos:]
+	for _, op := range ops {
+		if !strings.HasPrefix(rest, op) {
+			continue
+		}
+		if isNameChar(op[0]) && len(rest) > len(op) && isNameChar(rest[len(op)]) {
+			continue
+		}
+		p.pos += len(op)
+		return op, true
+	}
+	return "", false
+}
+
+func (p *queryExprParser) parseSetExpr() (queryExpr, error) {
+	lhs, err := p.parseArithExpr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.consumeOp(opAnd, opUnless)
+		if !ok {
+			return lhs, nil
+		}
+		p.operators = true
+		rhs, err := p.parseArithExpr()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryExpr{op: op, lhs: lhs, rhs: rhs}
+	}
+}
+
+func (p *queryExprParser) parseArithExpr() (queryExpr, error) {
+	lhs, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.consumeOp(opAdd, opSub)
+		if !ok {
+			return lhs, nil
+		}
+		p.operators = true
+		rhs, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryExpr{op: op, lhs: lhs, rhs: rhs}
+	}
+}
+
+func (p *queryExprParser) parseTerm() (queryExpr, error) {
+	p.skipSpaces()
+	if p.pos == len(p.input) {
+		return nil, p.errorf("unexpected end of query")
+	}
+	if p.input[p.pos] == '(' {
+		p.operators = true
+		p.pos++
+		e, err := p.parseSetExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.consumeOp(")"); !ok {
+			return nil, p.errorf("missing closing parenthesis")
+		}
+		return e, nil
+	}
+	return p.parseSelector()
+}
+
+// parseSelector reads an optional profile type followed by an optional
+// label selector, and parses it as a PromQL series selector.
+func (p *queryExprParser) parseSelector() (queryExpr, error) {
+	start := p.pos
+	for p.pos < len(p.input) && isNameChar(p.input[p.pos]) {
+		p.pos++
+	}
+	if p.pos < len(p.input) && p.input[p.pos] == '{' {
+		if err := p.skipBraces(); err != nil {
+			return nil, err
+		}
+	}
+	s := p.input[start:p.pos]
+	if s == "" {
+		return nil, p.errorf("expected selector")
+	}
+	selector, profileType, err := parseProfileSelector(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse selector %q: %w", s, err)
+	}
+	return &selectorExpr{labelSelector: selector, profileType: profileType}, nil
+}
+
+func (p *queryExprParser) skipBraces() error {
+	var quote byte
+	for p.pos++; p.pos < len(p.input); p.pos++ {
+		c := p.input[p.pos]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				p.pos++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '}':
+			p.pos++
+			return nil
+		}
+	}
+	return p.errorf("missing closing brace")
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == ':' || c == '.' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// resolveProfileType makes sure all the selectors of the expression
+// refer to the same profile type, and assigns it to the selectors
+// that don't specify it.
+func resolveProfileType(e queryExpr) (*typesv1.ProfileType, error) {
+	var profileType *typesv1.ProfileType
+	var selectors []*selectorExpr
+	var visit func(queryExpr)
+	visit = func(e queryExpr) {
+		switch x := e.(type) {
+		case *selectorExpr:
+			selectors = append(selectors, x)
+		case *binaryExpr:
+			visit(x.lhs)
+			visit(x.rhs)
+		}
+	}
+	visit(e)
+	for _, s := range selectors {
+		if s.profileType == nil {
+			continue
+		}
+		if profileType == nil {
+			profileType = s.profileType
+		} else if profileType.ID != s.profileType.ID {
+			return nil, fmt.Errorf("profile types must match: %s, %s", profileType.ID, s.profileType.ID)
+		}
+	}
+	if profileType == nil {
+		return nil, fmt.Errorf("query must contain a profile-type selection")
+	}
+	for _, s := range selectors {
+		s.profileType = profileType
+	}
+	return profileType, nil
+}
+
+// evalQueryExprRequest evaluates the expression of the request, the
+// combined tree is truncated to the max nodes of the request.
+func (q *QueryHandlers) evalQueryExprRequest(ctx context.Context, r *queryExprRequest) (*phlaremodel.Tree, error) {
+	tree, err := q.evalQueryExpr(ctx, r.expr, r.params)
+	if err != nil {
+		return nil, err
+	}
+	if maxNodes := r.params.GetMaxNodes(); maxNodes > 0 {
+		return phlaremodel.UnmarshalTree(tree.Bytes(maxNodes))
+	}
+	return tree, nil
+}
+
+// evalQueryExpr fetches the merged profile of every selector of the
+// expression, and combines them according to the operators. The request
+// provides the time range of the selector queries. The profiles of the
+// selectors are not truncated: the stacks dropped from one of the operands
+// would make the result of the operators wrong.
+func (q *QueryHandlers) evalQueryExpr(ctx context.Context, e queryExpr, req *querierv1.SelectMergeStacktracesRequest) (*phlaremodel.Tree, error) {
+	switch x := e.(type) {
+	case *selectorExpr:
+		resp, err := q.client.SelectMergeProfile(ctx, connect.NewRequest(&querierv1.SelectMergeProfileRequest{
+			ProfileTypeID: x.profileType.ID,
+			LabelSelector: x.labelSelector,
+			Start:         req.Start,
+			End:           req.End,
+		}))
+		if err != nil {
+			return nil, err
+		}
+		b, err := phlaremodel.TreeFromBackendProfile(resp.Msg, 0)
+		if err != nil {
+			return nil, err
+		}
+		return phlaremodel.UnmarshalTree(b)
+
+	case *binaryExpr:
+		var lhs, rhs *phlaremodel.Tree
+		g, ctx := errgroup.WithContext(ctx)
+		g.Go(func() (err error) {
+			lhs, err = q.evalQueryExpr(ctx, x.lhs, req)
+			return err
+		})
+		g.Go(func() (err error) {
+			rhs, err = q.evalQueryExpr(ctx, x.rhs, req)
+			return err
+		})
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+		switch x.op {
+		case opAdd:
+			lhs.Merge(rhs)
+			return lhs, nil
+		case opSub:
+			return lhs.Subtract(rhs), nil
+		case opAnd:
+			return lhs.Intersect(rhs), nil
+		case opUnless:
+			return lhs.Exclude(rhs), nil
+		}
+		return nil, fmt.Errorf("unknown operator %q", x.op)
+
+	default:
+		return nil, fmt.Errorf("unknown expression %T", e)
+	}
+}
+
+type queryExprRequest struct {
+	expr        queryExpr
+	params      *querierv1.SelectMergeStacktracesRequest
+	profileType *typesv1.ProfileType
+}
+
+// parseQueryExprRequest returns nil if the query is a single selector:
+// such queries are handled as usual.
+func parseQueryExprRequest(req *http.Request) (*queryExprRequest, error) {
+	p := &queryExprParser{input: req.Form.Get("query")}
+	e, err := p.parse()
+	if err != nil {
+		if p.operators {
+			return nil, err
+		}
+		// Let the regular query parser report the error of the selector.
+		return nil, nil
+	}
+	if _, ok := e.(*binaryExpr); !ok {
+		return nil, nil
+	}
+	profileType, err := resolveProfileType(e)
+	if err != nil {
+		return nil, err
+	}
+	return &queryExprRequest{
+		expr: e,
+		params: newSelectMergeStacktracesRequest(renderRequestFieldNames{
+			query: "query",
+			from:  "from",
+			until: "until",
+		}, req),
+		profileType: profileType,
+	}, nil
+}
+
+func (q *QueryHandlers) renderQueryExpr(w http.ResponseWriter, req *http.Request, r *queryExprRequest) {
+	tree, err := q.evalQueryExprRequest(req.Context(), r)
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	// The timeline of the combined profile is not defined.
+	fb := phlaremodel.ExportToFlamebearer(phlaremodel.NewFlameGraph(tree, r.params.GetMaxNodes()), r.profileType)
+	timelineStep := timeline.CalcPointInterval(r.params.Start, r.params.End)
+	fb.Timeline = timeline.New(&typesv1.Series{}, r.params.Start, r.params.End, int64(timelineStep))
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(fb); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+}
@@ -0,0 +1,145 @@
+package querier
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// formatQueryExpr renders the expression with explicit parentheses.
+func formatQueryExpr(e queryExpr) string {
+	switch x := e.(type) {
+	case *selectorExpr:
+		if x.profileType != nil {
+			return x.profileType.ID + x.labelSelector
+		}
+		return x.labelSelector
+	case *binaryExpr:
+		return fmt.Sprintf("(%s %s %s)", formatQueryExpr(x.lhs), x.op, formatQueryExpr(x.rhs))
+	}
+	return ""
+}
+
+func Test_ParseQueryExpr(t *testing.T) {
+	const cpu = "process_cpu:cpu:nanoseconds:cpu:nanoseconds"
+	for _, tc := range []struct {
+		query    string
+		expected string
+		err      string
+	}{
+		{
+			query:    cpu + `{service_name="a"}`,
+			expected: cpu + `{service_name="a"}`,
+		},
+		{
+			query:    `{service_name="a",version="2"} - {service_name="a",version="1"}`,
+			expected: `({service_name="a",version="2"} - {service_name="a",version="1"})`,
+		},
+		{
+			query:    `{a="1"}-{b="2"}+{c="3"}`,
+			expected: `(({a="1"} - {b="2"}) + {c="3"})`,
+		},
+		{
+			query:    `{a="1"} and {b="2"} - {c="3"}`,
+			expected: `({a="1"} and ({b="2"} - {c="3"}))`,
+		},
+		{
+			query:    `({a="1"} unless {b="2"}) - {c="3"}`,
+			expected: `(({a="1"} unless {b="2"}) - {c="3"})`,
+		},
+		{
+			query:    `{a="x - y", b="}"} unless {c=~"and|unless"}`,
+			expected: `({a="x - y",b="}"} unless {c=~"and|unless"})`,
+		},
+		{
+			query:    cpu + `{a="1"} - ` + cpu,
+			expected: `(` + cpu + `{a="1"} - ` + cpu + `{})`,
+		},
+		{
+			query: `{a="1"} -`,
+			err:   "unexpected end of query",
+		},
+		{
+			query: `({a="1"} - {b="2"}`,
+			err:   "missing closing parenthesis",
+		},
+		{
+			query: `{a="1"} - {b="2"`,
+			err:   "missing closing brace",
+		},
+		{
+			query: `{a="1"} or {b="2"}`,
+			err:   "unexpected",
+		},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			e, err := parseQueryExpr(tc.query)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, formatQueryExpr(e))
+		})
+	}
+}
+
+func Test_ResolveProfileType(t *testing.T) {
+	const cpu = "process_cpu:cpu:nanoseconds:cpu:nanoseconds"
+	const alloc = "memory:alloc_space:bytes:space:bytes"
+
+	e, err := parseQueryExpr(`{a="1"} - ` + cpu + `{b="2"} and {c="3"}`)
+	require.NoError(t, err)
+	profileType, err := resolveProfileType(e)
+	require.NoError(t, err)
+	assert.Equal(t, cpu, profileType.ID)
+	assert.Equal(t, 3, strings.Count(formatQueryExpr(e), cpu))
+
+	e, err = parseQueryExpr(cpu + `{a="1"} - ` + alloc + `{a="1"}`)
+	require.NoError(t, err)
+	_, err = resolveProfileType(e)
+	require.ErrorContains(t, err, "profile types must match")
+
+	e, err = parseQueryExpr(`{a="1"} - {a="2"}`)
+	require.NoError(t, err)
+	_, err = resolveProfileType(e)
+	require.ErrorContains(t, err, "profile-type selection")
+}
+
+func Test_ParseQueryExprRequest(t *testing.T) {
+	const cpu = "process_cpu:cpu:nanoseconds:cpu:nanoseconds"
+	parse := func(query string) (*queryExprRequest, error) {
+		req := httptest.NewRequest("GET", "/render?from=now-1h&until=now&query="+url.QueryEscape(query), nil)
+		require.NoError(t, req.ParseForm())
+		return parseQueryExprRequest(req)
+	}
+
+	r, err := parse(cpu + `{a="1"} - {a="2"}`)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, cpu, r.profileType.ID)
+
+	// The single selectors are handled by the regular query parser.
+	r, err = parse(cpu + `{a="1"}`)
+	require.NoError(t, err)
+	assert.Nil(t, r)
+	r, err = parse(cpu + `{a="1"`)
+	require.NoError(t, err)
+	assert.Nil(t, r)
+
+	for _, query := range []string{
+		cpu + `{a="1"} -`,
+		`(` + cpu + `{a="1"} - {a="2"}`,
+		cpu + `{a="1"} - {a="2"`,
+		cpu + `{a="1"} or {a="2"}`,
+	} {
+		_, err = parse(query)
+		assert.Error(t, err, query)
+	}
+}
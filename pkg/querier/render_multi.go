@@ -0,0 +1,169 @@
+package querier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"golang.org/x/sync/errgroup"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/structs/flamebearer"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+	"github.com/grafana/pyroscope/pkg/querier/timeline"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// maxRenderMultiProfileTypes limits the number of profile types of
+// a single render-multi query.
+const maxRenderMultiProfileTypes = 8
+
+type renderMultiResponse struct {
+	Profiles []renderMultiProfile `json:"profiles"`
+}
+
+type renderMultiProfile struct {
+	ProfileTypeID string `json:"profileTypeID"`
+	*flamebearer.FlamebearerProfile
+	Truncation *renderTruncation `json:"truncation,omitempty"`
+}
+
+// RenderMulti returns the flame graphs and timelines of several profile
+// types of the same selector in one response, e.g. to show the CPU,
+// off-CPU and allocation profiles of a service side by side. For example,
+// /pyroscope/render-multi?query={service_name="a"}&profileType=...&profileType=...&from=now-1h&until=now.
+//
+// The timelines of all the profile types share the same time range and
+// step, and the values are converted to consistent units: the values
+// measuring time are reported in nanoseconds, whatever the sample rate.
+func (q *QueryHandlers) RenderMulti(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	selector, profileTypes, err := parseRenderMultiQuery(req)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	params := newSelectMergeStacktracesRequest(renderRequestFieldNames{
+		query: "query",
+		from:  "from",
+		until: "until",
+	}, req)
+	params.LabelSelector = selector
+
+	timelineStep := timeline.CalcPointInterval(params.Start, params.End)
+	flames := make([]*connect.Response[querierv1.SelectMergeStacktracesResponse], len(profileTypes))
+	series := make([]*connect.Response[querierv1.SelectSeriesResponse], len(profileTypes))
+	g, ctx := errgroup.WithContext(req.Context())
+	for i, pt := range profileTypes {
+		g.Go(func() error {
+			p := params.CloneVT()
+			p.ProfileTypeID = pt.ID
+			var err error
+			flames[i], err = q.client.SelectMergeStacktraces(ctx, connect.NewRequest(p))
+			return err
+		})
+		g.Go(func() error {
+			def := phlaremodel.ProfileTypes.Lookup(pt)
+			aggregation := def.TimeSeriesAggregation()
+			var err error
+			series[i], err = q.client.SelectSeries(ctx, connect.NewRequest(&querierv1.SelectSeriesRequest{
+				ProfileTypeID: pt.ID,
+				LabelSelector: params.LabelSelector,
+				Start:         params.Start,
+				End:           params.End,
+				Step:          timelineStep,
+				Aggregation:   &aggregation,
+			}))
+			return err
+		})
+	}
+	if err = g.Wait(); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+
+	res := renderMultiResponse{Profiles: make([]renderMultiProfile, len(profileTypes))}
+	for i, pt := range profileTypes {
+		res.Profiles[i] = newRenderMultiProfile(pt, flames[i], series[i].Msg.Series, params.Start, params.End, int64(timelineStep))
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(res); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+}
+
+// newRenderMultiProfile converts the flame graph and the timeline of the
+// profile type to the normalized units.
+func newRenderMultiProfile(
+	pt *typesv1.ProfileType,
+	resFlame *connect.Response[querierv1.SelectMergeStacktracesResponse],
+	series []*typesv1.Series,
+	start, end, step int64,
+) renderMultiProfile {
+	def := phlaremodel.ProfileTypes.Lookup(pt)
+	c := def.NormalizedUnits()
+	phlaremodel.ScaleFlameGraph(resFlame.Msg.Flamegraph, c.Factor)
+	fb := phlaremodel.ExportToFlamebearer(resFlame.Msg.Flamegraph, pt)
+	fb.Metadata.Units = c.Units
+	fb.Metadata.SampleRate = c.SampleRate
+
+	seriesVal := &typesv1.Series{}
+	if len(series) == 1 {
+		seriesVal = series[0]
+	}
+	for _, p := range seriesVal.Points {
+		p.Value *= float64(c.Factor)
+	}
+	fb.Timeline = timeline.New(seriesVal, start, end, step)
+
+	p := renderMultiProfile{ProfileTypeID: pt.ID, FlamebearerProfile: fb}
+	if nodes, value, ok := stats.TruncationFromHeaders(resFlame.Header()); ok {
+		p.Truncation = &renderTruncation{PrunedNodes: nodes, OtherValue: value * c.Factor}
+	}
+	return p
+}
+
+// parseRenderMultiQuery returns the label selector of the query, and the
+// profile types of the query and of the profileType parameters.
+func parseRenderMultiQuery(req *http.Request) (string, []*typesv1.ProfileType, error) {
+	q := req.Form.Get("query")
+	if q == "" {
+		return "", nil, fmt.Errorf("'query' is required")
+	}
+	selector, pt, err := parseProfileSelector(q)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse 'query': %w", err)
+	}
+	var profileTypes []*typesv1.ProfileType
+	seen := make(map[string]struct{})
+	add := func(pt *typesv1.ProfileType) {
+		if _, ok := seen[pt.ID]; !ok {
+			seen[pt.ID] = struct{}{}
+			profileTypes = append(profileTypes, pt)
+		}
+	}
+	if pt != nil {
+		add(pt)
+	}
+	for _, v := range req.Form["profileType"] {
+		pt, err = phlaremodel.ParseProfileTypeSelector(v)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid profile type %q: %w", v, err)
+		}
+		add(pt)
+	}
+	switch {
+	case len(profileTypes) == 0:
+		return "", nil, fmt.Errorf("at least one profile type is required")
+	case len(profileTypes) > maxRenderMultiProfileTypes:
+		return "", nil, fmt.Errorf("too many profile types: %d, the maximum is %d", len(profileTypes), maxRenderMultiProfileTypes)
+	}
+	return selector, profileTypes, nil
+}
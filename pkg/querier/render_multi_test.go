@@ -0,0 +1,67 @@
+package querier
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/storage/metadata"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+)
+
+func Test_parseRenderMultiQuery(t *testing.T) {
+	parse := func(v url.Values) (string, []*typesv1.ProfileType, error) {
+		return parseRenderMultiQuery(&http.Request{Form: v})
+	}
+	const (
+		cpu   = "process_cpu:cpu:nanoseconds:cpu:nanoseconds"
+		alloc = "memory:alloc_space:bytes:space:bytes"
+	)
+
+	selector, pts, err := parse(url.Values{
+		"query":       {`process_cpu:cpu:nanoseconds:cpu:nanoseconds{service_name="a"}`},
+		"profileType": {alloc, cpu},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `{service_name="a"}`, selector)
+	require.Len(t, pts, 2)
+	assert.Equal(t, cpu, pts[0].ID)
+	assert.Equal(t, alloc, pts[1].ID)
+
+	for _, v := range []url.Values{
+		{},
+		{"query": {`{service_name="a"}`}},
+		{"query": {`{service_name="a"}`}, "profileType": {"cpu"}},
+	} {
+		_, _, err = parse(v)
+		assert.Error(t, err, v)
+	}
+}
+
+func Test_newRenderMultiProfile(t *testing.T) {
+	pt, err := phlaremodel.ParseProfileTypeSelector("wall:wall:samples:cpu:nanoseconds")
+	require.NoError(t, err)
+
+	tree := new(phlaremodel.Tree)
+	tree.InsertStack(3, "a", "b")
+	resp := connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{
+		Flamegraph: phlaremodel.NewFlameGraph(tree, -1),
+	})
+	stats.SetTruncationHeaders(resp.Header(), 2, 1)
+	series := []*typesv1.Series{{Points: []*typesv1.Point{{Timestamp: 0, Value: 3}}}}
+
+	p := newRenderMultiProfile(pt, resp, series, 0, 10_000, 10)
+	assert.Equal(t, pt.ID, p.ProfileTypeID)
+	assert.Equal(t, metadata.SamplesUnits, p.Metadata.Units)
+	assert.Equal(t, uint32(1_000_000_000), p.Metadata.SampleRate)
+	assert.Equal(t, 30_000_000, p.Flamebearer.NumTicks)
+	assert.Equal(t, []uint64{30_000_000}, p.Timeline.Samples[:1])
+	assert.Equal(t, &renderTruncation{PrunedNodes: 2, OtherValue: 10_000_000}, p.Truncation)
+}
@@ -0,0 +1,94 @@
+package querier
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+)
+
+// renderStreamBufferSize is the size of the buffer the flame graph levels
+// are written through.
+const renderStreamBufferSize = 64 << 10
+
+// flamebearerPlaceholder is how the flame graph without names and levels is
+// encoded in the response, it is replaced with the streamed ones.
+var flamebearerPlaceholder = []byte(`"flamebearer":{"names":null,"levels":null,`)
+
+// writeRenderResponse writes the render response with the names and the
+// levels of the flame graph, instead of the ones of res. The names and the
+// levels are encoded one at a time, so that the response of a large flame
+// graph is not buffered entirely in memory, which takes several times the
+// memory of the flame graph itself. The output is the same as the one of a
+// json.Encoder.
+func writeRenderResponse(w io.Writer, fg *querierv1.FlameGraph, res renderResponse) error {
+	fb := *res.FlamebearerProfile
+	fb.Flamebearer.Names = nil
+	fb.Flamebearer.Levels = nil
+	res.FlamebearerProfile = &fb
+	rest, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	prefix, suffix, ok := bytes.Cut(rest, flamebearerPlaceholder)
+	if !ok {
+		return fmt.Errorf("flamebearer not found in the render response")
+	}
+
+	// the write errors of the buffered writer are returned by Flush
+	bw := bufio.NewWriterSize(w, renderStreamBufferSize)
+	_, _ = bw.Write(prefix)
+	_, _ = bw.WriteString(`"flamebearer":{"names":`)
+	if err = writeNames(bw, fg.GetNames()); err != nil {
+		return err
+	}
+	_, _ = bw.WriteString(`,"levels":`)
+	writeLevels(bw, fg.GetLevels())
+	_ = bw.WriteByte(',')
+	_, _ = bw.Write(suffix)
+	_ = bw.WriteByte('\n')
+	return bw.Flush()
+}
+
+func writeNames(w *bufio.Writer, names []string) error {
+	if names == nil {
+		_, err := w.WriteString("null")
+		return err
+	}
+	_ = w.WriteByte('[')
+	for i, name := range names {
+		if i > 0 {
+			_ = w.WriteByte(',')
+		}
+		b, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		_, _ = w.Write(b)
+	}
+	_ = w.WriteByte(']')
+	return nil
+}
+
+func writeLevels(w *bufio.Writer, levels []*querierv1.Level) {
+	var num [20]byte
+	_ = w.WriteByte('[')
+	for i, level := range levels {
+		if i > 0 {
+			_ = w.WriteByte(',')
+		}
+		_ = w.WriteByte('[')
+		for j, v := range level.GetValues() {
+			if j > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = w.Write(strconv.AppendInt(num[:0], v, 10))
+		}
+		_ = w.WriteByte(']')
+	}
+	_ = w.WriteByte(']')
+}
@@ -0,0 +1,67 @@
+package querier
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/structs/flamebearer"
+	"github.com/grafana/pyroscope/pkg/settings/annotations"
+)
+
+func Test_writeRenderResponse(t *testing.T) {
+	profileType := &typesv1.ProfileType{
+		ID:         "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		Name:       "process_cpu",
+		SampleType: "cpu",
+		SampleUnit: "nanoseconds",
+		PeriodType: "cpu",
+		PeriodUnit: "nanoseconds",
+	}
+	for _, tc := range []struct {
+		name string
+		fg   *querierv1.FlameGraph
+	}{
+		{name: "nil flame graph"},
+		{name: "empty flame graph", fg: &querierv1.FlameGraph{}},
+		{
+			name: "flame graph",
+			fg: &querierv1.FlameGraph{
+				Names: []string{"total", "main", `<html> "quoted"`, "other"},
+				Levels: []*querierv1.Level{
+					{Values: []int64{0, 300, 0, 0}},
+					{Values: []int64{0, 200, 50, 1, 0, 100, 100, 3}},
+					{Values: []int64{0, 150, 150, 2}},
+					{},
+				},
+				Total:   300,
+				MaxSelf: 150,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := renderResponse{
+				FlamebearerProfile: phlaremodel.ExportToFlamebearer(tc.fg, profileType),
+				Annotations: []annotations.Annotation{{
+					ID:   "deploy",
+					Time: 1000,
+					Text: `"flamebearer":{"names":null,"levels":null,`,
+				}},
+				Truncation: &renderTruncation{PrunedNodes: 1, OtherValue: 10},
+			}
+			res.Timeline = &flamebearer.FlamebearerTimelineV1{StartTime: 1, Samples: []uint64{1, 2}, DurationDelta: 10}
+			res.Groups = map[string]*flamebearer.FlamebearerTimelineV1{"foo": res.Timeline}
+			var expected bytes.Buffer
+			require.NoError(t, json.NewEncoder(&expected).Encode(res))
+
+			var actual bytes.Buffer
+			require.NoError(t, writeRenderResponse(&actual, tc.fg, res))
+			require.Equal(t, expected.String(), actual.String())
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package querier
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/structs/flamebearer"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+	"github.com/grafana/pyroscope/pkg/querier/timeline"
+	"github.com/grafana/pyroscope/pkg/settings/annotations"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// renderAggregatedReplicas renders the profile with the series of the
+// replicas combined with the average or the maximum, instead of the sum.
+// The replicas are the series distinguished by the labels other than the
+// group by ones. The flame graph is the average profile of the replicas,
+// or the profile of the heaviest replica.
+func (q *QueryHandlers) renderAggregatedReplicas(
+	w http.ResponseWriter,
+	req *http.Request,
+	selectParams *querierv1.SelectMergeStacktracesRequest,
+	profileType *typesv1.ProfileType,
+	groupBy []string,
+	aggregation typesv1.TimeSeriesAggregationType,
+	fn phlaremodel.SeriesAggregation,
+) {
+	ctx := req.Context()
+	names, err := q.client.LabelNames(ctx, connect.NewRequest(&typesv1.LabelNamesRequest{
+		Matchers: []string{selectParams.LabelSelector},
+		Start:    selectParams.Start,
+		End:      selectParams.End,
+	}))
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	replicaBy := slices.Clone(groupBy)
+	for _, name := range names.Msg.Names {
+		if !strings.HasPrefix(name, "__") && !slices.Contains(replicaBy, name) {
+			replicaBy = append(replicaBy, name)
+		}
+	}
+
+	timelineStep := timeline.CalcPointInterval(selectParams.Start, selectParams.End)
+	resSeries, err := q.client.SelectSeries(ctx, connect.NewRequest(&querierv1.SelectSeriesRequest{
+		ProfileTypeID: selectParams.ProfileTypeID,
+		LabelSelector: selectParams.LabelSelector,
+		Start:         selectParams.Start,
+		End:           selectParams.End,
+		Step:          timelineStep,
+		GroupBy:       replicaBy,
+		Aggregation:   &aggregation,
+	}))
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	series := resSeries.Msg.Series
+
+	flameParams := selectParams.CloneVT()
+	flameParams.Format = querierv1.ProfileFormat_PROFILE_FORMAT_TREE
+	replicas := int64(len(series))
+	if fn == phlaremodel.SeriesAggregationMax {
+		if s := phlaremodel.HeaviestSeries(series); s != nil {
+			if flameParams.LabelSelector, err = withLabelMatchers(selectParams.LabelSelector, s.Labels); err != nil {
+				httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+				return
+			}
+		}
+		replicas = 1
+	}
+	resFlame, err := q.client.SelectMergeStacktraces(ctx, connect.NewRequest(flameParams))
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	tree, err := phlaremodel.UnmarshalTree(resFlame.Msg.Tree)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInternal, err))
+		return
+	}
+	if replicas > 1 {
+		tree = tree.Divide(replicas)
+	}
+
+	var resAnnotations []annotations.Annotation
+	if q.annotations != nil {
+		if resAnnotations, err = q.listAnnotations(ctx, selectParams); err != nil {
+			httputil.Error(w, err)
+			return
+		}
+	}
+
+	stats.CopyHeaders(w.Header(), resFlame.Header())
+	flame, truncation := phlaremodel.NewFlameGraphWithStats(tree, selectParams.GetMaxNodes())
+	fb := phlaremodel.ExportToFlamebearer(&querierv1.FlameGraph{Total: flame.GetTotal(), MaxSelf: flame.GetMaxSelf()}, profileType)
+	seriesVal := &typesv1.Series{}
+	if total := phlaremodel.AggregateSeries(series, nil, fn); len(total) == 1 {
+		seriesVal = total[0]
+	}
+	fb.Timeline = timeline.New(seriesVal, selectParams.Start, selectParams.End, int64(timelineStep))
+
+	if len(groupBy) > 0 {
+		fb.Groups = make(map[string]*flamebearer.FlamebearerTimelineV1)
+		for _, s := range phlaremodel.AggregateSeries(series, groupBy[:1], fn) {
+			key := "*"
+			if len(s.Labels) > 0 {
+				key = s.Labels[0].Value
+			}
+			fb.Groups[key] = timeline.New(s, selectParams.Start, selectParams.End, int64(timelineStep))
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	res := renderResponse{
+		FlamebearerProfile: fb,
+		Annotations:        resAnnotations,
+	}
+	if truncation.PrunedNodes > 0 || truncation.OtherValue > 0 {
+		res.Truncation = &renderTruncation{PrunedNodes: truncation.PrunedNodes, OtherValue: truncation.OtherValue}
+	}
+	if err := writeRenderResponse(w, flame, res); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+}
+
+// withLabelMatchers adds the equality matchers of the labels to the
+// selector, to select the profiles of a single series.
+func withLabelMatchers(selector string, lbs []*typesv1.LabelPair) (string, error) {
+	matchers, err := parser.ParseMetricSelector(selector)
+	if err != nil {
+		return "", err
+	}
+	for _, l := range lbs {
+		matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, l.Name, l.Value))
+	}
+	return convertMatchersToString(matchers), nil
+}
@@ -18,6 +18,7 @@ import (
 	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	"github.com/grafana/pyroscope/pkg/phlaredb/sharding"
+	"github.com/grafana/pyroscope/pkg/querier/partial"
 	"github.com/grafana/pyroscope/pkg/util"
 	"github.com/grafana/pyroscope/pkg/util/spanlogger"
 )
@@ -88,9 +89,12 @@ func forGivenReplicationSet[Result any, Querier any](ctx context.Context, client
 	return results, err
 }
 
-// forGivenPlan runs f, in parallel, for given plan.
+// forGivenPlan runs f, in parallel, for given plan. If the query allows
+// partial results, the failed replicas of the component are reported
+// missing and skipped.
 func forGivenPlan[Result any, Querier any](
 	ctx context.Context,
+	component string,
 	plan map[string]*blockPlanEntry,
 	clientFactory func(string) (Querier, error),
 	replicationSet ring.ReplicationSet, f QueryReplicaWithHintsFn[Result, Querier],
@@ -115,12 +119,12 @@ func forGivenPlan[Result any, Querier any](
 		g.Go(func() error {
 			client, err := clientFactory(r)
 			if err != nil {
-				return err
+				return partial.Tolerate(ctx, component+" "+r, err)
 			}
 
 			resp, err := f(ctx, client, &ingestv1.Hints{Block: h})
 			if err != nil {
-				return err
+				return partial.Tolerate(ctx, component+" "+r, err)
 			}
 
 			result[i] = ResponseFromReplica[Result]{r, resp}
@@ -133,7 +137,10 @@ func forGivenPlan[Result any, Querier any](
 		return nil, err
 	}
 
-	result = result[:idx]
+	// The skipped replicas have no address.
+	result = lo.Filter(result[:idx], func(r ResponseFromReplica[Result], _ int) bool {
+		return r.addr != ""
+	})
 
 	return result, nil
 }
@@ -0,0 +1,177 @@
+package querier
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+
+	"connectrpc.com/connect"
+	"golang.org/x/sync/errgroup"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/querier/timeline"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// serviceCatalogConcurrency is the number of profile types queried
+// concurrently for the service catalog.
+const serviceCatalogConcurrency = 4
+
+type ServiceCatalogResponse struct {
+	Services []ServiceCatalogEntry `json:"services"`
+}
+
+type ServiceCatalogEntry struct {
+	Name string `json:"name"`
+	// FirstSeen and LastSeen are the times of the first and the last
+	// profiles of the service in the time range, in milliseconds, at the
+	// resolution of the timeline of the time range.
+	FirstSeen    int64                `json:"firstSeen"`
+	LastSeen     int64                `json:"lastSeen"`
+	ProfileTypes []ServiceProfileType `json:"profileTypes"`
+}
+
+type ServiceProfileType struct {
+	ID        string `json:"id"`
+	FirstSeen int64  `json:"firstSeen"`
+	LastSeen  int64  `json:"lastSeen"`
+	// Total is the sum of the values of the profiles, in the unit of the
+	// profile type, for example, the CPU time in nanoseconds.
+	Total float64 `json:"total"`
+}
+
+// ServiceCatalog lists the services of the tenant, with their profile types,
+// when they were seen and the volume of their profiles, so that the service
+// pickers do not have to fetch all the series.
+// For example, /pyroscope/service-catalog?match[]={namespace="prod"}&from=now-7d.
+// At most one selector is accepted.
+func (q *QueryHandlers) ServiceCatalog(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	matchers, err := parseMatchers(req.Form)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	selector := "{}"
+	switch len(matchers) {
+	case 0:
+	case 1:
+		selector = matchers[0]
+	default:
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, errors.New("at most one match[] selector is supported")))
+		return
+	}
+	start, end := parseTimeRange(req.Form)
+
+	series, err := q.client.Series(req.Context(), connect.NewRequest(&querierv1.SeriesRequest{
+		Matchers:   matchers,
+		LabelNames: []string{phlaremodel.LabelNameServiceName, phlaremodel.LabelNameProfileType},
+		Start:      start,
+		End:        end,
+	}))
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	profileTypes := make(map[string]struct{})
+	for _, s := range series.Msg.LabelsSet {
+		if t := phlaremodel.Labels(s.Labels).Get(phlaremodel.LabelNameProfileType); t != "" {
+			profileTypes[t] = struct{}{}
+		}
+	}
+
+	var mu sync.Mutex
+	catalog := newServiceCatalog()
+	step := timeline.CalcPointInterval(start, end)
+	aggregation := typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM
+	g, ctx := errgroup.WithContext(req.Context())
+	g.SetLimit(serviceCatalogConcurrency)
+	for profileType := range profileTypes {
+		g.Go(func() error {
+			resp, err := q.client.SelectSeries(ctx, connect.NewRequest(&querierv1.SelectSeriesRequest{
+				ProfileTypeID: profileType,
+				LabelSelector: selector,
+				Start:         start,
+				End:           end,
+				Step:          step,
+				GroupBy:       []string{phlaremodel.LabelNameServiceName},
+				Aggregation:   &aggregation,
+			}))
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			catalog.add(profileType, resp.Msg.Series)
+			return nil
+		})
+	}
+	if err = g.Wait(); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ServiceCatalogResponse{Services: catalog.entries()}); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+}
+
+type serviceCatalog struct {
+	services map[string]*ServiceCatalogEntry
+}
+
+func newServiceCatalog() *serviceCatalog {
+	return &serviceCatalog{services: make(map[string]*ServiceCatalogEntry)}
+}
+
+// add adds the series of the profile type, grouped by service.
+func (c *serviceCatalog) add(profileType string, series []*typesv1.Series) {
+	for _, s := range series {
+		name := phlaremodel.Labels(s.Labels).Get(phlaremodel.LabelNameServiceName)
+		if name == "" || len(s.Points) == 0 {
+			continue
+		}
+		t := ServiceProfileType{
+			ID:        profileType,
+			FirstSeen: s.Points[0].Timestamp,
+			LastSeen:  s.Points[len(s.Points)-1].Timestamp,
+		}
+		for _, p := range s.Points {
+			t.Total += p.Value
+		}
+		e, ok := c.services[name]
+		if !ok {
+			e = &ServiceCatalogEntry{Name: name, FirstSeen: t.FirstSeen, LastSeen: t.LastSeen}
+			c.services[name] = e
+		}
+		e.FirstSeen = min(e.FirstSeen, t.FirstSeen)
+		e.LastSeen = max(e.LastSeen, t.LastSeen)
+		e.ProfileTypes = append(e.ProfileTypes, t)
+	}
+}
+
+// entries returns the services ordered by name, with their profile types
+// ordered by ID.
+func (c *serviceCatalog) entries() []ServiceCatalogEntry {
+	res := make([]ServiceCatalogEntry, 0, len(c.services))
+	for _, e := range c.services {
+		slices.SortFunc(e.ProfileTypes, func(a, b ServiceProfileType) int {
+			return cmp.Compare(a.ID, b.ID)
+		})
+		res = append(res, *e)
+	}
+	slices.SortFunc(res, func(a, b ServiceCatalogEntry) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return res
+}
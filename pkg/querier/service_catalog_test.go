@@ -0,0 +1,47 @@
+package querier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+func Test_ServiceCatalog(t *testing.T) {
+	c := newServiceCatalog()
+	c.add("memory", []*typesv1.Series{
+		{
+			Labels: phlaremodel.LabelsFromStrings("service_name", "b"),
+			Points: []*typesv1.Point{{Timestamp: 2000, Value: 10}, {Timestamp: 3000, Value: 5}},
+		},
+	})
+	c.add("cpu", []*typesv1.Series{
+		{
+			Labels: phlaremodel.LabelsFromStrings("service_name", "b"),
+			Points: []*typesv1.Point{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}},
+		},
+		{
+			Labels: phlaremodel.LabelsFromStrings("service_name", "a"),
+			Points: []*typesv1.Point{{Timestamp: 4000, Value: 3}},
+		},
+		{Labels: phlaremodel.LabelsFromStrings("service_name", "c")},
+		{
+			Labels: phlaremodel.LabelsFromStrings(),
+			Points: []*typesv1.Point{{Timestamp: 4000, Value: 3}},
+		},
+	})
+
+	assert.Equal(t, []ServiceCatalogEntry{
+		{Name: "a", FirstSeen: 4000, LastSeen: 4000, ProfileTypes: []ServiceProfileType{
+			{ID: "cpu", FirstSeen: 4000, LastSeen: 4000, Total: 3},
+		}},
+		{Name: "b", FirstSeen: 1000, LastSeen: 3000, ProfileTypes: []ServiceProfileType{
+			{ID: "cpu", FirstSeen: 1000, LastSeen: 2000, Total: 3},
+			{ID: "memory", FirstSeen: 2000, LastSeen: 3000, Total: 15},
+		}},
+	}, c.entries())
+
+	assert.Empty(t, newServiceCatalog().entries())
+}
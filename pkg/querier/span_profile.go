@@ -0,0 +1,102 @@
+package querier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// SpanProfile returns the merged profile of the samples labeled with the
+// given span IDs, for example,
+// /pyroscope/span-profile?query=...&from=now-1h&until=now&spanSelector=9a2e7bbd1c3f4a01.
+//
+// The span IDs are 16 hex characters long, and can be passed as multiple
+// spanSelector parameters, or as a comma-separated list: to get the profile
+// of a trace, pass the IDs of its spans. The profile is returned in the
+// flamebearer format, or in the formats supported by the export endpoint.
+func (q *QueryHandlers) SpanProfile(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	format := req.Form.Get("format")
+	switch format {
+	case "", "json", exportFormatSpeedscope, exportFormatCollapsed:
+	case "folded":
+		format = exportFormatCollapsed
+	default:
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unsupported format %q", format)))
+		return
+	}
+	spans, err := parseSpanSelector(req)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	selectParams, profileType, err := parseSelectProfilesRequest(renderRequestFieldNames{}, req)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+
+	spanReq := &querierv1.SelectMergeSpanProfileRequest{
+		ProfileTypeID: selectParams.ProfileTypeID,
+		LabelSelector: selectParams.LabelSelector,
+		SpanSelector:  spans,
+		Start:         selectParams.Start,
+		End:           selectParams.End,
+		MaxNodes:      selectParams.MaxNodes,
+	}
+	if format != "" && format != "json" {
+		spanReq.Format = querierv1.ProfileFormat_PROFILE_FORMAT_TREE
+	}
+	resp, err := q.client.SelectMergeSpanProfile(req.Context(), connect.NewRequest(spanReq))
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+	stats.CopyHeaders(w.Header(), resp.Header())
+
+	if spanReq.Format == querierv1.ProfileFormat_PROFILE_FORMAT_TREE {
+		tree, err := phlaremodel.UnmarshalTree(resp.Msg.Tree)
+		if err != nil {
+			httputil.Error(w, connect.NewError(connect.CodeInternal, err))
+			return
+		}
+		if err = writeExport(w, format, tree, profileType); err != nil {
+			httputil.Error(w, err)
+		}
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(phlaremodel.ExportToFlamebearer(resp.Msg.Flamegraph, profileType)); err != nil {
+		httputil.Error(w, err)
+	}
+}
+
+func parseSpanSelector(req *http.Request) ([]string, error) {
+	var spans []string
+	for _, v := range req.Form["spanSelector"] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				spans = append(spans, s)
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return nil, errors.New("'spanSelector' is required")
+	}
+	if _, err := phlaremodel.NewSpanSelector(spans); err != nil {
+		return nil, fmt.Errorf("invalid 'spanSelector': %w", err)
+	}
+	return spans, nil
+}
@@ -0,0 +1,57 @@
+package querier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/test/mocks/mockquerierv1connect"
+)
+
+func Test_SpanProfile(t *testing.T) {
+	tree := new(phlaremodel.Tree)
+	tree.InsertStack(3, "main", "handler")
+	client := mockquerierv1connect.NewMockQuerierServiceClient(t)
+	client.On("SelectMergeSpanProfile", mock.Anything, mock.MatchedBy(func(req *connect.Request[querierv1.SelectMergeSpanProfileRequest]) bool {
+		return assert.Equal(t, []string{"9a2e7bbd1c3f4a01", "0000000000000001", "0000000000000002"}, req.Msg.SpanSelector) &&
+			assert.Equal(t, `{service_name="svc"}`, req.Msg.LabelSelector) &&
+			assert.Equal(t, querierv1.ProfileFormat_PROFILE_FORMAT_TREE, req.Msg.Format)
+	})).Return(connect.NewResponse(&querierv1.SelectMergeSpanProfileResponse{Tree: tree.Bytes(-1)}), nil).Once()
+
+	q := url.Values{
+		"format":       {"collapsed"},
+		"query":        {`process_cpu:cpu:nanoseconds:cpu:nanoseconds{service_name="svc"}`},
+		"spanSelector": {"9a2e7bbd1c3f4a01", "0000000000000001,0000000000000002"},
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/pyroscope/span-profile?"+q.Encode(), nil)
+	NewHTTPHandlers(client, nil).SpanProfile(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "main;handler 3\n", w.Body.String())
+}
+
+func Test_SpanProfile_InvalidSpanSelector(t *testing.T) {
+	client := mockquerierv1connect.NewMockQuerierServiceClient(t)
+	for _, spans := range [][]string{
+		nil,
+		{""},
+		{"9a2e7bbd"},
+		{"zz2e7bbd1c3f4a01"},
+	} {
+		q := url.Values{
+			"query":        {"process_cpu:cpu:nanoseconds:cpu:nanoseconds{}"},
+			"spanSelector": spans,
+		}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/pyroscope/span-profile?"+q.Encode(), nil)
+		NewHTTPHandlers(client, nil).SpanProfile(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, spans)
+	}
+}
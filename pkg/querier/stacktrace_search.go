@@ -0,0 +1,211 @@
+package querier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"connectrpc.com/connect"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+const (
+	defaultStacktraceSearchLimit = 100
+	maxStacktraceSearchLimit     = 10000
+)
+
+type StacktraceSearchResult struct {
+	// Stack is the call path from the root to the matching function.
+	Stack []string `json:"stack"`
+	// Self is the value of the samples in which a matching function is the
+	// innermost frame.
+	Self int64 `json:"self"`
+	// Total is the value of the samples going through the call path.
+	Total int64 `json:"total"`
+}
+
+type StacktraceSearchResponse struct {
+	Stacks []StacktraceSearchResult `json:"stacks"`
+	// Total is the sum of the values of the samples containing the function.
+	Total int64 `json:"total"`
+	// ProfileTotal is the sum of all sample values of the profile.
+	ProfileTotal int64 `json:"profileTotal"`
+	// Count is the number of call paths before the limit.
+	Count int    `json:"count"`
+	Limit int    `json:"limit"`
+	Unit  string `json:"unit"`
+}
+
+type stacktraceSearchParams struct {
+	match func(string) bool
+	limit int
+}
+
+func parseStacktraceSearchParams(req *http.Request) (stacktraceSearchParams, error) {
+	v := req.Form
+	p := stacktraceSearchParams{limit: defaultStacktraceSearchLimit}
+	function, functionRegex := v.Get("function"), v.Get("functionRegex")
+	switch {
+	case function != "" && functionRegex != "":
+		return p, errors.New("only one of function and functionRegex can be specified")
+	case function != "":
+		p.match = func(name string) bool { return name == function }
+	case functionRegex != "":
+		// The expression is anchored, as in the label matchers.
+		re, err := regexp.Compile("^(?:" + functionRegex + ")$")
+		if err != nil {
+			return p, fmt.Errorf("invalid functionRegex %q: %w", functionRegex, err)
+		}
+		p.match = re.MatchString
+	default:
+		return p, errors.New("function or functionRegex parameter is required")
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("invalid limit %q", s)
+		}
+		p.limit = min(n, maxStacktraceSearchLimit)
+	}
+	return p, nil
+}
+
+// StacktraceSearch returns the call paths leading to the functions matching
+// the search, with the values of the samples going through them.
+// For example, /pyroscope/stacktrace-search?query=...&from=now-1h&function=runtime.mallocgc
+// answers which functions call runtime.mallocgc, and how much.
+func (q *QueryHandlers) StacktraceSearch(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	params, err := parseStacktraceSearchParams(req)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	selectParams, profileType, err := parseSelectProfilesRequest(renderRequestFieldNames{}, req)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	// As for the top functions, the search is done in the complete profile:
+	// the truncated trees fold the small call paths.
+	resp, err := q.client.SelectMergeProfile(req.Context(), connect.NewRequest(&querierv1.SelectMergeProfileRequest{
+		Start:         selectParams.Start,
+		End:           selectParams.End,
+		ProfileTypeID: selectParams.ProfileTypeID,
+		LabelSelector: selectParams.LabelSelector,
+	}))
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+
+	stats.CopyHeaders(w.Header(), resp.Header())
+	stacks, total, profileTotal := searchStacktraces(resp.Msg, params.match)
+	res := StacktraceSearchResponse{
+		Stacks:       stacks[:min(params.limit, len(stacks))],
+		Total:        total,
+		ProfileTotal: profileTotal,
+		Count:        len(stacks),
+		Limit:        params.limit,
+		Unit:         profileType.SampleUnit,
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+}
+
+// searchStacktraces aggregates the samples containing a matching function
+// by their call path from the root to the outermost matching frame, so that
+// recursive calls are counted once. The call paths are ranked by total value.
+func searchStacktraces(p *profilev1.Profile, match func(string) bool) ([]StacktraceSearchResult, int64, int64) {
+	str := func(i int64) string {
+		if i < 0 || i >= int64(len(p.StringTable)) {
+			return ""
+		}
+		return p.StringTable[i]
+	}
+	locations := make(map[uint64]*profilev1.Location, len(p.Location))
+	for _, l := range p.Location {
+		locations[l.Id] = l
+	}
+	functions := make(map[uint64]string, len(p.Function))
+	matches := make(map[uint64]bool, len(p.Function))
+	for _, f := range p.Function {
+		name := str(f.Name)
+		functions[f.Id] = name
+		matches[f.Id] = match(name)
+	}
+
+	var total, profileTotal int64
+	index := make(map[string]int)
+	results := make([]StacktraceSearchResult, 0)
+	frames := make([]uint64, 0, 64)
+	for _, s := range p.Sample {
+		if len(s.Value) == 0 || s.Value[0] == 0 {
+			continue
+		}
+		v := s.Value[0]
+		profileTotal += v
+		// Frames from the innermost call.
+		frames = frames[:0]
+		for _, id := range s.LocationId {
+			loc, ok := locations[id]
+			if !ok {
+				continue
+			}
+			for _, line := range loc.Line {
+				frames = append(frames, line.FunctionId)
+			}
+		}
+		outermost := -1
+		for i := len(frames) - 1; i >= 0; i-- {
+			if matches[frames[i]] {
+				outermost = i
+				break
+			}
+		}
+		if outermost < 0 {
+			continue
+		}
+		total += v
+		stack := make([]string, 0, len(frames)-outermost)
+		for i := len(frames) - 1; i >= outermost; i-- {
+			stack = append(stack, functions[frames[i]])
+		}
+		key := strings.Join(stack, "\x00")
+		i, ok := index[key]
+		if !ok {
+			i = len(results)
+			index[key] = i
+			results = append(results, StacktraceSearchResult{Stack: stack})
+		}
+		results[i].Total += v
+		if matches[frames[0]] {
+			results[i].Self += v
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Total != results[j].Total {
+			return results[i].Total > results[j].Total
+		}
+		return slices.Compare(results[i].Stack, results[j].Stack) < 0
+	})
+	return results, total, profileTotal
+}
@@ -0,0 +1,86 @@
+package querier
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+)
+
+func Test_SearchStacktraces(t *testing.T) {
+	p := &profilev1.Profile{
+		StringTable: []string{"", "main.main", "main.handler", "runtime.mallocgc", "main.worker", "main.encode"},
+		Function: []*profilev1.Function{
+			{Id: 1, Name: 1},
+			{Id: 2, Name: 2},
+			{Id: 3, Name: 3},
+			{Id: 4, Name: 4},
+			{Id: 5, Name: 5},
+		},
+		Location: []*profilev1.Location{
+			{Id: 1, Line: []*profilev1.Line{{FunctionId: 1}}},
+			{Id: 2, Line: []*profilev1.Line{{FunctionId: 2}}},
+			{Id: 3, Line: []*profilev1.Line{{FunctionId: 3}}},
+			{Id: 4, Line: []*profilev1.Line{{FunctionId: 4}}},
+			// Inlined call: main.encode in main.handler.
+			{Id: 5, Line: []*profilev1.Line{{FunctionId: 5}, {FunctionId: 2}}},
+		},
+		Sample: []*profilev1.Sample{
+			{LocationId: []uint64{3, 2, 1}, Value: []int64{10}},
+			{LocationId: []uint64{3, 4, 1}, Value: []int64{4}},
+			{LocationId: []uint64{3, 5, 1}, Value: []int64{3}},
+			{LocationId: []uint64{2, 1}, Value: []int64{7}},
+			// Recursion is only accounted once.
+			{LocationId: []uint64{2, 2, 1}, Value: []int64{1}},
+		},
+	}
+
+	stacks, total, profileTotal := searchStacktraces(p, func(name string) bool { return name == "runtime.mallocgc" })
+	assert.Equal(t, int64(17), total)
+	assert.Equal(t, int64(25), profileTotal)
+	assert.Equal(t, []StacktraceSearchResult{
+		{Stack: []string{"main.main", "main.handler", "runtime.mallocgc"}, Self: 10, Total: 10},
+		{Stack: []string{"main.main", "main.worker", "runtime.mallocgc"}, Self: 4, Total: 4},
+		{Stack: []string{"main.main", "main.handler", "main.encode", "runtime.mallocgc"}, Self: 3, Total: 3},
+	}, stacks)
+
+	stacks, total, _ = searchStacktraces(p, func(name string) bool { return name == "main.handler" })
+	assert.Equal(t, int64(21), total)
+	assert.Equal(t, []StacktraceSearchResult{
+		{Stack: []string{"main.main", "main.handler"}, Self: 8, Total: 21},
+	}, stacks)
+}
+
+func Test_ParseStacktraceSearchParams(t *testing.T) {
+	parse := func(query string) (stacktraceSearchParams, error) {
+		v, err := url.ParseQuery(query)
+		require.NoError(t, err)
+		return parseStacktraceSearchParams(&http.Request{Form: v})
+	}
+
+	p, err := parse("function=main.handler")
+	require.NoError(t, err)
+	assert.Equal(t, defaultStacktraceSearchLimit, p.limit)
+	assert.True(t, p.match("main.handler"))
+	assert.False(t, p.match("main.handler.func1"))
+
+	p, err = parse("functionRegex=main%5C.handler.*&limit=20000")
+	require.NoError(t, err)
+	assert.Equal(t, maxStacktraceSearchLimit, p.limit)
+	assert.True(t, p.match("main.handler.func1"))
+	assert.False(t, p.match("xmain.handler"))
+
+	for _, query := range []string{
+		"",
+		"function=a&functionRegex=b",
+		"functionRegex=(",
+		"function=a&limit=0",
+	} {
+		_, err = parse(query)
+		assert.Error(t, err, query)
+	}
+}
@@ -0,0 +1,84 @@
+package querier
+
+import (
+	"context"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/grafana/dskit/tenant"
+	"golang.org/x/sync/errgroup"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/util/validation"
+)
+
+type StaleSeriesLimits interface {
+	SeriesStaleAfter(tenantID string) time.Duration
+}
+
+// StaleSeriesMarker marks the stale series of the series responses of the
+// querier service with the __stale__="true" label, so that the service
+// pickers do not offer the services that are gone as if they were running.
+//
+// A series is stale if it has not received profiles in the last
+// SeriesStaleAfter period of the time range of the request: the series of
+// the period are queried along with the series of the whole time range.
+type StaleSeriesMarker struct {
+	querierv1connect.QuerierServiceHandler
+	limits StaleSeriesLimits
+}
+
+func NewStaleSeriesMarker(svc querierv1connect.QuerierServiceHandler, limits StaleSeriesLimits) *StaleSeriesMarker {
+	return &StaleSeriesMarker{
+		QuerierServiceHandler: svc,
+		limits:                limits,
+	}
+}
+
+func (m *StaleSeriesMarker) Series(ctx context.Context, c *connect.Request[querierv1.SeriesRequest]) (*connect.Response[querierv1.SeriesResponse], error) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	staleAfter := validation.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, m.limits.SeriesStaleAfter)
+	recentStart := c.Msg.End - staleAfter.Milliseconds()
+	if staleAfter <= 0 || c.Msg.End == 0 || recentStart <= c.Msg.Start {
+		// No series can be stale within the time range.
+		return m.QuerierServiceHandler.Series(ctx, c)
+	}
+
+	recentReq := c.Msg.CloneVT()
+	recentReq.Start = recentStart
+	var all, recent *connect.Response[querierv1.SeriesResponse]
+	g, gCtx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		all, err = m.QuerierServiceHandler.Series(gCtx, c)
+		return err
+	})
+	g.Go(func() (err error) {
+		recent, err = m.QuerierServiceHandler.Series(gCtx, connect.NewRequest(recentReq))
+		return err
+	})
+	if err = g.Wait(); err != nil {
+		return nil, err
+	}
+
+	markStaleSeries(all.Msg, recent.Msg)
+	return all, nil
+}
+
+// markStaleSeries marks the series of the response missing from the
+// response of the recent period.
+func markStaleSeries(all, recent *querierv1.SeriesResponse) {
+	fresh := make(map[uint64]struct{}, len(recent.LabelsSet))
+	for _, ls := range recent.LabelsSet {
+		fresh[phlaremodel.Labels(ls.Labels).Hash()] = struct{}{}
+	}
+	for _, ls := range all.LabelsSet {
+		if _, ok := fresh[phlaremodel.Labels(ls.Labels).Hash()]; !ok {
+			ls.Labels = phlaremodel.Labels(ls.Labels).InsertSorted(phlaremodel.LabelNameStale, "true")
+		}
+	}
+}
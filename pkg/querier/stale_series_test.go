@@ -0,0 +1,75 @@
+package querier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+// fakeSeriesService returns the series with a profile in the time range.
+type fakeSeriesService struct {
+	querierv1connect.UnimplementedQuerierServiceHandler
+	lastSeen map[string]int64
+}
+
+func (s *fakeSeriesService) Series(_ context.Context, req *connect.Request[querierv1.SeriesRequest]) (*connect.Response[querierv1.SeriesResponse], error) {
+	var res []*typesv1.Labels
+	for _, name := range []string{"checkout", "payment"} {
+		if ts := s.lastSeen[name]; ts >= req.Msg.Start && ts <= req.Msg.End {
+			res = append(res, &typesv1.Labels{Labels: phlaremodel.LabelsFromStrings("service_name", name)})
+		}
+	}
+	return connect.NewResponse(&querierv1.SeriesResponse{LabelsSet: res}), nil
+}
+
+func Test_StaleSeriesMarker(t *testing.T) {
+	svc := &fakeSeriesService{lastSeen: map[string]int64{
+		"checkout": 7 * time.Hour.Milliseconds(),
+		"payment":  2 * time.Hour.Milliseconds(),
+	}}
+	ctx := user.InjectOrgID(context.Background(), "tenant")
+	req := &querierv1.SeriesRequest{Start: 0, End: 8 * time.Hour.Milliseconds()}
+
+	for _, tc := range []struct {
+		name       string
+		staleAfter time.Duration
+		expected   []string
+	}{
+		{
+			name:     "disabled",
+			expected: []string{`{service_name="checkout"}`, `{service_name="payment"}`},
+		},
+		{
+			name:       "stale series",
+			staleAfter: 4 * time.Hour,
+			expected:   []string{`{service_name="checkout"}`, `{__stale__="true", service_name="payment"}`},
+		},
+		{
+			name:       "time range shorter than the stale period",
+			staleAfter: 12 * time.Hour,
+			expected:   []string{`{service_name="checkout"}`, `{service_name="payment"}`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewStaleSeriesMarker(svc, validation.MockLimits{SeriesStaleAfterValue: tc.staleAfter})
+			resp, err := m.Series(ctx, connect.NewRequest(req.CloneVT()))
+			require.NoError(t, err)
+			actual := make([]string, 0, len(resp.Msg.LabelsSet))
+			for _, ls := range resp.Msg.LabelsSet {
+				actual = append(actual, phlaremodel.LabelPairsString(ls.Labels))
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
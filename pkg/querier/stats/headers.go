@@ -0,0 +1,62 @@
+package stats
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Query statistics returned by the query frontend in the response headers.
+const (
+	HeaderPrefix = "X-Pyroscope-Query-"
+
+	// HeaderWallTime is the time the frontend spent executing the query.
+	HeaderWallTime = HeaderPrefix + "Wall-Time"
+	// HeaderSplitQueries is the number of sub-queries the query was split into.
+	HeaderSplitQueries = HeaderPrefix + "Split-Queries"
+	// HeaderBlocks is the number of blocks in the query time range.
+	HeaderBlocks = HeaderPrefix + "Blocks"
+	// HeaderSeries is the number of series selected by the query.
+	HeaderSeries = HeaderPrefix + "Series"
+	// HeaderPostings is the number of series postings in the index of the
+	// blocks the query reads, summed over the replicas.
+	HeaderPostings = HeaderPrefix + "Postings"
+	// HeaderBytes is the size of block data in the query time range.
+	HeaderBytes = HeaderPrefix + "Bytes"
+	// HeaderTruncatedNodes is the number of flame graph nodes pruned
+	// because of the max nodes limit.
+	HeaderTruncatedNodes = HeaderPrefix + "Truncated-Nodes"
+	// HeaderTruncatedValue is the value of the pruned flame graph nodes
+	// aggregated into the "other" nodes.
+	HeaderTruncatedValue = HeaderPrefix + "Truncated-Value"
+)
+
+// CopyHeaders copies the query statistics headers from src to dst.
+func CopyHeaders(dst, src http.Header) {
+	for k, v := range src {
+		if strings.HasPrefix(k, HeaderPrefix) {
+			dst[k] = append(dst[k], v...)
+		}
+	}
+}
+
+// SetTruncationHeaders sets the flame graph truncation headers.
+// The headers are only set if any node has been pruned.
+func SetTruncationHeaders(h http.Header, prunedNodes, otherValue int64) {
+	if prunedNodes == 0 && otherValue == 0 {
+		return
+	}
+	h.Set(HeaderTruncatedNodes, strconv.FormatInt(prunedNodes, 10))
+	h.Set(HeaderTruncatedValue, strconv.FormatInt(otherValue, 10))
+}
+
+// TruncationFromHeaders returns the flame graph truncation reported in
+// the headers; ok is false if the flame graph has not been truncated.
+func TruncationFromHeaders(h http.Header) (prunedNodes, otherValue int64, ok bool) {
+	n, nErr := strconv.ParseInt(h.Get(HeaderTruncatedNodes), 10, 64)
+	v, vErr := strconv.ParseInt(h.Get(HeaderTruncatedValue), 10, 64)
+	if nErr != nil || vErr != nil {
+		return 0, 0, false
+	}
+	return n, v, true
+}
@@ -7,6 +7,7 @@ package stats
 
 import (
 	"context"
+	"net/http"
 	"testing"
 	"time"
 
@@ -157,3 +158,16 @@ func TestStats_Merge(t *testing.T) {
 		assert.Equal(t, uint32(0), stats1.LoadSplitQueries())
 	})
 }
+
+func TestTruncationHeaders(t *testing.T) {
+	h := make(http.Header)
+	SetTruncationHeaders(h, 0, 0)
+	_, _, ok := TruncationFromHeaders(h)
+	assert.False(t, ok)
+
+	SetTruncationHeaders(h, 10, 42)
+	nodes, value, ok := TruncationFromHeaders(h)
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), nodes)
+	assert.Equal(t, int64(42), value)
+}
@@ -151,7 +151,7 @@ func forAllPlannedStoreGateways[T any](ctx context.Context, _ string, storegatew
 		return nil, err
 	}
 
-	return forGivenPlan(ctx, plan, func(addr string) (StoreGatewayQueryClient, error) {
+	return forGivenPlan(ctx, "store-gateway", plan, func(addr string) (StoreGatewayQueryClient, error) {
 		client, err := storegatewayQuerier.pool.GetClientFor(addr)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,275 @@
+package querier
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"connectrpc.com/connect"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+const (
+	defaultTopFunctionsLimit = 100
+	maxTopFunctionsLimit     = 10000
+)
+
+type TopFunction struct {
+	Name    string `json:"name"`
+	Package string `json:"package,omitempty"`
+	File    string `json:"file,omitempty"`
+	Flat    int64  `json:"flat"`
+	Cum     int64  `json:"cum"`
+}
+
+type TopFunctionsResponse struct {
+	Functions []TopFunction `json:"functions"`
+	// Total is the sum of all sample values of the profile.
+	Total int64 `json:"total"`
+	// Count is the number of functions before pagination.
+	Count  int    `json:"count"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+	Unit   string `json:"unit"`
+}
+
+type topFunctionsParams struct {
+	sortBy string
+	desc   bool
+	offset int
+	limit  int
+}
+
+func parseTopFunctionsParams(req *http.Request) (topFunctionsParams, error) {
+	v := req.Form
+	p := topFunctionsParams{
+		sortBy: "flat",
+		limit:  defaultTopFunctionsLimit,
+	}
+	switch s := v.Get("sortBy"); s {
+	case "":
+	case "flat", "cum", "name":
+		p.sortBy = s
+	default:
+		return p, fmt.Errorf("invalid sortBy %q: must be one of flat, cum, name", s)
+	}
+	// Values are ranked in descending order, names in ascending order by default.
+	p.desc = p.sortBy != "name"
+	switch o := v.Get("order"); o {
+	case "":
+	case "desc":
+		p.desc = true
+	case "asc":
+		p.desc = false
+	default:
+		return p, fmt.Errorf("invalid order %q: must be asc or desc", o)
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("invalid limit %q", s)
+		}
+		p.limit = min(n, maxTopFunctionsLimit)
+	}
+	if s := v.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid offset %q", s)
+		}
+		p.offset = n
+	}
+	return p, nil
+}
+
+// TopFunctions returns a page of the functions of the merged profile,
+// ranked by their flat (self) or cumulative (total) value.
+// For example, /pyroscope/top-functions?query=...&from=now-1h&sortBy=cum&limit=20&offset=40.
+func (q *QueryHandlers) TopFunctions(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	params, err := parseTopFunctionsParams(req)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	selectParams, profileType, err := parseSelectProfilesRequest(renderRequestFieldNames{}, req)
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	// The table is computed from the complete profile: truncation
+	// would skew cumulative values of the functions.
+	resp, err := q.client.SelectMergeProfile(req.Context(), connect.NewRequest(&querierv1.SelectMergeProfileRequest{
+		Start:         selectParams.Start,
+		End:           selectParams.End,
+		ProfileTypeID: selectParams.ProfileTypeID,
+		LabelSelector: selectParams.LabelSelector,
+	}))
+	if err != nil {
+		httputil.Error(w, err)
+		return
+	}
+
+	stats.CopyHeaders(w.Header(), resp.Header())
+	functions, total := topFunctions(resp.Msg)
+	sortTopFunctions(functions, params.sortBy, params.desc)
+	res := TopFunctionsResponse{
+		Total:  total,
+		Count:  len(functions),
+		Offset: params.offset,
+		Limit:  params.limit,
+		Unit:   profileType.SampleUnit,
+	}
+	if params.offset < len(functions) {
+		res.Functions = functions[params.offset:min(params.offset+params.limit, len(functions))]
+	} else {
+		res.Functions = []TopFunction{}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+}
+
+// topFunctions aggregates the profile samples by function. The flat value
+// is attributed to the innermost frame of the sample, the cumulative value
+// to every function present in the stack, counted once per sample.
+func topFunctions(p *profilev1.Profile) ([]TopFunction, int64) {
+	str := func(i int64) string {
+		if i < 0 || i >= int64(len(p.StringTable)) {
+			return ""
+		}
+		return p.StringTable[i]
+	}
+	locations := make(map[uint64]*profilev1.Location, len(p.Location))
+	for _, l := range p.Location {
+		locations[l.Id] = l
+	}
+
+	// Functions with the same name and file are merged:
+	// a profile may carry several function entries for them.
+	type functionKey struct{ name, file int64 }
+	index := make(map[uint64]int, len(p.Function))
+	keys := make(map[functionKey]int, len(p.Function))
+	functions := make([]TopFunction, 0, len(p.Function))
+	for _, f := range p.Function {
+		k := functionKey{name: f.Name, file: f.Filename}
+		i, ok := keys[k]
+		if !ok {
+			i = len(functions)
+			keys[k] = i
+			name := str(f.Name)
+			functions = append(functions, TopFunction{
+				Name:    name,
+				Package: packageName(name),
+				File:    str(f.Filename),
+			})
+		}
+		index[f.Id] = i
+	}
+
+	var total int64
+	seen := make([]bool, len(functions))
+	visited := make([]int, 0, 64)
+	for _, s := range p.Sample {
+		if len(s.Value) == 0 || s.Value[0] == 0 {
+			continue
+		}
+		v := s.Value[0]
+		total += v
+		leaf := true
+		for _, id := range s.LocationId {
+			loc, ok := locations[id]
+			if !ok {
+				continue
+			}
+			// Lines are ordered from the innermost (inlined) call.
+			for _, line := range loc.Line {
+				i, ok := index[line.FunctionId]
+				if !ok {
+					continue
+				}
+				if leaf {
+					functions[i].Flat += v
+					leaf = false
+				}
+				if !seen[i] {
+					seen[i] = true
+					functions[i].Cum += v
+					visited = append(visited, i)
+				}
+			}
+		}
+		for _, i := range visited {
+			seen[i] = false
+		}
+		visited = visited[:0]
+	}
+
+	// Drop functions that have no samples attributed.
+	n := 0
+	for _, f := range functions {
+		if f.Cum != 0 {
+			functions[n] = f
+			n++
+		}
+	}
+	return functions[:n], total
+}
+
+func sortTopFunctions(functions []TopFunction, sortBy string, desc bool) {
+	sort.SliceStable(functions, func(i, j int) bool {
+		a, b := functions[i], functions[j]
+		var c int
+		switch sortBy {
+		case "cum":
+			c = cmp.Compare(a.Cum, b.Cum)
+		case "flat":
+			c = cmp.Compare(a.Flat, b.Flat)
+		case "name":
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if desc {
+			c = -c
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		return c < 0
+	})
+}
+
+// packageName returns the package, namespace, or class of the function,
+// if it can be identified from the name. For Go symbols, the package path
+// ends at the first dot after the last slash.
+func packageName(name string) string {
+	if i := strings.LastIndex(name, "::"); i > 0 {
+		return name[:i]
+	}
+	if slash := strings.LastIndexByte(name, '/'); slash >= 0 {
+		if dot := strings.IndexByte(name[slash:], '.'); dot > 0 {
+			return name[:slash+dot]
+		}
+		return ""
+	}
+	if i := strings.Index(name, ".("); i > 0 {
+		// Go method with a pointer receiver: runtime.(*mheap).alloc.
+		return name[:i]
+	}
+	if dot := strings.LastIndexByte(name, '.'); dot > 0 {
+		return name[:dot]
+	}
+	return ""
+}
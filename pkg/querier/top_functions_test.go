@@ -0,0 +1,69 @@
+package querier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+)
+
+func Test_TopFunctions(t *testing.T) {
+	p := &profilev1.Profile{
+		StringTable: []string{"", "main.main", "main.go", "github.com/foo/bar.(*T).Do", "bar.go", "runtime.mallocgc", "malloc.go"},
+		Function: []*profilev1.Function{
+			{Id: 1, Name: 1, Filename: 2},
+			{Id: 2, Name: 3, Filename: 4},
+			{Id: 3, Name: 5, Filename: 6},
+		},
+		Location: []*profilev1.Location{
+			{Id: 1, Line: []*profilev1.Line{{FunctionId: 1}}},
+			{Id: 2, Line: []*profilev1.Line{{FunctionId: 2}}},
+			{Id: 3, Line: []*profilev1.Line{{FunctionId: 3}}},
+		},
+		Sample: []*profilev1.Sample{
+			{LocationId: []uint64{3, 2, 1}, Value: []int64{10}},
+			{LocationId: []uint64{2, 1}, Value: []int64{5}},
+			// Recursion is only accounted once.
+			{LocationId: []uint64{1, 1}, Value: []int64{1}},
+		},
+	}
+
+	functions, total := topFunctions(p)
+	require.Equal(t, int64(16), total)
+	sortTopFunctions(functions, "cum", true)
+	assert.Equal(t, []TopFunction{
+		{Name: "main.main", Package: "main", File: "main.go", Flat: 1, Cum: 16},
+		{Name: "github.com/foo/bar.(*T).Do", Package: "github.com/foo/bar", File: "bar.go", Flat: 5, Cum: 15},
+		{Name: "runtime.mallocgc", Package: "runtime", File: "malloc.go", Flat: 10, Cum: 10},
+	}, functions)
+
+	sortTopFunctions(functions, "flat", true)
+	assert.Equal(t, []string{"runtime.mallocgc", "github.com/foo/bar.(*T).Do", "main.main"}, functionNames(functions))
+
+	sortTopFunctions(functions, "name", false)
+	assert.Equal(t, []string{"github.com/foo/bar.(*T).Do", "main.main", "runtime.mallocgc"}, functionNames(functions))
+}
+
+func Test_PackageName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"github.com/foo/bar.(*T).Do":       "github.com/foo/bar",
+		"github.com/foo/bar/v2.Func.func1": "github.com/foo/bar/v2",
+		"runtime.(*mheap).alloc":           "runtime",
+		"runtime.mallocgc":                 "runtime",
+		"com.example.Foo.bar":              "com.example.Foo",
+		"std::vector<int>::push_back":      "std::vector<int>",
+		"main":                             "",
+	} {
+		assert.Equal(t, expected, packageName(name), name)
+	}
+}
+
+func functionNames(functions []TopFunction) []string {
+	names := make([]string, len(functions))
+	for i, f := range functions {
+		names[i] = f.Name
+	}
+	return names
+}
@@ -0,0 +1,91 @@
+package querier
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/grafana/dskit/tenant"
+	"golang.org/x/sync/errgroup"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// UsageResponse describes the data stored for the tenant in a time range.
+type UsageResponse struct {
+	Tenant string `json:"tenant"`
+	// From and Until are the time range boundaries in milliseconds.
+	From  int64 `json:"from"`
+	Until int64 `json:"until"`
+
+	// StoredBytes is the size of the blocks that overlap the time range.
+	StoredBytes uint64 `json:"storedBytes"`
+	Blocks      uint64 `json:"blocks"`
+	Profiles    uint64 `json:"profiles"`
+	Samples     uint64 `json:"samples"`
+	// Series is the number of distinct series in the time range.
+	Series int `json:"series"`
+}
+
+// Usage reports the storage usage of the tenant in the time range,
+// for chargeback purposes. The ingestion and query usage is exported
+// as metrics by the distributors and query frontends.
+// For example, /pyroscope/usage?from=now-24h&until=now.
+func (q *QueryHandlers) Usage(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	tenantID, err := tenant.TenantID(req.Context())
+	if err != nil {
+		httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	start, end := parseTimeRange(req.Form)
+
+	var (
+		analysis *connect.Response[querierv1.AnalyzeQueryResponse]
+		series   *connect.Response[querierv1.SeriesResponse]
+	)
+	g, ctx := errgroup.WithContext(req.Context())
+	g.Go(func() (err error) {
+		analysis, err = q.client.AnalyzeQuery(ctx, connect.NewRequest(&querierv1.AnalyzeQueryRequest{
+			Start: start,
+			End:   end,
+		}))
+		return err
+	})
+	g.Go(func() (err error) {
+		series, err = q.client.Series(ctx, connect.NewRequest(&querierv1.SeriesRequest{
+			Start: start,
+			End:   end,
+		}))
+		return err
+	})
+	if err = g.Wait(); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+
+	res := UsageResponse{
+		Tenant: tenantID,
+		From:   start,
+		Until:  end,
+		Series: len(series.Msg.LabelsSet),
+	}
+	for _, s := range analysis.Msg.QueryScopes {
+		res.Blocks += s.BlockCount
+		res.Profiles += s.ProfileCount
+		res.Samples += s.SampleCount
+	}
+	if impact := analysis.Msg.QueryImpact; impact != nil {
+		res.StoredBytes = impact.TotalBytesInTimeRange
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		httputil.Error(w, err)
+		return
+	}
+}
@@ -0,0 +1,409 @@
+package regression
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/notifier"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+type Config struct {
+	Enabled              bool          `yaml:"enabled"`
+	Interval             time.Duration `yaml:"interval"`
+	Lookback             time.Duration `yaml:"lookback" category:"advanced"`
+	Window               time.Duration `yaml:"window" category:"advanced"`
+	MinDuration          time.Duration `yaml:"min_duration" category:"advanced"`
+	VersionLabel         string        `yaml:"version_label"`
+	ProfileType          string        `yaml:"profile_type"`
+	Threshold            float64       `yaml:"threshold"`
+	MaxFunctions         int           `yaml:"max_functions" category:"advanced"`
+	QueryFrontendAddress string        `yaml:"query_frontend_address"`
+	DeprecatedWebhookURL string        `yaml:"webhook_url" category:"advanced"` // Deprecated: use notifier.webhook_url instead
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "regression-detection.enabled", false, "Enable the continuous detection of performance regressions between the versions of the services.")
+	f.DurationVar(&cfg.Interval, "regression-detection.interval", 10*time.Minute, "How frequently the latest version of each service is compared with the previous one.")
+	f.DurationVar(&cfg.Lookback, "regression-detection.lookback", 24*time.Hour, "How far back the versions of the services are looked up.")
+	f.DurationVar(&cfg.Window, "regression-detection.window", time.Hour, "The time range of the profiles compared for each version.")
+	f.DurationVar(&cfg.MinDuration, "regression-detection.min-duration", 30*time.Minute, "How long the latest version must have been profiled before it is compared with the previous one.")
+	f.StringVar(&cfg.VersionLabel, "regression-detection.version-label", "version", "The label that identifies the version of the services, for example version or commit.")
+	f.StringVar(&cfg.ProfileType, "regression-detection.profile-type", "process_cpu:cpu:nanoseconds:cpu:nanoseconds", "The profile type compared between the versions.")
+	f.Float64Var(&cfg.Threshold, "regression-detection.threshold", 0.05, "The minimum increase of the share of the samples of a function, between 0 and 1, to be reported as a regression.")
+	f.IntVar(&cfg.MaxFunctions, "regression-detection.max-functions", 10, "The maximum number of regressed functions reported for each regression.")
+	f.StringVar(&cfg.QueryFrontendAddress, "regression-detection.query-frontend.address", "", "The HTTP address of the query-frontend the profiles are queried from. If empty, the HTTP server of the local instance is queried.")
+	f.StringVar(&cfg.DeprecatedWebhookURL, "regression-detection.webhook-url", "", "Deprecated: Use 'notifier.webhook-url' instead. The URL the detected regressions are posted to, as JSON.")
+}
+
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Interval <= 0 {
+		return errors.New("the regression detection interval must be positive")
+	}
+	if cfg.Window <= 0 || cfg.Window > cfg.Lookback {
+		return errors.New("the regression detection window must be positive and not exceed the lookback")
+	}
+	if cfg.MinDuration < 0 || cfg.MinDuration > cfg.Lookback {
+		return errors.New("the regression detection min duration must not be negative or exceed the lookback")
+	}
+	if cfg.VersionLabel == "" {
+		return errors.New("the regression detection version label is required")
+	}
+	if cfg.Threshold <= 0 || cfg.Threshold > 1 {
+		return errors.New("the regression detection threshold must be in the range (0, 1]")
+	}
+	return nil
+}
+
+type QuerierClient interface {
+	LabelValues(context.Context, *connect.Request[typesv1.LabelValuesRequest]) (*connect.Response[typesv1.LabelValuesResponse], error)
+	SelectSeries(context.Context, *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error)
+	SelectMergeStacktraces(context.Context, *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error)
+}
+
+// TenantsFunc returns the tenants the regressions are detected for.
+type TenantsFunc func(ctx context.Context) ([]string, error)
+
+// Regression describes the functions whose share of the samples increased
+// between two versions of a service.
+type Regression struct {
+	TenantID        string          `json:"tenant_id"`
+	ServiceName     string          `json:"service_name"`
+	ProfileType     string          `json:"profile_type"`
+	Version         string          `json:"version"`
+	PreviousVersion string          `json:"previous_version"`
+	Start           int64           `json:"start"`
+	End             int64           `json:"end"`
+	PreviousStart   int64           `json:"previous_start"`
+	PreviousEnd     int64           `json:"previous_end"`
+	Functions       []FunctionDelta `json:"functions"`
+}
+
+// event returns the notification of the regression, linking to the diff
+// view of the versions.
+func (r *Regression) event(versionLabel string) *notifier.Event {
+	var text strings.Builder
+	for _, fn := range r.Functions {
+		_, _ = fmt.Fprintf(&text, "%s: %.2f%% -> %.2f%%\n", fn.Name, fn.Previous*100, fn.Current*100)
+	}
+	return &notifier.Event{
+		Type:     "regression",
+		TenantID: r.TenantID,
+		Title:    fmt.Sprintf("Performance regression in %s %s (previous version %s)", r.ServiceName, r.Version, r.PreviousVersion),
+		Text:     strings.TrimSuffix(text.String(), "\n"),
+		Diff: &notifier.Diff{
+			ProfileType:   r.ProfileType,
+			LeftSelector:  versionSelector(r.ServiceName, versionLabel, r.PreviousVersion),
+			LeftStart:     r.PreviousStart,
+			LeftEnd:       r.PreviousEnd,
+			RightSelector: versionSelector(r.ServiceName, versionLabel, r.Version),
+			RightStart:    r.Start,
+			RightEnd:      r.End,
+		},
+		Data: r,
+	}
+}
+
+// FunctionDelta is the share of the self samples of a function, between 0
+// and 1, in the previous and the latest version.
+type FunctionDelta struct {
+	Name     string  `json:"name"`
+	Previous float64 `json:"previous"`
+	Current  float64 `json:"current"`
+	Delta    float64 `json:"delta"`
+}
+
+// Detector periodically compares the profiles of the latest version of each
+// service with the profiles of its previous version, and reports the
+// functions whose share of the samples increased above the threshold.
+//
+// The versions are ordered by the time they were first seen: the latest
+// version is compared over the last window, the previous version over the
+// window before it was last seen. The latest version is compared once it has
+// been profiled for the min duration, and again every interval, over the
+// latest window, until a regression is reported. A regression is reported
+// once per pair of versions.
+type Detector struct {
+	services.Service
+
+	cfg      Config
+	client   QuerierClient
+	notifier notifier.Notifier
+	tenants  TenantsFunc
+	logger   log.Logger
+
+	mtx      sync.Mutex
+	reported map[string]string // tenant/service -> latest version regressed.
+
+	comparisons *prometheus.CounterVec
+	regressions *prometheus.CounterVec
+	failures    *prometheus.CounterVec
+}
+
+func New(cfg Config, client QuerierClient, n notifier.Notifier, tenants TenantsFunc, logger log.Logger, reg prometheus.Registerer) *Detector {
+	d := &Detector{
+		cfg:      cfg,
+		client:   client,
+		notifier: n,
+		tenants:  tenants,
+		logger:   logger,
+		reported: make(map[string]string),
+		comparisons: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Subsystem: "regression_detector",
+			Name:      "comparisons_total",
+			Help:      "The total number of comparisons between service versions.",
+		}, []string{"tenant"}),
+		regressions: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Subsystem: "regression_detector",
+			Name:      "regressions_total",
+			Help:      "The total number of regressions detected between service versions.",
+		}, []string{"tenant"}),
+		failures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Subsystem: "regression_detector",
+			Name:      "comparison_failures_total",
+			Help:      "The total number of failed comparisons between service versions.",
+		}, []string{"tenant"}),
+	}
+	d.Service = services.NewTimerService(cfg.Interval, nil, d.iteration, nil)
+	return d
+}
+
+func (d *Detector) iteration(ctx context.Context) error {
+	d.detect(ctx, time.Now())
+	return nil
+}
+
+// detect compares the versions of the services of all the tenants.
+// Failures are logged and do not stop the service.
+func (d *Detector) detect(ctx context.Context, now time.Time) {
+	tenants, err := d.tenants(ctx)
+	if err != nil {
+		level.Error(d.logger).Log("msg", "failed to list tenants", "err", err)
+		return
+	}
+	for _, tenantID := range tenants {
+		tenantCtx := tenant.InjectTenantID(ctx, tenantID)
+		serviceNames, err := d.serviceNames(tenantCtx, now)
+		if err != nil {
+			level.Warn(d.logger).Log("msg", "failed to list services", "tenant", tenantID, "err", err)
+			continue
+		}
+		for _, serviceName := range serviceNames {
+			r, err := d.compare(tenantCtx, tenantID, serviceName, now)
+			if err != nil {
+				d.failures.WithLabelValues(tenantID).Inc()
+				level.Warn(d.logger).Log("msg", "failed to compare service versions", "tenant", tenantID, "service", serviceName, "err", err)
+				continue
+			}
+			if r == nil {
+				continue
+			}
+			d.regressions.WithLabelValues(tenantID).Inc()
+			level.Info(d.logger).Log(
+				"msg", "regression detected",
+				"tenant", tenantID,
+				"service", serviceName,
+				"version", r.Version,
+				"previous_version", r.PreviousVersion,
+				"top_function", r.Functions[0].Name,
+				"delta", r.Functions[0].Delta,
+			)
+			if d.notifier != nil {
+				if err = d.notifier.Notify(ctx, r.event(d.cfg.VersionLabel)); err != nil {
+					level.Warn(d.logger).Log("msg", "failed to notify regression", "tenant", tenantID, "service", serviceName, "err", err)
+				}
+			}
+		}
+	}
+}
+
+func (d *Detector) serviceNames(ctx context.Context, now time.Time) ([]string, error) {
+	resp, err := d.client.LabelValues(ctx, connect.NewRequest(&typesv1.LabelValuesRequest{
+		Name:     phlaremodel.LabelNameServiceName,
+		Matchers: []string{fmt.Sprintf("{%s=%q}", phlaremodel.LabelNameProfileType, d.cfg.ProfileType)},
+		Start:    now.Add(-d.cfg.Lookback).UnixMilli(),
+		End:      now.UnixMilli(),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Msg.Names, nil
+}
+
+// compare returns the regression between the latest and the previous version
+// of the service, or nil if there is no regression, it has already been
+// reported, or the latest version has not been profiled long enough.
+func (d *Detector) compare(ctx context.Context, tenantID, serviceName string, now time.Time) (*Regression, error) {
+	latest, previous, err := d.versions(ctx, serviceName, now)
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil || previous == nil {
+		return nil, nil
+	}
+	key := tenantID + "/" + serviceName
+	d.mtx.Lock()
+	reported := d.reported[key] == latest.name
+	d.mtx.Unlock()
+	if reported || now.UnixMilli()-latest.firstSeen < d.cfg.MinDuration.Milliseconds() {
+		return nil, nil
+	}
+
+	d.comparisons.WithLabelValues(tenantID).Inc()
+	r := &Regression{
+		TenantID:        tenantID,
+		ServiceName:     serviceName,
+		ProfileType:     d.cfg.ProfileType,
+		Version:         latest.name,
+		PreviousVersion: previous.name,
+		End:             now.UnixMilli(),
+		PreviousEnd:     previous.lastSeen,
+	}
+	r.Start = max(latest.firstSeen, now.Add(-d.cfg.Window).UnixMilli())
+	r.PreviousStart = max(previous.firstSeen, previous.lastSeen-d.cfg.Window.Milliseconds())
+
+	current, err := d.functionShares(ctx, serviceName, latest.name, r.Start, r.End)
+	if err != nil {
+		return nil, err
+	}
+	before, err := d.functionShares(ctx, serviceName, previous.name, r.PreviousStart, r.PreviousEnd)
+	if err != nil {
+		return nil, err
+	}
+	r.Functions = regressedFunctions(before, current, d.cfg.Threshold, d.cfg.MaxFunctions)
+	if len(r.Functions) == 0 {
+		return nil, nil
+	}
+
+	d.mtx.Lock()
+	d.reported[key] = latest.name
+	d.mtx.Unlock()
+	return r, nil
+}
+
+type version struct {
+	name      string
+	firstSeen int64
+	lastSeen  int64
+}
+
+// versions returns the version first seen last, and the version last seen
+// before it.
+func (d *Detector) versions(ctx context.Context, serviceName string, now time.Time) (latest, previous *version, err error) {
+	resp, err := d.client.SelectSeries(ctx, connect.NewRequest(&querierv1.SelectSeriesRequest{
+		ProfileTypeID: d.cfg.ProfileType,
+		LabelSelector: fmt.Sprintf("{%s=%q}", phlaremodel.LabelNameServiceName, serviceName),
+		Start:         now.Add(-d.cfg.Lookback).UnixMilli(),
+		End:           now.UnixMilli(),
+		GroupBy:       []string{d.cfg.VersionLabel},
+		Step:          d.cfg.Interval.Seconds(),
+	}))
+	if err != nil {
+		return nil, nil, fmt.Errorf("selecting series: %w", err)
+	}
+	versions := make([]*version, 0, len(resp.Msg.Series))
+	for _, s := range resp.Msg.Series {
+		v := &version{name: phlaremodel.Labels(s.Labels).Get(d.cfg.VersionLabel)}
+		if v.name == "" || len(s.Points) == 0 {
+			continue
+		}
+		v.firstSeen = s.Points[0].Timestamp
+		v.lastSeen = s.Points[len(s.Points)-1].Timestamp
+		versions = append(versions, v)
+	}
+	if len(versions) < 2 {
+		return nil, nil, nil
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].firstSeen > versions[j].firstSeen
+	})
+	latest = versions[0]
+	for _, v := range versions[1:] {
+		if previous == nil || v.lastSeen > previous.lastSeen {
+			previous = v
+		}
+	}
+	return latest, previous, nil
+}
+
+// functionShares returns the share of the self samples of each function in
+// the profiles of the version.
+func (d *Detector) functionShares(ctx context.Context, serviceName, version string, start, end int64) (map[string]float64, error) {
+	resp, err := d.client.SelectMergeStacktraces(ctx, connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: d.cfg.ProfileType,
+		LabelSelector: versionSelector(serviceName, d.cfg.VersionLabel, version),
+		Start:         start,
+		End:           end,
+		Format:        querierv1.ProfileFormat_PROFILE_FORMAT_TREE,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("selecting stack traces: %w", err)
+	}
+	tree, err := phlaremodel.UnmarshalTree(resp.Msg.Tree)
+	if err != nil {
+		return nil, fmt.Errorf("decoding tree: %w", err)
+	}
+	total := float64(tree.Total())
+	shares := make(map[string]float64)
+	if total == 0 {
+		return shares, nil
+	}
+	tree.IterateStacks(func(name string, self int64, _ []string) {
+		shares[name] += float64(self) / total
+	})
+	return shares, nil
+}
+
+func versionSelector(serviceName, versionLabel, version string) string {
+	return fmt.Sprintf("{%s=%q,%s=%q}", phlaremodel.LabelNameServiceName, serviceName, versionLabel, version)
+}
+
+// regressedFunctions returns the functions whose share increased by at least
+// the threshold, ordered by the largest increase first.
+func regressedFunctions(before, current map[string]float64, threshold float64, limit int) []FunctionDelta {
+	var deltas []FunctionDelta
+	for name, share := range current {
+		delta := share - before[name]
+		if delta < threshold {
+			continue
+		}
+		deltas = append(deltas, FunctionDelta{
+			Name:     name,
+			Previous: before[name],
+			Current:  share,
+			Delta:    delta,
+		})
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		if deltas[i].Delta != deltas[j].Delta {
+			return deltas[i].Delta > deltas[j].Delta
+		}
+		return deltas[i].Name < deltas[j].Name
+	})
+	if limit > 0 && len(deltas) > limit {
+		deltas = deltas[:limit]
+	}
+	return deltas
+}
@@ -0,0 +1,187 @@
+package regression
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/notifier"
+)
+
+type fakeQuerierClient struct {
+	series []*typesv1.Series
+	// Trees by the version label value.
+	trees    map[string]*phlaremodel.Tree
+	selected []string
+}
+
+func (c *fakeQuerierClient) LabelValues(context.Context, *connect.Request[typesv1.LabelValuesRequest]) (*connect.Response[typesv1.LabelValuesResponse], error) {
+	return connect.NewResponse(&typesv1.LabelValuesResponse{Names: []string{"svc"}}), nil
+}
+
+func (c *fakeQuerierClient) SelectSeries(context.Context, *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error) {
+	return connect.NewResponse(&querierv1.SelectSeriesResponse{Series: c.series}), nil
+}
+
+func (c *fakeQuerierClient) SelectMergeStacktraces(_ context.Context, req *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+	c.selected = append(c.selected, req.Msg.LabelSelector)
+	for v, tree := range c.trees {
+		if strings.Contains(req.Msg.LabelSelector, `version="`+v+`"`) {
+			return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{Tree: tree.Bytes(-1)}), nil
+		}
+	}
+	return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{}), nil
+}
+
+type notifications []*notifier.Event
+
+func (n *notifications) Notify(_ context.Context, e *notifier.Event) error {
+	*n = append(*n, e)
+	return nil
+}
+
+func versionSeries(version string, timestamps ...int64) *typesv1.Series {
+	s := &typesv1.Series{Labels: []*typesv1.LabelPair{{Name: "version", Value: version}}}
+	for _, ts := range timestamps {
+		s.Points = append(s.Points, &typesv1.Point{Timestamp: ts, Value: 1})
+	}
+	return s
+}
+
+func testConfig() Config {
+	return Config{
+		Enabled:      true,
+		Interval:     10 * time.Minute,
+		Lookback:     24 * time.Hour,
+		Window:       time.Hour,
+		MinDuration:  30 * time.Minute,
+		VersionLabel: "version",
+		ProfileType:  "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		Threshold:    0.1,
+		MaxFunctions: 10,
+	}
+}
+
+func Test_Detector(t *testing.T) {
+	now := time.Unix(100000, 0)
+	before := new(phlaremodel.Tree)
+	before.InsertStack(8, "main", "handler")
+	before.InsertStack(2, "main", "encode")
+	after := new(phlaremodel.Tree)
+	after.InsertStack(5, "main", "handler")
+	after.InsertStack(5, "main", "encode")
+
+	client := &fakeQuerierClient{
+		series: []*typesv1.Series{
+			versionSeries("v1", 10000, 50000),
+			versionSeries("v0", 1000, 20000),
+			versionSeries("v2", 60000, 99000),
+		},
+		trees: map[string]*phlaremodel.Tree{"v1": before, "v2": after},
+	}
+	var n notifications
+	tenants := func(context.Context) ([]string, error) { return []string{"tenant-a"}, nil }
+	d := New(testConfig(), client, &n, tenants, log.NewNopLogger(), prometheus.NewRegistry())
+
+	d.detect(context.Background(), now)
+	require.Len(t, n, 1)
+	assert.Equal(t, "regression", n[0].Type)
+	assert.Equal(t, "Performance regression in svc v2 (previous version v1)", n[0].Title)
+	assert.Equal(t, "encode: 20.00% -> 50.00%", n[0].Text)
+	assert.Equal(t, `{service_name="svc",version="v1"}`, n[0].Diff.LeftSelector)
+	assert.Equal(t, `{service_name="svc",version="v2"}`, n[0].Diff.RightSelector)
+	r := n[0].Data.(*Regression)
+	require.Len(t, r.Functions, 1)
+	assert.Equal(t, "encode", r.Functions[0].Name)
+	assert.InDelta(t, 0.3, r.Functions[0].Delta, 1e-9)
+	r.Functions = nil
+	assert.Equal(t, &Regression{
+		TenantID:        "tenant-a",
+		ServiceName:     "svc",
+		ProfileType:     "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		Version:         "v2",
+		PreviousVersion: "v1",
+		Start:           now.Add(-time.Hour).UnixMilli(),
+		End:             now.UnixMilli(),
+		PreviousStart:   10000,
+		PreviousEnd:     50000,
+	}, r)
+	assert.Equal(t, []string{
+		`{service_name="svc",version="v2"}`,
+		`{service_name="svc",version="v1"}`,
+	}, client.selected)
+
+	// The regression is reported once.
+	d.detect(context.Background(), now.Add(time.Minute))
+	assert.Len(t, n, 1)
+}
+
+func Test_Detector_ReEvaluated(t *testing.T) {
+	now := time.Unix(100000, 0)
+	firstSeen := now.Add(-10 * time.Minute).UnixMilli()
+	before := new(phlaremodel.Tree)
+	before.InsertStack(8, "main", "handler")
+	before.InsertStack(2, "main", "encode")
+	client := &fakeQuerierClient{
+		series: []*typesv1.Series{
+			versionSeries("v1", 10000, 50000),
+			versionSeries("v2", firstSeen, now.UnixMilli()),
+		},
+		trees: map[string]*phlaremodel.Tree{"v1": before, "v2": before},
+	}
+	var n notifications
+	tenants := func(context.Context) ([]string, error) { return []string{"tenant-a"}, nil }
+	d := New(testConfig(), client, &n, tenants, log.NewNopLogger(), prometheus.NewRegistry())
+
+	// The latest version is not compared before the min duration.
+	d.detect(context.Background(), now)
+	assert.Empty(t, client.selected)
+
+	// No regression: the version is compared again on the next windows.
+	now = now.Add(30 * time.Minute)
+	d.detect(context.Background(), now)
+	assert.Len(t, client.selected, 2)
+	assert.Empty(t, n)
+
+	after := new(phlaremodel.Tree)
+	after.InsertStack(5, "main", "handler")
+	after.InsertStack(5, "main", "encode")
+	client.trees["v2"] = after
+	now = now.Add(10 * time.Minute)
+	d.detect(context.Background(), now)
+	assert.Len(t, client.selected, 4)
+	require.Len(t, n, 1)
+	assert.Equal(t, firstSeen, n[0].Data.(*Regression).Start)
+}
+
+func Test_Detector_SingleVersion(t *testing.T) {
+	client := &fakeQuerierClient{series: []*typesv1.Series{versionSeries("v1", 1000, 2000)}}
+	var n notifications
+	tenants := func(context.Context) ([]string, error) { return []string{"tenant-a"}, nil }
+	d := New(testConfig(), client, &n, tenants, log.NewNopLogger(), prometheus.NewRegistry())
+
+	d.detect(context.Background(), time.Unix(100000, 0))
+	assert.Empty(t, n)
+	assert.Empty(t, client.selected)
+}
+
+func Test_RegressedFunctions(t *testing.T) {
+	before := map[string]float64{"a": 0.5, "b": 0.25, "c": 0.25}
+	current := map[string]float64{"a": 0.125, "b": 0.5, "c": 0.25, "d": 0.125}
+	assert.Equal(t, []FunctionDelta{
+		{Name: "b", Previous: 0.25, Current: 0.5, Delta: 0.25},
+		{Name: "d", Previous: 0, Current: 0.125, Delta: 0.125},
+	}, regressedFunctions(before, current, 0.1, 10))
+	assert.Len(t, regressedFunctions(before, current, 0.1, 1), 1)
+	assert.Empty(t, regressedFunctions(before, current, 0.5, 10))
+}
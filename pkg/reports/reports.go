@@ -0,0 +1,385 @@
+package reports
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/notifier"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+// checkInterval is how frequently the reporter checks whether a report
+// period has ended.
+const checkInterval = time.Minute
+
+type Config struct {
+	Enabled              bool          `yaml:"enabled"`
+	Interval             time.Duration `yaml:"interval"`
+	ProfileType          string        `yaml:"profile_type"`
+	TopServices          int           `yaml:"top_services"`
+	TopFunctions         int           `yaml:"top_functions" category:"advanced"`
+	QueryFrontendAddress string        `yaml:"query_frontend_address"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "reports.enabled", false, "Enable the scheduled reports, summarizing the resource usage of the services of each tenant, sent through the notifier.")
+	f.DurationVar(&cfg.Interval, "reports.interval", 7*24*time.Hour, "How frequently the reports are sent. Each report covers the previous interval, compared with the interval before it. The weekly reports cover Monday 00:00 UTC to Monday 00:00 UTC.")
+	f.StringVar(&cfg.ProfileType, "reports.profile-type", "process_cpu:cpu:nanoseconds:cpu:nanoseconds", "The CPU profile type the usage of the services is computed from. The sample unit must be nanoseconds.")
+	f.IntVar(&cfg.TopServices, "reports.top-services", 10, "The number of services reported by CPU usage, and by change of CPU usage.")
+	f.IntVar(&cfg.TopFunctions, "reports.top-functions", 5, "The number of functions reported for each of the top services.")
+	f.StringVar(&cfg.QueryFrontendAddress, "reports.query-frontend.address", "", "The HTTP address of the query-frontend the profiles are queried from. If empty, the HTTP server of the local instance is queried.")
+}
+
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Interval < time.Hour {
+		return errors.New("the reports interval must be at least 1h")
+	}
+	pt, err := phlaremodel.ParseProfileTypeSelector(cfg.ProfileType)
+	if err != nil {
+		return fmt.Errorf("invalid reports profile type: %w", err)
+	}
+	if pt.SampleUnit != "nanoseconds" {
+		return fmt.Errorf("the reports profile type must have the nanoseconds sample unit, got %q", pt.SampleUnit)
+	}
+	if cfg.TopServices <= 0 {
+		return errors.New("the number of services in the reports must be positive")
+	}
+	return nil
+}
+
+type QuerierClient interface {
+	SelectSeries(context.Context, *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error)
+	SelectMergeStacktraces(context.Context, *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error)
+}
+
+// TenantsFunc returns the tenants the reports are sent for.
+type TenantsFunc func(ctx context.Context) ([]string, error)
+
+// Report summarizes the CPU usage of the services of a tenant over a
+// period. The time ranges are in milliseconds.
+type Report struct {
+	TenantID      string `json:"tenant_id"`
+	ProfileType   string `json:"profile_type"`
+	Start         int64  `json:"start"`
+	End           int64  `json:"end"`
+	PreviousStart int64  `json:"previous_start"`
+	PreviousEnd   int64  `json:"previous_end"`
+	// TopServices are the services using the most CPU cores, with their
+	// top functions.
+	TopServices []ServiceUsage `json:"top_services"`
+	// Movers are the services whose CPU usage changed the most since the
+	// previous period.
+	Movers []ServiceUsage `json:"movers"`
+}
+
+// ServiceUsage is the average number of CPU cores used by a service over
+// the period of the report, and over the previous period.
+type ServiceUsage struct {
+	ServiceName   string          `json:"service_name"`
+	Cores         float64         `json:"cores"`
+	PreviousCores float64         `json:"previous_cores"`
+	Functions     []FunctionShare `json:"functions,omitempty"`
+}
+
+func (u *ServiceUsage) delta() float64 { return u.Cores - u.PreviousCores }
+
+// FunctionShare is the share of the self samples of a function, between 0
+// and 1.
+type FunctionShare struct {
+	Name  string  `json:"name"`
+	Share float64 `json:"share"`
+}
+
+func (r *Report) event() *notifier.Event {
+	var text strings.Builder
+	text.WriteString("Top services by CPU cores:\n")
+	for _, s := range r.TopServices {
+		_, _ = fmt.Fprintf(&text, "%s: %.2f cores\n", s.ServiceName, s.Cores)
+		for _, fn := range s.Functions {
+			_, _ = fmt.Fprintf(&text, "  %s: %.2f%%\n", fn.Name, fn.Share*100)
+		}
+	}
+	if len(r.Movers) > 0 {
+		text.WriteString("\nBiggest changes from the previous period:\n")
+		for _, s := range r.Movers {
+			_, _ = fmt.Fprintf(&text, "%s: %.2f -> %.2f cores (%+.2f)\n", s.ServiceName, s.PreviousCores, s.Cores, s.delta())
+		}
+	}
+	const day = "2006-01-02"
+	return &notifier.Event{
+		Type:     "report",
+		TenantID: r.TenantID,
+		Title: fmt.Sprintf("Profiling report for %s, %s to %s",
+			r.TenantID, time.UnixMilli(r.Start).UTC().Format(day), time.UnixMilli(r.End).UTC().Format(day)),
+		Text: strings.TrimSuffix(text.String(), "\n"),
+		Data: r,
+	}
+}
+
+// Reporter sends a report for each tenant at the end of each interval,
+// through the notifier.
+//
+// The intervals are aligned to the multiples of the interval since the
+// zero time: no report is sent for the interval the reporter started in.
+type Reporter struct {
+	services.Service
+
+	cfg      Config
+	client   QuerierClient
+	notifier notifier.Notifier
+	tenants  TenantsFunc
+	logger   log.Logger
+
+	// last is the end of the last period reported.
+	last time.Time
+
+	sent     *prometheus.CounterVec
+	failures *prometheus.CounterVec
+}
+
+func New(cfg Config, client QuerierClient, n notifier.Notifier, tenants TenantsFunc, logger log.Logger, reg prometheus.Registerer) *Reporter {
+	r := &Reporter{
+		cfg:      cfg,
+		client:   client,
+		notifier: n,
+		tenants:  tenants,
+		logger:   logger,
+		sent: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Subsystem: "reports",
+			Name:      "sent_total",
+			Help:      "The total number of scheduled reports sent.",
+		}, []string{"tenant"}),
+		failures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Subsystem: "reports",
+			Name:      "failures_total",
+			Help:      "The total number of scheduled reports that failed to be generated or sent.",
+		}, []string{"tenant"}),
+	}
+	r.Service = services.NewTimerService(checkInterval, r.starting, r.iteration, nil)
+	return r
+}
+
+func (r *Reporter) starting(context.Context) error {
+	r.last = time.Now().Truncate(r.cfg.Interval)
+	return nil
+}
+
+func (r *Reporter) iteration(ctx context.Context) error {
+	end := time.Now().Truncate(r.cfg.Interval)
+	if !end.After(r.last) {
+		return nil
+	}
+	r.last = end
+	r.report(ctx, end)
+	return nil
+}
+
+// report sends the reports of all the tenants for the interval ending at
+// the given time. Failures are logged and do not stop the service.
+func (r *Reporter) report(ctx context.Context, end time.Time) {
+	tenants, err := r.tenants(ctx)
+	if err != nil {
+		level.Error(r.logger).Log("msg", "failed to list tenants", "err", err)
+		return
+	}
+	for _, tenantID := range tenants {
+		rep, err := r.generate(tenant.InjectTenantID(ctx, tenantID), tenantID, end)
+		if err != nil {
+			r.failures.WithLabelValues(tenantID).Inc()
+			level.Warn(r.logger).Log("msg", "failed to generate report", "tenant", tenantID, "err", err)
+			continue
+		}
+		if rep == nil {
+			continue
+		}
+		if err = r.notifier.Notify(ctx, rep.event()); err != nil {
+			r.failures.WithLabelValues(tenantID).Inc()
+			level.Warn(r.logger).Log("msg", "failed to send report", "tenant", tenantID, "err", err)
+			continue
+		}
+		r.sent.WithLabelValues(tenantID).Inc()
+		level.Info(r.logger).Log("msg", "report sent", "tenant", tenantID, "services", len(rep.TopServices))
+	}
+}
+
+// generate returns the report of the tenant for the interval ending at the
+// given time, or nil if the tenant has no profiles in the interval.
+func (r *Reporter) generate(ctx context.Context, tenantID string, end time.Time) (*Report, error) {
+	start := end.Add(-r.cfg.Interval)
+	previousStart := start.Add(-r.cfg.Interval)
+	current, err := r.serviceCores(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if len(current) == 0 {
+		return nil, nil
+	}
+	previous, err := r.serviceCores(ctx, previousStart, start)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make([]ServiceUsage, 0, len(current)+len(previous))
+	for name, cores := range current {
+		usage = append(usage, ServiceUsage{ServiceName: name, Cores: cores, PreviousCores: previous[name]})
+	}
+	for name, cores := range previous {
+		if _, ok := current[name]; !ok {
+			usage = append(usage, ServiceUsage{ServiceName: name, PreviousCores: cores})
+		}
+	}
+
+	rep := &Report{
+		TenantID:      tenantID,
+		ProfileType:   r.cfg.ProfileType,
+		Start:         start.UnixMilli(),
+		End:           end.UnixMilli(),
+		PreviousStart: previousStart.UnixMilli(),
+		PreviousEnd:   start.UnixMilli(),
+		TopServices:   topServices(usage, r.cfg.TopServices),
+		Movers:        movers(usage, r.cfg.TopServices),
+	}
+	for i := range rep.TopServices {
+		s := &rep.TopServices[i]
+		if s.Functions, err = r.topFunctions(ctx, s.ServiceName, start, end); err != nil {
+			return nil, err
+		}
+	}
+	return rep, nil
+}
+
+// serviceCores returns the average number of CPU cores used by each service
+// over the time range.
+func (r *Reporter) serviceCores(ctx context.Context, start, end time.Time) (map[string]float64, error) {
+	resp, err := r.client.SelectSeries(ctx, connect.NewRequest(&querierv1.SelectSeriesRequest{
+		ProfileTypeID: r.cfg.ProfileType,
+		LabelSelector: "{}",
+		Start:         start.UnixMilli(),
+		End:           end.UnixMilli(),
+		GroupBy:       []string{phlaremodel.LabelNameServiceName},
+		Step:          end.Sub(start).Seconds(),
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("selecting series: %w", err)
+	}
+	duration := float64(end.Sub(start).Nanoseconds())
+	cores := make(map[string]float64, len(resp.Msg.Series))
+	for _, s := range resp.Msg.Series {
+		name := phlaremodel.Labels(s.Labels).Get(phlaremodel.LabelNameServiceName)
+		if name == "" {
+			continue
+		}
+		for _, p := range s.Points {
+			cores[name] += p.Value / duration
+		}
+	}
+	return cores, nil
+}
+
+// topFunctions returns the functions with the largest share of the self
+// samples of the service.
+func (r *Reporter) topFunctions(ctx context.Context, serviceName string, start, end time.Time) ([]FunctionShare, error) {
+	if r.cfg.TopFunctions <= 0 {
+		return nil, nil
+	}
+	resp, err := r.client.SelectMergeStacktraces(ctx, connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: r.cfg.ProfileType,
+		LabelSelector: fmt.Sprintf("{%s=%q}", phlaremodel.LabelNameServiceName, serviceName),
+		Start:         start.UnixMilli(),
+		End:           end.UnixMilli(),
+		Format:        querierv1.ProfileFormat_PROFILE_FORMAT_TREE,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("selecting stack traces: %w", err)
+	}
+	tree, err := phlaremodel.UnmarshalTree(resp.Msg.Tree)
+	if err != nil {
+		return nil, fmt.Errorf("decoding tree: %w", err)
+	}
+	total := float64(tree.Total())
+	if total == 0 {
+		return nil, nil
+	}
+	shares := make(map[string]float64)
+	tree.IterateStacks(func(name string, self int64, _ []string) {
+		shares[name] += float64(self) / total
+	})
+	functions := make([]FunctionShare, 0, len(shares))
+	for name, share := range shares {
+		if share > 0 {
+			functions = append(functions, FunctionShare{Name: name, Share: share})
+		}
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].Share != functions[j].Share {
+			return functions[i].Share > functions[j].Share
+		}
+		return functions[i].Name < functions[j].Name
+	})
+	if len(functions) > r.cfg.TopFunctions {
+		functions = functions[:r.cfg.TopFunctions]
+	}
+	return functions, nil
+}
+
+// topServices returns the services using the most CPU cores.
+func topServices(usage []ServiceUsage, limit int) []ServiceUsage {
+	top := make([]ServiceUsage, 0, len(usage))
+	for _, u := range usage {
+		if u.Cores > 0 {
+			top = append(top, u)
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Cores != top[j].Cores {
+			return top[i].Cores > top[j].Cores
+		}
+		return top[i].ServiceName < top[j].ServiceName
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	return top
+}
+
+// movers returns the services whose CPU usage changed the most, in absolute
+// terms, including the services new or gone in the period.
+func movers(usage []ServiceUsage, limit int) []ServiceUsage {
+	m := make([]ServiceUsage, 0, len(usage))
+	for _, u := range usage {
+		if u.delta() != 0 {
+			m = append(m, u)
+		}
+	}
+	sort.Slice(m, func(i, j int) bool {
+		di, dj := math.Abs(m[i].delta()), math.Abs(m[j].delta())
+		if di != dj {
+			return di > dj
+		}
+		return m[i].ServiceName < m[j].ServiceName
+	})
+	if len(m) > limit {
+		m = m[:limit]
+	}
+	return m
+}
@@ -0,0 +1,138 @@
+package reports
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/notifier"
+)
+
+type fakeQuerierClient struct {
+	// Series by the start of the time range.
+	series map[int64][]*typesv1.Series
+	// Trees by the label selector.
+	trees map[string]*phlaremodel.Tree
+}
+
+func (c *fakeQuerierClient) SelectSeries(_ context.Context, req *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error) {
+	return connect.NewResponse(&querierv1.SelectSeriesResponse{Series: c.series[req.Msg.Start]}), nil
+}
+
+func (c *fakeQuerierClient) SelectMergeStacktraces(_ context.Context, req *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+	if tree, ok := c.trees[req.Msg.LabelSelector]; ok {
+		return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{Tree: tree.Bytes(-1)}), nil
+	}
+	return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{}), nil
+}
+
+type notifications []*notifier.Event
+
+func (n *notifications) Notify(_ context.Context, e *notifier.Event) error {
+	*n = append(*n, e)
+	return nil
+}
+
+// serviceSeries returns the series of a service using the given number of
+// cores over an hour.
+func serviceSeries(name string, cores float64) *typesv1.Series {
+	return &typesv1.Series{
+		Labels: []*typesv1.LabelPair{{Name: "service_name", Value: name}},
+		Points: []*typesv1.Point{{Value: cores * float64(time.Hour)}},
+	}
+}
+
+func testConfig() Config {
+	return Config{
+		Enabled:      true,
+		Interval:     time.Hour,
+		ProfileType:  "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		TopServices:  2,
+		TopFunctions: 1,
+	}
+}
+
+func Test_Reporter(t *testing.T) {
+	end := time.Unix(0, 0).Add(10 * time.Hour)
+	start := end.Add(-time.Hour)
+	tree := new(phlaremodel.Tree)
+	tree.InsertStack(3, "main", "handler")
+	tree.InsertStack(1, "main", "encode")
+
+	client := &fakeQuerierClient{
+		series: map[int64][]*typesv1.Series{
+			start.UnixMilli(): {
+				serviceSeries("api", 4),
+				serviceSeries("worker", 2),
+				serviceSeries("cron", 0.5),
+			},
+			start.Add(-time.Hour).UnixMilli(): {
+				serviceSeries("api", 3.5),
+				serviceSeries("worker", 0.5),
+				serviceSeries("batch", 1),
+			},
+		},
+		trees: map[string]*phlaremodel.Tree{`{service_name="api"}`: tree},
+	}
+	var n notifications
+	tenants := func(context.Context) ([]string, error) { return []string{"tenant-a", "tenant-b"}, nil }
+	r := New(testConfig(), client, &n, tenants, log.NewNopLogger(), prometheus.NewRegistry())
+
+	r.report(context.Background(), end)
+	// The tenants have the same profiles in the fake client.
+	require.Len(t, n, 2)
+	assert.Equal(t, "report", n[0].Type)
+	assert.Equal(t, "tenant-a", n[0].TenantID)
+	assert.Equal(t, "Profiling report for tenant-a, 1970-01-01 to 1970-01-01", n[0].Title)
+	assert.Equal(t, `Top services by CPU cores:
+api: 4.00 cores
+  handler: 75.00%
+worker: 2.00 cores
+
+Biggest changes from the previous period:
+worker: 0.50 -> 2.00 cores (+1.50)
+batch: 1.00 -> 0.00 cores (-1.00)`, n[0].Text)
+	assert.Equal(t, &Report{
+		TenantID:      "tenant-a",
+		ProfileType:   "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		Start:         start.UnixMilli(),
+		End:           end.UnixMilli(),
+		PreviousStart: start.Add(-time.Hour).UnixMilli(),
+		PreviousEnd:   start.UnixMilli(),
+		TopServices: []ServiceUsage{
+			{ServiceName: "api", Cores: 4, PreviousCores: 3.5, Functions: []FunctionShare{{Name: "handler", Share: 0.75}}},
+			{ServiceName: "worker", Cores: 2, PreviousCores: 0.5},
+		},
+		Movers: []ServiceUsage{
+			{ServiceName: "worker", Cores: 2, PreviousCores: 0.5},
+			{ServiceName: "batch", PreviousCores: 1},
+		},
+	}, n[0].Data)
+}
+
+func Test_Reporter_NoProfiles(t *testing.T) {
+	var n notifications
+	tenants := func(context.Context) ([]string, error) { return []string{"tenant-a"}, nil }
+	r := New(testConfig(), &fakeQuerierClient{}, &n, tenants, log.NewNopLogger(), prometheus.NewRegistry())
+	r.report(context.Background(), time.Unix(0, 0).Add(10*time.Hour))
+	assert.Empty(t, n)
+}
+
+func Test_Config_Validate(t *testing.T) {
+	cfg := testConfig()
+	require.NoError(t, cfg.Validate())
+	cfg.ProfileType = "memory:alloc_space:bytes:space:bytes"
+	require.Error(t, cfg.Validate())
+	cfg = testConfig()
+	cfg.Interval = time.Minute
+	require.Error(t, cfg.Validate())
+}
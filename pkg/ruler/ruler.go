@@ -0,0 +1,324 @@
+package ruler
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	settingsv1 "github.com/grafana/pyroscope/api/gen/proto/go/settings/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/experiment/metrics"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/notifier"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+type Config struct {
+	EvaluationInterval   time.Duration `yaml:"evaluation_interval"`
+	EvaluationDelay      time.Duration `yaml:"evaluation_delay" category:"advanced"`
+	QueryFrontendAddress string        `yaml:"query_frontend_address"`
+	QueryTimeout         time.Duration `yaml:"query_timeout" category:"advanced"`
+	RemoteWriteAddress   string        `yaml:"remote_write_address"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&cfg.EvaluationInterval, "ruler.evaluation-interval", time.Minute, "How frequently the recording rules are evaluated. Each evaluation covers the profiles of the previous interval.")
+	f.DurationVar(&cfg.EvaluationDelay, "ruler.evaluation-delay", time.Minute, "How far the evaluation window lags behind the current time, to account for profiles that are ingested late.")
+	f.StringVar(&cfg.QueryFrontendAddress, "ruler.query-frontend.address", "", "The HTTP address of the query-frontend the recording rules are evaluated with. If empty, the ruler queries the HTTP server of the local instance.")
+	f.DurationVar(&cfg.QueryTimeout, "ruler.query-timeout", time.Minute, "The timeout of the queries the recording rules are evaluated with.")
+	f.StringVar(&cfg.RemoteWriteAddress, "ruler.remote-write-address", "", "The Prometheus remote write endpoint the recorded metrics are sent to, for example http://prometheus:9090/api/v1/write. The ruler is disabled if empty.")
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.EvaluationInterval <= 0 {
+		return errors.New("the ruler evaluation interval must be positive")
+	}
+	if cfg.EvaluationDelay < 0 {
+		return errors.New("the ruler evaluation delay must not be negative")
+	}
+	if cfg.QueryTimeout <= 0 {
+		return errors.New("the ruler query timeout must be positive")
+	}
+	return nil
+}
+
+type Limits interface {
+	RecordingRules(tenantID string) []*settingsv1.RecordingRule
+}
+
+type QuerierClient interface {
+	SelectSeries(context.Context, *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error)
+	SelectMergeStacktraces(context.Context, *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error)
+}
+
+// TenantsFunc returns the tenants the recording rules are evaluated for.
+type TenantsFunc func(ctx context.Context) ([]string, error)
+
+// Ruler periodically evaluates the recording rules of the tenants, and
+// sends the results to a Prometheus remote write endpoint.
+//
+// A recording rule aggregates the values of the profiles matching the rule
+// selectors over the evaluation interval, by the group-by labels. If the rule
+// has a function name filter, only the samples of the stack traces that
+// include the function are counted.
+type Ruler struct {
+	services.Service
+
+	cfg      Config
+	client   QuerierClient
+	exporter metrics.Exporter
+	notifier notifier.Notifier
+	limits   Limits
+	tenants  TenantsFunc
+	logger   log.Logger
+
+	// failing holds the rules that failed in the previous evaluation, by
+	// tenant and metric name. The failure of a rule is notified once.
+	failing map[string]struct{}
+
+	evaluations        *prometheus.CounterVec
+	evaluationFailures *prometheus.CounterVec
+}
+
+func New(
+	cfg Config,
+	client QuerierClient,
+	exporter metrics.Exporter,
+	n notifier.Notifier,
+	limits Limits,
+	tenants TenantsFunc,
+	logger log.Logger,
+	reg prometheus.Registerer,
+) *Ruler {
+	r := &Ruler{
+		cfg:      cfg,
+		client:   client,
+		exporter: exporter,
+		notifier: n,
+		limits:   limits,
+		tenants:  tenants,
+		logger:   logger,
+		failing:  make(map[string]struct{}),
+		evaluations: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Subsystem: "ruler",
+			Name:      "rule_evaluations_total",
+			Help:      "The total number of recording rule evaluations.",
+		}, []string{"tenant"}),
+		evaluationFailures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Subsystem: "ruler",
+			Name:      "rule_evaluation_failures_total",
+			Help:      "The total number of recording rule evaluation failures.",
+		}, []string{"tenant"}),
+	}
+	r.Service = services.NewTimerService(cfg.EvaluationInterval, nil, r.iteration, r.stopping)
+	return r
+}
+
+func (r *Ruler) iteration(ctx context.Context) error {
+	end := time.Now().Add(-r.cfg.EvaluationDelay).Truncate(r.cfg.EvaluationInterval)
+	r.evaluate(ctx, end.Add(-r.cfg.EvaluationInterval), end)
+	return nil
+}
+
+func (r *Ruler) stopping(_ error) error {
+	r.exporter.Flush()
+	return nil
+}
+
+// evaluate evaluates the rules of all the tenants in the time range.
+// Failures are logged and do not stop the service.
+func (r *Ruler) evaluate(ctx context.Context, start, end time.Time) {
+	tenants, err := r.tenants(ctx)
+	if err != nil {
+		level.Error(r.logger).Log("msg", "failed to list tenants", "err", err)
+		return
+	}
+	for _, tenantID := range tenants {
+		rules := r.limits.RecordingRules(tenantID)
+		if len(rules) == 0 {
+			continue
+		}
+		tenantCtx := tenant.InjectTenantID(ctx, tenantID)
+		var series []prompb.TimeSeries
+		for _, rule := range rules {
+			r.evaluations.WithLabelValues(tenantID).Inc()
+			s, err := r.evaluateRule(tenantCtx, rule, start, end)
+			r.setFailing(ctx, tenantID, rule, err)
+			if err != nil {
+				r.evaluationFailures.WithLabelValues(tenantID).Inc()
+				level.Warn(r.logger).Log("msg", "failed to evaluate recording rule", "tenant", tenantID, "rule", rule.MetricName, "err", err)
+				continue
+			}
+			series = append(series, s...)
+		}
+		if len(series) > 0 {
+			if err = r.exporter.Send(tenantID, series); err != nil {
+				level.Error(r.logger).Log("msg", "failed to send recorded series", "tenant", tenantID, "err", err)
+			}
+		}
+	}
+	r.exporter.Flush()
+}
+
+// setFailing records the result of the rule evaluation, and notifies the
+// rules that start failing.
+func (r *Ruler) setFailing(ctx context.Context, tenantID string, rule *settingsv1.RecordingRule, err error) {
+	key := tenantID + "/" + rule.MetricName
+	_, failing := r.failing[key]
+	if err == nil {
+		delete(r.failing, key)
+		return
+	}
+	r.failing[key] = struct{}{}
+	if failing || r.notifier == nil {
+		return
+	}
+	e := &notifier.Event{
+		Type:     "recording_rule_failure",
+		TenantID: tenantID,
+		Title:    fmt.Sprintf("Recording rule %s failed", rule.MetricName),
+		Text:     err.Error(),
+		Data:     rule,
+	}
+	if err = r.notifier.Notify(ctx, e); err != nil {
+		level.Warn(r.logger).Log("msg", "failed to notify recording rule failure", "tenant", tenantID, "rule", rule.MetricName, "err", err)
+	}
+}
+
+func (r *Ruler) evaluateRule(ctx context.Context, rule *settingsv1.RecordingRule, start, end time.Time) ([]prompb.TimeSeries, error) {
+	rr, err := phlaremodel.NewRecordingRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	profileType, selector := splitMatchers(rr.Matchers)
+	resp, err := r.client.SelectSeries(ctx, connect.NewRequest(&querierv1.SelectSeriesRequest{
+		ProfileTypeID: profileType,
+		LabelSelector: selector.String(),
+		Start:         start.UnixMilli(),
+		End:           end.UnixMilli(),
+		GroupBy:       rr.GroupBy,
+		Step:          end.Sub(start).Seconds(),
+		Aggregation:   typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM.Enum(),
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("selecting series: %w", err)
+	}
+
+	result := make([]prompb.TimeSeries, 0, len(resp.Msg.Series))
+	for _, s := range resp.Msg.Series {
+		var value float64
+		if rr.FunctionName != "" {
+			if value, err = r.functionTotal(ctx, profileType, selector.withGroup(rr.GroupBy, s.Labels), rr.FunctionName, start, end); err != nil {
+				return nil, err
+			}
+		} else {
+			for _, p := range s.Points {
+				value += p.Value
+			}
+		}
+		result = append(result, newTimeSeries(rr, s.Labels, value, end.UnixMilli()))
+	}
+	return result, nil
+}
+
+// functionTotal returns the total of the samples of the stack traces that
+// include the function, in the profiles matching the selector.
+func (r *Ruler) functionTotal(ctx context.Context, profileType string, selector matchers, functionName string, start, end time.Time) (float64, error) {
+	resp, err := r.client.SelectMergeStacktraces(ctx, connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: profileType,
+		LabelSelector: selector.String(),
+		Start:         start.UnixMilli(),
+		End:           end.UnixMilli(),
+		Format:        querierv1.ProfileFormat_PROFILE_FORMAT_TREE,
+	}))
+	if err != nil {
+		return 0, fmt.Errorf("selecting stack traces: %w", err)
+	}
+	tree, err := phlaremodel.UnmarshalTree(resp.Msg.Tree)
+	if err != nil {
+		return 0, fmt.Errorf("decoding tree: %w", err)
+	}
+	var total int64
+	tree.IterateStacks(func(_ string, self int64, stack []string) {
+		if slices.Contains(stack, functionName) {
+			total += self
+		}
+	})
+	return float64(total), nil
+}
+
+type matchers []*labels.Matcher
+
+// splitMatchers returns the profile type and the remaining matchers.
+// The recording rule guarantees the presence of the profile type matcher.
+func splitMatchers(ms []*labels.Matcher) (profileType string, rest matchers) {
+	for _, m := range ms {
+		if m.Name == phlaremodel.LabelNameProfileType {
+			profileType = m.Value
+			continue
+		}
+		rest = append(rest, m)
+	}
+	return profileType, rest
+}
+
+// withGroup returns the matchers selecting the group of the series.
+func (ms matchers) withGroup(groupBy []string, series []*typesv1.LabelPair) matchers {
+	group := make(matchers, 0, len(ms)+len(groupBy))
+	group = append(group, ms...)
+	for _, name := range groupBy {
+		var value string
+		for _, l := range series {
+			if l.Name == name {
+				value = l.Value
+				break
+			}
+		}
+		group = append(group, labels.MustNewMatcher(labels.MatchEqual, name, value))
+	}
+	return group
+}
+
+func (ms matchers) String() string {
+	s := make([]string, len(ms))
+	for i, m := range ms {
+		s[i] = m.String()
+	}
+	return "{" + strings.Join(s, ",") + "}"
+}
+
+func newTimeSeries(rule *phlaremodel.RecordingRule, series []*typesv1.LabelPair, value float64, timestamp int64) prompb.TimeSeries {
+	ls := make(labels.Labels, 0, len(rule.ExternalLabels)+len(series))
+	ls = append(ls, rule.ExternalLabels...)
+	for _, l := range series {
+		if slices.Contains(rule.GroupBy, l.Name) {
+			ls = append(ls, labels.Label{Name: l.Name, Value: l.Value})
+		}
+	}
+	sort.Sort(ls)
+	pbLabels := make([]prompb.Label, len(ls))
+	for i, l := range ls {
+		pbLabels[i] = prompb.Label{Name: l.Name, Value: l.Value}
+	}
+	return prompb.TimeSeries{
+		Labels:  pbLabels,
+		Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+	}
+}
@@ -0,0 +1,181 @@
+package ruler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	settingsv1 "github.com/grafana/pyroscope/api/gen/proto/go/settings/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/notifier"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/test/mocks/mockmetrics"
+)
+
+type rulesLimits map[string][]*settingsv1.RecordingRule
+
+func (l rulesLimits) RecordingRules(tenantID string) []*settingsv1.RecordingRule { return l[tenantID] }
+
+type fakeQuerierClient struct {
+	series  []*typesv1.Series
+	tree    *phlaremodel.Tree
+	seriesR []*querierv1.SelectSeriesRequest
+	stacksR []*querierv1.SelectMergeStacktracesRequest
+}
+
+func (c *fakeQuerierClient) SelectSeries(ctx context.Context, req *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error) {
+	if _, err := tenant.ExtractTenantIDFromContext(ctx); err != nil {
+		return nil, err
+	}
+	c.seriesR = append(c.seriesR, req.Msg)
+	return connect.NewResponse(&querierv1.SelectSeriesResponse{Series: c.series}), nil
+}
+
+func (c *fakeQuerierClient) SelectMergeStacktraces(ctx context.Context, req *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+	if _, err := tenant.ExtractTenantIDFromContext(ctx); err != nil {
+		return nil, err
+	}
+	c.stacksR = append(c.stacksR, req.Msg)
+	return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{Tree: c.tree.Bytes(-1)}), nil
+}
+
+type notifications []*notifier.Event
+
+func (n *notifications) Notify(_ context.Context, e *notifier.Event) error {
+	*n = append(*n, e)
+	return nil
+}
+
+func Test_Ruler_Evaluate(t *testing.T) {
+	const profileType = "process_cpu:cpu:nanoseconds:cpu:nanoseconds"
+	client := &fakeQuerierClient{
+		series: []*typesv1.Series{
+			{
+				Labels: []*typesv1.LabelPair{{Name: "service_name", Value: "svc-a"}},
+				Points: []*typesv1.Point{{Value: 1}, {Value: 2}},
+			},
+			{
+				Labels: []*typesv1.LabelPair{{Name: "service_name", Value: "svc-b"}},
+				Points: []*typesv1.Point{{Value: 5}},
+			},
+		},
+	}
+	limits := rulesLimits{
+		"tenant-a": {{
+			MetricName: "profiles_cpu_total",
+			Matchers:   []string{`{__profile_type__="` + profileType + `", env="prod"}`},
+			GroupBy:    []string{"service_name"},
+		}},
+	}
+	tenants := func(context.Context) ([]string, error) { return []string{"tenant-a", "tenant-b"}, nil }
+
+	exporter := new(mockmetrics.MockExporter)
+	var sent []prompb.TimeSeries
+	exporter.On("Send", "tenant-a", mock.Anything).Run(func(args mock.Arguments) {
+		sent = args.Get(1).([]prompb.TimeSeries)
+	}).Return(nil).Once()
+	exporter.On("Flush").Return()
+
+	r := New(Config{EvaluationInterval: time.Minute}, client, exporter, nil, limits, tenants, log.NewNopLogger(), prometheus.NewRegistry())
+	end := time.Unix(120, 0)
+	r.evaluate(context.Background(), end.Add(-time.Minute), end)
+	exporter.AssertExpectations(t)
+
+	require.Len(t, client.seriesR, 1)
+	assert.Equal(t, profileType, client.seriesR[0].ProfileTypeID)
+	assert.Equal(t, `{env="prod"}`, client.seriesR[0].LabelSelector)
+	assert.Equal(t, float64(60), client.seriesR[0].Step)
+	assert.Equal(t, []prompb.TimeSeries{
+		{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "profiles_cpu_total"},
+				{Name: "service_name", Value: "svc-a"},
+			},
+			Samples: []prompb.Sample{{Value: 3, Timestamp: end.UnixMilli()}},
+		},
+		{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "profiles_cpu_total"},
+				{Name: "service_name", Value: "svc-b"},
+			},
+			Samples: []prompb.Sample{{Value: 5, Timestamp: end.UnixMilli()}},
+		},
+	}, sent)
+}
+
+func Test_Ruler_EvaluateFunctionFilter(t *testing.T) {
+	tree := new(phlaremodel.Tree)
+	tree.InsertStack(1, "main", "foo")
+	tree.InsertStack(2, "main", "foo", "bar")
+	tree.InsertStack(4, "main", "baz")
+	client := &fakeQuerierClient{
+		tree: tree,
+		series: []*typesv1.Series{{
+			Labels: []*typesv1.LabelPair{{Name: "service_name", Value: "svc-a"}},
+			Points: []*typesv1.Point{{Value: 7}},
+		}},
+	}
+	limits := rulesLimits{
+		"tenant-a": {{
+			MetricName: "profiles_cpu_foo_total",
+			Matchers:   []string{`{__profile_type__="process_cpu:cpu:nanoseconds:cpu:nanoseconds"}`},
+			GroupBy:    []string{"service_name"},
+			StacktraceFilter: &settingsv1.StacktraceFilter{
+				FunctionName: &settingsv1.StacktraceFilterFunctionName{FunctionName: "foo"},
+			},
+		}},
+	}
+	tenants := func(context.Context) ([]string, error) { return []string{"tenant-a"}, nil }
+
+	exporter := new(mockmetrics.MockExporter)
+	var sent []prompb.TimeSeries
+	exporter.On("Send", "tenant-a", mock.Anything).Run(func(args mock.Arguments) {
+		sent = args.Get(1).([]prompb.TimeSeries)
+	}).Return(nil).Once()
+	exporter.On("Flush").Return()
+
+	r := New(Config{EvaluationInterval: time.Minute}, client, exporter, nil, limits, tenants, log.NewNopLogger(), prometheus.NewRegistry())
+	end := time.Unix(120, 0)
+	r.evaluate(context.Background(), end.Add(-time.Minute), end)
+	exporter.AssertExpectations(t)
+
+	require.Len(t, client.stacksR, 1)
+	assert.Equal(t, `{service_name="svc-a"}`, client.stacksR[0].LabelSelector)
+	require.Len(t, sent, 1)
+	assert.Equal(t, float64(3), sent[0].Samples[0].Value)
+}
+
+func Test_Ruler_InvalidRule(t *testing.T) {
+	client := &fakeQuerierClient{}
+	limits := rulesLimits{
+		"tenant-a": {{MetricName: "profiles_total", Matchers: []string{`{service_name="svc-a"}`}}},
+	}
+	tenants := func(context.Context) ([]string, error) { return []string{"tenant-a"}, nil }
+
+	exporter := new(mockmetrics.MockExporter)
+	exporter.On("Flush").Return()
+
+	var n notifications
+	r := New(Config{EvaluationInterval: time.Minute}, client, exporter, &n, limits, tenants, log.NewNopLogger(), prometheus.NewRegistry())
+	r.evaluate(context.Background(), time.Unix(0, 0), time.Unix(60, 0))
+	exporter.AssertExpectations(t)
+	assert.Empty(t, client.seriesR)
+	require.Len(t, n, 1)
+	assert.Equal(t, "recording_rule_failure", n[0].Type)
+	assert.Equal(t, "tenant-a", n[0].TenantID)
+	assert.Equal(t, "Recording rule profiles_total failed", n[0].Title)
+
+	// The failure is notified once.
+	r.evaluate(context.Background(), time.Unix(60, 0), time.Unix(120, 0))
+	assert.Len(t, n, 1)
+}
@@ -0,0 +1,308 @@
+package annotations
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/tenant"
+	"github.com/oklog/ulid"
+	"github.com/thanos-io/objstore"
+
+	"github.com/grafana/pyroscope/pkg/og/util/attime"
+	"github.com/grafana/pyroscope/pkg/settings/store"
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// allow to overide time for testing
+var timeNow = time.Now
+
+const (
+	maxTextLength   = 1024
+	maxTypeLength   = 64
+	maxLabels       = 16
+	readerCacheTTL  = time.Minute
+	defaultListFrom = "now-1h"
+)
+
+// Annotation marks an event of a tenant, such as a deploy, an incident, or a
+// feature flag change, to correlate it with the changes of the profiles.
+type Annotation struct {
+	ID string `json:"id"`
+	// ServiceName is the service the annotation applies to. If empty, the
+	// annotation applies to all the services of the tenant.
+	ServiceName string `json:"serviceName,omitempty"`
+	// Type of the event, for example, deploy, incident, or feature_flag.
+	Type string `json:"type"`
+	Text string `json:"text"`
+	// Time of the event, and its end for the events lasting over a period,
+	// in milliseconds since the epoch.
+	Time    int64             `json:"time"`
+	EndTime int64             `json:"endTime,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+
+	Generation int64 `json:"generation"`
+}
+
+func (a *Annotation) validate() error {
+	switch {
+	case a.Text == "":
+		return errors.New("text is required")
+	case len(a.Text) > maxTextLength:
+		return fmt.Errorf("text is longer than %d characters", maxTextLength)
+	case a.Type == "":
+		return errors.New("type is required")
+	case len(a.Type) > maxTypeLength:
+		return fmt.Errorf("type is longer than %d characters", maxTypeLength)
+	case len(a.Labels) > maxLabels:
+		return fmt.Errorf("annotation has more than %d labels", maxLabels)
+	case a.EndTime != 0 && a.EndTime < a.Time:
+		return errors.New("end time is before the time of the annotation")
+	}
+	return nil
+}
+
+func (a *Annotation) overlaps(start, end int64) bool {
+	endTime := a.EndTime
+	if endTime == 0 {
+		endTime = a.Time
+	}
+	return a.Time <= end && endTime >= start
+}
+
+// filter returns the annotations overlapping the time range, ordered by time.
+func filter(elements []*Annotation, start, end int64) []Annotation {
+	result := make([]Annotation, 0)
+	for _, a := range elements {
+		if a.overlaps(start, end) {
+			result = append(result, *a)
+		}
+	}
+	slices.SortStableFunc(result, func(x, y Annotation) int {
+		return cmp.Compare(x.Time, y.Time)
+	})
+	return result
+}
+
+// Annotations stores the annotations of the tenants, and serves the API to
+// create, list, and delete them.
+type Annotations struct {
+	cfg    Config
+	bucket objstore.Bucket
+	logger log.Logger
+
+	lck    sync.RWMutex
+	stores map[store.Key]*bucketStore
+}
+
+func New(cfg Config, bucket objstore.Bucket, logger log.Logger) *Annotations {
+	return &Annotations{
+		cfg:    cfg,
+		bucket: bucket,
+		logger: logger,
+		stores: make(map[store.Key]*bucketStore),
+	}
+}
+
+func (a *Annotations) storeForTenant(ctx context.Context) (*bucketStore, error) {
+	tenantID, err := tenant.TenantID(ctx)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, err)
+	}
+	k := store.Key{TenantID: tenantID}
+
+	a.lck.RLock()
+	s, ok := a.stores[k]
+	a.lck.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	a.lck.Lock()
+	defer a.lck.Unlock()
+	if s, ok = a.stores[k]; !ok {
+		s = newBucketStore(a.logger, a.bucket, k)
+		a.stores[k] = s
+	}
+	return s, nil
+}
+
+// Create stores the annotation, and deletes the oldest annotations of the
+// tenant above the limit.
+func (a *Annotations) Create(ctx context.Context, annotation *Annotation) error {
+	if err := annotation.validate(); err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	s, err := a.storeForTenant(ctx)
+	if err != nil {
+		return err
+	}
+	annotation.ID = ulid.MustNew(ulid.Timestamp(timeNow()), rand.Reader).String()
+	annotation.Generation = 1
+	return s.Update(ctx, func(_ context.Context, coll *store.Collection[*Annotation]) error {
+		coll.Elements = append(coll.Elements, annotation)
+		if n := len(coll.Elements) - a.cfg.MaxAnnotationsPerTenant; n > 0 {
+			slices.SortStableFunc(coll.Elements, func(x, y *Annotation) int {
+				return cmp.Compare(x.Time, y.Time)
+			})
+			coll.Elements = coll.Elements[n:]
+		}
+		return nil
+	})
+}
+
+// List returns the annotations of the tenant overlapping the time range.
+func (a *Annotations) List(ctx context.Context, start, end int64) ([]Annotation, error) {
+	s, err := a.storeForTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result []Annotation
+	err = s.Read(ctx, func(_ context.Context, coll *store.Collection[*Annotation]) error {
+		result = filter(coll.Elements, start, end)
+		return nil
+	})
+	return result, err
+}
+
+func (a *Annotations) Delete(ctx context.Context, id string) error {
+	s, err := a.storeForTenant(ctx)
+	if err != nil {
+		return err
+	}
+	if err = s.Delete(ctx, id); errors.Is(err, store.ErrElementNotFound) {
+		return connect.NewError(connect.CodeNotFound, fmt.Errorf("annotation %s not found", id))
+	}
+	return err
+}
+
+type ListResponse struct {
+	Annotations []Annotation `json:"annotations"`
+}
+
+// ServeHTTP serves the annotations API: POST creates the annotation of the
+// request body, GET lists the annotations overlapping the from and until
+// time range, and DELETE deletes the annotation with the id parameter.
+func (a *Annotations) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	switch req.Method {
+	case http.MethodPost:
+		var annotation Annotation
+		if err := json.NewDecoder(req.Body).Decode(&annotation); err != nil {
+			httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+			return
+		}
+		if annotation.Time == 0 {
+			annotation.Time = timeNow().UnixMilli()
+		}
+		if err := a.Create(ctx, &annotation); err != nil {
+			level.Warn(a.logger).Log("msg", "failed to create annotation", "err", err)
+			httputil.Error(w, err)
+			return
+		}
+		writeJSON(w, annotation)
+
+	case http.MethodGet:
+		if err := req.ParseForm(); err != nil {
+			httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, err))
+			return
+		}
+		from, until := req.Form.Get("from"), req.Form.Get("until")
+		if from == "" {
+			from = defaultListFrom
+		}
+		if until == "" {
+			until = "now"
+		}
+		start, end := attime.Parse(from).UnixMilli(), attime.Parse(until).UnixMilli()
+		annotations, err := a.List(ctx, start, end)
+		if err != nil {
+			httputil.Error(w, err)
+			return
+		}
+		if serviceName := req.Form.Get("service_name"); serviceName != "" {
+			annotations = slices.DeleteFunc(annotations, func(a Annotation) bool {
+				return a.ServiceName != "" && a.ServiceName != serviceName
+			})
+		}
+		writeJSON(w, ListResponse{Annotations: annotations})
+
+	case http.MethodDelete:
+		id := req.URL.Query().Get("id")
+		if id == "" {
+			httputil.Error(w, connect.NewError(connect.CodeInvalidArgument, errors.New("id is required")))
+			return
+		}
+		if err := a.Delete(ctx, id); err != nil {
+			httputil.Error(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		httputil.Error(w, err)
+	}
+}
+
+// Reader reads the annotations of the tenants in the query path. The
+// annotations are written by the tenant-settings component, possibly in
+// another process: they are reloaded from the bucket after a minute.
+type Reader struct {
+	bucket objstore.Bucket
+	logger log.Logger
+
+	mtx   sync.Mutex
+	cache map[string]cachedAnnotations
+}
+
+type cachedAnnotations struct {
+	loaded   time.Time
+	elements []*Annotation
+}
+
+func NewReader(bucket objstore.Bucket, logger log.Logger) *Reader {
+	return &Reader{
+		bucket: bucket,
+		logger: logger,
+		cache:  make(map[string]cachedAnnotations),
+	}
+}
+
+// List returns the annotations of the tenant overlapping the time range.
+func (r *Reader) List(ctx context.Context, start, end int64) ([]Annotation, error) {
+	tenantID, err := tenant.TenantID(ctx)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, err)
+	}
+	r.mtx.Lock()
+	c, ok := r.cache[tenantID]
+	r.mtx.Unlock()
+	if !ok || timeNow().Sub(c.loaded) > readerCacheTTL {
+		coll, err := newBucketStore(r.logger, r.bucket, store.Key{TenantID: tenantID}).Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c = cachedAnnotations{loaded: timeNow(), elements: coll.Elements}
+		r.mtx.Lock()
+		r.cache[tenantID] = c
+		r.mtx.Unlock()
+	}
+	return filter(c.elements, start, end), nil
+}
@@ -0,0 +1,116 @@
+package annotations
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+)
+
+func Test_Annotations(t *testing.T) {
+	bucket := objstore.NewInMemBucket()
+	a := New(Config{Enabled: true, MaxAnnotationsPerTenant: 2}, bucket, log.NewNopLogger())
+	ctx := user.InjectOrgID(context.Background(), "user-a")
+	ctxB := user.InjectOrgID(context.Background(), "user-b")
+
+	deploy := &Annotation{ServiceName: "checkout", Type: "deploy", Text: "v1", Time: 1000}
+	require.NoError(t, a.Create(ctx, deploy))
+	require.NotEmpty(t, deploy.ID)
+	incident := &Annotation{Type: "incident", Text: "outage", Time: 2000, EndTime: 5000}
+	require.NoError(t, a.Create(ctx, incident))
+
+	list, err := a.List(ctx, 0, 10000)
+	require.NoError(t, err)
+	assert.Equal(t, []Annotation{*deploy, *incident}, list)
+
+	// The incident overlaps the time range.
+	list, err = a.List(ctx, 3000, 4000)
+	require.NoError(t, err)
+	assert.Equal(t, []Annotation{*incident}, list)
+
+	list, err = a.List(ctxB, 0, 10000)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	// The oldest annotation is deleted above the limit.
+	require.NoError(t, a.Create(ctx, &Annotation{Type: "deploy", Text: "v2", Time: 3000}))
+	list, err = a.List(ctx, 0, 10000)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, incident.ID, list[0].ID)
+
+	require.NoError(t, a.Delete(ctx, incident.ID))
+	err = a.Delete(ctx, incident.ID)
+	assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+	err = a.Create(ctx, &Annotation{Type: "deploy"})
+	assert.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
+
+func Test_Annotations_HTTP(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	a := New(Config{Enabled: true, MaxAnnotationsPerTenant: 10}, objstore.NewInMemBucket(), log.NewNopLogger())
+	ctx := user.InjectOrgID(context.Background(), "user-a")
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/settings/v1/annotations", strings.NewReader(`{"serviceName":"checkout","type":"deploy","text":"v1"}`))
+	a.ServeHTTP(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusOK, w.Code)
+	var created Annotation
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.Equal(t, now.UnixMilli(), created.Time)
+
+	for serviceName, expected := range map[string]int{"": 1, "checkout": 1, "cart": 0} {
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, "/settings/v1/annotations?from=1699990000000&until=1700000001000&service_name="+serviceName, nil)
+		a.ServeHTTP(w, req.WithContext(ctx))
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp ListResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Len(t, resp.Annotations, expected, serviceName)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "/settings/v1/annotations?id="+created.ID, nil)
+	a.ServeHTTP(w, req.WithContext(ctx))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func Test_Reader(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	bucket := objstore.NewInMemBucket()
+	a := New(Config{Enabled: true, MaxAnnotationsPerTenant: 10}, bucket, log.NewNopLogger())
+	r := NewReader(bucket, log.NewNopLogger())
+	ctx := user.InjectOrgID(context.Background(), "user-a")
+
+	require.NoError(t, a.Create(ctx, &Annotation{Type: "deploy", Text: "v1", Time: 1000}))
+	list, err := r.List(ctx, 0, 10000)
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+
+	// The annotations are reloaded after the cache TTL.
+	require.NoError(t, a.Create(ctx, &Annotation{Type: "deploy", Text: "v2", Time: 2000}))
+	list, err = r.List(ctx, 0, 10000)
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+	now = now.Add(readerCacheTTL + time.Second)
+	list, err = r.List(ctx, 0, 10000)
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+}
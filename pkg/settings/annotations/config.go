@@ -0,0 +1,42 @@
+package annotations
+
+import (
+	"flag"
+	"fmt"
+)
+
+type Config struct {
+	Enabled                 bool `yaml:"enabled" category:"experimental"`
+	MaxAnnotationsPerTenant int  `yaml:"max_annotations_per_tenant" category:"experimental"`
+}
+
+const (
+	flagPrefix                  = "tenant-settings.annotations."
+	flagEnabled                 = flagPrefix + "enabled"
+	flagMaxAnnotationsPerTenant = flagPrefix + "max-annotations-per-tenant"
+)
+
+func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(
+		&cfg.Enabled,
+		flagEnabled,
+		false,
+		"Enable the storing of annotations, such as deploy markers, in tenant settings. The annotations are returned with the timelines of the render API.",
+	)
+	fs.IntVar(
+		&cfg.MaxAnnotationsPerTenant,
+		flagMaxAnnotationsPerTenant,
+		10000,
+		"The maximum number of annotations stored per tenant. The oldest annotations are deleted when the limit is reached.",
+	)
+}
+
+func (cfg *Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MaxAnnotationsPerTenant <= 0 {
+		return fmt.Errorf("%s must be positive", flagMaxAnnotationsPerTenant)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package annotations
+
+import (
+	"encoding/json"
+
+	"github.com/go-kit/log"
+	"github.com/thanos-io/objstore"
+
+	"github.com/grafana/pyroscope/pkg/settings/store"
+)
+
+type bucketStore = store.GenericStore[*Annotation, *storeHelper]
+
+func newBucketStore(logger log.Logger, bucket objstore.Bucket, key store.Key) *bucketStore {
+	return store.New(logger, bucket, key, &storeHelper{})
+}
+
+type storeHelper struct{}
+
+func (*storeHelper) ID(a *Annotation) string { return a.ID }
+
+func (*storeHelper) GetGeneration(a *Annotation) int64 { return a.Generation }
+
+func (*storeHelper) SetGeneration(a *Annotation, v int64) { a.Generation = v }
+
+func (*storeHelper) FromStore(storeBytes json.RawMessage) (*Annotation, error) {
+	var a Annotation
+	if err := json.Unmarshal(storeBytes, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (*storeHelper) ToStore(a *Annotation) (json.RawMessage, error) {
+	return json.Marshal(a)
+}
+
+func (*storeHelper) TypePath() string { return "settings/annotations.v1" }
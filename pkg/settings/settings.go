@@ -16,24 +16,28 @@ import (
 
 	settingsv1 "github.com/grafana/pyroscope/api/gen/proto/go/settings/v1"
 	"github.com/grafana/pyroscope/api/gen/proto/go/settings/v1/settingsv1connect"
+	"github.com/grafana/pyroscope/pkg/settings/annotations"
 	"github.com/grafana/pyroscope/pkg/settings/collection"
 	"github.com/grafana/pyroscope/pkg/settings/recording"
 )
 
 type Config struct {
-	Collection collection.Config `yaml:"collection_rules"`
-	Recording  recording.Config  `yaml:"recording_rules"`
+	Collection  collection.Config  `yaml:"collection_rules"`
+	Recording   recording.Config   `yaml:"recording_rules"`
+	Annotations annotations.Config `yaml:"annotations"`
 }
 
 func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
 	cfg.Collection.RegisterFlags(fs)
 	cfg.Recording.RegisterFlags(fs)
+	cfg.Annotations.RegisterFlags(fs)
 }
 
 func (cfg *Config) Validate() error {
 	return errors.Join(
 		cfg.Collection.Validate(),
 		cfg.Recording.Validate(),
+		cfg.Annotations.Validate(),
 	)
 }
 
@@ -60,6 +64,10 @@ func New(cfg Config, bucket objstore.Bucket, logger log.Logger) (*TenantSettings
 		ts.RecordingRulesServiceHandler = recording.New(cfg.Recording, bucket, logger)
 	}
 
+	if cfg.Annotations.Enabled {
+		ts.Annotations = annotations.New(cfg.Annotations, bucket, logger)
+	}
+
 	ts.Service = services.NewBasicService(ts.starting, ts.running, ts.stopping)
 
 	return ts, nil
@@ -70,6 +78,9 @@ type TenantSettings struct {
 	settingsv1connect.CollectionRulesServiceHandler
 	settingsv1connect.RecordingRulesServiceHandler
 
+	// Annotations is nil if the annotations are not enabled.
+	Annotations *annotations.Annotations
+
 	store  store
 	logger log.Logger
 }
@@ -43,6 +43,10 @@ type BucketStore struct {
 
 	metrics *Metrics
 	stats   BucketStoreStats
+
+	indexHeaders *indexHeaderPool
+	// If lazy loading is enabled, the blocks are only opened when queried.
+	lazyLoading bool
 }
 
 func NewBucketStore(bucket phlareobj.Bucket, fetcher block.MetadataFetcher, tenantID string, syncDir string, logger log.Logger, reg prometheus.Registerer) (*BucketStore, error) {
@@ -58,6 +62,7 @@ func NewBucketStore(bucket phlareobj.Bucket, fetcher block.MetadataFetcher, tena
 			prometheus.Labels{"tenant": tenantID},
 			reg,
 		)),
+		indexHeaders: newIndexHeaderPool(IndexHeaderConfig{}, logger, nil),
 	}
 
 	if err := os.MkdirAll(syncDir, 0o750); err != nil {
@@ -192,9 +197,9 @@ func (bs *BucketStore) addBlock(ctx context.Context, meta *block.Meta) (err erro
 	if err != nil {
 		return err
 	}
-	// Load the block into memory if it's within the last 24 hours.
-	// Todo make this configurable
-	if phlaredb.InRange(b, model.Now().Add(-24*time.Hour), model.Now()) {
+	// Load the block into memory if it's within the last 24 hours,
+	// unless the blocks are loaded lazily on the first query.
+	if !bs.lazyLoading && phlaredb.InRange(b, model.Now().Add(-24*time.Hour), model.Now()) {
 		level.Debug(bs.logger).Log("msg", "opening block",
 			"id", meta.ULID.String(),
 			"min", b.meta.MinTime.Time().Format(time.RFC3339),
@@ -205,7 +210,10 @@ func (bs *BucketStore) addBlock(ctx context.Context, meta *block.Meta) (err erro
 		defer func() {
 			level.Info(bs.logger).Log("msg", "block opened", "duration", time.Since(start), "id", meta.ULID.String())
 		}()
+		bs.indexHeaders.acquire(b)
+		defer bs.indexHeaders.release(b)
 		if err := b.Open(ctx); err != nil {
+			bs.indexHeaders.loadFailures.Inc()
 			level.Error(bs.logger).Log("msg", "open block", "err", err)
 		}
 	}
@@ -239,6 +247,7 @@ func (s *BucketStore) removeBlock(id ulid.ULID) (returnErr error) {
 	// // even if releasing its resources could fail below.
 	s.metrics.blockDrops.Inc()
 
+	s.indexHeaders.forget(b)
 	if err := b.Close(); err != nil {
 		return errors.Wrap(err, "close block")
 	}
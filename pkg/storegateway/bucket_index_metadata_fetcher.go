@@ -21,9 +21,9 @@ import (
 )
 
 const (
-	corruptedBucketIndex     = "corrupted-bucket-index"
-	noBucketIndex            = "no-bucket-index"
-	bucketIndexOlderThanHour = "bucket-index-older-than-hour"
+	corruptedBucketIndex = "corrupted-bucket-index"
+	noBucketIndex        = "no-bucket-index"
+	staleBucketIndex     = "stale-bucket-index"
 )
 
 // BucketIndexMetadataFetcher is a Thanos MetadataFetcher implementation leveraging on the Mimir bucket index.
@@ -35,23 +35,28 @@ type BucketIndexMetadataFetcher struct {
 	filters     []block.MetadataFilter
 	metrics     *block.FetcherMetrics
 	fallback    block.MetadataFetcher
+
+	// The bucket is listed if the index is older than maxStalePeriod.
+	maxStalePeriod time.Duration
 }
 
 func NewBucketIndexMetadataFetcher(
 	userID string,
 	bkt objstore.Bucket,
 	cfgProvider objstore.TenantConfigProvider,
+	maxStalePeriod time.Duration,
 	logger log.Logger,
 	reg prometheus.Registerer,
 	filters []block.MetadataFilter,
 ) *BucketIndexMetadataFetcher {
 	return &BucketIndexMetadataFetcher{
-		userID:      userID,
-		bkt:         bkt,
-		cfgProvider: cfgProvider,
-		logger:      logger,
-		filters:     filters,
-		metrics:     block.NewFetcherMetrics(reg, [][]string{{corruptedBucketIndex}, {noBucketIndex}, {minTimeExcludedMeta}}),
+		userID:         userID,
+		bkt:            bkt,
+		cfgProvider:    cfgProvider,
+		maxStalePeriod: maxStalePeriod,
+		logger:         logger,
+		filters:        filters,
+		metrics:        block.NewFetcherMetrics(reg, [][]string{{corruptedBucketIndex}, {noBucketIndex}, {staleBucketIndex}, {minTimeExcludedMeta}}),
 	}
 }
 
@@ -110,14 +115,15 @@ func (f *BucketIndexMetadataFetcher) Fetch(ctx context.Context) (metas map[ulid.
 		return nil, nil, errors.Wrapf(err, "read bucket index")
 	}
 
-	// check if index is older than 1 hour, fallback to metafetcher
-	if time.Unix(idx.UpdatedAt, 0).Before(start.Add(-1 * time.Hour)) {
+	// The index is updated by the compactor: if it is stale, the compactor
+	// is likely not running, and the index misses the recent blocks.
+	if f.maxStalePeriod > 0 && time.Unix(idx.UpdatedAt, 0).Before(start.Add(-f.maxStalePeriod)) {
 		defer func() {
-			f.metrics.Synced.WithLabelValues(bucketIndexOlderThanHour).Set(1)
+			f.metrics.Synced.WithLabelValues(staleBucketIndex).Set(1)
 			f.metrics.Submit()
 		}()
 
-		level.Warn(f.logger).Log("msg", "bucket index is older than 1 hour, falling back to fetching directly from bucket", "user", f.userID)
+		level.Warn(f.logger).Log("msg", "bucket index is stale, falling back to fetching directly from bucket", "user", f.userID, "updated_at", time.Unix(idx.UpdatedAt, 0), "max_stale_period", f.maxStalePeriod)
 		return f.fallbackFetch(ctx)
 	}
 
@@ -61,7 +61,7 @@ func TestBucketIndexMetadataFetcher_Fetch(t *testing.T) {
 		newMinTimeMetaFilter(1 * time.Hour),
 	}
 
-	fetcher := NewBucketIndexMetadataFetcher(userID, bkt, nil, logger, reg, filters)
+	fetcher := NewBucketIndexMetadataFetcher(userID, bkt, nil, time.Hour, logger, reg, filters)
 	metas, partials, err := fetcher.Fetch(ctx)
 	require.NoError(t, err)
 	assert.Equal(t, map[ulid.ULID]*block.Meta{
@@ -88,6 +88,7 @@ func TestBucketIndexMetadataFetcher_Fetch(t *testing.T) {
 		blocks_meta_synced{state="marked-for-no-compact"} 0
 		blocks_meta_synced{state="no-bucket-index"} 0
 		blocks_meta_synced{state="no-meta-json"} 0
+		blocks_meta_synced{state="stale-bucket-index"} 0
 		blocks_meta_synced{state="time-excluded"} 0
 		blocks_meta_synced{state="min-time-excluded"} 1
 
@@ -110,7 +111,7 @@ func TestBucketIndexMetadataFetcher_Fetch_NoBucketIndex(t *testing.T) {
 	logs := &concurrency.SyncBuffer{}
 	logger := log.NewLogfmtLogger(logs)
 
-	fetcher := NewBucketIndexMetadataFetcher(userID, bkt, nil, logger, reg, nil)
+	fetcher := NewBucketIndexMetadataFetcher(userID, bkt, nil, time.Hour, logger, reg, nil)
 	metas, partials, err := fetcher.Fetch(ctx)
 	require.NoError(t, err)
 	assert.Empty(t, metas)
@@ -134,6 +135,7 @@ func TestBucketIndexMetadataFetcher_Fetch_NoBucketIndex(t *testing.T) {
 		blocks_meta_synced{state="marked-for-no-compact"} 0
 		blocks_meta_synced{state="no-bucket-index"} 1
 		blocks_meta_synced{state="no-meta-json"} 0
+		blocks_meta_synced{state="stale-bucket-index"} 0
 		blocks_meta_synced{state="time-excluded"} 0
 		blocks_meta_synced{state="min-time-excluded"} 0
 
@@ -160,7 +162,7 @@ func TestBucketIndexMetadataFetcher_Fetch_CorruptedBucketIndex(t *testing.T) {
 	// Upload a corrupted bucket index.
 	require.NoError(t, bkt.Upload(ctx, path.Join(userID, "phlaredb/", bucketindex.IndexCompressedFilename), strings.NewReader("invalid}!")))
 
-	fetcher := NewBucketIndexMetadataFetcher(userID, bkt, nil, logger, reg, nil)
+	fetcher := NewBucketIndexMetadataFetcher(userID, bkt, nil, time.Hour, logger, reg, nil)
 	metas, partials, err := fetcher.Fetch(ctx)
 	require.NoError(t, err)
 	assert.Empty(t, metas)
@@ -184,6 +186,7 @@ func TestBucketIndexMetadataFetcher_Fetch_CorruptedBucketIndex(t *testing.T) {
 		blocks_meta_synced{state="marked-for-no-compact"} 0
 		blocks_meta_synced{state="no-bucket-index"} 0
 		blocks_meta_synced{state="no-meta-json"} 0
+		blocks_meta_synced{state="stale-bucket-index"} 0
 		blocks_meta_synced{state="time-excluded"} 0
 		blocks_meta_synced{state="min-time-excluded"} 0
 
@@ -25,12 +25,14 @@ import (
 var errBucketStoreNotFound = errors.New("bucket store not found")
 
 type BucketStoreConfig struct {
-	SyncDir                  string        `yaml:"sync_dir"`
-	SyncInterval             time.Duration `yaml:"sync_interval" category:"advanced"`
-	TenantSyncConcurrency    int           `yaml:"tenant_sync_concurrency" category:"advanced"`
-	IgnoreBlocksWithin       time.Duration `yaml:"ignore_blocks_within" category:"advanced"`
-	MetaSyncConcurrency      int           `yaml:"meta_sync_concurrency" category:"advanced"`
-	IgnoreDeletionMarksDelay time.Duration `yaml:"ignore_deletion_mark_delay" category:"advanced"`
+	SyncDir                  string            `yaml:"sync_dir"`
+	SyncInterval             time.Duration     `yaml:"sync_interval" category:"advanced"`
+	TenantSyncConcurrency    int               `yaml:"tenant_sync_concurrency" category:"advanced"`
+	IgnoreBlocksWithin       time.Duration     `yaml:"ignore_blocks_within" category:"advanced"`
+	MetaSyncConcurrency      int               `yaml:"meta_sync_concurrency" category:"advanced"`
+	IgnoreDeletionMarksDelay time.Duration     `yaml:"ignore_deletion_mark_delay" category:"advanced"`
+	BucketIndex              BucketIndexConfig `yaml:"bucket_index"`
+	IndexHeader              IndexHeaderConfig `yaml:"index_header"`
 }
 
 // RegisterFlags registers the BucketStore flags
@@ -38,8 +40,8 @@ func (cfg *BucketStoreConfig) RegisterFlags(f *flag.FlagSet, logger log.Logger)
 	// cfg.IndexCache.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.index-cache.")
 	// cfg.ChunksCache.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.chunks-cache.", logger)
 	// cfg.MetadataCache.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.metadata-cache.")
-	// cfg.BucketIndex.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.bucket-index.")
-	// cfg.IndexHeader.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.index-header.")
+	cfg.BucketIndex.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.bucket-index.")
+	cfg.IndexHeader.RegisterFlagsWithPrefix(f, "blocks-storage.bucket-store.index-header.")
 
 	f.StringVar(&cfg.SyncDir, "blocks-storage.bucket-store.sync-dir", "./data/pyroscope-sync/", "Directory to store synchronized pyroscope block headers. This directory is not required to be persisted between restarts, but it's highly recommended in order to improve the store-gateway startup time.")
 	f.DurationVar(&cfg.SyncInterval, "blocks-storage.bucket-store.sync-interval", 15*time.Minute, "How frequently to scan the bucket, or to refresh the bucket index (if enabled), in order to look for changes (new blocks shipped by ingesters and blocks deleted by retention or compaction).")
@@ -57,8 +59,6 @@ func (cfg *BucketStoreConfig) RegisterFlags(f *flag.FlagSet, logger log.Logger)
 	f.DurationVar(&cfg.IgnoreDeletionMarksDelay, "blocks-storage.bucket-store.ignore-deletion-marks-delay", 30*time.Minute, "Duration after which the blocks marked for deletion will be filtered out while fetching blocks. "+
 		"The idea of ignore-deletion-marks-delay is to ignore blocks that are marked for deletion with some delay. This ensures store can still serve blocks that are meant to be deleted but do not have a replacement yet.")
 	// f.IntVar(&cfg.PostingOffsetsInMemSampling, "blocks-storage.bucket-store.posting-offsets-in-mem-sampling", DefaultPostingOffsetInMemorySampling, "Controls what is the ratio of postings offsets that the store will hold in memory.")
-	// f.BoolVar(&cfg.IndexHeaderLazyLoadingEnabled, "blocks-storage.bucket-store.index-header-lazy-loading-enabled", true, "If enabled, store-gateway will lazy load an index-header only once required by a query.")
-	// f.DurationVar(&cfg.IndexHeaderLazyLoadingIdleTimeout, "blocks-storage.bucket-store.index-header-lazy-loading-idle-timeout", 60*time.Minute, "If index-header lazy loading is enabled and this setting is > 0, the store-gateway will offload unused index-headers after 'idle timeout' inactivity.")
 	// f.Uint64Var(&cfg.PartitionerMaxGapBytes, "blocks-storage.bucket-store.partitioner-max-gap-bytes", DefaultPartitionerMaxGapSize, "Max size - in bytes - of a gap for which the partitioner aggregates together two bucket GET object requests.")
 	// f.IntVar(&cfg.StreamingBatchSize, "blocks-storage.bucket-store.batch-series-size", 5000, "This option controls how many series to fetch per batch. The batch size must be greater than 0.")
 	// f.IntVar(&cfg.ChunkRangesPerSeries, "blocks-storage.bucket-store.fine-grained-chunks-caching-ranges-per-series", 1, "This option controls into how many ranges the chunks of each series from each block are split. This value is effectively the number of chunks cache items per series per block when -blocks-storage.bucket-store.chunks-cache.fine-grained-chunks-caching-enabled is enabled.")
@@ -97,7 +97,7 @@ type BucketIndexConfig struct {
 func (cfg *BucketIndexConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
 	f.DurationVar(&cfg.UpdateOnErrorInterval, prefix+"update-on-error-interval", time.Minute, "How frequently a bucket index, which previously failed to load, should be tried to load again. This option is used only by querier.")
 	f.DurationVar(&cfg.IdleTimeout, prefix+"idle-timeout", time.Hour, "How long a unused bucket index should be cached. Once this timeout expires, the unused bucket index is removed from the in-memory cache. This option is used only by querier.")
-	f.DurationVar(&cfg.MaxStalePeriod, prefix+"max-stale-period", time.Hour, "The maximum allowed age of a bucket index (last updated) before queries start failing because the bucket index is too old. The bucket index is periodically updated by the compactor, and this check is enforced in the querier (at query time).")
+	f.DurationVar(&cfg.MaxStalePeriod, prefix+"max-stale-period", time.Hour, "The maximum allowed age of a bucket index (last updated) before it is considered stale. The bucket index is periodically updated by the compactor: the store-gateway lists the bucket instead of relying on a stale bucket index. 0 to disable the check.")
 }
 
 type BucketStores struct {
@@ -111,6 +111,8 @@ type BucketStores struct {
 	// Keeps a bucket store for each tenant.
 	storesMu sync.RWMutex
 	stores   map[string]*BucketStore
+	// Tracks the index-headers loaded by all the bucket stores.
+	indexHeaders *indexHeaderPool
 
 	// Metrics.
 	syncTimes         prometheus.Histogram
@@ -134,6 +136,7 @@ func NewBucketStores(cfg BucketStoreConfig, shardingStrategy ShardingStrategy, s
 		shardingStrategy: shardingStrategy,
 		reg:              reg,
 		limits:           limits,
+		indexHeaders:     newIndexHeaderPool(cfg.IndexHeader, logger, reg),
 	}
 	// Register metrics.
 	bs.syncTimes = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
@@ -331,6 +334,7 @@ func (bs *BucketStores) getOrCreateStore(userID string) (*BucketStore, error) {
 		userID,
 		bs.storageBucket,
 		bs.limits,
+		bs.cfg.BucketIndex.MaxStalePeriod,
 		bs.logger,
 		fetcherReg,
 		filters,
@@ -347,6 +351,8 @@ func (bs *BucketStores) getOrCreateStore(userID string) (*BucketStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	s.indexHeaders = bs.indexHeaders
+	s.lazyLoading = bs.cfg.IndexHeader.LazyLoadingEnabled
 
 	bs.stores[userID] = s
 
@@ -422,6 +428,12 @@ func (bs *BucketStores) closeBucketStore(userID string) error {
 	return s.RemoveBlocksAndClose()
 }
 
+// OffloadIdleIndexHeaders offloads the index-headers not used for longer
+// than the lazy loading idle timeout.
+func (bs *BucketStores) OffloadIdleIndexHeaders() {
+	bs.indexHeaders.offloadIdle(time.Now())
+}
+
 // getBlocksLoadedMetric returns the number of blocks currently loaded across all bucket stores.
 func (u *BucketStores) getBlocksLoadedMetric() float64 {
 	count := 0
@@ -240,10 +240,20 @@ func (g *StoreGateway) running(ctx context.Context) error {
 	ringTicker := time.NewTicker(util.DurationWithJitter(g.gatewayCfg.ShardingRing.RingCheckPeriod, 0.2))
 	defer ringTicker.Stop()
 
+	// The idle index-headers are checked a few times per idle timeout.
+	var offloadC <-chan time.Time
+	if idleTimeout := g.gatewayCfg.BucketStoreConfig.IndexHeader.LazyLoadingIdleTimeout; idleTimeout > 0 {
+		offloadTicker := time.NewTicker(max(idleTimeout/10, time.Second))
+		defer offloadTicker.Stop()
+		offloadC = offloadTicker.C
+	}
+
 	for {
 		select {
 		case <-syncTicker.C:
 			g.syncStores(ctx, syncReasonPeriodic)
+		case <-offloadC:
+			g.stores.OffloadIdleIndexHeaders()
 		case <-ringTicker.C:
 			// We ignore the error because in case of error it will return an empty
 			// replication set which we use to compare with the previous state.
@@ -0,0 +1,194 @@
+package storegateway
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+)
+
+type IndexHeaderConfig struct {
+	LazyLoadingEnabled     bool          `yaml:"lazy_loading_enabled" category:"advanced"`
+	LazyLoadingIdleTimeout time.Duration `yaml:"lazy_loading_idle_timeout" category:"advanced"`
+	MaxMemoryBytes         uint64        `yaml:"max_memory_bytes" category:"advanced"`
+}
+
+func (cfg *IndexHeaderConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.BoolVar(&cfg.LazyLoadingEnabled, prefix+"lazy-loading-enabled", true, "If enabled, store-gateway will lazy load an index-header only once required by a query. Otherwise, the index-headers of the blocks of the last 24 hours are loaded when the blocks are synchronized.")
+	f.DurationVar(&cfg.LazyLoadingIdleTimeout, prefix+"lazy-loading-idle-timeout", time.Hour, "If index-header lazy loading is enabled and this setting is > 0, the store-gateway will offload unused index-headers after 'idle timeout' inactivity.")
+	f.Uint64Var(&cfg.MaxMemoryBytes, prefix+"max-memory-bytes", 0, "Maximum size in bytes of the index-headers loaded in memory, shared across all tenants. When a query requires loading an index-header exceeding the budget, the least recently used unused index-headers are offloaded. 0 to disable the limit.")
+}
+
+const (
+	offloadReasonIdle   = "idle"
+	offloadReasonMemory = "memory"
+)
+
+// indexHeaderPool tracks the blocks whose index-headers are loaded in
+// memory. The pool is shared by the bucket stores of all the tenants so
+// that the memory budget applies to the whole store-gateway.
+//
+// A block is referenced while it is queried: only the blocks not
+// referenced are offloaded, either when the memory budget is exceeded
+// or when they have been idle for longer than the idle timeout.
+type indexHeaderPool struct {
+	cfg    IndexHeaderConfig
+	logger log.Logger
+
+	mu     sync.Mutex
+	loaded map[*Block]*indexHeader
+	size   uint64
+
+	loads        prometheus.Counter
+	loadFailures prometheus.Counter
+	offloads     *prometheus.CounterVec
+}
+
+type indexHeader struct {
+	size     uint64
+	refs     int
+	lastUsed time.Time
+}
+
+func newIndexHeaderPool(cfg IndexHeaderConfig, logger log.Logger, reg prometheus.Registerer) *indexHeaderPool {
+	p := &indexHeaderPool{
+		cfg:    cfg,
+		logger: logger,
+		loaded: make(map[*Block]*indexHeader),
+		loads: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_bucket_store_indexheader_loads_total",
+			Help: "Total number of index-header loads.",
+		}),
+		loadFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_bucket_store_indexheader_load_failures_total",
+			Help: "Total number of failed index-header loads.",
+		}),
+		offloads: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_bucket_store_indexheader_offloads_total",
+			Help: "Total number of index-header offloads, by reason.",
+		}, []string{"reason"}),
+	}
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "pyroscope_bucket_store_indexheader_loaded",
+		Help: "Number of index-headers currently loaded in memory.",
+	}, func() float64 {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return float64(len(p.loaded))
+	})
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "pyroscope_bucket_store_indexheader_loaded_bytes",
+		Help: "Size in bytes of the index-headers currently loaded in memory.",
+	}, func() float64 {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return float64(p.size)
+	})
+	return p
+}
+
+// acquire references the block for a query, and accounts for its
+// index-header, offloading other index-headers if needed to stay within
+// the memory budget. The caller is expected to open the block, and to
+// release it once the query is done.
+func (p *indexHeaderPool) acquire(b *Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.loaded[b]
+	if !ok {
+		h = &indexHeader{size: indexHeaderSize(b.meta)}
+		p.offloadForLocked(h.size)
+		p.loaded[b] = h
+		p.size += h.size
+		p.loads.Inc()
+	}
+	h.refs++
+	h.lastUsed = time.Now()
+}
+
+// release marks the block as not used by the query anymore.
+func (p *indexHeaderPool) release(b *Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if h, ok := p.loaded[b]; ok {
+		h.refs--
+		h.lastUsed = time.Now()
+	}
+}
+
+// forget removes the block from the pool, e.g. when the block is dropped
+// from the bucket store. The caller is responsible for closing the block.
+func (p *indexHeaderPool) forget(b *Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if h, ok := p.loaded[b]; ok {
+		p.size -= h.size
+		delete(p.loaded, b)
+	}
+}
+
+// offloadForLocked offloads the least recently used index-headers not
+// referenced until size bytes fit in the memory budget. The budget may be
+// exceeded if all the loaded index-headers are used by queries.
+func (p *indexHeaderPool) offloadForLocked(size uint64) {
+	if p.cfg.MaxMemoryBytes == 0 {
+		return
+	}
+	for p.size+size > p.cfg.MaxMemoryBytes {
+		var victim *Block
+		var oldest time.Time
+		for b, h := range p.loaded {
+			if h.refs == 0 && (victim == nil || h.lastUsed.Before(oldest)) {
+				victim, oldest = b, h.lastUsed
+			}
+		}
+		if victim == nil {
+			level.Warn(p.logger).Log("msg", "index-headers memory budget exceeded: all loaded index-headers are in use", "loaded_bytes", p.size, "max_memory_bytes", p.cfg.MaxMemoryBytes)
+			return
+		}
+		p.offloadLocked(victim, offloadReasonMemory)
+	}
+}
+
+// offloadIdle offloads the index-headers not used for longer than the
+// idle timeout.
+func (p *indexHeaderPool) offloadIdle(now time.Time) {
+	if !p.cfg.LazyLoadingEnabled || p.cfg.LazyLoadingIdleTimeout <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for b, h := range p.loaded {
+		if h.refs == 0 && now.Sub(h.lastUsed) > p.cfg.LazyLoadingIdleTimeout {
+			p.offloadLocked(b, offloadReasonIdle)
+		}
+	}
+}
+
+func (p *indexHeaderPool) offloadLocked(b *Block, reason string) {
+	p.size -= p.loaded[b].size
+	delete(p.loaded, b)
+	p.offloads.WithLabelValues(reason).Inc()
+	if err := b.Close(); err != nil {
+		level.Warn(p.logger).Log("msg", "failed to offload index-header", "block", b.meta.ULID, "reason", reason, "err", err)
+		return
+	}
+	level.Debug(p.logger).Log("msg", "offloaded index-header", "block", b.meta.ULID, "reason", reason)
+}
+
+// indexHeaderSize returns the size of the index of the block, which is
+// loaded in memory when the block is opened.
+func indexHeaderSize(meta *block.Meta) uint64 {
+	for _, f := range meta.Files {
+		if f.RelPath == block.IndexFilename {
+			return f.SizeBytes
+		}
+	}
+	return 0
+}
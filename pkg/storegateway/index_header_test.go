@@ -0,0 +1,97 @@
+package storegateway
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grafana/pyroscope/pkg/phlaredb"
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+)
+
+type closeCounter struct {
+	phlaredb.Querier
+	closed int
+}
+
+func (c *closeCounter) Close() error {
+	c.closed++
+	return nil
+}
+
+func newIndexHeaderTestBlock(size uint64) (*Block, *closeCounter) {
+	c := new(closeCounter)
+	return &Block{
+		BlockCloser: c,
+		meta: &block.Meta{
+			ULID:  ulid.MustNew(ulid.Now(), nil),
+			Files: []block.File{{RelPath: block.IndexFilename, SizeBytes: size}},
+		},
+		logger: log.NewNopLogger(),
+	}, c
+}
+
+func TestIndexHeaderPool_MemoryBudget(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := newIndexHeaderPool(IndexHeaderConfig{MaxMemoryBytes: 100}, log.NewNopLogger(), reg)
+
+	b1, c1 := newIndexHeaderTestBlock(60)
+	b2, c2 := newIndexHeaderTestBlock(30)
+	b3, c3 := newIndexHeaderTestBlock(50)
+
+	p.acquire(b1)
+	p.release(b1)
+	p.acquire(b2)
+	// b2 is in use: b1, the least recently used, is offloaded.
+	p.acquire(b3)
+	assert.Equal(t, 1, c1.closed)
+	assert.Equal(t, 0, c2.closed)
+	assert.Equal(t, uint64(80), p.size)
+
+	// All the index-headers are in use: the budget is exceeded.
+	b4, c4 := newIndexHeaderTestBlock(40)
+	p.acquire(b4)
+	assert.Equal(t, 0, c3.closed+c4.closed)
+	assert.Equal(t, uint64(120), p.size)
+
+	assert.Equal(t, float64(4), testutil.ToFloat64(p.loads))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.offloads.WithLabelValues(offloadReasonMemory)))
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP pyroscope_bucket_store_indexheader_loaded Number of index-headers currently loaded in memory.
+		# TYPE pyroscope_bucket_store_indexheader_loaded gauge
+		pyroscope_bucket_store_indexheader_loaded 3
+	`), "pyroscope_bucket_store_indexheader_loaded"))
+}
+
+func TestIndexHeaderPool_OffloadIdle(t *testing.T) {
+	p := newIndexHeaderPool(IndexHeaderConfig{
+		LazyLoadingEnabled:     true,
+		LazyLoadingIdleTimeout: time.Minute,
+	}, log.NewNopLogger(), nil)
+
+	idle, cIdle := newIndexHeaderTestBlock(10)
+	used, cUsed := newIndexHeaderTestBlock(10)
+	p.acquire(idle)
+	p.release(idle)
+	p.acquire(used)
+
+	p.offloadIdle(time.Now())
+	assert.Equal(t, 0, cIdle.closed)
+
+	p.offloadIdle(time.Now().Add(2 * time.Minute))
+	assert.Equal(t, 1, cIdle.closed)
+	assert.Equal(t, 0, cUsed.closed)
+	assert.Len(t, p.loaded, 1)
+
+	// A removed block is not offloaded by the pool.
+	p.forget(used)
+	p.release(used)
+	assert.Empty(t, p.loaded)
+	assert.Zero(t, p.size)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"slices"
+	"sync"
 
 	"connectrpc.com/connect"
 	"github.com/pkg/errors"
@@ -48,8 +49,10 @@ func (s *StoreGateway) MergeProfilesPprof(ctx context.Context, stream *connect.B
 func (s *StoreGateway) ProfileTypes(ctx context.Context, req *connect.Request[ingestv1.ProfileTypesRequest]) (*connect.Response[ingestv1.ProfileTypesResponse], error) {
 	var res *ingestv1.ProfileTypesResponse
 	_, err := s.forBucketStore(ctx, func(bs *BucketStore) error {
+		open, release := bs.blocksForReading()
+		defer release()
 		var err error
-		result, err := phlaredb.ProfileTypes(ctx, req, bs.openBlocksForReading)
+		result, err := phlaredb.ProfileTypes(ctx, req, open)
 		if err != nil {
 			return err
 		}
@@ -66,8 +69,10 @@ func (s *StoreGateway) ProfileTypes(ctx context.Context, req *connect.Request[in
 func (s *StoreGateway) LabelValues(ctx context.Context, req *connect.Request[typesv1.LabelValuesRequest]) (*connect.Response[typesv1.LabelValuesResponse], error) {
 	var res *typesv1.LabelValuesResponse
 	_, err := s.forBucketStore(ctx, func(bs *BucketStore) error {
+		open, release := bs.blocksForReading()
+		defer release()
 		var err error
-		res, err = phlaredb.LabelValues(ctx, req, bs.openBlocksForReading)
+		res, err = phlaredb.LabelValues(ctx, req, open)
 		if err != nil {
 			return err
 		}
@@ -83,8 +88,10 @@ func (s *StoreGateway) LabelValues(ctx context.Context, req *connect.Request[typ
 func (s *StoreGateway) LabelNames(ctx context.Context, req *connect.Request[typesv1.LabelNamesRequest]) (*connect.Response[typesv1.LabelNamesResponse], error) {
 	var res *typesv1.LabelNamesResponse
 	_, err := s.forBucketStore(ctx, func(bs *BucketStore) error {
+		open, release := bs.blocksForReading()
+		defer release()
 		var err error
-		res, err = phlaredb.LabelNames(ctx, req, bs.openBlocksForReading)
+		res, err = phlaredb.LabelNames(ctx, req, open)
 		if err != nil {
 			return err
 		}
@@ -100,8 +107,10 @@ func (s *StoreGateway) LabelNames(ctx context.Context, req *connect.Request[type
 func (s *StoreGateway) Series(ctx context.Context, req *connect.Request[ingestv1.SeriesRequest]) (*connect.Response[ingestv1.SeriesResponse], error) {
 	var res *ingestv1.SeriesResponse
 	_, err := s.forBucketStore(ctx, func(bs *BucketStore) error {
+		open, release := bs.blocksForReading()
+		defer release()
 		var err error
-		res, err = phlaredb.Series(ctx, req.Msg, bs.openBlocksForReading)
+		res, err = phlaredb.Series(ctx, req.Msg, open)
 		if err != nil {
 			return err
 		}
@@ -186,36 +195,64 @@ func (s *StoreGateway) forBucketStore(ctx context.Context, f func(*BucketStore)
 	return false, nil
 }
 
-func (s *BucketStore) openBlocksForReading(ctx context.Context, minT, maxT model.Time, hints *ingestv1.Hints) (phlaredb.Queriers, error) {
-	skipBlock := phlaredb.HintsToBlockSkipper(hints)
-	blks := s.blockSet.getFor(minT, maxT)
-	querier := make(phlaredb.Queriers, 0, len(blks))
-	for _, b := range blks {
-		if skipBlock(b.BlockID()) {
-			continue
+// blocksForReading returns the function opening the blocks of the query
+// time range, and the function releasing them once the query is done.
+func (s *BucketStore) blocksForReading() (phlaredb.BlockGetter, func()) {
+	var mu sync.Mutex
+	var acquired []*Block
+	open := func(ctx context.Context, minT, maxT model.Time, hints *ingestv1.Hints) (phlaredb.Queriers, error) {
+		skipBlock := phlaredb.HintsToBlockSkipper(hints)
+		blks := s.blockSet.getFor(minT, maxT)
+		querier := make(phlaredb.Queriers, 0, len(blks))
+		for _, b := range blks {
+			if skipBlock(b.BlockID()) {
+				continue
+			}
+			s.indexHeaders.acquire(b)
+			mu.Lock()
+			acquired = append(acquired, b)
+			mu.Unlock()
+			querier = append(querier, b)
+		}
+		if err := querier.Open(ctx); err != nil {
+			s.indexHeaders.loadFailures.Inc()
+			return nil, err
 		}
-		querier = append(querier, b)
+		return querier, nil
 	}
-	if err := querier.Open(ctx); err != nil {
-		return nil, err
+	release := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, b := range acquired {
+			s.indexHeaders.release(b)
+		}
+		acquired = nil
 	}
-	return querier, nil
+	return open, release
 }
 
 func (store *BucketStore) MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesStacktracesRequest, ingestv1.MergeProfilesStacktracesResponse]) error {
-	return phlaredb.MergeProfilesStacktraces(ctx, stream, store.openBlocksForReading)
+	open, release := store.blocksForReading()
+	defer release()
+	return phlaredb.MergeProfilesStacktraces(ctx, stream, open)
 }
 
 func (store *BucketStore) MergeProfilesLabels(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesLabelsRequest, ingestv1.MergeProfilesLabelsResponse]) error {
-	return phlaredb.MergeProfilesLabels(ctx, stream, store.openBlocksForReading)
+	open, release := store.blocksForReading()
+	defer release()
+	return phlaredb.MergeProfilesLabels(ctx, stream, open)
 }
 
 func (store *BucketStore) MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesPprofRequest, ingestv1.MergeProfilesPprofResponse]) error {
-	return phlaredb.MergeProfilesPprof(ctx, stream, store.openBlocksForReading)
+	open, release := store.blocksForReading()
+	defer release()
+	return phlaredb.MergeProfilesPprof(ctx, stream, open)
 }
 
 func (store *BucketStore) MergeSpanProfile(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeSpanProfileRequest, ingestv1.MergeSpanProfileResponse]) error {
-	return phlaredb.MergeSpanProfile(ctx, stream, store.openBlocksForReading)
+	open, release := store.blocksForReading()
+	defer release()
+	return phlaredb.MergeSpanProfile(ctx, stream, open)
 }
 
 func (s *BucketStore) BlockMetadata(ctx context.Context, req *ingestv1.BlockMetadataRequest) (*ingestv1.BlockMetadataResponse, error) {
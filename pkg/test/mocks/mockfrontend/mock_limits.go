@@ -21,6 +21,98 @@ func (_m *MockLimits) EXPECT() *MockLimits_Expecter {
 	return &MockLimits_Expecter{mock: &_m.Mock}
 }
 
+// HeavyQueryMinRange provides a mock function with given fields: _a0
+func (_m *MockLimits) HeavyQueryMinRange(_a0 string) time.Duration {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for HeavyQueryMinRange")
+	}
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func(string) time.Duration); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	return r0
+}
+
+// MockLimits_HeavyQueryMinRange_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'HeavyQueryMinRange'
+type MockLimits_HeavyQueryMinRange_Call struct {
+	*mock.Call
+}
+
+// HeavyQueryMinRange is a helper method to define mock.On call
+//   - _a0 string
+func (_e *MockLimits_Expecter) HeavyQueryMinRange(_a0 interface{}) *MockLimits_HeavyQueryMinRange_Call {
+	return &MockLimits_HeavyQueryMinRange_Call{Call: _e.mock.On("HeavyQueryMinRange", _a0)}
+}
+
+func (_c *MockLimits_HeavyQueryMinRange_Call) Run(run func(_a0 string)) *MockLimits_HeavyQueryMinRange_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *MockLimits_HeavyQueryMinRange_Call) Return(_a0 time.Duration) *MockLimits_HeavyQueryMinRange_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockLimits_HeavyQueryMinRange_Call) RunAndReturn(run func(string) time.Duration) *MockLimits_HeavyQueryMinRange_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// MaxConcurrentHeavyQueries provides a mock function with given fields: _a0
+func (_m *MockLimits) MaxConcurrentHeavyQueries(_a0 string) int {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for MaxConcurrentHeavyQueries")
+	}
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(string) int); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// MockLimits_MaxConcurrentHeavyQueries_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'MaxConcurrentHeavyQueries'
+type MockLimits_MaxConcurrentHeavyQueries_Call struct {
+	*mock.Call
+}
+
+// MaxConcurrentHeavyQueries is a helper method to define mock.On call
+//   - _a0 string
+func (_e *MockLimits_Expecter) MaxConcurrentHeavyQueries(_a0 interface{}) *MockLimits_MaxConcurrentHeavyQueries_Call {
+	return &MockLimits_MaxConcurrentHeavyQueries_Call{Call: _e.mock.On("MaxConcurrentHeavyQueries", _a0)}
+}
+
+func (_c *MockLimits_MaxConcurrentHeavyQueries_Call) Run(run func(_a0 string)) *MockLimits_MaxConcurrentHeavyQueries_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *MockLimits_MaxConcurrentHeavyQueries_Call) Return(_a0 int) *MockLimits_MaxConcurrentHeavyQueries_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockLimits_MaxConcurrentHeavyQueries_Call) RunAndReturn(run func(string) int) *MockLimits_MaxConcurrentHeavyQueries_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // MaxFlameGraphNodesDefault provides a mock function with given fields: _a0
 func (_m *MockLimits) MaxFlameGraphNodesDefault(_a0 string) int {
 	ret := _m.Called(_a0)
@@ -113,6 +205,98 @@ func (_c *MockLimits_MaxFlameGraphNodesMax_Call) RunAndReturn(run func(string) i
 	return _c
 }
 
+// MaxInflightResponseBytes provides a mock function with given fields: _a0
+func (_m *MockLimits) MaxInflightResponseBytes(_a0 string) int {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for MaxInflightResponseBytes")
+	}
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(string) int); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// MockLimits_MaxInflightResponseBytes_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'MaxInflightResponseBytes'
+type MockLimits_MaxInflightResponseBytes_Call struct {
+	*mock.Call
+}
+
+// MaxInflightResponseBytes is a helper method to define mock.On call
+//   - _a0 string
+func (_e *MockLimits_Expecter) MaxInflightResponseBytes(_a0 interface{}) *MockLimits_MaxInflightResponseBytes_Call {
+	return &MockLimits_MaxInflightResponseBytes_Call{Call: _e.mock.On("MaxInflightResponseBytes", _a0)}
+}
+
+func (_c *MockLimits_MaxInflightResponseBytes_Call) Run(run func(_a0 string)) *MockLimits_MaxInflightResponseBytes_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *MockLimits_MaxInflightResponseBytes_Call) Return(_a0 int) *MockLimits_MaxInflightResponseBytes_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockLimits_MaxInflightResponseBytes_Call) RunAndReturn(run func(string) int) *MockLimits_MaxInflightResponseBytes_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// MaxQueryBytes provides a mock function with given fields: _a0
+func (_m *MockLimits) MaxQueryBytes(_a0 string) int {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for MaxQueryBytes")
+	}
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(string) int); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// MockLimits_MaxQueryBytes_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'MaxQueryBytes'
+type MockLimits_MaxQueryBytes_Call struct {
+	*mock.Call
+}
+
+// MaxQueryBytes is a helper method to define mock.On call
+//   - _a0 string
+func (_e *MockLimits_Expecter) MaxQueryBytes(_a0 interface{}) *MockLimits_MaxQueryBytes_Call {
+	return &MockLimits_MaxQueryBytes_Call{Call: _e.mock.On("MaxQueryBytes", _a0)}
+}
+
+func (_c *MockLimits_MaxQueryBytes_Call) Run(run func(_a0 string)) *MockLimits_MaxQueryBytes_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *MockLimits_MaxQueryBytes_Call) Return(_a0 int) *MockLimits_MaxQueryBytes_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockLimits_MaxQueryBytes_Call) RunAndReturn(run func(string) int) *MockLimits_MaxQueryBytes_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // MaxQueryLength provides a mock function with given fields: tenantID
 func (_m *MockLimits) MaxQueryLength(tenantID string) time.Duration {
 	ret := _m.Called(tenantID)
@@ -251,6 +435,52 @@ func (_c *MockLimits_MaxQueryParallelism_Call) RunAndReturn(run func(string) int
 	return _c
 }
 
+// MaxQuerySeries provides a mock function with given fields: _a0
+func (_m *MockLimits) MaxQuerySeries(_a0 string) int {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for MaxQuerySeries")
+	}
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(string) int); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// MockLimits_MaxQuerySeries_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'MaxQuerySeries'
+type MockLimits_MaxQuerySeries_Call struct {
+	*mock.Call
+}
+
+// MaxQuerySeries is a helper method to define mock.On call
+//   - _a0 string
+func (_e *MockLimits_Expecter) MaxQuerySeries(_a0 interface{}) *MockLimits_MaxQuerySeries_Call {
+	return &MockLimits_MaxQuerySeries_Call{Call: _e.mock.On("MaxQuerySeries", _a0)}
+}
+
+func (_c *MockLimits_MaxQuerySeries_Call) Run(run func(_a0 string)) *MockLimits_MaxQuerySeries_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *MockLimits_MaxQuerySeries_Call) Return(_a0 int) *MockLimits_MaxQuerySeries_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockLimits_MaxQuerySeries_Call) RunAndReturn(run func(string) int) *MockLimits_MaxQuerySeries_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // QueryAnalysisEnabled provides a mock function with given fields: _a0
 func (_m *MockLimits) QueryAnalysisEnabled(_a0 string) bool {
 	ret := _m.Called(_a0)
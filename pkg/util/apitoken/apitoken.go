@@ -0,0 +1,277 @@
+// Package apitoken authenticates the API requests with scoped tokens.
+//
+// The tokens are listed in a file, with the SHA-256 hash of their value:
+//
+//	tokens:
+//	  - name: alloy-us-east
+//	    tenant: team-a
+//	    scopes: [ingest]
+//	    sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//
+// The tenant of the token replaces the tenant of the request, so that the
+// clients only need the token.
+package apitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/user"
+	"gopkg.in/yaml.v3"
+)
+
+type Scope string
+
+const (
+	// ScopeIngest allows to push profiles.
+	ScopeIngest Scope = "ingest"
+	// ScopeQuery allows to query profiles.
+	ScopeQuery Scope = "query"
+	// ScopeAdmin allows all requests, including the management of the
+	// tenant settings and of the tenant data.
+	ScopeAdmin Scope = "admin"
+)
+
+func (s Scope) valid() bool {
+	switch s {
+	case ScopeIngest, ScopeQuery, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+var (
+	ErrMissingToken = errors.New("missing API token")
+	ErrInvalidToken = errors.New("invalid API token")
+	ErrScope        = errors.New("API token scope does not allow the request")
+	ErrOperator     = errors.New("the request requires a cluster-level admin API token")
+	ErrNoOperator   = errors.New("the request requires a cluster-level admin API token, the API tokens are disabled")
+	ErrNoTenant     = errors.New("API token has no tenant")
+)
+
+type Token struct {
+	Name   string  `yaml:"name"`
+	Tenant string  `yaml:"tenant"`
+	Scopes []Scope `yaml:"scopes"`
+	SHA256 string  `yaml:"sha256"`
+}
+
+func (t Token) allows(scope Scope) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeAdmin)
+}
+
+// Operator reports whether the token is a cluster-level admin token: an
+// admin token not bound to a tenant, which acts on the tenant of the
+// request.
+func (t Token) Operator() bool {
+	return t.Tenant == "" && slices.Contains(t.Scopes, ScopeAdmin)
+}
+
+type tokensFile struct {
+	Tokens []Token `yaml:"tokens"`
+}
+
+type Config struct {
+	File         string        `yaml:"file"`
+	ReloadPeriod time.Duration `yaml:"reload_period" category:"advanced"`
+
+	// MultitenancyEnabled requires the tokens to have a tenant, unless they
+	// are cluster-level admin tokens.
+	MultitenancyEnabled bool `yaml:"-"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.File, "api.tokens.file", "", "File listing the API tokens, with their tenant and scopes: ingest, query or admin. If set, the requests to the public API require a token, sent as a bearer token or as the basic auth password.")
+	f.DurationVar(&cfg.ReloadPeriod, "api.tokens.reload-period", 10*time.Second, "How often the API tokens file is checked for changes.")
+}
+
+// Authenticator checks the tokens of the requests.
+type Authenticator struct {
+	cfg    Config
+	logger log.Logger
+	now    func() time.Time
+
+	mu      sync.RWMutex
+	tokens  map[string]Token
+	modTime time.Time
+	checked time.Time
+}
+
+// New returns the authenticator, or nil if the tokens file is not set.
+func New(cfg Config, logger log.Logger) (*Authenticator, error) {
+	if cfg.File == "" {
+		return nil, nil
+	}
+	a := &Authenticator{
+		cfg:    cfg,
+		logger: log.With(logger, "component", "api-tokens"),
+		now:    time.Now,
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Authenticator) load() error {
+	info, err := os.Stat(a.cfg.File)
+	if err != nil {
+		return fmt.Errorf("failed to read API tokens: %w", err)
+	}
+	a.checked = a.now()
+	if info.ModTime().Equal(a.modTime) {
+		return nil
+	}
+	b, err := os.ReadFile(a.cfg.File)
+	if err != nil {
+		return fmt.Errorf("failed to read API tokens: %w", err)
+	}
+	tokens, err := parseTokens(b)
+	if err != nil {
+		return err
+	}
+	a.tokens = tokens
+	a.modTime = info.ModTime()
+	return nil
+}
+
+func parseTokens(b []byte) (map[string]Token, error) {
+	var f tokensFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse API tokens: %w", err)
+	}
+	tokens := make(map[string]Token, len(f.Tokens))
+	for _, t := range f.Tokens {
+		h, err := hex.DecodeString(t.SHA256)
+		if err != nil || len(h) != sha256.Size {
+			return nil, fmt.Errorf("API token %q: invalid SHA-256 hash", t.Name)
+		}
+		if len(t.Scopes) == 0 {
+			return nil, fmt.Errorf("API token %q: no scopes", t.Name)
+		}
+		for _, s := range t.Scopes {
+			if !s.valid() {
+				return nil, fmt.Errorf("API token %q: invalid scope %q", t.Name, s)
+			}
+		}
+		tokens[hex.EncodeToString(h)] = t
+	}
+	return tokens, nil
+}
+
+// reload reloads the tokens if the file has changed since the last check.
+// The tokens are kept if the file can't be loaded.
+func (a *Authenticator) reload() {
+	a.mu.RLock()
+	stale := a.now().Sub(a.checked) >= a.cfg.ReloadPeriod
+	a.mu.RUnlock()
+	if !stale {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.now().Sub(a.checked) < a.cfg.ReloadPeriod {
+		return
+	}
+	if err := a.load(); err != nil {
+		level.Error(a.logger).Log("msg", "failed to reload API tokens", "err", err)
+	}
+}
+
+// Authenticate returns the token of the request, if it allows the scope.
+func (a *Authenticator) Authenticate(h http.Header, scope Scope) (Token, error) {
+	value := tokenFromHeader(h)
+	if value == "" {
+		return Token{}, ErrMissingToken
+	}
+	a.reload()
+	// The tokens are looked up by hash: the lookup time does
+	// not reveal anything about the token values.
+	a.mu.RLock()
+	t, ok := a.tokens[HashToken(value)]
+	a.mu.RUnlock()
+	if !ok {
+		return Token{}, ErrInvalidToken
+	}
+	if !t.allows(scope) {
+		return t, ErrScope
+	}
+	return t, nil
+}
+
+// authenticateRequest authenticates the request, and sets the tenant of
+// the token in the request headers. If multitenancy is enabled, only the
+// cluster-level admin tokens act on the tenant of the request: the other
+// tokens without a tenant would give access to all the tenants.
+func (a *Authenticator) authenticateRequest(h http.Header, scope Scope) error {
+	t, err := a.Authenticate(h, scope)
+	if err != nil {
+		return err
+	}
+	if t.Tenant != "" {
+		h.Set(user.OrgIDHeaderName, t.Tenant)
+		return nil
+	}
+	if a.cfg.MultitenancyEnabled && !t.Operator() {
+		return ErrNoTenant
+	}
+	return nil
+}
+
+// authenticateOperator authenticates the request with a cluster-level
+// admin token. The tenant of the request is kept.
+func (a *Authenticator) authenticateOperator(h http.Header) error {
+	t, err := a.Authenticate(h, ScopeAdmin)
+	if err != nil {
+		return err
+	}
+	if !t.Operator() {
+		return ErrOperator
+	}
+	return nil
+}
+
+func tokenFromHeader(h http.Header) string {
+	v := h.Get("Authorization")
+	if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if encoded, ok := strings.CutPrefix(v, "Basic "); ok {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return ""
+		}
+		_, password, _ := strings.Cut(string(b), ":")
+		return password
+	}
+	return ""
+}
+
+// HashToken returns the hex-encoded SHA-256 hash of the token,
+// as listed in the tokens file.
+func HashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// Generate returns a new random token.
+func Generate() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "pyro_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,230 @@
+package apitoken
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTokens(t *testing.T, path string, tokens ...string) {
+	t.Helper()
+	content := "tokens:\n"
+	for i := 0; i < len(tokens); i += 3 {
+		content += fmt.Sprintf("  - name: %s\n    tenant: %s\n    scopes: [%s]\n    sha256: %s\n",
+			tokens[i], tokens[i+1], tokens[i+2], HashToken(tokens[i]))
+	}
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func newTestAuthenticator(t *testing.T) (*Authenticator, string) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	writeTokens(t, path,
+		"ingester-token", "team-a", "ingest",
+		"reader-token", "team-a", "query",
+		"admin-token", "", "admin",
+	)
+	a, err := New(Config{File: path, ReloadPeriod: time.Minute}, log.NewNopLogger())
+	require.NoError(t, err)
+	return a, path
+}
+
+func Test_Authenticate(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+	header := func(k, v string) http.Header {
+		h := make(http.Header)
+		if k != "" {
+			h.Set(k, v)
+		}
+		return h
+	}
+
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		scope  Scope
+		err    error
+	}{
+		{"missing token", header("", ""), ScopeIngest, ErrMissingToken},
+		{"invalid token", header("Authorization", "Bearer foo"), ScopeIngest, ErrInvalidToken},
+		{"bearer token", header("Authorization", "Bearer ingester-token"), ScopeIngest, nil},
+		{"basic auth password", header("Authorization", "Basic "+basicAuth("team-a", "ingester-token")), ScopeIngest, nil},
+		{"scope not allowed", header("Authorization", "Bearer ingester-token"), ScopeQuery, ErrScope},
+		{"query token", header("Authorization", "Bearer reader-token"), ScopeQuery, nil},
+		{"admin token", header("Authorization", "Bearer admin-token"), ScopeIngest, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := a.Authenticate(tc.header, tc.scope)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func Test_Reload(t *testing.T) {
+	a, path := newTestAuthenticator(t)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	h := make(http.Header)
+	h.Set("Authorization", "Bearer new-token")
+
+	writeTokens(t, path, "new-token", "team-b", "query")
+	require.NoError(t, os.Chtimes(path, now, now.Add(time.Hour)))
+	_, err := a.Authenticate(h, ScopeQuery)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	now = now.Add(time.Minute)
+	tok, err := a.Authenticate(h, ScopeQuery)
+	require.NoError(t, err)
+	assert.Equal(t, "team-b", tok.Tenant)
+
+	// Invalid files are ignored.
+	require.NoError(t, os.WriteFile(path, []byte("tokens: [{name: x, sha256: foo, scopes: [ingest]}]"), 0o600))
+	require.NoError(t, os.Chtimes(path, now, now.Add(2*time.Hour)))
+	now = now.Add(time.Minute)
+	_, err = a.Authenticate(h, ScopeQuery)
+	require.NoError(t, err)
+}
+
+func Test_parseTokens(t *testing.T) {
+	_, err := parseTokens([]byte("tokens: [{name: x, sha256: " + HashToken("x") + ", scopes: [write]}]"))
+	assert.EqualError(t, err, `API token "x": invalid scope "write"`)
+	_, err = parseTokens([]byte("tokens: [{name: x, sha256: " + HashToken("x") + "}]"))
+	assert.EqualError(t, err, `API token "x": no scopes`)
+	_, err = parseTokens([]byte("tokens: [{name: x, sha256: abc, scopes: [ingest]}]"))
+	assert.EqualError(t, err, `API token "x": invalid SHA-256 hash`)
+}
+
+func Test_NewHTTP(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+	var tenantID string
+	handler := a.NewHTTP(ScopeIngest).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID = r.Header.Get(user.OrgIDHeaderName)
+	}))
+
+	for _, tc := range []struct {
+		token  string
+		status int
+		tenant string
+	}{
+		{"", http.StatusUnauthorized, ""},
+		{"reader-token", http.StatusForbidden, ""},
+		{"ingester-token", http.StatusOK, "team-a"},
+		{"admin-token", http.StatusOK, "team-c"},
+	} {
+		tenantID = ""
+		req := httptest.NewRequest(http.MethodPost, "/ingest", nil)
+		req.Header.Set(user.OrgIDHeaderName, "team-c")
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, tc.status, rec.Code, tc.token)
+		assert.Equal(t, tc.tenant, tenantID, tc.token)
+	}
+}
+
+func Test_NewHTTP_Multitenancy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	writeTokens(t, path,
+		"reader-token", "", "query",
+		"tenant-reader-token", "team-a", "query",
+		"admin-token", "", "admin",
+	)
+	a, err := New(Config{File: path, ReloadPeriod: time.Minute, MultitenancyEnabled: true}, log.NewNopLogger())
+	require.NoError(t, err)
+	var tenantID string
+	handler := a.NewHTTP(ScopeQuery).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID = r.Header.Get(user.OrgIDHeaderName)
+	}))
+
+	for _, tc := range []struct {
+		token  string
+		status int
+		tenant string
+	}{
+		{"reader-token", http.StatusForbidden, ""},
+		{"tenant-reader-token", http.StatusOK, "team-a"},
+		{"admin-token", http.StatusOK, "team-c"},
+	} {
+		tenantID = ""
+		req := httptest.NewRequest(http.MethodGet, "/pyroscope/render", nil)
+		req.Header.Set(user.OrgIDHeaderName, "team-c")
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, tc.status, rec.Code, tc.token)
+		assert.Equal(t, tc.tenant, tenantID, tc.token)
+	}
+}
+
+func Test_NewHTTPOperator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	writeTokens(t, path,
+		"reader-token", "", "query",
+		"tenant-admin-token", "team-a", "admin",
+		"admin-token", "", "admin",
+	)
+	a, err := New(Config{File: path, ReloadPeriod: time.Minute}, log.NewNopLogger())
+	require.NoError(t, err)
+	var tenantID string
+	handler := a.NewHTTPOperator().Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID = r.Header.Get(user.OrgIDHeaderName)
+	}))
+
+	for _, tc := range []struct {
+		token  string
+		status int
+		tenant string
+	}{
+		{"", http.StatusUnauthorized, ""},
+		{"reader-token", http.StatusForbidden, ""},
+		{"tenant-admin-token", http.StatusForbidden, ""},
+		{"admin-token", http.StatusOK, "team-c"},
+	} {
+		tenantID = ""
+		req := httptest.NewRequest(http.MethodPost, "/compactor/migrate_tenant", nil)
+		req.Header.Set(user.OrgIDHeaderName, "team-c")
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, tc.status, rec.Code, tc.token)
+		assert.Equal(t, tc.tenant, tenantID, tc.token)
+	}
+}
+
+func Test_NewHTTPNoOperator(t *testing.T) {
+	called := false
+	handler := NewHTTPNoOperator().Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/compactor/migrate_tenant", nil)
+	req.Header.Set(user.OrgIDHeaderName, "team-c")
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.False(t, called)
+}
+
+func Test_New_Disabled(t *testing.T) {
+	a, err := New(Config{}, log.NewNopLogger())
+	require.NoError(t, err)
+	assert.Nil(t, a)
+}
+
+func basicAuth(username, password string) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth(username, password)
+	return req.Header.Get("Authorization")[len("Basic "):]
+}
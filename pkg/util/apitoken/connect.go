@@ -0,0 +1,51 @@
+package apitoken
+
+import (
+	"context"
+	"errors"
+
+	"connectrpc.com/connect"
+)
+
+type tokenInterceptor struct {
+	authenticator *Authenticator
+	scope         Scope
+}
+
+// NewConnect returns the interceptor rejecting the requests without a token
+// allowing the scope. The interceptor is only meant for handlers.
+func (a *Authenticator) NewConnect(scope Scope) connect.Interceptor {
+	return &tokenInterceptor{authenticator: a, scope: scope}
+}
+
+func (i *tokenInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		if err := i.authenticator.authenticateRequest(req.Header(), i.scope); err != nil {
+			return nil, connectError(err)
+		}
+		return next(ctx, req)
+	}
+}
+
+func (i *tokenInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *tokenInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if err := i.authenticator.authenticateRequest(conn.RequestHeader(), i.scope); err != nil {
+			return connectError(err)
+		}
+		return next(ctx, conn)
+	}
+}
+
+func connectError(err error) error {
+	if errors.Is(err, ErrScope) || errors.Is(err, ErrNoTenant) {
+		return connect.NewError(connect.CodePermissionDenied, err)
+	}
+	return connect.NewError(connect.CodeUnauthenticated, err)
+}
@@ -0,0 +1,47 @@
+package apitoken
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/grafana/dskit/middleware"
+
+	httputil "github.com/grafana/pyroscope/pkg/util/http"
+)
+
+// NewHTTP returns the middleware rejecting the requests without a token
+// allowing the scope.
+func (a *Authenticator) NewHTTP(scope Scope) middleware.Interface {
+	return newHTTP(func(h http.Header) error {
+		return a.authenticateRequest(h, scope)
+	})
+}
+
+// NewHTTPOperator returns the middleware rejecting the requests without a
+// cluster-level admin token, for the requests that act on several tenants.
+func (a *Authenticator) NewHTTPOperator() middleware.Interface {
+	return newHTTP(a.authenticateOperator)
+}
+
+// NewHTTPNoOperator returns the middleware rejecting all the requests, for
+// the requests that act on several tenants when the tokens are disabled:
+// there is no cluster-level credential to authenticate them with.
+func NewHTTPNoOperator() middleware.Interface {
+	return newHTTP(func(http.Header) error { return ErrNoOperator })
+}
+
+func newHTTP(authenticate func(http.Header) error) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := authenticate(r.Header); err != nil {
+				status := http.StatusUnauthorized
+				if errors.Is(err, ErrScope) || errors.Is(err, ErrOperator) || errors.Is(err, ErrNoOperator) || errors.Is(err, ErrNoTenant) {
+					status = http.StatusForbidden
+				}
+				httputil.ErrorWithStatus(w, err, status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+}
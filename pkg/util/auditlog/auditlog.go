@@ -0,0 +1,188 @@
+// Package auditlog records who queried which profiles.
+package auditlog
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/middleware"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+// UserHeader is the header carrying the name of the user on whose behalf
+// the request is made. Grafana sends it when send_user_header is enabled.
+const UserHeader = "X-Grafana-User"
+
+type Config struct {
+	Enabled bool   `yaml:"enabled"`
+	File    string `yaml:"file"`
+}
+
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "api.query-audit-log.enabled", false, "Log an audit entry for each query: the tenant and user, the selector and time range, the response size and the duration.")
+	f.StringVar(&cfg.File, "api.query-audit-log.file", "", "File the audit entries are appended to, as JSON lines. By default, they are written to the server log.")
+}
+
+// Logger writes the audit entries of the queries.
+type Logger struct {
+	logger log.Logger
+	now    func() time.Time
+}
+
+// New returns the audit logger, or nil if the audit log is disabled.
+func New(cfg Config, logger log.Logger) (*Logger, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.File == "" {
+		return newLogger(log.With(logger, "component", "query-audit")), nil
+	}
+	f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open query audit log file: %w", err)
+	}
+	return newLogger(log.With(log.NewJSONLogger(log.NewSyncWriter(f)), "ts", log.DefaultTimestampUTC)), nil
+}
+
+func newLogger(logger log.Logger) *Logger {
+	return &Logger{logger: logger, now: time.Now}
+}
+
+// Unary request messages are inspected with the getters of the
+// generated code: the fields missing in the request are not logged.
+type (
+	profileTypeRequest interface{ GetProfileTypeID() string }
+	selectorRequest    interface{ GetLabelSelector() string }
+	matchersRequest    interface{ GetMatchers() []string }
+	timeRangeRequest   interface {
+		GetStart() int64
+		GetEnd() int64
+	}
+)
+
+// NewConnect returns the interceptor logging the unary requests.
+func NewConnect(l *Logger) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			begin := l.now()
+			resp, err := next(ctx, req)
+			l.log(ctx, req, resp, err, l.now().Sub(begin))
+			return resp, err
+		}
+	}
+}
+
+// NewHTTP returns the middleware logging the HTTP queries, for example,
+// /pyroscope/render. It must come after the auth middleware, which sets
+// the tenant of the request.
+func NewHTTP(l *Logger) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			begin := l.now()
+			rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rw, r)
+			l.logHTTP(r, rw, l.now().Sub(begin))
+		})
+	})
+}
+
+// httpQueryParams are the parameters of the HTTP queries logged, e.g.,
+// the selectors and the time ranges of /pyroscope/render-diff.
+var httpQueryParams = []string{
+	"query", "from", "until",
+	"leftQuery", "leftFrom", "leftUntil",
+	"rightQuery", "rightFrom", "rightUntil",
+}
+
+func (l *Logger) logHTTP(r *http.Request, rw *responseWriter, duration time.Duration) {
+	kv := entry(r.Context(), r.Header.Get(UserHeader), r.RemoteAddr, r.URL.Path)
+	params := r.URL.Query()
+	for _, name := range httpQueryParams {
+		if v := params.Get(name); v != "" {
+			kv = append(kv, name, v)
+		}
+	}
+	kv = append(kv,
+		"response_bytes", rw.size,
+		"duration", duration,
+		"status", rw.status,
+	)
+	_ = l.logger.Log(kv...)
+}
+
+// responseWriter records the status and the size of the response.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// entry returns the fields common to the audit entries.
+func entry(ctx context.Context, user, remoteAddr, route string) []interface{} {
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		tenantID = "anonymous"
+	}
+	return []interface{}{
+		"msg", "query",
+		"tenant", tenantID,
+		"user", user,
+		"remote_addr", remoteAddr,
+		"route", route,
+	}
+}
+
+func (l *Logger) log(ctx context.Context, req connect.AnyRequest, resp connect.AnyResponse, err error, duration time.Duration) {
+	kv := entry(ctx, req.Header().Get(UserHeader), req.Peer().Addr, req.Spec().Procedure)
+	msg := req.Any()
+	if r, ok := msg.(profileTypeRequest); ok {
+		kv = append(kv, "profile_type", r.GetProfileTypeID())
+	}
+	if r, ok := msg.(selectorRequest); ok {
+		kv = append(kv, "selector", r.GetLabelSelector())
+	}
+	if r, ok := msg.(matchersRequest); ok && len(r.GetMatchers()) > 0 {
+		kv = append(kv, "matchers", fmt.Sprint(r.GetMatchers()))
+	}
+	if r, ok := msg.(timeRangeRequest); ok {
+		kv = append(kv,
+			"start", time.UnixMilli(r.GetStart()).UTC().Format(time.RFC3339),
+			"end", time.UnixMilli(r.GetEnd()).UTC().Format(time.RFC3339),
+		)
+	}
+	var size int
+	if resp != nil {
+		if m, ok := resp.Any().(proto.Message); ok {
+			size = proto.Size(m)
+		}
+	}
+	status := "ok"
+	if err != nil {
+		status = connect.CodeOf(err).String()
+	}
+	kv = append(kv,
+		"response_bytes", size,
+		"duration", duration,
+		"status", status,
+	)
+	_ = l.logger.Log(kv...)
+}
@@ -0,0 +1,105 @@
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+func Test_NewConnect(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger(log.NewLogfmtLogger(&buf))
+	now := time.Unix(0, 0)
+	l.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	ctx := tenant.InjectTenantID(context.Background(), "tenant-a")
+	req := connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		LabelSelector: `{service_name="checkout"}`,
+		Start:         0,
+		End:           3600 * 1000,
+	})
+	req.Header().Set(UserHeader, "alice")
+	resp := &querierv1.SelectMergeStacktracesResponse{Tree: []byte("0123456789")}
+
+	_, err := NewConnect(l)(func(context.Context, connect.AnyRequest) (connect.AnyResponse, error) {
+		return connect.NewResponse(resp), nil
+	})(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`msg=query tenant=tenant-a user=alice remote_addr= route= `+
+			`profile_type=process_cpu:cpu:nanoseconds:cpu:nanoseconds selector="{service_name=\"checkout\"}" `+
+			`start=1970-01-01T00:00:00Z end=1970-01-01T01:00:00Z response_bytes=12 duration=1s status=ok`+"\n",
+		buf.String())
+
+	buf.Reset()
+	_, err = NewConnect(l)(func(context.Context, connect.AnyRequest) (connect.AnyResponse, error) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("invalid selector"))
+	})(ctx, connect.NewRequest(&typesv1.LabelNamesRequest{Matchers: []string{`{service_name="checkout"}`}}))
+	require.Error(t, err)
+	assert.Contains(t, buf.String(), `matchers="[{service_name=\"checkout\"}]"`)
+	assert.Contains(t, buf.String(), `response_bytes=0 duration=1s status=invalid_argument`)
+}
+
+func Test_NewHTTP(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger(log.NewLogfmtLogger(&buf))
+	now := time.Unix(0, 0)
+	l.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	handler := NewHTTP(l).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+
+	params := url.Values{
+		"leftQuery":  {`cpu{service_name="a"}`},
+		"leftFrom":   {"now-2h"},
+		"leftUntil":  {"now-1h"},
+		"rightQuery": {`cpu{service_name="b"}`},
+		"rightFrom":  {"now-1h"},
+		"rightUntil": {"now"},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/pyroscope/render-diff?"+params.Encode(), nil)
+	req = req.WithContext(tenant.InjectTenantID(req.Context(), "tenant-a"))
+	req.Header.Set(UserHeader, "alice")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t,
+		`msg=query tenant=tenant-a user=alice remote_addr=192.0.2.1:1234 route=/pyroscope/render-diff `+
+			`leftQuery="cpu{service_name=\"a\"}" leftFrom=now-2h leftUntil=now-1h `+
+			`rightQuery="cpu{service_name=\"b\"}" rightFrom=now-1h rightUntil=now `+
+			`response_bytes=10 duration=1s status=200`+"\n",
+		buf.String())
+
+	buf.Reset()
+	handler = NewHTTP(l).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid query", http.StatusBadRequest)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/pyroscope/export?query=cpu&from=now-1h&until=now", nil))
+	assert.Contains(t, buf.String(), `tenant=anonymous`)
+	assert.Contains(t, buf.String(), `route=/pyroscope/export query=cpu from=now-1h until=now response_bytes=14 duration=1s status=400`)
+}
+
+func Test_New_Disabled(t *testing.T) {
+	l, err := New(Config{}, log.NewNopLogger())
+	require.NoError(t, err)
+	assert.Nil(t, l)
+}
@@ -0,0 +1,85 @@
+package validation
+
+import (
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/cespare/xxhash/v2"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+// LabelSanitizer cleans up the labels of the ingested profiles, after the
+// relabeling rules are applied, and before the labels are validated.
+type LabelSanitizer struct {
+	// RequireServiceName rejects the profiles without a service name: by
+	// default, they are ingested with the unknown_service service name.
+	RequireServiceName bool
+	// MaxValueLength is the length the label values are truncated to,
+	// 0 if they are not truncated.
+	MaxValueLength int
+	// HashedLabels are the names of the labels with hashed values.
+	HashedLabels []string
+	// HashBuckets is the number of distinct hashed values of each label,
+	// 0 if not limited.
+	HashBuckets uint64
+}
+
+func (o *Overrides) IngestionLabelSanitizer(tenantID string) LabelSanitizer {
+	l := o.getOverridesForTenant(tenantID)
+	s := LabelSanitizer{
+		RequireServiceName: l.IngestionRequireServiceName,
+		HashedLabels:       l.IngestionHashedLabels,
+		HashBuckets:        uint64(max(l.IngestionHashedLabelsBuckets, 0)),
+	}
+	if l.IngestionTruncateLabelValues {
+		s.MaxValueLength = l.MaxLabelValueLength
+	}
+	return s
+}
+
+func (s LabelSanitizer) Sanitize(lb *phlaremodel.LabelsBuilder) {
+	for _, name := range s.HashedLabels {
+		if v := lb.Get(name); v != "" {
+			lb.Set(name, s.hash(v))
+		}
+	}
+	if s.MaxValueLength > 0 {
+		lb.Range(func(l *typesv1.LabelPair) {
+			if len(l.Value) > s.MaxValueLength {
+				lb.Set(l.Name, truncateString(l.Value, s.MaxValueLength))
+			}
+		})
+	}
+}
+
+// Validate checks the labels after they are sanitized.
+func (s LabelSanitizer) Validate(ls phlaremodel.Labels) error {
+	if s.RequireServiceName {
+		if v := ls.Get(phlaremodel.LabelNameServiceName); v == "" || v == phlaremodel.AttrServiceNameFallback {
+			return NewErrorf(MissingLabels, InvalidLabelsErrorMsg, phlaremodel.LabelPairsString(ls), "service name is not provided")
+		}
+	}
+	return nil
+}
+
+func (s LabelSanitizer) hash(v string) string {
+	h := xxhash.Sum64String(v)
+	if s.HashBuckets > 0 {
+		return strconv.FormatUint(h%s.HashBuckets, 10)
+	}
+	return strconv.FormatUint(h, 16)
+}
+
+// truncateString truncates the string to at most n bytes,
+// without splitting a multi-byte character.
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,89 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+func TestLabelSanitizer_Sanitize(t *testing.T) {
+	labels := phlaremodel.LabelsFromStrings(
+		"service_name", "my-service",
+		"user_id", "42",
+		"url", "/api/v1/users/42/orders/😀😀",
+	)
+
+	for _, tc := range []struct {
+		name      string
+		sanitizer LabelSanitizer
+		expected  map[string]string
+	}{
+		{
+			name:      "no sanitization",
+			sanitizer: LabelSanitizer{},
+			expected: map[string]string{
+				"service_name": "my-service",
+				"user_id":      "42",
+				"url":          "/api/v1/users/42/orders/😀😀",
+			},
+		},
+		{
+			name:      "truncated values",
+			sanitizer: LabelSanitizer{MaxValueLength: 27},
+			expected: map[string]string{
+				"service_name": "my-service",
+				"user_id":      "42",
+				"url":          "/api/v1/users/42/orders/",
+			},
+		},
+		{
+			name:      "hashed values in buckets",
+			sanitizer: LabelSanitizer{HashedLabels: []string{"user_id", "missing"}, HashBuckets: 1},
+			expected: map[string]string{
+				"service_name": "my-service",
+				"user_id":      "0",
+				"url":          "/api/v1/users/42/orders/😀😀",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lb := phlaremodel.NewLabelsBuilder(labels)
+			tc.sanitizer.Sanitize(lb)
+			actual := make(map[string]string)
+			for _, l := range lb.Labels() {
+				actual[l.Name] = l.Value
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestLabelSanitizer_HashedValues(t *testing.T) {
+	s := LabelSanitizer{HashedLabels: []string{"user_id"}}
+	hash := func(v string) string {
+		lb := phlaremodel.NewLabelsBuilder(phlaremodel.LabelsFromStrings("user_id", v))
+		s.Sanitize(lb)
+		return lb.Get("user_id")
+	}
+	assert.Equal(t, hash("42"), hash("42"))
+	assert.NotEqual(t, hash("42"), hash("43"))
+	assert.NotEqual(t, "42", hash("42"))
+}
+
+func TestLabelSanitizer_Validate(t *testing.T) {
+	s := LabelSanitizer{RequireServiceName: true}
+	require.NoError(t, s.Validate(phlaremodel.LabelsFromStrings("service_name", "my-service")))
+	require.Error(t, s.Validate(phlaremodel.LabelsFromStrings("service_name", phlaremodel.AttrServiceNameFallback)))
+	require.Error(t, s.Validate(phlaremodel.LabelsFromStrings("foo", "bar")))
+	require.NoError(t, LabelSanitizer{}.Validate(phlaremodel.LabelsFromStrings("foo", "bar")))
+}
+
+func TestTruncateString(t *testing.T) {
+	assert.Equal(t, "abc", truncateString("abc", 5))
+	assert.Equal(t, "ab", truncateString("abc", 2))
+	// "é" is 2 bytes long and must not be split.
+	assert.Equal(t, "a", truncateString("aé", 2))
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/grafana/dskit/flagext"
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/model"
 	"gopkg.in/yaml.v3"
@@ -33,33 +34,50 @@ const (
 // to support tenant-friendly duration format (e.g: "1h30m45s") in JSON value.
 type Limits struct {
 	// Distributor enforced limits.
-	IngestionRateMB        float64               `yaml:"ingestion_rate_mb" json:"ingestion_rate_mb"`
-	IngestionBurstSizeMB   float64               `yaml:"ingestion_burst_size_mb" json:"ingestion_burst_size_mb"`
-	IngestionLimit         *ingest_limits.Config `yaml:"ingestion_limit" json:"ingestion_limit" category:"advanced" doc:"hidden"`
-	DistributorSampling    *sampling.Config      `yaml:"distributor_sampling" json:"distributor_sampling" category:"advanced" doc:"hidden"`
-	MaxLabelNameLength     int                   `yaml:"max_label_name_length" json:"max_label_name_length"`
-	MaxLabelValueLength    int                   `yaml:"max_label_value_length" json:"max_label_value_length"`
-	MaxLabelNamesPerSeries int                   `yaml:"max_label_names_per_series" json:"max_label_names_per_series"`
-	MaxSessionsPerSeries   int                   `yaml:"max_sessions_per_series" json:"max_sessions_per_series"`
-	EnforceLabelsOrder     bool                  `yaml:"enforce_labels_order" json:"enforce_labels_order"`
+	IngestionRateMB            float64               `yaml:"ingestion_rate_mb" json:"ingestion_rate_mb"`
+	IngestionBurstSizeMB       float64               `yaml:"ingestion_burst_size_mb" json:"ingestion_burst_size_mb"`
+	IngestionRateProfiles      float64               `yaml:"ingestion_rate_profiles" json:"ingestion_rate_profiles"`
+	IngestionBurstSizeProfiles int                   `yaml:"ingestion_burst_size_profiles" json:"ingestion_burst_size_profiles"`
+	IngestionLimit             *ingest_limits.Config `yaml:"ingestion_limit" json:"ingestion_limit" category:"advanced" doc:"hidden"`
+	DistributorSampling        *sampling.Config      `yaml:"distributor_sampling" json:"distributor_sampling" category:"advanced" doc:"hidden"`
+	MaxLabelNameLength         int                   `yaml:"max_label_name_length" json:"max_label_name_length"`
+	MaxLabelValueLength        int                   `yaml:"max_label_value_length" json:"max_label_value_length"`
+	MaxLabelNamesPerSeries     int                   `yaml:"max_label_names_per_series" json:"max_label_names_per_series"`
+	MaxSessionsPerSeries       int                   `yaml:"max_sessions_per_series" json:"max_sessions_per_series"`
+	EnforceLabelsOrder         bool                  `yaml:"enforce_labels_order" json:"enforce_labels_order"`
 
 	MaxProfileSizeBytes              int `yaml:"max_profile_size_bytes" json:"max_profile_size_bytes"`
 	MaxProfileStacktraceSamples      int `yaml:"max_profile_stacktrace_samples" json:"max_profile_stacktrace_samples"`
 	MaxProfileStacktraceSampleLabels int `yaml:"max_profile_stacktrace_sample_labels" json:"max_profile_stacktrace_sample_labels"`
 	MaxProfileStacktraceDepth        int `yaml:"max_profile_stacktrace_depth" json:"max_profile_stacktrace_depth"`
 	MaxProfileSymbolValueLength      int `yaml:"max_profile_symbol_value_length" json:"max_profile_symbol_value_length"`
+	MaxProfileStringTableSize        int `yaml:"max_profile_string_table_size" json:"max_profile_string_table_size"`
+	MaxProfileStringLength           int `yaml:"max_profile_string_length" json:"max_profile_string_length"`
+	MaxRequestSizeBytes              int `yaml:"max_request_size_bytes" json:"max_request_size_bytes"`
+	MaxRequestSamples                int `yaml:"max_request_samples" json:"max_request_samples"`
 
 	// Distributor per-app usage breakdown.
 	DistributorUsageGroups *UsageGroupConfig `yaml:"distributor_usage_groups" json:"distributor_usage_groups"`
 
 	// Distributor aggregation.
-	DistributorAggregationWindow model.Duration `yaml:"distributor_aggregation_window" json:"distributor_aggregation_window"`
-	DistributorAggregationPeriod model.Duration `yaml:"distributor_aggregation_period" json:"distributor_aggregation_period"`
+	DistributorAggregationWindow        model.Duration         `yaml:"distributor_aggregation_window" json:"distributor_aggregation_window"`
+	DistributorAggregationPeriod        model.Duration         `yaml:"distributor_aggregation_period" json:"distributor_aggregation_period"`
+	DistributorAggregationIgnoredLabels flagext.StringSliceCSV `yaml:"distributor_aggregation_ignored_labels" json:"distributor_aggregation_ignored_labels"`
+
+	// Resource attributes indexed as series labels.
+	DistributorIndexedResourceAttributes flagext.StringSliceCSV `yaml:"distributor_indexed_resource_attributes" json:"distributor_indexed_resource_attributes"`
 
 	// IngestionRelabelingRules allow to specify additional relabeling rules that get applied before a profile gets ingested. There are some default relabeling rules, which ensure consistency of profiling series. The position of the default rules can be contolled by IngestionRelabelingDefaultRulesPosition
 	IngestionRelabelingRules                RelabelRules         `yaml:"ingestion_relabeling_rules" json:"ingestion_relabeling_rules" category:"advanced"`
 	IngestionRelabelingDefaultRulesPosition RelabelRulesPosition `yaml:"ingestion_relabeling_default_rules_position" json:"ingestion_relabeling_default_rules_position" category:"advanced"`
 
+	// Label sanitization, applied after the relabeling rules.
+	IngestionRequireServiceName  bool                   `yaml:"ingestion_require_service_name" json:"ingestion_require_service_name" category:"advanced"`
+	IngestionTruncateLabelValues bool                   `yaml:"ingestion_truncate_label_values" json:"ingestion_truncate_label_values" category:"advanced"`
+	IngestionHashedLabels        flagext.StringSliceCSV `yaml:"ingestion_hashed_labels" json:"ingestion_hashed_labels" category:"advanced"`
+	IngestionHashedLabelsBuckets int                    `yaml:"ingestion_hashed_labels_buckets" json:"ingestion_hashed_labels_buckets" category:"advanced"`
+	IngestionDropTraceIDLabels   bool                   `yaml:"ingestion_drop_trace_id_labels" json:"ingestion_drop_trace_id_labels" category:"advanced"`
+
 	// The tenant shard size determines the how many ingesters a particular
 	// tenant will be sharded to. Needs to be specified on distributors for
 	// correct distribution and on ingesters so that the local ingestion limit
@@ -79,6 +97,12 @@ type Limits struct {
 	MaxQueryParallelism        int            `yaml:"max_query_parallelism" json:"max_query_parallelism"`
 	QueryAnalysisEnabled       bool           `yaml:"query_analysis_enabled" json:"query_analysis_enabled"`
 	QueryAnalysisSeriesEnabled bool           `yaml:"query_analysis_series_enabled" json:"query_analysis_series_enabled"`
+	MaxQuerySeries             int            `yaml:"max_query_series" json:"max_query_series"`
+	MaxQueryBytes              int            `yaml:"max_query_bytes" json:"max_query_bytes"`
+	MaxConcurrentHeavyQueries  int            `yaml:"max_concurrent_heavy_queries" json:"max_concurrent_heavy_queries"`
+	HeavyQueryMinRange         model.Duration `yaml:"heavy_query_min_range" json:"heavy_query_min_range"`
+	MaxInflightResponseBytes   int            `yaml:"max_inflight_response_bytes" json:"max_inflight_response_bytes"`
+	SeriesStaleAfter           model.Duration `yaml:"series_stale_after" json:"series_stale_after"`
 
 	// Flame graph enforced limits.
 	MaxFlameGraphNodesDefault int `yaml:"max_flamegraph_nodes_default" json:"max_flamegraph_nodes_default"`
@@ -109,6 +133,8 @@ type Limits struct {
 	RejectOlderThan model.Duration `yaml:"reject_older_than" json:"reject_older_than"`
 	RejectNewerThan model.Duration `yaml:"reject_newer_than" json:"reject_newer_than"`
 
+	MaxProfileDuration model.Duration `yaml:"max_profile_duration" json:"max_profile_duration"`
+
 	// Write path overrides used in distributor.
 	WritePathOverrides writepath.Config `yaml:",inline" json:",inline"`
 
@@ -126,6 +152,12 @@ type Limits struct {
 
 	// Symbolizer.
 	Symbolizer Symbolizer `yaml:"symbolizer" json:"symbolizer" category:"experimental" doc:"hidden"`
+
+	// SourceCodeRepositories map the services of the tenant to the repositories of their source code.
+	SourceCodeRepositories []SourceCodeRepository `yaml:"source_code_repositories" json:"source_code_repositories" category:"experimental" doc:"hidden"`
+
+	// SymbolNormalizationRules rewrite the function names at query time.
+	SymbolNormalizationRules []phlaremodel.SymbolNormalizationRule `yaml:"symbol_normalization_rules" json:"symbol_normalization_rules" category:"experimental" doc:"hidden"`
 }
 
 // LimitError are errors that do not comply with the limits specified.
@@ -139,6 +171,8 @@ func (e LimitError) Error() string {
 func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.Float64Var(&l.IngestionRateMB, "distributor.ingestion-rate-limit-mb", 4, "Per-tenant ingestion rate limit in sample size per second. Units in MB.")
 	f.Float64Var(&l.IngestionBurstSizeMB, "distributor.ingestion-burst-size-mb", 2, "Per-tenant allowed ingestion burst size (in sample size). Units in MB. The burst size refers to the per-distributor local rate limiter, and should be set at least to the maximum profile size expected in a single push request.")
+	f.Float64Var(&l.IngestionRateProfiles, "distributor.ingestion-rate-limit-profiles", 0, "Per-tenant ingestion rate limit in profiles per second. 0 to disable.")
+	f.IntVar(&l.IngestionBurstSizeProfiles, "distributor.ingestion-burst-size-profiles", 0, "Per-tenant allowed ingestion burst size in profiles. The burst size refers to the per-distributor local rate limiter, and should be set at least to the maximum number of profiles expected in a single push request. 0 to use the rate limit as the burst size.")
 
 	f.IntVar(&l.IngestionTenantShardSize, "distributor.ingestion-tenant-shard-size", 0, "The tenant's shard size used by shuffle-sharding. Must be set both on ingesters and distributors. 0 disables shuffle sharding.")
 
@@ -167,17 +201,33 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.BoolVar(&l.QueryAnalysisEnabled, "querier.query-analysis-enabled", true, "Whether query analysis is enabled in the query frontend. If disabled, the /AnalyzeQuery endpoint will return an empty response.")
 	f.BoolVar(&l.QueryAnalysisSeriesEnabled, "querier.query-analysis-series-enabled", false, "Whether the series portion of query analysis is enabled. If disabled, no series data (e.g., series count) will be calculated by the /AnalyzeQuery endpoint.")
 
+	f.IntVar(&l.MaxQuerySeries, "querier.max-query-series", 0, "Maximum number of series a query can select. The number is estimated by the query frontend before the query is executed, the series are counted even if -querier.query-analysis-series-enabled is false. 0 to disable.")
+	f.IntVar(&l.MaxQueryBytes, "querier.max-query-bytes", 0, "Maximum number of bytes of block data a query can touch. The size is estimated by the query frontend before the query is executed. 0 to disable.")
+	f.IntVar(&l.MaxConcurrentHeavyQueries, "querier.max-concurrent-heavy-queries", 0, "Maximum number of heavy queries a tenant can run concurrently in a query frontend. Queries exceeding the limit are rejected. A query is heavy if its time range is at least -querier.heavy-query-min-range. 0 to disable.")
+	_ = l.HeavyQueryMinRange.Set("6h")
+	f.Var(&l.HeavyQueryMinRange, "querier.heavy-query-min-range", "Minimum time range of a query to be considered heavy by the -querier.max-concurrent-heavy-queries limit.")
+	f.IntVar(&l.MaxInflightResponseBytes, "querier.max-inflight-response-bytes", 0, "Maximum number of bytes of the split query responses the queries of a tenant can hold at once in a query frontend, while the responses are merged. Queries exceeding the limit are rejected. 0 to disable.")
+
+	_ = l.SeriesStaleAfter.Set("0s")
+	f.Var(&l.SeriesStaleAfter, "querier.series-stale-after", "Period without profiles after which a series is stale. The stale series of the series requests are marked with the __stale__=\"true\" label, so that the services that are gone can be told apart. 0 to disable.")
+
 	f.IntVar(&l.MaxProfileSizeBytes, "validation.max-profile-size-bytes", 4*1024*1024, "Maximum size of a profile in bytes. This is based off the uncompressed size. 0 to disable.")
 	f.IntVar(&l.MaxProfileStacktraceSamples, "validation.max-profile-stacktrace-samples", 16000, "Maximum number of samples in a profile. 0 to disable.")
 	f.IntVar(&l.MaxProfileStacktraceSampleLabels, "validation.max-profile-stacktrace-sample-labels", 100, "Maximum number of labels in a profile sample. 0 to disable.")
 	f.IntVar(&l.MaxProfileStacktraceDepth, "validation.max-profile-stacktrace-depth", 1000, "Maximum depth of a profile stacktrace. Profiles are not rejected instead stacktraces are truncated. 0 to disable.")
 	f.IntVar(&l.MaxProfileSymbolValueLength, "validation.max-profile-symbol-value-length", 65535, "Maximum length of a profile symbol value (labels, function names and filenames, etc...). Profiles are not rejected instead symbol values are truncated. 0 to disable.")
+	f.IntVar(&l.MaxProfileStringTableSize, "validation.max-profile-string-table-size", 0, "Maximum number of strings in the string table of a profile, such as the function names and the sample label values. 0 to disable.")
+	f.IntVar(&l.MaxProfileStringLength, "validation.max-profile-string-length", 0, "Maximum length of a string in the string table of a profile. Unlike -validation.max-profile-symbol-value-length, profiles with longer strings are rejected. 0 to disable.")
+	f.IntVar(&l.MaxRequestSizeBytes, "validation.max-request-size-bytes", 0, "Maximum size of all the profiles of a push request in bytes. This is based off the uncompressed size. 0 to disable.")
+	f.IntVar(&l.MaxRequestSamples, "validation.max-request-samples", 0, "Maximum number of samples of all the profiles of a push request. 0 to disable.")
 
 	f.IntVar(&l.MaxFlameGraphNodesDefault, "querier.max-flamegraph-nodes-default", 8<<10, "Maximum number of flame graph nodes by default. 0 to disable.")
 	f.IntVar(&l.MaxFlameGraphNodesMax, "querier.max-flamegraph-nodes-max", 0, "Maximum number of flame graph nodes allowed. 0 to disable.")
 
 	f.Var(&l.DistributorAggregationWindow, "distributor.aggregation-window", "Duration of the distributor aggregation window. Requires aggregation period to be specified. 0 to disable.")
 	f.Var(&l.DistributorAggregationPeriod, "distributor.aggregation-period", "Duration of the distributor aggregation period. Requires aggregation window to be specified. 0 to disable.")
+	f.Var(&l.DistributorAggregationIgnoredLabels, "distributor.aggregation-ignored-labels", "Comma-separated list of labels removed from the series when the distributor aggregation is enabled, so that the profiles of the series only differing by these labels, e.g., the replicas of a function-as-a-service, are merged into the same series.")
+	f.Var(&l.DistributorIndexedResourceAttributes, "distributor.indexed-resource-attributes", "Comma-separated list of resource attributes also kept as series labels, so that they can be used in query selectors. The resource attributes are the labels with the __resource_ prefix, e.g., __resource_host_kernel_release, set by the agents: they are stored as profile annotations instead of series labels, and the indexed ones are added to the series labels without the prefix.")
 
 	f.Var(&l.CompactorBlocksRetentionPeriod, "compactor.blocks-retention-period", "Delete blocks containing samples older than the specified retention period. 0 to disable.")
 	f.IntVar(&l.CompactorSplitAndMergeShards, "compactor.split-and-merge-shards", 0, "The number of shards to use when splitting blocks. 0 to disable splitting.")
@@ -194,11 +244,19 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	_ = l.RejectOlderThan.Set("1h")
 	f.Var(&l.RejectOlderThan, "validation.reject-older-than", "This limits how far into the past profiling data can be ingested. This limit is enforced in the distributor. 0 to disable, defaults to 1h.")
 
+	f.Var(&l.MaxProfileDuration, "validation.max-profile-duration", "Maximum duration of a profile, the time range its samples were collected over. This limit is enforced in the distributor. 0 to disable.")
+
 	_ = l.IngestionRelabelingDefaultRulesPosition.Set("first")
 	f.Var(&l.IngestionRelabelingDefaultRulesPosition, "distributor.ingestion-relabeling-default-rules-position", "Position of the default ingestion relabeling rules in relation to relabel rules from overrides. Valid values are 'first', 'last' or 'disabled'.")
 	_ = l.IngestionRelabelingRules.Set("[]")
 	f.Var(&l.IngestionRelabelingRules, "distributor.ingestion-relabeling-rules", "List of ingestion relabel configurations. The relabeling rules work the same way, as those of [Prometheus](https://prometheus.io/docs/prometheus/latest/configuration/configuration/#relabel_config). All rules are applied in the order they are specified. Note: In most situations, it is more effective to use relabeling directly in Grafana Alloy.")
 
+	f.BoolVar(&l.IngestionRequireServiceName, "distributor.ingestion-require-service-name", false, "Reject the profiles without a service_name label after relabeling, instead of ingesting them with the unknown_service service name.")
+	f.BoolVar(&l.IngestionTruncateLabelValues, "distributor.ingestion-truncate-label-values", false, "Truncate the label values longer than the maximum label value length, instead of rejecting the profiles.")
+	f.Var(&l.IngestionHashedLabels, "distributor.ingestion-hashed-labels", "Comma-separated list of labels whose values are replaced with their hash, after relabeling, e.g., to keep user identifiers or long URLs out of the series labels.")
+	f.IntVar(&l.IngestionHashedLabelsBuckets, "distributor.ingestion-hashed-labels-buckets", 0, "Number of distinct values of each hashed label, to bound their cardinality. 0 to keep the full hash.")
+	f.BoolVar(&l.IngestionDropTraceIDLabels, "distributor.ingestion-drop-trace-id-labels", false, "Remove the trace_id sample labels of the profiles, so that each trace does not create new series. The span profiles are selected by the span IDs, which are stored separately.")
+
 	f.Var(&l.IngestionArtificialDelay, "distributor.ingestion-artificial-delay", "Target ingestion delay to apply to all tenants. If set to a non-zero value, the distributor will artificially delay ingestion time-frame by the specified duration by computing the difference between actual ingestion and the target. There is no delay on actual ingestion of samples, it is only the response back to the client.")
 
 }
@@ -231,6 +289,10 @@ func (l *Limits) Validate() error {
 		}
 	}
 
+	if l.IngestionHashedLabelsBuckets < 0 {
+		return errors.New("ingestion_hashed_labels_buckets must not be negative")
+	}
+
 	for idx, rule := range l.RecordingRules {
 		_, err := phlaremodel.NewRecordingRule(rule)
 		if err != nil {
@@ -238,6 +300,18 @@ func (l *Limits) Validate() error {
 		}
 	}
 
+	for idx, repo := range l.SourceCodeRepositories {
+		if err := repo.Validate(); err != nil {
+			return fmt.Errorf("source code repository at pos %d is not valid: %v", idx, err)
+		}
+	}
+
+	for idx, rule := range l.SymbolNormalizationRules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("symbol normalization rule at pos %d is not valid: %v", idx, err)
+		}
+	}
+
 	return nil
 }
 
@@ -294,6 +368,16 @@ func (o *Overrides) IngestionBurstSizeBytes(tenantID string) int {
 	return int(o.getOverridesForTenant(tenantID).IngestionBurstSizeMB * bytesInMB)
 }
 
+// IngestionRateProfiles returns the limit on ingestion rate (profiles per second).
+func (o *Overrides) IngestionRateProfiles(tenantID string) float64 {
+	return o.getOverridesForTenant(tenantID).IngestionRateProfiles
+}
+
+// IngestionBurstSizeProfiles returns the burst size for ingestion rate in profiles.
+func (o *Overrides) IngestionBurstSizeProfiles(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).IngestionBurstSizeProfiles
+}
+
 func (o *Overrides) IngestionLimit(tenantID string) *ingest_limits.Config {
 	return o.getOverridesForTenant(tenantID).IngestionLimit
 }
@@ -353,6 +437,26 @@ func (o *Overrides) MaxProfileSymbolValueLength(tenantID string) int {
 	return o.getOverridesForTenant(tenantID).MaxProfileSymbolValueLength
 }
 
+// MaxProfileStringTableSize returns the maximum number of strings in the string table of a profile.
+func (o *Overrides) MaxProfileStringTableSize(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxProfileStringTableSize
+}
+
+// MaxProfileStringLength returns the maximum length of a string in the string table of a profile.
+func (o *Overrides) MaxProfileStringLength(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxProfileStringLength
+}
+
+// MaxRequestSizeBytes returns the maximum uncompressed size of all the profiles of a push request.
+func (o *Overrides) MaxRequestSizeBytes(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxRequestSizeBytes
+}
+
+// MaxRequestSamples returns the maximum number of samples of all the profiles of a push request.
+func (o *Overrides) MaxRequestSamples(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxRequestSamples
+}
+
 // MaxSessionsPerSeries returns the maximum number of sessions per single series.
 func (o *Overrides) MaxSessionsPerSeries(tenantID string) int {
 	return o.getOverridesForTenant(tenantID).MaxSessionsPerSeries
@@ -362,6 +466,10 @@ func (o *Overrides) EnforceLabelsOrder(tenantID string) bool {
 	return o.getOverridesForTenant(tenantID).EnforceLabelsOrder
 }
 
+func (o *Overrides) IngestionDropTraceIDLabels(tenantID string) bool {
+	return o.getOverridesForTenant(tenantID).IngestionDropTraceIDLabels
+}
+
 func (o *Overrides) DistributorAggregationWindow(tenantID string) model.Duration {
 	return o.getOverridesForTenant(tenantID).DistributorAggregationWindow
 }
@@ -370,6 +478,15 @@ func (o *Overrides) DistributorAggregationPeriod(tenantID string) model.Duration
 	return o.getOverridesForTenant(tenantID).DistributorAggregationPeriod
 }
 
+func (o *Overrides) DistributorAggregationIgnoredLabels(tenantID string) []string {
+	return o.getOverridesForTenant(tenantID).DistributorAggregationIgnoredLabels
+}
+
+// DistributorIndexedResourceAttributes returns the resource attributes kept as series labels.
+func (o *Overrides) DistributorIndexedResourceAttributes(tenantID string) []string {
+	return o.getOverridesForTenant(tenantID).DistributorIndexedResourceAttributes
+}
+
 // MaxLocalSeriesPerTenant returns the maximum number of series a tenant is allowed to store
 // in a single ingester.
 func (o *Overrides) MaxLocalSeriesPerTenant(tenantID string) int {
@@ -398,6 +515,40 @@ func (o *Overrides) MaxQueryLookback(tenantID string) time.Duration {
 	return time.Duration(o.getOverridesForTenant(tenantID).MaxQueryLookback)
 }
 
+// MaxQuerySeries returns the max number of series a query can select.
+func (o *Overrides) MaxQuerySeries(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxQuerySeries
+}
+
+// MaxQueryBytes returns the max number of bytes of block data a query can touch.
+func (o *Overrides) MaxQueryBytes(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxQueryBytes
+}
+
+// MaxConcurrentHeavyQueries returns the max number of heavy queries
+// a tenant can run concurrently in a query frontend.
+func (o *Overrides) MaxConcurrentHeavyQueries(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxConcurrentHeavyQueries
+}
+
+// HeavyQueryMinRange returns the min time range of a heavy query.
+func (o *Overrides) HeavyQueryMinRange(tenantID string) time.Duration {
+	return time.Duration(o.getOverridesForTenant(tenantID).HeavyQueryMinRange)
+}
+
+// MaxInflightResponseBytes returns the max number of bytes of the split
+// query responses the queries of a tenant can hold at once in a query
+// frontend.
+func (o *Overrides) MaxInflightResponseBytes(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxInflightResponseBytes
+}
+
+// SeriesStaleAfter returns the period without profiles after which
+// a series is stale.
+func (o *Overrides) SeriesStaleAfter(tenantID string) time.Duration {
+	return time.Duration(o.getOverridesForTenant(tenantID).SeriesStaleAfter)
+}
+
 // MaxFlameGraphNodesDefault returns the max flame graph nodes used by default.
 func (o *Overrides) MaxFlameGraphNodesDefault(tenantID string) int {
 	return o.getOverridesForTenant(tenantID).MaxFlameGraphNodesDefault
@@ -487,6 +638,11 @@ func (o *Overrides) RejectNewerThan(tenantID string) time.Duration {
 	return time.Duration(o.getOverridesForTenant(tenantID).RejectNewerThan)
 }
 
+// MaxProfileDuration returns the maximum duration of a profile.
+func (o *Overrides) MaxProfileDuration(tenantID string) time.Duration {
+	return time.Duration(o.getOverridesForTenant(tenantID).MaxProfileDuration)
+}
+
 // RejectOlderThan will ensure that profiles that are older than the return value are rejected.
 func (o *Overrides) RejectOlderThan(tenantID string) time.Duration {
 	return time.Duration(o.getOverridesForTenant(tenantID).RejectOlderThan)
@@ -503,6 +659,12 @@ func (o *Overrides) QueryAnalysisSeriesEnabled(tenantID string) bool {
 	return o.getOverridesForTenant(tenantID).QueryAnalysisSeriesEnabled
 }
 
+// SymbolNormalizationRules returns the rules rewriting the function names
+// of the tenant profiles at query time.
+func (o *Overrides) SymbolNormalizationRules(tenantID string) []phlaremodel.SymbolNormalizationRule {
+	return o.getOverridesForTenant(tenantID).SymbolNormalizationRules
+}
+
 func (o *Overrides) WritePathOverrides(tenantID string) writepath.Config {
 	return o.getOverridesForTenant(tenantID).WritePathOverrides
 }
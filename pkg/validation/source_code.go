@@ -0,0 +1,60 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/grafana/dskit/flagext"
+	giturl "github.com/kubescape/go-git-url"
+	"github.com/kubescape/go-git-url/apis"
+)
+
+// SourceCodeRepository maps the profiles of a service to the repository
+// of its source code, used to show the source code of functions.
+type SourceCodeRepository struct {
+	// ServiceName is the service_name label value of the profiles. If empty,
+	// the repository is used for the services without a repository.
+	ServiceName string `yaml:"service_name" json:"service_name"`
+	// URL of the repository, for example, https://github.com/grafana/pyroscope.
+	// GitHub and GitLab repositories are supported.
+	URL string `yaml:"url" json:"url"`
+	// RootPath is the path of the service source code in the repository.
+	RootPath string `yaml:"root_path" json:"root_path"`
+	// Ref is the branch, tag, or commit the source code is fetched from,
+	// if the profiles are not labeled with the commit they were built from.
+	Ref string `yaml:"ref" json:"ref"`
+	// Token is the access token used to fetch the source code.
+	Token flagext.Secret `yaml:"token" json:"-"`
+}
+
+func (r *SourceCodeRepository) Validate() error {
+	u, err := giturl.NewGitURL(r.URL)
+	if err != nil {
+		return fmt.Errorf("invalid repository url %q: %w", r.URL, err)
+	}
+	switch u.GetProvider() {
+	case apis.ProviderGitHub.String(), apis.ProviderGitLab.String():
+	default:
+		return fmt.Errorf("unsupported repository provider %q, only GitHub and GitLab are supported", u.GetProvider())
+	}
+	return nil
+}
+
+// SourceCodeRepository returns the source code repository of the service.
+func (o *Overrides) SourceCodeRepository(tenantID, serviceName string) (SourceCodeRepository, bool) {
+	var fallback *SourceCodeRepository
+	repos := o.getOverridesForTenant(tenantID).SourceCodeRepositories
+	for i := range repos {
+		switch repos[i].ServiceName {
+		case serviceName:
+			return repos[i], true
+		case "":
+			if fallback == nil {
+				fallback = &repos[i]
+			}
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return SourceCodeRepository{}, false
+}
@@ -2,6 +2,8 @@ package validation
 
 import (
 	"time"
+
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 )
 
 type MockLimits struct {
@@ -11,6 +13,12 @@ type MockLimits struct {
 	MaxQueryLookbackValue           time.Duration
 	QueryAnalysisEnabledValue       bool
 	QueryAnalysisSeriesEnabledValue bool
+	MaxQuerySeriesValue             int
+	MaxQueryBytesValue              int
+	MaxConcurrentHeavyQueriesValue  int
+	HeavyQueryMinRangeValue         time.Duration
+	MaxInflightResponseBytesValue   int
+	SeriesStaleAfterValue           time.Duration
 	MaxLabelNameLengthValue         int
 	MaxLabelValueLengthValue        int
 	MaxLabelNamesPerSeriesValue     int
@@ -29,10 +37,17 @@ type MockLimits struct {
 	MaxProfileStacktraceDepthValue        int
 	MaxProfileStacktraceSampleLabelsValue int
 	MaxProfileSymbolValueLengthValue      int
+	MaxProfileStringTableSizeValue        int
+	MaxProfileStringLengthValue           int
+	MaxRequestSizeBytesValue              int
+	MaxRequestSamplesValue                int
+	MaxProfileDurationValue               time.Duration
 
 	MaxQueriersPerTenantValue int
 
 	SymbolizerEnabledValue bool
+
+	SymbolNormalizationRulesValue []phlaremodel.SymbolNormalizationRule
 }
 
 func (m MockLimits) QuerySplitDuration(string) time.Duration        { return m.QuerySplitDurationValue }
@@ -44,6 +59,22 @@ func (m MockLimits) QueryAnalysisSeriesEnabled(tenantID string) bool {
 	return m.QueryAnalysisSeriesEnabledValue
 }
 
+func (m MockLimits) SymbolNormalizationRules(string) []phlaremodel.SymbolNormalizationRule {
+	return m.SymbolNormalizationRulesValue
+}
+
+func (m MockLimits) MaxQuerySeries(string) int            { return m.MaxQuerySeriesValue }
+func (m MockLimits) MaxQueryBytes(string) int             { return m.MaxQueryBytesValue }
+func (m MockLimits) MaxConcurrentHeavyQueries(string) int { return m.MaxConcurrentHeavyQueriesValue }
+func (m MockLimits) HeavyQueryMinRange(string) time.Duration {
+	return m.HeavyQueryMinRangeValue
+}
+func (m MockLimits) MaxInflightResponseBytes(string) int { return m.MaxInflightResponseBytesValue }
+
+func (m MockLimits) SeriesStaleAfter(string) time.Duration {
+	return m.SeriesStaleAfterValue
+}
+
 func (m MockLimits) MaxFlameGraphNodesDefault(string) int { return m.MaxFlameGraphNodesDefaultValue }
 func (m MockLimits) MaxFlameGraphNodesMax(string) int     { return m.MaxFlameGraphNodesMaxValue }
 
@@ -74,6 +105,26 @@ func (m MockLimits) MaxProfileSymbolValueLength(userID string) int {
 	return m.MaxProfileSymbolValueLengthValue
 }
 
+func (m MockLimits) MaxProfileStringTableSize(userID string) int {
+	return m.MaxProfileStringTableSizeValue
+}
+
+func (m MockLimits) MaxProfileStringLength(userID string) int {
+	return m.MaxProfileStringLengthValue
+}
+
+func (m MockLimits) MaxRequestSizeBytes(userID string) int {
+	return m.MaxRequestSizeBytesValue
+}
+
+func (m MockLimits) MaxRequestSamples(userID string) int {
+	return m.MaxRequestSamplesValue
+}
+
+func (m MockLimits) MaxProfileDuration(userID string) time.Duration {
+	return m.MaxProfileDurationValue
+}
+
 func (m MockLimits) MaxQueriersPerTenant(_ string) int {
 	return m.MaxQueriersPerTenantValue
 }
@@ -4,15 +4,18 @@ import (
 	"encoding/hex"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
 
+	"connectrpc.com/connect"
 	"github.com/go-kit/log/level"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 
 	googlev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
@@ -52,6 +55,8 @@ const (
 	SamplesLimit          Reason = "samples_limit"
 	ProfileSizeLimit      Reason = "profile_size_limit"
 	SampleLabelsLimit     Reason = "sample_labels_limit"
+	StringTableLimit      Reason = "string_table_limit"
+	ProfileDurationLimit  Reason = "profile_duration_limit"
 	MalformedProfile      Reason = "malformed_profile"
 	FlameGraphLimit       Reason = "flamegraph_limit"
 	QueryMissingTimeRange Reason = "missing_time_range"
@@ -74,11 +79,20 @@ const (
 	ProfileTooBigErrorMsg               = "the profile with labels '%s' exceeds the size limit (max_profile_size_byte, actual: %d, limit: %d)"
 	ProfileTooManySamplesErrorMsg       = "the profile with labels '%s' exceeds the samples count limit (max_profile_stacktrace_samples, actual: %d, limit: %d)"
 	ProfileTooManySampleLabelsErrorMsg  = "the profile with labels '%s' exceeds the sample labels limit (max_profile_stacktrace_sample_labels, actual: %d, limit: %d)"
+	ProfileTooManyStringsErrorMsg       = "the profile with labels '%s' exceeds the string table size limit (max_profile_string_table_size, actual: %d, limit: %d), check that the sample labels do not have unique values, such as timestamps or request IDs"
+	ProfileStringTooLongErrorMsg        = "the profile with labels '%s' has a string too long in its string table (max_profile_string_length, actual: %d, limit: %d)"
+	RequestTooBigErrorMsg               = "the push request exceeds the size limit (max_request_size_bytes, actual: %d, limit: %d)"
+	RequestTooManySamplesErrorMsg       = "the push request exceeds the samples count limit (max_request_samples, actual: %d, limit: %d)"
+	ProfileTooLongErrorMsg              = "the profile with labels '%s' exceeds the duration limit (max_profile_duration, actual: %s, limit: %s)"
 	NotInIngestionWindowErrorMsg        = "profile with labels '%s' is outside of ingestion window (profile timestamp: %s, %s)"
 	MaxFlameGraphNodesErrorMsg          = "max flamegraph nodes limit %d is greater than allowed %d"
 	MaxFlameGraphNodesUnlimitedErrorMsg = "max flamegraph nodes limit must be set (max allowed %d)"
 	QueryMissingTimeRangeErrorMsg       = "missing time range in the query"
 	QueryStartAfterEndErrorMsg          = "query start time is after end time"
+	QueryTooManySeriesErrorMsg          = "the query selects too many series (max_query_series, actual: %d, limit: %d)"
+	QueryTooManyBytesErrorMsg           = "the query touches too much data (max_query_bytes, actual: %d, limit: %d)"
+	TooManyHeavyQueriesErrorMsg         = "too many heavy queries in progress (max_concurrent_heavy_queries, limit: %d), retry later or reduce the query time range"
+	TooManyInflightBytesErrorMsg        = "the responses of the queries in progress exceed the in-flight bytes limit (max_inflight_response_bytes, limit: %d), retry later or reduce the query time range or the max nodes"
 )
 
 var (
@@ -190,6 +204,9 @@ type ProfileValidationLimits interface {
 	MaxProfileStacktraceSampleLabels(tenantID string) int
 	MaxProfileStacktraceDepth(tenantID string) int
 	MaxProfileSymbolValueLength(tenantID string) int
+	MaxProfileStringTableSize(tenantID string) int
+	MaxProfileStringLength(tenantID string) int
+	MaxProfileDuration(tenantID string) time.Duration
 	RejectNewerThan(tenantID string) time.Duration
 	RejectOlderThan(tenantID string) time.Duration
 }
@@ -228,6 +245,23 @@ func (iw *ingestionWindow) valid(t model.Time, ls phlaremodel.Labels) error {
 	return NewErrorf(NotInIngestionWindow, NotInIngestionWindowErrorMsg, phlaremodel.LabelPairsString(ls), util.FormatTimeMillis(int64(t)), iw.errorDetail())
 }
 
+type RequestValidationLimits interface {
+	MaxRequestSizeBytes(tenantID string) int
+	MaxRequestSamples(tenantID string) int
+}
+
+// ValidateRequest checks the uncompressed size and the number of samples
+// of all the profiles of a push request.
+func ValidateRequest(limits RequestValidationLimits, tenantID string, uncompressedSize int64, samples int) error {
+	if limit := limits.MaxRequestSizeBytes(tenantID); limit != 0 && uncompressedSize > int64(limit) {
+		return NewErrorf(ProfileSizeLimit, RequestTooBigErrorMsg, uncompressedSize, limit)
+	}
+	if limit := limits.MaxRequestSamples(tenantID); limit != 0 && samples > limit {
+		return NewErrorf(SamplesLimit, RequestTooManySamplesErrorMsg, samples, limit)
+	}
+	return nil
+}
+
 func ValidateProfile(limits ProfileValidationLimits, tenantID string, prof *googlev1.Profile, uncompressedSize int, ls phlaremodel.Labels, now model.Time) error {
 	if prof == nil {
 		return nil
@@ -248,12 +282,31 @@ func ValidateProfile(limits ProfileValidationLimits, tenantID string, prof *goog
 	if limit, size := limits.MaxProfileStacktraceSamples(tenantID), len(prof.Sample); limit != 0 && size > limit {
 		return NewErrorf(SamplesLimit, ProfileTooManySamplesErrorMsg, phlaremodel.LabelPairsString(ls), size, limit)
 	}
+	if limit, size := limits.MaxProfileStringTableSize(tenantID), len(prof.StringTable); limit != 0 && size > limit {
+		return NewErrorf(StringTableLimit, ProfileTooManyStringsErrorMsg, phlaremodel.LabelPairsString(ls), size, limit)
+	}
+	if limit := limits.MaxProfileStringLength(tenantID); limit != 0 {
+		for i, str := range prof.StringTable {
+			if len(str) > limit {
+				return NewErrorf(StringTableLimit, ProfileStringTooLongErrorMsg, phlaremodel.LabelPairsString(ls), len(str), limit).
+					WithMetadata("string_index", strconv.Itoa(i))
+			}
+		}
+	}
+	if prof.DurationNanos < 0 {
+		return NewErrorf(MalformedProfile, "the profile duration is negative: %d ns", prof.DurationNanos).
+			WithMetadata("duration_nanos", strconv.FormatInt(prof.DurationNanos, 10))
+	}
+	if limit, d := limits.MaxProfileDuration(tenantID), time.Duration(prof.DurationNanos); limit != 0 && d > limit {
+		return NewErrorf(ProfileDurationLimit, ProfileTooLongErrorMsg, phlaremodel.LabelPairsString(ls), d, limit).
+			WithMetadata("duration_nanos", strconv.FormatInt(prof.DurationNanos, 10))
+	}
 	var (
 		depthLimit        = limits.MaxProfileStacktraceDepth(tenantID)
 		labelsLimit       = limits.MaxProfileStacktraceSampleLabels(tenantID)
 		symbolLengthLimit = limits.MaxProfileSymbolValueLength(tenantID)
 	)
-	for _, s := range prof.Sample {
+	for i, s := range prof.Sample {
 		if depthLimit != 0 && len(s.LocationId) > depthLimit {
 			// Truncate the deepest frames: s.LocationId[0] is the leaf.
 			s.LocationId = s.LocationId[len(s.LocationId)-depthLimit:]
@@ -261,6 +314,12 @@ func ValidateProfile(limits ProfileValidationLimits, tenantID string, prof *goog
 		if labelsLimit != 0 && len(s.Label) > labelsLimit {
 			return NewErrorf(SampleLabelsLimit, ProfileTooManySampleLabelsErrorMsg, phlaremodel.LabelPairsString(ls), len(s.Label), labelsLimit)
 		}
+		// The profiles without sample types are accepted: the values are
+		// attributed to the default sample type.
+		if len(prof.SampleType) > 0 && len(s.Value) != len(prof.SampleType) {
+			return NewErrorf(MalformedProfile, "sample %d has %d values, expected one per sample type (%d)", i, len(s.Value), len(prof.SampleType)).
+				WithMetadata("sample_index", strconv.Itoa(i))
+		}
 	}
 	if symbolLengthLimit > 0 {
 		for i := range prof.StringTable {
@@ -279,6 +338,9 @@ func ValidateProfile(limits ProfileValidationLimits, tenantID string, prof *goog
 			return NewErrorf(MalformedProfile, "function id is 0")
 		}
 	}
+	if err := validateReferences(prof); err != nil {
+		return err
+	}
 
 	if err := validateStringTableAccess(prof); err != nil {
 		return err
@@ -299,6 +361,41 @@ func ValidateProfile(limits ProfileValidationLimits, tenantID string, prof *goog
 	return nil
 }
 
+// validateReferences checks that the locations of the samples and the
+// functions of the locations exist. The profiles without locations or
+// functions are only checked for the string table references.
+func validateReferences(prof *googlev1.Profile) error {
+	if len(prof.Location) > 0 {
+		locations := make(map[uint64]struct{}, len(prof.Location))
+		for _, location := range prof.Location {
+			locations[location.Id] = struct{}{}
+		}
+		for i, sample := range prof.Sample {
+			for _, id := range sample.LocationId {
+				if _, ok := locations[id]; !ok {
+					return NewErrorf(MalformedProfile, "sample %d references the location %d, which does not exist", i, id).
+						WithMetadata("sample_index", strconv.Itoa(i), "location_id", strconv.FormatUint(id, 10))
+				}
+			}
+		}
+	}
+	if len(prof.Function) > 0 {
+		functions := make(map[uint64]struct{}, len(prof.Function))
+		for _, function := range prof.Function {
+			functions[function.Id] = struct{}{}
+		}
+		for _, location := range prof.Location {
+			for _, line := range location.Line {
+				if _, ok := functions[line.FunctionId]; !ok {
+					return NewErrorf(MalformedProfile, "location %d references the function %d, which does not exist", location.Id, line.FunctionId).
+						WithMetadata("location_id", strconv.FormatUint(location.Id, 10), "function_id", strconv.FormatUint(line.FunctionId, 10))
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func validateStringTableAccess(prof *googlev1.Profile) error {
 	if len(prof.StringTable) == 0 || prof.StringTable[0] != "" {
 		return NewErrorf(MalformedProfile, "string 0 should be empty string")
@@ -308,10 +405,11 @@ func validateStringTableAccess(prof *googlev1.Profile) error {
 			return NewErrorf(MalformedProfile, "sample type type string index out of range")
 		}
 	}
-	for _, sample := range prof.Sample {
+	for i, sample := range prof.Sample {
 		for _, lbl := range sample.Label {
 			if int(lbl.Str) >= len(prof.StringTable) || int(lbl.Key) >= len(prof.StringTable) {
-				return NewErrorf(MalformedProfile, "sample label string index out of range")
+				return NewErrorf(MalformedProfile, "sample label string index out of range").
+					WithMetadata("sample_index", strconv.Itoa(i))
 			}
 		}
 	}
@@ -344,12 +442,26 @@ func isValidServiceName(serviceNameValue string) bool {
 type Error struct {
 	Reason Reason
 	msg    string
+	// Metadata identifies the offending part of the request, for example,
+	// the index of the sample, for the clients to act on the error.
+	Metadata map[string]string
 }
 
 func (e *Error) Error() string {
 	return e.msg
 }
 
+// WithMetadata adds the key-value pairs to the metadata of the error.
+func (e *Error) WithMetadata(kv ...string) *Error {
+	if e.Metadata == nil {
+		e.Metadata = make(map[string]string, len(kv)/2)
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		e.Metadata[kv[i]] = kv[i+1]
+	}
+	return e
+}
+
 func NewErrorf(reason Reason, msg string, args ...interface{}) *Error {
 	return &Error{
 		Reason: reason,
@@ -366,6 +478,30 @@ func ReasonOf(err error) Reason {
 	return validationErr.Reason
 }
 
+// NewInvalidArgumentError returns the error of the rejected request. The
+// reason and the metadata of a validation error are attached as an
+// google.rpc.ErrorInfo detail, so that the clients can handle the rejection
+// without parsing the message.
+func NewInvalidArgumentError(err error) *connect.Error {
+	cerr := connect.NewError(connect.CodeInvalidArgument, err)
+	var validationErr *Error
+	if !errors.As(err, &validationErr) {
+		return cerr
+	}
+	detail, detailErr := connect.NewErrorDetail(&errdetails.ErrorInfo{
+		Reason:   string(validationErr.Reason),
+		Domain:   errorDomain,
+		Metadata: validationErr.Metadata,
+	})
+	if detailErr == nil {
+		cerr.AddDetail(detail)
+	}
+	return cerr
+}
+
+// errorDomain is the domain of the reasons of the validation errors.
+const errorDomain = "pyroscope.grafana.com"
+
 type RangeRequestLimits interface {
 	MaxQueryLength(tenantID string) time.Duration
 	MaxQueryLookback(tenantID string) time.Duration
@@ -442,6 +578,26 @@ func SanitizeTimeRange(limits RangeRequestLimits, tenant []string, start, end *i
 	return false, nil
 }
 
+type QueryLimits interface {
+	MaxQuerySeries(string) int
+	MaxQueryBytes(string) int
+	MaxConcurrentHeavyQueries(string) int
+	HeavyQueryMinRange(string) time.Duration
+	MaxInflightResponseBytes(string) int
+}
+
+// ValidateQueryImpact checks the series and bytes a query is estimated
+// to touch against the tenant limits.
+func ValidateQueryImpact(l QueryLimits, tenantIDs []string, series, bytes uint64) error {
+	if maxSeries := validation.SmallestPositiveNonZeroIntPerTenant(tenantIDs, l.MaxQuerySeries); maxSeries > 0 && series > uint64(maxSeries) {
+		return NewErrorf(QueryLimit, QueryTooManySeriesErrorMsg, series, maxSeries)
+	}
+	if maxBytes := validation.SmallestPositiveNonZeroIntPerTenant(tenantIDs, l.MaxQueryBytes); maxBytes > 0 && bytes > uint64(maxBytes) {
+		return NewErrorf(QueryLimit, QueryTooManyBytesErrorMsg, bytes, maxBytes)
+	}
+	return nil
+}
+
 type FlameGraphLimits interface {
 	MaxFlameGraphNodesDefault(string) int
 	MaxFlameGraphNodesMax(string) int
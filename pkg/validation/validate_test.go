@@ -1,12 +1,15 @@
 package validation
 
 import (
+	"errors"
 	"testing"
 	"time"
 
+	"connectrpc.com/connect"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 
 	googlev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
@@ -286,6 +289,19 @@ func TestValidateProfile(t *testing.T) {
 			NewErrorf(SamplesLimit, ProfileTooManySamplesErrorMsg, `{foo="bar"}`, 3, 2),
 			nil,
 		},
+		{
+			"string too long",
+			&googlev1.Profile{
+				StringTable: []string{"", "foo", "foobar"},
+			},
+			0,
+			MockLimits{
+				MaxProfileStringLengthValue: 3,
+			},
+			NewErrorf(StringTableLimit, ProfileStringTooLongErrorMsg, `{foo="bar"}`, 6, 3).
+				WithMetadata("string_index", "2"),
+			nil,
+		},
 		{
 			"too many labels",
 			&googlev1.Profile{
@@ -361,6 +377,83 @@ func TestValidateProfile(t *testing.T) {
 				RejectNewerThanValue: 10 * time.Minute,
 			},
 		},
+		{
+			name: "too many strings",
+			profile: &googlev1.Profile{
+				StringTable: []string{"", "a", "b"},
+			},
+			limits: MockLimits{
+				MaxProfileStringTableSizeValue: 2,
+			},
+			expectedErr: NewErrorf(StringTableLimit, ProfileTooManyStringsErrorMsg, `{foo="bar"}`, 3, 2),
+		},
+		{
+			name: "too long",
+			profile: &googlev1.Profile{
+				StringTable:   []string{""},
+				DurationNanos: int64(48 * time.Hour),
+			},
+			limits: MockLimits{
+				MaxProfileDurationValue: 24 * time.Hour,
+			},
+			expectedErr: NewErrorf(ProfileDurationLimit, ProfileTooLongErrorMsg, `{foo="bar"}`, 48*time.Hour, 24*time.Hour).
+				WithMetadata("duration_nanos", "172800000000000"),
+		},
+		{
+			name: "negative duration",
+			profile: &googlev1.Profile{
+				StringTable:   []string{""},
+				DurationNanos: -1,
+			},
+			limits: MockLimits{},
+			expectedErr: &Error{
+				Reason:   MalformedProfile,
+				msg:      "the profile duration is negative: -1 ns",
+				Metadata: map[string]string{"duration_nanos": "-1"},
+			},
+		},
+		{
+			name: "sample values do not match the sample types",
+			profile: &googlev1.Profile{
+				StringTable: []string{"", "cpu", "nanoseconds"},
+				SampleType:  []*googlev1.ValueType{{Type: 1, Unit: 2}},
+				Sample:      []*googlev1.Sample{{Value: []int64{1}}, {Value: []int64{1, 2}}},
+			},
+			limits: MockLimits{},
+			expectedErr: &Error{
+				Reason:   MalformedProfile,
+				msg:      "sample 1 has 2 values, expected one per sample type (1)",
+				Metadata: map[string]string{"sample_index": "1"},
+			},
+		},
+		{
+			name: "sample references a missing location",
+			profile: &googlev1.Profile{
+				StringTable: []string{""},
+				Location:    []*googlev1.Location{{Id: 1}},
+				Sample:      []*googlev1.Sample{{LocationId: []uint64{1}}, {LocationId: []uint64{1, 2}}},
+			},
+			limits: MockLimits{},
+			expectedErr: &Error{
+				Reason:   MalformedProfile,
+				msg:      "sample 1 references the location 2, which does not exist",
+				Metadata: map[string]string{"sample_index": "1", "location_id": "2"},
+			},
+		},
+		{
+			name: "location references a missing function",
+			profile: &googlev1.Profile{
+				StringTable: []string{""},
+				Function:    []*googlev1.Function{{Id: 1}},
+				Location:    []*googlev1.Location{{Id: 1, Line: []*googlev1.Line{{FunctionId: 3}}}},
+			},
+			limits: MockLimits{},
+			expectedErr: &Error{
+				Reason:   MalformedProfile,
+				msg:      "location 1 references the function 3, which does not exist",
+				Metadata: map[string]string{"location_id": "1", "function_id": "3"},
+			},
+		},
 		{
 			name: "without timestamp",
 			profile: &googlev1.Profile{
@@ -389,6 +482,73 @@ func TestValidateProfile(t *testing.T) {
 	}
 }
 
+func TestValidateRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		size        int64
+		samples     int
+		limits      RequestValidationLimits
+		expectedErr error
+	}{
+		{
+			name:    "no limits",
+			size:    1 << 20,
+			samples: 1 << 20,
+			limits:  MockLimits{},
+		},
+		{
+			name:    "within limits",
+			size:    10,
+			samples: 10,
+			limits: MockLimits{
+				MaxRequestSizeBytesValue: 10,
+				MaxRequestSamplesValue:   10,
+			},
+		},
+		{
+			name: "too big",
+			size: 11,
+			limits: MockLimits{
+				MaxRequestSizeBytesValue: 10,
+			},
+			expectedErr: NewErrorf(ProfileSizeLimit, RequestTooBigErrorMsg, 11, 10),
+		},
+		{
+			name:    "too many samples",
+			samples: 11,
+			limits: MockLimits{
+				MaxRequestSamplesValue: 10,
+			},
+			expectedErr: NewErrorf(SamplesLimit, RequestTooManySamplesErrorMsg, 11, 10),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateRequest(tc.limits, "foo", tc.size, tc.samples)
+			if tc.expectedErr != nil {
+				require.Equal(t, tc.expectedErr, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewInvalidArgumentError(t *testing.T) {
+	err := NewInvalidArgumentError(NewErrorf(MalformedProfile, "sample 1 is invalid").WithMetadata("sample_index", "1"))
+	assert.Equal(t, connect.CodeInvalidArgument, err.Code())
+	assert.Equal(t, "sample 1 is invalid", err.Message())
+	require.Len(t, err.Details(), 1)
+	detail, derr := err.Details()[0].Value()
+	require.NoError(t, derr)
+	info, ok := detail.(*errdetails.ErrorInfo)
+	require.True(t, ok)
+	assert.Equal(t, "malformed_profile", info.Reason)
+	assert.Equal(t, map[string]string{"sample_index": "1"}, info.Metadata)
+
+	err = NewInvalidArgumentError(errors.New("not a validation error"))
+	assert.Empty(t, err.Details())
+}
+
 func TestValidateFlamegraphMaxNodes(t *testing.T) {
 	type testCase struct {
 		name      string
@@ -449,6 +609,44 @@ func TestValidateFlamegraphMaxNodes(t *testing.T) {
 	}
 }
 
+func TestValidateQueryImpact(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		series, bytes uint64
+		limits        QueryLimits
+		err           error
+	}{
+		{
+			name:   "limits disabled",
+			series: 1000,
+			bytes:  1 << 30,
+			limits: MockLimits{},
+		},
+		{
+			name:   "within limits",
+			series: 10,
+			bytes:  100,
+			limits: MockLimits{MaxQuerySeriesValue: 10, MaxQueryBytesValue: 100},
+		},
+		{
+			name:   "too many series",
+			series: 11,
+			limits: MockLimits{MaxQuerySeriesValue: 10},
+			err:    &Error{Reason: "query_limit", msg: "the query selects too many series (max_query_series, actual: 11, limit: 10)"},
+		},
+		{
+			name:   "too many bytes",
+			bytes:  101,
+			limits: MockLimits{MaxQueryBytesValue: 100},
+			err:    &Error{Reason: "query_limit", msg: "the query touches too much data (max_query_bytes, actual: 101, limit: 100)"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.err, ValidateQueryImpact(tc.limits, []string{"tenant"}, tc.series, tc.bytes))
+		})
+	}
+}
+
 func Test_SanitizeLabelName(t *testing.T) {
 	for _, tc := range []struct {
 		input    string